@@ -3,20 +3,43 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"palm/src/config"
 	"palm/src/controllers"
+	"palm/src/entities"
+	"palm/src/events"
 	"palm/src/repositories/sqlite"
 	"palm/src/services"
 
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"gorm.io/gorm"
 )
 
 // App struct
 type App struct {
-	ctx             context.Context
-	db              *gorm.DB
-	emailController *controllers.EmailController
+	ctx                    context.Context
+	db                     *gorm.DB
+	events                 *events.Bus
+	eventBridge            *events.Bridge
+	stopWatchers           context.CancelFunc
+	accountController      *controllers.AccountController
+	emailController        *controllers.EmailController
+	contactController      *controllers.ContactController
+	archiveController      *controllers.ArchiveController
+	pgpController          *controllers.PGPController
+	smimeController        *controllers.SMIMEController
+	sendController         *controllers.SendController
+	calendarController     *controllers.CalendarController
+	ruleController         *controllers.RuleController
+	sieveController        *controllers.SieveController
+	spamController         *controllers.SpamController
+	subscriptionController *controllers.SubscriptionController
+	categoryController     *controllers.CategoryController
+	snoozeController       *controllers.SnoozeController
+	followUpController     *controllers.FollowUpController
+	assets                 *http.ServeMux
+	syncService            *services.SyncService
 }
 
 // NewApp creates a new App application struct
@@ -39,35 +62,204 @@ func (a *App) startup(ctx context.Context) {
 	}
 	a.db = db
 
+	// Initialize the event bus and forward its events to the frontend
+	a.events = events.NewBus()
+	a.eventBridge = events.NewBridge(a.events, func(name string, payload interface{}) {
+		runtime.EventsEmit(a.ctx, name, payload)
+	}, events.DefaultBridgeWindow)
+
 	// Initialize repositories
+	accountRepo := sqlite.NewAccountRepository(db)
 	messageRepo := sqlite.NewMessageRepository(db)
 	recipientRepo := sqlite.NewRecipientRepository(db)
 	attachmentRepo := sqlite.NewAttachmentRepository(db)
+	contactRepo := sqlite.NewContactRepository(db)
 
 	// Initialize services
 	emailService := services.NewEmailService(db, messageRepo, recipientRepo, attachmentRepo)
+	emailService.SetEventBus(a.events)
+	a.syncService = services.NewSyncService(accountRepo, emailService)
+	a.syncService.SetEventBus(a.events)
+	contactService := services.NewContactService(db, contactRepo)
+	contactService.Subscribe(a.events)
+	// Messages are analyzed after the contacts are recorded, which the
+	// analyzer compares senders to, and again once their sender is verified
+	phishingAnalyzer := services.NewPhishingAnalyzer(db)
+	phishingAnalyzer.Subscribe(a.events)
+	senderVerifier := services.NewSenderVerifier(db, nil)
+	senderVerifier.SetPhishingAnalyzer(phishingAnalyzer)
+	senderVerifier.Subscribe(a.events)
+	// Attachment contents live next to palm.sqlite
+	store := services.NewAttachmentStore("attachments")
+	archiveService := services.NewArchiveService(db, accountRepo, emailService, store)
+	archiveService.SetEventBus(a.events)
+	maildirSource := services.NewMaildirSource(db, emailService, store)
+	maildirSource.SetEventBus(a.events)
+	maildirSource.Subscribe(a.events)
+	a.syncService.RegisterSource(entities.AccountTypeMaildir, maildirSource)
+	pgpService := services.NewPGPService(db)
+	// S/MIME signatures are trusted through the system's roots and the
+	// certificates the user marks as trust anchors
+	smimeService := services.NewSMIMEService(db, nil)
+	// Local Maildir accounts send through the system's sendmail. Microsoft
+	// and Google accounts have no transport yet, so sending from them
+	// reports ErrNoTransport.
+	sendService := services.NewSendService(accountRepo, emailService, pgpService, smimeService, store)
+	sendService.RegisterTransport(entities.AccountTypeMaildir, services.NewSendmailTransport(services.DefaultSendmailPath))
+	// Invitations are read from the parts of every new message and
+	// answered through the sending pipeline
+	calendarService := services.NewCalendarService(db, emailService, sendService, store)
+	calendarService.Subscribe(a.events)
+	// Likely spam is moved aside before the user's rules and script see it
+	spamFilter := services.NewSpamFilter(db, emailService, store)
+	spamFilter.SetEventBus(a.events)
+	spamFilter.Subscribe(a.events)
+	// Messages are grouped by mailing list for the subscriptions view and
+	// unsubscribing
+	subscriptionService := services.NewSubscriptionService(db, sendService, nil)
+	subscriptionService.SetEventBus(a.events)
+	subscriptionService.Subscribe(a.events)
+	// Incoming messages are sorted into the inbox tabs
+	categorizer := services.NewCategorizer(db)
+	categorizer.SetEventBus(a.events)
+	categorizer.Subscribe(a.events)
+	// Replies to sent messages drop their follow-up reminders
+	followUpService := services.NewFollowUpService(db)
+	followUpService.SetEventBus(a.events)
+	followUpService.Subscribe(a.events)
+	// The user's rules run last, once every other subscriber has seen the
+	// message, since they may delete it
+	ruleService := services.NewRuleService(db, emailService, sendService, store)
+	ruleService.SetEventBus(a.events)
+	ruleService.Subscribe(a.events)
+	// The active Sieve script sees what the rules left of the message
+	sieveService := services.NewSieveService(db, emailService, sendService, store)
+	sieveService.SetEventBus(a.events)
+	sieveService.Subscribe(a.events)
+
+	// Keep Local Maildir accounts in step with their directories
+	watchCtx, stopWatchers := context.WithCancel(ctx)
+	a.stopWatchers = stopWatchers
+	go services.NewMaildirWatcher(accountRepo, a.syncService, services.DefaultMaildirPollInterval).Run(watchCtx)
+	// Verify senders away from sync and imports, as it looks up DNS
+	go senderVerifier.Run(watchCtx)
+	// Wake snoozed messages on time, including those due while closed
+	snoozeService := services.NewSnoozeService(db)
+	snoozeService.SetEventBus(a.events)
+	go snoozeService.Run(watchCtx)
+	// Send queued messages once sending can no longer be undone, and
+	// those sent later on time, including those due while closed
+	sendScheduler := services.NewSendScheduler(db, sendService, followUpService)
+	sendScheduler.SetEventBus(a.events)
+	go sendScheduler.Run(watchCtx)
+	// Resurface sent messages no one replied to in time
+	go followUpService.Run(watchCtx)
+
+	// Build the address book for databases created before contacts existed,
+	// then analyze the messages stored before the phishing analyzer
+	go func() {
+		if _, err := contactService.Rebuild(ctx); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to build contacts")
+		}
+		if _, err := phishingAnalyzer.AnalyzeMissing(ctx); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to analyze messages")
+		}
+		if _, err := subscriptionService.DetectMissing(ctx); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to group messages by mailing list")
+		}
+		if _, err := categorizer.CategorizeMissing(ctx); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to categorize messages")
+		}
+	}()
 
 	// Initialize controllers
-	a.emailController = controllers.NewEmailController(emailService)
+	a.accountController = controllers.NewAccountController(services.NewAccountService(accountRepo))
+	// Remote images are cached next to palm.sqlite too
+	imageProxy := services.NewImageProxy("remote-images", nil)
+	a.emailController = controllers.NewEmailController(emailService,
+		services.NewSourceService(db, emailService, store),
+		services.NewRemoteContentService(db, imageProxy),
+		pgpService,
+		smimeService,
+		calendarService)
+	a.contactController = controllers.NewContactController(contactService)
+	a.archiveController = controllers.NewArchiveController(archiveService)
+	a.pgpController = controllers.NewPGPController(pgpService)
+	a.smimeController = controllers.NewSMIMEController(smimeService)
+	a.sendController = controllers.NewSendController(sendService, sendScheduler, followUpService)
+	a.calendarController = controllers.NewCalendarController(calendarService)
+	a.ruleController = controllers.NewRuleController(ruleService)
+	a.sieveController = controllers.NewSieveController(sieveService)
+	a.spamController = controllers.NewSpamController(spamFilter)
+	a.subscriptionController = controllers.NewSubscriptionController(subscriptionService)
+	a.categoryController = controllers.NewCategoryController(categorizer)
+	a.snoozeController = controllers.NewSnoozeController(snoozeService)
+	a.followUpController = controllers.NewFollowUpController(followUpService)
+
+	// Local URLs the webview loads for rendered bodies
+	assets := http.NewServeMux()
+	assets.Handle(services.AttachmentURLPrefix, controllers.NewAttachmentHandler(services.NewAttachmentService(attachmentRepo), store))
+	assets.Handle(services.RemoteImageURLPrefix, controllers.NewRemoteImageHandler(imageProxy))
+	a.assets = assets
 
 	config.Logger.Info().Msg("Application started successfully")
 }
 
+// shutdown is called when the app is closing. Pending events are flushed
+// to the frontend before the database is closed.
+func (a *App) shutdown(ctx context.Context) {
+	if a.stopWatchers != nil {
+		a.stopWatchers()
+	}
+	if a.eventBridge != nil {
+		a.eventBridge.Close()
+	}
+	if a.db != nil {
+		if sqlDB, err := a.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	config.Logger.Info().Msg("Application shut down")
+}
+
+// serveAsset serves the requests the webview makes outside the bundled
+// frontend, such as inline and remote images of rendered email bodies
+func (a *App) serveAsset(w http.ResponseWriter, r *http.Request) {
+	if a.assets == nil {
+		http.NotFound(w, r)
+		return
+	}
+	a.assets.ServeHTTP(w, r)
+}
+
 // Greet returns a greeting for the given name
 func (a *App) Greet(name string) string {
 	config.Logger.Debug().Str("name", name).Msg("Greet function called")
 	return fmt.Sprintf("Hello %s, relax!", name)
 }
 
-// ListEmails returns a paginated list of emails for the given account
-func (a *App) ListEmails(accountID uint, page int, pageSize int) (*controllers.ListEmailsResponse, error) {
+// ListEmails returns a paginated list of emails for the given account,
+// filtered and sorted according to options
+func (a *App) ListEmails(accountID uint, page int, pageSize int, options controllers.ListEmailsOptions) (*controllers.ListEmailsResponse, error) {
 	config.Logger.Debug().
 		Uint("accountID", accountID).
 		Int("page", page).
 		Int("pageSize", pageSize).
 		Msg("ListEmails called from frontend")
 
-	return a.emailController.ListEmails(a.ctx, accountID, page, pageSize)
+	return a.emailController.ListEmails(a.ctx, accountID, page, pageSize, options)
+}
+
+// ListUnifiedEmails returns a paginated list of emails merged across the given
+// accounts, or across all accounts when accountIDs is empty
+func (a *App) ListUnifiedEmails(accountIDs []uint, page int, pageSize int) (*controllers.UnifiedInboxResponse, error) {
+	config.Logger.Debug().
+		Interface("accountIDs", accountIDs).
+		Int("page", page).
+		Int("pageSize", pageSize).
+		Msg("ListUnifiedEmails called from frontend")
+
+	return a.emailController.ListUnifiedEmails(a.ctx, accountIDs, page, pageSize)
 }
 
 // GetEmail returns the details of a specific email
@@ -78,3 +270,683 @@ func (a *App) GetEmail(messageID uint) (*controllers.EmailResponse, error) {
 
 	return a.emailController.GetEmail(a.ctx, messageID)
 }
+
+// LoadRemoteContent returns an email with its remote images loaded this
+// once, for the "Load images" button of a message
+func (a *App) LoadRemoteContent(messageID uint) (*controllers.EmailResponse, error) {
+	config.Logger.Debug().
+		Uint("messageID", messageID).
+		Msg("LoadRemoteContent called from frontend")
+
+	return a.emailController.LoadRemoteContent(a.ctx, messageID)
+}
+
+// AllowRemoteContent always loads remote images from a sender, given as an
+// address or a domain
+func (a *App) AllowRemoteContent(sender string) error {
+	config.Logger.Debug().
+		Str("sender", sender).
+		Msg("AllowRemoteContent called from frontend")
+
+	return a.emailController.AllowRemoteContent(a.ctx, sender)
+}
+
+// DisallowRemoteContent blocks remote images from a sender again
+func (a *App) DisallowRemoteContent(sender string) error {
+	config.Logger.Debug().
+		Str("sender", sender).
+		Msg("DisallowRemoteContent called from frontend")
+
+	return a.emailController.DisallowRemoteContent(a.ctx, sender)
+}
+
+// ListRemoteContentSenders returns the senders whose remote images load
+func (a *App) ListRemoteContentSenders() ([]string, error) {
+	config.Logger.Debug().Msg("ListRemoteContentSenders called from frontend")
+
+	return a.emailController.ListRemoteContentSenders(a.ctx)
+}
+
+// GetEmailHeaders returns every header field of an email, for the
+// "View source" panel
+func (a *App) GetEmailHeaders(messageID uint) (*controllers.EmailHeadersResponse, error) {
+	config.Logger.Debug().
+		Uint("messageID", messageID).
+		Msg("GetEmailHeaders called from frontend")
+
+	return a.emailController.GetEmailHeaders(a.ctx, messageID)
+}
+
+// GetEmailSource returns the raw RFC 5322 source of an email
+func (a *App) GetEmailSource(messageID uint) (*controllers.EmailSourceResponse, error) {
+	config.Logger.Debug().
+		Uint("messageID", messageID).
+		Msg("GetEmailSource called from frontend")
+
+	return a.emailController.GetEmailSource(a.ctx, messageID)
+}
+
+// SyncAccount fetches new messages for an account. Progress is reported
+// through the sync:* events while it runs.
+func (a *App) SyncAccount(accountID uint) (*services.SyncResult, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Msg("SyncAccount called from frontend")
+
+	return a.syncService.SyncAccount(a.ctx, accountID)
+}
+
+// AutocompleteRecipients suggests recipient addresses matching what the user
+// has typed, most frequently contacted first
+func (a *App) AutocompleteRecipients(prefix string) ([]controllers.RecipientSuggestionResponse, error) {
+	config.Logger.Debug().
+		Str("prefix", prefix).
+		Msg("AutocompleteRecipients called from frontend")
+
+	return a.contactController.AutocompleteRecipients(a.ctx, prefix)
+}
+
+// ListContacts returns the address book
+func (a *App) ListContacts() ([]controllers.ContactResponse, error) {
+	config.Logger.Debug().Msg("ListContacts called from frontend")
+
+	return a.contactController.ListContacts(a.ctx)
+}
+
+// UpdateContact renames a contact and adds addresses to it
+func (a *App) UpdateContact(contactID uint, displayName string, addEmails []string) (*controllers.ContactResponse, error) {
+	config.Logger.Debug().
+		Uint("contactID", contactID).
+		Msg("UpdateContact called from frontend")
+
+	return a.contactController.UpdateContact(a.ctx, contactID, displayName, addEmails)
+}
+
+// MergeContacts folds duplicate contacts into the target contact
+func (a *App) MergeContacts(targetID uint, duplicateIDs []uint) (*controllers.ContactResponse, error) {
+	config.Logger.Debug().
+		Uint("targetID", targetID).
+		Msg("MergeContacts called from frontend")
+
+	return a.contactController.MergeContacts(a.ctx, targetID, duplicateIDs)
+}
+
+// FindDuplicateContacts returns groups of contacts that look like the same person
+func (a *App) FindDuplicateContacts() ([][]controllers.ContactResponse, error) {
+	config.Logger.Debug().Msg("FindDuplicateContacts called from frontend")
+
+	return a.contactController.FindDuplicateContacts(a.ctx)
+}
+
+// DeleteContact removes a contact from the address book
+func (a *App) DeleteContact(contactID uint) error {
+	config.Logger.Debug().
+		Uint("contactID", contactID).
+		Msg("DeleteContact called from frontend")
+
+	return a.contactController.DeleteContact(a.ctx, contactID)
+}
+
+// ImportVCards adds the contacts of a .vcf file's contents to the address book
+func (a *App) ImportVCards(data string) (*controllers.VCardImportResponse, error) {
+	config.Logger.Debug().Msg("ImportVCards called from frontend")
+
+	return a.contactController.ImportVCards(a.ctx, data)
+}
+
+// ExportVCards returns the given contacts, or all of them, as a .vcf file
+func (a *App) ExportVCards(contactIDs []uint, version string) (string, error) {
+	config.Logger.Debug().
+		Interface("contactIDs", contactIDs).
+		Str("version", version).
+		Msg("ExportVCards called from frontend")
+
+	return a.contactController.ExportVCards(a.ctx, contactIDs, version)
+}
+
+// SendEmail sends a message, signed and encrypted with OpenPGP or S/MIME
+// where keys and certificates are available
+func (a *App) SendEmail(request controllers.SendEmailRequest) (*controllers.SendEmailResponse, error) {
+	config.Logger.Debug().Uint("accountID", request.AccountID).Msg("SendEmail called from frontend")
+
+	return a.sendController.SendEmail(a.ctx, request)
+}
+
+// ScheduleEmail queues a message to be sent at an RFC 3339 time, or now
+// if sendAt is empty, with undoSeconds to undo sending. outbox:updated
+// events report when it is sent, held by the provider or failed.
+func (a *App) ScheduleEmail(request controllers.SendEmailRequest, sendAt string, undoSeconds int) (*controllers.ScheduledEmailResponse, error) {
+	config.Logger.Debug().Uint("accountID", request.AccountID).Str("sendAt", sendAt).Msg("ScheduleEmail called from frontend")
+
+	return a.sendController.ScheduleEmail(a.ctx, request, sendAt, undoSeconds)
+}
+
+// CancelScheduledEmail undoes sending a queued message and returns it to
+// reopen the composer
+func (a *App) CancelScheduledEmail(id uint) (*controllers.SendEmailRequest, error) {
+	config.Logger.Debug().Uint("outboxID", id).Msg("CancelScheduledEmail called from frontend")
+
+	return a.sendController.CancelScheduledEmail(a.ctx, id)
+}
+
+// RetryScheduledEmail sends a message that failed to send again
+func (a *App) RetryScheduledEmail(id uint) (*controllers.ScheduledEmailResponse, error) {
+	config.Logger.Debug().Uint("outboxID", id).Msg("RetryScheduledEmail called from frontend")
+
+	return a.sendController.RetryScheduledEmail(a.ctx, id)
+}
+
+// ListScheduledEmails returns the messages of an account waiting to be
+// sent or that failed
+func (a *App) ListScheduledEmails(accountID uint) ([]controllers.ScheduledEmailResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ListScheduledEmails called from frontend")
+
+	return a.sendController.ListScheduledEmails(a.ctx, accountID)
+}
+
+// RespondToInvitation answers a meeting invitation with ACCEPTED,
+// TENTATIVE or DECLINED, sending the reply to its organizer
+func (a *App) RespondToInvitation(eventID uint, response string) (*controllers.SendEmailResponse, error) {
+	config.Logger.Debug().
+		Uint("eventID", eventID).
+		Str("response", response).
+		Msg("RespondToInvitation called from frontend")
+
+	return a.calendarController.RespondToInvitation(a.ctx, eventID, response)
+}
+
+// ListRules returns the rules filtering incoming mail, in the order they run
+func (a *App) ListRules() ([]controllers.RuleResponse, error) {
+	config.Logger.Debug().Msg("ListRules called from frontend")
+
+	return a.ruleController.ListRules(a.ctx)
+}
+
+// CreateRule adds a rule after the existing ones
+func (a *App) CreateRule(request controllers.RuleRequest) (*controllers.RuleResponse, error) {
+	config.Logger.Debug().Str("name", request.Name).Msg("CreateRule called from frontend")
+
+	return a.ruleController.CreateRule(a.ctx, request)
+}
+
+// UpdateRule replaces a rule, keeping its place in the order
+func (a *App) UpdateRule(ruleID uint, request controllers.RuleRequest) (*controllers.RuleResponse, error) {
+	config.Logger.Debug().Uint("ruleID", ruleID).Msg("UpdateRule called from frontend")
+
+	return a.ruleController.UpdateRule(a.ctx, ruleID, request)
+}
+
+// DeleteRule removes a rule
+func (a *App) DeleteRule(ruleID uint) error {
+	config.Logger.Debug().Uint("ruleID", ruleID).Msg("DeleteRule called from frontend")
+
+	return a.ruleController.DeleteRule(a.ctx, ruleID)
+}
+
+// ReorderRules sets the order rules run in; ruleIDs lists every rule once
+func (a *App) ReorderRules(ruleIDs []uint) error {
+	config.Logger.Debug().Int("ruleCount", len(ruleIDs)).Msg("ReorderRules called from frontend")
+
+	return a.ruleController.ReorderRules(a.ctx, ruleIDs)
+}
+
+// DryRunRule previews which stored emails a rule, saved or not, would
+// match, without taking its actions
+func (a *App) DryRunRule(request controllers.RuleRequest, limit int) (*controllers.RuleDryRunResponse, error) {
+	config.Logger.Debug().Str("name", request.Name).Msg("DryRunRule called from frontend")
+
+	return a.ruleController.DryRunRule(a.ctx, request, limit)
+}
+
+// ListSieveScripts returns the stored Sieve scripts
+func (a *App) ListSieveScripts() ([]controllers.SieveScriptResponse, error) {
+	config.Logger.Debug().Msg("ListSieveScripts called from frontend")
+
+	return a.sieveController.ListSieveScripts(a.ctx)
+}
+
+// ImportSieveScript stores a Sieve script, replacing the one of the same
+// name and account
+func (a *App) ImportSieveScript(request controllers.SieveScriptRequest) (*controllers.SieveScriptResponse, error) {
+	config.Logger.Debug().Str("name", request.Name).Msg("ImportSieveScript called from frontend")
+
+	return a.sieveController.ImportSieveScript(a.ctx, request)
+}
+
+// SetSieveScriptActive activates or deactivates a Sieve script
+func (a *App) SetSieveScriptActive(scriptID uint, active bool) error {
+	config.Logger.Debug().Uint("scriptID", scriptID).Bool("active", active).Msg("SetSieveScriptActive called from frontend")
+
+	return a.sieveController.SetSieveScriptActive(a.ctx, scriptID, active)
+}
+
+// DeleteSieveScript removes a Sieve script
+func (a *App) DeleteSieveScript(scriptID uint) error {
+	config.Logger.Debug().Uint("scriptID", scriptID).Msg("DeleteSieveScript called from frontend")
+
+	return a.sieveController.DeleteSieveScript(a.ctx, scriptID)
+}
+
+// TestSieveScript returns the actions a Sieve script, saved or not, would
+// take on a stored email, without taking them
+func (a *App) TestSieveScript(source string, emailID uint) ([]controllers.SieveActionResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("TestSieveScript called from frontend")
+
+	return a.sieveController.TestSieveScript(a.ctx, source, emailID)
+}
+
+// UploadSieveScript stores a Sieve script on a ManageSieve server
+func (a *App) UploadSieveScript(scriptID uint, server controllers.ManageSieveRequest) error {
+	config.Logger.Debug().Uint("scriptID", scriptID).Str("server", server.Address).Msg("UploadSieveScript called from frontend")
+
+	return a.sieveController.UploadSieveScript(a.ctx, scriptID, server)
+}
+
+// SetEmailCategory moves an email and the other emails of its sender to
+// the Primary, Updates, Promotions or Social tab, and keeps the sender's
+// future emails there. It returns how many emails were moved.
+func (a *App) SetEmailCategory(emailID uint, category string) (int, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Str("category", category).Msg("SetEmailCategory called from frontend")
+
+	return a.categoryController.SetEmailCategory(a.ctx, emailID, category)
+}
+
+// ListCategoryOverrides returns the tabs chosen for senders of an account
+func (a *App) ListCategoryOverrides(accountID uint) ([]controllers.CategoryOverrideResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ListCategoryOverrides called from frontend")
+
+	return a.categoryController.ListCategoryOverrides(a.ctx, accountID)
+}
+
+// DeleteCategoryOverride forgets the tab chosen for a sender. It returns
+// how many emails were moved.
+func (a *App) DeleteCategoryOverride(id uint) (int, error) {
+	config.Logger.Debug().Uint("id", id).Msg("DeleteCategoryOverride called from frontend")
+
+	return a.categoryController.DeleteCategoryOverride(a.ctx, id)
+}
+
+// SnoozeEmail hides an email until an RFC 3339 time or a preset from
+// GetSnoozePresets, after which it returns unread and a message:woken
+// event is emitted
+func (a *App) SnoozeEmail(emailID uint, until string) (*controllers.SnoozeResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Str("until", until).Msg("SnoozeEmail called from frontend")
+
+	return a.snoozeController.SnoozeEmail(a.ctx, emailID, until)
+}
+
+// UnsnoozeEmail returns a snoozed email to its folder now
+func (a *App) UnsnoozeEmail(emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("UnsnoozeEmail called from frontend")
+
+	return a.snoozeController.UnsnoozeEmail(a.ctx, emailID)
+}
+
+// ListSnoozedEmails returns the snoozed emails of an account
+func (a *App) ListSnoozedEmails(accountID uint) ([]controllers.SnoozeResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ListSnoozedEmails called from frontend")
+
+	return a.snoozeController.ListSnoozedEmails(a.ctx, accountID)
+}
+
+// GetSnoozePresets returns the snooze times offered now, such as
+// tomorrow morning, in the user's time zone
+func (a *App) GetSnoozePresets() []controllers.SnoozePresetResponse {
+	config.Logger.Debug().Msg("GetSnoozePresets called from frontend")
+
+	return a.snoozeController.GetSnoozePresets()
+}
+
+// ListSubscriptions returns the mailing lists and newsletters an account
+// receives, with how many of their emails arrived and were read
+func (a *App) ListSubscriptions(accountID uint) ([]controllers.SubscriptionResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ListSubscriptions called from frontend")
+
+	return a.subscriptionController.ListSubscriptions(a.ctx, accountID)
+}
+
+// Unsubscribe unsubscribes from the mailing list an email came from, with
+// a one-click request or by mail; lists offering only a web page return
+// it to be opened
+func (a *App) Unsubscribe(emailID uint) (*controllers.UnsubscribeResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("Unsubscribe called from frontend")
+
+	return a.subscriptionController.Unsubscribe(a.ctx, emailID)
+}
+
+// GetSpamStatus returns the training of the spam filter
+func (a *App) GetSpamStatus() (*controllers.SpamStatusResponse, error) {
+	config.Logger.Debug().Msg("GetSpamStatus called from frontend")
+
+	return a.spamController.GetSpamStatus(a.ctx)
+}
+
+// MarkSpam trains the spam filter with an email as spam and moves it aside
+func (a *App) MarkSpam(emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("MarkSpam called from frontend")
+
+	return a.spamController.MarkSpam(a.ctx, emailID)
+}
+
+// MarkNotSpam trains the spam filter with an email as wanted and moves it
+// back to the inbox
+func (a *App) MarkNotSpam(emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("MarkNotSpam called from frontend")
+
+	return a.spamController.MarkNotSpam(a.ctx, emailID)
+}
+
+// SetSpamThreshold sets the score from which messages are moved aside
+func (a *App) SetSpamThreshold(threshold float64) error {
+	config.Logger.Debug().Float64("threshold", threshold).Msg("SetSpamThreshold called from frontend")
+
+	return a.spamController.SetSpamThreshold(a.ctx, threshold)
+}
+
+// ImportPGPKeys adds the keys of an ASCII-armored key file's contents to
+// the OpenPGP keyring
+func (a *App) ImportPGPKeys(data string) ([]controllers.PGPKeyResponse, error) {
+	config.Logger.Debug().Msg("ImportPGPKeys called from frontend")
+
+	return a.pgpController.ImportPGPKeys(a.ctx, data)
+}
+
+// ExportPGPKey returns a key of the keyring ASCII-armored, with its secret
+// part if secret is set
+func (a *App) ExportPGPKey(fingerprint string, secret bool) (string, error) {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("ExportPGPKey called from frontend")
+
+	return a.pgpController.ExportPGPKey(a.ctx, fingerprint, secret)
+}
+
+// ListPGPKeys returns the keys of the OpenPGP keyring
+func (a *App) ListPGPKeys() ([]controllers.PGPKeyResponse, error) {
+	config.Logger.Debug().Msg("ListPGPKeys called from frontend")
+
+	return a.pgpController.ListPGPKeys(a.ctx)
+}
+
+// DeletePGPKey removes a key from the OpenPGP keyring
+func (a *App) DeletePGPKey(fingerprint string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("DeletePGPKey called from frontend")
+
+	return a.pgpController.DeletePGPKey(a.ctx, fingerprint)
+}
+
+// UnlockPGPKey unlocks a secret key with its passphrase until the app quits
+func (a *App) UnlockPGPKey(fingerprint string, passphrase string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("UnlockPGPKey called from frontend")
+
+	return a.pgpController.UnlockPGPKey(a.ctx, fingerprint, passphrase)
+}
+
+// ImportSMIMECertificates asks for certificate files, such as partners'
+// certificates or a company's certification authority, and adds them to
+// the S/MIME store. It returns nil if the dialog was cancelled.
+func (a *App) ImportSMIMECertificates() ([]controllers.SMIMECertificateResponse, error) {
+	config.Logger.Debug().Msg("ImportSMIMECertificates called from frontend")
+
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Import certificates",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Certificates (*.pem, *.crt, *.cer, *.der)", Pattern: "*.pem;*.crt;*.cer;*.der"},
+			{DisplayName: "All files", Pattern: "*"},
+		},
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.smimeController.ImportSMIMECertificateFile(a.ctx, path)
+}
+
+// ImportSMIMEIdentity asks for a PKCS#12 file holding one of the user's
+// certificates and its private key, and opens it with password. It
+// returns nil if the dialog was cancelled.
+func (a *App) ImportSMIMEIdentity(password string) (*controllers.SMIMECertificateResponse, error) {
+	config.Logger.Debug().Msg("ImportSMIMEIdentity called from frontend")
+
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Import S/MIME identity",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "PKCS#12 files (*.p12, *.pfx)", Pattern: "*.p12;*.pfx"},
+			{DisplayName: "All files", Pattern: "*"},
+		},
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.smimeController.ImportSMIMEIdentityFile(a.ctx, path, password)
+}
+
+// ExportSMIMECertificate returns a certificate of the S/MIME store
+// PEM-encoded, to give to correspondents
+func (a *App) ExportSMIMECertificate(fingerprint string) (string, error) {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("ExportSMIMECertificate called from frontend")
+
+	return a.smimeController.ExportSMIMECertificate(a.ctx, fingerprint)
+}
+
+// ListSMIMECertificates returns the certificates of the S/MIME store
+func (a *App) ListSMIMECertificates() ([]controllers.SMIMECertificateResponse, error) {
+	config.Logger.Debug().Msg("ListSMIMECertificates called from frontend")
+
+	return a.smimeController.ListSMIMECertificates(a.ctx)
+}
+
+// DeleteSMIMECertificate removes a certificate from the S/MIME store
+func (a *App) DeleteSMIMECertificate(fingerprint string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("DeleteSMIMECertificate called from frontend")
+
+	return a.smimeController.DeleteSMIMECertificate(a.ctx, fingerprint)
+}
+
+// SetSMIMETrustAnchor trusts a certificate as a root for S/MIME
+// signatures, or stops trusting it
+func (a *App) SetSMIMETrustAnchor(fingerprint string, trusted bool) error {
+	config.Logger.Debug().
+		Str("fingerprint", fingerprint).
+		Bool("trusted", trusted).
+		Msg("SetSMIMETrustAnchor called from frontend")
+
+	return a.smimeController.SetSMIMETrustAnchor(a.ctx, fingerprint, trusted)
+}
+
+// UnlockSMIMEIdentity unlocks an identity with its password until the app
+// quits
+func (a *App) UnlockSMIMEIdentity(fingerprint string, password string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("UnlockSMIMEIdentity called from frontend")
+
+	return a.smimeController.UnlockSMIMEIdentity(a.ctx, fingerprint, password)
+}
+
+// ImportMbox asks for an mbox file and imports it into the account.
+// format is "mboxrd" (the default when empty) or "mboxo". It returns nil if
+// the dialog was cancelled.
+func (a *App) ImportMbox(accountID uint, format string) (*controllers.ImportResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ImportMbox called from frontend")
+
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Import mbox",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Mailbox files (*.mbox)", Pattern: "*.mbox;*.mbx"},
+			{DisplayName: "All files", Pattern: "*"},
+		},
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.archiveController.ImportMboxFile(a.ctx, accountID, path, format)
+}
+
+// ExportMbox asks where to save and writes the account's messages, or those
+// matching query, as an mbox file. It returns nil if the dialog was
+// cancelled.
+func (a *App) ExportMbox(accountID uint, query string, format string) (*controllers.ExportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Msg("ExportMbox called from frontend")
+
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Export mbox",
+		DefaultFilename: "palm.mbox",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Mailbox files (*.mbox)", Pattern: "*.mbox"},
+		},
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.archiveController.ExportMboxFile(a.ctx, accountID, query, path, format)
+}
+
+// AddMaildirAccount asks for a maildir, such as one kept by offlineimap or
+// mbsync, and adds it as a Local Maildir account. Its messages are loaded in
+// the background and kept in sync while the app runs. It returns nil if the
+// dialog was cancelled.
+func (a *App) AddMaildirAccount(email string) (*controllers.AccountResponse, error) {
+	config.Logger.Debug().Str("email", email).Msg("AddMaildirAccount called from frontend")
+
+	path, err := runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Choose maildir",
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	account, err := a.accountController.CreateMaildirAccount(a.ctx, email, path)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if _, err := a.syncService.SyncAccount(a.ctx, account.ID); err != nil {
+			config.Logger.Error().Err(err).Uint("accountID", account.ID).Msg("Initial maildir sync failed")
+		}
+	}()
+	return account, nil
+}
+
+// SetTrustedAuthServIDs sets the servers, such as "mx.example.org", whose
+// Authentication-Results fields are believed when verifying the senders of
+// an account's mail. Without any, only DKIM signatures are checked.
+func (a *App) SetTrustedAuthServIDs(accountID uint, authServIDs []string) (*controllers.AccountResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("SetTrustedAuthServIDs called from frontend")
+	return a.accountController.SetTrustedAuthServIDs(a.ctx, accountID, authServIDs)
+}
+
+// ImportMaildir asks for a maildir and imports its messages into the
+// account. It returns nil if the dialog was cancelled.
+func (a *App) ImportMaildir(accountID uint) (*controllers.ImportResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ImportMaildir called from frontend")
+
+	path, err := runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Import maildir",
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.archiveController.ImportMaildirDir(a.ctx, accountID, path)
+}
+
+// ExportMaildir asks for a directory and delivers the account's messages, or
+// those matching query, into it as a maildir. It returns nil if the dialog
+// was cancelled.
+func (a *App) ExportMaildir(accountID uint, query string) (*controllers.ExportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Msg("ExportMaildir called from frontend")
+
+	path, err := runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:                "Export maildir",
+		CanCreateDirectories: true,
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.archiveController.ExportMaildirDir(a.ctx, accountID, query, path)
+}
+
+// ImportEML asks for one or more .eml files and imports them into the
+// account. It returns nil if the dialog was cancelled.
+func (a *App) ImportEML(accountID uint) (*controllers.ImportResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ImportEML called from frontend")
+
+	paths, err := runtime.OpenMultipleFilesDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Import .eml",
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Email messages (*.eml)", Pattern: "*.eml"},
+			{DisplayName: "All files", Pattern: "*"},
+		},
+	})
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+	return a.archiveController.ImportEMLFiles(a.ctx, accountID, paths)
+}
+
+// ImportEMLFile imports a single .eml file, such as one dropped on the
+// window, and returns the created email
+func (a *App) ImportEMLFile(accountID uint, path string) (*controllers.EmailResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("path", path).
+		Msg("ImportEMLFile called from frontend")
+
+	return a.archiveController.ImportEMLFile(a.ctx, accountID, path)
+}
+
+// ImportEMLFiles imports the .eml files dropped on the window
+func (a *App) ImportEMLFiles(accountID uint, paths []string) (*controllers.ImportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Int("count", len(paths)).
+		Msg("ImportEMLFiles called from frontend")
+
+	return a.archiveController.ImportEMLFiles(a.ctx, accountID, paths)
+}
+
+// ExportEmail asks where to save and writes the email's RFC 5322 source as
+// a .eml file. It returns nil if the dialog was cancelled.
+func (a *App) ExportEmail(messageID uint) (*controllers.ExportResponse, error) {
+	config.Logger.Debug().Uint("messageID", messageID).Msg("ExportEmail called from frontend")
+
+	filename, err := a.archiveController.EMLFilename(a.ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Save as .eml",
+		DefaultFilename: filename,
+		Filters: []runtime.FileFilter{
+			{DisplayName: "Email messages (*.eml)", Pattern: "*.eml"},
+		},
+	})
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return a.archiveController.ExportEmailFile(a.ctx, messageID, path)
+}
+
+// FollowUpEmail reminds the user of a sent email if no recipient replies
+// within days, with a message:follow-up event and the email back in the
+// inbox as unread
+func (a *App) FollowUpEmail(emailID uint, days int) (*controllers.FollowUpResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Int("days", days).Msg("FollowUpEmail called from frontend")
+
+	return a.followUpController.FollowUpEmail(a.ctx, emailID, days)
+}
+
+// CancelFollowUp drops the reminder of a sent email
+func (a *App) CancelFollowUp(emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("CancelFollowUp called from frontend")
+
+	return a.followUpController.CancelFollowUp(a.ctx, emailID)
+}
+
+// ListFollowUps returns the sent emails of an account awaiting a reply
+func (a *App) ListFollowUps(accountID uint) ([]controllers.FollowUpResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("ListFollowUps called from frontend")
+
+	return a.followUpController.ListFollowUps(a.ctx, accountID)
+}
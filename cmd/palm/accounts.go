@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var accountCommands = map[string]command{
+	"list": {
+		usage: "",
+		run:   runAccountsList,
+	},
+	"add": {
+		usage: "<email> <Microsoft|Google>",
+		run:   runAccountsAdd,
+	},
+	"add-maildir": {
+		usage: "<email> <maildir>",
+		run:   runAccountsAddMaildir,
+	},
+	"remove": {
+		usage: "<account-id>",
+		run:   runAccountsRemove,
+	},
+	"trust": {
+		usage: "<account-id> [authserv-id...]",
+		run:   runAccountsTrust,
+	},
+}
+
+func runAccountsList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	accounts, err := a.accountController.ListAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.output(accounts, func(w io.Writer) error {
+		rows := make([][]string, 0, len(accounts))
+		for _, account := range accounts {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(account.ID), 10),
+				account.Email,
+				account.AccountType,
+			})
+		}
+		return table(w, []string{"ID", "EMAIL", "TYPE"}, rows)
+	})
+}
+
+func runAccountsAdd(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return usageError(fs, "expected an email address and an account type")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	account, err := a.accountController.CreateAccount(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	return a.output(account, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Added account %d (%s)\n", account.ID, account.Email)
+		return err
+	})
+}
+
+// runAccountsAddMaildir adds a Local Maildir account; "palm sync" loads it
+func runAccountsAddMaildir(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return usageError(fs, "expected an email address and a maildir")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	account, err := a.accountController.CreateMaildirAccount(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	return a.output(account, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Added account %d (%s) reading %s\n", account.ID, account.Email, account.MaildirPath)
+		return err
+	})
+}
+
+func runAccountsRemove(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an account id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.accountController.DeleteAccount(ctx, id); err != nil {
+		return err
+	}
+
+	return a.output(map[string]uint{"removed": id}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Removed account %d\n", id)
+		return err
+	})
+}
+
+// runAccountsTrust sets the servers whose Authentication-Results fields are
+// believed when verifying senders; without any, only DKIM is checked
+func runAccountsTrust(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) < 1 {
+		return usageError(fs, "expected an account id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	account, err := a.accountController.SetTrustedAuthServIDs(ctx, id, args[1:])
+	if err != nil {
+		return err
+	}
+
+	return a.output(account, func(w io.Writer) error {
+		if len(account.TrustedAuthServIDs) == 0 {
+			_, err := fmt.Fprintf(w, "Account %d trusts no Authentication-Results\n", id)
+			return err
+		}
+		_, err := fmt.Fprintf(w, "Account %d trusts Authentication-Results from %s\n", id, strings.Join(account.TrustedAuthServIDs, ", "))
+		return err
+	})
+}
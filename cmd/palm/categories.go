@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// categoryFlags holds the flags of the categories commands
+var categoryFlags struct {
+	accountID uint
+}
+
+var categoryCommands = map[string]command{
+	"set": {
+		usage: "<email-id> <Primary|Updates|Promotions|Social>",
+		run:   runCategoriesSet,
+	},
+	"overrides": {
+		usage: "--account <id>",
+		run:   runCategoriesOverrides,
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&categoryFlags.accountID, "account", 0, "account id (required)")
+		},
+	},
+	"forget": {
+		usage: "<override-id>",
+		run:   runCategoriesForget,
+	},
+}
+
+// runCategoriesSet moves an email and the rest of its sender's emails to
+// a tab
+func runCategoriesSet(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return usageError(fs, "expected an email id and a category")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	moved, err := a.categoryController.SetEmailCategory(ctx, id, args[1])
+	if err != nil {
+		return err
+	}
+	return a.output(map[string]any{"email": id, "category": args[1], "moved": moved}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Moved %d emails to %s\n", moved, args[1])
+		return err
+	})
+}
+
+func runCategoriesOverrides(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if categoryFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	overrides, err := a.categoryController.ListCategoryOverrides(ctx, categoryFlags.accountID)
+	if err != nil {
+		return err
+	}
+	return a.output(overrides, func(w io.Writer) error {
+		rows := make([][]string, len(overrides))
+		for i, o := range overrides {
+			rows[i] = []string{strconv.FormatUint(uint64(o.ID), 10), o.SenderEmail, o.Category, o.UpdatedAt}
+		}
+		return table(w, []string{"ID", "SENDER", "CATEGORY", "UPDATED"}, rows)
+	})
+}
+
+// runCategoriesForget lets the categorizer sort a sender's emails again
+func runCategoriesForget(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an override id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	moved, err := a.categoryController.DeleteCategoryOverride(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.output(map[string]any{"override": id, "moved": moved}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Forgot override %d; moved %d emails\n", id, moved)
+		return err
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// certFlags holds the flags of the certs commands
+var certFlags struct {
+	password string
+	out      string
+}
+
+var certCommands = map[string]command{
+	"list": {
+		usage: "",
+		run:   runCertsList,
+	},
+	"import": {
+		usage: "<certificate-file>",
+		run:   runCertsImport,
+	},
+	"import-identity": {
+		usage: "[--password <password>] <pkcs12-file>",
+		run:   runCertsImportIdentity,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&certFlags.password, "password", "", "password of the PKCS#12 file")
+		},
+	},
+	"export": {
+		usage: "[--out <file>] <fingerprint>",
+		run:   runCertsExport,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&certFlags.out, "out", "", "write to this file instead of stdout")
+		},
+	},
+	"trust": {
+		usage: "<fingerprint>",
+		run:   runCertsTrust(true),
+	},
+	"untrust": {
+		usage: "<fingerprint>",
+		run:   runCertsTrust(false),
+	},
+	"delete": {
+		usage: "<fingerprint>",
+		run:   runCertsDelete,
+	},
+}
+
+func runCertsList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	certificates, err := a.smimeController.ListSMIMECertificates(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.output(certificates, func(w io.Writer) error {
+		rows := make([][]string, 0, len(certificates))
+		for _, certificate := range certificates {
+			var kind []string
+			switch {
+			case certificate.Locked:
+				kind = append(kind, "identity (locked)")
+			case certificate.IsIdentity:
+				kind = append(kind, "identity")
+			case certificate.IsCA:
+				kind = append(kind, "CA")
+			}
+			if certificate.TrustAnchor {
+				kind = append(kind, "trusted")
+			}
+			rows = append(rows, []string{
+				certificate.Fingerprint[:16],
+				strings.Join(kind, ", "),
+				certificate.Subject,
+				strings.Join(certificate.Emails, ", "),
+				certificate.NotAfter[:10],
+			})
+		}
+		return table(w, []string{"FINGERPRINT", "TYPE", "SUBJECT", "EMAILS", "EXPIRES"}, rows)
+	})
+}
+
+// runCertsImport adds the certificates of a PEM or DER file
+func runCertsImport(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a certificate file")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	certificates, err := a.smimeController.ImportSMIMECertificateFile(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	return a.output(certificates, func(w io.Writer) error {
+		for _, certificate := range certificates {
+			if _, err := fmt.Fprintf(w, "Imported %s %s\n", certificate.Fingerprint, certificate.Subject); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runCertsImportIdentity adds one of the user's identities from a PKCS#12
+// file. Identities protected by a password can only be used by the
+// desktop app, where they are unlocked for the session.
+func runCertsImportIdentity(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a PKCS#12 file")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	certificate, err := a.smimeController.ImportSMIMEIdentityFile(ctx, args[0], certFlags.password)
+	if err != nil {
+		return err
+	}
+
+	return a.output(certificate, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Imported identity %s %s\n", certificate.Fingerprint, certificate.Subject)
+		return err
+	})
+}
+
+func runCertsExport(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a fingerprint")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	certificate, err := a.smimeController.ExportSMIMECertificate(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if certFlags.out != "" {
+		return os.WriteFile(certFlags.out, []byte(certificate), 0o644)
+	}
+	_, err = io.WriteString(a.stdout, certificate)
+	return err
+}
+
+// runCertsTrust returns the command that adds a certificate to the trust
+// store, or removes it
+func runCertsTrust(trusted bool) func(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	return func(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+		if len(args) != 1 {
+			return usageError(fs, "expected a fingerprint")
+		}
+		if err := a.open(); err != nil {
+			return err
+		}
+
+		if err := a.smimeController.SetSMIMETrustAnchor(ctx, args[0], trusted); err != nil {
+			return err
+		}
+		return a.output(map[string]bool{"trusted": trusted}, func(w io.Writer) error {
+			verb := "Trusted"
+			if !trusted {
+				verb = "No longer trusting"
+			}
+			_, err := fmt.Fprintf(w, "%s %s\n", verb, args[0])
+			return err
+		})
+	}
+}
+
+func runCertsDelete(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a fingerprint")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.smimeController.DeleteSMIMECertificate(ctx, args[0]); err != nil {
+		return err
+	}
+	return a.output(map[string]string{"deleted": args[0]}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Deleted certificate %s\n", args[0])
+		return err
+	})
+}
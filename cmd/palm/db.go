@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+var dbCommands = map[string]command{
+	"migrate": {
+		usage: "",
+		run:   runDBMigrate,
+	},
+	"vacuum": {
+		usage: "",
+		run:   runDBVacuum,
+	},
+	"check": {
+		usage: "",
+		run:   runDBCheck,
+	},
+}
+
+func runDBMigrate(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	// The only command that creates the database or changes its schema
+	if err := a.connect(true); err != nil {
+		return err
+	}
+	if err := a.maintenanceService.Migrate(ctx); err != nil {
+		return err
+	}
+	return a.output(map[string]bool{"migrated": true}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Migrated %s\n", a.opts.dbPath)
+		return err
+	})
+}
+
+func runDBVacuum(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	if err := a.maintenanceService.Vacuum(ctx); err != nil {
+		return err
+	}
+	return a.output(map[string]bool{"vacuumed": true}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Vacuumed %s\n", a.opts.dbPath)
+		return err
+	})
+}
+
+func runDBCheck(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	result, err := a.maintenanceService.Check(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := a.output(result, func(w io.Writer) error {
+		if result.OK {
+			_, err := fmt.Fprintln(w, "Database OK")
+			return err
+		}
+		for _, table := range result.MissingTables {
+			fmt.Fprintf(w, "missing table: %s\n", table)
+		}
+		for _, line := range result.IntegrityErrors {
+			fmt.Fprintf(w, "integrity: %s\n", line)
+		}
+		for _, line := range result.ForeignKeyViolations {
+			fmt.Fprintf(w, "foreign key: %s\n", line)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if !result.OK {
+		return fmt.Errorf("database check failed")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// followUpFlags holds the flags of the followups commands
+var followUpFlags struct {
+	accountID uint
+}
+
+var followUpCommands = map[string]command{
+	"list": {
+		usage: "--account <id>",
+		run:   runFollowUpList,
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&followUpFlags.accountID, "account", 0, "account id (required)")
+		},
+	},
+	"set": {
+		usage: "<email-id> <days>",
+		run:   runFollowUpSet,
+	},
+	"cancel": {
+		usage: "<email-id>",
+		run:   runFollowUpCancel,
+	},
+	"remind": {
+		usage: "",
+		run:   runFollowUpRemind,
+	},
+}
+
+func runFollowUpList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if followUpFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	followUps, err := a.followUpController.ListFollowUps(ctx, followUpFlags.accountID)
+	if err != nil {
+		return err
+	}
+	return a.output(followUps, func(w io.Writer) error {
+		rows := make([][]string, len(followUps))
+		for i, f := range followUps {
+			rows[i] = []string{strconv.FormatUint(uint64(f.EmailID), 10), f.SentAt, f.RemindAt, truncate(f.Subject, 60)}
+		}
+		return table(w, []string{"ID", "SENT", "REMIND AT", "SUBJECT"}, rows)
+	})
+}
+
+func runFollowUpSet(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return usageError(fs, "expected an email id and a number of days")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	days, err := strconv.Atoi(args[1])
+	if err != nil {
+		return usageError(fs, "invalid number of days %q", args[1])
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	followUp, err := a.followUpController.FollowUpEmail(ctx, id, days)
+	if err != nil {
+		return err
+	}
+	return a.output(followUp, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Reminding of email %d at %s unless someone replies\n", id, followUp.RemindAt)
+		return err
+	})
+}
+
+func runFollowUpCancel(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an email id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.followUpController.CancelFollowUp(ctx, id); err != nil {
+		return err
+	}
+	return a.output(map[string]uint{"email": id}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "No longer following up email %d\n", id)
+		return err
+	})
+}
+
+// runFollowUpRemind resurfaces the unanswered emails whose reminder is
+// due, as the desktop app does while it runs
+func runFollowUpRemind(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	reminded, err := a.followUpService.RemindDue(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	return a.output(map[string]int{"reminded": reminded}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Resurfaced %d emails awaiting a reply\n", reminded)
+		return err
+	})
+}
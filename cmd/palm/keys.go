@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// keyFlags holds the flags of the keys commands
+var keyFlags struct {
+	secret bool
+	out    string
+}
+
+var keyCommands = map[string]command{
+	"list": {
+		usage: "",
+		run:   runKeysList,
+	},
+	"import": {
+		usage: "<key-file>",
+		run:   runKeysImport,
+	},
+	"export": {
+		usage: "[--secret] [--out <file>] <fingerprint>",
+		run:   runKeysExport,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&keyFlags.secret, "secret", false, "export the secret key")
+			fs.StringVar(&keyFlags.out, "out", "", "write to this file instead of stdout")
+		},
+	},
+	"delete": {
+		usage: "<fingerprint>",
+		run:   runKeysDelete,
+	},
+}
+
+func runKeysList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	keys, err := a.pgpController.ListPGPKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.output(keys, func(w io.Writer) error {
+		rows := make([][]string, 0, len(keys))
+		for _, key := range keys {
+			kind := "public"
+			switch {
+			case key.Locked:
+				kind = "secret (locked)"
+			case key.HasSecret:
+				kind = "secret"
+			}
+			if key.Revoked {
+				kind += ", revoked"
+			}
+			rows = append(rows, []string{key.Fingerprint, kind, key.UserID, strings.Join(key.Emails, ", ")})
+		}
+		return table(w, []string{"FINGERPRINT", "TYPE", "USER ID", "EMAILS"}, rows)
+	})
+}
+
+// runKeysImport adds the keys of an ASCII-armored or binary key file
+func runKeysImport(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a key file")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	keys, err := a.pgpController.ImportPGPKeys(ctx, string(data))
+	if err != nil {
+		return err
+	}
+
+	return a.output(keys, func(w io.Writer) error {
+		for _, key := range keys {
+			if _, err := fmt.Fprintf(w, "Imported %s %s\n", key.Fingerprint, key.UserID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func runKeysExport(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a fingerprint")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	armored, err := a.pgpController.ExportPGPKey(ctx, args[0], keyFlags.secret)
+	if err != nil {
+		return err
+	}
+	if keyFlags.out != "" {
+		// Secret keys are only readable by their owner
+		return os.WriteFile(keyFlags.out, []byte(armored), 0o600)
+	}
+	_, err = io.WriteString(a.stdout, armored)
+	return err
+}
+
+func runKeysDelete(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a fingerprint")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.pgpController.DeletePGPKey(ctx, args[0]); err != nil {
+		return err
+	}
+	return a.output(map[string]string{"deleted": args[0]}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Deleted key %s\n", args[0])
+		return err
+	})
+}
@@ -0,0 +1,813 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"palm/src/controllers"
+	"palm/src/formats/mbox"
+	"palm/src/services"
+)
+
+// mailFlags holds the flags of the mail commands
+var mailFlags struct {
+	accountID   uint
+	page        int
+	pageSize    int
+	out         string
+	options     controllers.ListEmailsOptions
+	attachments string
+	drafts      string
+	folder      string
+	mailingList uint
+	query       string
+	format      string
+}
+
+func registerListFlags(fs *flag.FlagSet) {
+	fs.UintVar(&mailFlags.accountID, "account", 0, "account id")
+	fs.IntVar(&mailFlags.page, "page", 1, "page number")
+	fs.IntVar(&mailFlags.pageSize, "page-size", 20, "emails per page (1-100)")
+}
+
+func registerFilterFlags(fs *flag.FlagSet) {
+	registerListFlags(fs)
+	o := &mailFlags.options
+	fs.BoolVar(&o.UnreadOnly, "unread", false, "only unread emails")
+	fs.BoolVar(&o.FlaggedOnly, "flagged", false, "only flagged emails")
+	fs.StringVar(&o.Importance, "importance", "", "only emails with this importance (Low, Normal, High)")
+	fs.StringVar(&o.Sender, "sender", "", "only emails whose sender contains this text")
+	fs.StringVar(&o.ReceivedAfter, "after", "", "only emails received at or after this RFC 3339 time")
+	fs.StringVar(&o.ReceivedBefore, "before", "", "only emails received before this RFC 3339 time")
+	fs.StringVar(&mailFlags.attachments, "attachments", "", "yes or no to require or exclude attachments")
+	fs.StringVar(&mailFlags.drafts, "drafts", "", "yes for only drafts, no to exclude drafts")
+	fs.StringVar(&mailFlags.folder, "folder", "", "only emails in this folder; empty for the inbox")
+	fs.StringVar(&o.Label, "label", "", "only emails with this label")
+	fs.UintVar(&mailFlags.mailingList, "mailing-list", 0, "only emails from this mailing list id")
+	fs.StringVar(&o.Category, "category", "", "only emails in this tab (Primary, Updates, Promotions, Social)")
+	fs.StringVar(&o.SortBy, "sort", "", "sort by date, sender, subject or size")
+	fs.StringVar(&o.SortOrder, "order", "", "sort order, asc or desc")
+}
+
+// listOptions completes the filter options from the yes/no flags
+func listOptions(fs *flag.FlagSet) (controllers.ListEmailsOptions, error) {
+	o := mailFlags.options
+	for _, f := range []struct {
+		name  string
+		value string
+		dest  **bool
+	}{
+		{"attachments", mailFlags.attachments, &o.HasAttachments},
+		{"drafts", mailFlags.drafts, &o.Drafts},
+	} {
+		switch f.value {
+		case "":
+		case "yes":
+			v := true
+			*f.dest = &v
+		case "no":
+			v := false
+			*f.dest = &v
+		default:
+			return o, usageError(fs, "--%s must be yes or no", f.name)
+		}
+	}
+	// These filter only when given, since an empty --folder selects the inbox
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "folder":
+			folder := mailFlags.folder
+			o.Folder = &folder
+		case "mailing-list":
+			id := mailFlags.mailingList
+			o.MailingListID = &id
+		}
+	})
+	return o, nil
+}
+
+var mailCommands = map[string]command{
+	"list": {
+		usage: "[--account <id>] [--page n] [--page-size n] [filter and sort flags]",
+		flags: registerFilterFlags,
+		run:   runMailList,
+	},
+	"search": {
+		usage: "--account <id> [--page n] [--page-size n] <query>",
+		flags: registerListFlags,
+		run:   runMailSearch,
+	},
+	"show": {
+		usage: "<message-id>",
+		run:   runMailShow,
+	},
+	"headers": {
+		usage: "<message-id>",
+		run:   runMailHeaders,
+	},
+	"verify": {
+		usage: "<message-id>",
+		run:   runMailVerify,
+	},
+	"allow-remote": {
+		usage: "<address|domain>",
+		run:   runMailAllowRemote,
+	},
+	"disallow-remote": {
+		usage: "<address|domain>",
+		run:   runMailDisallowRemote,
+	},
+	"remote-senders": {
+		usage: "",
+		run:   runMailRemoteSenders,
+	},
+	"export": {
+		usage: "--account <id> [--out file]",
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&mailFlags.accountID, "account", 0, "account id (required)")
+			fs.StringVar(&mailFlags.out, "out", "", "output file (default stdout)")
+		},
+		run: runMailExport,
+	},
+	"import-mbox": {
+		usage: "--account <id> [--format mboxrd|mboxo] <file>",
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&mailFlags.accountID, "account", 0, "account id (required)")
+			fs.StringVar(&mailFlags.format, "format", "mboxrd", "quoting convention, mboxrd or mboxo")
+		},
+		run: runMailImportMbox,
+	},
+	"export-mbox": {
+		usage: "--account <id> [--query text] [--format mboxrd|mboxo] [--out file]",
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&mailFlags.accountID, "account", 0, "account id (required)")
+			fs.StringVar(&mailFlags.query, "query", "", "only export emails matching this search")
+			fs.StringVar(&mailFlags.format, "format", "mboxrd", "quoting convention, mboxrd or mboxo")
+			fs.StringVar(&mailFlags.out, "out", "", "output file (default stdout)")
+		},
+		run: runMailExportMbox,
+	},
+	"import-eml": {
+		usage: "--account <id> <file>...",
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&mailFlags.accountID, "account", 0, "account id (required)")
+		},
+		run: runMailImportEML,
+	},
+	"export-eml": {
+		usage: "[--out file] <message-id>",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&mailFlags.out, "out", "", "output file (default stdout)")
+		},
+		run: runMailExportEML,
+	},
+	"import-maildir": {
+		usage: "--account <id> <maildir>",
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&mailFlags.accountID, "account", 0, "account id (required)")
+		},
+		run: runMailImportMaildir,
+	},
+	"export-maildir": {
+		usage: "--account <id> [--query text] --out <maildir>",
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&mailFlags.accountID, "account", 0, "account id (required)")
+			fs.StringVar(&mailFlags.query, "query", "", "only export emails matching this search")
+			fs.StringVar(&mailFlags.out, "out", "", "maildir to deliver into, created if missing (required)")
+		},
+		run: runMailExportMaildir,
+	},
+}
+
+// runMailList lists one account, or the unified inbox of all accounts when
+// --account is omitted
+func runMailList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	options, err := listOptions(fs)
+	if err != nil {
+		return err
+	}
+
+	if mailFlags.accountID == 0 {
+		if options != (controllers.ListEmailsOptions{}) {
+			return usageError(fs, "filter and sort flags require --account")
+		}
+		response, err := a.emailController.ListUnifiedEmails(ctx, nil, mailFlags.page, mailFlags.pageSize)
+		if err != nil {
+			return err
+		}
+		return a.output(response, func(w io.Writer) error {
+			return printEmailPage(w, &controllers.ListEmailsResponse{
+				Emails:     response.Emails,
+				TotalCount: response.TotalCount,
+				Page:       response.Page,
+				PageSize:   response.PageSize,
+				TotalPages: response.TotalPages,
+			})
+		})
+	}
+
+	response, err := a.emailController.ListEmails(ctx, mailFlags.accountID, mailFlags.page, mailFlags.pageSize, options)
+	if err != nil {
+		return err
+	}
+	return a.output(response, func(w io.Writer) error { return printEmailPage(w, response) })
+}
+
+func runMailSearch(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if mailFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if len(args) == 0 {
+		return usageError(fs, "expected a search query")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	query := strings.Join(args, " ")
+	response, err := a.emailController.SearchEmails(ctx, mailFlags.accountID, query, mailFlags.page, mailFlags.pageSize)
+	if err != nil {
+		return err
+	}
+	return a.output(response, func(w io.Writer) error { return printEmailPage(w, response) })
+}
+
+func runMailShow(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a message id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	email, err := a.emailController.GetEmail(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return a.output(email, func(w io.Writer) error {
+		fmt.Fprintf(w, "ID:         %d\n", email.ID)
+		fmt.Fprintf(w, "Account:    %d\n", email.AccountID)
+		fmt.Fprintf(w, "From:       %s\n", formatAddress(email.SenderName, email.SenderEmail))
+		if email.Authentication.SenderStatus != "" {
+			fmt.Fprintf(w, "Sender:     %s\n", email.Authentication.SenderStatus)
+		}
+		for _, r := range email.Recipients {
+			fmt.Fprintf(w, "%-11s %s\n", r.Type+":", formatAddress(r.Name, r.Email))
+		}
+		fmt.Fprintf(w, "Subject:    %s\n", email.Subject)
+		fmt.Fprintf(w, "Received:   %s\n", email.ReceivedAt)
+		fmt.Fprintf(w, "Importance: %s\n", email.Importance)
+		fmt.Fprintf(w, "Read:       %t\n", email.IsRead)
+		if email.Folder != "" {
+			fmt.Fprintf(w, "Folder:     %s\n", email.Folder)
+		}
+		if len(email.Labels) > 0 {
+			fmt.Fprintf(w, "Labels:     %s\n", strings.Join(email.Labels, ", "))
+		}
+		for _, att := range email.Attachments {
+			fmt.Fprintf(w, "Attachment: %s (%s, %d bytes)\n", att.Filename, att.MimeType, att.Size)
+		}
+		if pgp := email.OpenPGP; pgp.Encrypted || pgp.Signature != "" {
+			fmt.Fprintf(w, "OpenPGP:    %s\n", formatOpenPGP(pgp))
+		}
+		if smime := email.SMIME; smime.Encrypted || smime.Signature != "" {
+			fmt.Fprintf(w, "S/MIME:     %s\n", formatSMIME(smime))
+		}
+		for _, event := range email.Events {
+			fmt.Fprintf(w, "Event:      %s\n", formatEvent(event))
+			fmt.Fprintf(w, "            %s\n", event.When)
+			if event.Recurrence != "" {
+				fmt.Fprintf(w, "            %s\n", event.Recurrence)
+			}
+			if event.Location != "" {
+				fmt.Fprintf(w, "            at %s\n", event.Location)
+			}
+		}
+		if email.Risk.Score > 0 {
+			fmt.Fprintf(w, "Risk:       %d (%s)\n", email.Risk.Score, email.Risk.Level)
+			for _, reason := range email.Risk.Reasons {
+				fmt.Fprintf(w, "            %s\n", reason.Detail)
+			}
+		}
+		_, err := fmt.Fprintf(w, "\n%s\n", email.Text)
+		return err
+	})
+}
+
+// formatOpenPGP summarizes the OpenPGP status of an email, e.g.
+// "decrypted, good signature by Alice <alice@example.com>"
+func formatOpenPGP(pgp controllers.OpenPGPResponse) string {
+	var parts []string
+	switch {
+	case pgp.Decrypted:
+		parts = append(parts, "decrypted")
+	case pgp.Encrypted:
+		parts = append(parts, "encrypted ("+pgp.DecryptError+")")
+	}
+	if pgp.Signature != "" {
+		signer := pgp.SignerUserID
+		if signer == "" {
+			signer = "key " + pgp.SignerKeyID
+		}
+		signature := pgp.Signature + " signature by " + signer
+		if pgp.Signature == "good" && !pgp.SignerMatches {
+			signature += ", not the sender's key"
+		}
+		parts = append(parts, signature)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatSMIME summarizes the S/MIME status of an email, e.g.
+// "decrypted, good signature by CN=Alice (trusted)"
+func formatSMIME(smime controllers.SMIMEResponse) string {
+	var parts []string
+	switch {
+	case smime.Decrypted:
+		parts = append(parts, "decrypted")
+	case smime.Encrypted:
+		parts = append(parts, "encrypted ("+smime.DecryptError+")")
+	}
+	if smime.Signature != "" {
+		signature := smime.Signature + " signature"
+		if smime.SignerSubject != "" {
+			signature += " by " + smime.SignerSubject
+		}
+		switch {
+		case smime.Trusted:
+			signature += " (trusted)"
+		case smime.TrustError != "":
+			signature += " (untrusted: " + smime.TrustError + ")"
+		}
+		if smime.Signature == "good" && !smime.SignerMatches {
+			signature += ", not the sender's certificate"
+		}
+		parts = append(parts, signature)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatEvent summarizes a calendar event of an email, e.g.
+// "Planning (invitation from Alice <alice@example.com>, accepted)"
+func formatEvent(event controllers.CalendarEventResponse) string {
+	summary := event.Summary
+	if summary == "" {
+		summary = "(no title)"
+	}
+	var details []string
+	switch event.Method {
+	case "REQUEST":
+		details = append(details, "invitation from "+formatAddress(event.Organizer.Name, event.Organizer.Email))
+	case "CANCEL":
+		details = append(details, "cancelled by "+formatAddress(event.Organizer.Name, event.Organizer.Email))
+	case "REPLY":
+		for _, attendee := range event.Attendees {
+			details = append(details, strings.ToLower(attendee.Status)+" by "+formatAddress(attendee.Name, attendee.Email))
+		}
+	}
+	if event.Response != "" {
+		details = append(details, strings.ToLower(event.Response))
+	}
+	if len(details) == 0 {
+		return summary
+	}
+	return summary + " (" + strings.Join(details, ", ") + ")"
+}
+
+// runMailExport writes every email of an account as a JSON array
+func runMailExport(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if mailFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	var emails []controllers.EmailResponse
+	for page := 1; ; page++ {
+		response, err := a.emailController.ListEmails(ctx, mailFlags.accountID, page, 100, controllers.ListEmailsOptions{AllFolders: true})
+		if err != nil {
+			return err
+		}
+		emails = append(emails, response.Emails...)
+		if page >= response.TotalPages {
+			break
+		}
+	}
+
+	w := a.stdout
+	if mailFlags.out != "" {
+		file, err := os.Create(mailFlags.out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", mailFlags.out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := printJSON(w, emails); err != nil {
+		return err
+	}
+
+	if mailFlags.out != "" {
+		fmt.Fprintf(a.stderr, "Exported %d emails to %s\n", len(emails), mailFlags.out)
+	}
+	return nil
+}
+
+// mboxFormat parses the --format flag
+func mboxFormat(fs *flag.FlagSet) (mbox.Format, error) {
+	switch mailFlags.format {
+	case "mboxrd":
+		return mbox.MboxRD, nil
+	case "mboxo":
+		return mbox.MboxO, nil
+	default:
+		return 0, usageError(fs, "--format must be mboxrd or mboxo")
+	}
+}
+
+// runMailImportMbox imports an mbox file, reporting progress on stderr
+func runMailImportMbox(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if mailFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected an mbox file")
+	}
+	format, err := mboxFormat(fs)
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", args[0], err)
+	}
+	defer file.Close()
+
+	opts := services.ImportOptions{Format: format}
+	if info, err := file.Stat(); err == nil {
+		opts.TotalBytes = info.Size()
+	}
+	opts.Progress = a.importProgress()
+
+	result, err := a.archiveService.ImportMbox(ctx, mailFlags.accountID, file, opts)
+	return a.importOutput(result, err, opts)
+}
+
+// runMailImportMaildir imports a maildir and its subfolders, reporting
+// progress on stderr
+func runMailImportMaildir(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if mailFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if len(args) != 1 {
+		return usageError(fs, "expected a maildir")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	opts := services.ImportOptions{Progress: a.importProgress()}
+	result, err := a.archiveService.ImportMaildir(ctx, mailFlags.accountID, args[0], opts)
+	return a.importOutput(result, err, opts)
+}
+
+// runMailImportEML imports .eml files. A single file is created directly so
+// a duplicate is reported as an error.
+func runMailImportEML(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if mailFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if len(args) == 0 {
+		return usageError(fs, "expected one or more .eml files")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if len(args) > 1 {
+		opts := services.ImportOptions{Progress: a.importProgress()}
+		result, err := a.archiveService.ImportEMLFiles(ctx, mailFlags.accountID, args, opts)
+		return a.importOutput(result, err, opts)
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", args[0], err)
+	}
+	defer file.Close()
+
+	email, err := a.archiveService.ImportEML(ctx, mailFlags.accountID, file)
+	if err != nil {
+		return err
+	}
+	return a.output(map[string]uint{"id": email.Message.ID}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Imported email %d\n", email.Message.ID)
+		return err
+	})
+}
+
+// runMailHeaders prints every header field of an email
+func runMailHeaders(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a message id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	response, err := a.emailController.GetEmailHeaders(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.output(response, func(w io.Writer) error {
+		if response.Reconstructed {
+			fmt.Fprintln(a.stderr, "The original source was not stored; these headers were rebuilt.")
+		}
+		for _, header := range response.Headers {
+			fmt.Fprintf(w, "%s: %s\n", header.Name, header.Value)
+		}
+		return nil
+	})
+}
+
+// runMailAllowRemote lets messages from a sender load remote images
+func runMailAllowRemote(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an address or a domain")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.emailController.AllowRemoteContent(ctx, args[0]); err != nil {
+		return err
+	}
+	return a.output(map[string]string{"allowed": args[0]}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Remote images from %s will be loaded\n", args[0])
+		return err
+	})
+}
+
+// runMailVerify verifies the sender of a message again, analyzes it again
+// and prints the results
+func runMailVerify(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a message id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if _, err := a.senderVerifier.Verify(ctx, id); err != nil {
+		return err
+	}
+	if _, err := a.phishingAnalyzer.Analyze(ctx, id); err != nil {
+		return err
+	}
+	email, err := a.emailController.GetEmail(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]interface{}{"authentication": email.Authentication, "risk": email.Risk}
+	return a.output(result, func(w io.Writer) error {
+		auth := email.Authentication
+		fmt.Fprintf(w, "Sender: %s (%s)\n", email.SenderEmail, auth.SenderStatus)
+		dkim := auth.DKIM
+		if auth.DKIMDomain != "" {
+			dkim += " (" + auth.DKIMDomain + ")"
+		}
+		fmt.Fprintf(w, "DKIM:   %s\n", dkim)
+		fmt.Fprintf(w, "SPF:    %s\n", auth.SPF)
+		fmt.Fprintf(w, "DMARC:  %s\n", auth.DMARC)
+		fmt.Fprintf(w, "ARC:    %s\n", auth.ARC)
+		fmt.Fprintf(w, "Risk:   %d (%s)\n", email.Risk.Score, email.Risk.Level)
+		for _, reason := range email.Risk.Reasons {
+			fmt.Fprintf(w, "        %s\n", reason.Detail)
+		}
+		return nil
+	})
+}
+
+// runMailDisallowRemote blocks remote images from a sender again
+func runMailDisallowRemote(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an address or a domain")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.emailController.DisallowRemoteContent(ctx, args[0]); err != nil {
+		return err
+	}
+	return a.output(map[string]string{"disallowed": args[0]}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Remote images from %s will be blocked\n", args[0])
+		return err
+	})
+}
+
+// runMailRemoteSenders lists the senders whose remote images are loaded
+func runMailRemoteSenders(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	senders, err := a.emailController.ListRemoteContentSenders(ctx)
+	if err != nil {
+		return err
+	}
+	return a.output(senders, func(w io.Writer) error {
+		for _, sender := range senders {
+			fmt.Fprintln(w, sender)
+		}
+		return nil
+	})
+}
+
+// runMailExportEML writes the RFC 5322 source of one email
+func runMailExportEML(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a message id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	w := a.stdout
+	if mailFlags.out != "" {
+		file, err := os.Create(mailFlags.out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", mailFlags.out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	return a.archiveService.WriteEML(ctx, id, w)
+}
+
+// importProgress returns a progress callback that rewrites one line of
+// stderr, or nil for JSON output
+func (a *cli) importProgress() func(services.ImportResult) {
+	if a.opts.json {
+		return nil
+	}
+	return func(p services.ImportResult) {
+		percent := 100.0
+		if p.TotalBytes > 0 {
+			percent = float64(p.BytesRead) * 100 / float64(p.TotalBytes)
+		}
+		fmt.Fprintf(a.stderr, "\r%5.1f%%  %d processed, %d imported, %d duplicates, %d failed",
+			percent, p.Processed, p.Imported, p.Duplicates, p.Failed)
+	}
+}
+
+// importOutput ends the progress line and prints the result of an import
+func (a *cli) importOutput(result *services.ImportResult, err error, opts services.ImportOptions) error {
+	if opts.Progress != nil {
+		fmt.Fprintln(a.stderr)
+	}
+	if err != nil {
+		return err
+	}
+	return a.output(result, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Imported %d of %d messages (%d duplicates, %d failed)\n",
+			result.Imported, result.Processed, result.Duplicates, result.Failed)
+		return err
+	})
+}
+
+// runMailExportMbox writes an account, or a search within it, as mbox
+func runMailExportMbox(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if mailFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	format, err := mboxFormat(fs)
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	w := a.stdout
+	if mailFlags.out != "" {
+		file, err := os.Create(mailFlags.out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", mailFlags.out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	count, err := a.archiveService.ExportMbox(ctx, mailFlags.accountID, mailFlags.query, w, format)
+	if err != nil {
+		return err
+	}
+	if mailFlags.out != "" {
+		fmt.Fprintf(a.stderr, "Exported %d emails to %s\n", count, mailFlags.out)
+	}
+	return nil
+}
+
+// runMailExportMaildir delivers an account, or a search within it, into a maildir
+func runMailExportMaildir(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if mailFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if mailFlags.out == "" {
+		return usageError(fs, "--out is required")
+	}
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	count, err := a.archiveService.ExportMaildir(ctx, mailFlags.accountID, mailFlags.query, mailFlags.out)
+	if err != nil {
+		return err
+	}
+	return a.output(&controllers.ExportResponse{Path: mailFlags.out, Count: count}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Exported %d emails to %s\n", count, mailFlags.out)
+		return err
+	})
+}
+
+func printEmailPage(w io.Writer, response *controllers.ListEmailsResponse) error {
+	if err := printEmails(w, response.Emails); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nPage %d of %d (%d emails)\n", response.Page, response.TotalPages, response.TotalCount)
+	return err
+}
+
+// printEmails writes a table of emails, unread ones marked with a star
+func printEmails(w io.Writer, emails []controllers.EmailResponse) error {
+	rows := make([][]string, 0, len(emails))
+	for _, email := range emails {
+		read := " "
+		if !email.IsRead {
+			read = "*"
+		}
+		rows = append(rows, []string{
+			strconv.FormatUint(uint64(email.ID), 10),
+			read,
+			email.ReceivedAt,
+			truncate(formatAddress(email.SenderName, email.SenderEmail), 40),
+			truncate(email.Subject, 60),
+		})
+	}
+	return table(w, []string{"ID", "", "RECEIVED", "FROM", "SUBJECT"}, rows)
+}
+
+func formatAddress(name, email string) string {
+	if name == "" {
+		return email
+	}
+	return fmt.Sprintf("%s <%s>", name, email)
+}
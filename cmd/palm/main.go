@@ -0,0 +1,359 @@
+// Command palm operates on a Palm mailbox database without the desktop app.
+//
+// Usage:
+//
+//	palm <group> <command> [flags] [args]
+//
+// Every command accepts --db to select the database file and --json to
+// print machine-readable output.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"palm/src/config"
+	"palm/src/controllers"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/repositories"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const defaultDBPath = "palm.sqlite"
+
+// errUsage is returned when the command line is malformed; the usage text
+// has already been printed
+var errUsage = errors.New("invalid usage")
+
+// options holds the flags shared by every command
+type options struct {
+	dbPath  string
+	json    bool
+	verbose bool
+}
+
+// register adds the shared flags to a command's flag set
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.dbPath, "db", defaultDBPath, "path to the Palm database")
+	fs.BoolVar(&o.json, "json", false, "print output as JSON")
+	fs.BoolVar(&o.verbose, "verbose", false, "log debug output to stderr")
+}
+
+// command is a leaf command such as "accounts list"
+type command struct {
+	usage string
+	run   func(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error
+	flags func(fs *flag.FlagSet)
+}
+
+// cli carries the parsed options, output streams and lazily opened services
+type cli struct {
+	opts   options
+	stdout io.Writer
+	stderr io.Writer
+
+	db                     *gorm.DB
+	accountRepo            repositories.AccountRepository
+	accountController      *controllers.AccountController
+	emailService           *services.EmailService
+	emailController        *controllers.EmailController
+	syncService            *services.SyncService
+	contactService         *services.ContactService
+	archiveService         *services.ArchiveService
+	maintenanceService     *services.MaintenanceService
+	senderVerifier         *services.SenderVerifier
+	phishingAnalyzer       *services.PhishingAnalyzer
+	pgpController          *controllers.PGPController
+	ruleController         *controllers.RuleController
+	sieveController        *controllers.SieveController
+	spamController         *controllers.SpamController
+	subscriptionController *controllers.SubscriptionController
+	categoryController     *controllers.CategoryController
+	snoozeService          *services.SnoozeService
+	snoozeController       *controllers.SnoozeController
+	followUpService        *services.FollowUpService
+	followUpController     *controllers.FollowUpController
+	sendController         *controllers.SendController
+	smimeController        *controllers.SMIMEController
+}
+
+var commands = map[string]map[string]command{
+	"accounts":      accountCommands,
+	"certs":         certCommands,
+	"mail":          mailCommands,
+	"outbox":        outboxCommands,
+	"rules":         ruleCommands,
+	"sieve":         sieveCommands,
+	"snooze":        snoozeCommands,
+	"spam":          spamCommands,
+	"subscriptions": subscriptionCommands,
+	"sync":          {"": syncCommand},
+	"db":            dbCommands,
+	"followups":     followUpCommands,
+	"keys":          keyCommands,
+}
+
+func main() {
+	a := &cli{stdout: os.Stdout, stderr: os.Stderr}
+	if err := a.run(context.Background(), os.Args[1:]); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintf(a.stderr, "palm: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run resolves the command named by args and executes it
+func (a *cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		a.printUsage()
+		if len(args) == 0 {
+			return errUsage
+		}
+		return nil
+	}
+
+	group, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(a.stderr, "palm: unknown command %q\n\n", args[0])
+		a.printUsage()
+		return errUsage
+	}
+
+	name := ""
+	rest := args[1:]
+	if _, single := group[""]; !single {
+		if len(rest) == 0 {
+			a.printUsage()
+			return errUsage
+		}
+		name, rest = rest[0], rest[1:]
+	}
+	cmd, ok := group[name]
+	if !ok {
+		fmt.Fprintf(a.stderr, "palm: unknown command %q\n\n", strings.TrimSpace(args[0]+" "+name))
+		a.printUsage()
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet(strings.TrimSpace("palm "+args[0]+" "+name), flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	a.opts.register(fs)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "Usage: %s %s\n\nFlags:\n", fs.Name(), cmd.usage)
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, rest)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+
+	a.initLogger()
+	defer a.close()
+	return cmd.run(ctx, a, fs, positional)
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments, returning the positional arguments in order
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// initLogger sends logs to stderr so stdout only carries command output
+func (a *cli) initLogger() {
+	config.InitLoggerWithWriter(a.stderr)
+	if a.opts.verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
+
+// open connects to an existing database and wires the services on first
+// use. The schema is left as it is: only db migrate changes it.
+func (a *cli) open() error {
+	return a.connect(false)
+}
+
+// connect is open, creating the database file if create is true and it
+// does not exist yet
+func (a *cli) connect(create bool) error {
+	if a.db != nil {
+		return nil
+	}
+
+	// SQL errors are only shown with --verbose; commands report their own
+	gormLogger := logger.Default.LogMode(logger.Silent)
+	if a.opts.verbose {
+		gormLogger = logger.Default
+	}
+	db, err := config.OpenPalmDB(a.opts.dbPath, create, gormLogger)
+	if errors.Is(err, config.ErrDatabaseNotFound) {
+		return fmt.Errorf("database %s not found; run palm db migrate to create it", a.opts.dbPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open database %s: %w", a.opts.dbPath, err)
+	}
+	a.db = db
+
+	a.accountRepo = sqlite.NewAccountRepository(db)
+	messageRepo := sqlite.NewMessageRepository(db)
+	recipientRepo := sqlite.NewRecipientRepository(db)
+	attachmentRepo := sqlite.NewAttachmentRepository(db)
+
+	// Messages written by commands such as sync feed the same subscribers
+	// as in the desktop app
+	bus := events.NewBus()
+
+	a.accountController = controllers.NewAccountController(services.NewAccountService(a.accountRepo))
+	a.emailService = services.NewEmailService(db, messageRepo, recipientRepo, attachmentRepo)
+	a.emailService.SetEventBus(bus)
+	// Attachment contents live next to the database, as in the desktop app
+	store := services.NewAttachmentStore(filepath.Join(filepath.Dir(a.opts.dbPath), "attachments"))
+	pgpService := services.NewPGPService(db)
+	a.pgpController = controllers.NewPGPController(pgpService)
+	smimeService := services.NewSMIMEService(db, nil)
+	a.smimeController = controllers.NewSMIMEController(smimeService)
+	// Local Maildir accounts send through the system's sendmail, as in the
+	// desktop app; the other account types have no transport yet
+	sendService := services.NewSendService(a.accountRepo, a.emailService, pgpService, smimeService, store)
+	sendService.RegisterTransport(entities.AccountTypeMaildir, services.NewSendmailTransport(services.DefaultSendmailPath))
+	calendarService := services.NewCalendarService(db, a.emailService, sendService, store)
+	calendarService.Subscribe(bus)
+	// The command line shows no images, so no proxy is needed
+	a.emailController = controllers.NewEmailController(a.emailService,
+		services.NewSourceService(db, a.emailService, store),
+		services.NewRemoteContentService(db, nil),
+		pgpService,
+		smimeService,
+		calendarService)
+	a.syncService = services.NewSyncService(a.accountRepo, a.emailService)
+	a.syncService.SetEventBus(bus)
+	a.contactService = services.NewContactService(db, sqlite.NewContactRepository(db))
+	a.contactService.Subscribe(bus)
+	a.phishingAnalyzer = services.NewPhishingAnalyzer(db)
+	a.phishingAnalyzer.Subscribe(bus)
+	// Senders of the messages a command stores are verified before it
+	// exits
+	a.senderVerifier = services.NewSenderVerifier(db, nil)
+	a.senderVerifier.SetPhishingAnalyzer(a.phishingAnalyzer)
+	a.senderVerifier.Subscribe(bus)
+	a.archiveService = services.NewArchiveService(db, a.accountRepo, a.emailService, store)
+	maildirSource := services.NewMaildirSource(db, a.emailService, store)
+	maildirSource.SetEventBus(bus)
+	maildirSource.Subscribe(bus)
+	a.syncService.RegisterSource(entities.AccountTypeMaildir, maildirSource)
+	spamFilter := services.NewSpamFilter(db, a.emailService, store)
+	spamFilter.SetEventBus(bus)
+	spamFilter.Subscribe(bus)
+	a.spamController = controllers.NewSpamController(spamFilter)
+	// Unsubscribing by mail fails for accounts without a transport
+	subscriptionService := services.NewSubscriptionService(db, sendService, nil)
+	subscriptionService.SetEventBus(bus)
+	subscriptionService.Subscribe(bus)
+	a.subscriptionController = controllers.NewSubscriptionController(subscriptionService)
+	categorizer := services.NewCategorizer(db)
+	categorizer.SetEventBus(bus)
+	categorizer.Subscribe(bus)
+	a.categoryController = controllers.NewCategoryController(categorizer)
+	// The command line has no scheduler; snooze wake wakes due messages
+	a.snoozeService = services.NewSnoozeService(db)
+	a.snoozeService.SetEventBus(bus)
+	a.snoozeController = controllers.NewSnoozeController(a.snoozeService)
+	// The command line has no reminder loop; followups remind fires due
+	// reminders
+	a.followUpService = services.NewFollowUpService(db)
+	a.followUpService.SetEventBus(bus)
+	a.followUpService.Subscribe(bus)
+	a.followUpController = controllers.NewFollowUpController(a.followUpService)
+	// Queued messages can be listed and cancelled; the desktop app sends
+	// them
+	a.sendController = controllers.NewSendController(sendService,
+		services.NewSendScheduler(db, sendService, a.followUpService), a.followUpService)
+	// Rules run last, as in the desktop app
+	ruleService := services.NewRuleService(db, a.emailService, sendService, store)
+	ruleService.SetEventBus(bus)
+	ruleService.Subscribe(bus)
+	a.ruleController = controllers.NewRuleController(ruleService)
+	// The active Sieve script runs after the rules
+	sieveService := services.NewSieveService(db, a.emailService, sendService, store)
+	sieveService.SetEventBus(bus)
+	sieveService.Subscribe(bus)
+	a.sieveController = controllers.NewSieveController(sieveService)
+	a.maintenanceService = services.NewMaintenanceService(db)
+	return nil
+}
+
+func (a *cli) close() {
+	if a.db == nil {
+		return
+	}
+	a.senderVerifier.VerifyPending(context.Background())
+	if sqlDB, err := a.db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// usageError prints the command usage and returns errUsage
+func usageError(fs *flag.FlagSet, format string, args ...interface{}) error {
+	fmt.Fprintf(fs.Output(), "%s: %s\n", fs.Name(), fmt.Sprintf(format, args...))
+	fs.Usage()
+	return errUsage
+}
+
+func (a *cli) printUsage() {
+	fmt.Fprintln(a.stderr, "Usage: palm <command> [flags] [args]")
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, "Commands:")
+
+	groups := make([]string, 0, len(commands))
+	for name := range commands {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		names := make([]string, 0, len(commands[group]))
+		for name := range commands[group] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			line := strings.TrimSpace(group + " " + name)
+			fmt.Fprintf(a.stderr, "  %-20s %s\n", line, commands[group][name].usage)
+		}
+	}
+
+	fmt.Fprintln(a.stderr)
+	fmt.Fprintln(a.stderr, "Common flags: --db <path>, --json, --verbose")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// outboxFlags holds the flags of the outbox commands
+var outboxFlags struct {
+	accountID uint
+}
+
+var outboxCommands = map[string]command{
+	"list": {
+		usage: "--account <id>",
+		run:   runOutboxList,
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&outboxFlags.accountID, "account", 0, "account id (required)")
+		},
+	},
+	"cancel": {
+		usage: "<outbox-id>",
+		run:   runOutboxCancel,
+	},
+}
+
+func runOutboxList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if outboxFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	messages, err := a.sendController.ListScheduledEmails(ctx, outboxFlags.accountID)
+	if err != nil {
+		return err
+	}
+	return a.output(messages, func(w io.Writer) error {
+		rows := make([][]string, len(messages))
+		for i, m := range messages {
+			status := m.Status
+			if m.Error != "" {
+				status += ": " + truncate(m.Error, 40)
+			}
+			rows[i] = []string{
+				strconv.FormatUint(uint64(m.ID), 10), m.SendAt, status,
+				truncate(m.Recipients, 40), truncate(m.Subject, 60),
+			}
+		}
+		return table(w, []string{"ID", "SEND AT", "STATUS", "TO", "SUBJECT"}, rows)
+	})
+}
+
+// runOutboxCancel keeps a queued email from being sent and prints it
+func runOutboxCancel(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an outbox id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	email, err := a.sendController.CancelScheduledEmail(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.output(email, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Cancelled %q to %s\n", email.Subject, strings.Join(email.To, ", "))
+		return err
+	})
+}
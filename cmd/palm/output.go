@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// printJSON writes v as indented JSON
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// output prints v as JSON when --json is set and otherwise calls text
+func (a *cli) output(v interface{}, text func(w io.Writer) error) error {
+	if a.opts.json {
+		return printJSON(a.stdout, v)
+	}
+	return text(a.stdout)
+}
+
+// table writes aligned columns to w
+func table(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	writeRow := func(cells []string) {
+		for i, cell := range cells {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	writeRow(header)
+	for _, row := range rows {
+		writeRow(row)
+	}
+	return tw.Flush()
+}
+
+// parseID parses a positive database identifier
+func parseID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 0)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	return uint(id), nil
+}
+
+// truncate shortens s to at most n runes for tabular output
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
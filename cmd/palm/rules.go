@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"palm/src/controllers"
+)
+
+// ruleFlags holds the flags of the rules commands
+var ruleFlags struct {
+	limit int
+}
+
+var ruleCommands = map[string]command{
+	"list": {
+		usage: "",
+		run:   runRulesList,
+	},
+	"add": {
+		usage: "<rule.json>",
+		run:   runRulesAdd,
+	},
+	"delete": {
+		usage: "<rule-id>",
+		run:   runRulesDelete,
+	},
+	"reorder": {
+		usage: "<rule-id>...",
+		run:   runRulesReorder,
+	},
+	"dry-run": {
+		usage: "[--limit n] <rule-id|rule.json>",
+		run:   runRulesDryRun,
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&ruleFlags.limit, "limit", 0, "list at most this many matching emails")
+		},
+	},
+}
+
+func runRulesList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	rules, err := a.ruleController.ListRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.output(rules, func(w io.Writer) error {
+		rows := make([][]string, 0, len(rules))
+		for _, rule := range rules {
+			var flags []string
+			if !rule.Enabled {
+				flags = append(flags, "disabled")
+			}
+			if rule.StopProcessing {
+				flags = append(flags, "stop")
+			}
+			account := "all"
+			if rule.AccountID != nil {
+				account = strconv.FormatUint(uint64(*rule.AccountID), 10)
+			}
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(rule.ID), 10),
+				rule.Name,
+				account,
+				formatConditions(rule.RuleRequest),
+				formatActions(rule.Actions),
+				strings.Join(flags, ", "),
+			})
+		}
+		return table(w, []string{"ID", "NAME", "ACCOUNT", "CONDITIONS", "ACTIONS", "FLAGS"}, rows)
+	})
+}
+
+// runRulesAdd adds the rule described by a JSON file, in the format of
+// the rules list --json output
+func runRulesAdd(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a rule file")
+	}
+	request, err := readRule(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	rule, err := a.ruleController.CreateRule(ctx, *request)
+	if err != nil {
+		return err
+	}
+	return a.output(rule, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Added rule %d %s\n", rule.ID, rule.Name)
+		return err
+	})
+}
+
+func runRulesDelete(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a rule id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.ruleController.DeleteRule(ctx, id); err != nil {
+		return err
+	}
+	return a.output(map[string]uint{"deleted": id}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Deleted rule %d\n", id)
+		return err
+	})
+}
+
+// runRulesReorder sets the order rules run in from the ids of every rule
+func runRulesReorder(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return usageError(fs, "expected the ids of every rule in order")
+	}
+	ids := make([]uint, len(args))
+	for i, arg := range args {
+		id, err := parseID(arg)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.ruleController.ReorderRules(ctx, ids); err != nil {
+		return err
+	}
+	return a.output(map[string][]uint{"order": ids}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Reordered %d rules\n", len(ids))
+		return err
+	})
+}
+
+// runRulesDryRun lists the stored emails a saved rule, or one described
+// by a JSON file, matches without taking its actions
+func runRulesDryRun(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a rule id or file")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	var request *controllers.RuleRequest
+	if id, err := parseID(args[0]); err == nil {
+		rules, err := a.ruleController.ListRules(ctx)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			if rule.ID == id {
+				request = &rule.RuleRequest
+				break
+			}
+		}
+		if request == nil {
+			return fmt.Errorf("rule %d not found", id)
+		}
+	} else if request, err = readRule(args[0]); err != nil {
+		return err
+	}
+
+	response, err := a.ruleController.DryRunRule(ctx, *request, ruleFlags.limit)
+	if err != nil {
+		return err
+	}
+	return a.output(response, func(w io.Writer) error {
+		if err := printEmails(w, response.Emails); err != nil {
+			return err
+		}
+		more := ""
+		if response.Truncated {
+			more = ", more not listed"
+		}
+		_, err := fmt.Fprintf(w, "\n%d of %d emails match%s\n", len(response.Emails), response.Scanned, more)
+		return err
+	})
+}
+
+// readRule reads a rule from a JSON file
+func readRule(path string) (*controllers.RuleRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var request controllers.RuleRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, fmt.Errorf("invalid rule file %s: %w", path, err)
+	}
+	return &request, nil
+}
+
+// formatConditions describes the conditions of a rule, as in
+// `subject contains "invoice" and has-attachments equals "true"`
+func formatConditions(rule controllers.RuleRequest) string {
+	parts := make([]string, len(rule.Conditions))
+	for i, c := range rule.Conditions {
+		field := c.Field
+		if c.Header != "" {
+			field = c.Header
+		}
+		parts[i] = fmt.Sprintf("%s %s %q", field, c.Operator, c.Value)
+	}
+	join := " or "
+	if rule.MatchAll {
+		join = " and "
+	}
+	return strings.Join(parts, join)
+}
+
+// formatActions describes the actions of a rule, as in "label Bills, mark-read"
+func formatActions(actions []controllers.RuleActionRequest) string {
+	parts := make([]string, len(actions))
+	for i, action := range actions {
+		parts[i] = strings.TrimSpace(action.Type + " " + action.Value)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"palm/src/controllers"
+)
+
+// sieveFlags holds the flags of the sieve commands
+var sieveFlags struct {
+	name     string
+	account  uint
+	activate bool
+	username string
+	password string
+	security string
+}
+
+var sieveCommands = map[string]command{
+	"list": {
+		usage: "",
+		run:   runSieveList,
+	},
+	"import": {
+		usage: "[--name name] [--account id] [--activate] <script.sieve>",
+		run:   runSieveImport,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&sieveFlags.name, "name", "", "name of the script; the file name without extension by default")
+			fs.UintVar(&sieveFlags.account, "account", 0, "account the script filters; every account by default")
+			fs.BoolVar(&sieveFlags.activate, "activate", false, "make the script the active one of its account")
+		},
+	},
+	"activate": {
+		usage: "<script-id>",
+		run:   runSieveActivate,
+	},
+	"deactivate": {
+		usage: "<script-id>",
+		run:   runSieveDeactivate,
+	},
+	"delete": {
+		usage: "<script-id>",
+		run:   runSieveDelete,
+	},
+	"test": {
+		usage: "<script-id|script.sieve> <email-id>",
+		run:   runSieveTest,
+	},
+	"upload": {
+		usage: "--username user [--password pw] [--security starttls|tls|none] <script-id> <host[:port]>",
+		run:   runSieveUpload,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&sieveFlags.username, "username", "", "user name on the ManageSieve server")
+			fs.StringVar(&sieveFlags.password, "password", "", "password on the ManageSieve server")
+			fs.StringVar(&sieveFlags.security, "security", "starttls", "connection security: starttls, tls or none")
+		},
+	},
+}
+
+func runSieveList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	scripts, err := a.sieveController.ListSieveScripts(ctx)
+	if err != nil {
+		return err
+	}
+	return a.output(scripts, func(w io.Writer) error {
+		rows := make([][]string, 0, len(scripts))
+		for _, script := range scripts {
+			account := "all"
+			if script.AccountID != nil {
+				account = strconv.FormatUint(uint64(*script.AccountID), 10)
+			}
+			active := ""
+			if script.Active {
+				active = "active"
+			}
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(script.ID), 10),
+				script.Name,
+				account,
+				active,
+			})
+		}
+		return table(w, []string{"ID", "NAME", "ACCOUNT", "STATUS"}, rows)
+	})
+}
+
+// runSieveImport stores a script file, replacing the script of the same
+// name and account
+func runSieveImport(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a script file")
+	}
+	source, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	request := controllers.SieveScriptRequest{
+		Name:   sieveFlags.name,
+		Source: string(source),
+		Active: sieveFlags.activate,
+	}
+	if request.Name == "" {
+		request.Name = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	}
+	if sieveFlags.account != 0 {
+		request.AccountID = &sieveFlags.account
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	script, err := a.sieveController.ImportSieveScript(ctx, request)
+	if err != nil {
+		return err
+	}
+	return a.output(script, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Imported sieve script %d %s\n", script.ID, script.Name)
+		return err
+	})
+}
+
+func runSieveActivate(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	return setSieveScriptActive(ctx, a, fs, args, true)
+}
+
+func runSieveDeactivate(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	return setSieveScriptActive(ctx, a, fs, args, false)
+}
+
+func setSieveScriptActive(ctx context.Context, a *cli, fs *flag.FlagSet, args []string, active bool) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a script id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.sieveController.SetSieveScriptActive(ctx, id, active); err != nil {
+		return err
+	}
+	state := "Deactivated"
+	if active {
+		state = "Activated"
+	}
+	return a.output(map[string]any{"id": id, "active": active}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%s sieve script %d\n", state, id)
+		return err
+	})
+}
+
+func runSieveDelete(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a script id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.sieveController.DeleteSieveScript(ctx, id); err != nil {
+		return err
+	}
+	return a.output(map[string]uint{"deleted": id}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Deleted sieve script %d\n", id)
+		return err
+	})
+}
+
+// runSieveTest lists the actions a saved script, or a script file, would
+// take on a stored email without taking them
+func runSieveTest(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return usageError(fs, "expected a script id or file and an email id")
+	}
+	emailID, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	var source string
+	if id, err := parseID(args[0]); err == nil {
+		scripts, err := a.sieveController.ListSieveScripts(ctx)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, script := range scripts {
+			if script.ID == id {
+				source, found = script.Source, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("sieve script %d not found", id)
+		}
+	} else {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		source = string(data)
+	}
+
+	actions, err := a.sieveController.TestSieveScript(ctx, source, emailID)
+	if err != nil {
+		return err
+	}
+	return a.output(actions, func(w io.Writer) error {
+		for _, action := range actions {
+			line := action.Type
+			if action.Value != "" {
+				line += " " + strconv.Quote(action.Value)
+			}
+			if len(action.Flags) > 0 {
+				line += " flags " + strings.Join(action.Flags, " ")
+			}
+			if action.Copy {
+				line += " (copy)"
+			}
+			if action.Implicit {
+				line += " (implicit)"
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runSieveUpload stores a script on a ManageSieve server, activating it
+// there if it is active
+func runSieveUpload(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return usageError(fs, "expected a script id and a server")
+	}
+	if sieveFlags.username == "" {
+		return usageError(fs, "--username is required")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	err = a.sieveController.UploadSieveScript(ctx, id, controllers.ManageSieveRequest{
+		Address:  args[1],
+		Username: sieveFlags.username,
+		Password: sieveFlags.password,
+		Security: sieveFlags.security,
+	})
+	if err != nil {
+		return err
+	}
+	return a.output(map[string]any{"uploaded": id, "server": args[1]}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Uploaded sieve script %d to %s\n", id, args[1])
+		return err
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// snoozeFlags holds the flags of the snooze commands
+var snoozeFlags struct {
+	accountID uint
+}
+
+var snoozeCommands = map[string]command{
+	"list": {
+		usage: "--account <id>",
+		run:   runSnoozeList,
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&snoozeFlags.accountID, "account", 0, "account id (required)")
+		},
+	},
+	"set": {
+		usage: "<email-id> <RFC 3339 time|preset>",
+		run:   runSnoozeSet,
+	},
+	"cancel": {
+		usage: "<email-id>",
+		run:   runSnoozeCancel,
+	},
+	"presets": {
+		usage: "",
+		run:   runSnoozePresets,
+	},
+	"wake": {
+		usage: "",
+		run:   runSnoozeWake,
+	},
+}
+
+func runSnoozeList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if snoozeFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	snoozes, err := a.snoozeController.ListSnoozedEmails(ctx, snoozeFlags.accountID)
+	if err != nil {
+		return err
+	}
+	return a.output(snoozes, func(w io.Writer) error {
+		rows := make([][]string, len(snoozes))
+		for i, s := range snoozes {
+			rows[i] = []string{strconv.FormatUint(uint64(s.EmailID), 10), s.Until, s.SenderEmail, truncate(s.Subject, 60)}
+		}
+		return table(w, []string{"ID", "UNTIL", "FROM", "SUBJECT"}, rows)
+	})
+}
+
+func runSnoozeSet(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return usageError(fs, "expected an email id and a time")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	snooze, err := a.snoozeController.SnoozeEmail(ctx, id, args[1])
+	if err != nil {
+		return err
+	}
+	return a.output(snooze, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Snoozed email %d until %s\n", id, snooze.Until)
+		return err
+	})
+}
+
+func runSnoozeCancel(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an email id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.snoozeController.UnsnoozeEmail(ctx, id); err != nil {
+		return err
+	}
+	return a.output(map[string]uint{"email": id}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Email %d is back\n", id)
+		return err
+	})
+}
+
+// runSnoozePresets prints the times offered now in the local time zone
+func runSnoozePresets(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	presets := a.snoozeController.GetSnoozePresets()
+	return a.output(presets, func(w io.Writer) error {
+		rows := make([][]string, len(presets))
+		for i, p := range presets {
+			rows[i] = []string{p.Name, p.Label, p.Until}
+		}
+		return table(w, []string{"PRESET", "LABEL", "UNTIL"}, rows)
+	})
+}
+
+// runSnoozeWake wakes the emails whose snooze ended, as the desktop app
+// does while it runs
+func runSnoozeWake(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	woken, err := a.snoozeService.WakeDue(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	return a.output(map[string]int{"woken": woken}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Woke %d emails\n", woken)
+		return err
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"palm/src/services"
+)
+
+// spamFlags holds the flags of the spam commands
+var spamFlags struct {
+	folds     int
+	threshold float64
+}
+
+var spamCommands = map[string]command{
+	"status": {
+		usage: "",
+		run:   runSpamStatus,
+	},
+	"mark": {
+		usage: "<email-id>...",
+		run:   runSpamMark,
+	},
+	"unmark": {
+		usage: "<email-id>...",
+		run:   runSpamUnmark,
+	},
+	"threshold": {
+		usage: "<score>",
+		run:   runSpamThreshold,
+	},
+	"evaluate": {
+		usage: "[--folds n] [--threshold score] <corpus-dir>",
+		run:   runSpamEvaluate,
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&spamFlags.folds, "folds", services.DefaultSpamFolds, "number of cross-validation folds")
+			fs.Float64Var(&spamFlags.threshold, "threshold", services.DefaultSpamThreshold, "score from which messages count as spam")
+		},
+	},
+}
+
+func runSpamStatus(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	status, err := a.spamController.GetSpamStatus(ctx)
+	if err != nil {
+		return err
+	}
+	return a.output(status, func(w io.Writer) error {
+		ready := "no, mark more emails as spam and not spam"
+		if status.Ready {
+			ready = "yes"
+		}
+		return table(w, []string{"SETTING", "VALUE"}, [][]string{
+			{"spam trained", strconv.Itoa(status.SpamMessages)},
+			{"not spam trained", strconv.Itoa(status.HamMessages)},
+			{"tokens", strconv.FormatInt(status.Tokens, 10)},
+			{"threshold", strconv.FormatFloat(status.Threshold, 'f', -1, 64)},
+			{"scoring", ready},
+		})
+	})
+}
+
+// runSpamMark trains the filter with emails as spam
+func runSpamMark(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	return trainSpam(ctx, a, fs, args, true)
+}
+
+// runSpamUnmark trains the filter with emails as wanted
+func runSpamUnmark(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	return trainSpam(ctx, a, fs, args, false)
+}
+
+func trainSpam(ctx context.Context, a *cli, fs *flag.FlagSet, args []string, spam bool) error {
+	if len(args) == 0 {
+		return usageError(fs, "expected email ids")
+	}
+	ids := make([]uint, len(args))
+	for i, arg := range args {
+		id, err := parseID(arg)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var err error
+		if spam {
+			err = a.spamController.MarkSpam(ctx, id)
+		} else {
+			err = a.spamController.MarkNotSpam(ctx, id)
+		}
+		if err != nil {
+			return fmt.Errorf("email %d: %w", id, err)
+		}
+	}
+	label := "not spam"
+	if spam {
+		label = "spam"
+	}
+	return a.output(map[string]any{"emails": ids, "spam": spam}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Marked %d emails as %s\n", len(ids), label)
+		return err
+	})
+}
+
+func runSpamThreshold(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a score")
+	}
+	threshold, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return usageError(fs, "invalid score %q", args[0])
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if err := a.spamController.SetSpamThreshold(ctx, threshold); err != nil {
+		return err
+	}
+	return a.output(map[string]float64{"threshold": threshold}, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "Spam threshold set to %g\n", threshold)
+		return err
+	})
+}
+
+// runSpamEvaluate cross-validates the classifier on a labelled corpus, a
+// directory with spam and ham subdirectories of messages. The database is
+// not used.
+func runSpamEvaluate(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected a corpus directory")
+	}
+	samples, err := services.LoadSpamCorpus(args[0])
+	if err != nil {
+		return err
+	}
+	evaluation, err := services.EvaluateSpamClassifier(samples, spamFlags.folds, spamFlags.threshold)
+	if err != nil {
+		return err
+	}
+
+	result := map[string]any{
+		"samples":        len(samples),
+		"truePositives":  evaluation.TruePositives,
+		"falsePositives": evaluation.FalsePositives,
+		"trueNegatives":  evaluation.TrueNegatives,
+		"falseNegatives": evaluation.FalseNegatives,
+		"unsure":         evaluation.Unsure,
+		"precision":      evaluation.Precision(),
+		"recall":         evaluation.Recall(),
+		"accuracy":       evaluation.Accuracy(),
+		"misclassified":  evaluation.Misclassified,
+	}
+	return a.output(result, func(w io.Writer) error {
+		percent := func(v float64) string { return strconv.FormatFloat(100*v, 'f', 1, 64) + "%" }
+		err := table(w, []string{"MEASURE", "VALUE"}, [][]string{
+			{"samples", strconv.Itoa(len(samples))},
+			{"spam caught", strconv.Itoa(evaluation.TruePositives)},
+			{"spam missed", strconv.Itoa(evaluation.FalseNegatives)},
+			{"wanted kept", strconv.Itoa(evaluation.TrueNegatives)},
+			{"wanted taken for spam", strconv.Itoa(evaluation.FalsePositives)},
+			{"unscored", strconv.Itoa(evaluation.Unsure)},
+			{"precision", percent(evaluation.Precision())},
+			{"recall", percent(evaluation.Recall())},
+			{"accuracy", percent(evaluation.Accuracy())},
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range evaluation.Misclassified {
+			if _, err := fmt.Fprintf(w, "misclassified: %s\n", name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"palm/src/services"
+)
+
+// subscriptionFlags holds the flags of the subscriptions commands
+var subscriptionFlags struct {
+	accountID uint
+}
+
+var subscriptionCommands = map[string]command{
+	"list": {
+		usage: "--account <id>",
+		run:   runSubscriptionsList,
+		flags: func(fs *flag.FlagSet) {
+			fs.UintVar(&subscriptionFlags.accountID, "account", 0, "account id (required)")
+		},
+	},
+	"unsubscribe": {
+		usage: "<email-id>",
+		run:   runSubscriptionsUnsubscribe,
+	},
+}
+
+func runSubscriptionsList(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if subscriptionFlags.accountID == 0 {
+		return usageError(fs, "--account is required")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	subscriptions, err := a.subscriptionController.ListSubscriptions(ctx, subscriptionFlags.accountID)
+	if err != nil {
+		return err
+	}
+	return a.output(subscriptions, func(w io.Writer) error {
+		rows := make([][]string, 0, len(subscriptions))
+		for _, s := range subscriptions {
+			unsubscribe := "no"
+			switch {
+			case s.UnsubscribedAt != "":
+				unsubscribe = "done"
+			case s.OneClick:
+				unsubscribe = "one-click"
+			case s.CanUnsubscribe:
+				unsubscribe = "yes"
+			}
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(s.ID), 10),
+				s.Name,
+				s.SenderEmail,
+				strconv.Itoa(s.Messages),
+				strconv.Itoa(s.Unread),
+				s.LastReceivedAt,
+				s.LastReadAt,
+				unsubscribe,
+			})
+		}
+		return table(w, []string{"ID", "NAME", "SENDER", "EMAILS", "UNREAD", "LAST RECEIVED", "LAST READ", "UNSUBSCRIBE"}, rows)
+	})
+}
+
+// runSubscriptionsUnsubscribe unsubscribes from the list an email came
+// from; lists offering only a web page print it to be opened
+func runSubscriptionsUnsubscribe(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return usageError(fs, "expected an email id")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	result, err := a.subscriptionController.Unsubscribe(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.output(result, func(w io.Writer) error {
+		var err error
+		switch result.Method {
+		case services.UnsubscribeWeb:
+			_, err = fmt.Fprintf(w, "Open %s to unsubscribe\n", result.URL)
+		case services.UnsubscribeMailto:
+			_, err = fmt.Fprintf(w, "Sent an unsubscribe request for mailing list %d\n", result.MailingListID)
+		default:
+			_, err = fmt.Fprintf(w, "Unsubscribed from mailing list %d\n", result.MailingListID)
+		}
+		return err
+	})
+}
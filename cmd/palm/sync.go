@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"palm/src/services"
+)
+
+var syncFlags struct {
+	accountID uint
+	watch     bool
+	interval  time.Duration
+}
+
+var syncCommand = command{
+	usage: "[--account <id>] [--watch [--interval d]]",
+	flags: func(fs *flag.FlagSet) {
+		fs.UintVar(&syncFlags.accountID, "account", 0, "sync only this account")
+		fs.BoolVar(&syncFlags.watch, "watch", false, "keep Local Maildir accounts in sync until interrupted")
+		fs.DurationVar(&syncFlags.interval, "interval", services.DefaultMaildirPollInterval, "how often --watch checks maildirs")
+	},
+	run: runSync,
+}
+
+func runSync(ctx context.Context, a *cli, fs *flag.FlagSet, args []string) error {
+	if len(args) != 0 {
+		return usageError(fs, "unexpected arguments")
+	}
+	if syncFlags.watch && syncFlags.accountID != 0 {
+		return usageError(fs, "--watch syncs every maildir account and cannot be combined with --account")
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+	if syncFlags.watch {
+		return a.watchMaildirs(ctx)
+	}
+
+	var results []*services.SyncResult
+	var syncErr error
+	if syncFlags.accountID != 0 {
+		result, err := a.syncService.SyncAccount(ctx, syncFlags.accountID)
+		if result != nil {
+			results = append(results, result)
+		}
+		syncErr = err
+	} else {
+		results, syncErr = a.syncService.SyncAll(ctx)
+	}
+
+	if err := a.printSyncResults(results); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+func (a *cli) printSyncResults(results []*services.SyncResult) error {
+	return a.output(results, func(w io.Writer) error {
+		for _, r := range results {
+			fmt.Fprintf(w, "%s: fetched %d, created %d, failed %d\n", r.Email, r.Fetched, r.Created, r.Failed)
+		}
+		return nil
+	})
+}
+
+// watchMaildirs syncs the Local Maildir accounts whenever their directory
+// changes, printing each round of results, until interrupted
+func (a *cli) watchMaildirs(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Verify senders as messages arrive rather than only on exit
+	go a.senderVerifier.Run(ctx)
+	watcher := services.NewMaildirWatcher(a.accountRepo, a.syncService, syncFlags.interval)
+	ticker := time.NewTicker(syncFlags.interval)
+	defer ticker.Stop()
+	for {
+		results, err := watcher.Poll(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(results) > 0 {
+			if err := a.printSyncResults(results); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Event names and payloads pushed by the Go backend through runtime.EventsEmit.
+// Keep in sync with src/events/bus.go and src/events/payloads.go.
+
+export const MessageCreated = "message:created";
+export const MessageUpdated = "message:updated";
+export const MessageDeleted = "message:deleted";
+export const SyncStarted = "sync:started";
+export const SyncProgress = "sync:progress";
+export const SyncFinished = "sync:finished";
+export const SyncFailed = "sync:failed";
+export const AccountAuthExpired = "account:auth-expired";
+export const ImportProgress = "import:progress";
+export const ImportFinished = "import:finished";
+
+// Message events are coalesced by the backend into one batch per topic.
+export interface MessageBatchPayload {
+  count: number;
+  messageIds: number[];
+  accountIds: number[];
+  truncated: boolean;
+}
+
+export interface SyncPayload {
+  accountId: number;
+  email: string;
+  fetched: number;
+  created: number;
+  failed: number;
+  error?: string;
+}
+
+export interface AccountPayload {
+  accountId: number;
+  email: string;
+  reason?: string;
+}
+
+export interface ImportPayload {
+  accountId: number;
+  source: string;
+  processed: number;
+  imported: number;
+  duplicates: number;
+  failed: number;
+  bytesRead: number;
+  totalBytes?: number;
+  error?: string;
+}
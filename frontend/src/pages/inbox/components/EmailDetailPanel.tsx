@@ -2,7 +2,13 @@ import React, { useState, useEffect } from "react";
 import BackgroundOverlay from "../../../components/BackgroundOverlay";
 import { hexToRgba, cream, borderCream } from "../../../styles/themes";
 import { controllers } from "../../../../wailsjs/go/models";
-import { GetEmail } from "../../../../wailsjs/go/main/App";
+import {
+  AllowRemoteContent,
+  GetEmail,
+  LoadRemoteContent,
+  RespondToInvitation,
+} from "../../../../wailsjs/go/main/App";
+import { BrowserOpenURL } from "../../../../wailsjs/runtime/runtime";
 import {
   ReplyIcon,
   ReplyAllIcon,
@@ -54,6 +60,36 @@ const EmailDetailPanel: React.FC<EmailDetailPanelProps> = ({
     fetchEmailDetail();
   }, [selectedEmailId]);
 
+  const loadImages = async (always: boolean) => {
+    if (!email) return;
+    try {
+      if (always) {
+        await AllowRemoteContent(email.senderEmail);
+      }
+      setEmail(await LoadRemoteContent(email.id));
+    } catch (err) {
+      console.error("Error loading remote images:", err);
+    }
+  };
+
+  const respond = async (eventId: number, response: string) => {
+    if (!email) return;
+    try {
+      await RespondToInvitation(eventId, response);
+      setEmail(await GetEmail(email.id));
+    } catch (err) {
+      console.error("Error responding to invitation:", err);
+    }
+  };
+
+  // Links in the body open in the system browser instead of replacing the app
+  const handleBodyClick = (event: React.MouseEvent<HTMLDivElement>) => {
+    const href = (event.target as HTMLElement).closest("a")?.getAttribute("href");
+    if (!href || href.startsWith("#")) return;
+    event.preventDefault();
+    BrowserOpenURL(href);
+  };
+
   const formatDate = (dateString: string | undefined): string => {
     if (!dateString) return "";
     return new Date(dateString).toLocaleString(undefined, {
@@ -164,6 +200,22 @@ const EmailDetailPanel: React.FC<EmailDetailPanelProps> = ({
                         title={email.senderEmail}
                       >
                         &lt;{email.senderEmail}&gt;
+                        {email.authentication.senderStatus === "verified" && (
+                          <span
+                            className="ml-1 text-green-700"
+                            title={`Signed by ${email.authentication.dkimDomain || "the sender's domain"}`}
+                          >
+                            ✓ Verified
+                          </span>
+                        )}
+                        {email.authentication.senderStatus === "failed" && (
+                          <span
+                            className="ml-1 text-red-700"
+                            title="The sender's domain does not vouch for this message"
+                          >
+                            Not verified
+                          </span>
+                        )}
                       </div>
                     </div>
                   </div>
@@ -173,6 +225,88 @@ const EmailDetailPanel: React.FC<EmailDetailPanelProps> = ({
                   >
                     {formatRecipients(email)}
                   </div>
+                  {(email.openpgp.encrypted || email.openpgp.signature) && (
+                    <div className="text-xs mb-2">
+                      {email.openpgp.decrypted && (
+                        <span className="mr-2 text-green-700">🔒 Encrypted</span>
+                      )}
+                      {email.openpgp.encrypted && !email.openpgp.decrypted && (
+                        <span
+                          className="mr-2 text-red-700"
+                          title={email.openpgp.decryptError}
+                        >
+                          Could not decrypt this message
+                        </span>
+                      )}
+                      {email.openpgp.signature === "good" && (
+                        <span
+                          className={
+                            email.openpgp.signerMatches
+                              ? "text-green-700"
+                              : "text-yellow-700"
+                          }
+                          title={`Key ${email.openpgp.signerKeyId}`}
+                        >
+                          {email.openpgp.signerMatches
+                            ? `✓ Signed by ${email.openpgp.signerUserId}`
+                            : `Signed by ${email.openpgp.signerUserId}, not the sender's key`}
+                        </span>
+                      )}
+                      {email.openpgp.signature === "bad" && (
+                        <span className="text-red-700">
+                          Invalid signature: the message was altered
+                        </span>
+                      )}
+                      {email.openpgp.signature === "unknown-key" && (
+                        <span title={`Key ${email.openpgp.signerKeyId}`}>
+                          Signed with a key not in your keyring
+                        </span>
+                      )}
+                    </div>
+                  )}
+                  {(email.smime.encrypted || email.smime.signature) && (
+                    <div className="text-xs mb-2">
+                      {email.smime.decrypted && (
+                        <span className="mr-2 text-green-700">🔒 Encrypted</span>
+                      )}
+                      {email.smime.encrypted && !email.smime.decrypted && (
+                        <span
+                          className="mr-2 text-red-700"
+                          title={email.smime.decryptError}
+                        >
+                          Could not decrypt this message
+                        </span>
+                      )}
+                      {email.smime.signature === "good" && (
+                        <span
+                          className={
+                            email.smime.trusted && email.smime.signerMatches
+                              ? "text-green-700"
+                              : "text-yellow-700"
+                          }
+                          title={
+                            email.smime.trusted
+                              ? `Issued by ${email.smime.signerIssuer}, valid until ${email.smime.signerExpires}`
+                              : email.smime.trustError
+                          }
+                        >
+                          {email.smime.trusted
+                            ? `✓ Signed by ${email.smime.signerSubject}`
+                            : `Signed by ${email.smime.signerSubject}, certificate not trusted`}
+                          {!email.smime.signerMatches &&
+                            ", not the sender's certificate"}
+                        </span>
+                      )}
+                      {email.smime.signature === "bad" && (
+                        <span className="text-red-700">
+                          Invalid signature: the message was altered
+                        </span>
+                      )}
+                      {email.smime.signature === "unknown-key" && (
+                        <span>Signed without the signer's certificate</span>
+                      )}
+                    </div>
+                  )}
                 </div>
 
                 <div className="flex flex-col items-end flex-shrink-0 ml-4">
@@ -202,8 +336,96 @@ const EmailDetailPanel: React.FC<EmailDetailPanelProps> = ({
             </div>
 
             <div className="flex-grow overflow-y-auto p-4 md:p-6">
+              {(email.risk.level === "medium" ||
+                email.risk.level === "high") && (
+                <div
+                  className={`mb-4 px-3 py-2 text-sm rounded-lg text-left ${
+                    email.risk.level === "high"
+                      ? "bg-red-100 text-red-900"
+                      : "bg-orange-100 text-orange-900"
+                  }`}
+                >
+                  <p className="font-medium">
+                    {email.risk.level === "high"
+                      ? "This message looks like phishing."
+                      : "Be careful with this message."}{" "}
+                    Don't open its links or attachments unless you trust it.
+                  </p>
+                  <ul className="mt-1 list-disc list-inside">
+                    {email.risk.reasons.map(
+                      (reason: { detail: string }, index: number) => (
+                        <li key={index}>{reason.detail}</li>
+                      ),
+                    )}
+                  </ul>
+                </div>
+              )}
+              {email.remoteContent.blocked > 0 && (
+                <div className="mb-4 px-3 py-2 bg-gray-100 text-sm rounded-lg flex flex-wrap items-center gap-2 text-left">
+                  <span className="flex-grow">
+                    Remote images were blocked to protect your privacy.
+                    {email.remoteContent.trackingPixels > 0 &&
+                      ` ${email.remoteContent.trackingPixels} tracker(s) removed.`}
+                  </span>
+                  <button
+                    className="px-2 py-1 hover:text-orange-500 cursor-pointer"
+                    onClick={() => loadImages(false)}
+                  >
+                    Load images
+                  </button>
+                  <button
+                    className="px-2 py-1 hover:text-orange-500 cursor-pointer"
+                    onClick={() => loadImages(true)}
+                  >
+                    Always load from {email.senderEmail}
+                  </button>
+                </div>
+              )}
+              {email.events?.map((event: controllers.CalendarEventResponse) => (
+                <div
+                  key={event.id}
+                  className="mb-4 px-3 py-2 bg-gray-100 text-sm rounded-lg text-left"
+                >
+                  <p className="font-medium">
+                    {event.status === "CANCELLED" || event.method === "CANCEL"
+                      ? `Cancelled: ${event.summary}`
+                      : event.summary}
+                  </p>
+                  <p>{event.when}</p>
+                  {event.recurrence && <p>{event.recurrence}</p>}
+                  {event.location && <p>{event.location}</p>}
+                  {event.organizer.email && (
+                    <p className="text-xs">
+                      Organized by{" "}
+                      {event.organizer.name || event.organizer.email}
+                    </p>
+                  )}
+                  {event.canRespond && (
+                    <div className="mt-2 flex flex-wrap items-center gap-2">
+                      {[
+                        ["ACCEPTED", "Accept"],
+                        ["TENTATIVE", "Maybe"],
+                        ["DECLINED", "Decline"],
+                      ].map(([response, label]) => (
+                        <button
+                          key={response}
+                          className={`px-2 py-1 cursor-pointer hover:text-orange-500 ${
+                            event.response === response
+                              ? "font-medium text-orange-500"
+                              : ""
+                          }`}
+                          onClick={() => respond(event.id, response)}
+                        >
+                          {label}
+                        </button>
+                      ))}
+                    </div>
+                  )}
+                </div>
+              ))}
               <div
                 className="overflow-x-auto text-left"
+                onClick={handleBodyClick}
                 dangerouslySetInnerHTML={{
                   __html: email.body || "<p>No content</p>",
                 }}
@@ -95,8 +95,8 @@ const EmailItem: React.FC<EmailItemProps> = ({
             {email.subject}
           </div>
           <div className="text-sm truncate text-left min-w-0">
-            {email.body.replace(/<[^>]*>/g, "").substring(0, 100)}
-            {email.body.length > 100 ? "..." : ""}
+            {email.text.substring(0, 100)}
+            {email.text.length > 100 ? "..." : ""}
           </div>
           {email.attachments && email.attachments.length > 0 && (
             <div className="text-left text-xs mt-1">
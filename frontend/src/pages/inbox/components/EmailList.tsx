@@ -1,6 +1,17 @@
 import React, { useState, useEffect, useRef, useCallback } from "react";
 import { controllers } from "../../../../wailsjs/go/models";
-import { ListEmails } from "../../../../wailsjs/go/main/App";
+import { ImportEMLFiles, ListEmails } from "../../../../wailsjs/go/main/App";
+import {
+  EventsOn,
+  OnFileDrop,
+  OnFileDropOff,
+} from "../../../../wailsjs/runtime/runtime";
+import {
+  MessageBatchPayload,
+  MessageCreated,
+  MessageDeleted,
+  MessageUpdated,
+} from "../../../events";
 import EmailItem from "./EmailItem";
 
 const PAGE_SIZE = 20;
@@ -22,6 +33,7 @@ const EmailList: React.FC<EmailListProps> = ({
   const [hasMore, setHasMore] = useState(true);
   const [page, setPage] = useState(1);
   const [error, setError] = useState<string | null>(null);
+  const [reloadCount, setReloadCount] = useState(0);
 
   const observer = useRef<IntersectionObserver | null>(null);
   const lastEmailElementRef = useCallback(
@@ -47,6 +59,37 @@ const EmailList: React.FC<EmailListProps> = ({
     setHasMore(true);
   }, [searchQuery]);
 
+  // Reload from the first page when the backend reports changes to this account
+  useEffect(() => {
+    const onMessagesChanged = (batch: MessageBatchPayload) => {
+      if (!batch.accountIds.includes(ACCOUNT_ID)) return;
+      setEmails([]);
+      setPage(1);
+      setHasMore(true);
+      setReloadCount((count) => count + 1);
+    };
+
+    const unsubscribers = [MessageCreated, MessageUpdated, MessageDeleted].map(
+      (name) => EventsOn(name, onMessagesChanged)
+    );
+    return () => unsubscribers.forEach((unsubscribe) => unsubscribe());
+  }, []);
+
+  // Import .eml files dropped on the window; the list reloads through the
+  // message:created events
+  useEffect(() => {
+    OnFileDrop((_x, _y, paths) => {
+      const emlPaths = paths.filter((path) =>
+        path.toLowerCase().endsWith(".eml")
+      );
+      if (emlPaths.length === 0) return;
+      ImportEMLFiles(ACCOUNT_ID, emlPaths).catch((err) =>
+        console.error("Error importing .eml files:", err)
+      );
+    }, false);
+    return () => OnFileDropOff();
+  }, []);
+
   // Load emails when page changes
   useEffect(() => {
     const fetchEmails = async () => {
@@ -54,7 +97,12 @@ const EmailList: React.FC<EmailListProps> = ({
       setError(null);
 
       try {
-        const response = await ListEmails(ACCOUNT_ID, page, PAGE_SIZE);
+        const response = await ListEmails(
+          ACCOUNT_ID,
+          page,
+          PAGE_SIZE,
+          controllers.ListEmailsOptions.createFrom({})
+        );
 
         setEmails((prevEmails) => {
           // Add only unique emails
@@ -77,7 +125,7 @@ const EmailList: React.FC<EmailListProps> = ({
     };
 
     fetchEmails();
-  }, [page, searchQuery]);
+  }, [page, searchQuery, reloadCount]);
 
   if (error) {
     return <div className="text-red-500 p-4">{error}</div>;
@@ -1,9 +1,154 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 import {controllers} from '../models';
+import {services} from '../models';
+
+export function AddMaildirAccount(arg1:string):Promise<controllers.AccountResponse>;
+
+export function AllowRemoteContent(arg1:string):Promise<void>;
+
+export function AutocompleteRecipients(arg1:string):Promise<Array<controllers.RecipientSuggestionResponse>>;
+
+export function CancelFollowUp(arg1:number):Promise<void>;
+
+export function CancelScheduledEmail(arg1:number):Promise<controllers.SendEmailRequest>;
+
+export function CreateRule(arg1:controllers.RuleRequest):Promise<controllers.RuleResponse>;
+
+export function DeleteCategoryOverride(arg1:number):Promise<number>;
+
+export function DeleteContact(arg1:number):Promise<void>;
+
+export function DeletePGPKey(arg1:string):Promise<void>;
+
+export function DeleteRule(arg1:number):Promise<void>;
+
+export function DeleteSMIMECertificate(arg1:string):Promise<void>;
+
+export function DeleteSieveScript(arg1:number):Promise<void>;
+
+export function DisallowRemoteContent(arg1:string):Promise<void>;
+
+export function DryRunRule(arg1:controllers.RuleRequest,arg2:number):Promise<controllers.RuleDryRunResponse>;
+
+export function ExportEmail(arg1:number):Promise<controllers.ExportResponse>;
+
+export function ExportMaildir(arg1:number,arg2:string):Promise<controllers.ExportResponse>;
+
+export function ExportMbox(arg1:number,arg2:string,arg3:string):Promise<controllers.ExportResponse>;
+
+export function ExportPGPKey(arg1:string,arg2:boolean):Promise<string>;
+
+export function ExportSMIMECertificate(arg1:string):Promise<string>;
+
+export function ExportVCards(arg1:Array<number>,arg2:string):Promise<string>;
+
+export function FindDuplicateContacts():Promise<Array<any>>;
+
+export function FollowUpEmail(arg1:number,arg2:number):Promise<controllers.FollowUpResponse>;
 
 export function GetEmail(arg1:number):Promise<controllers.EmailResponse>;
 
+export function GetEmailHeaders(arg1:number):Promise<controllers.EmailHeadersResponse>;
+
+export function GetEmailSource(arg1:number):Promise<controllers.EmailSourceResponse>;
+
+export function GetSnoozePresets():Promise<Array<controllers.SnoozePresetResponse>>;
+
+export function GetSpamStatus():Promise<controllers.SpamStatusResponse>;
+
 export function Greet(arg1:string):Promise<string>;
 
-export function ListEmails(arg1:number,arg2:number,arg3:number):Promise<controllers.ListEmailsResponse>;
+export function ImportEML(arg1:number):Promise<controllers.ImportResponse>;
+
+export function ImportEMLFile(arg1:number,arg2:string):Promise<controllers.EmailResponse>;
+
+export function ImportEMLFiles(arg1:number,arg2:Array<string>):Promise<controllers.ImportResponse>;
+
+export function ImportMaildir(arg1:number):Promise<controllers.ImportResponse>;
+
+export function ImportMbox(arg1:number,arg2:string):Promise<controllers.ImportResponse>;
+
+export function ImportPGPKeys(arg1:string):Promise<Array<controllers.PGPKeyResponse>>;
+
+export function ImportSMIMECertificates():Promise<Array<controllers.SMIMECertificateResponse>>;
+
+export function ImportSMIMEIdentity(arg1:string):Promise<controllers.SMIMECertificateResponse>;
+
+export function ImportSieveScript(arg1:controllers.SieveScriptRequest):Promise<controllers.SieveScriptResponse>;
+
+export function ImportVCards(arg1:string):Promise<controllers.VCardImportResponse>;
+
+export function ListCategoryOverrides(arg1:number):Promise<Array<controllers.CategoryOverrideResponse>>;
+
+export function ListContacts():Promise<Array<controllers.ContactResponse>>;
+
+export function ListEmails(arg1:number,arg2:number,arg3:number,arg4:controllers.ListEmailsOptions):Promise<controllers.ListEmailsResponse>;
+
+export function ListFollowUps(arg1:number):Promise<Array<controllers.FollowUpResponse>>;
+
+export function ListPGPKeys():Promise<Array<controllers.PGPKeyResponse>>;
+
+export function ListRemoteContentSenders():Promise<Array<string>>;
+
+export function ListRules():Promise<Array<controllers.RuleResponse>>;
+
+export function ListSMIMECertificates():Promise<Array<controllers.SMIMECertificateResponse>>;
+
+export function ListScheduledEmails(arg1:number):Promise<Array<controllers.ScheduledEmailResponse>>;
+
+export function ListSieveScripts():Promise<Array<controllers.SieveScriptResponse>>;
+
+export function ListSnoozedEmails(arg1:number):Promise<Array<controllers.SnoozeResponse>>;
+
+export function ListSubscriptions(arg1:number):Promise<Array<controllers.SubscriptionResponse>>;
+
+export function ListUnifiedEmails(arg1:Array<number>,arg2:number,arg3:number):Promise<controllers.UnifiedInboxResponse>;
+
+export function LoadRemoteContent(arg1:number):Promise<controllers.EmailResponse>;
+
+export function MarkNotSpam(arg1:number):Promise<void>;
+
+export function MarkSpam(arg1:number):Promise<void>;
+
+export function MergeContacts(arg1:number,arg2:Array<number>):Promise<controllers.ContactResponse>;
+
+export function ReorderRules(arg1:Array<number>):Promise<void>;
+
+export function RespondToInvitation(arg1:number,arg2:string):Promise<controllers.SendEmailResponse>;
+
+export function RetryScheduledEmail(arg1:number):Promise<controllers.ScheduledEmailResponse>;
+
+export function ScheduleEmail(arg1:controllers.SendEmailRequest,arg2:string,arg3:number):Promise<controllers.ScheduledEmailResponse>;
+
+export function SendEmail(arg1:controllers.SendEmailRequest):Promise<controllers.SendEmailResponse>;
+
+export function SetEmailCategory(arg1:number,arg2:string):Promise<number>;
+
+export function SetSMIMETrustAnchor(arg1:string,arg2:boolean):Promise<void>;
+
+export function SetSieveScriptActive(arg1:number,arg2:boolean):Promise<void>;
+
+export function SetSpamThreshold(arg1:number):Promise<void>;
+
+export function SetTrustedAuthServIDs(arg1:number,arg2:Array<string>):Promise<controllers.AccountResponse>;
+
+export function SnoozeEmail(arg1:number,arg2:string):Promise<controllers.SnoozeResponse>;
+
+export function SyncAccount(arg1:number):Promise<services.SyncResult>;
+
+export function TestSieveScript(arg1:string,arg2:number):Promise<Array<controllers.SieveActionResponse>>;
+
+export function UnlockPGPKey(arg1:string,arg2:string):Promise<void>;
+
+export function UnlockSMIMEIdentity(arg1:string,arg2:string):Promise<void>;
+
+export function UnsnoozeEmail(arg1:number):Promise<void>;
+
+export function Unsubscribe(arg1:number):Promise<controllers.UnsubscribeResponse>;
+
+export function UpdateContact(arg1:number,arg2:string,arg3:Array<string>):Promise<controllers.ContactResponse>;
+
+export function UpdateRule(arg1:number,arg2:controllers.RuleRequest):Promise<controllers.RuleResponse>;
+
+export function UploadSieveScript(arg1:number,arg2:controllers.ManageSieveRequest):Promise<void>;
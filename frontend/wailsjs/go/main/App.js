@@ -2,14 +2,302 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AddMaildirAccount(arg1) {
+  return window['go']['main']['App']['AddMaildirAccount'](arg1);
+}
+
+export function AllowRemoteContent(arg1) {
+  return window['go']['main']['App']['AllowRemoteContent'](arg1);
+}
+
+export function AutocompleteRecipients(arg1) {
+  return window['go']['main']['App']['AutocompleteRecipients'](arg1);
+}
+
+export function CancelFollowUp(arg1) {
+  return window['go']['main']['App']['CancelFollowUp'](arg1);
+}
+
+export function CancelScheduledEmail(arg1) {
+  return window['go']['main']['App']['CancelScheduledEmail'](arg1);
+}
+
+export function CreateRule(arg1) {
+  return window['go']['main']['App']['CreateRule'](arg1);
+}
+
+export function DeleteCategoryOverride(arg1) {
+  return window['go']['main']['App']['DeleteCategoryOverride'](arg1);
+}
+
+export function DeleteContact(arg1) {
+  return window['go']['main']['App']['DeleteContact'](arg1);
+}
+
+export function DeletePGPKey(arg1) {
+  return window['go']['main']['App']['DeletePGPKey'](arg1);
+}
+
+export function DeleteRule(arg1) {
+  return window['go']['main']['App']['DeleteRule'](arg1);
+}
+
+export function DeleteSMIMECertificate(arg1) {
+  return window['go']['main']['App']['DeleteSMIMECertificate'](arg1);
+}
+
+export function DeleteSieveScript(arg1) {
+  return window['go']['main']['App']['DeleteSieveScript'](arg1);
+}
+
+export function DisallowRemoteContent(arg1) {
+  return window['go']['main']['App']['DisallowRemoteContent'](arg1);
+}
+
+export function DryRunRule(arg1, arg2) {
+  return window['go']['main']['App']['DryRunRule'](arg1, arg2);
+}
+
+export function ExportEmail(arg1) {
+  return window['go']['main']['App']['ExportEmail'](arg1);
+}
+
+export function ExportMaildir(arg1, arg2) {
+  return window['go']['main']['App']['ExportMaildir'](arg1, arg2);
+}
+
+export function ExportMbox(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ExportMbox'](arg1, arg2, arg3);
+}
+
+export function ExportPGPKey(arg1, arg2) {
+  return window['go']['main']['App']['ExportPGPKey'](arg1, arg2);
+}
+
+export function ExportSMIMECertificate(arg1) {
+  return window['go']['main']['App']['ExportSMIMECertificate'](arg1);
+}
+
+export function ExportVCards(arg1, arg2) {
+  return window['go']['main']['App']['ExportVCards'](arg1, arg2);
+}
+
+export function FindDuplicateContacts() {
+  return window['go']['main']['App']['FindDuplicateContacts']();
+}
+
+export function FollowUpEmail(arg1, arg2) {
+  return window['go']['main']['App']['FollowUpEmail'](arg1, arg2);
+}
+
 export function GetEmail(arg1) {
   return window['go']['main']['App']['GetEmail'](arg1);
 }
 
+export function GetEmailHeaders(arg1) {
+  return window['go']['main']['App']['GetEmailHeaders'](arg1);
+}
+
+export function GetEmailSource(arg1) {
+  return window['go']['main']['App']['GetEmailSource'](arg1);
+}
+
+export function GetSnoozePresets() {
+  return window['go']['main']['App']['GetSnoozePresets']();
+}
+
+export function GetSpamStatus() {
+  return window['go']['main']['App']['GetSpamStatus']();
+}
+
 export function Greet(arg1) {
   return window['go']['main']['App']['Greet'](arg1);
 }
 
-export function ListEmails(arg1, arg2, arg3) {
-  return window['go']['main']['App']['ListEmails'](arg1, arg2, arg3);
+export function ImportEML(arg1) {
+  return window['go']['main']['App']['ImportEML'](arg1);
+}
+
+export function ImportEMLFile(arg1, arg2) {
+  return window['go']['main']['App']['ImportEMLFile'](arg1, arg2);
+}
+
+export function ImportEMLFiles(arg1, arg2) {
+  return window['go']['main']['App']['ImportEMLFiles'](arg1, arg2);
+}
+
+export function ImportMaildir(arg1) {
+  return window['go']['main']['App']['ImportMaildir'](arg1);
+}
+
+export function ImportMbox(arg1, arg2) {
+  return window['go']['main']['App']['ImportMbox'](arg1, arg2);
+}
+
+export function ImportPGPKeys(arg1) {
+  return window['go']['main']['App']['ImportPGPKeys'](arg1);
+}
+
+export function ImportSMIMECertificates() {
+  return window['go']['main']['App']['ImportSMIMECertificates']();
+}
+
+export function ImportSMIMEIdentity(arg1) {
+  return window['go']['main']['App']['ImportSMIMEIdentity'](arg1);
+}
+
+export function ImportSieveScript(arg1) {
+  return window['go']['main']['App']['ImportSieveScript'](arg1);
+}
+
+export function ImportVCards(arg1) {
+  return window['go']['main']['App']['ImportVCards'](arg1);
+}
+
+export function ListCategoryOverrides(arg1) {
+  return window['go']['main']['App']['ListCategoryOverrides'](arg1);
+}
+
+export function ListContacts() {
+  return window['go']['main']['App']['ListContacts']();
+}
+
+export function ListEmails(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['ListEmails'](arg1, arg2, arg3, arg4);
+}
+
+export function ListFollowUps(arg1) {
+  return window['go']['main']['App']['ListFollowUps'](arg1);
+}
+
+export function ListPGPKeys() {
+  return window['go']['main']['App']['ListPGPKeys']();
+}
+
+export function ListRemoteContentSenders() {
+  return window['go']['main']['App']['ListRemoteContentSenders']();
+}
+
+export function ListRules() {
+  return window['go']['main']['App']['ListRules']();
+}
+
+export function ListSMIMECertificates() {
+  return window['go']['main']['App']['ListSMIMECertificates']();
+}
+
+export function ListScheduledEmails(arg1) {
+  return window['go']['main']['App']['ListScheduledEmails'](arg1);
+}
+
+export function ListSieveScripts() {
+  return window['go']['main']['App']['ListSieveScripts']();
+}
+
+export function ListSnoozedEmails(arg1) {
+  return window['go']['main']['App']['ListSnoozedEmails'](arg1);
+}
+
+export function ListSubscriptions(arg1) {
+  return window['go']['main']['App']['ListSubscriptions'](arg1);
+}
+
+export function ListUnifiedEmails(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ListUnifiedEmails'](arg1, arg2, arg3);
+}
+
+export function LoadRemoteContent(arg1) {
+  return window['go']['main']['App']['LoadRemoteContent'](arg1);
+}
+
+export function MarkNotSpam(arg1) {
+  return window['go']['main']['App']['MarkNotSpam'](arg1);
+}
+
+export function MarkSpam(arg1) {
+  return window['go']['main']['App']['MarkSpam'](arg1);
+}
+
+export function MergeContacts(arg1, arg2) {
+  return window['go']['main']['App']['MergeContacts'](arg1, arg2);
+}
+
+export function ReorderRules(arg1) {
+  return window['go']['main']['App']['ReorderRules'](arg1);
+}
+
+export function RespondToInvitation(arg1, arg2) {
+  return window['go']['main']['App']['RespondToInvitation'](arg1, arg2);
+}
+
+export function RetryScheduledEmail(arg1) {
+  return window['go']['main']['App']['RetryScheduledEmail'](arg1);
+}
+
+export function ScheduleEmail(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ScheduleEmail'](arg1, arg2, arg3);
+}
+
+export function SendEmail(arg1) {
+  return window['go']['main']['App']['SendEmail'](arg1);
+}
+
+export function SetEmailCategory(arg1, arg2) {
+  return window['go']['main']['App']['SetEmailCategory'](arg1, arg2);
+}
+
+export function SetSMIMETrustAnchor(arg1, arg2) {
+  return window['go']['main']['App']['SetSMIMETrustAnchor'](arg1, arg2);
+}
+
+export function SetSieveScriptActive(arg1, arg2) {
+  return window['go']['main']['App']['SetSieveScriptActive'](arg1, arg2);
+}
+
+export function SetSpamThreshold(arg1) {
+  return window['go']['main']['App']['SetSpamThreshold'](arg1);
+}
+
+export function SetTrustedAuthServIDs(arg1, arg2) {
+  return window['go']['main']['App']['SetTrustedAuthServIDs'](arg1, arg2);
+}
+
+export function SnoozeEmail(arg1, arg2) {
+  return window['go']['main']['App']['SnoozeEmail'](arg1, arg2);
+}
+
+export function SyncAccount(arg1) {
+  return window['go']['main']['App']['SyncAccount'](arg1);
+}
+
+export function TestSieveScript(arg1, arg2) {
+  return window['go']['main']['App']['TestSieveScript'](arg1, arg2);
+}
+
+export function UnlockPGPKey(arg1, arg2) {
+  return window['go']['main']['App']['UnlockPGPKey'](arg1, arg2);
+}
+
+export function UnlockSMIMEIdentity(arg1, arg2) {
+  return window['go']['main']['App']['UnlockSMIMEIdentity'](arg1, arg2);
+}
+
+export function UnsnoozeEmail(arg1) {
+  return window['go']['main']['App']['UnsnoozeEmail'](arg1);
+}
+
+export function Unsubscribe(arg1) {
+  return window['go']['main']['App']['Unsubscribe'](arg1);
+}
+
+export function UpdateContact(arg1, arg2, arg3) {
+  return window['go']['main']['App']['UpdateContact'](arg1, arg2, arg3);
+}
+
+export function UpdateRule(arg1, arg2) {
+  return window['go']['main']['App']['UpdateRule'](arg1, arg2);
+}
+
+export function UploadSieveScript(arg1, arg2) {
+  return window['go']['main']['App']['UploadSieveScript'](arg1, arg2);
 }
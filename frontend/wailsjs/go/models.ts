@@ -1,5 +1,43 @@
 export namespace controllers {
 	
+	export class AccountResponse {
+	    id: number;
+	    email: string;
+	    accountType: string;
+	    maildirPath?: string;
+	    trustedAuthServIds?: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new AccountResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.email = source["email"];
+	        this.accountType = source["accountType"];
+	        this.maildirPath = source["maildirPath"];
+	        this.trustedAuthServIds = source["trustedAuthServIds"];
+	    }
+	}
+	export class AccountUnreadResponse {
+	    id: number;
+	    email: string;
+	    accountType: string;
+	    unreadCount: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AccountUnreadResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.email = source["email"];
+	        this.accountType = source["accountType"];
+	        this.unreadCount = source["unreadCount"];
+	    }
+	}
 	export class AttachmentResponse {
 	    id: number;
 	    filename: string;
@@ -18,6 +56,326 @@ export namespace controllers {
 	        this.mimeType = source["mimeType"];
 	    }
 	}
+	export class AuthenticationResponse {
+	    senderStatus: string;
+	    dkim: string;
+	    dkimDomain: string;
+	    spf: string;
+	    dmarc: string;
+	    arc: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new AuthenticationResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.senderStatus = source["senderStatus"];
+	        this.dkim = source["dkim"];
+	        this.dkimDomain = source["dkimDomain"];
+	        this.spf = source["spf"];
+	        this.dmarc = source["dmarc"];
+	        this.arc = source["arc"];
+	    }
+	}
+	export class CalendarAttendeeResponse {
+	    email: string;
+	    name: string;
+	    role?: string;
+	    status?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new CalendarAttendeeResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.email = source["email"];
+	        this.name = source["name"];
+	        this.role = source["role"];
+	        this.status = source["status"];
+	    }
+	}
+	export class CalendarEventResponse {
+	    id: number;
+	    method: string;
+	    status: string;
+	    summary: string;
+	    location: string;
+	    description: string;
+	    start: string;
+	    end: string;
+	    allDay: boolean;
+	    when: string;
+	    recurrence?: string;
+	    organizer: CalendarAttendeeResponse;
+	    attendees: CalendarAttendeeResponse[];
+	    response: string;
+	    canRespond: boolean;
+	    respondedAt?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new CalendarEventResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.method = source["method"];
+	        this.status = source["status"];
+	        this.summary = source["summary"];
+	        this.location = source["location"];
+	        this.description = source["description"];
+	        this.start = source["start"];
+	        this.end = source["end"];
+	        this.allDay = source["allDay"];
+	        this.when = source["when"];
+	        this.recurrence = source["recurrence"];
+	        this.organizer = this.convertValues(source["organizer"], CalendarAttendeeResponse);
+	        this.attendees = this.convertValues(source["attendees"], CalendarAttendeeResponse);
+	        this.response = source["response"];
+	        this.canRespond = source["canRespond"];
+	        this.respondedAt = source["respondedAt"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class CategoryOverrideResponse {
+	    id: number;
+	    accountId: number;
+	    senderEmail: string;
+	    category: string;
+	    updatedAt: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new CategoryOverrideResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.accountId = source["accountId"];
+	        this.senderEmail = source["senderEmail"];
+	        this.category = source["category"];
+	        this.updatedAt = source["updatedAt"];
+	    }
+	}
+	export class ContactResponse {
+	    id: number;
+	    displayName: string;
+	    displayNameLocked: boolean;
+	    emails: string[];
+	    phones: string[];
+	    organization?: string;
+	    note?: string;
+	    hasPhoto: boolean;
+	    messageCount: number;
+	    sentCount: number;
+	    lastInteraction?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ContactResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.displayName = source["displayName"];
+	        this.displayNameLocked = source["displayNameLocked"];
+	        this.emails = source["emails"];
+	        this.phones = source["phones"];
+	        this.organization = source["organization"];
+	        this.note = source["note"];
+	        this.hasPhoto = source["hasPhoto"];
+	        this.messageCount = source["messageCount"];
+	        this.sentCount = source["sentCount"];
+	        this.lastInteraction = source["lastInteraction"];
+	    }
+	}
+	export class HeaderResponse {
+	    name: string;
+	    value: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new HeaderResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.value = source["value"];
+	    }
+	}
+	export class EmailHeadersResponse {
+	    headers: HeaderResponse[];
+	    reconstructed: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new EmailHeadersResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.headers = this.convertValues(source["headers"], HeaderResponse);
+	        this.reconstructed = source["reconstructed"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SMIMEResponse {
+	    encrypted: boolean;
+	    decrypted: boolean;
+	    decryptError?: string;
+	    signature: string;
+	    signerSubject?: string;
+	    signerEmails?: string[];
+	    signerIssuer?: string;
+	    signerExpires?: string;
+	    trusted: boolean;
+	    trustError?: string;
+	    signerMatches: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new SMIMEResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.encrypted = source["encrypted"];
+	        this.decrypted = source["decrypted"];
+	        this.decryptError = source["decryptError"];
+	        this.signature = source["signature"];
+	        this.signerSubject = source["signerSubject"];
+	        this.signerEmails = source["signerEmails"];
+	        this.signerIssuer = source["signerIssuer"];
+	        this.signerExpires = source["signerExpires"];
+	        this.trusted = source["trusted"];
+	        this.trustError = source["trustError"];
+	        this.signerMatches = source["signerMatches"];
+	    }
+	}
+	export class OpenPGPResponse {
+	    encrypted: boolean;
+	    decrypted: boolean;
+	    decryptError?: string;
+	    signature: string;
+	    signerKeyId?: string;
+	    signerUserId?: string;
+	    signerMatches: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new OpenPGPResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.encrypted = source["encrypted"];
+	        this.decrypted = source["decrypted"];
+	        this.decryptError = source["decryptError"];
+	        this.signature = source["signature"];
+	        this.signerKeyId = source["signerKeyId"];
+	        this.signerUserId = source["signerUserId"];
+	        this.signerMatches = source["signerMatches"];
+	    }
+	}
+	export class RiskReasonResponse {
+	    code: string;
+	    detail: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RiskReasonResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.code = source["code"];
+	        this.detail = source["detail"];
+	    }
+	}
+	export class RiskResponse {
+	    score: number;
+	    level: string;
+	    reasons: RiskReasonResponse[];
+	
+	    static createFrom(source: any = {}) {
+	        return new RiskResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.score = source["score"];
+	        this.level = source["level"];
+	        this.reasons = this.convertValues(source["reasons"], RiskReasonResponse);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class RemoteContentResponse {
+	    blocked: number;
+	    trackingPixels: number;
+	    trackedLinks: number;
+	    allowed: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new RemoteContentResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.blocked = source["blocked"];
+	        this.trackingPixels = source["trackingPixels"];
+	        this.trackedLinks = source["trackedLinks"];
+	        this.allowed = source["allowed"];
+	    }
+	}
 	export class RecipientResponse {
 	    id: number;
 	    email: string;
@@ -39,15 +397,29 @@ export namespace controllers {
 	export class EmailResponse {
 	    id: number;
 	    accountId: number;
+	    accountEmail?: string;
+	    accountType?: string;
 	    subject: string;
 	    body: string;
+	    text: string;
 	    senderName: string;
 	    senderEmail: string;
 	    receivedAt: string;
 	    isRead: boolean;
+	    isFlagged: boolean;
 	    importance: string;
+	    folder: string;
+	    labels: string[];
+	    mailingListId?: number;
+	    category?: string;
 	    recipients: RecipientResponse[];
 	    attachments?: AttachmentResponse[];
+	    remoteContent: RemoteContentResponse;
+	    risk: RiskResponse;
+	    authentication: AuthenticationResponse;
+	    openpgp: OpenPGPResponse;
+	    smime: SMIMEResponse;
+	    events?: CalendarEventResponse[];
 	
 	    static createFrom(source: any = {}) {
 	        return new EmailResponse(source);
@@ -57,15 +429,29 @@ export namespace controllers {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.id = source["id"];
 	        this.accountId = source["accountId"];
+	        this.accountEmail = source["accountEmail"];
+	        this.accountType = source["accountType"];
 	        this.subject = source["subject"];
 	        this.body = source["body"];
+	        this.text = source["text"];
 	        this.senderName = source["senderName"];
 	        this.senderEmail = source["senderEmail"];
 	        this.receivedAt = source["receivedAt"];
 	        this.isRead = source["isRead"];
+	        this.isFlagged = source["isFlagged"];
 	        this.importance = source["importance"];
+	        this.folder = source["folder"];
+	        this.labels = source["labels"];
+	        this.mailingListId = source["mailingListId"];
+	        this.category = source["category"];
 	        this.recipients = this.convertValues(source["recipients"], RecipientResponse);
 	        this.attachments = this.convertValues(source["attachments"], AttachmentResponse);
+	        this.remoteContent = this.convertValues(source["remoteContent"], RemoteContentResponse);
+	        this.risk = this.convertValues(source["risk"], RiskResponse);
+	        this.authentication = this.convertValues(source["authentication"], AuthenticationResponse);
+	        this.openpgp = this.convertValues(source["openpgp"], OpenPGPResponse);
+	        this.smime = this.convertValues(source["smime"], SMIMEResponse);
+	        this.events = this.convertValues(source["events"], CalendarEventResponse);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -86,6 +472,117 @@ export namespace controllers {
 		    return a;
 		}
 	}
+	export class EmailSourceResponse {
+	    source: string;
+	    size: number;
+	    reconstructed: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new EmailSourceResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.source = source["source"];
+	        this.size = source["size"];
+	        this.reconstructed = source["reconstructed"];
+	    }
+	}
+	export class ExportResponse {
+	    path: string;
+	    count: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ExportResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.count = source["count"];
+	    }
+	}
+	export class FollowUpResponse {
+	    emailId: number;
+	    accountId?: number;
+	    subject?: string;
+	    sentAt?: string;
+	    remindAt: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new FollowUpResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.emailId = source["emailId"];
+	        this.accountId = source["accountId"];
+	        this.subject = source["subject"];
+	        this.sentAt = source["sentAt"];
+	        this.remindAt = source["remindAt"];
+	    }
+	}
+	
+	export class ImportResponse {
+	    accountId: number;
+	    processed: number;
+	    imported: number;
+	    duplicates: number;
+	    failed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ImportResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.accountId = source["accountId"];
+	        this.processed = source["processed"];
+	        this.imported = source["imported"];
+	        this.duplicates = source["duplicates"];
+	        this.failed = source["failed"];
+	    }
+	}
+	export class ListEmailsOptions {
+	    unreadOnly: boolean;
+	    flaggedOnly: boolean;
+	    importance?: string;
+	    hasAttachments?: boolean;
+	    sender?: string;
+	    receivedAfter?: string;
+	    receivedBefore?: string;
+	    drafts?: boolean;
+	    folder?: string;
+	    allFolders?: boolean;
+	    label?: string;
+	    mailingListId?: number;
+	    category?: string;
+	    sortBy?: string;
+	    sortOrder?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ListEmailsOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.unreadOnly = source["unreadOnly"];
+	        this.flaggedOnly = source["flaggedOnly"];
+	        this.importance = source["importance"];
+	        this.hasAttachments = source["hasAttachments"];
+	        this.sender = source["sender"];
+	        this.receivedAfter = source["receivedAfter"];
+	        this.receivedBefore = source["receivedBefore"];
+	        this.drafts = source["drafts"];
+	        this.folder = source["folder"];
+	        this.allFolders = source["allFolders"];
+	        this.label = source["label"];
+	        this.mailingListId = source["mailingListId"];
+	        this.category = source["category"];
+	        this.sortBy = source["sortBy"];
+	        this.sortOrder = source["sortOrder"];
+	    }
+	}
 	export class ListEmailsResponse {
 	    emails: EmailResponse[];
 	    totalCount: number;
@@ -124,6 +621,577 @@ export namespace controllers {
 		    return a;
 		}
 	}
+	export class ManageSieveRequest {
+	    address: string;
+	    username: string;
+	    password: string;
+	    security?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ManageSieveRequest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.address = source["address"];
+	        this.username = source["username"];
+	        this.password = source["password"];
+	        this.security = source["security"];
+	    }
+	}
+	
+	export class PGPKeyResponse {
+	    fingerprint: string;
+	    keyId: string;
+	    userId: string;
+	    emails: string[];
+	    hasSecret: boolean;
+	    locked: boolean;
+	    expiresAt?: string;
+	    revoked: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new PGPKeyResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.fingerprint = source["fingerprint"];
+	        this.keyId = source["keyId"];
+	        this.userId = source["userId"];
+	        this.emails = source["emails"];
+	        this.hasSecret = source["hasSecret"];
+	        this.locked = source["locked"];
+	        this.expiresAt = source["expiresAt"];
+	        this.revoked = source["revoked"];
+	    }
+	}
+	
+	export class RecipientSuggestionResponse {
+	    contactId: number;
+	    name: string;
+	    email: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RecipientSuggestionResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.contactId = source["contactId"];
+	        this.name = source["name"];
+	        this.email = source["email"];
+	    }
+	}
+	
+	
+	
+	export class RuleActionRequest {
+	    type: string;
+	    value?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RuleActionRequest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.type = source["type"];
+	        this.value = source["value"];
+	    }
+	}
+	export class RuleConditionRequest {
+	    field: string;
+	    header?: string;
+	    operator: string;
+	    value: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RuleConditionRequest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.field = source["field"];
+	        this.header = source["header"];
+	        this.operator = source["operator"];
+	        this.value = source["value"];
+	    }
+	}
+	export class RuleDryRunResponse {
+	    emails: EmailResponse[];
+	    scanned: number;
+	    truncated: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new RuleDryRunResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.emails = this.convertValues(source["emails"], EmailResponse);
+	        this.scanned = source["scanned"];
+	        this.truncated = source["truncated"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class RuleRequest {
+	    name: string;
+	    enabled: boolean;
+	    matchAll: boolean;
+	    stopProcessing: boolean;
+	    accountId?: number;
+	    conditions: RuleConditionRequest[];
+	    actions: RuleActionRequest[];
+	
+	    static createFrom(source: any = {}) {
+	        return new RuleRequest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.enabled = source["enabled"];
+	        this.matchAll = source["matchAll"];
+	        this.stopProcessing = source["stopProcessing"];
+	        this.accountId = source["accountId"];
+	        this.conditions = this.convertValues(source["conditions"], RuleConditionRequest);
+	        this.actions = this.convertValues(source["actions"], RuleActionRequest);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class RuleResponse {
+	    id: number;
+	    position: number;
+	    name: string;
+	    enabled: boolean;
+	    matchAll: boolean;
+	    stopProcessing: boolean;
+	    accountId?: number;
+	    conditions: RuleConditionRequest[];
+	    actions: RuleActionRequest[];
+	
+	    static createFrom(source: any = {}) {
+	        return new RuleResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.position = source["position"];
+	        this.name = source["name"];
+	        this.enabled = source["enabled"];
+	        this.matchAll = source["matchAll"];
+	        this.stopProcessing = source["stopProcessing"];
+	        this.accountId = source["accountId"];
+	        this.conditions = this.convertValues(source["conditions"], RuleConditionRequest);
+	        this.actions = this.convertValues(source["actions"], RuleActionRequest);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class SMIMECertificateResponse {
+	    fingerprint: string;
+	    subject: string;
+	    issuer: string;
+	    emails: string[];
+	    notBefore: string;
+	    notAfter: string;
+	    isCa: boolean;
+	    trustAnchor: boolean;
+	    isIdentity: boolean;
+	    locked: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new SMIMECertificateResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.fingerprint = source["fingerprint"];
+	        this.subject = source["subject"];
+	        this.issuer = source["issuer"];
+	        this.emails = source["emails"];
+	        this.notBefore = source["notBefore"];
+	        this.notAfter = source["notAfter"];
+	        this.isCa = source["isCa"];
+	        this.trustAnchor = source["trustAnchor"];
+	        this.isIdentity = source["isIdentity"];
+	        this.locked = source["locked"];
+	    }
+	}
+	
+	export class ScheduledEmailResponse {
+	    id: number;
+	    accountId: number;
+	    status: string;
+	    subject: string;
+	    recipients: string;
+	    sendAt: string;
+	    undoUntil: string;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ScheduledEmailResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.accountId = source["accountId"];
+	        this.status = source["status"];
+	        this.subject = source["subject"];
+	        this.recipients = source["recipients"];
+	        this.sendAt = source["sendAt"];
+	        this.undoUntil = source["undoUntil"];
+	        this.error = source["error"];
+	    }
+	}
+	export class SendEmailRequest {
+	    accountId: number;
+	    to: string[];
+	    cc?: string[];
+	    bcc?: string[];
+	    subject: string;
+	    text: string;
+	    html?: string;
+	    inReplyTo?: string;
+	    references?: string[];
+	    followUpDays?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SendEmailRequest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.accountId = source["accountId"];
+	        this.to = source["to"];
+	        this.cc = source["cc"];
+	        this.bcc = source["bcc"];
+	        this.subject = source["subject"];
+	        this.text = source["text"];
+	        this.html = source["html"];
+	        this.inReplyTo = source["inReplyTo"];
+	        this.references = source["references"];
+	        this.followUpDays = source["followUpDays"];
+	    }
+	}
+	export class SendEmailResponse {
+	    messageId: number;
+	    encrypted: string[];
+	    signed: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new SendEmailResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.messageId = source["messageId"];
+	        this.encrypted = source["encrypted"];
+	        this.signed = source["signed"];
+	    }
+	}
+	export class SieveActionResponse {
+	    type: string;
+	    value?: string;
+	    flags?: string[];
+	    copy?: boolean;
+	    implicit?: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new SieveActionResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.type = source["type"];
+	        this.value = source["value"];
+	        this.flags = source["flags"];
+	        this.copy = source["copy"];
+	        this.implicit = source["implicit"];
+	    }
+	}
+	export class SieveScriptRequest {
+	    name: string;
+	    source: string;
+	    active: boolean;
+	    accountId?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SieveScriptRequest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.source = source["source"];
+	        this.active = source["active"];
+	        this.accountId = source["accountId"];
+	    }
+	}
+	export class SieveScriptResponse {
+	    id: number;
+	    name: string;
+	    source: string;
+	    active: boolean;
+	    accountId?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SieveScriptResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.source = source["source"];
+	        this.active = source["active"];
+	        this.accountId = source["accountId"];
+	    }
+	}
+	export class SnoozePresetResponse {
+	    name: string;
+	    label: string;
+	    until: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SnoozePresetResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.label = source["label"];
+	        this.until = source["until"];
+	    }
+	}
+	export class SnoozeResponse {
+	    emailId: number;
+	    accountId?: number;
+	    subject?: string;
+	    senderEmail?: string;
+	    until: string;
+	    folder: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SnoozeResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.emailId = source["emailId"];
+	        this.accountId = source["accountId"];
+	        this.subject = source["subject"];
+	        this.senderEmail = source["senderEmail"];
+	        this.until = source["until"];
+	        this.folder = source["folder"];
+	    }
+	}
+	export class SpamStatusResponse {
+	    spamMessages: number;
+	    hamMessages: number;
+	    tokens: number;
+	    threshold: number;
+	    ready: boolean;
+	    folder: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SpamStatusResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.spamMessages = source["spamMessages"];
+	        this.hamMessages = source["hamMessages"];
+	        this.tokens = source["tokens"];
+	        this.threshold = source["threshold"];
+	        this.ready = source["ready"];
+	        this.folder = source["folder"];
+	    }
+	}
+	export class SubscriptionResponse {
+	    id: number;
+	    listId: string;
+	    name: string;
+	    senderEmail: string;
+	    messages: number;
+	    unread: number;
+	    lastReceivedAt?: string;
+	    lastReadAt?: string;
+	    canUnsubscribe: boolean;
+	    oneClick: boolean;
+	    unsubscribedAt?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SubscriptionResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.listId = source["listId"];
+	        this.name = source["name"];
+	        this.senderEmail = source["senderEmail"];
+	        this.messages = source["messages"];
+	        this.unread = source["unread"];
+	        this.lastReceivedAt = source["lastReceivedAt"];
+	        this.lastReadAt = source["lastReadAt"];
+	        this.canUnsubscribe = source["canUnsubscribe"];
+	        this.oneClick = source["oneClick"];
+	        this.unsubscribedAt = source["unsubscribedAt"];
+	    }
+	}
+	export class UnifiedInboxResponse {
+	    emails: EmailResponse[];
+	    totalCount: number;
+	    page: number;
+	    pageSize: number;
+	    totalPages: number;
+	    accounts: AccountUnreadResponse[];
+	    totalUnread: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new UnifiedInboxResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.emails = this.convertValues(source["emails"], EmailResponse);
+	        this.totalCount = source["totalCount"];
+	        this.page = source["page"];
+	        this.pageSize = source["pageSize"];
+	        this.totalPages = source["totalPages"];
+	        this.accounts = this.convertValues(source["accounts"], AccountUnreadResponse);
+	        this.totalUnread = source["totalUnread"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class UnsubscribeResponse {
+	    mailingListId: number;
+	    method: string;
+	    url?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new UnsubscribeResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.mailingListId = source["mailingListId"];
+	        this.method = source["method"];
+	        this.url = source["url"];
+	    }
+	}
+	export class VCardImportResponse {
+	    created: number;
+	    merged: number;
+	    skipped: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new VCardImportResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.created = source["created"];
+	        this.merged = source["merged"];
+	        this.skipped = source["skipped"];
+	    }
+	}
+
+}
+
+export namespace services {
+	
+	export class SyncResult {
+	    accountId: number;
+	    email: string;
+	    fetched: number;
+	    created: number;
+	    failed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SyncResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.accountId = source["accountId"];
+	        this.email = source["email"];
+	        this.fetched = source["fetched"];
+	        this.created = source["created"];
+	        this.failed = source["failed"];
+	    }
+	}
 
 }
 
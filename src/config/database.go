@@ -1,15 +1,23 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"math/rand"
+	"os"
 	"palm/src/entities"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
+// ErrDatabaseNotFound is returned by OpenPalmDB when the database file
+// does not exist
+var ErrDatabaseNotFound = errors.New("database not found")
+
 var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 // PalmDB creates a new database connection.
@@ -32,9 +40,41 @@ func PalmDB(inMemory bool, identifier ...string) (*gorm.DB, error) {
 		dbPath = identifier[0]
 	}
 
-	fmt.Printf("\n\nUsing database path: %s\n\n\n", dbPath)
+	Logger.Debug().Str("path", dbPath).Msg("Using database path")
 
-	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
+	db, err := connect(dbPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run migrations for all models
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// OpenPalmDB connects to the database file at path without migrating it.
+// Unless create is true, a missing file is ErrDatabaseNotFound rather than
+// a new empty database. SQL is logged to gormLogger, or GORM's default
+// logger if nil.
+func OpenPalmDB(path string, create bool, gormLogger logger.Interface) (*gorm.DB, error) {
+	if !create {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrDatabaseNotFound
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	Logger.Debug().Str("path", path).Msg("Using database path")
+	return connect(path, gormLogger)
+}
+
+// connect opens an SQLite database with foreign key constraints enabled
+func connect(dsn string, gormLogger logger.Interface) (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormLogger})
 	if err != nil {
 		return nil, err
 	}
@@ -48,16 +88,46 @@ func PalmDB(inMemory bool, identifier ...string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	// Run migrations for all models
-	err = db.AutoMigrate(
+	return db, nil
+}
+
+// Models returns every entity persisted in the database, in migration order
+func Models() []interface{} {
+	return []interface{}{
 		&entities.Account{},
 		&entities.Message{},
 		&entities.Recipient{},
 		&entities.Attachment{},
-	)
-	if err != nil {
-		return nil, err
+		&entities.MessageSource{},
+		&entities.MessageHeader{},
+		&entities.MessageRisk{},
+		&entities.MessageAuthentication{},
+		&entities.Contact{},
+		&entities.ContactEmail{},
+		&entities.ContactPhone{},
+		&entities.RemoteContentSender{},
+		&entities.PGPKey{},
+		&entities.PGPKeyEmail{},
+		&entities.SMIMECertificate{},
+		&entities.SMIMECertificateEmail{},
+		&entities.CalendarEvent{},
+		&entities.CalendarAttendee{},
+		&entities.Rule{},
+		&entities.MessageLabel{},
+		&entities.SieveScript{},
+		&entities.VacationReply{},
+		&entities.SpamModel{},
+		&entities.SpamToken{},
+		&entities.SpamVerdict{},
+		&entities.MailingList{},
+		&entities.CategoryOverride{},
+		&entities.Snooze{},
+		&entities.OutboxMessage{},
+		&entities.FollowUp{},
 	}
+}
 
-	return db, nil
+// Migrate creates or updates the tables for all models
+func Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(Models()...)
 }
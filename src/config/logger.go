@@ -13,12 +13,18 @@ var Logger zerolog.Logger
 
 // InitLogger initializes the global logger with zerolog
 func InitLogger() {
+	InitLoggerWithWriter(os.Stdout)
+}
+
+// InitLoggerWithWriter initializes the global logger writing to out.
+// Command-line tools use it to keep stdout free for their own output.
+func InitLoggerWithWriter(out io.Writer) {
 	// Set global time format to ISO8601
 	zerolog.TimeFieldFormat = time.RFC3339
 
 	// Configure console writer with color and caller info
 	consoleWriter := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        out,
 		TimeFormat: time.RFC3339,
 	}
 
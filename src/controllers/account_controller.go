@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+)
+
+// AccountController handles account-related requests
+type AccountController struct {
+	accountService *services.AccountService
+}
+
+// NewAccountController creates a new account controller
+func NewAccountController(accountService *services.AccountService) *AccountController {
+	config.Logger.Debug().Msg("Initializing account controller")
+	return &AccountController{
+		accountService: accountService,
+	}
+}
+
+// AccountResponse represents an account returned to the frontend
+type AccountResponse struct {
+	ID          uint   `json:"id"`
+	Email       string `json:"email"`
+	AccountType string `json:"accountType"`
+	MaildirPath string `json:"maildirPath,omitempty"` // Set for Local Maildir accounts
+	// TrustedAuthServIDs are the servers whose Authentication-Results are
+	// believed when verifying senders
+	TrustedAuthServIDs []string `json:"trustedAuthServIds,omitempty"`
+}
+
+// CreateAccount adds an account of a provider's type
+func (c *AccountController) CreateAccount(ctx context.Context, email string, accountType string) (*AccountResponse, error) {
+	config.Logger.Debug().
+		Str("email", email).
+		Str("accountType", accountType).
+		Msg("Create account request received")
+
+	account, err := c.accountService.CreateAccount(ctx, email, accountType)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("accountType", accountType).Msg("Failed to create account")
+		return nil, err
+	}
+	return mapAccountToResponse(account), nil
+}
+
+// ListAccounts returns every account
+func (c *AccountController) ListAccounts(ctx context.Context) ([]*AccountResponse, error) {
+	config.Logger.Debug().Msg("List accounts request received")
+
+	accounts, err := c.accountService.ListAccounts(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to list accounts")
+		return nil, err
+	}
+	responses := make([]*AccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		responses = append(responses, mapAccountToResponse(account))
+	}
+	return responses, nil
+}
+
+// DeleteAccount removes an account
+func (c *AccountController) DeleteAccount(ctx context.Context, accountID uint) error {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("Delete account request received")
+
+	if err := c.accountService.DeleteAccount(ctx, accountID); err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to delete account")
+		return err
+	}
+	return nil
+}
+
+// CreateMaildirAccount adds a Local Maildir account reading the maildir at path
+func (c *AccountController) CreateMaildirAccount(ctx context.Context, email string, path string) (*AccountResponse, error) {
+	config.Logger.Debug().
+		Str("email", email).
+		Str("path", path).
+		Msg("Create maildir account request received")
+
+	account, err := c.accountService.CreateMaildirAccount(ctx, email, path)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to create maildir account")
+		return nil, err
+	}
+	return mapAccountToResponse(account), nil
+}
+
+// SetTrustedAuthServIDs sets the servers whose Authentication-Results are
+// believed when verifying the senders of an account's mail
+func (c *AccountController) SetTrustedAuthServIDs(ctx context.Context, accountID uint, authServIDs []string) (*AccountResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Strs("authServIDs", authServIDs).
+		Msg("Set trusted authserv-ids request received")
+
+	account, err := c.accountService.SetTrustedAuthServIDs(ctx, accountID, authServIDs)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to set trusted authserv-ids")
+		return nil, err
+	}
+	return mapAccountToResponse(account), nil
+}
+
+func mapAccountToResponse(account *entities.Account) *AccountResponse {
+	response := &AccountResponse{
+		ID:                 account.ID,
+		Email:              account.Email,
+		AccountType:        account.AccountType,
+		TrustedAuthServIDs: account.TrustedAuthServIDs,
+	}
+	if account.MaildirPath != nil {
+		response.MaildirPath = *account.MaildirPath
+	}
+	return response
+}
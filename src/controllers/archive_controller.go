@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"palm/src/config"
+	"palm/src/formats/mbox"
+	"palm/src/services"
+)
+
+// ErrInvalidMboxFormat is returned for a format other than "mboxrd" or "mboxo"
+var ErrInvalidMboxFormat = errors.New("mbox format must be mboxrd or mboxo")
+
+// ArchiveController handles importing and exporting mbox files, maildirs
+// and .eml files
+type ArchiveController struct {
+	archiveService *services.ArchiveService
+}
+
+// NewArchiveController creates a new archive controller
+func NewArchiveController(archiveService *services.ArchiveService) *ArchiveController {
+	config.Logger.Debug().Msg("Initializing archive controller")
+	return &ArchiveController{
+		archiveService: archiveService,
+	}
+}
+
+// ImportResponse summarises an import
+type ImportResponse struct {
+	AccountID  uint `json:"accountId"`
+	Processed  int  `json:"processed"`
+	Imported   int  `json:"imported"`
+	Duplicates int  `json:"duplicates"`
+	Failed     int  `json:"failed"`
+}
+
+// ExportResponse describes a written export file
+type ExportResponse struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// ImportMboxFile imports the mbox file at path into an account. Progress is
+// published as import:progress events.
+func (c *ArchiveController) ImportMboxFile(ctx context.Context, accountID uint, path string, format string) (*ImportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("path", path).
+		Msg("Import mbox request received")
+
+	mboxFormat, err := parseMboxFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to open mbox file")
+		return nil, fmt.Errorf("failed to open mbox file: %w", err)
+	}
+	defer file.Close()
+
+	opts := services.ImportOptions{Format: mboxFormat}
+	if info, err := file.Stat(); err == nil {
+		opts.TotalBytes = info.Size()
+	}
+
+	result, err := c.archiveService.ImportMbox(ctx, accountID, file, opts)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to import mbox file")
+		return nil, err
+	}
+	return mapImportResultToResponse(result), nil
+}
+
+// ExportMboxFile writes an account's messages, or those matching query, to
+// a new mbox file at path
+func (c *ArchiveController) ExportMboxFile(ctx context.Context, accountID uint, query string, path string, format string) (*ExportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Str("path", path).
+		Msg("Export mbox request received")
+
+	mboxFormat, err := parseMboxFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to create mbox file")
+		return nil, fmt.Errorf("failed to create mbox file: %w", err)
+	}
+
+	count, err := c.archiveService.ExportMbox(ctx, accountID, query, file, mboxFormat)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write mbox file: %w", closeErr)
+	}
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to export mbox file")
+		os.Remove(path)
+		return nil, err
+	}
+	return &ExportResponse{Path: path, Count: count}, nil
+}
+
+// ImportMaildirDir imports the maildir at path, with its subfolders, into an
+// account. Progress is published as import:progress events.
+func (c *ArchiveController) ImportMaildirDir(ctx context.Context, accountID uint, path string) (*ImportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("path", path).
+		Msg("Import maildir request received")
+
+	result, err := c.archiveService.ImportMaildir(ctx, accountID, path, services.ImportOptions{})
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to import maildir")
+		return nil, err
+	}
+	return mapImportResultToResponse(result), nil
+}
+
+// ExportMaildirDir delivers an account's messages, or those matching query,
+// into the maildir at path, creating it if needed
+func (c *ArchiveController) ExportMaildirDir(ctx context.Context, accountID uint, query string, path string) (*ExportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Str("path", path).
+		Msg("Export maildir request received")
+
+	count, err := c.archiveService.ExportMaildir(ctx, accountID, query, path)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to export maildir")
+		return nil, err
+	}
+	return &ExportResponse{Path: path, Count: count}, nil
+}
+
+// ImportEMLFile creates an email in an account from the .eml file at path
+func (c *ArchiveController) ImportEMLFile(ctx context.Context, accountID uint, path string) (*EmailResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("path", path).
+		Msg("Import .eml request received")
+
+	file, err := os.Open(path)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to open .eml file")
+		return nil, fmt.Errorf("failed to open .eml file: %w", err)
+	}
+	defer file.Close()
+
+	email, err := c.archiveService.ImportEML(ctx, accountID, file)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to import .eml file")
+		return nil, err
+	}
+	response := mapEmailToResponse(email, services.RenderBody(email, services.RenderOptions{}))
+	return &response, nil
+}
+
+// ImportEMLFiles imports several .eml files into an account. Progress is
+// published as import:progress events.
+func (c *ArchiveController) ImportEMLFiles(ctx context.Context, accountID uint, paths []string) (*ImportResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Int("count", len(paths)).
+		Msg("Import .eml files request received")
+
+	result, err := c.archiveService.ImportEMLFiles(ctx, accountID, paths, services.ImportOptions{})
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to import .eml files")
+		return nil, err
+	}
+	return mapImportResultToResponse(result), nil
+}
+
+// EMLFilename suggests the file name to save an email under
+func (c *ArchiveController) EMLFilename(ctx context.Context, messageID uint) (string, error) {
+	return c.archiveService.EMLFilename(ctx, messageID)
+}
+
+// ExportEmailFile saves the RFC 5322 source of an email to a .eml file at path
+func (c *ArchiveController) ExportEmailFile(ctx context.Context, messageID uint, path string) (*ExportResponse, error) {
+	config.Logger.Debug().
+		Uint("messageID", messageID).
+		Str("path", path).
+		Msg("Export email request received")
+
+	file, err := os.Create(path)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to create .eml file")
+		return nil, fmt.Errorf("failed to create .eml file: %w", err)
+	}
+
+	err = c.archiveService.WriteEML(ctx, messageID, file)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write .eml file: %w", closeErr)
+	}
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("messageID", messageID).Msg("Failed to export email")
+		os.Remove(path)
+		return nil, err
+	}
+	return &ExportResponse{Path: path, Count: 1}, nil
+}
+
+// parseMboxFormat maps a format name to its mbox.Format; empty means mboxrd
+func parseMboxFormat(format string) (mbox.Format, error) {
+	switch format {
+	case "", "mboxrd":
+		return mbox.MboxRD, nil
+	case "mboxo":
+		return mbox.MboxO, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMboxFormat, format)
+	}
+}
+
+func mapImportResultToResponse(result *services.ImportResult) *ImportResponse {
+	return &ImportResponse{
+		AccountID:  result.AccountID,
+		Processed:  result.Processed,
+		Imported:   result.Imported,
+		Duplicates: result.Duplicates,
+		Failed:     result.Failed,
+	}
+}
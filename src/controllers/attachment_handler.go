@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"palm/src/config"
+	"palm/src/repositories"
+	"palm/src/services"
+	"strconv"
+)
+
+// inlineTypes are the attachment types the webview may display. Anything
+// else, HTML and SVG in particular, is only offered as a download so it
+// never runs with the application's privileges.
+var inlineTypes = map[string]bool{
+	"image/bmp": true, "image/gif": true, "image/jpeg": true,
+	"image/png": true, "image/webp": true,
+}
+
+// AttachmentHandler serves attachment contents to the webview at the URLs
+// built by services.AttachmentURL, for inline images in rendered bodies
+type AttachmentHandler struct {
+	attachmentService *services.AttachmentService
+	store             *services.AttachmentStore
+}
+
+// NewAttachmentHandler creates a new attachment handler
+func NewAttachmentHandler(attachmentService *services.AttachmentService, store *services.AttachmentStore) *AttachmentHandler {
+	config.Logger.Debug().Msg("Initializing attachment handler")
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+		store:             store,
+	}
+}
+
+// ServeHTTP serves GET requests for attachment URLs
+func (h *AttachmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := services.ParseAttachmentURL(r.URL.Path)
+	if !ok || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	attachment, err := h.attachmentService.GetAttachment(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrAttachmentNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "failed to load attachment", http.StatusInternalServerError)
+		return
+	}
+	data, err := h.store.Read(attachment)
+	if err != nil {
+		if errors.Is(err, services.ErrAttachmentUnavailable) {
+			http.NotFound(w, r)
+			return
+		}
+		config.Logger.Error().Err(err).Uint("attachmentID", id).Msg("Failed to read attachment")
+		http.Error(w, "failed to read attachment", http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	header.Set("Cache-Control", "private, max-age=86400")
+	if inlineTypes[attachment.MimeType] {
+		header.Set("Content-Type", attachment.MimeType)
+	} else {
+		header.Set("Content-Type", "application/octet-stream")
+		header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	}
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
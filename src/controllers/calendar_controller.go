@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/formats/ical"
+	"palm/src/services"
+	"time"
+)
+
+// CalendarController handles requests related to the calendar events of
+// emails
+type CalendarController struct {
+	calendarService *services.CalendarService
+}
+
+// NewCalendarController creates a new calendar controller
+func NewCalendarController(calendarService *services.CalendarService) *CalendarController {
+	config.Logger.Debug().Msg("Initializing calendar controller")
+	return &CalendarController{calendarService: calendarService}
+}
+
+// CalendarEventResponse is an event carried by an email, with its times in
+// the local time zone
+type CalendarEventResponse struct {
+	ID          uint                       `json:"id"`
+	Method      string                     `json:"method"` // REQUEST, REPLY, CANCEL or PUBLISH
+	Status      string                     `json:"status"`
+	Summary     string                     `json:"summary"`
+	Location    string                     `json:"location"`
+	Description string                     `json:"description"`
+	Start       string                     `json:"start"` // RFC 3339, or the date of an all-day event
+	End         string                     `json:"end"`   // Exclusive, as Start
+	AllDay      bool                       `json:"allDay"`
+	When        string                     `json:"when"`                 // e.g. "Tue, Mar 3, 2026, 10:00 – 11:00 CET"
+	Recurrence  string                     `json:"recurrence,omitempty"` // e.g. "Every week on Monday"
+	Organizer   CalendarAttendeeResponse   `json:"organizer"`
+	Attendees   []CalendarAttendeeResponse `json:"attendees"`
+	Response    string                     `json:"response"`   // The user's reply: ACCEPTED, TENTATIVE, DECLINED or ""
+	CanRespond  bool                       `json:"canRespond"` // The event is an invitation awaiting a reply
+	RespondedAt string                     `json:"respondedAt,omitempty"`
+}
+
+// CalendarAttendeeResponse is the organizer or an attendee of an event.
+// Status is the participation status of attendees.
+type CalendarAttendeeResponse struct {
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Role   string `json:"role,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// RespondToInvitation answers an invitation with ACCEPTED, TENTATIVE or
+// DECLINED. The reply is sent to the organizer from the account that
+// received the invitation.
+func (c *CalendarController) RespondToInvitation(ctx context.Context, eventID uint, response string) (*SendEmailResponse, error) {
+	config.Logger.Debug().
+		Uint("eventID", eventID).
+		Str("response", response).
+		Msg("Respond to invitation request received")
+
+	result, err := c.calendarService.Respond(ctx, eventID, response)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("eventID", eventID).Msg("Failed to respond to invitation")
+		return nil, err
+	}
+	return &SendEmailResponse{
+		MessageID: result.MessageID,
+		Encrypted: result.Encrypted,
+		Signed:    result.Signed,
+	}, nil
+}
+
+// mapCalendarEvents converts events to their responses, rendering times
+// in the local time zone
+func mapCalendarEvents(records []*entities.CalendarEvent) []CalendarEventResponse {
+	response := make([]CalendarEventResponse, 0, len(records))
+	for _, record := range records {
+		event := CalendarEventResponse{
+			ID:          record.ID,
+			Method:      record.Method,
+			Status:      record.Status,
+			Summary:     record.Summary,
+			Location:    record.Location,
+			Description: record.Description,
+			Start:       record.Start.In(time.Local).Format(time.RFC3339),
+			End:         record.End.In(time.Local).Format(time.RFC3339),
+			AllDay:      record.AllDay,
+			When:        services.FormatEventTime(record, time.Local),
+			Recurrence:  services.RecurrenceSummary(record),
+			Organizer: CalendarAttendeeResponse{
+				Email: record.OrganizerEmail,
+				Name:  record.OrganizerName,
+			},
+			Attendees:  make([]CalendarAttendeeResponse, 0, len(record.Attendees)),
+			Response:   record.Response,
+			CanRespond: record.Method == ical.MethodRequest && record.Status != ical.StatusCancelled,
+		}
+		if record.AllDay {
+			// Dates belong to no time zone
+			event.Start = record.Start.UTC().Format(time.DateOnly)
+			event.End = record.End.UTC().Format(time.DateOnly)
+		}
+		if record.RespondedAt != nil {
+			event.RespondedAt = record.RespondedAt.Format(time.RFC3339)
+		}
+		for _, attendee := range record.Attendees {
+			event.Attendees = append(event.Attendees, CalendarAttendeeResponse{
+				Email:  attendee.Email,
+				Name:   attendee.Name,
+				Role:   attendee.Role,
+				Status: attendee.PartStat,
+			})
+		}
+		response = append(response, event)
+	}
+	return response
+}
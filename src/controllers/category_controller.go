@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+	"time"
+)
+
+// CategoryController handles requests to move emails between the inbox
+// tabs and to review what the categorizer was taught
+type CategoryController struct {
+	categorizer *services.Categorizer
+}
+
+// NewCategoryController creates a new category controller
+func NewCategoryController(categorizer *services.Categorizer) *CategoryController {
+	config.Logger.Debug().Msg("Initializing category controller")
+	return &CategoryController{categorizer: categorizer}
+}
+
+// CategoryOverrideResponse is the category chosen for a sender's emails
+type CategoryOverrideResponse struct {
+	ID          uint   `json:"id"`
+	AccountID   uint   `json:"accountId"`
+	SenderEmail string `json:"senderEmail"`
+	Category    string `json:"category"`
+	UpdatedAt   string `json:"updatedAt"` // RFC 3339
+}
+
+// SetEmailCategory moves an email to Primary, Updates, Promotions or
+// Social, along with every other email of its sender, and keeps future
+// emails of the sender there. It returns how many emails were moved.
+func (c *CategoryController) SetEmailCategory(ctx context.Context, emailID uint, category string) (int, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Str("category", category).Msg("Set email category request received")
+
+	moved, err := c.categorizer.SetCategory(ctx, emailID, entities.Category(category))
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to set email category")
+		return 0, err
+	}
+	return moved, nil
+}
+
+// ListCategoryOverrides returns the categories chosen for senders of an
+// account
+func (c *CategoryController) ListCategoryOverrides(ctx context.Context, accountID uint) ([]CategoryOverrideResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("List category overrides request received")
+
+	overrides, err := c.categorizer.Overrides(ctx, accountID)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to list category overrides")
+		return nil, err
+	}
+	response := make([]CategoryOverrideResponse, len(overrides))
+	for i, override := range overrides {
+		response[i] = CategoryOverrideResponse{
+			ID:          override.ID,
+			AccountID:   override.AccountID,
+			SenderEmail: override.SenderEmail,
+			Category:    string(override.Category),
+			UpdatedAt:   override.UpdatedAt.Format(time.RFC3339),
+		}
+	}
+	return response, nil
+}
+
+// DeleteCategoryOverride forgets the category chosen for a sender, whose
+// emails are categorized automatically again. It returns how many emails
+// were moved.
+func (c *CategoryController) DeleteCategoryOverride(ctx context.Context, id uint) (int, error) {
+	config.Logger.Debug().Uint("id", id).Msg("Delete category override request received")
+
+	moved, err := c.categorizer.DeleteOverride(ctx, id)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("id", id).Msg("Failed to delete category override")
+		return 0, err
+	}
+	return moved, nil
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+	"strings"
+)
+
+// autocompleteLimit is the number of suggestions returned while typing
+const autocompleteLimit = 10
+
+// ContactController handles requests related to contacts
+type ContactController struct {
+	contactService *services.ContactService
+}
+
+// NewContactController creates a new contact controller
+func NewContactController(contactService *services.ContactService) *ContactController {
+	config.Logger.Debug().Msg("Initializing contact controller")
+	return &ContactController{
+		contactService: contactService,
+	}
+}
+
+// ContactResponse represents a contact returned to the frontend
+type ContactResponse struct {
+	ID                uint     `json:"id"`
+	DisplayName       string   `json:"displayName"`
+	DisplayNameLocked bool     `json:"displayNameLocked"`
+	Emails            []string `json:"emails"`
+	Phones            []string `json:"phones"`
+	Organization      string   `json:"organization,omitempty"`
+	Note              string   `json:"note,omitempty"`
+	HasPhoto          bool     `json:"hasPhoto"`
+	MessageCount      uint     `json:"messageCount"`
+	SentCount         uint     `json:"sentCount"`
+	LastInteraction   string   `json:"lastInteraction,omitempty"`
+}
+
+// VCardImportResponse summarises a vCard import
+type VCardImportResponse struct {
+	Created int `json:"created"`
+	Merged  int `json:"merged"`
+	Skipped int `json:"skipped"`
+}
+
+// RecipientSuggestionResponse is one autocomplete suggestion
+type RecipientSuggestionResponse struct {
+	ContactID uint   `json:"contactId"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+}
+
+// AutocompleteRecipients suggests addresses starting with prefix
+func (c *ContactController) AutocompleteRecipients(ctx context.Context, prefix string) ([]RecipientSuggestionResponse, error) {
+	config.Logger.Debug().Str("prefix", prefix).Msg("Autocomplete recipients request received")
+
+	suggestions, err := c.contactService.Autocomplete(ctx, prefix, autocompleteLimit)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("prefix", prefix).Msg("Failed to autocomplete recipients")
+		return nil, err
+	}
+
+	response := make([]RecipientSuggestionResponse, 0, len(suggestions))
+	for _, s := range suggestions {
+		response = append(response, RecipientSuggestionResponse{
+			ContactID: s.ContactID,
+			Name:      s.Name,
+			Email:     s.Email,
+		})
+	}
+	return response, nil
+}
+
+// ListContacts returns every contact, most frequently contacted first
+func (c *ContactController) ListContacts(ctx context.Context) ([]ContactResponse, error) {
+	contacts, err := c.contactService.ListContacts(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to list contacts")
+		return nil, err
+	}
+	return mapContactsToResponse(contacts), nil
+}
+
+// UpdateContact renames a contact and adds addresses to it
+func (c *ContactController) UpdateContact(ctx context.Context, id uint, displayName string, addEmails []string) (*ContactResponse, error) {
+	config.Logger.Debug().Uint("contactID", id).Msg("Update contact request received")
+
+	contact, err := c.contactService.UpdateContact(ctx, id, displayName, addEmails)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("contactID", id).Msg("Failed to update contact")
+		return nil, err
+	}
+	response := mapContactToResponse(contact)
+	return &response, nil
+}
+
+// MergeContacts folds duplicate contacts into the target contact
+func (c *ContactController) MergeContacts(ctx context.Context, targetID uint, duplicateIDs []uint) (*ContactResponse, error) {
+	config.Logger.Debug().
+		Uint("targetID", targetID).
+		Interface("duplicateIDs", duplicateIDs).
+		Msg("Merge contacts request received")
+
+	contact, err := c.contactService.MergeContacts(ctx, targetID, duplicateIDs)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("targetID", targetID).Msg("Failed to merge contacts")
+		return nil, err
+	}
+	response := mapContactToResponse(contact)
+	return &response, nil
+}
+
+// FindDuplicateContacts returns groups of contacts sharing a display name
+func (c *ContactController) FindDuplicateContacts(ctx context.Context) ([][]ContactResponse, error) {
+	groups, err := c.contactService.FindDuplicates(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to find duplicate contacts")
+		return nil, err
+	}
+
+	response := make([][]ContactResponse, 0, len(groups))
+	for _, group := range groups {
+		response = append(response, mapContactsToResponse(group))
+	}
+	return response, nil
+}
+
+// DeleteContact removes a contact
+func (c *ContactController) DeleteContact(ctx context.Context, id uint) error {
+	config.Logger.Debug().Uint("contactID", id).Msg("Delete contact request received")
+
+	if err := c.contactService.DeleteContact(ctx, id); err != nil {
+		config.Logger.Error().Err(err).Uint("contactID", id).Msg("Failed to delete contact")
+		return err
+	}
+	return nil
+}
+
+// ImportVCards adds the contacts of a vCard file's contents to the address book
+func (c *ContactController) ImportVCards(ctx context.Context, data string) (*VCardImportResponse, error) {
+	config.Logger.Debug().Int("size", len(data)).Msg("Import vCards request received")
+
+	result, err := c.contactService.ImportVCards(ctx, strings.NewReader(data))
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to import vCards")
+		return nil, err
+	}
+	return &VCardImportResponse{
+		Created: result.Created,
+		Merged:  result.Merged,
+		Skipped: result.Skipped,
+	}, nil
+}
+
+// ExportVCards returns contacts as a vCard file of the given version ("3.0"
+// or "4.0"). No contact IDs exports the whole address book.
+func (c *ContactController) ExportVCards(ctx context.Context, contactIDs []uint, version string) (string, error) {
+	config.Logger.Debug().
+		Interface("contactIDs", contactIDs).
+		Str("version", version).
+		Msg("Export vCards request received")
+
+	var buf bytes.Buffer
+	if _, err := c.contactService.ExportVCards(ctx, &buf, contactIDs, version); err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to export vCards")
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func mapContactsToResponse(contacts []*entities.Contact) []ContactResponse {
+	response := make([]ContactResponse, 0, len(contacts))
+	for _, contact := range contacts {
+		response = append(response, mapContactToResponse(contact))
+	}
+	return response
+}
+
+// mapContactToResponse converts a Contact entity to a ContactResponse
+func mapContactToResponse(contact *entities.Contact) ContactResponse {
+	displayName := ""
+	if contact.DisplayName != nil {
+		displayName = *contact.DisplayName
+	}
+
+	lastInteraction := ""
+	if contact.LastInteraction != nil {
+		lastInteraction = contact.LastInteraction.Format("2006-01-02T15:04:05Z07:00")
+	}
+
+	emails := make([]string, 0, len(contact.Emails))
+	for _, e := range contact.Emails {
+		emails = append(emails, e.Email)
+	}
+
+	phones := make([]string, 0, len(contact.Phones))
+	for _, p := range contact.Phones {
+		phones = append(phones, p.Number)
+	}
+
+	organization := ""
+	if contact.Organization != nil {
+		organization = *contact.Organization
+	}
+	note := ""
+	if contact.Note != nil {
+		note = *contact.Note
+	}
+
+	return ContactResponse{
+		ID:                contact.ID,
+		DisplayName:       displayName,
+		DisplayNameLocked: contact.DisplayNameLocked,
+		Emails:            emails,
+		Phones:            phones,
+		Organization:      organization,
+		Note:              note,
+		HasPhoto:          len(contact.Photo) > 0,
+		MessageCount:      contact.MessageCount,
+		SentCount:         contact.SentCount,
+		LastInteraction:   lastInteraction,
+	}
+}
@@ -2,23 +2,101 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"palm/src/config"
+	"palm/src/entities"
 	"palm/src/services"
+	"strings"
+	"time"
 )
 
 // EmailController handles HTTP requests related to emails
 type EmailController struct {
-	emailService *services.EmailService
+	emailService    *services.EmailService
+	sourceService   *services.SourceService
+	remoteService   *services.RemoteContentService
+	pgpService      *services.PGPService
+	smimeService    *services.SMIMEService
+	calendarService *services.CalendarService
 }
 
 // NewEmailController creates a new email controller
-func NewEmailController(emailService *services.EmailService) *EmailController {
+func NewEmailController(emailService *services.EmailService, sourceService *services.SourceService, remoteService *services.RemoteContentService, pgpService *services.PGPService, smimeService *services.SMIMEService, calendarService *services.CalendarService) *EmailController {
 	config.Logger.Debug().Msg("Initializing email controller")
 	return &EmailController{
-		emailService: emailService,
+		emailService:    emailService,
+		sourceService:   sourceService,
+		remoteService:   remoteService,
+		pgpService:      pgpService,
+		smimeService:    smimeService,
+		calendarService: calendarService,
 	}
 }
 
+// ListEmailsOptions filters and sorts the emails returned by ListEmails.
+// Every field is optional; the zero value lists all emails newest first,
+// except snoozed ones and likely spam.
+type ListEmailsOptions struct {
+	UnreadOnly     bool    `json:"unreadOnly"`
+	FlaggedOnly    bool    `json:"flaggedOnly"`
+	Importance     string  `json:"importance,omitempty"`     // Low, Normal or High
+	HasAttachments *bool   `json:"hasAttachments,omitempty"` // With or without attachments
+	Sender         string  `json:"sender,omitempty"`         // Substring of sender address or name
+	ReceivedAfter  string  `json:"receivedAfter,omitempty"`  // RFC 3339, inclusive
+	ReceivedBefore string  `json:"receivedBefore,omitempty"` // RFC 3339, exclusive
+	Drafts         *bool   `json:"drafts,omitempty"`         // Only drafts or no drafts
+	Folder         *string `json:"folder,omitempty"`         // Only emails in this folder, "" being the inbox
+	AllFolders     bool    `json:"allFolders,omitempty"`     // Include snoozed emails and spam when no folder is given
+	Label          string  `json:"label,omitempty"`          // Only emails with this label
+	MailingListID  *uint   `json:"mailingListId,omitempty"`  // Only emails from this mailing list
+	Category       string  `json:"category,omitempty"`       // Primary, Updates, Promotions or Social
+	SortBy         string  `json:"sortBy,omitempty"`         // date, sender, subject or size
+	SortOrder      string  `json:"sortOrder,omitempty"`      // asc or desc
+}
+
+// toServiceOptions converts the request options, parsing the date bounds
+func (o ListEmailsOptions) toServiceOptions() (services.ListOptions, error) {
+	opts := services.ListOptions{
+		Filter: services.EmailFilter{
+			UnreadOnly:     o.UnreadOnly,
+			FlaggedOnly:    o.FlaggedOnly,
+			Importance:     entities.Importance(o.Importance),
+			HasAttachments: o.HasAttachments,
+			Sender:         o.Sender,
+			Drafts:         o.Drafts,
+			Folder:         o.Folder,
+			AllFolders:     o.AllFolders,
+			Label:          o.Label,
+			MailingListID:  o.MailingListID,
+			Category:       entities.Category(o.Category),
+		},
+		Sort: services.EmailSort{
+			Field: services.SortField(o.SortBy),
+			Order: services.SortOrder(o.SortOrder),
+		},
+	}
+
+	parse := func(name, value string) (*time.Time, error) {
+		if value == "" {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not an RFC 3339 time", services.ErrInvalidFilter, name)
+		}
+		return &t, nil
+	}
+
+	var err error
+	if opts.Filter.ReceivedAfter, err = parse("receivedAfter", o.ReceivedAfter); err != nil {
+		return opts, err
+	}
+	if opts.Filter.ReceivedBefore, err = parse("receivedBefore", o.ReceivedBefore); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
 // ListEmailsResponse is the response for the ListEmails method
 type ListEmailsResponse struct {
 	Emails     []EmailResponse `json:"emails"`
@@ -30,17 +108,113 @@ type ListEmailsResponse struct {
 
 // EmailResponse represents the email data returned to the frontend
 type EmailResponse struct {
-	ID          uint                 `json:"id"`
-	AccountID   uint                 `json:"accountId"`
-	Subject     string               `json:"subject"`
-	Body        string               `json:"body"`
-	SenderName  string               `json:"senderName"`
-	SenderEmail string               `json:"senderEmail"`
-	ReceivedAt  string               `json:"receivedAt"`
-	IsRead      bool                 `json:"isRead"`
-	Importance  string               `json:"importance"`
-	Recipients  []RecipientResponse  `json:"recipients"`
-	Attachments []AttachmentResponse `json:"attachments,omitempty"`
+	ID             uint                    `json:"id"`
+	AccountID      uint                    `json:"accountId"`
+	AccountEmail   string                  `json:"accountEmail,omitempty"` // Set in the unified inbox
+	AccountType    string                  `json:"accountType,omitempty"`  // Set in the unified inbox
+	Subject        string                  `json:"subject"`
+	Body           string                  `json:"body"` // Sanitized HTML, safe to insert into the page
+	Text           string                  `json:"text"` // Plain text rendering of the body
+	SenderName     string                  `json:"senderName"`
+	SenderEmail    string                  `json:"senderEmail"`
+	ReceivedAt     string                  `json:"receivedAt"`
+	IsRead         bool                    `json:"isRead"`
+	IsFlagged      bool                    `json:"isFlagged"`
+	Importance     string                  `json:"importance"`
+	Folder         string                  `json:"folder"` // "" for the inbox
+	Labels         []string                `json:"labels"`
+	MailingListID  *uint                   `json:"mailingListId,omitempty"` // Mailing list or newsletter the email came from
+	Category       string                  `json:"category,omitempty"`      // Inbox tab; unset for sent emails
+	Recipients     []RecipientResponse     `json:"recipients"`
+	Attachments    []AttachmentResponse    `json:"attachments,omitempty"`
+	RemoteContent  RemoteContentResponse   `json:"remoteContent"`
+	Risk           RiskResponse            `json:"risk"`
+	Authentication AuthenticationResponse  `json:"authentication"`   // Empty until the sender is verified
+	OpenPGP        OpenPGPResponse         `json:"openpgp"`          // Only set by GetEmail and LoadRemoteContent
+	SMIME          SMIMEResponse           `json:"smime"`            // Only set by GetEmail and LoadRemoteContent
+	Events         []CalendarEventResponse `json:"events,omitempty"` // Calendar events such as invitations; only set by GetEmail and LoadRemoteContent
+}
+
+// OpenPGPResponse describes the OpenPGP protection of an email. Signature
+// is "", "good", "bad" or "unknown-key".
+type OpenPGPResponse struct {
+	Encrypted     bool   `json:"encrypted"`
+	Decrypted     bool   `json:"decrypted"`
+	DecryptError  string `json:"decryptError,omitempty"`
+	Signature     string `json:"signature"`
+	SignerKeyID   string `json:"signerKeyId,omitempty"`
+	SignerUserID  string `json:"signerUserId,omitempty"`
+	SignerMatches bool   `json:"signerMatches"` // The signing key belongs to the sender
+}
+
+// SMIMEResponse describes the S/MIME protection of an email. Signature is
+// "", "good", "bad" or "unknown-key"; a good signature is only vouched for
+// when Trusted is set.
+type SMIMEResponse struct {
+	Encrypted     bool     `json:"encrypted"`
+	Decrypted     bool     `json:"decrypted"`
+	DecryptError  string   `json:"decryptError,omitempty"`
+	Signature     string   `json:"signature"`
+	SignerSubject string   `json:"signerSubject,omitempty"`
+	SignerEmails  []string `json:"signerEmails,omitempty"`
+	SignerIssuer  string   `json:"signerIssuer,omitempty"`
+	SignerExpires string   `json:"signerExpires,omitempty"` // RFC 3339
+	Trusted       bool     `json:"trusted"`                 // The certificate chains to the trust store
+	TrustError    string   `json:"trustError,omitempty"`
+	SignerMatches bool     `json:"signerMatches"` // The certificate is issued to the sender
+}
+
+// AuthenticationResponse tells whether an email comes from the domain of
+// its sender. SenderStatus is "verified", "unverified" or "failed".
+type AuthenticationResponse struct {
+	SenderStatus string `json:"senderStatus"`
+	DKIM         string `json:"dkim"`
+	DKIMDomain   string `json:"dkimDomain"`
+	SPF          string `json:"spf"`
+	DMARC        string `json:"dmarc"`
+	ARC          string `json:"arc"`
+}
+
+// RiskResponse is the phishing analysis of an email. Level is "none",
+// "low", "medium" or "high"; from medium up the email deserves a warning.
+type RiskResponse struct {
+	Score   int                  `json:"score"`
+	Level   string               `json:"level"`
+	Reasons []RiskReasonResponse `json:"reasons"`
+}
+
+// RiskReasonResponse is one finding behind a risk score
+type RiskReasonResponse struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// RemoteContentResponse describes the remote content of an email body.
+// Remote images are only loaded in GetEmail and LoadRemoteContent.
+type RemoteContentResponse struct {
+	Blocked        int  `json:"blocked"`        // Remote images not loaded
+	TrackingPixels int  `json:"trackingPixels"` // Trackers, never loaded
+	TrackedLinks   int  `json:"trackedLinks"`   // Links through click-tracking redirects
+	Allowed        bool `json:"allowed"`        // Remote images are loaded
+}
+
+// UnifiedInboxResponse is the response for the ListUnifiedEmails method
+type UnifiedInboxResponse struct {
+	Emails      []EmailResponse         `json:"emails"`
+	TotalCount  int64                   `json:"totalCount"`
+	Page        int                     `json:"page"`
+	PageSize    int                     `json:"pageSize"`
+	TotalPages  int                     `json:"totalPages"`
+	Accounts    []AccountUnreadResponse `json:"accounts"`
+	TotalUnread int64                   `json:"totalUnread"`
+}
+
+// AccountUnreadResponse represents the unread count of one account
+type AccountUnreadResponse struct {
+	ID          uint   `json:"id"`
+	Email       string `json:"email"`
+	AccountType string `json:"accountType"`
+	UnreadCount int64  `json:"unreadCount"`
 }
 
 // RecipientResponse represents a recipient in the response
@@ -59,36 +233,34 @@ type AttachmentResponse struct {
 	MimeType string `json:"mimeType"`
 }
 
-// ListEmails returns a paginated list of emails for an account
-func (c *EmailController) ListEmails(ctx context.Context, accountID uint, page int, pageSize int) (*ListEmailsResponse, error) {
+// ListEmails returns a paginated, filtered and sorted list of emails for an account
+func (c *EmailController) ListEmails(ctx context.Context, accountID uint, page int, pageSize int, options ListEmailsOptions) (*ListEmailsResponse, error) {
 	config.Logger.Debug().
 		Uint("accountID", accountID).
 		Int("page", page).
 		Int("pageSize", pageSize).
+		Interface("options", options).
 		Msg("List emails request received")
 
-	result, err := c.emailService.List(ctx, accountID, pageSize, page)
+	opts, err := options.toServiceOptions()
 	if err != nil {
 		config.Logger.Error().
 			Err(err).
 			Uint("accountID", accountID).
-			Msg("Failed to list emails")
+			Msg("Invalid list options")
 		return nil, err
 	}
 
-	response := &ListEmailsResponse{
-		Emails:     make([]EmailResponse, 0, len(result.Emails)),
-		TotalCount: result.TotalCount,
-		Page:       result.Page,
-		PageSize:   result.PageSize,
-		TotalPages: result.TotalPages,
+	result, err := c.emailService.ListWithOptions(ctx, accountID, opts, pageSize, page)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", accountID).
+			Msg("Failed to list emails")
+		return nil, err
 	}
 
-	// Convert service DTOs to response format
-	for _, email := range result.Emails {
-		emailResp := mapEmailToResponse(email)
-		response.Emails = append(response.Emails, emailResp)
-	}
+	response := mapPaginatedEmailsToResponse(result)
 
 	config.Logger.Debug().
 		Uint("accountID", accountID).
@@ -99,10 +271,96 @@ func (c *EmailController) ListEmails(ctx context.Context, accountID uint, page i
 	return response, nil
 }
 
-// GetEmail returns a single email with its details
+// SearchEmails returns a paginated list of emails for an account matching a query
+func (c *EmailController) SearchEmails(ctx context.Context, accountID uint, query string, page int, pageSize int) (*ListEmailsResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Int("page", page).
+		Int("pageSize", pageSize).
+		Msg("Search emails request received")
+
+	result, err := c.emailService.Search(ctx, accountID, query, pageSize, page)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", accountID).
+			Msg("Failed to search emails")
+		return nil, err
+	}
+
+	return mapPaginatedEmailsToResponse(result), nil
+}
+
+// ListUnifiedEmails returns a paginated list of emails merged across the
+// given accounts (all accounts when empty) with their unread counts
+func (c *EmailController) ListUnifiedEmails(ctx context.Context, accountIDs []uint, page int, pageSize int) (*UnifiedInboxResponse, error) {
+	config.Logger.Debug().
+		Interface("accountIDs", accountIDs).
+		Int("page", page).
+		Int("pageSize", pageSize).
+		Msg("List unified emails request received")
+
+	result, err := c.emailService.ListUnified(ctx, accountIDs, pageSize, page)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Msg("Failed to list unified emails")
+		return nil, err
+	}
+
+	counts, err := c.emailService.UnreadCounts(ctx, accountIDs)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Msg("Failed to count unread emails")
+		return nil, err
+	}
+
+	list := mapPaginatedEmailsToResponse(result)
+	response := &UnifiedInboxResponse{
+		Emails:     list.Emails,
+		TotalCount: list.TotalCount,
+		Page:       list.Page,
+		PageSize:   list.PageSize,
+		TotalPages: list.TotalPages,
+		Accounts:   make([]AccountUnreadResponse, 0, len(counts)),
+	}
+	for _, count := range counts {
+		response.Accounts = append(response.Accounts, AccountUnreadResponse{
+			ID:          count.AccountID,
+			Email:       count.Email,
+			AccountType: count.AccountType,
+			UnreadCount: count.Unread,
+		})
+		response.TotalUnread += count.Unread
+	}
+
+	config.Logger.Debug().
+		Int("emailCount", len(response.Emails)).
+		Int64("totalCount", response.TotalCount).
+		Int64("totalUnread", response.TotalUnread).
+		Msg("Unified emails listed successfully")
+
+	return response, nil
+}
+
+// GetEmail returns a single email with its details. Remote images are
+// loaded if the sender is allowed.
 func (c *EmailController) GetEmail(ctx context.Context, messageID uint) (*EmailResponse, error) {
+	return c.getEmail(ctx, messageID, false)
+}
+
+// LoadRemoteContent returns an email with its remote images loaded, once,
+// whether or not the sender is allowed
+func (c *EmailController) LoadRemoteContent(ctx context.Context, messageID uint) (*EmailResponse, error) {
+	return c.getEmail(ctx, messageID, true)
+}
+
+func (c *EmailController) getEmail(ctx context.Context, messageID uint, loadRemote bool) (*EmailResponse, error) {
 	config.Logger.Debug().
 		Uint("messageID", messageID).
+		Bool("loadRemote", loadRemote).
 		Msg("Get email request received")
 
 	email, err := c.emailService.GetByID(ctx, messageID)
@@ -114,24 +372,209 @@ func (c *EmailController) GetEmail(ctx context.Context, messageID uint) (*EmailR
 		return nil, err
 	}
 
-	response := mapEmailToResponse(email)
+	// Encrypted bodies are decrypted before they are rendered
+	pgp, err := c.pgpService.Open(ctx, email)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to open OpenPGP message")
+		return nil, err
+	}
+	smime, err := c.smimeService.Open(ctx, email)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to open S/MIME message")
+		return nil, err
+	}
+
+	events, err := c.calendarService.Events(ctx, messageID)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to get calendar events")
+		return nil, err
+	}
+
+	body, err := c.remoteService.Render(ctx, email, loadRemote)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to render email")
+		return nil, err
+	}
+	response := mapEmailToResponse(email, body)
+	response.OpenPGP = OpenPGPResponse{
+		Encrypted:     pgp.Encrypted,
+		Decrypted:     pgp.Decrypted,
+		Signature:     string(pgp.Signature),
+		SignerKeyID:   pgp.SignerKeyID,
+		SignerUserID:  pgp.SignerUserID,
+		SignerMatches: pgp.SignerMatches,
+	}
+	if pgp.DecryptError != nil {
+		response.OpenPGP.DecryptError = pgp.DecryptError.Error()
+	}
+	response.SMIME = mapSMIMEStatus(smime)
+	response.Events = mapCalendarEvents(events)
 
 	config.Logger.Debug().
 		Uint("messageID", messageID).
+		Int("blockedImages", body.Remote.Blocked).
+		Int("trackingPixels", body.Remote.TrackingPixels).
 		Msg("Email retrieved successfully")
 
 	return &response, nil
 }
 
-// mapEmailToResponse converts an EmailDTO to an EmailResponse
-func mapEmailToResponse(email *services.EmailDTO) EmailResponse {
-	var subject, body string
+// AllowRemoteContent loads remote images in messages from a sender, given
+// as an address or a domain
+func (c *EmailController) AllowRemoteContent(ctx context.Context, sender string) error {
+	config.Logger.Debug().
+		Str("sender", sender).
+		Msg("Allow remote content request received")
+
+	if _, err := c.remoteService.Allow(ctx, sender); err != nil {
+		config.Logger.Error().
+			Err(err).
+			Str("sender", sender).
+			Msg("Failed to allow remote content")
+		return err
+	}
+	return nil
+}
+
+// DisallowRemoteContent blocks remote images from a sender again
+func (c *EmailController) DisallowRemoteContent(ctx context.Context, sender string) error {
+	config.Logger.Debug().
+		Str("sender", sender).
+		Msg("Disallow remote content request received")
+
+	if err := c.remoteService.Disallow(ctx, sender); err != nil {
+		config.Logger.Error().
+			Err(err).
+			Str("sender", sender).
+			Msg("Failed to disallow remote content")
+		return err
+	}
+	return nil
+}
+
+// ListRemoteContentSenders returns the addresses and domains whose messages
+// load remote images
+func (c *EmailController) ListRemoteContentSenders(ctx context.Context) ([]string, error) {
+	senders, err := c.remoteService.List(ctx)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Msg("Failed to list remote content senders")
+		return nil, err
+	}
+
+	response := make([]string, len(senders))
+	for i, sender := range senders {
+		response[i] = sender.Sender
+	}
+	return response, nil
+}
+
+// HeaderResponse is one header field of an email, as it appears in the source
+type HeaderResponse struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// EmailHeadersResponse lists the header fields of an email in order
+type EmailHeadersResponse struct {
+	Headers       []HeaderResponse `json:"headers"`
+	Reconstructed bool             `json:"reconstructed"` // The original source was not stored
+}
+
+// EmailSourceResponse is the raw RFC 5322 source of an email
+type EmailSourceResponse struct {
+	Source        string `json:"source"`
+	Size          int    `json:"size"`
+	Reconstructed bool   `json:"reconstructed"` // Rebuilt from the stored fields
+}
+
+// GetEmailHeaders returns the header fields of an email
+func (c *EmailController) GetEmailHeaders(ctx context.Context, messageID uint) (*EmailHeadersResponse, error) {
+	config.Logger.Debug().
+		Uint("messageID", messageID).
+		Msg("Get email headers request received")
+
+	headers, err := c.sourceService.Headers(ctx, messageID)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to get email headers")
+		return nil, err
+	}
+
+	response := &EmailHeadersResponse{
+		Headers:       make([]HeaderResponse, len(headers.Fields)),
+		Reconstructed: headers.Reconstructed,
+	}
+	for i, field := range headers.Fields {
+		response.Headers[i] = HeaderResponse{Name: field.Name, Value: field.Value}
+	}
+	return response, nil
+}
+
+// GetEmailSource returns the raw source of an email. Bytes that are not
+// UTF-8 are shown as replacement characters.
+func (c *EmailController) GetEmailSource(ctx context.Context, messageID uint) (*EmailSourceResponse, error) {
+	config.Logger.Debug().
+		Uint("messageID", messageID).
+		Msg("Get email source request received")
+
+	source, err := c.sourceService.Source(ctx, messageID)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to get email source")
+		return nil, err
+	}
+
+	return &EmailSourceResponse{
+		Source:        strings.ToValidUTF8(string(source.Data), "\uFFFD"),
+		Size:          len(source.Data),
+		Reconstructed: source.Reconstructed,
+	}, nil
+}
+
+// mapPaginatedEmailsToResponse converts a page of EmailDTOs to a ListEmailsResponse
+func mapPaginatedEmailsToResponse(result *services.PaginatedEmailsResult) *ListEmailsResponse {
+	response := &ListEmailsResponse{
+		Emails:     make([]EmailResponse, 0, len(result.Emails)),
+		TotalCount: result.TotalCount,
+		Page:       result.Page,
+		PageSize:   result.PageSize,
+		TotalPages: result.TotalPages,
+	}
+
+	// Convert service DTOs to response format
+	for _, email := range result.Emails {
+		// Listings never load remote images
+		body := services.RenderBody(email, services.RenderOptions{})
+		response.Emails = append(response.Emails, mapEmailToResponse(email, body))
+	}
+	return response
+}
+
+// mapEmailToResponse converts an EmailDTO and its rendered body to an
+// EmailResponse
+func mapEmailToResponse(email *services.EmailDTO, body services.RenderedBody) EmailResponse {
+	var subject string
 	if email.Message.Subject != nil {
 		subject = *email.Message.Subject
 	}
-	if email.Message.Body != nil {
-		body = *email.Message.Body
-	}
 
 	// Format received date as ISO string or empty if nil
 	receivedAt := ""
@@ -172,17 +615,84 @@ func mapEmailToResponse(email *services.EmailDTO) EmailResponse {
 		senderName = *email.Message.SenderName
 	}
 
+	labels := email.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+
+	// Map the phishing analysis, if the email was analyzed yet
+	risk := RiskResponse{Level: services.RiskLevelNone, Reasons: []RiskReasonResponse{}}
+	if email.Risk != nil {
+		risk.Score = email.Risk.Score
+		risk.Level = services.RiskLevel(email.Risk.Score)
+		for _, reason := range email.Risk.Reasons {
+			risk.Reasons = append(risk.Reasons, RiskReasonResponse{Code: reason.Code, Detail: reason.Detail})
+		}
+	}
+
+	var authentication AuthenticationResponse
+	if auth := email.Authentication; auth != nil {
+		authentication = AuthenticationResponse{
+			SenderStatus: auth.SenderStatus,
+			DKIM:         auth.DKIM,
+			DKIMDomain:   auth.DKIMDomain,
+			SPF:          auth.SPF,
+			DMARC:        auth.DMARC,
+			ARC:          auth.ARC,
+		}
+	}
+
 	return EmailResponse{
-		ID:          email.Message.ID,
-		AccountID:   email.Message.AccountID,
-		Subject:     subject,
-		Body:        body,
-		SenderName:  senderName,
-		SenderEmail: email.Message.SenderEmail,
-		ReceivedAt:  receivedAt,
-		IsRead:      email.Message.IsRead,
-		Importance:  string(email.Message.Importance),
-		Recipients:  recipients,
-		Attachments: attachments,
+		ID:            email.Message.ID,
+		AccountID:     email.Message.AccountID,
+		AccountEmail:  email.Message.Account.Email,
+		AccountType:   email.Message.Account.AccountType,
+		Subject:       subject,
+		Body:          body.HTML,
+		Text:          body.Text,
+		SenderName:    senderName,
+		SenderEmail:   email.Message.SenderEmail,
+		ReceivedAt:    receivedAt,
+		IsRead:        email.Message.IsRead,
+		IsFlagged:     email.Message.IsFlagged,
+		Importance:    string(email.Message.Importance),
+		Folder:        email.Message.Folder,
+		MailingListID: email.Message.MailingListID,
+		Category:      string(email.Message.Category),
+		Labels:        labels,
+		Recipients:    recipients,
+		Attachments:   attachments,
+		RemoteContent: RemoteContentResponse{
+			Blocked:        body.Remote.Blocked,
+			TrackingPixels: body.Remote.TrackingPixels,
+			TrackedLinks:   body.Remote.TrackedLinks,
+			Allowed:        body.Remote.Allowed,
+		},
+		Risk:           risk,
+		Authentication: authentication,
+	}
+}
+
+// mapSMIMEStatus converts the S/MIME status of an email to its response
+func mapSMIMEStatus(status *services.SMIMEStatus) SMIMEResponse {
+	response := SMIMEResponse{
+		Encrypted:     status.Encrypted,
+		Decrypted:     status.Decrypted,
+		Signature:     string(status.Signature),
+		SignerSubject: status.SignerSubject,
+		SignerEmails:  status.SignerEmails,
+		SignerIssuer:  status.SignerIssuer,
+		Trusted:       status.Trusted,
+		SignerMatches: status.SignerMatches,
+	}
+	if status.DecryptError != nil {
+		response.DecryptError = status.DecryptError.Error()
+	}
+	if !status.SignerExpires.IsZero() {
+		response.SignerExpires = status.SignerExpires.Format(time.RFC3339)
+	}
+	if status.TrustError != nil {
+		response.TrustError = status.TrustError.Error()
 	}
+	return response
 }
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+	"time"
+)
+
+// FollowUpController handles requests to be reminded of sent emails no
+// one replied to
+type FollowUpController struct {
+	followUpService *services.FollowUpService
+}
+
+// NewFollowUpController creates a new follow-up controller
+func NewFollowUpController(followUpService *services.FollowUpService) *FollowUpController {
+	config.Logger.Debug().Msg("Initializing follow-up controller")
+	return &FollowUpController{followUpService: followUpService}
+}
+
+// FollowUpResponse is a sent email awaiting a reply
+type FollowUpResponse struct {
+	EmailID   uint   `json:"emailId"`
+	AccountID uint   `json:"accountId,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	SentAt    string `json:"sentAt,omitempty"` // RFC 3339, in the local time zone
+	RemindAt  string `json:"remindAt"`         // RFC 3339, in the local time zone
+}
+
+// FollowUpEmail reminds the user of an email they sent if no recipient
+// replies within days of sending it. A message:follow-up event announces
+// the reminder.
+func (c *FollowUpController) FollowUpEmail(ctx context.Context, emailID uint, days int) (*FollowUpResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Int("days", days).Msg("Follow up email request received")
+
+	followUp, err := c.followUpService.FollowUp(ctx, emailID, followUpDelay(days))
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to set follow-up")
+		return nil, err
+	}
+	return &FollowUpResponse{
+		EmailID:  emailID,
+		RemindAt: followUp.RemindAt.In(time.Local).Format(time.RFC3339),
+	}, nil
+}
+
+// CancelFollowUp drops the reminder of an email
+func (c *FollowUpController) CancelFollowUp(ctx context.Context, emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("Cancel follow-up request received")
+
+	if err := c.followUpService.Cancel(ctx, emailID); err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to cancel follow-up")
+		return err
+	}
+	return nil
+}
+
+// ListFollowUps returns the sent emails of an account awaiting a reply,
+// the first reminder first
+func (c *FollowUpController) ListFollowUps(ctx context.Context, accountID uint) ([]FollowUpResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("List follow-ups request received")
+
+	followUps, err := c.followUpService.List(ctx, accountID)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to list follow-ups")
+		return nil, err
+	}
+	response := make([]FollowUpResponse, len(followUps))
+	for i, followUp := range followUps {
+		response[i] = followUpResponse(followUp)
+	}
+	return response, nil
+}
+
+// followUpResponse converts a follow-up for the frontend
+func followUpResponse(followUp *entities.FollowUp) FollowUpResponse {
+	message := &followUp.Message
+	response := FollowUpResponse{
+		EmailID:   followUp.MessageID,
+		AccountID: message.AccountID,
+		RemindAt:  followUp.RemindAt.In(time.Local).Format(time.RFC3339),
+	}
+	if message.Subject != nil {
+		response.Subject = *message.Subject
+	}
+	if message.SentDatetime != nil {
+		response.SentAt = message.SentDatetime.In(time.Local).Format(time.RFC3339)
+	}
+	return response
+}
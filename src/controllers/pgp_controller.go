@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+	"time"
+)
+
+// PGPController handles requests related to the OpenPGP keyring
+type PGPController struct {
+	pgpService *services.PGPService
+}
+
+// NewPGPController creates a new OpenPGP controller
+func NewPGPController(pgpService *services.PGPService) *PGPController {
+	config.Logger.Debug().Msg("Initializing OpenPGP controller")
+	return &PGPController{pgpService: pgpService}
+}
+
+// PGPKeyResponse represents a key of the keyring
+type PGPKeyResponse struct {
+	Fingerprint string   `json:"fingerprint"`
+	KeyID       string   `json:"keyId"`
+	UserID      string   `json:"userId"`
+	Emails      []string `json:"emails"`
+	HasSecret   bool     `json:"hasSecret"` // One of the user's own keys
+	Locked      bool     `json:"locked"`    // The secret key needs its passphrase
+	ExpiresAt   string   `json:"expiresAt,omitempty"`
+	Revoked     bool     `json:"revoked"`
+}
+
+// ImportPGPKeys adds the keys of an ASCII-armored key file to the keyring
+func (c *PGPController) ImportPGPKeys(ctx context.Context, data string) ([]PGPKeyResponse, error) {
+	config.Logger.Debug().Msg("Import OpenPGP keys request received")
+
+	keys, err := c.pgpService.ImportKeys(ctx, []byte(data))
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to import OpenPGP keys")
+		return nil, err
+	}
+	return c.mapKeys(keys), nil
+}
+
+// ExportPGPKey returns a key ASCII-armored, with its secret part if secret
+// is set
+func (c *PGPController) ExportPGPKey(ctx context.Context, fingerprint string, secret bool) (string, error) {
+	config.Logger.Debug().
+		Str("fingerprint", fingerprint).
+		Bool("secret", secret).
+		Msg("Export OpenPGP key request received")
+
+	armored, err := c.pgpService.ExportKey(ctx, fingerprint, secret)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("fingerprint", fingerprint).Msg("Failed to export OpenPGP key")
+		return "", err
+	}
+	return armored, nil
+}
+
+// ListPGPKeys returns every key of the keyring
+func (c *PGPController) ListPGPKeys(ctx context.Context) ([]PGPKeyResponse, error) {
+	keys, err := c.pgpService.ListKeys(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to list OpenPGP keys")
+		return nil, err
+	}
+	return c.mapKeys(keys), nil
+}
+
+// DeletePGPKey removes a key from the keyring
+func (c *PGPController) DeletePGPKey(ctx context.Context, fingerprint string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("Delete OpenPGP key request received")
+
+	if err := c.pgpService.DeleteKey(ctx, fingerprint); err != nil {
+		config.Logger.Error().Err(err).Str("fingerprint", fingerprint).Msg("Failed to delete OpenPGP key")
+		return err
+	}
+	return nil
+}
+
+// UnlockPGPKey makes a passphrase-protected secret key usable until the
+// application quits
+func (c *PGPController) UnlockPGPKey(ctx context.Context, fingerprint string, passphrase string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("Unlock OpenPGP key request received")
+
+	if err := c.pgpService.Unlock(ctx, fingerprint, passphrase); err != nil {
+		config.Logger.Warn().Err(err).Str("fingerprint", fingerprint).Msg("Failed to unlock OpenPGP key")
+		return err
+	}
+	return nil
+}
+
+// mapKeys converts keys to their responses
+func (c *PGPController) mapKeys(keys []*entities.PGPKey) []PGPKeyResponse {
+	response := make([]PGPKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		emails := make([]string, 0, len(key.Emails))
+		for _, email := range key.Emails {
+			emails = append(emails, email.Email)
+		}
+		expiresAt := ""
+		if key.ExpiresAt != nil {
+			expiresAt = key.ExpiresAt.Format(time.RFC3339)
+		}
+		response = append(response, PGPKeyResponse{
+			Fingerprint: key.Fingerprint,
+			KeyID:       key.KeyID,
+			UserID:      key.UserID,
+			Emails:      emails,
+			HasSecret:   key.Secret != nil,
+			Locked:      c.pgpService.IsLocked(key),
+			ExpiresAt:   expiresAt,
+			Revoked:     key.Revoked,
+		})
+	}
+	return response
+}
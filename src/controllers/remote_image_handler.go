@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"palm/src/config"
+	"palm/src/services"
+	"strconv"
+)
+
+// RemoteImageHandler serves remote images of rendered bodies to the webview
+// through the image proxy, at the URLs built by services.ImageProxy.URL
+type RemoteImageHandler struct {
+	proxy *services.ImageProxy
+}
+
+// NewRemoteImageHandler creates a new remote image handler
+func NewRemoteImageHandler(proxy *services.ImageProxy) *RemoteImageHandler {
+	config.Logger.Debug().Msg("Initializing remote image handler")
+	return &RemoteImageHandler{proxy: proxy}
+}
+
+// ServeHTTP serves GET requests for proxied images
+func (h *RemoteImageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remote, err := h.proxy.Resolve(r.URL.Path, r.URL.Query().Get("url"))
+	if err != nil || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	image, err := h.proxy.Fetch(r.Context(), remote)
+	if err != nil {
+		config.Logger.Warn().Err(err).Str("url", remote).Msg("Failed to proxy remote image")
+		status := http.StatusBadGateway
+		if errors.Is(err, services.ErrNotAnImage) {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	header.Set("Cache-Control", "private, max-age=86400")
+	header.Set("Content-Type", image.ContentType)
+	header.Set("Content-Length", strconv.Itoa(len(image.Data)))
+	w.Write(image.Data)
+}
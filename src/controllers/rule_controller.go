@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+)
+
+// RuleController handles requests to manage the rules filtering incoming
+// mail
+type RuleController struct {
+	ruleService *services.RuleService
+}
+
+// NewRuleController creates a new rule controller
+func NewRuleController(ruleService *services.RuleService) *RuleController {
+	config.Logger.Debug().Msg("Initializing rule controller")
+	return &RuleController{ruleService: ruleService}
+}
+
+// RuleConditionRequest is a condition of a rule. Field is sender,
+// recipients, subject, body, header, has-attachments or importance;
+// Operator is contains, not-contains, equals, not-equals, starts-with,
+// ends-with or matches.
+type RuleConditionRequest struct {
+	Field    string `json:"field"`
+	Header   string `json:"header,omitempty"` // Header field name, for the header field
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// RuleActionRequest is an action of a rule. Type is mark-read,
+// set-importance, label, move, delete or forward.
+type RuleActionRequest struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"` // Importance, label, folder or address
+}
+
+// RuleRequest is a rule created, updated or dry-run from the frontend
+type RuleRequest struct {
+	Name           string                 `json:"name"`
+	Enabled        bool                   `json:"enabled"`
+	MatchAll       bool                   `json:"matchAll"` // Every condition must hold, rather than any
+	StopProcessing bool                   `json:"stopProcessing"`
+	AccountID      *uint                  `json:"accountId,omitempty"` // Every account if unset
+	Conditions     []RuleConditionRequest `json:"conditions"`
+	Actions        []RuleActionRequest    `json:"actions"`
+}
+
+// RuleResponse is a stored rule
+type RuleResponse struct {
+	ID       uint `json:"id"`
+	Position int  `json:"position"`
+	RuleRequest
+}
+
+// RuleDryRunResponse lists the stored emails a rule matches
+type RuleDryRunResponse struct {
+	Emails    []EmailResponse `json:"emails"`
+	Scanned   int             `json:"scanned"`   // Emails the rule was evaluated on
+	Truncated bool            `json:"truncated"` // More emails match than are listed
+}
+
+// ListRules returns the rules in the order they run
+func (c *RuleController) ListRules(ctx context.Context) ([]RuleResponse, error) {
+	config.Logger.Debug().Msg("List rules request received")
+
+	rules, err := c.ruleService.List(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to list rules")
+		return nil, err
+	}
+	response := make([]RuleResponse, 0, len(rules))
+	for _, rule := range rules {
+		response = append(response, mapRule(rule))
+	}
+	return response, nil
+}
+
+// CreateRule adds a rule after the existing ones
+func (c *RuleController) CreateRule(ctx context.Context, request RuleRequest) (*RuleResponse, error) {
+	config.Logger.Debug().Str("name", request.Name).Msg("Create rule request received")
+
+	rule := request.toEntity()
+	if err := c.ruleService.Create(ctx, rule); err != nil {
+		config.Logger.Error().Err(err).Str("name", request.Name).Msg("Failed to create rule")
+		return nil, err
+	}
+	response := mapRule(rule)
+	return &response, nil
+}
+
+// UpdateRule replaces a rule, keeping its place in the order
+func (c *RuleController) UpdateRule(ctx context.Context, ruleID uint, request RuleRequest) (*RuleResponse, error) {
+	config.Logger.Debug().Uint("ruleID", ruleID).Msg("Update rule request received")
+
+	rule := request.toEntity()
+	rule.ID = ruleID
+	if err := c.ruleService.Update(ctx, rule); err != nil {
+		config.Logger.Error().Err(err).Uint("ruleID", ruleID).Msg("Failed to update rule")
+		return nil, err
+	}
+	response := mapRule(rule)
+	return &response, nil
+}
+
+// DeleteRule removes a rule
+func (c *RuleController) DeleteRule(ctx context.Context, ruleID uint) error {
+	config.Logger.Debug().Uint("ruleID", ruleID).Msg("Delete rule request received")
+
+	if err := c.ruleService.Delete(ctx, ruleID); err != nil {
+		config.Logger.Error().Err(err).Uint("ruleID", ruleID).Msg("Failed to delete rule")
+		return err
+	}
+	return nil
+}
+
+// ReorderRules sets the order rules run in; ruleIDs lists every rule once
+func (c *RuleController) ReorderRules(ctx context.Context, ruleIDs []uint) error {
+	config.Logger.Debug().Int("ruleCount", len(ruleIDs)).Msg("Reorder rules request received")
+
+	if err := c.ruleService.Reorder(ctx, ruleIDs); err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to reorder rules")
+		return err
+	}
+	return nil
+}
+
+// DryRunRule previews a rule on the stored emails without taking its
+// actions. It returns up to limit matches, newest first; a limit of 0
+// uses services.DefaultDryRunLimit.
+func (c *RuleController) DryRunRule(ctx context.Context, request RuleRequest, limit int) (*RuleDryRunResponse, error) {
+	config.Logger.Debug().Str("name", request.Name).Int("limit", limit).Msg("Dry run rule request received")
+
+	result, err := c.ruleService.DryRun(ctx, request.toEntity(), limit)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("name", request.Name).Msg("Failed to dry run rule")
+		return nil, err
+	}
+
+	response := &RuleDryRunResponse{
+		Emails:    make([]EmailResponse, 0, len(result.Matches)),
+		Scanned:   result.Scanned,
+		Truncated: result.Truncated,
+	}
+	for _, email := range result.Matches {
+		body := services.RenderBody(email, services.RenderOptions{})
+		response.Emails = append(response.Emails, mapEmailToResponse(email, body))
+	}
+	return response, nil
+}
+
+// toEntity converts the request to a rule
+func (r RuleRequest) toEntity() *entities.Rule {
+	rule := &entities.Rule{
+		Name:           r.Name,
+		Enabled:        r.Enabled,
+		MatchAll:       r.MatchAll,
+		StopProcessing: r.StopProcessing,
+		AccountID:      r.AccountID,
+		Conditions:     make([]entities.RuleCondition, len(r.Conditions)),
+		Actions:        make([]entities.RuleAction, len(r.Actions)),
+	}
+	for i, c := range r.Conditions {
+		rule.Conditions[i] = entities.RuleCondition(c)
+	}
+	for i, a := range r.Actions {
+		rule.Actions[i] = entities.RuleAction(a)
+	}
+	return rule
+}
+
+// mapRule converts a rule to its response
+func mapRule(rule *entities.Rule) RuleResponse {
+	response := RuleResponse{
+		ID:       rule.ID,
+		Position: rule.Position,
+		RuleRequest: RuleRequest{
+			Name:           rule.Name,
+			Enabled:        rule.Enabled,
+			MatchAll:       rule.MatchAll,
+			StopProcessing: rule.StopProcessing,
+			AccountID:      rule.AccountID,
+			Conditions:     make([]RuleConditionRequest, len(rule.Conditions)),
+			Actions:        make([]RuleActionRequest, len(rule.Actions)),
+		},
+	}
+	for i, c := range rule.Conditions {
+		response.Conditions[i] = RuleConditionRequest(c)
+	}
+	for i, a := range rule.Actions {
+		response.Actions[i] = RuleActionRequest(a)
+	}
+	return response
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+	"time"
+)
+
+// SendController handles requests to send messages
+type SendController struct {
+	sendService     *services.SendService
+	sendScheduler   *services.SendScheduler
+	followUpService *services.FollowUpService
+}
+
+// NewSendController creates a new send controller
+func NewSendController(sendService *services.SendService, sendScheduler *services.SendScheduler, followUpService *services.FollowUpService) *SendController {
+	config.Logger.Debug().Msg("Initializing send controller")
+	return &SendController{sendService: sendService, sendScheduler: sendScheduler, followUpService: followUpService}
+}
+
+// SendEmailRequest is a message composed in the frontend. Addresses may
+// carry a display name, as in "Alice <alice@example.com>".
+type SendEmailRequest struct {
+	AccountID  uint     `json:"accountId"`
+	To         []string `json:"to"`
+	Cc         []string `json:"cc,omitempty"`
+	Bcc        []string `json:"bcc,omitempty"`
+	Subject    string   `json:"subject"`
+	Text       string   `json:"text"`
+	HTML       string   `json:"html,omitempty"`
+	InReplyTo  string   `json:"inReplyTo,omitempty"`
+	References []string `json:"references,omitempty"`
+	// Remind the user if no one replies within this many days, 0 for never
+	FollowUpDays int `json:"followUpDays,omitempty"`
+}
+
+// SendEmailResponse tells how a message was sent
+type SendEmailResponse struct {
+	MessageID uint     `json:"messageId"` // The stored sent copy
+	Encrypted []string `json:"encrypted"` // Recipients who received it encrypted
+	Signed    bool     `json:"signed"`
+}
+
+// ScheduledEmailResponse is a message waiting to be sent, or that failed
+type ScheduledEmailResponse struct {
+	ID         uint   `json:"id"`
+	AccountID  uint   `json:"accountId"`
+	Status     string `json:"status"` // queued, sending, deferred or failed
+	Subject    string `json:"subject"`
+	Recipients string `json:"recipients"`
+	SendAt     string `json:"sendAt"`    // RFC 3339, in the local time zone
+	UndoUntil  string `json:"undoUntil"` // RFC 3339, in the local time zone
+	Error      string `json:"error,omitempty"`
+}
+
+// SendEmail sends a message from an account
+func (c *SendController) SendEmail(ctx context.Context, request SendEmailRequest) (*SendEmailResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", request.AccountID).
+		Int("recipientCount", len(request.To)+len(request.Cc)+len(request.Bcc)).
+		Msg("Send email request received")
+
+	outgoing, err := outgoingEmail(request)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.sendService.Send(ctx, outgoing)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", request.AccountID).
+			Msg("Failed to send email")
+		return nil, err
+	}
+	// The message is gone; a reminder that cannot be set is only logged
+	if request.FollowUpDays > 0 {
+		if _, err := c.followUpService.FollowUp(ctx, result.MessageID, followUpDelay(request.FollowUpDays)); err != nil {
+			config.Logger.Error().Err(err).Uint("messageID", result.MessageID).Msg("Failed to set follow-up")
+		}
+	}
+
+	encrypted := result.Encrypted
+	if encrypted == nil {
+		encrypted = []string{}
+	}
+	return &SendEmailResponse{
+		MessageID: result.MessageID,
+		Encrypted: encrypted,
+		Signed:    result.Signed,
+	}, nil
+}
+
+// ScheduleEmail queues a message to be sent at an RFC 3339 time, or now
+// if sendAt is empty. Sending can be undone for undoSeconds, and until
+// the time for messages the account's provider cannot hold; outbox:updated
+// events report what becomes of the message.
+func (c *SendController) ScheduleEmail(ctx context.Context, request SendEmailRequest, sendAt string, undoSeconds int) (*ScheduledEmailResponse, error) {
+	config.Logger.Debug().
+		Uint("accountID", request.AccountID).
+		Str("sendAt", sendAt).
+		Int("undoSeconds", undoSeconds).
+		Msg("Schedule email request received")
+
+	var at time.Time
+	if sendAt != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, sendAt); err != nil {
+			return nil, fmt.Errorf("invalid send time %q: expected RFC 3339", sendAt)
+		}
+	}
+	outgoing, err := outgoingEmail(request)
+	if err != nil {
+		return nil, err
+	}
+	message, err := c.sendScheduler.Schedule(ctx, outgoing, at, time.Duration(undoSeconds)*time.Second,
+		followUpDelay(request.FollowUpDays))
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", request.AccountID).
+			Msg("Failed to schedule email")
+		return nil, err
+	}
+	return scheduledEmailResponse(message), nil
+}
+
+// CancelScheduledEmail keeps a queued message from being sent and returns
+// it, so that the composer can be opened on it again
+func (c *SendController) CancelScheduledEmail(ctx context.Context, id uint) (*SendEmailRequest, error) {
+	config.Logger.Debug().Uint("outboxID", id).Msg("Cancel scheduled email request received")
+
+	outgoing, err := c.sendScheduler.Cancel(ctx, id)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("outboxID", id).Msg("Failed to cancel scheduled email")
+		return nil, err
+	}
+	request := &SendEmailRequest{
+		AccountID:  outgoing.AccountID,
+		To:         []string{},
+		Subject:    outgoing.Subject,
+		Text:       outgoing.Text,
+		HTML:       outgoing.HTML,
+		InReplyTo:  outgoing.InReplyTo,
+		References: outgoing.References,
+	}
+	for _, field := range []struct {
+		list []*mail.Address
+		dst  *[]string
+	}{
+		{outgoing.To, &request.To},
+		{outgoing.Cc, &request.Cc},
+		{outgoing.Bcc, &request.Bcc},
+	} {
+		for _, addr := range field.list {
+			*field.dst = append(*field.dst, addr.String())
+		}
+	}
+	return request, nil
+}
+
+// RetryScheduledEmail queues a message that failed to send to be sent now
+func (c *SendController) RetryScheduledEmail(ctx context.Context, id uint) (*ScheduledEmailResponse, error) {
+	config.Logger.Debug().Uint("outboxID", id).Msg("Retry scheduled email request received")
+
+	message, err := c.sendScheduler.Retry(ctx, id)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("outboxID", id).Msg("Failed to retry scheduled email")
+		return nil, err
+	}
+	return scheduledEmailResponse(message), nil
+}
+
+// ListScheduledEmails returns the messages of an account waiting to be
+// sent or that failed, the first to go out first
+func (c *SendController) ListScheduledEmails(ctx context.Context, accountID uint) ([]ScheduledEmailResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("List scheduled emails request received")
+
+	messages, err := c.sendScheduler.List(ctx, accountID)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to list scheduled emails")
+		return nil, err
+	}
+	responses := make([]ScheduledEmailResponse, len(messages))
+	for i, message := range messages {
+		responses[i] = *scheduledEmailResponse(message)
+	}
+	return responses, nil
+}
+
+// scheduledEmailResponse converts an outbox message for the frontend
+func scheduledEmailResponse(message *entities.OutboxMessage) *ScheduledEmailResponse {
+	return &ScheduledEmailResponse{
+		ID:         message.ID,
+		AccountID:  message.AccountID,
+		Status:     message.Status,
+		Subject:    message.Subject,
+		Recipients: message.Recipients,
+		SendAt:     message.SendAt.In(time.Local).Format(time.RFC3339),
+		UndoUntil:  message.UndoUntil.In(time.Local).Format(time.RFC3339),
+		Error:      message.Error,
+	}
+}
+
+// outgoingEmail converts a message composed in the frontend
+func outgoingEmail(request SendEmailRequest) (*services.OutgoingEmail, error) {
+	if request.FollowUpDays < 0 {
+		return nil, services.ErrInvalidFollowUpDelay
+	}
+	outgoing := &services.OutgoingEmail{
+		AccountID:  request.AccountID,
+		Subject:    request.Subject,
+		Text:       request.Text,
+		HTML:       request.HTML,
+		InReplyTo:  request.InReplyTo,
+		References: request.References,
+	}
+	for _, field := range []struct {
+		list []string
+		dst  *[]*mail.Address
+	}{
+		{request.To, &outgoing.To},
+		{request.Cc, &outgoing.Cc},
+		{request.Bcc, &outgoing.Bcc},
+	} {
+		for _, value := range field.list {
+			addr, err := mail.ParseAddress(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", services.ErrInvalidRecipient, value)
+			}
+			*field.dst = append(*field.dst, addr)
+		}
+	}
+	return outgoing, nil
+}
+
+// followUpDelay converts a number of days to wait for a reply
+func followUpDelay(days int) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
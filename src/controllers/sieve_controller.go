@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/formats/managesieve"
+	"palm/src/formats/sieve"
+	"palm/src/services"
+)
+
+// SieveController handles requests to manage the Sieve scripts filtering
+// incoming mail
+type SieveController struct {
+	sieveService *services.SieveService
+}
+
+// NewSieveController creates a new sieve controller
+func NewSieveController(sieveService *services.SieveService) *SieveController {
+	config.Logger.Debug().Msg("Initializing sieve controller")
+	return &SieveController{sieveService: sieveService}
+}
+
+// SieveScriptRequest is a script imported from the frontend
+type SieveScriptRequest struct {
+	Name      string `json:"name"`
+	Source    string `json:"source"`
+	Active    bool   `json:"active"`
+	AccountID *uint  `json:"accountId,omitempty"` // Every account without a script of its own if unset
+}
+
+// SieveScriptResponse is a stored script
+type SieveScriptResponse struct {
+	ID uint `json:"id"`
+	SieveScriptRequest
+}
+
+// SieveActionResponse is an action a script takes on a message. Type is
+// keep, fileinto, redirect, discard or vacation.
+type SieveActionResponse struct {
+	Type     string   `json:"type"`
+	Value    string   `json:"value,omitempty"` // Mailbox, address or vacation reason
+	Flags    []string `json:"flags,omitempty"`
+	Copy     bool     `json:"copy,omitempty"`
+	Implicit bool     `json:"implicit,omitempty"` // A keep no action cancelled
+}
+
+// ManageSieveRequest is the ManageSieve server a script is uploaded to.
+// Security is starttls, tls or none.
+type ManageSieveRequest struct {
+	Address  string `json:"address"` // host or host:port
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Security string `json:"security,omitempty"`
+}
+
+// ListSieveScripts returns the stored scripts
+func (c *SieveController) ListSieveScripts(ctx context.Context) ([]SieveScriptResponse, error) {
+	config.Logger.Debug().Msg("List sieve scripts request received")
+
+	scripts, err := c.sieveService.List(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to list sieve scripts")
+		return nil, err
+	}
+	response := make([]SieveScriptResponse, 0, len(scripts))
+	for _, script := range scripts {
+		response = append(response, mapSieveScript(script))
+	}
+	return response, nil
+}
+
+// ImportSieveScript stores a script, replacing the one of the same name
+// and account
+func (c *SieveController) ImportSieveScript(ctx context.Context, request SieveScriptRequest) (*SieveScriptResponse, error) {
+	config.Logger.Debug().Str("name", request.Name).Msg("Import sieve script request received")
+
+	script := &entities.SieveScript{
+		Name:      request.Name,
+		Source:    request.Source,
+		Active:    request.Active,
+		AccountID: request.AccountID,
+	}
+	if err := c.sieveService.Import(ctx, script); err != nil {
+		config.Logger.Error().Err(err).Str("name", request.Name).Msg("Failed to import sieve script")
+		return nil, err
+	}
+	response := mapSieveScript(script)
+	return &response, nil
+}
+
+// SetSieveScriptActive activates or deactivates a script. Activating a
+// script deactivates the other scripts of its account.
+func (c *SieveController) SetSieveScriptActive(ctx context.Context, scriptID uint, active bool) error {
+	config.Logger.Debug().Uint("scriptID", scriptID).Bool("active", active).Msg("Set sieve script active request received")
+
+	if err := c.sieveService.SetActive(ctx, scriptID, active); err != nil {
+		config.Logger.Error().Err(err).Uint("scriptID", scriptID).Msg("Failed to set sieve script active")
+		return err
+	}
+	return nil
+}
+
+// DeleteSieveScript removes a script
+func (c *SieveController) DeleteSieveScript(ctx context.Context, scriptID uint) error {
+	config.Logger.Debug().Uint("scriptID", scriptID).Msg("Delete sieve script request received")
+
+	if err := c.sieveService.Delete(ctx, scriptID); err != nil {
+		config.Logger.Error().Err(err).Uint("scriptID", scriptID).Msg("Failed to delete sieve script")
+		return err
+	}
+	return nil
+}
+
+// TestSieveScript runs a script on a stored email without taking its
+// actions, and returns the actions it would take
+func (c *SieveController) TestSieveScript(ctx context.Context, source string, emailID uint) ([]SieveActionResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("Test sieve script request received")
+
+	result, err := c.sieveService.Evaluate(ctx, source, emailID)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to test sieve script")
+		return nil, err
+	}
+	response := make([]SieveActionResponse, 0, len(result.Actions))
+	for _, action := range result.Actions {
+		response = append(response, mapSieveAction(action))
+	}
+	return response, nil
+}
+
+// UploadSieveScript stores a script on a ManageSieve server, activating
+// it there if it is active
+func (c *SieveController) UploadSieveScript(ctx context.Context, scriptID uint, server ManageSieveRequest) error {
+	config.Logger.Debug().Uint("scriptID", scriptID).Str("server", server.Address).Msg("Upload sieve script request received")
+
+	err := c.sieveService.Upload(ctx, scriptID, services.ManageSieveServer{
+		Address:  server.Address,
+		Username: server.Username,
+		Password: server.Password,
+		Security: managesieve.Security(server.Security),
+	})
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("scriptID", scriptID).Str("server", server.Address).Msg("Failed to upload sieve script")
+		return err
+	}
+	return nil
+}
+
+// mapSieveScript converts a script to its response
+func mapSieveScript(script *entities.SieveScript) SieveScriptResponse {
+	return SieveScriptResponse{
+		ID: script.ID,
+		SieveScriptRequest: SieveScriptRequest{
+			Name:      script.Name,
+			Source:    script.Source,
+			Active:    script.Active,
+			AccountID: script.AccountID,
+		},
+	}
+}
+
+// mapSieveAction converts an action of a script to its response
+func mapSieveAction(action sieve.Action) SieveActionResponse {
+	switch a := action.(type) {
+	case sieve.Keep:
+		return SieveActionResponse{Type: "keep", Flags: a.Flags, Implicit: a.Implicit}
+	case sieve.FileInto:
+		return SieveActionResponse{Type: "fileinto", Value: a.Mailbox, Flags: a.Flags, Copy: a.Copy}
+	case sieve.Redirect:
+		return SieveActionResponse{Type: "redirect", Value: a.Address, Copy: a.Copy}
+	case sieve.Vacation:
+		return SieveActionResponse{Type: "vacation", Value: a.Reason}
+	}
+	return SieveActionResponse{Type: "discard"}
+}
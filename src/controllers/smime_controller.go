@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+	"time"
+)
+
+// SMIMEController handles requests related to the S/MIME certificate store
+type SMIMEController struct {
+	smimeService *services.SMIMEService
+}
+
+// NewSMIMEController creates a new S/MIME controller
+func NewSMIMEController(smimeService *services.SMIMEService) *SMIMEController {
+	config.Logger.Debug().Msg("Initializing S/MIME controller")
+	return &SMIMEController{smimeService: smimeService}
+}
+
+// SMIMECertificateResponse represents a certificate of the store
+type SMIMECertificateResponse struct {
+	Fingerprint string   `json:"fingerprint"`
+	Subject     string   `json:"subject"`
+	Issuer      string   `json:"issuer"`
+	Emails      []string `json:"emails"`
+	NotBefore   string   `json:"notBefore"` // RFC 3339
+	NotAfter    string   `json:"notAfter"`  // RFC 3339
+	IsCA        bool     `json:"isCa"`
+	TrustAnchor bool     `json:"trustAnchor"` // Trusted as a root
+	IsIdentity  bool     `json:"isIdentity"`  // One of the user's own, with its private key
+	Locked      bool     `json:"locked"`      // The identity needs its password
+}
+
+// ImportSMIMECertificateFile adds the certificates of a PEM or DER file to
+// the store
+func (c *SMIMEController) ImportSMIMECertificateFile(ctx context.Context, path string) ([]SMIMECertificateResponse, error) {
+	config.Logger.Debug().Str("path", path).Msg("Import S/MIME certificates request received")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to read certificate file")
+		return nil, err
+	}
+	records, err := c.smimeService.ImportCertificates(ctx, data)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to import S/MIME certificates")
+		return nil, err
+	}
+	return c.mapCertificates(records), nil
+}
+
+// ImportSMIMEIdentityFile adds one of the user's identities from a
+// PKCS#12 (.p12 or .pfx) file
+func (c *SMIMEController) ImportSMIMEIdentityFile(ctx context.Context, path string, password string) (*SMIMECertificateResponse, error) {
+	config.Logger.Debug().Str("path", path).Msg("Import S/MIME identity request received")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("path", path).Msg("Failed to read identity file")
+		return nil, err
+	}
+	record, err := c.smimeService.ImportIdentity(ctx, data, password)
+	if err != nil {
+		config.Logger.Warn().Err(err).Str("path", path).Msg("Failed to import S/MIME identity")
+		return nil, err
+	}
+	response := c.mapCertificates([]*entities.SMIMECertificate{record})[0]
+	return &response, nil
+}
+
+// ExportSMIMECertificate returns a certificate PEM-encoded
+func (c *SMIMEController) ExportSMIMECertificate(ctx context.Context, fingerprint string) (string, error) {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("Export S/MIME certificate request received")
+
+	certificate, err := c.smimeService.ExportCertificate(ctx, fingerprint)
+	if err != nil {
+		config.Logger.Error().Err(err).Str("fingerprint", fingerprint).Msg("Failed to export S/MIME certificate")
+		return "", err
+	}
+	return certificate, nil
+}
+
+// ListSMIMECertificates returns every certificate of the store
+func (c *SMIMEController) ListSMIMECertificates(ctx context.Context) ([]SMIMECertificateResponse, error) {
+	records, err := c.smimeService.ListCertificates(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to list S/MIME certificates")
+		return nil, err
+	}
+	return c.mapCertificates(records), nil
+}
+
+// DeleteSMIMECertificate removes a certificate from the store
+func (c *SMIMEController) DeleteSMIMECertificate(ctx context.Context, fingerprint string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("Delete S/MIME certificate request received")
+
+	if err := c.smimeService.DeleteCertificate(ctx, fingerprint); err != nil {
+		config.Logger.Error().Err(err).Str("fingerprint", fingerprint).Msg("Failed to delete S/MIME certificate")
+		return err
+	}
+	return nil
+}
+
+// SetSMIMETrustAnchor adds a certificate to the trust store, or removes it
+func (c *SMIMEController) SetSMIMETrustAnchor(ctx context.Context, fingerprint string, trusted bool) error {
+	config.Logger.Debug().
+		Str("fingerprint", fingerprint).
+		Bool("trusted", trusted).
+		Msg("Set S/MIME trust anchor request received")
+
+	if err := c.smimeService.SetTrustAnchor(ctx, fingerprint, trusted); err != nil {
+		config.Logger.Error().Err(err).Str("fingerprint", fingerprint).Msg("Failed to update S/MIME trust anchor")
+		return err
+	}
+	return nil
+}
+
+// UnlockSMIMEIdentity makes a password-protected identity usable until the
+// application quits
+func (c *SMIMEController) UnlockSMIMEIdentity(ctx context.Context, fingerprint string, password string) error {
+	config.Logger.Debug().Str("fingerprint", fingerprint).Msg("Unlock S/MIME identity request received")
+
+	if err := c.smimeService.Unlock(ctx, fingerprint, password); err != nil {
+		config.Logger.Warn().Err(err).Str("fingerprint", fingerprint).Msg("Failed to unlock S/MIME identity")
+		return err
+	}
+	return nil
+}
+
+// mapCertificates converts certificates to their responses
+func (c *SMIMEController) mapCertificates(records []*entities.SMIMECertificate) []SMIMECertificateResponse {
+	response := make([]SMIMECertificateResponse, 0, len(records))
+	for _, record := range records {
+		emails := make([]string, 0, len(record.Emails))
+		for _, email := range record.Emails {
+			emails = append(emails, email.Email)
+		}
+		response = append(response, SMIMECertificateResponse{
+			Fingerprint: record.Fingerprint,
+			Subject:     record.Subject,
+			Issuer:      record.Issuer,
+			Emails:      emails,
+			NotBefore:   record.NotBefore.Format(time.RFC3339),
+			NotAfter:    record.NotAfter.Format(time.RFC3339),
+			IsCA:        record.IsCA,
+			TrustAnchor: record.TrustAnchor,
+			IsIdentity:  record.PKCS12 != nil,
+			Locked:      c.smimeService.IsLocked(record),
+		})
+	}
+	return response
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/services"
+	"time"
+)
+
+// SnoozeController handles requests to hide emails until a later time
+type SnoozeController struct {
+	snoozeService *services.SnoozeService
+}
+
+// NewSnoozeController creates a new snooze controller
+func NewSnoozeController(snoozeService *services.SnoozeService) *SnoozeController {
+	config.Logger.Debug().Msg("Initializing snooze controller")
+	return &SnoozeController{snoozeService: snoozeService}
+}
+
+// SnoozeResponse is a snoozed email and when it returns
+type SnoozeResponse struct {
+	EmailID     uint   `json:"emailId"`
+	AccountID   uint   `json:"accountId,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	SenderEmail string `json:"senderEmail,omitempty"`
+	Until       string `json:"until"`  // RFC 3339, in the local time zone
+	Folder      string `json:"folder"` // Folder the email returns to, "" being the inbox
+}
+
+// SnoozePresetResponse is a time offered for snoozing
+type SnoozePresetResponse struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Until string `json:"until"` // RFC 3339, in the local time zone
+}
+
+// SnoozeEmail hides an email until an RFC 3339 time, or the time of a
+// preset such as tomorrow-morning. The email then returns unread.
+func (c *SnoozeController) SnoozeEmail(ctx context.Context, emailID uint, until string) (*SnoozeResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Str("until", until).Msg("Snooze email request received")
+
+	wakeAt, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		wakeAt, err = services.SnoozePresetTime(until, time.Now(), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snooze time %q: expected RFC 3339 or a preset", until)
+		}
+	}
+	snooze, err := c.snoozeService.Snooze(ctx, emailID, wakeAt)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to snooze email")
+		return nil, err
+	}
+	response := mapSnoozeToResponse(snooze)
+	return &response, nil
+}
+
+// UnsnoozeEmail returns a snoozed email to its folder now
+func (c *SnoozeController) UnsnoozeEmail(ctx context.Context, emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("Unsnooze email request received")
+
+	if err := c.snoozeService.Unsnooze(ctx, emailID); err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to unsnooze email")
+		return err
+	}
+	return nil
+}
+
+// ListSnoozedEmails returns the snoozed emails of an account, the first
+// to return first
+func (c *SnoozeController) ListSnoozedEmails(ctx context.Context, accountID uint) ([]SnoozeResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("List snoozed emails request received")
+
+	snoozes, err := c.snoozeService.List(ctx, accountID)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to list snoozed emails")
+		return nil, err
+	}
+	response := make([]SnoozeResponse, len(snoozes))
+	for i, snooze := range snoozes {
+		response[i] = mapSnoozeToResponse(snooze)
+	}
+	return response, nil
+}
+
+func mapSnoozeToResponse(snooze *entities.Snooze) SnoozeResponse {
+	subject := ""
+	if snooze.Message.Subject != nil {
+		subject = *snooze.Message.Subject
+	}
+	return SnoozeResponse{
+		EmailID:     snooze.MessageID,
+		AccountID:   snooze.Message.AccountID,
+		Subject:     subject,
+		SenderEmail: snooze.Message.SenderEmail,
+		Until:       snooze.WakeAt.In(time.Local).Format(time.RFC3339),
+		Folder:      snooze.Folder,
+	}
+}
+
+// GetSnoozePresets returns the snooze times offered now, computed in the
+// local time zone
+func (c *SnoozeController) GetSnoozePresets() []SnoozePresetResponse {
+	presets := services.SnoozePresets(time.Now(), time.Local)
+	response := make([]SnoozePresetResponse, len(presets))
+	for i, preset := range presets {
+		response[i] = SnoozePresetResponse{
+			Name:  preset.Name,
+			Label: preset.Label,
+			Until: preset.Until.Format(time.RFC3339),
+		}
+	}
+	return response
+}
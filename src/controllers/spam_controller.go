@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/services"
+)
+
+// SpamController handles requests to train the spam filter and set how
+// eagerly it moves messages aside
+type SpamController struct {
+	spamFilter *services.SpamFilter
+}
+
+// NewSpamController creates a new spam controller
+func NewSpamController(spamFilter *services.SpamFilter) *SpamController {
+	config.Logger.Debug().Msg("Initializing spam controller")
+	return &SpamController{spamFilter: spamFilter}
+}
+
+// SpamStatusResponse describes the training of the spam filter
+type SpamStatusResponse struct {
+	SpamMessages int     `json:"spamMessages"`
+	HamMessages  int     `json:"hamMessages"` // Messages trained as not spam
+	Tokens       int64   `json:"tokens"`
+	Threshold    float64 `json:"threshold"` // Score from 0 to 1 from which messages are moved aside
+	Ready        bool    `json:"ready"`     // Enough messages are trained for new ones to be scored
+	Folder       string  `json:"folder"`    // Folder likely spam is moved to
+}
+
+// GetSpamStatus returns the training of the spam filter
+func (c *SpamController) GetSpamStatus(ctx context.Context) (*SpamStatusResponse, error) {
+	config.Logger.Debug().Msg("Get spam status request received")
+
+	status, err := c.spamFilter.Status(ctx)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to get spam status")
+		return nil, err
+	}
+	return &SpamStatusResponse{
+		SpamMessages: status.SpamMessages,
+		HamMessages:  status.HamMessages,
+		Tokens:       status.Tokens,
+		Threshold:    status.Threshold,
+		Ready:        status.Ready,
+		Folder:       services.SpamFolder,
+	}, nil
+}
+
+// MarkSpam trains the filter with an email as spam and moves it aside
+func (c *SpamController) MarkSpam(ctx context.Context, emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("Mark spam request received")
+
+	if err := c.spamFilter.Train(ctx, emailID, true); err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to mark email as spam")
+		return err
+	}
+	return nil
+}
+
+// MarkNotSpam trains the filter with an email as wanted and moves it
+// back to the inbox if it was set aside
+func (c *SpamController) MarkNotSpam(ctx context.Context, emailID uint) error {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("Mark not spam request received")
+
+	if err := c.spamFilter.Train(ctx, emailID, false); err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to mark email as not spam")
+		return err
+	}
+	return nil
+}
+
+// SetSpamThreshold sets the score, above 0 and at most 1, from which
+// messages are moved aside
+func (c *SpamController) SetSpamThreshold(ctx context.Context, threshold float64) error {
+	config.Logger.Debug().Float64("threshold", threshold).Msg("Set spam threshold request received")
+
+	if err := c.spamFilter.SetThreshold(ctx, threshold); err != nil {
+		config.Logger.Error().Err(err).Float64("threshold", threshold).Msg("Failed to set spam threshold")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"palm/src/config"
+	"palm/src/services"
+	"time"
+)
+
+// SubscriptionController handles requests about the mailing lists and
+// newsletters an account receives
+type SubscriptionController struct {
+	subscriptionService *services.SubscriptionService
+}
+
+// NewSubscriptionController creates a new subscription controller
+func NewSubscriptionController(subscriptionService *services.SubscriptionService) *SubscriptionController {
+	config.Logger.Debug().Msg("Initializing subscription controller")
+	return &SubscriptionController{subscriptionService: subscriptionService}
+}
+
+// SubscriptionResponse is a mailing list with statistics on its emails.
+// Emails of the list are listed with the mailingListId option of
+// ListEmails.
+type SubscriptionResponse struct {
+	ID             uint   `json:"id"`
+	ListID         string `json:"listId"` // List-Id identifier, or the sender's address
+	Name           string `json:"name"`
+	SenderEmail    string `json:"senderEmail"`
+	Messages       int    `json:"messages"`
+	Unread         int    `json:"unread"`
+	LastReceivedAt string `json:"lastReceivedAt,omitempty"` // RFC 3339
+	LastReadAt     string `json:"lastReadAt,omitempty"`     // RFC 3339; receipt time of the newest email read
+	CanUnsubscribe bool   `json:"canUnsubscribe"`
+	OneClick       bool   `json:"oneClick"`                 // Unsubscribing needs no further step
+	UnsubscribedAt string `json:"unsubscribedAt,omitempty"` // RFC 3339
+}
+
+// UnsubscribeResponse tells how the user was unsubscribed. Method is
+// one-click or mailto when done, or web when URL has to be opened.
+type UnsubscribeResponse struct {
+	MailingListID uint   `json:"mailingListId"`
+	Method        string `json:"method"`
+	URL           string `json:"url,omitempty"`
+}
+
+// ListSubscriptions returns the mailing lists an account receives, the
+// most prolific first
+func (c *SubscriptionController) ListSubscriptions(ctx context.Context, accountID uint) ([]SubscriptionResponse, error) {
+	config.Logger.Debug().Uint("accountID", accountID).Msg("List subscriptions request received")
+
+	subscriptions, err := c.subscriptionService.List(ctx, accountID)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("accountID", accountID).Msg("Failed to list subscriptions")
+		return nil, err
+	}
+	response := make([]SubscriptionResponse, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		list := subscription.List
+		response = append(response, SubscriptionResponse{
+			ID:             list.ID,
+			ListID:         list.ListID,
+			Name:           list.Name,
+			SenderEmail:    list.SenderEmail,
+			Messages:       subscription.Messages,
+			Unread:         subscription.Unread,
+			LastReceivedAt: formatOptionalTime(subscription.LastReceived),
+			LastReadAt:     formatOptionalTime(subscription.LastRead),
+			CanUnsubscribe: list.UnsubscribeURL != "" || list.UnsubscribeMailto != "",
+			OneClick:       list.OneClick,
+			UnsubscribedAt: formatOptionalTime(list.UnsubscribedAt),
+		})
+	}
+	return response, nil
+}
+
+// Unsubscribe unsubscribes from the mailing list an email came from
+func (c *SubscriptionController) Unsubscribe(ctx context.Context, emailID uint) (*UnsubscribeResponse, error) {
+	config.Logger.Debug().Uint("emailID", emailID).Msg("Unsubscribe request received")
+
+	result, err := c.subscriptionService.Unsubscribe(ctx, emailID)
+	if err != nil {
+		config.Logger.Error().Err(err).Uint("emailID", emailID).Msg("Failed to unsubscribe")
+		return nil, err
+	}
+	return &UnsubscribeResponse{
+		MailingListID: result.List.ID,
+		Method:        result.Method,
+		URL:           result.URL,
+	}, nil
+}
+
+// formatOptionalTime formats a time as RFC 3339, or returns "" for nil
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -5,11 +5,16 @@ import "gorm.io/gorm"
 const (
 	AccountTypeMicrosoft = "Microsoft"
 	AccountTypeGoogle    = "Google"
+	AccountTypeMaildir   = "Local Maildir"
 )
 
 type Account struct {
 	gorm.Model
-	Email       string    `json:"email" gorm:"unique;not null"`
-	AccountType string    `json:"account_type" gorm:"not null"`
-	Messages    []Message `json:"messages,omitempty"`
+	Email       string  `json:"email" gorm:"unique;not null"`
+	AccountType string  `json:"account_type" gorm:"not null"`
+	MaildirPath *string `json:"maildir_path,omitempty"` // Root of a Local Maildir account
+	// TrustedAuthServIDs are the authserv-ids of the servers whose
+	// Authentication-Results fields are believed (RFC 8601 section 5)
+	TrustedAuthServIDs []string  `json:"trusted_authserv_ids,omitempty" gorm:"serializer:json"`
+	Messages           []Message `json:"messages,omitempty"`
 }
@@ -9,6 +9,7 @@ type Attachment struct {
 	MimeType  string  `json:"mime_type" gorm:"not null"`
 	Size      uint    `json:"size" gorm:"not null"`
 	LocalPath *string `json:"local_path,omitempty"`
-	MessageID uint    `json:"message_id"`
+	ContentID *string `json:"content_id,omitempty"` // Referenced by cid: URLs in the body
+	MessageID uint    `json:"message_id" gorm:"index"`
 	Message   Message `json:"message,omitempty"`
 }
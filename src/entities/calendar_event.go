@@ -0,0 +1,43 @@
+package entities
+
+import "time"
+
+// CalendarEvent is an event of an iCalendar (RFC 5545) object carried by a
+// message, such as a meeting invitation. Times are stored absolute; the
+// dates of all-day events are stored as midnight UTC.
+type CalendarEvent struct {
+	ID             uint               `json:"id" gorm:"primarykey"`
+	CreatedAt      time.Time          `json:"created_at"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+	Method         string             `json:"method"`                    // iTIP method of the object: REQUEST, REPLY, CANCEL or PUBLISH
+	UID            string             `json:"uid" gorm:"index;not null"` // Identifies the event across updates
+	Sequence       int                `json:"sequence" gorm:"not null"`  // Revision of the event
+	RecurrenceID   *time.Time         `json:"recurrence_id,omitempty"`   // The occurrence the event overrides (optional)
+	Status         string             `json:"status"`                    // TENTATIVE, CONFIRMED or CANCELLED
+	Summary        string             `json:"summary"`
+	Location       string             `json:"location"`
+	Description    string             `json:"description"`
+	Start          time.Time          `json:"start" gorm:"not null"`
+	End            time.Time          `json:"end" gorm:"not null"` // Exclusive
+	AllDay         bool               `json:"all_day" gorm:"not null"`
+	TimeZone       string             `json:"time_zone"`  // TZID the event was scheduled in
+	Recurrence     string             `json:"recurrence"` // RRULE value; empty for a single event
+	OrganizerEmail string             `json:"organizer_email"`
+	OrganizerName  string             `json:"organizer_name"`
+	Response       string             `json:"response"` // The user's reply: ACCEPTED, TENTATIVE or DECLINED; empty until they reply
+	RespondedAt    *time.Time         `json:"responded_at,omitempty"`
+	MessageID      uint               `json:"message_id" gorm:"index;not null"`
+	Message        Message            `json:"message,omitempty"`
+	Attendees      []CalendarAttendee `json:"attendees,omitempty"`
+}
+
+// CalendarAttendee is an attendee of a calendar event
+type CalendarAttendee struct {
+	ID              uint   `json:"id" gorm:"primarykey"`
+	Email           string `json:"email" gorm:"not null"`
+	Name            string `json:"name"`
+	Role            string `json:"role"`      // e.g. REQ-PARTICIPANT or OPT-PARTICIPANT
+	PartStat        string `json:"part_stat"` // NEEDS-ACTION, ACCEPTED, TENTATIVE or DECLINED
+	RSVP            bool   `json:"rsvp" gorm:"not null"`
+	CalendarEventID uint   `json:"calendar_event_id" gorm:"index;not null"`
+}
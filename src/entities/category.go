@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+// Category is the inbox tab a message is sorted into
+type Category string
+
+const (
+	CategoryPrimary    Category = "Primary"    // Personal mail and anything not recognized
+	CategoryUpdates    Category = "Updates"    // Receipts, notifications, alerts and mailing lists
+	CategoryPromotions Category = "Promotions" // Marketing and offers
+	CategorySocial     Category = "Social"     // Social networks
+)
+
+// Categories lists the categories in tab order
+var Categories = []Category{CategoryPrimary, CategoryUpdates, CategoryPromotions, CategorySocial}
+
+// CategoryOverride is the category the user chose for a sender's messages,
+// which takes precedence over the categorizer's heuristics
+type CategoryOverride struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	SenderEmail string    `json:"sender_email" gorm:"not null;uniqueIndex:idx_category_overrides_account_sender,priority:2"` // Lower-cased
+	Category    Category  `json:"category" gorm:"not null"`
+	AccountID   uint      `json:"account_id" gorm:"not null;uniqueIndex:idx_category_overrides_account_sender,priority:1"`
+	Account     Account   `json:"account,omitempty"`
+}
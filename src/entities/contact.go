@@ -0,0 +1,42 @@
+package entities
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Contact represents a person we correspond with, derived from message
+// senders and recipients or entered manually
+type Contact struct {
+	gorm.Model
+	DisplayName       *string        `json:"display_name,omitempty"`
+	DisplayNameLocked bool           `json:"display_name_locked" gorm:"not null"` // Set by manual edits; sync no longer renames
+	MessageCount      uint           `json:"message_count" gorm:"not null"`       // Messages the contact appeared on
+	SentCount         uint           `json:"sent_count" gorm:"not null"`          // Messages we sent to the contact
+	LastInteraction   *time.Time     `json:"last_interaction,omitempty"`
+	Organization      *string        `json:"organization,omitempty"`
+	Note              *string        `json:"note,omitempty"`
+	Photo             []byte         `json:"photo,omitempty"`
+	PhotoMediaType    *string        `json:"photo_media_type,omitempty"`
+	Emails            []ContactEmail `json:"emails,omitempty"`
+	Phones            []ContactPhone `json:"phones,omitempty"`
+}
+
+// ContactEmail is one address of a contact. Addresses are stored normalized
+// (trimmed and lower-cased) and belong to at most one contact.
+type ContactEmail struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	Email     string    `json:"email" gorm:"uniqueIndex;not null"`
+	ContactID uint      `json:"contact_id" gorm:"index;not null"`
+}
+
+// ContactPhone is one phone number of a contact
+type ContactPhone struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	Number    string    `json:"number" gorm:"not null"`
+	Type      string    `json:"type"` // e.g. work, home, cell
+	ContactID uint      `json:"contact_id" gorm:"index;not null"`
+}
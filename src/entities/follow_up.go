@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+// FollowUp reminds the user of a message they sent if no recipient has
+// replied by RemindAt. Follow-ups are deleted once a reply arrives or the
+// reminder fires.
+type FollowUp struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	RemindAt  time.Time `json:"remind_at" gorm:"not null;index"` // UTC
+	MessageID uint      `json:"message_id" gorm:"uniqueIndex;not null"`
+	Message   Message   `json:"message,omitempty"`
+}
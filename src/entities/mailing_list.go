@@ -0,0 +1,21 @@
+package entities
+
+import "time"
+
+// MailingList is a mailing list or newsletter an account receives, found
+// from the List-* header fields of its messages (RFC 2369, RFC 2919).
+// Unsubscribe addresses are those of the newest message.
+type MailingList struct {
+	ID                uint       `json:"id" gorm:"primarykey"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	ListID            string     `json:"list_id" gorm:"not null;uniqueIndex:idx_mailing_lists_account_list,priority:2"` // List-Id identifier, or the sender's address for newsletters without one, lower-cased
+	Name              string     `json:"name" gorm:"not null"`                                                          // Description of the List-Id, or the sender's name
+	SenderEmail       string     `json:"sender_email" gorm:"not null"`
+	UnsubscribeURL    string     `json:"unsubscribe_url"`                         // HTTP(S) URI of List-Unsubscribe
+	UnsubscribeMailto string     `json:"unsubscribe_mailto"`                      // mailto URI of List-Unsubscribe
+	OneClick          bool       `json:"one_click" gorm:"not null;default:false"` // UnsubscribeURL takes an RFC 8058 one-click POST
+	UnsubscribedAt    *time.Time `json:"unsubscribed_at,omitempty"`               // When the user last unsubscribed
+	AccountID         uint       `json:"account_id" gorm:"not null;uniqueIndex:idx_mailing_lists_account_list,priority:1"`
+	Account           Account    `json:"account,omitempty"`
+}
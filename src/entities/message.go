@@ -17,19 +17,25 @@ const (
 // Message represents an email message
 type Message struct {
 	gorm.Model
-	Subject          *string      `json:"subject,omitempty"`
-	Body             *string      `json:"body,omitempty"`
-	BodyPreview      *string      `json:"body_preview,omitempty"`
-	SenderEmail      string       `json:"sender_email" gorm:"not null"`
-	SenderName       *string      `json:"sender_name,omitempty"`
-	ReceivedDatetime *time.Time   `json:"received_datetime,omitempty"`
-	SentDatetime     *time.Time   `json:"sent_datetime,omitempty"`
-	IsDraft          bool         `json:"is_draft" gorm:"not null"`
-	IsRead           bool         `json:"is_read" gorm:"not null"`
-	Importance       Importance   `json:"importance" gorm:"not null"`
-	ConversationID   *string      `json:"conversation_id,omitempty"`
-	AccountID        uint         `json:"account_id"`
-	Account          Account      `json:"account,omitempty"`
-	Attachments      []Attachment `json:"attachments,omitempty"`
-	Recipients       []Recipient  `json:"recipients,omitempty"`
+	Subject           *string      `json:"subject,omitempty"`
+	Body              *string      `json:"body,omitempty"`
+	BodyPreview       *string      `json:"body_preview,omitempty"`
+	SenderEmail       string       `json:"sender_email" gorm:"not null;index:idx_messages_account_sender,priority:2"`
+	SenderName        *string      `json:"sender_name,omitempty"`
+	ReceivedDatetime  *time.Time   `json:"received_datetime,omitempty" gorm:"index:idx_messages_account_received,priority:2"`
+	SentDatetime      *time.Time   `json:"sent_datetime,omitempty"`
+	IsDraft           bool         `json:"is_draft" gorm:"not null"`
+	IsRead            bool         `json:"is_read" gorm:"not null;index:idx_messages_account_read,priority:2"`
+	IsFlagged         bool         `json:"is_flagged" gorm:"not null;default:false"`
+	Importance        Importance   `json:"importance" gorm:"not null"`
+	Category          Category     `json:"category" gorm:"not null;default:'';index:idx_messages_account_category,priority:2"` // Inbox tab, "" until categorized
+	Folder            string       `json:"folder" gorm:"not null;default:''"`                                                  // Folder the message was moved to, "" being the inbox
+	ConversationID    *string      `json:"conversation_id,omitempty"`
+	InternetMessageID *string      `json:"internet_message_id,omitempty" gorm:"index:idx_messages_account_message_id,priority:2"`
+	SourceKey         *string      `json:"source_key,omitempty" gorm:"index:idx_messages_account_source,priority:2"` // Identifies the message in its account's mail source, e.g. a Maildir file
+	MailingListID     *uint        `json:"mailing_list_id,omitempty" gorm:"index"`                                   // List or newsletter the message came from, if any
+	AccountID         uint         `json:"account_id" gorm:"index:idx_messages_account_received,priority:1;index:idx_messages_account_read,priority:1;index:idx_messages_account_sender,priority:1;index:idx_messages_account_message_id,priority:1;index:idx_messages_account_source,priority:1;index:idx_messages_account_category,priority:1"`
+	Account           Account      `json:"account,omitempty"`
+	Attachments       []Attachment `json:"attachments,omitempty"`
+	Recipients        []Recipient  `json:"recipients,omitempty"`
 }
@@ -0,0 +1,27 @@
+package entities
+
+import "time"
+
+// Sender statuses of a message's authentication
+const (
+	SenderVerified   = "verified"   // The From domain vouches for the message (DMARC pass)
+	SenderUnverified = "unverified" // Nothing proves or disproves the sender
+	SenderFailed     = "failed"     // The From domain disowns the message (DMARC fail)
+)
+
+// MessageAuthentication records whether a message comes from the domain of
+// its From address. Results use the values of Authentication-Results
+// (RFC 8601), such as "pass", "fail" or "none".
+type MessageAuthentication struct {
+	ID           uint      `json:"id" gorm:"primarykey"`
+	VerifiedAt   time.Time `json:"verified_at" gorm:"not null"`
+	SenderStatus string    `json:"sender_status" gorm:"not null"`
+	DKIM         string    `json:"dkim" gorm:"not null"`       // Best DKIM result
+	DKIMDomain   string    `json:"dkim_domain"`                // Signing domain of that result
+	DKIMLocal    bool      `json:"dkim_local" gorm:"not null"` // DKIM was verified here rather than reported
+	SPF          string    `json:"spf" gorm:"not null"`        // As reported on delivery
+	DMARC        string    `json:"dmarc" gorm:"not null"`      // Evaluated here from DKIM, SPF and the domain's policy
+	ARC          string    `json:"arc" gorm:"not null"`        // Chain validation of the newest ARC set, as reported
+	MessageID    uint      `json:"message_id" gorm:"uniqueIndex;not null"`
+	Message      Message   `json:"message,omitempty"`
+}
@@ -0,0 +1,31 @@
+package entities
+
+import "time"
+
+// RiskReason codes name the checks of the phishing analyzer
+const (
+	RiskDisplayNameSpoofing = "display-name-spoofing" // Sender name of a known contact, another address
+	RiskLookalikeDomain     = "lookalike-domain"      // Domain imitating a known one
+	RiskPunycodeDomain      = "punycode-domain"       // Internationalized domain mixing scripts
+	RiskLinkMismatch        = "link-mismatch"         // Link text naming another site than the link
+	RiskDangerousAttachment = "dangerous-attachment"  // Executable or disguised attachment
+	RiskAuthentication      = "authentication-failed" // SPF, DKIM or DMARC failed
+)
+
+// RiskReason is one finding of the phishing analyzer
+type RiskReason struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"` // Human-readable explanation
+	Weight int    `json:"weight"` // Contribution to the score
+}
+
+// MessageRisk is the phishing analysis of a message. Score ranges from 0,
+// nothing suspicious, to 100.
+type MessageRisk struct {
+	ID         uint         `json:"id" gorm:"primarykey"`
+	AnalyzedAt time.Time    `json:"analyzed_at" gorm:"not null"`
+	Score      int          `json:"score" gorm:"not null"`
+	Reasons    []RiskReason `json:"reasons" gorm:"serializer:json"`
+	MessageID  uint         `json:"message_id" gorm:"uniqueIndex;not null"`
+	Message    Message      `json:"message,omitempty"`
+}
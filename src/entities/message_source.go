@@ -0,0 +1,29 @@
+package entities
+
+import "time"
+
+// SourceEncodingGzip marks a message source compressed with gzip
+const SourceEncodingGzip = "gzip"
+
+// MessageSource is the raw RFC 5322 source of a message, kept apart from
+// the messages table so listings never load it
+type MessageSource struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	Encoding  string    `json:"encoding" gorm:"not null"` // Compression of Data
+	Size      uint      `json:"size" gorm:"not null"`     // Uncompressed size in bytes
+	Data      []byte    `json:"-" gorm:"not null"`
+	MessageID uint      `json:"message_id" gorm:"uniqueIndex;not null"`
+	Message   Message   `json:"message,omitempty"`
+}
+
+// MessageHeader is one header field of a message's source, in its original
+// order and with its value unfolded but not decoded
+type MessageHeader struct {
+	ID        uint    `json:"id" gorm:"primarykey"`
+	Position  int     `json:"position" gorm:"not null"`
+	Name      string  `json:"name" gorm:"not null;index"`
+	Value     string  `json:"value" gorm:"not null"`
+	MessageID uint    `json:"message_id" gorm:"index;not null"`
+	Message   Message `json:"message,omitempty"`
+}
@@ -0,0 +1,36 @@
+package entities
+
+import "time"
+
+// Statuses of an outbox message
+const (
+	OutboxQueued   = "queued"   // Waiting for its time; can be cancelled
+	OutboxSending  = "sending"  // Being handed to the provider
+	OutboxDeferred = "deferred" // Held by the provider until SendAt; can be cancelled
+	OutboxFailed   = "failed"   // Sending failed; Error tells why
+
+	// Reported for messages that left the outbox
+	OutboxSent      = "sent"
+	OutboxCancelled = "cancelled"
+)
+
+// OutboxMessage is a message waiting to be sent: during the grace period
+// in which sending can be undone, or until the time it was scheduled for.
+// Messages leave the outbox once sent or cancelled.
+type OutboxMessage struct {
+	ID          uint          `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Status      string        `json:"status" gorm:"not null;index"`
+	SendAt      time.Time     `json:"send_at" gorm:"not null"`      // When recipients get the message, UTC
+	UndoUntil   time.Time     `json:"undo_until" gorm:"not null"`   // End of the grace period, UTC
+	DueAt       time.Time     `json:"due_at" gorm:"not null;index"` // When the scheduler next acts on the message, UTC
+	Subject     string        `json:"subject" gorm:"not null"`
+	Recipients  string        `json:"recipients" gorm:"not null"`          // For display, as in "Bob <bob@example.com>, eve@example.com"
+	Content     []byte        `json:"-" gorm:"not null"`                   // The composed message, as JSON
+	ProviderIDs []string      `json:"provider_ids" gorm:"serializer:json"` // Ids of the messages the provider holds, when deferred
+	Error       string        `json:"error,omitempty"`
+	FollowUp    time.Duration `json:"follow_up,omitempty" gorm:"not null;default:0"` // Remind the user if no one replies within this of sending, 0 for never
+	AccountID   uint          `json:"account_id" gorm:"not null;index"`
+	Account     Account       `json:"account,omitempty"`
+}
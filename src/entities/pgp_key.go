@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+// PGPKey is an OpenPGP key in the local keyring: a correspondent's public
+// key, or one of the user's own keys with its secret part. Secret keys are
+// stored as imported, still protected by their passphrase if they had one.
+type PGPKey struct {
+	ID          uint          `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Fingerprint string        `json:"fingerprint" gorm:"uniqueIndex;not null"` // Upper-case hex of the primary key
+	KeyID       string        `json:"key_id" gorm:"not null"`                  // Last 16 hex digits of the fingerprint
+	UserID      string        `json:"user_id"`                                 // Primary user ID, e.g. "Alice <alice@example.com>"
+	Public      []byte        `json:"-" gorm:"not null"`                       // Binary transferable public key
+	Secret      []byte        `json:"-"`                                       // Binary transferable secret key; nil for correspondents
+	ExpiresAt   *time.Time    `json:"expires_at,omitempty"`
+	Revoked     bool          `json:"revoked" gorm:"not null"`
+	Emails      []PGPKeyEmail `json:"emails,omitempty"`
+}
+
+// PGPKeyEmail is one address named in the user IDs of a key, stored
+// normalized. An address may have several keys.
+type PGPKeyEmail struct {
+	ID       uint   `json:"id" gorm:"primarykey"`
+	Email    string `json:"email" gorm:"index;not null"`
+	PGPKeyID uint   `json:"pgp_key_id" gorm:"index;not null"`
+}
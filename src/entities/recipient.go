@@ -17,6 +17,6 @@ type Recipient struct {
 	Email         string        `json:"email" gorm:"not null"`
 	Name          *string       `json:"name,omitempty"`
 	RecipientType RecipientType `json:"recipient_type" gorm:"not null"`
-	MessageID     uint          `json:"message_id"`
+	MessageID     uint          `json:"message_id" gorm:"index"`
 	Message       Message       `json:"message,omitempty"`
 }
@@ -0,0 +1,13 @@
+package entities
+
+import "time"
+
+// RemoteContentSender allows remote images in messages from a sender. The
+// sender is either an address, matching only that address, or a domain,
+// matching every address at the domain and its subdomains. Values are
+// stored trimmed and lower-cased.
+type RemoteContentSender struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	Sender    string    `json:"sender" gorm:"uniqueIndex;not null"`
+}
@@ -0,0 +1,76 @@
+package entities
+
+import "time"
+
+// Fields a rule condition tests
+const (
+	RuleFieldSender         = "sender"          // Sender address and name
+	RuleFieldRecipients     = "recipients"      // To and Cc addresses and names
+	RuleFieldSubject        = "subject"         // Subject
+	RuleFieldBody           = "body"            // Body as plain text
+	RuleFieldHeader         = "header"          // Header fields named by the condition's Header
+	RuleFieldHasAttachments = "has-attachments" // "true" or "false"
+	RuleFieldImportance     = "importance"      // Low, Normal or High
+)
+
+// Operators comparing a field to a condition's value. Text comparisons
+// ignore case, except for regular expressions.
+const (
+	RuleOperatorContains    = "contains"
+	RuleOperatorNotContains = "not-contains"
+	RuleOperatorEquals      = "equals"
+	RuleOperatorNotEquals   = "not-equals"
+	RuleOperatorStartsWith  = "starts-with"
+	RuleOperatorEndsWith    = "ends-with"
+	RuleOperatorMatches     = "matches" // Regular expression
+)
+
+// Actions a rule takes on the messages it matches
+const (
+	RuleActionMarkRead      = "mark-read"
+	RuleActionSetImportance = "set-importance" // Value is Low, Normal or High
+	RuleActionLabel         = "label"          // Value is the label
+	RuleActionMove          = "move"           // Value is the folder, "" being the inbox
+	RuleActionDelete        = "delete"         // Ends processing
+	RuleActionForward       = "forward"        // Value is the address to forward to
+)
+
+// RuleCondition is a test of a rule on a message field
+type RuleCondition struct {
+	Field    string `json:"field"`
+	Header   string `json:"header,omitempty"` // Header field name, for the header field
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// RuleAction is an action of a rule
+type RuleAction struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+// Rule is a user-defined filter run on incoming messages. Rules run in
+// order of Position; a matching rule with StopProcessing set keeps the
+// later rules from running.
+type Rule struct {
+	ID             uint            `json:"id" gorm:"primarykey"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	Name           string          `json:"name" gorm:"not null"`
+	Position       int             `json:"position" gorm:"not null;index"`
+	Enabled        bool            `json:"enabled" gorm:"not null"`
+	MatchAll       bool            `json:"match_all" gorm:"not null"` // Every condition must hold, rather than any
+	StopProcessing bool            `json:"stop_processing" gorm:"not null"`
+	Conditions     []RuleCondition `json:"conditions" gorm:"serializer:json"`
+	Actions        []RuleAction    `json:"actions" gorm:"serializer:json"`
+	AccountID      *uint           `json:"account_id,omitempty" gorm:"index"` // The rule applies to every account if nil
+	Account        *Account        `json:"account,omitempty"`
+}
+
+// MessageLabel is a label put on a message, by a rule or the user
+type MessageLabel struct {
+	ID        uint    `json:"id" gorm:"primarykey"`
+	Name      string  `json:"name" gorm:"not null;uniqueIndex:idx_message_labels_message_name,priority:2;index"`
+	MessageID uint    `json:"message_id" gorm:"not null;uniqueIndex:idx_message_labels_message_name,priority:1"`
+	Message   Message `json:"message,omitempty"`
+}
@@ -0,0 +1,28 @@
+package entities
+
+import "time"
+
+// SieveScript is a Sieve filtering script (RFC 5228). The active script
+// of an account runs on its incoming messages; a script without an
+// account applies to the accounts with no active script of their own.
+type SieveScript struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name" gorm:"not null;index"`
+	Source    string    `json:"source" gorm:"type:text;not null"`
+	Active    bool      `json:"active" gorm:"not null"`
+	AccountID *uint     `json:"account_id,omitempty" gorm:"index"`
+	Account   *Account  `json:"account,omitempty"`
+}
+
+// VacationReply records when a Sieve vacation response was last sent to a
+// sender, so that each sender gets one per response and period
+type VacationReply struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	AccountID uint      `json:"account_id" gorm:"not null;uniqueIndex:idx_vacation_replies_key,priority:1"`
+	Handle    string    `json:"handle" gorm:"not null;uniqueIndex:idx_vacation_replies_key,priority:2"`
+	Sender    string    `json:"sender" gorm:"not null;uniqueIndex:idx_vacation_replies_key,priority:3"` // Lower case address
+	SentAt    time.Time `json:"sent_at" gorm:"not null"`
+	Account   Account   `json:"account,omitempty"`
+}
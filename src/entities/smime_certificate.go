@@ -0,0 +1,31 @@
+package entities
+
+import "time"
+
+// SMIMECertificate is an X.509 certificate in the local S/MIME store: a
+// correspondent's certificate, a certification authority, or one of the
+// user's own identities with its private key. Identities are stored as the
+// PKCS#12 file they were imported from, still protected by its password.
+type SMIMECertificate struct {
+	ID          uint                    `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time               `json:"created_at"`
+	UpdatedAt   time.Time               `json:"updated_at"`
+	Fingerprint string                  `json:"fingerprint" gorm:"uniqueIndex;not null"` // Upper-case hex SHA-256 of the certificate
+	Subject     string                  `json:"subject"`                                 // e.g. "CN=Alice Example,O=Example Corp"
+	Issuer      string                  `json:"issuer"`
+	NotBefore   time.Time               `json:"not_before"`
+	NotAfter    time.Time               `json:"not_after"`
+	IsCA        bool                    `json:"is_ca" gorm:"not null"`
+	TrustAnchor bool                    `json:"trust_anchor" gorm:"not null"` // Trusted as a root, besides the system's
+	Certificate []byte                  `json:"-" gorm:"not null"`            // DER
+	PKCS12      []byte                  `json:"-"`                            // Set for the user's own identities
+	Emails      []SMIMECertificateEmail `json:"emails,omitempty"`
+}
+
+// SMIMECertificateEmail is one address a certificate is issued to, stored
+// normalized. An address may have several certificates.
+type SMIMECertificateEmail struct {
+	ID                 uint   `json:"id" gorm:"primarykey"`
+	Email              string `json:"email" gorm:"index;not null"`
+	SMIMECertificateID uint   `json:"smime_certificate_id" gorm:"index;not null"`
+}
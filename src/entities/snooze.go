@@ -0,0 +1,15 @@
+package entities
+
+import "time"
+
+// Snooze hides a message until WakeAt, when it returns to Folder as
+// unread. Snoozes are deleted once the message wakes.
+type Snooze struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	WakeAt    time.Time `json:"wake_at" gorm:"not null;index"`     // UTC
+	Folder    string    `json:"folder" gorm:"not null;default:''"` // Folder the message was snoozed from, "" being the inbox
+	MessageID uint      `json:"message_id" gorm:"uniqueIndex;not null"`
+	Message   Message   `json:"message,omitempty"`
+}
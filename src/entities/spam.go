@@ -0,0 +1,36 @@
+package entities
+
+import "time"
+
+// SpamModel holds the totals of the spam classifier and its settings. A
+// single row exists.
+type SpamModel struct {
+	ID           uint    `json:"id" gorm:"primarykey"`
+	SpamMessages int     `json:"spam_messages" gorm:"not null;default:0"` // Messages trained as spam
+	HamMessages  int     `json:"ham_messages" gorm:"not null;default:0"`  // Messages trained as not spam
+	Threshold    float64 `json:"threshold" gorm:"not null"`               // Score from which messages are moved aside
+}
+
+// SpamToken counts the trained messages a token appeared in
+type SpamToken struct {
+	Token string `json:"token" gorm:"primarykey"`
+	Spam  int    `json:"spam" gorm:"not null;default:0"`
+	Ham   int    `json:"ham" gorm:"not null;default:0"`
+}
+
+// Training labels of a message
+const (
+	SpamLabelSpam = "spam"
+	SpamLabelHam  = "ham"
+)
+
+// SpamVerdict is the spam score of a message and the label the user gave
+// it. Score ranges from 0, certainly wanted, to 1, certainly spam.
+type SpamVerdict struct {
+	ID        uint       `json:"id" gorm:"primarykey"`
+	Score     *float64   `json:"score,omitempty"`                  // Unset until the classifier had enough training
+	ScoredAt  *time.Time `json:"scored_at,omitempty"`              // When Score was computed
+	Label     string     `json:"label" gorm:"not null;default:''"` // SpamLabelSpam or SpamLabelHam once trained, else ""
+	MessageID uint       `json:"message_id" gorm:"uniqueIndex;not null"`
+	Message   Message    `json:"message,omitempty"`
+}
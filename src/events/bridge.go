@@ -0,0 +1,168 @@
+package events
+
+import (
+	"palm/src/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBridgeWindow is how long the bridge collects message and progress
+// events before forwarding them
+const DefaultBridgeWindow = 250 * time.Millisecond
+
+// maxBatchMessageIDs caps the message ids carried by a single batch so a
+// large sync does not produce huge payloads
+const maxBatchMessageIDs = 200
+
+// EmitFunc forwards a named event to the frontend, e.g. runtime.EventsEmit
+type EmitFunc func(name string, payload interface{})
+
+// Bridge forwards bus events to the frontend.
+// Message events are coalesced per topic into a MessageBatchPayload and sync
+// progress is reduced to the latest value per account; both are flushed once
+// per window. Every other event is forwarded immediately, after flushing
+// anything pending so the frontend sees events in order.
+type Bridge struct {
+	emit        EmitFunc
+	window      time.Duration
+	unsubscribe func()
+
+	// emitMu serializes calls to emit
+	emitMu sync.Mutex
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	closed   bool
+	batches  map[Topic]*MessageBatchPayload
+	accounts map[Topic]map[uint]struct{}
+	progress map[uint]SyncPayload
+}
+
+// NewBridge subscribes to every topic on bus and forwards events to emit
+func NewBridge(bus *Bus, emit EmitFunc, window time.Duration) *Bridge {
+	config.Logger.Debug().Dur("window", window).Msg("Initializing event bridge")
+
+	b := &Bridge{
+		emit:     emit,
+		window:   window,
+		batches:  make(map[Topic]*MessageBatchPayload),
+		accounts: make(map[Topic]map[uint]struct{}),
+		progress: make(map[uint]SyncPayload),
+	}
+	b.unsubscribe = bus.SubscribeAll(b.handle)
+	return b
+}
+
+// Close unsubscribes from the bus and flushes pending events
+func (b *Bridge) Close() {
+	b.unsubscribe()
+
+	b.mu.Lock()
+	b.closed = true
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	b.Flush()
+}
+
+func (b *Bridge) handle(event Event) {
+	switch event.Topic {
+	case TopicMessageCreated, TopicMessageUpdated, TopicMessageDeleted:
+		payload, ok := event.Payload.(MessagePayload)
+		if !ok {
+			return
+		}
+		b.mu.Lock()
+		b.addMessage(event.Topic, payload)
+		b.schedule()
+		b.mu.Unlock()
+
+	case TopicSyncProgress:
+		payload, ok := event.Payload.(SyncPayload)
+		if !ok {
+			return
+		}
+		b.mu.Lock()
+		b.progress[payload.AccountID] = payload
+		b.schedule()
+		b.mu.Unlock()
+
+	default:
+		b.emitMu.Lock()
+		defer b.emitMu.Unlock()
+		b.flushLocked()
+		b.emit(string(event.Topic), event.Payload)
+	}
+}
+
+// addMessage merges a message event into its topic's batch; b.mu must be held
+func (b *Bridge) addMessage(topic Topic, payload MessagePayload) {
+	batch, ok := b.batches[topic]
+	if !ok {
+		batch = &MessageBatchPayload{}
+		b.batches[topic] = batch
+		b.accounts[topic] = make(map[uint]struct{})
+	}
+	batch.Count++
+	if len(batch.MessageIDs) < maxBatchMessageIDs {
+		batch.MessageIDs = append(batch.MessageIDs, payload.MessageID)
+	} else {
+		batch.Truncated = true
+	}
+	b.accounts[topic][payload.AccountID] = struct{}{}
+}
+
+// schedule arms the flush timer if it is not already running; b.mu must be held
+func (b *Bridge) schedule() {
+	if b.timer != nil || b.closed {
+		return
+	}
+	b.timer = time.AfterFunc(b.window, func() {
+		b.mu.Lock()
+		b.timer = nil
+		b.mu.Unlock()
+		b.Flush()
+	})
+}
+
+// Flush forwards every pending batch and progress update immediately
+func (b *Bridge) Flush() {
+	b.emitMu.Lock()
+	defer b.emitMu.Unlock()
+	b.flushLocked()
+}
+
+// flushLocked drains pending events; b.emitMu must be held
+func (b *Bridge) flushLocked() {
+	b.mu.Lock()
+	batches, accounts, progress := b.batches, b.accounts, b.progress
+	b.batches = make(map[Topic]*MessageBatchPayload)
+	b.accounts = make(map[Topic]map[uint]struct{})
+	b.progress = make(map[uint]SyncPayload)
+	b.mu.Unlock()
+
+	for _, topic := range []Topic{TopicMessageCreated, TopicMessageUpdated, TopicMessageDeleted} {
+		batch, ok := batches[topic]
+		if !ok {
+			continue
+		}
+		for accountID := range accounts[topic] {
+			batch.AccountIDs = append(batch.AccountIDs, accountID)
+		}
+		sort.Slice(batch.AccountIDs, func(i, j int) bool { return batch.AccountIDs[i] < batch.AccountIDs[j] })
+		b.emit(string(topic), *batch)
+	}
+
+	accountIDs := make([]uint, 0, len(progress))
+	for accountID := range progress {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Slice(accountIDs, func(i, j int) bool { return accountIDs[i] < accountIDs[j] })
+	for _, accountID := range accountIDs {
+		b.emit(string(TopicSyncProgress), progress[accountID])
+	}
+}
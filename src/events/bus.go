@@ -0,0 +1,114 @@
+package events
+
+import (
+	"palm/src/config"
+	"sync"
+)
+
+// Topic identifies a kind of event published on the bus
+type Topic string
+
+const (
+	TopicMessageCreated     Topic = "message:created"
+	TopicMessageUpdated     Topic = "message:updated"
+	TopicMessageDeleted     Topic = "message:deleted"
+	TopicMessageWoken       Topic = "message:woken"
+	TopicFollowUpDue        Topic = "message:follow-up"
+	TopicSyncStarted        Topic = "sync:started"
+	TopicSyncProgress       Topic = "sync:progress"
+	TopicSyncFinished       Topic = "sync:finished"
+	TopicSyncFailed         Topic = "sync:failed"
+	TopicAccountAuthExpired Topic = "account:auth-expired"
+	TopicImportProgress     Topic = "import:progress"
+	TopicImportFinished     Topic = "import:finished"
+	TopicOutboxUpdated      Topic = "outbox:updated"
+)
+
+// Event is a single notification published on the bus.
+// Payload holds one of the payload types declared in payloads.go.
+type Event struct {
+	Topic   Topic
+	Payload interface{}
+}
+
+// Handler receives events from the bus
+type Handler func(Event)
+
+type subscription struct {
+	id      uint64
+	topic   Topic // empty for subscriptions to every topic
+	handler Handler
+}
+
+// Bus is an in-process publish/subscribe event bus.
+// Handlers run synchronously on the publisher's goroutine, so they must be
+// quick and must not publish back onto the bus while holding locks the
+// publisher needs. A nil *Bus is valid and discards every event, which lets
+// services run without one.
+type Bus struct {
+	mu            sync.RWMutex
+	nextID        uint64
+	subscriptions []subscription
+}
+
+// NewBus creates an empty event bus
+func NewBus() *Bus {
+	config.Logger.Debug().Msg("Initializing event bus")
+	return &Bus{}
+}
+
+// Subscribe registers a handler for a single topic and returns a function
+// that removes it
+func (b *Bus) Subscribe(topic Topic, handler Handler) func() {
+	return b.subscribe(topic, handler)
+}
+
+// SubscribeAll registers a handler for every topic and returns a function
+// that removes it
+func (b *Bus) SubscribeAll(handler Handler) func() {
+	return b.subscribe("", handler)
+}
+
+func (b *Bus) subscribe(topic Topic, handler Handler) func() {
+	if b == nil {
+		return func() {}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	b.subscriptions = append(b.subscriptions, subscription{id: id, topic: topic, handler: handler})
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subscriptions {
+			if s.id == id {
+				b.subscriptions = append(b.subscriptions[:i:i], b.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish delivers an event to every handler subscribed to its topic
+func (b *Bus) Publish(topic Topic, payload interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.subscriptions))
+	for _, s := range b.subscriptions {
+		if s.topic == "" || s.topic == topic {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	b.mu.RUnlock()
+
+	event := Event{Topic: topic, Payload: payload}
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,89 @@
+package events
+
+import "time"
+
+// Origins of created messages, telling mail just delivered to an account
+// from mail it already had
+const (
+	OriginSync   = "sync"   // Fetched from the account's mailbox
+	OriginImport = "import" // Imported from an archive or a file
+	OriginSent   = "sent"   // The copy of a message sent from Palm
+)
+
+// MessagePayload accompanies message:created, message:updated and
+// message:deleted events
+type MessagePayload struct {
+	MessageID uint   `json:"messageId"`
+	AccountID uint   `json:"accountId"`
+	Origin    string `json:"origin,omitempty"` // How a created message arrived; one of the Origin values
+}
+
+// SnoozePayload accompanies message:woken events, published when a
+// snoozed message returns
+type SnoozePayload struct {
+	MessageID uint   `json:"messageId"`
+	AccountID uint   `json:"accountId"`
+	Subject   string `json:"subject"`
+	Sender    string `json:"sender"`
+}
+
+// FollowUpPayload accompanies message:follow-up events, published when
+// no one replied to a sent message in time
+type FollowUpPayload struct {
+	MessageID  uint   `json:"messageId"`
+	AccountID  uint   `json:"accountId"`
+	Subject    string `json:"subject"`
+	Recipients string `json:"recipients"`
+}
+
+// SyncPayload accompanies the sync:* events
+type SyncPayload struct {
+	AccountID uint   `json:"accountId"`
+	Email     string `json:"email"`
+	Fetched   int    `json:"fetched"`
+	Created   int    `json:"created"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AccountPayload accompanies account:* events
+type AccountPayload struct {
+	AccountID uint   `json:"accountId"`
+	Email     string `json:"email"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ImportPayload accompanies the import:* events
+type ImportPayload struct {
+	AccountID  uint   `json:"accountId"`
+	Source     string `json:"source"` // "mbox" or "maildir"
+	Processed  int    `json:"processed"`
+	Imported   int    `json:"imported"`
+	Duplicates int    `json:"duplicates"`
+	Failed     int    `json:"failed"`
+	BytesRead  int64  `json:"bytesRead"`
+	TotalBytes int64  `json:"totalBytes,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// OutboxPayload accompanies outbox:updated events, published whenever a
+// message waiting to be sent changes state. Status is one of the outbox
+// statuses, or "sent" or "cancelled" once the message left the outbox.
+type OutboxPayload struct {
+	ID        uint      `json:"id"`
+	AccountID uint      `json:"accountId"`
+	Status    string    `json:"status"`
+	SendAt    time.Time `json:"sendAt"`
+	MessageID uint      `json:"messageId,omitempty"` // The stored sent copy, once sent
+	Error     string    `json:"error,omitempty"`
+}
+
+// MessageBatchPayload is what the frontend receives for message events:
+// all events of one topic that arrived within a debounce window.
+// MessageIDs is capped; Count always holds the full number of events.
+type MessageBatchPayload struct {
+	Count      int    `json:"count"`
+	MessageIDs []uint `json:"messageIds"`
+	AccountIDs []uint `json:"accountIds"`
+	Truncated  bool   `json:"truncated"`
+}
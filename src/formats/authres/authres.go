@@ -0,0 +1,238 @@
+// Package authres parses the Authentication-Results header field
+// (RFC 8601) and the fields of ARC sets (RFC 8617).
+//
+// These fields only report what the server that added them found; only
+// those added on delivery, the topmost, can be trusted.
+package authres
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Common errors
+var (
+	ErrMalformed = errors.New("malformed authentication results")
+)
+
+// spacedEquals matches an equals sign with the whitespace around it
+var spacedEquals = regexp.MustCompile(`\s*=\s*`)
+
+// Result is the result of one authentication method
+type Result struct {
+	Method     string            // Lower-cased method, such as "dkim"
+	Value      string            // Lower-cased result, such as "pass"
+	Reason     string            // Explanation given with reason=, if any
+	Properties map[string]string // Lower-cased "ptype.property" to value, such as "header.d"
+}
+
+// Results is a parsed Authentication-Results field
+type Results struct {
+	AuthServID string // The server that authenticated the message
+	Results    []Result
+}
+
+// Get returns the first result of a method. A method reported more than
+// once, such as dkim for several signatures, prefers a passing result.
+func (r *Results) Get(method string) (Result, bool) {
+	var found Result
+	ok := false
+	for _, result := range r.Results {
+		if result.Method != method {
+			continue
+		}
+		if !ok || (found.Value != "pass" && result.Value == "pass") {
+			found, ok = result, true
+		}
+	}
+	return found, ok
+}
+
+// All returns every result of a method in field order
+func (r *Results) All(method string) []Result {
+	var results []Result
+	for _, result := range r.Results {
+		if result.Method == method {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// Parse parses the value of an Authentication-Results field
+func Parse(value string) (*Results, error) {
+	specs := splitUnquoted(stripComments(value), ';')
+	servID := strings.Fields(specs[0])
+	if len(servID) == 0 {
+		return nil, fmt.Errorf("%w: missing authserv-id", ErrMalformed)
+	}
+
+	results := &Results{AuthServID: strings.ToLower(unquote(servID[0]))}
+	for _, spec := range specs[1:] {
+		spec = strings.TrimSpace(spec)
+		if spec == "" || strings.EqualFold(spec, "none") {
+			continue
+		}
+		result, err := parseResult(spec)
+		if err != nil {
+			return nil, err
+		}
+		results.Results = append(results.Results, result)
+	}
+	return results, nil
+}
+
+// parseResult parses "method[/version]=result property=value ..."
+func parseResult(spec string) (Result, error) {
+	words := splitUnquoted(spacedEquals.ReplaceAllString(spec, "="), ' ')
+	method, value, ok := strings.Cut(words[0], "=")
+	if !ok || method == "" || value == "" {
+		return Result{}, fmt.Errorf("%w: %q", ErrMalformed, spec)
+	}
+	method, _, _ = strings.Cut(method, "/")
+
+	result := Result{
+		Method:     strings.ToLower(strings.TrimSpace(method)),
+		Value:      strings.ToLower(strings.TrimSpace(value)),
+		Properties: make(map[string]string),
+	}
+	for _, word := range words[1:] {
+		name, value, ok := strings.Cut(word, "=")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(name)
+		if name == "reason" {
+			result.Reason = unquote(value)
+		} else {
+			result.Properties[name] = unquote(value)
+		}
+	}
+	return result, nil
+}
+
+// ARCSeal is a parsed ARC-Seal field. Its signature is not verified.
+type ARCSeal struct {
+	Instance        int    // Position in the chain, from 1
+	ChainValidation string // "none", "pass" or "fail": the chain as the sealer found it
+	Domain          string // Sealing domain
+}
+
+// ParseARCSeal parses the value of an ARC-Seal field
+func ParseARCSeal(value string) (ARCSeal, error) {
+	var seal ARCSeal
+	for _, spec := range strings.Split(value, ";") {
+		name, tagValue, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok {
+			continue
+		}
+		tagValue = strings.TrimSpace(tagValue)
+		switch strings.TrimSpace(name) {
+		case "i":
+			instance, err := strconv.Atoi(tagValue)
+			if err != nil || instance < 1 {
+				return ARCSeal{}, fmt.Errorf("%w: ARC instance %q", ErrMalformed, tagValue)
+			}
+			seal.Instance = instance
+		case "cv":
+			seal.ChainValidation = strings.ToLower(tagValue)
+		case "d":
+			seal.Domain = strings.ToLower(tagValue)
+		}
+	}
+	if seal.Instance == 0 || seal.ChainValidation == "" {
+		return ARCSeal{}, fmt.Errorf("%w: ARC-Seal needs i= and cv=", ErrMalformed)
+	}
+	return seal, nil
+}
+
+// ParseARCResults parses the value of an ARC-Authentication-Results
+// field: an instance tag followed by Authentication-Results
+func ParseARCResults(value string) (int, *Results, error) {
+	tag, rest, ok := strings.Cut(value, ";")
+	name, instanceValue, hasValue := strings.Cut(strings.TrimSpace(tag), "=")
+	if !ok || !hasValue || strings.TrimSpace(name) != "i" {
+		return 0, nil, fmt.Errorf("%w: missing ARC instance", ErrMalformed)
+	}
+	instance, err := strconv.Atoi(strings.TrimSpace(instanceValue))
+	if err != nil || instance < 1 {
+		return 0, nil, fmt.Errorf("%w: ARC instance %q", ErrMalformed, instanceValue)
+	}
+	results, err := Parse(rest)
+	if err != nil {
+		return 0, nil, err
+	}
+	return instance, results, nil
+}
+
+// stripComments removes parenthesized comments, which may nest, outside
+// quoted strings
+func stripComments(s string) string {
+	var b strings.Builder
+	depth := 0
+	quoted := false
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && (quoted || depth > 0):
+			escaped = true
+		case quoted:
+			if r == '"' {
+				quoted = false
+			}
+		case r == '(':
+			depth++
+			continue
+		case r == ')' && depth > 0:
+			depth--
+			continue
+		case depth > 0:
+			continue
+		case r == '"':
+			quoted = true
+		}
+		if depth == 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// splitUnquoted splits s at sep outside quoted strings. Splitting at a
+// space skips runs of whitespace.
+func splitUnquoted(s string, sep rune) []string {
+	var parts []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		}
+		isSep := r == sep || (sep == ' ' && (r == '\t' || r == '\r' || r == '\n'))
+		if isSep && !quoted {
+			if sep != ' ' || current.Len() > 0 {
+				parts = append(parts, current.String())
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if sep != ' ' || current.Len() > 0 || len(parts) == 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+// unquote removes the quotes of a quoted string
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strings.ReplaceAll(s[1:len(s)-1], `\"`, `"`)
+	}
+	return s
+}
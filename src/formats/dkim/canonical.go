@@ -0,0 +1,189 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"strings"
+)
+
+// Canonicalization algorithms (RFC 6376 section 3.4)
+const (
+	simple  = "simple"
+	relaxed = "relaxed"
+)
+
+// field is a header field as it appears in the message, folding included
+type field struct {
+	name string
+	text string // The whole field without its final CRLF
+}
+
+// value returns the field's value, after the colon
+func (f field) value() string {
+	_, value, _ := strings.Cut(f.text, ":")
+	return value
+}
+
+// validCanonicalization reports whether c names a canonicalization
+func validCanonicalization(c string) bool {
+	return c == simple || c == relaxed
+}
+
+// splitMessage splits a message into its header fields and its body, with
+// CRLF line endings
+func splitMessage(message []byte) ([]field, []byte) {
+	message = bytes.ReplaceAll(message, []byte("\r\n"), []byte("\n"))
+	message = bytes.ReplaceAll(message, []byte("\n"), []byte("\r\n"))
+
+	var header []field
+	rest := message
+	for len(rest) > 0 {
+		end := bytes.Index(rest, []byte("\r\n"))
+		line := rest
+		if end >= 0 {
+			line, rest = rest[:end], rest[end+2:]
+		} else {
+			rest = nil
+		}
+		if len(line) == 0 {
+			return header, rest
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(header) > 0 {
+			header[len(header)-1].text += "\r\n" + string(line)
+			continue
+		}
+		name, _, ok := strings.Cut(string(line), ":")
+		if !ok {
+			continue
+		}
+		header = append(header, field{name: strings.TrimRight(name, " \t"), text: string(line)})
+	}
+	return header, nil
+}
+
+// hashBody returns the hash of a canonicalized body, of which only the
+// first length bytes are signed unless length is negative
+func hashBody(hash crypto.Hash, canon string, body []byte, length int64) ([]byte, error) {
+	var canonical []byte
+	if canon == relaxed {
+		canonical = relaxedBody(body)
+	} else {
+		canonical = simpleBody(body)
+	}
+	if length >= 0 {
+		if length > int64(len(canonical)) {
+			return nil, fmt.Errorf("%w: l= exceeds the body", ErrMalformedSignature)
+		}
+		canonical = canonical[:length]
+	}
+	h := hash.New()
+	h.Write(canonical)
+	return h.Sum(nil), nil
+}
+
+// hashHeader returns the hash of the signed header fields followed by the
+// signature field with its b= value removed. Fields named more than once
+// are taken from the bottom up; names without a field are skipped.
+func hashHeader(hash crypto.Hash, canon string, header []field, names []string, sigField field) []byte {
+	h := hash.New()
+	used := make(map[string]int)
+	for _, name := range names {
+		key := strings.ToLower(name)
+		skip := used[key]
+		for i := len(header) - 1; i >= 0; i-- {
+			if !strings.EqualFold(header[i].name, name) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			h.Write([]byte(canonicalField(canon, header[i]) + "\r\n"))
+			break
+		}
+		used[key]++
+	}
+
+	stripped := field{name: sigField.name, text: stripSignature(sigField.text)}
+	h.Write([]byte(canonicalField(canon, stripped)))
+	return h.Sum(nil)
+}
+
+// canonicalField canonicalizes a header field, without a final CRLF
+func canonicalField(canon string, f field) string {
+	if canon != relaxed {
+		return f.text
+	}
+	value := strings.ReplaceAll(f.value(), "\r\n", "")
+	value = collapseWhitespace(value)
+	return strings.ToLower(strings.TrimSpace(f.name)) + ":" + strings.TrimSpace(value)
+}
+
+// stripSignature empties the b= tag of a signature field, keeping the
+// rest of the field as it is
+func stripSignature(text string) string {
+	name, value, _ := strings.Cut(text, ":")
+	specs := strings.Split(value, ";")
+	for i, spec := range specs {
+		tag, _, ok := strings.Cut(spec, "=")
+		if ok && strings.TrimSpace(tag) == "b" {
+			specs[i] = spec[:strings.Index(spec, "=")+1]
+		}
+	}
+	return name + ":" + strings.Join(specs, ";")
+}
+
+// simpleBody canonicalizes a body with the simple algorithm: trailing
+// empty lines are removed and an empty body becomes one CRLF
+func simpleBody(body []byte) []byte {
+	for bytes.HasSuffix(body, []byte("\r\n\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) == 0 || !bytes.HasSuffix(body, []byte("\r\n")) {
+		body = append(bytes.Clone(body), "\r\n"...)
+	}
+	return body
+}
+
+// relaxedBody canonicalizes a body with the relaxed algorithm: whitespace
+// runs become one space, whitespace at line ends and trailing empty lines
+// are removed
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(collapseWhitespace(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWhitespace replaces runs of spaces and tabs with one space
+func collapseWhitespace(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
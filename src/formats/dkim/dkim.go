@@ -0,0 +1,429 @@
+// Package dkim verifies and creates DomainKeys Identified Mail signatures
+// (RFC 6376), with RSA and Ed25519 keys (RFC 8463). As RFC 8301 requires,
+// rsa-sha1 signatures and RSA keys shorter than 1024 bits are not valid.
+//
+// Messages are verified as stored: bare LF line endings are read as CRLF,
+// as they were on the wire.
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Common errors
+var (
+	ErrMalformedSignature   = errors.New("malformed DKIM-Signature")
+	ErrUnsupportedAlgorithm = errors.New("unsupported DKIM algorithm")
+	ErrInsecureAlgorithm    = errors.New("insecure DKIM algorithm")
+	ErrExpired              = errors.New("DKIM signature expired")
+	ErrBodyHashMismatch     = errors.New("body hash does not match")
+	ErrSignatureMismatch    = errors.New("signature does not match")
+	ErrKeyNotFound          = errors.New("DKIM key not found")
+	ErrKeyLookup            = errors.New("DKIM key lookup failed")
+	ErrMalformedKey         = errors.New("malformed DKIM key")
+	ErrKeyRevoked           = errors.New("DKIM key revoked")
+	ErrWeakKey              = errors.New("DKIM key too short")
+)
+
+// Result is the outcome of verifying one signature, as reported in
+// Authentication-Results (RFC 8601)
+type Result string
+
+// Results of verifying a signature
+const (
+	Pass      Result = "pass"      // The signature verified
+	Fail      Result = "fail"      // The message or signature was altered, or it expired
+	Neutral   Result = "neutral"   // The signature cannot be verified, such as an unknown algorithm
+	TempError Result = "temperror" // The key could not be retrieved this time
+	PermError Result = "permerror" // The signature or its key is unusable
+)
+
+const (
+	// maxSignatures bounds the signatures verified per message
+	maxSignatures = 5
+	// minRSABits is the shortest RSA key accepted (RFC 8301)
+	minRSABits = 1024
+	// fieldName is the name of signature header fields
+	fieldName = "DKIM-Signature"
+)
+
+// Resolver looks up DNS TXT records; *net.Resolver implements it
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Verification is the outcome of verifying one DKIM-Signature field
+type Verification struct {
+	Result    Result
+	Domain    string // Signing domain (d=)
+	Selector  string // Key selector (s=)
+	Identity  string // Agent or user identifier (i=)
+	Algorithm string // Signing algorithm (a=)
+	Testing   bool   // The key is published for testing only
+	Err       error  // Why the signature did not pass
+}
+
+// Verify verifies the DKIM signatures of a message, up to five, in the
+// order of their fields. A message without signatures has none.
+func Verify(ctx context.Context, resolver Resolver, message []byte) []Verification {
+	header, body := splitMessage(message)
+
+	var verifications []Verification
+	for i := range header {
+		if !strings.EqualFold(header[i].name, fieldName) {
+			continue
+		}
+		if len(verifications) == maxSignatures {
+			break
+		}
+		verifications = append(verifications, verify(ctx, resolver, header, i, body))
+	}
+	return verifications
+}
+
+// verify verifies the signature in the field at index i of header
+func verify(ctx context.Context, resolver Resolver, header []field, i int, body []byte) Verification {
+	sig, err := parseSignature(header[i].value())
+	if err != nil {
+		return failed(Verification{}, err)
+	}
+	v := Verification{
+		Domain:    sig.domain,
+		Selector:  sig.selector,
+		Identity:  sig.identity,
+		Algorithm: sig.algorithm,
+	}
+	// SHA-1 signatures can be forged (RFC 8301 section 3.1)
+	if sig.hash == crypto.SHA1 {
+		return failed(v, ErrInsecureAlgorithm)
+	}
+	if !sig.expires.IsZero() && time.Now().After(sig.expires) {
+		return failed(v, ErrExpired)
+	}
+
+	key, err := lookupKey(ctx, resolver, sig.selector, sig.domain)
+	if err != nil {
+		return failed(v, err)
+	}
+	v.Testing = key.testing
+	if err := key.accepts(sig); err != nil {
+		return failed(v, err)
+	}
+
+	bodyHash, err := hashBody(sig.hash, sig.bodyCanon, body, sig.length)
+	if err != nil {
+		return failed(v, err)
+	}
+	if !bytes.Equal(bodyHash, sig.bodyHash) {
+		return failed(v, ErrBodyHashMismatch)
+	}
+
+	digest := hashHeader(sig.hash, sig.headerCanon, header, sig.headers, header[i])
+	if err := key.verify(sig.hash, digest, sig.signature); err != nil {
+		return failed(v, err)
+	}
+	v.Result = Pass
+	return v
+}
+
+// failed records why a signature did not pass
+func failed(v Verification, err error) Verification {
+	v.Err = err
+	switch {
+	case errors.Is(err, ErrBodyHashMismatch), errors.Is(err, ErrSignatureMismatch), errors.Is(err, ErrExpired):
+		v.Result = Fail
+	case errors.Is(err, ErrKeyLookup):
+		v.Result = TempError
+	case errors.Is(err, ErrUnsupportedAlgorithm):
+		v.Result = Neutral
+	default:
+		v.Result = PermError
+	}
+	return v
+}
+
+// signature is a parsed DKIM-Signature field
+type signature struct {
+	algorithm   string
+	keyType     string
+	hash        crypto.Hash
+	signature   []byte
+	bodyHash    []byte
+	headerCanon string
+	bodyCanon   string
+	domain      string
+	selector    string
+	identity    string
+	headers     []string
+	length      int64 // Signed body length, or -1 for all of it
+	expires     time.Time
+}
+
+// parseSignature parses the value of a DKIM-Signature field
+func parseSignature(value string) (*signature, error) {
+	tags, err := parseTags(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[name]; !ok {
+			return nil, fmt.Errorf("%w: missing %s= tag", ErrMalformedSignature, name)
+		}
+	}
+	if tags["v"] != "1" {
+		return nil, fmt.Errorf("%w: version %q", ErrMalformedSignature, tags["v"])
+	}
+
+	sig := &signature{
+		algorithm: strings.ToLower(tags["a"]),
+		domain:    strings.ToLower(strings.TrimSuffix(tags["d"], ".")),
+		selector:  strings.ToLower(tags["s"]),
+		length:    -1,
+	}
+	switch sig.algorithm {
+	case "rsa-sha256":
+		sig.keyType, sig.hash = "rsa", crypto.SHA256
+	case "rsa-sha1":
+		sig.keyType, sig.hash = "rsa", crypto.SHA1
+	case "ed25519-sha256":
+		sig.keyType, sig.hash = "ed25519", crypto.SHA256
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, sig.algorithm)
+	}
+
+	if sig.signature, err = decodeBase64(tags["b"]); err != nil || len(sig.signature) == 0 {
+		return nil, fmt.Errorf("%w: invalid b= tag", ErrMalformedSignature)
+	}
+	if sig.bodyHash, err = decodeBase64(tags["bh"]); err != nil || len(sig.bodyHash) == 0 {
+		return nil, fmt.Errorf("%w: invalid bh= tag", ErrMalformedSignature)
+	}
+
+	sig.headerCanon, sig.bodyCanon = simple, simple
+	if c, ok := tags["c"]; ok {
+		headerCanon, bodyCanon, hasBody := strings.Cut(strings.ToLower(c), "/")
+		sig.headerCanon = headerCanon
+		if hasBody {
+			sig.bodyCanon = bodyCanon
+		}
+		if !validCanonicalization(sig.headerCanon) || !validCanonicalization(sig.bodyCanon) {
+			return nil, fmt.Errorf("%w: canonicalization %q", ErrMalformedSignature, c)
+		}
+	}
+
+	for _, name := range strings.Split(tags["h"], ":") {
+		if name = strings.TrimSpace(name); name != "" {
+			sig.headers = append(sig.headers, name)
+		}
+	}
+	if !containsFold(sig.headers, "From") {
+		return nil, fmt.Errorf("%w: From is not signed", ErrMalformedSignature)
+	}
+
+	sig.identity = "@" + sig.domain
+	if i, ok := tags["i"]; ok {
+		sig.identity = i
+		_, domain, found := strings.Cut(i, "@")
+		domain = strings.ToLower(domain)
+		if !found || (domain != sig.domain && !strings.HasSuffix(domain, "."+sig.domain)) {
+			return nil, fmt.Errorf("%w: identity %q is not in %s", ErrMalformedSignature, i, sig.domain)
+		}
+	}
+
+	if l, ok := tags["l"]; ok {
+		if sig.length, err = strconv.ParseInt(l, 10, 64); err != nil || sig.length < 0 {
+			return nil, fmt.Errorf("%w: invalid l= tag", ErrMalformedSignature)
+		}
+	}
+	if x, ok := tags["x"]; ok {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid x= tag", ErrMalformedSignature)
+		}
+		sig.expires = time.Unix(expires, 0)
+	}
+	if q, ok := tags["q"]; ok && !strings.Contains(strings.ToLower(q), "dns/txt") {
+		return nil, fmt.Errorf("%w: query method %q", ErrUnsupportedAlgorithm, q)
+	}
+	return sig, nil
+}
+
+// publicKey is a key record published at selector._domainkey.domain
+type publicKey struct {
+	keyType string
+	rsa     *rsa.PublicKey
+	ed25519 ed25519.PublicKey
+	hashes  []string // Acceptable hash algorithms, all if empty
+	testing bool     // t=y
+	strict  bool     // t=s: the identity must be in the domain itself
+}
+
+// lookupKey retrieves and parses the key a signature names
+func lookupKey(ctx context.Context, resolver Resolver, selector, domain string) (*publicKey, error) {
+	name := selector + "._domainkey." + domain
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+		}
+		return nil, fmt.Errorf("%w: %s: %v", ErrKeyLookup, name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	// A name publishes one key; several records are joined as TXT strings
+	return parseKey(strings.Join(records, ""))
+}
+
+// parseKey parses a key record
+func parseKey(record string) (*publicKey, error) {
+	tags, err := parseTags(record)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedKey, err)
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, fmt.Errorf("%w: version %q", ErrMalformedKey, v)
+	}
+	if s, ok := tags["s"]; ok && !strings.Contains(s, "*") && !strings.Contains(strings.ToLower(s), "email") {
+		return nil, fmt.Errorf("%w: not for email", ErrMalformedKey)
+	}
+
+	key := &publicKey{keyType: "rsa"}
+	if k, ok := tags["k"]; ok {
+		key.keyType = strings.ToLower(k)
+	}
+	if h, ok := tags["h"]; ok {
+		for _, hash := range strings.Split(h, ":") {
+			key.hashes = append(key.hashes, strings.ToLower(strings.TrimSpace(hash)))
+		}
+	}
+	for _, flag := range strings.Split(tags["t"], ":") {
+		switch strings.TrimSpace(flag) {
+		case "y":
+			key.testing = true
+		case "s":
+			key.strict = true
+		}
+	}
+
+	p, ok := tags["p"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing p= tag", ErrMalformedKey)
+	}
+	data, err := decodeBase64(p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid p= tag", ErrMalformedKey)
+	}
+	if len(data) == 0 {
+		return nil, ErrKeyRevoked
+	}
+
+	switch key.keyType {
+	case "rsa":
+		parsed, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			parsed, err = x509.ParsePKCS1PublicKey(data)
+		}
+		rsaKey, ok := parsed.(*rsa.PublicKey)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("%w: invalid RSA key", ErrMalformedKey)
+		}
+		if rsaKey.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("%w: %d bits", ErrWeakKey, rsaKey.N.BitLen())
+		}
+		key.rsa = rsaKey
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid Ed25519 key", ErrMalformedKey)
+		}
+		key.ed25519 = ed25519.PublicKey(data)
+	default:
+		return nil, fmt.Errorf("%w: key type %s", ErrUnsupportedAlgorithm, key.keyType)
+	}
+	return key, nil
+}
+
+// accepts checks that a key may verify a signature
+func (k *publicKey) accepts(sig *signature) error {
+	if k.keyType != sig.keyType {
+		return fmt.Errorf("%w: %s key for %s", ErrMalformedKey, k.keyType, sig.algorithm)
+	}
+	if len(k.hashes) > 0 {
+		_, hash, _ := strings.Cut(sig.algorithm, "-")
+		allowed := false
+		for _, h := range k.hashes {
+			allowed = allowed || h == hash
+		}
+		if !allowed {
+			return fmt.Errorf("%w: key does not allow %s", ErrMalformedKey, hash)
+		}
+	}
+	if k.strict {
+		_, domain, _ := strings.Cut(sig.identity, "@")
+		if !strings.EqualFold(domain, sig.domain) {
+			return fmt.Errorf("%w: key requires the identity to be in %s", ErrMalformedKey, sig.domain)
+		}
+	}
+	return nil
+}
+
+// verify checks a signature over a header digest
+func (k *publicKey) verify(hash crypto.Hash, digest, sig []byte) error {
+	switch {
+	case k.rsa != nil:
+		if rsa.VerifyPKCS1v15(k.rsa, hash, digest, sig) != nil {
+			return ErrSignatureMismatch
+		}
+	case k.ed25519 != nil:
+		// Ed25519 signs the digest rather than the data (RFC 8463)
+		if !ed25519.Verify(k.ed25519, digest, sig) {
+			return ErrSignatureMismatch
+		}
+	}
+	return nil
+}
+
+// parseTags parses a tag list (RFC 6376 section 3.2). Whitespace around
+// tags and values is ignored.
+func parseTags(list string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(list, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: tag %q has no value", ErrMalformedSignature, spec)
+		}
+		name = strings.TrimSpace(name)
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("%w: duplicate %s= tag", ErrMalformedSignature, name)
+		}
+		tags[name] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+// decodeBase64 decodes base64 that may be folded with whitespace
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, value)
+	return base64.StdEncoding.DecodeString(value)
+}
@@ -0,0 +1,114 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultSignedHeaders are the fields Sign signs when the options name
+// none
+var DefaultSignedHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// SignOptions configure a signature
+type SignOptions struct {
+	Domain   string        // Signing domain (d=)
+	Selector string        // Selector of the published key (s=)
+	Key      crypto.Signer // *rsa.PrivateKey or ed25519.PrivateKey
+	Headers  []string      // Fields to sign, DefaultSignedHeaders if empty
+	Expires  time.Duration // Signature lifetime, unlimited if zero
+}
+
+// Sign signs a message with relaxed canonicalization and returns the
+// DKIM-Signature field, ending in CRLF, to prepend to it. Only the fields
+// present in the message are signed.
+func Sign(message []byte, opts SignOptions) (string, error) {
+	if opts.Domain == "" || opts.Selector == "" {
+		return "", errors.New("dkim: domain and selector are required")
+	}
+	var algorithm string
+	switch opts.Key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return "", fmt.Errorf("%w: key type %T", ErrUnsupportedAlgorithm, opts.Key)
+	}
+
+	header, body := splitMessage(message)
+	names := opts.Headers
+	if len(names) == 0 {
+		names = DefaultSignedHeaders
+	}
+	var signed []string
+	for _, name := range names {
+		for _, f := range header {
+			if strings.EqualFold(f.name, name) {
+				signed = append(signed, name)
+				break
+			}
+		}
+	}
+	if !containsFold(signed, "From") {
+		return "", errors.New("dkim: the message has no From field to sign")
+	}
+	// Signing From once more than it appears keeps another from being added
+	signed = append(signed, "From")
+
+	bodyHash, err := hashBody(crypto.SHA256, relaxed, body, -1)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	tags := []string{
+		"v=1",
+		"a=" + algorithm,
+		"c=relaxed/relaxed",
+		"d=" + opts.Domain,
+		"s=" + opts.Selector,
+		fmt.Sprintf("t=%d", now.Unix()),
+	}
+	if opts.Expires > 0 {
+		tags = append(tags, fmt.Sprintf("x=%d", now.Add(opts.Expires).Unix()))
+	}
+	tags = append(tags,
+		"h="+strings.Join(signed, ":"),
+		"bh="+base64.StdEncoding.EncodeToString(bodyHash),
+		"b=",
+	)
+	text := fieldName + ": " + strings.Join(tags, ";\r\n\t")
+
+	digest := hashHeader(crypto.SHA256, relaxed, header, signed, field{name: fieldName, text: text})
+	var sig []byte
+	if algorithm == "ed25519-sha256" {
+		sig, err = opts.Key.Sign(rand.Reader, digest, crypto.Hash(0))
+	} else {
+		sig, err = opts.Key.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+	if err != nil {
+		return "", fmt.Errorf("dkim: failed to sign: %w", err)
+	}
+	return text + base64.StdEncoding.EncodeToString(sig) + "\r\n", nil
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
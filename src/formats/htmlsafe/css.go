@@ -0,0 +1,312 @@
+package htmlsafe
+
+import (
+	"regexp"
+	"strings"
+)
+
+// blockedProperties can run code or escape the message's box
+var blockedProperties = map[string]bool{
+	"-moz-binding": true, "behavior": true, "-webkit-binding": true,
+}
+
+// blockedValues are never allowed in a declaration
+var blockedValues = []string{"expression(", "javascript:", "vbscript:", "@import", "<", "\\"}
+
+// propertyName matches the name of a declaration
+var propertyName = regexp.MustCompile(`^-?[a-z][a-z0-9-]*$`)
+
+// urlFunction matches a url() reference with its argument
+var urlFunction = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"\s]*))\s*\)`)
+
+// styleSheet returns the rules of a <style> element limited to the
+// message. @media and @supports blocks are kept with their rules scoped;
+// other at-rules, such as @import, are removed.
+func (s *sanitizer) styleSheet(css string) string {
+	css = stripComments(css)
+	var out strings.Builder
+	for len(css) > 0 {
+		css = strings.TrimLeft(css, " \t\r\n\f")
+		if css == "" {
+			break
+		}
+
+		end := scanUntil(css, "{;")
+		prelude := strings.TrimSpace(css[:end])
+		if end == len(css) || css[end] == ';' {
+			// A statement at-rule, such as @import or @charset
+			css = css[min(end+1, len(css)):]
+			continue
+		}
+		block, rest := splitBlock(css[end+1:])
+		css = rest
+
+		if strings.HasPrefix(prelude, "@") {
+			name, condition, _ := strings.Cut(prelude, " ")
+			switch strings.ToLower(name) {
+			case "@media", "@supports":
+				if strings.ContainsAny(condition, "<\\") {
+					continue
+				}
+				if rules := s.styleSheet(block); rules != "" {
+					out.WriteString(name + " " + strings.TrimSpace(condition) + "{" + rules + "}")
+				}
+			case "@font-face":
+				if declarations := s.declarations(block); declarations != "" {
+					out.WriteString("@font-face{" + declarations + "}")
+				}
+			}
+			continue
+		}
+
+		selectors := s.selectors(prelude)
+		declarations := s.declarations(block)
+		if selectors != "" && declarations != "" {
+			out.WriteString(selectors + "{" + declarations + "}")
+		}
+	}
+	return out.String()
+}
+
+// selectors scopes a selector list to the message: each selector is made to
+// match only inside the scope element, html, :root and body stand for the
+// scope element itself, and class names and ids are prefixed
+func (s *sanitizer) selectors(list string) string {
+	if strings.ContainsAny(list, "<\\") || !balancedQuotes(list) {
+		return ""
+	}
+	scope := "." + s.opts.Scope
+	var scoped []string
+	for _, selector := range splitTopLevel(list, ',') {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+		selector = s.prefixSelector(selector)
+		if s.opts.Scope == "" {
+			scoped = append(scoped, selector)
+			continue
+		}
+
+		root := false
+		for _, name := range []string{"html", ":root"} {
+			if rest, ok := cutKeyword(selector, name); ok {
+				selector = strings.TrimLeft(rest, " \t\r\n\f>")
+				root = true
+			}
+		}
+		switch rest, ok := cutKeyword(selector, "body"); {
+		case ok:
+			scoped = append(scoped, scope+rest)
+		case root && selector == "":
+			scoped = append(scoped, scope)
+		default:
+			scoped = append(scoped, scope+" "+selector)
+		}
+	}
+	return strings.Join(scoped, ",")
+}
+
+// prefixSelector prefixes the class names and ids in a selector
+func (s *sanitizer) prefixSelector(selector string) string {
+	if s.opts.Scope == "" {
+		return selector
+	}
+	var b strings.Builder
+	var quote byte
+	brackets := 0
+	for i := 0; i < len(selector); i++ {
+		c := selector[i]
+		b.WriteByte(c)
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			brackets++
+		case c == ']':
+			brackets--
+		case (c == '.' || c == '#') && brackets == 0 && i+1 < len(selector) && isNameStart(selector[i+1]):
+			b.WriteString(s.opts.Scope + "-")
+		}
+	}
+	return b.String()
+}
+
+// declarations returns the allowed declarations of a style attribute or
+// rule block. Images referenced with url() follow the same rules as <img>.
+func (s *sanitizer) declarations(list string) string {
+	var kept []string
+	for _, declaration := range splitTopLevel(stripComments(list), ';') {
+		name, value, ok := strings.Cut(declaration, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if !propertyName.MatchString(name) || blockedProperties[name] || value == "" || !balancedQuotes(value) {
+			continue
+		}
+
+		lower := strings.ToLower(strings.Join(strings.Fields(value), ""))
+		blocked := false
+		for _, pattern := range blockedValues {
+			if strings.Contains(lower, pattern) {
+				blocked = true
+				break
+			}
+		}
+		// Fixed elements could cover the application around the message
+		if name == "position" && !strings.HasPrefix(lower, "static") && !strings.HasPrefix(lower, "relative") {
+			blocked = true
+		}
+		if blocked {
+			continue
+		}
+
+		value, ok = s.cssURLs(value)
+		if ok {
+			kept = append(kept, name+":"+value)
+		}
+	}
+	return strings.Join(kept, ";")
+}
+
+// cssURLs checks the url() references of a value. It reports false when
+// one of them is not allowed.
+func (s *sanitizer) cssURLs(value string) (string, bool) {
+	allowed := true
+	value = urlFunction.ReplaceAllStringFunc(value, func(match string) string {
+		groups := urlFunction.FindStringSubmatch(match)
+		u, ok := s.imageURL(groups[1]+groups[2]+groups[3], Image{Background: true})
+		if !ok || strings.ContainsAny(u, `"`) {
+			allowed = false
+			return ""
+		}
+		return `url("` + u + `")`
+	})
+	// A url( the pattern did not recognize could hide any reference
+	if allowed && strings.Count(strings.ToLower(value), "url(") != len(urlFunction.FindAllString(value, -1)) {
+		allowed = false
+	}
+	return value, allowed
+}
+
+// stripComments removes /* */ comments outside of strings
+func stripComments(css string) string {
+	if !strings.Contains(css, "/*") {
+		return css
+	}
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '/' && i+1 < len(css) && css[i+1] == '*':
+			end := strings.Index(css[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 3
+			b.WriteByte(' ')
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// scanUntil returns the index of the first of chars outside of strings and
+// parentheses, or len(css)
+func scanUntil(css, chars string) int {
+	var quote byte
+	parens := 0
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			parens++
+		case c == ')':
+			parens--
+		case parens <= 0 && strings.IndexByte(chars, c) >= 0:
+			return i
+		}
+	}
+	return len(css)
+}
+
+// splitBlock splits css after an opening brace into the block's content
+// and what follows its closing brace
+func splitBlock(css string) (block, rest string) {
+	depth := 1
+	var quote byte
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return css[:i], css[i+1:]
+			}
+		}
+	}
+	return css, ""
+}
+
+// splitTopLevel splits s at sep outside of strings and parentheses
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	for {
+		i := scanUntil(s, string(sep))
+		parts = append(parts, s[:i])
+		if i == len(s) {
+			return parts
+		}
+		s = s[i+1:]
+	}
+}
+
+// balancedQuotes reports whether every string in css is closed, so that
+// none can run into the rules that follow
+func balancedQuotes(css string) bool {
+	return strings.Count(css, `"`)%2 == 0 && strings.Count(css, "'")%2 == 0
+}
+
+// cutKeyword removes a leading element name or pseudo-class from a selector
+func cutKeyword(selector, keyword string) (string, bool) {
+	if len(selector) < len(keyword) || !strings.EqualFold(selector[:len(keyword)], keyword) {
+		return selector, false
+	}
+	rest := selector[len(keyword):]
+	if rest != "" && (isNameStart(rest[0]) || rest[0] >= '0' && rest[0] <= '9') {
+		return selector, false
+	}
+	return rest, true
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '-' || c >= 0x80
+}
@@ -0,0 +1,371 @@
+// Package htmlsafe sanitizes HTML message bodies for display inside the
+// application. Only an allow-list of elements and attributes is kept,
+// scripts, forms, frames and event handlers are removed, style sheets are
+// limited to the message and URLs are restricted to safe schemes.
+package htmlsafe
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Options control how a message body is sanitized
+type Options struct {
+	// Scope is the class of the element the sanitized body is wrapped in.
+	// Style rules of the message only apply inside it, and the message's
+	// own class names and ids are prefixed with Scope + "-" so they cannot
+	// match elements of the application.
+	Scope string
+	// ResolveCID returns the URL of the part with the given Content-ID,
+	// which cid: references are replaced with. References it cannot
+	// resolve are removed.
+	ResolveCID func(contentID string) (string, bool)
+	// RemoteImage decides whether an http or https image is loaded and
+	// returns the URL to load it from. When nil, remote images are kept.
+	RemoteImage func(image Image) (string, bool)
+	// Link returns the URL a link to a web page points to. When nil, links
+	// are kept.
+	Link func(u *url.URL) string
+}
+
+// Image is a remote image referenced by a message
+type Image struct {
+	URL *url.URL
+	// Hidden is set for images too small to see or hidden with CSS, which
+	// is how tracking pixels are usually embedded
+	Hidden bool
+	// Background is set for images referenced from CSS
+	Background bool
+}
+
+// allowedElements are kept with their allowed attributes
+var allowedElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.Address: true, atom.Article: true,
+	atom.Aside: true, atom.B: true, atom.Bdi: true, atom.Bdo: true,
+	atom.Big: true, atom.Blockquote: true, atom.Br: true, atom.Caption: true,
+	atom.Center: true, atom.Cite: true, atom.Code: true, atom.Col: true,
+	atom.Colgroup: true, atom.Dd: true, atom.Del: true, atom.Details: true,
+	atom.Dfn: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Em: true, atom.Figcaption: true, atom.Figure: true, atom.Font: true,
+	atom.Footer: true, atom.H1: true, atom.H2: true, atom.H3: true,
+	atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true,
+	atom.Hr: true, atom.I: true, atom.Img: true, atom.Ins: true,
+	atom.Kbd: true, atom.Li: true, atom.Main: true, atom.Mark: true,
+	atom.Nav: true, atom.Ol: true, atom.P: true, atom.Pre: true,
+	atom.Q: true, atom.S: true, atom.Samp: true, atom.Section: true,
+	atom.Small: true, atom.Span: true, atom.Strike: true, atom.Strong: true,
+	atom.Sub: true, atom.Summary: true, atom.Sup: true, atom.Table: true,
+	atom.Tbody: true, atom.Td: true, atom.Tfoot: true, atom.Th: true,
+	atom.Thead: true, atom.Time: true, atom.Tr: true, atom.Tt: true,
+	atom.U: true, atom.Ul: true, atom.Var: true, atom.Wbr: true,
+}
+
+// droppedElements are removed together with their content. Elements that
+// are neither allowed nor dropped, such as <html>, <body> or <form>, are
+// replaced by their content.
+var droppedElements = map[atom.Atom]bool{
+	atom.Applet: true, atom.Audio: true, atom.Base: true, atom.Button: true,
+	atom.Canvas: true, atom.Datalist: true, atom.Dialog: true, atom.Embed: true,
+	atom.Frame: true, atom.Frameset: true, atom.Iframe: true, atom.Input: true,
+	atom.Keygen: true, atom.Link: true, atom.Map: true, atom.Math: true,
+	atom.Meta: true, atom.Noembed: true, atom.Noframes: true, atom.Noscript: true,
+	atom.Object: true, atom.Optgroup: true, atom.Option: true, atom.Output: true,
+	atom.Param: true, atom.Script: true, atom.Select: true,
+	atom.Slot: true, atom.Source: true, atom.Svg: true, atom.Template: true,
+	atom.Textarea: true, atom.Title: true, atom.Track: true, atom.Video: true,
+}
+
+// allowedAttributes are kept on every allowed element. URL attributes,
+// class, id and style are handled separately.
+var allowedAttributes = map[string]bool{
+	"align": true, "alt": true, "bgcolor": true, "border": true,
+	"cellpadding": true, "cellspacing": true, "color": true, "cols": true,
+	"colspan": true, "datetime": true, "dir": true, "face": true,
+	"height": true, "hspace": true, "lang": true, "nowrap": true,
+	"open": true, "reversed": true, "rowspan": true, "scope": true,
+	"size": true, "span": true, "start": true, "summary": true,
+	"title": true, "type": true, "valign": true, "value": true,
+	"vspace": true, "width": true,
+}
+
+// linkSchemes are the schemes a link may use
+var linkSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "tel": true}
+
+// imageTypes are the data: media types an image may use. SVG can carry
+// scripts and is not among them.
+var imageTypes = map[string]bool{
+	"image/bmp": true, "image/gif": true, "image/jpeg": true, "image/jpg": true,
+	"image/png": true, "image/webp": true,
+}
+
+// Sanitize returns the sanitized body of an HTML document or fragment,
+// wrapped in a <div> of class opts.Scope. The wrapper is empty for
+// documents without a body, such as a <frameset>.
+func Sanitize(source string, opts Options) string {
+	s := &sanitizer{opts: opts}
+	var style []string
+	var content strings.Builder
+
+	doc, err := html.Parse(strings.NewReader(source))
+	if err == nil {
+		// Style sheets in <head> apply to the body
+		if head := findElement(doc, atom.Head); head != nil {
+			s.clean(head)
+		}
+		body := findElement(doc, atom.Body)
+		if body == nil {
+			// A <frameset> document has no body, and its frames are not
+			// shown
+			body = &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		}
+		// The style and background color of <body> apply to the wrapper
+		for _, attr := range s.attributes(body) {
+			switch attr.Key {
+			case "bgcolor":
+				if declaration := s.declarations("background-color:" + attr.Val); declaration != "" {
+					style = append(style, declaration)
+				}
+			case "style":
+				style = append(style, attr.Val)
+			}
+		}
+		s.clean(body)
+		for c := body.FirstChild; c != nil; c = c.NextSibling {
+			html.Render(&content, c)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<div class="`)
+	b.WriteString(html.EscapeString(opts.Scope))
+	b.WriteString(`"`)
+	if len(style) > 0 {
+		b.WriteString(` style="`)
+		b.WriteString(html.EscapeString(strings.Join(style, ";")))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	for _, sheet := range s.styles {
+		if sheet != "" {
+			b.WriteString("<style>")
+			b.WriteString(sheet)
+			b.WriteString("</style>")
+		}
+	}
+	b.WriteString(content.String())
+	b.WriteString("</div>")
+	return b.String()
+}
+
+// sanitizer holds the state of one Sanitize call
+type sanitizer struct {
+	opts   Options
+	styles []string
+}
+
+// clean sanitizes the children of n in place
+func (s *sanitizer) clean(n *html.Node) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			s.cleanElement(c)
+		case html.DocumentNode:
+			s.clean(c)
+		default:
+			// Comments may hold conditional comments for old browsers
+			n.RemoveChild(c)
+		}
+	}
+}
+
+// cleanElement keeps, removes or unwraps an element
+func (s *sanitizer) cleanElement(n *html.Node) {
+	parent := n.Parent
+	switch {
+	case n.Namespace != "" || droppedElements[n.DataAtom]:
+		parent.RemoveChild(n)
+	case n.DataAtom == atom.Style:
+		if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+			s.styles = append(s.styles, s.styleSheet(n.FirstChild.Data))
+		}
+		parent.RemoveChild(n)
+	case allowedElements[n.DataAtom]:
+		n.Attr = s.attributes(n)
+		s.clean(n)
+		// Links within the message scroll it rather than open a window
+		if href := getAttr(n, "href"); n.DataAtom == atom.A && href != "" && !strings.HasPrefix(href, "#") {
+			n.Attr = append(n.Attr,
+				html.Attribute{Key: "target", Val: "_blank"},
+				html.Attribute{Key: "rel", Val: "noopener noreferrer"})
+		}
+	default:
+		s.clean(n)
+		for c := n.FirstChild; c != nil; c = n.FirstChild {
+			n.RemoveChild(c)
+			parent.InsertBefore(c, n)
+		}
+		parent.RemoveChild(n)
+	}
+}
+
+// attributes returns the sanitized attributes of an element
+func (s *sanitizer) attributes(n *html.Node) []html.Attribute {
+	var attrs []html.Attribute
+	image := Image{Hidden: n.DataAtom == atom.Img && hiddenImage(n)}
+	for _, attr := range n.Attr {
+		if attr.Namespace != "" {
+			continue
+		}
+		key := strings.ToLower(attr.Key)
+		value := attr.Val
+		ok := false
+		switch {
+		case key == "href" && n.DataAtom == atom.A:
+			value, ok = s.linkURL(value)
+		case key == "src" && n.DataAtom == atom.Img:
+			value, ok = s.imageURL(value, image)
+		case key == "background":
+			value, ok = s.imageURL(value, Image{Background: true})
+		case key == "class":
+			value = s.classes(value)
+			ok = value != ""
+		case key == "id":
+			value = s.prefixed(strings.TrimSpace(value))
+			ok = value != ""
+		case key == "style":
+			value = s.declarations(value)
+			ok = value != ""
+		case allowedAttributes[key]:
+			ok = true
+		}
+		if ok {
+			attrs = append(attrs, html.Attribute{Key: key, Val: value})
+		}
+	}
+	return attrs
+}
+
+// classes prefixes every class name of a class attribute
+func (s *sanitizer) classes(value string) string {
+	names := strings.Fields(value)
+	for i, name := range names {
+		names[i] = s.prefixed(name)
+	}
+	return strings.Join(names, " ")
+}
+
+// prefixed returns a class name or id of the message in its own namespace
+func (s *sanitizer) prefixed(name string) string {
+	if name == "" || s.opts.Scope == "" {
+		return name
+	}
+	return s.opts.Scope + "-" + name
+}
+
+// linkURL returns the URL a link may point to
+func (s *sanitizer) linkURL(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "#") {
+		// Links within the message follow its prefixed ids
+		return "#" + s.prefixed(raw[1:]), len(raw) > 1
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !linkSchemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	if s.opts.Link != nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return s.opts.Link(u), true
+	}
+	return u.String(), true
+}
+
+// imageURL returns the URL an image may be loaded from. Relative URLs would
+// resolve against the application and are removed.
+func (s *sanitizer) imageURL(raw string, image Image) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "//") {
+		raw = "https:" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if s.opts.RemoteImage == nil {
+			return u.String(), true
+		}
+		image.URL = u
+		return s.opts.RemoteImage(image)
+	case "cid":
+		if s.opts.ResolveCID == nil {
+			return "", false
+		}
+		id, err := url.PathUnescape(u.Opaque)
+		if err != nil {
+			return "", false
+		}
+		return s.opts.ResolveCID(strings.Trim(id, "<>"))
+	case "data":
+		mediaType, _, _ := strings.Cut(u.Opaque, ",")
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		return raw, imageTypes[strings.ToLower(mediaType)]
+	}
+	return "", false
+}
+
+// hiddenImage reports whether an <img> is at most one pixel wide or high,
+// or hidden with its style
+func hiddenImage(n *html.Node) bool {
+	for _, key := range []string{"width", "height"} {
+		value := strings.TrimSuffix(strings.TrimSpace(getAttr(n, key)), "px")
+		if size, err := strconv.Atoi(value); err == nil && size <= 1 {
+			return true
+		}
+	}
+	for _, declaration := range strings.Split(getAttr(n, "style"), ";") {
+		name, value, _ := strings.Cut(declaration, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important")))
+		switch {
+		case name == "display" && value == "none",
+			name == "visibility" && value == "hidden",
+			name == "opacity" && (value == "0" || value == "0.0"),
+			(name == "width" || name == "height" || name == "max-width" || name == "max-height") &&
+				(value == "0" || value == "0px" || value == "1px"):
+			return true
+		}
+	}
+	return false
+}
+
+// findElement returns the first element of a parsed document with the given
+// name, or nil. Parsed documents have a <head>, and a <body> unless they
+// are a <frameset> document.
+func findElement(n *html.Node, name atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == name {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,184 @@
+// Package htmltext converts between HTML message bodies and plain text
+package htmltext
+
+import (
+	"html"
+	"strings"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// blockElements start a new line in the text rendering
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"div": true, "dl": true, "dt": true, "dd": true, "fieldset": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true,
+	"li": true, "main": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "tr": true, "ul": true,
+}
+
+// skippedElements have content that is never shown
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true, "template": true,
+}
+
+// ToText renders HTML as plain text: markup is dropped, block elements and
+// <br> become line breaks, whitespace is collapsed as a browser would and
+// runs of blank lines are reduced to one.
+func ToText(source string) string {
+	z := nethtml.NewTokenizer(strings.NewReader(source))
+	w := &textWriter{}
+	skipDepth := 0
+	preDepth := 0
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case nethtml.ErrorToken:
+			return tidy(w.String())
+
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if tt == nethtml.StartTagToken {
+				if skippedElements[tag] {
+					skipDepth++
+				}
+				if tag == "pre" {
+					preDepth++
+				}
+			}
+			if tag == "br" || blockElements[tag] {
+				w.newline()
+			}
+			if tag == "li" {
+				w.raw("- ")
+			}
+			if tag == "td" || tag == "th" {
+				w.space = true
+			}
+
+		case nethtml.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			if skippedElements[tag] && skipDepth > 0 {
+				skipDepth--
+			}
+			if tag == "pre" && preDepth > 0 {
+				preDepth--
+			}
+			// List items are separated by their start tags alone
+			if blockElements[tag] && tag != "li" {
+				w.newline()
+			}
+
+		case nethtml.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			text := string(z.Text())
+			if preDepth > 0 {
+				w.raw(text)
+				continue
+			}
+			if startsWithSpace(text) {
+				w.space = true
+			}
+			for i, word := range strings.Fields(text) {
+				if i > 0 {
+					w.space = true
+				}
+				w.word(word)
+			}
+			if endsWithSpace(text) {
+				w.space = true
+			}
+		}
+	}
+}
+
+// textWriter collapses whitespace between words
+type textWriter struct {
+	strings.Builder
+	space     bool // a space is due before the next word
+	lineStart bool
+}
+
+func (w *textWriter) word(s string) {
+	if w.space && !w.lineStart && w.Len() > 0 {
+		w.WriteString(" ")
+	}
+	w.WriteString(s)
+	w.space = false
+	w.lineStart = false
+}
+
+func (w *textWriter) raw(s string) {
+	w.WriteString(s)
+	w.space = false
+	w.lineStart = strings.HasSuffix(s, "\n") || s == "- "
+}
+
+func (w *textWriter) newline() {
+	w.WriteString("\n")
+	w.space = false
+	w.lineStart = true
+}
+
+// FromText renders plain text as HTML, keeping line breaks and escaping
+// markup characters
+func FromText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var b strings.Builder
+	b.WriteString("<html><body>")
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		if strings.TrimSpace(paragraph) == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>"))
+		b.WriteString("</p>")
+	}
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+// Preview returns up to max runes of text with whitespace collapsed, for
+// message list previews
+func Preview(text string, max int) string {
+	preview := strings.Join(strings.Fields(text), " ")
+	runes := []rune(preview)
+	if len(runes) <= max {
+		return preview
+	}
+	return strings.TrimSpace(string(runes[:max-3])) + "..."
+}
+
+func startsWithSpace(s string) bool {
+	return s != "" && strings.TrimLeft(s, " \t\r\n\f") != s
+}
+
+func endsWithSpace(s string) bool {
+	return s != "" && strings.TrimRight(s, " \t\r\n\f") != s
+}
+
+// tidy trims trailing spaces and collapses blank lines
+func tidy(s string) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,471 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of DATE and DATE-TIME values
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+)
+
+// component is a parsed BEGIN/END block
+type component struct {
+	name       string
+	properties []property
+	children   []*component
+}
+
+// property returns the first property with the given name
+func (c *component) property(name string) (property, bool) {
+	for _, p := range c.properties {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return property{}, false
+}
+
+// text returns the unescaped value of the first property with the given
+// name, or ""
+func (c *component) text(name string) string {
+	p, _ := c.property(name)
+	return unescapeText(p.value)
+}
+
+// property is one parsed content line
+type property struct {
+	name   string              // upper-case
+	params map[string][]string // upper-case keys, unquoted values
+	value  string              // raw (still escaped) value
+}
+
+func (p property) param(name string) string {
+	if values := p.params[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Parse reads an iCalendar object
+func Parse(r io.Reader) (*Calendar, error) {
+	root, err := readComponents(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := &Calendar{
+		ProductID: root.text("PRODID"),
+		Method:    strings.ToUpper(strings.TrimSpace(root.text("METHOD"))),
+	}
+	zones := make(map[string]*timezone)
+	for _, child := range root.children {
+		if child.name == "VTIMEZONE" {
+			if tz, err := parseTimezone(child); err == nil {
+				zones[tz.id] = tz
+			}
+		}
+	}
+	for _, child := range root.children {
+		if child.name != "VEVENT" {
+			continue
+		}
+		event, err := parseEvent(child, zones)
+		if err != nil {
+			return nil, err
+		}
+		cal.Events = append(cal.Events, event)
+	}
+	return cal, nil
+}
+
+// readComponents reads the content lines of r into the tree of the
+// VCALENDAR component
+func readComponents(r io.Reader) (*component, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var stack []*component
+	var root *component
+	lineNumber := 0
+	handle := func(line string) error {
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		prop, err := parseProperty(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		switch prop.name {
+		case "BEGIN":
+			c := &component{name: strings.ToUpper(strings.TrimSpace(prop.value))}
+			if len(stack) == 0 {
+				if c.name != "VCALENDAR" {
+					return fmt.Errorf("line %d: %w: BEGIN:%s outside VCALENDAR", lineNumber, ErrMalformed, c.name)
+				}
+				if root != nil {
+					// Only the first calendar of a stream is read
+					return io.EOF
+				}
+				root = c
+			} else {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			name := strings.ToUpper(strings.TrimSpace(prop.value))
+			if len(stack) == 0 || stack[len(stack)-1].name != name {
+				return fmt.Errorf("line %d: %w: unexpected END:%s", lineNumber, ErrMalformed, name)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return fmt.Errorf("line %d: %w: property outside VCALENDAR", lineNumber, ErrMalformed)
+			}
+			current := stack[len(stack)-1]
+			current.properties = append(current.properties, prop)
+		}
+		return nil
+	}
+
+	// Lines starting with white space continue the previous one
+	var pending string
+	havePending := false
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if havePending && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			pending += line[1:]
+			continue
+		}
+		if havePending {
+			if err := handle(pending); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		pending, havePending = line, true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if havePending {
+		if err := handle(pending); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("%w: missing BEGIN:VCALENDAR", ErrMalformed)
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%w: missing END:%s", ErrMalformed, stack[len(stack)-1].name)
+	}
+	return root, nil
+}
+
+// parseProperty splits "NAME;PARAM=a,b;PARAM2="c:d":value"
+func parseProperty(line string) (property, error) {
+	colon := -1
+	inQuotes := false
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, fmt.Errorf("%w: missing ':' in %q", ErrMalformed, line)
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	parts := splitUnquoted(head, ';')
+	prop := property{
+		name:   strings.ToUpper(strings.TrimSpace(parts[0])),
+		params: make(map[string][]string),
+		value:  value,
+	}
+	for _, param := range parts[1:] {
+		key, val, found := strings.Cut(param, "=")
+		if !found {
+			continue
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		for _, v := range splitUnquoted(val, ',') {
+			prop.params[key] = append(prop.params[key], strings.Trim(v, `"`))
+		}
+	}
+	return prop, nil
+}
+
+// splitUnquoted splits s on sep outside double quotes
+func splitUnquoted(s string, sep rune) []string {
+	var parts []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case r == sep && !inQuotes:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, current.String())
+}
+
+// parseEvent reads a VEVENT component
+func parseEvent(c *component, zones map[string]*timezone) (*Event, error) {
+	event := &Event{
+		UID:         strings.TrimSpace(c.text("UID")),
+		Status:      strings.ToUpper(strings.TrimSpace(c.text("STATUS"))),
+		Summary:     c.text("SUMMARY"),
+		Location:    c.text("LOCATION"),
+		Description: c.text("DESCRIPTION"),
+	}
+	if event.UID == "" {
+		return nil, fmt.Errorf("%w: event without UID", ErrMalformed)
+	}
+	if sequence := strings.TrimSpace(c.text("SEQUENCE")); sequence != "" {
+		n, err := strconv.Atoi(sequence)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid SEQUENCE %q", ErrMalformed, sequence)
+		}
+		event.Sequence = n
+	}
+	if p, ok := c.property("DTSTAMP"); ok {
+		event.Stamp, _, _ = parseTime(p, zones)
+	}
+
+	start, ok := c.property("DTSTART")
+	if !ok {
+		return nil, fmt.Errorf("%w: event %s without DTSTART", ErrMalformed, event.UID)
+	}
+	var err error
+	event.Start, event.AllDay, err = parseTime(start, zones)
+	if err != nil {
+		return nil, err
+	}
+	if !event.AllDay && !strings.HasSuffix(start.value, "Z") {
+		event.TimeZone = start.param("TZID")
+	}
+
+	switch {
+	case hasProperty(c, "DTEND"):
+		end, _ := c.property("DTEND")
+		if event.End, _, err = parseTime(end, zones); err != nil {
+			return nil, err
+		}
+	case hasProperty(c, "DURATION"):
+		p, _ := c.property("DURATION")
+		d, err := parseDuration(p.value)
+		if err != nil {
+			return nil, err
+		}
+		event.End = addDuration(event.Start, d)
+	case event.AllDay:
+		// A date without an end lasts the day (RFC 5545 section 3.6.1)
+		event.End = event.Start.AddDate(0, 0, 1)
+	default:
+		event.End = event.Start
+	}
+
+	if p, ok := c.property("RECURRENCE-ID"); ok {
+		if event.RecurrenceID, _, err = parseTime(p, zones); err != nil {
+			return nil, err
+		}
+	}
+	if p, ok := c.property("RRULE"); ok {
+		if event.Recurrence, err = ParseRecurrence(p.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if p, ok := c.property("ORGANIZER"); ok {
+		event.Organizer = parseParticipant(p)
+	}
+	for _, p := range c.properties {
+		if p.name == "ATTENDEE" {
+			event.Attendees = append(event.Attendees, parseParticipant(p))
+		}
+	}
+	return event, nil
+}
+
+func hasProperty(c *component, name string) bool {
+	_, ok := c.property(name)
+	return ok
+}
+
+// parseParticipant reads an ORGANIZER or ATTENDEE property
+func parseParticipant(p property) *Participant {
+	email := strings.TrimSpace(p.value)
+	if len(email) >= len("mailto:") && strings.EqualFold(email[:len("mailto:")], "mailto:") {
+		email = email[len("mailto:"):]
+	}
+	partStat := strings.ToUpper(p.param("PARTSTAT"))
+	if partStat == "" && p.name == "ATTENDEE" {
+		partStat = PartStatNeedsAction
+	}
+	return &Participant{
+		Email:    email,
+		Name:     p.param("CN"),
+		Role:     strings.ToUpper(p.param("ROLE")),
+		PartStat: partStat,
+		RSVP:     strings.EqualFold(p.param("RSVP"), "TRUE"),
+	}
+}
+
+// parseTime reads a DATE or DATE-TIME value, reporting whether it is a
+// date. Times with a TZID are resolved in its VTIMEZONE or, failing
+// that, the IANA zone of the same name; floating times are local.
+func parseTime(p property, zones map[string]*timezone) (time.Time, bool, error) {
+	value := strings.TrimSpace(p.value)
+	if strings.EqualFold(p.param("VALUE"), "DATE") || len(value) == len(dateLayout) {
+		t, err := time.Parse(dateLayout, value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: invalid %s %q", ErrMalformed, p.name, value)
+		}
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(dateTimeLayout+"Z", value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: invalid %s %q", ErrMalformed, p.name, value)
+		}
+		return t, false, nil
+	}
+
+	wall, err := time.Parse(dateTimeLayout, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: invalid %s %q", ErrMalformed, p.name, value)
+	}
+	return inZone(wall, p.param("TZID"), zones), false, nil
+}
+
+// inZone interprets the wall clock time of wall, read as UTC, in the zone
+// named tzid
+func inZone(wall time.Time, tzid string, zones map[string]*timezone) time.Time {
+	if tzid == "" {
+		return setLocation(wall, time.Local)
+	}
+	if tz, ok := zones[tzid]; ok {
+		return tz.resolve(wall)
+	}
+	// Some producers prefix the zone name with a slash
+	if loc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+		return setLocation(wall, loc)
+	}
+	return setLocation(wall, time.Local)
+}
+
+// setLocation returns the time with wall's clock reading in loc
+func setLocation(wall time.Time, loc *time.Location) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, loc)
+}
+
+// duration is a DURATION value: days are kept apart from the exact part
+// since a day is not always 24 hours
+type duration struct {
+	days  int
+	exact time.Duration
+}
+
+// parseDuration reads a DURATION value, such as "PT1H30M" or "-P1W"
+func parseDuration(value string) (duration, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	invalid := fmt.Errorf("%w: invalid DURATION %q", ErrMalformed, value)
+
+	sign := 1
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign, s = -1, s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return duration{}, invalid
+	}
+	s = s[1:]
+
+	var d duration
+	inTime := false
+	number := ""
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			number += string(r)
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return duration{}, invalid
+		}
+		number = ""
+		switch {
+		case r == 'W' && !inTime:
+			d.days += 7 * n
+		case r == 'D' && !inTime:
+			d.days += n
+		case r == 'H' && inTime:
+			d.exact += time.Duration(n) * time.Hour
+		case r == 'M' && inTime:
+			d.exact += time.Duration(n) * time.Minute
+		case r == 'S' && inTime:
+			d.exact += time.Duration(n) * time.Second
+		default:
+			return duration{}, invalid
+		}
+	}
+	if number != "" {
+		return duration{}, invalid
+	}
+	d.days *= sign
+	d.exact *= time.Duration(sign)
+	return d, nil
+}
+
+// addDuration adds d to t, days in t's calendar
+func addDuration(t time.Time, d duration) time.Time {
+	return t.AddDate(0, 0, d.days).Add(d.exact)
+}
+
+// unescapeText reverses the TEXT escaping of RFC 5545 section 3.3.11
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the folding limit of RFC 5545 section 3.1
+const maxLineOctets = 75
+
+// Write writes a calendar. Times are written in UTC, so the object needs
+// no VTIMEZONE; the dates of all-day events are written as dates.
+func Write(w io.Writer, c *Calendar) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.writeLine("BEGIN:VCALENDAR")
+	productID := c.ProductID
+	if productID == "" {
+		productID = ProductID
+	}
+	e.writeLine("PRODID:" + escapeText(productID))
+	e.writeLine("VERSION:2.0")
+	e.writeLine("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		e.writeLine("METHOD:" + c.Method)
+	}
+	for _, event := range c.Events {
+		e.writeEvent(event)
+	}
+	e.writeLine("END:VCALENDAR")
+	return e.w.Flush()
+}
+
+// encoder writes content lines; errors surface on Flush
+type encoder struct {
+	w *bufio.Writer
+}
+
+func (e *encoder) writeEvent(event *Event) {
+	e.writeLine("BEGIN:VEVENT")
+	e.writeLine("UID:" + escapeText(event.UID))
+	if event.Sequence != 0 {
+		e.writeLine("SEQUENCE:" + strconv.Itoa(event.Sequence))
+	}
+	stamp := event.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	e.writeLine("DTSTAMP:" + formatUTC(stamp))
+	if !event.RecurrenceID.IsZero() {
+		e.writeLine("RECURRENCE-ID" + formatTime(event.RecurrenceID, event.AllDay))
+	}
+	if !event.Start.IsZero() {
+		e.writeLine("DTSTART" + formatTime(event.Start, event.AllDay))
+	}
+	if !event.End.IsZero() {
+		e.writeLine("DTEND" + formatTime(event.End, event.AllDay))
+	}
+	if event.Recurrence != nil {
+		e.writeLine("RRULE:" + event.Recurrence.String())
+	}
+	for _, p := range []struct{ name, value string }{
+		{"SUMMARY", event.Summary},
+		{"LOCATION", event.Location},
+		{"DESCRIPTION", event.Description},
+	} {
+		if p.value != "" {
+			e.writeLine(p.name + ":" + escapeText(p.value))
+		}
+	}
+	if event.Status != "" {
+		e.writeLine("STATUS:" + event.Status)
+	}
+	if event.Organizer != nil {
+		e.writeLine("ORGANIZER" + participantParams(event.Organizer, false) + ":mailto:" + event.Organizer.Email)
+	}
+	for _, attendee := range event.Attendees {
+		e.writeLine("ATTENDEE" + participantParams(attendee, true) + ":mailto:" + attendee.Email)
+	}
+	e.writeLine("END:VEVENT")
+}
+
+// participantParams renders the parameters of an ORGANIZER or ATTENDEE
+func participantParams(p *Participant, attendee bool) string {
+	var params []string
+	if p.Name != "" {
+		params = append(params, "CN="+quoteParam(p.Name))
+	}
+	if attendee {
+		if p.Role != "" {
+			params = append(params, "ROLE="+p.Role)
+		}
+		if p.PartStat != "" {
+			params = append(params, "PARTSTAT="+p.PartStat)
+		}
+		if p.RSVP {
+			params = append(params, "RSVP=TRUE")
+		}
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return ";" + strings.Join(params, ";")
+}
+
+// quoteParam quotes a parameter value containing separators. Parameter
+// values cannot hold double quotes, so they are dropped.
+func quoteParam(value string) string {
+	value = strings.ReplaceAll(value, `"`, "")
+	if strings.ContainsAny(value, ";:,") {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+// formatTime renders the value and its VALUE parameter: a date, or a time
+// in UTC
+func formatTime(t time.Time, date bool) string {
+	if date {
+		return ";VALUE=DATE:" + t.Format(dateLayout)
+	}
+	return ":" + formatUTC(t)
+}
+
+func formatUTC(t time.Time) string {
+	return t.UTC().Format(dateTimeLayout + "Z")
+}
+
+// writeLine writes a content line folded at 75 octets without splitting
+// UTF-8 sequences, terminated by CRLF
+func (e *encoder) writeLine(line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		e.w.WriteString(line[:cut])
+		e.w.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts toward the limit
+		limit = maxLineOctets - 1
+	}
+	e.w.WriteString(line)
+	e.w.WriteString("\r\n")
+}
+
+// escapeText applies the TEXT escaping of RFC 5545 section 3.3.11
+func escapeText(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,122 @@
+// Package ical reads and writes iCalendar (RFC 5545) objects and builds the
+// iTIP (RFC 5546) replies to invitations.
+//
+// Only events are modelled: their time, recurrence rule, description,
+// organizer and attendees. To-dos, journals, alarms and other properties
+// are skipped when decoding. Time zones are resolved from the object's
+// VTIMEZONE definitions, or from the IANA database when it defines none.
+package ical
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// MediaType is the media type of iCalendar objects
+const MediaType = "text/calendar"
+
+// ProductID identifies Palm in the objects it writes
+const ProductID = "-//Palm//Palm Mail//EN"
+
+// iTIP methods (RFC 5546 section 1.4)
+const (
+	MethodPublish = "PUBLISH"
+	MethodRequest = "REQUEST"
+	MethodReply   = "REPLY"
+	MethodCancel  = "CANCEL"
+)
+
+// Participation statuses of an attendee
+const (
+	PartStatNeedsAction = "NEEDS-ACTION"
+	PartStatAccepted    = "ACCEPTED"
+	PartStatTentative   = "TENTATIVE"
+	PartStatDeclined    = "DECLINED"
+)
+
+// Statuses of an event
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Common errors
+var (
+	ErrMalformed   = errors.New("malformed iCalendar object")
+	ErrInvalidRule = errors.New("invalid recurrence rule")
+)
+
+// Calendar is an iCalendar object
+type Calendar struct {
+	ProductID string
+	Method    string // iTIP method; empty for a plain calendar
+	Events    []*Event
+}
+
+// Event is a VEVENT component. Times are absolute, except the dates of
+// all-day events, which are midnight UTC, and floating times, which are
+// read in the local time zone.
+type Event struct {
+	UID          string
+	Sequence     int
+	Stamp        time.Time // DTSTAMP
+	Status       string
+	Summary      string
+	Location     string
+	Description  string
+	Start        time.Time
+	End          time.Time // Exclusive; derived from DURATION when absent
+	AllDay       bool
+	TimeZone     string    // TZID of the start; empty for UTC, floating and dates
+	RecurrenceID time.Time // Set when the event overrides one occurrence
+	Recurrence   *Recurrence
+	Organizer    *Participant
+	Attendees    []*Participant
+}
+
+// Participant is the organizer or an attendee of an event
+type Participant struct {
+	Email    string // From the mailto: URI
+	Name     string // CN
+	Role     string // e.g. REQ-PARTICIPANT; attendees only
+	PartStat string // Attendees only
+	RSVP     bool   // A reply is expected; attendees only
+}
+
+// Attendee returns the attendee with the given address, compared without
+// case, or nil
+func (e *Event) Attendee(email string) *Participant {
+	for _, attendee := range e.Attendees {
+		if strings.EqualFold(attendee.Email, email) {
+			return attendee
+		}
+	}
+	return nil
+}
+
+// Reply builds the iTIP REPLY of an attendee to an event of a REQUEST,
+// answering partStat. The event's identifying properties are copied;
+// the reply names the attendee alone (RFC 5546 section 3.2.3).
+func Reply(event *Event, attendee *Participant, partStat string, now time.Time) *Calendar {
+	answer := *attendee
+	answer.PartStat = partStat
+	answer.RSVP = false
+	return &Calendar{
+		ProductID: ProductID,
+		Method:    MethodReply,
+		Events: []*Event{{
+			UID:          event.UID,
+			Sequence:     event.Sequence,
+			Stamp:        now.UTC(),
+			Summary:      event.Summary,
+			Start:        event.Start,
+			End:          event.End,
+			AllDay:       event.AllDay,
+			RecurrenceID: event.RecurrenceID,
+			Organizer:    event.Organizer,
+			Attendees:    []*Participant{&answer},
+		}},
+	}
+}
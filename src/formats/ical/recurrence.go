@@ -0,0 +1,352 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequencies of a recurrence rule
+const (
+	FrequencySecondly = "SECONDLY"
+	FrequencyMinutely = "MINUTELY"
+	FrequencyHourly   = "HOURLY"
+	FrequencyDaily    = "DAILY"
+	FrequencyWeekly   = "WEEKLY"
+	FrequencyMonthly  = "MONTHLY"
+	FrequencyYearly   = "YEARLY"
+)
+
+// frequencyUnits names the period of each frequency
+var frequencyUnits = map[string]string{
+	FrequencySecondly: "second",
+	FrequencyMinutely: "minute",
+	FrequencyHourly:   "hour",
+	FrequencyDaily:    "day",
+	FrequencyWeekly:   "week",
+	FrequencyMonthly:  "month",
+	FrequencyYearly:   "year",
+}
+
+// weekdayCodes are the two-letter weekday names of recurrence rules
+var weekdayCodes = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// Recurrence is a recurrence rule (RFC 5545 section 3.3.10). Only the
+// parts Palm describes are modelled; the others are kept as written.
+type Recurrence struct {
+	Frequency  string
+	Interval   int       // At least 1
+	Count      int       // 0 when the rule is not limited by a count
+	Until      time.Time // Zero when the rule has no end date
+	ByDay      []WeekdayNum
+	ByMonthDay []int // Negative days count from the end of the month
+	ByMonth    []time.Month
+	BySetPos   []int
+
+	other []string // Parts not modelled, such as "WKST=MO"
+}
+
+// WeekdayNum is a BYDAY value such as "MO" or "-1SU"
+type WeekdayNum struct {
+	N   int // Occurrence within the month or year, negative from its end; 0 for every one
+	Day time.Weekday
+}
+
+// ParseRecurrence reads an RRULE value
+func ParseRecurrence(value string) (*Recurrence, error) {
+	r := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(strings.TrimSpace(value), ";") {
+		if part == "" {
+			continue
+		}
+		name, val, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRule, value)
+		}
+		name = strings.ToUpper(name)
+		var err error
+		switch name {
+		case "FREQ":
+			r.Frequency = strings.ToUpper(val)
+			if _, ok := frequencyUnits[r.Frequency]; !ok {
+				return nil, fmt.Errorf("%w: unknown frequency %q", ErrInvalidRule, val)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(val)
+			if err == nil && r.Interval < 1 {
+				err = fmt.Errorf("%w: interval %d", ErrInvalidRule, r.Interval)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(val)
+		case "UNTIL":
+			r.Until, err = parseUntil(val)
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, err := parseWeekdayNum(day)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(val)
+		case "BYMONTH":
+			var months []int
+			months, err = parseInts(val)
+			for _, month := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(month))
+			}
+		case "BYSETPOS":
+			r.BySetPos, err = parseInts(val)
+		default:
+			r.other = append(r.other, name+"="+val)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s in %q", ErrInvalidRule, name, value)
+		}
+	}
+	if r.Frequency == "" {
+		return nil, fmt.Errorf("%w: missing FREQ in %q", ErrInvalidRule, value)
+	}
+	return r, nil
+}
+
+// parseUntil reads an UNTIL value, a date or a time in UTC
+func parseUntil(value string) (time.Time, error) {
+	switch {
+	case len(value) == len(dateLayout):
+		return time.Parse(dateLayout, value)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse(dateTimeLayout+"Z", value)
+	default:
+		// Floating, which RFC 5545 allows only for floating starts
+		return time.ParseInLocation(dateTimeLayout, value, time.Local)
+	}
+}
+
+// parseWeekdayNum reads a BYDAY value
+func parseWeekdayNum(value string) (WeekdayNum, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if len(value) < 2 {
+		return WeekdayNum{}, fmt.Errorf("%w: invalid weekday %q", ErrInvalidRule, value)
+	}
+	code := value[len(value)-2:]
+	for day, c := range weekdayCodes {
+		if c != code {
+			continue
+		}
+		w := WeekdayNum{Day: time.Weekday(day)}
+		if n := value[:len(value)-2]; n != "" {
+			var err error
+			if w.N, err = strconv.Atoi(n); err != nil {
+				return WeekdayNum{}, fmt.Errorf("%w: invalid weekday %q", ErrInvalidRule, value)
+			}
+		}
+		return w, nil
+	}
+	return WeekdayNum{}, fmt.Errorf("%w: invalid weekday %q", ErrInvalidRule, value)
+}
+
+func parseInts(value string) ([]int, error) {
+	var ints []int
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// String returns the rule as an RRULE value
+func (r *Recurrence) String() string {
+	parts := []string{"FREQ=" + r.Frequency}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(dateTimeLayout+"Z"))
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]int, len(r.ByMonth))
+		for i, month := range r.ByMonth {
+			months[i] = int(month)
+		}
+		parts = append(parts, "BYMONTH="+joinInts(months))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = day.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.BySetPos) > 0 {
+		parts = append(parts, "BYSETPOS="+joinInts(r.BySetPos))
+	}
+	return strings.Join(append(parts, r.other...), ";")
+}
+
+// String returns the BYDAY form of the weekday
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return weekdayCodes[w.Day]
+	}
+	return strconv.Itoa(w.N) + weekdayCodes[w.Day]
+}
+
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// Summary describes the rule in English, such as "Every 2 weeks on Monday
+// and Wednesday, until Mar 2, 2027"
+func (r *Recurrence) Summary() string {
+	var b strings.Builder
+	unit := frequencyUnits[r.Frequency]
+	weekdays := r.isWeekdays()
+	switch {
+	case weekdays && r.Interval == 1 && (r.Frequency == FrequencyDaily || r.Frequency == FrequencyWeekly):
+		b.WriteString("Every weekday")
+	case r.Interval == 1:
+		b.WriteString("Every " + unit)
+	default:
+		fmt.Fprintf(&b, "Every %d %ss", r.Interval, unit)
+	}
+
+	switch r.Frequency {
+	case FrequencyDaily, FrequencyWeekly:
+		if len(r.ByDay) > 0 && !(weekdays && r.Interval == 1) {
+			b.WriteString(" on " + r.dayNames())
+		}
+	case FrequencyMonthly:
+		if on := r.daysOfMonth(); on != "" {
+			b.WriteString(" on " + on)
+		}
+	case FrequencyYearly:
+		months := make([]string, len(r.ByMonth))
+		for i, month := range r.ByMonth {
+			months[i] = month.String()
+		}
+		switch {
+		case len(r.ByMonth) == 1 && len(r.ByMonthDay) == 1 && len(r.ByDay) == 0:
+			fmt.Fprintf(&b, " on %s %d", months[0], r.ByMonthDay[0])
+		case len(months) > 0 && r.daysOfMonth() != "":
+			b.WriteString(" on " + r.daysOfMonth() + " of " + joinWords(months))
+		case len(months) > 0:
+			b.WriteString(" in " + joinWords(months))
+		}
+	}
+
+	switch {
+	case r.Count == 1:
+		b.WriteString(", once")
+	case r.Count > 1:
+		fmt.Fprintf(&b, ", %d times", r.Count)
+	case !r.Until.IsZero():
+		b.WriteString(", until " + r.Until.Format("Jan 2, 2006"))
+	}
+	return b.String()
+}
+
+// isWeekdays reports whether the rule recurs on every day from Monday to
+// Friday
+func (r *Recurrence) isWeekdays() bool {
+	if len(r.ByDay) != 5 {
+		return false
+	}
+	seen := make(map[time.Weekday]bool)
+	for _, day := range r.ByDay {
+		if day.N != 0 || day.Day == time.Saturday || day.Day == time.Sunday {
+			return false
+		}
+		seen[day.Day] = true
+	}
+	return len(seen) == 5
+}
+
+// dayNames lists the weekdays of the rule, such as "the first Monday" or
+// "Tuesday and Thursday"
+func (r *Recurrence) dayNames() string {
+	names := make([]string, len(r.ByDay))
+	for i, day := range r.ByDay {
+		names[i] = day.Day.String()
+		if day.N != 0 {
+			names[i] = "the " + ordinal(day.N) + " " + names[i]
+		}
+	}
+	if len(r.BySetPos) == 1 && len(names) > 0 {
+		// e.g. BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1 is the last weekday
+		if r.isWeekdays() {
+			return "the " + ordinal(r.BySetPos[0]) + " weekday"
+		}
+		return "the " + ordinal(r.BySetPos[0]) + " " + joinWords(names)
+	}
+	return joinWords(names)
+}
+
+// daysOfMonth describes the days of a monthly or yearly rule, such as
+// "day 15", "the last day" or "the second Tuesday"
+func (r *Recurrence) daysOfMonth() string {
+	switch {
+	case len(r.ByDay) > 0:
+		return r.dayNames()
+	case len(r.ByMonthDay) == 1 && r.ByMonthDay[0] < 0:
+		return "the " + ordinal(r.ByMonthDay[0]) + " day"
+	case len(r.ByMonthDay) == 1:
+		return "day " + strconv.Itoa(r.ByMonthDay[0])
+	case len(r.ByMonthDay) > 1:
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		return "days " + joinWords(days)
+	}
+	return ""
+}
+
+// ordinal names the nth occurrence, negative from the end
+func ordinal(n int) string {
+	names := []string{"first", "second", "third", "fourth", "fifth"}
+	switch {
+	case n == -1:
+		return "last"
+	case n < -1 && -n <= len(names):
+		return names[-n-1] + " to last"
+	case n > 0 && n <= len(names):
+		return names[n-1]
+	}
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// joinWords joins words as in "a, b and c"
+func joinWords(words []string) string {
+	if len(words) <= 1 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " and " + words[len(words)-1]
+}
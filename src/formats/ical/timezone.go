@@ -0,0 +1,203 @@
+package ical
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timezone is a VTIMEZONE component: the offsets a zone observes and when
+// each takes effect
+type timezone struct {
+	id          string
+	observances []observance
+}
+
+// observance is a STANDARD or DAYLIGHT sub-component
+type observance struct {
+	start      time.Time   // DTSTART: wall clock of the first onset, read as UTC
+	offsetFrom int         // Seconds east of UTC before an onset
+	offsetTo   int         // Seconds east of UTC from an onset
+	rule       *Recurrence // Yearly onsets after the first (optional)
+	dates      []time.Time // RDATE onsets, wall clock read as UTC
+}
+
+// parseTimezone reads a VTIMEZONE component
+func parseTimezone(c *component) (*timezone, error) {
+	tz := &timezone{id: strings.TrimSpace(c.text("TZID"))}
+	if tz.id == "" {
+		return nil, fmt.Errorf("%w: time zone without TZID", ErrMalformed)
+	}
+	for _, child := range c.children {
+		if child.name != "STANDARD" && child.name != "DAYLIGHT" {
+			continue
+		}
+		o, err := parseObservance(child)
+		if err != nil {
+			return nil, fmt.Errorf("time zone %s: %w", tz.id, err)
+		}
+		tz.observances = append(tz.observances, o)
+	}
+	if len(tz.observances) == 0 {
+		return nil, fmt.Errorf("%w: time zone %s has no observances", ErrMalformed, tz.id)
+	}
+	return tz, nil
+}
+
+// parseObservance reads a STANDARD or DAYLIGHT sub-component
+func parseObservance(c *component) (observance, error) {
+	var o observance
+	var err error
+	if o.start, err = time.Parse(dateTimeLayout, strings.TrimSpace(c.text("DTSTART"))); err != nil {
+		return o, fmt.Errorf("%w: invalid observance DTSTART", ErrMalformed)
+	}
+	if o.offsetFrom, err = parseOffset(c.text("TZOFFSETFROM")); err != nil {
+		return o, err
+	}
+	if o.offsetTo, err = parseOffset(c.text("TZOFFSETTO")); err != nil {
+		return o, err
+	}
+	if p, ok := c.property("RRULE"); ok {
+		if o.rule, err = ParseRecurrence(p.value); err != nil {
+			return o, err
+		}
+	}
+	for _, p := range c.properties {
+		if p.name != "RDATE" {
+			continue
+		}
+		for _, value := range strings.Split(p.value, ",") {
+			if date, err := time.Parse(dateTimeLayout, strings.TrimSpace(value)); err == nil {
+				o.dates = append(o.dates, date)
+			}
+		}
+	}
+	return o, nil
+}
+
+// parseOffset reads a UTC-OFFSET value such as "+0100" or "-053000"
+func parseOffset(value string) (int, error) {
+	s := strings.TrimSpace(value)
+	invalid := fmt.Errorf("%w: invalid UTC offset %q", ErrMalformed, value)
+	if (len(s) != 5 && len(s) != 7) || (s[0] != '+' && s[0] != '-') {
+		return 0, invalid
+	}
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(s) {
+			break
+		}
+		n, err := strconv.Atoi(s[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, invalid
+		}
+		seconds += n * unit
+	}
+	if s[0] == '-' {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+// resolve returns the time whose wall clock in the zone reads wall, read
+// as UTC: at the offset of the observance with the latest onset before it
+func (tz *timezone) resolve(wall time.Time) time.Time {
+	var latest time.Time
+	offset, found := 0, false
+	for _, o := range tz.observances {
+		onset, ok := o.lastOnset(wall)
+		if ok && (!found || onset.After(latest)) {
+			latest, offset, found = onset, o.offsetTo, true
+		}
+	}
+	if !found {
+		// Before every onset: the offset the earliest one replaces
+		first := tz.observances[0]
+		for _, o := range tz.observances[1:] {
+			if o.start.Before(first.start) {
+				first = o
+			}
+		}
+		offset = first.offsetFrom
+	}
+	return setLocation(wall, time.FixedZone(tz.id, offset))
+}
+
+// lastOnset returns the latest onset of the observance at or before wall
+func (o observance) lastOnset(wall time.Time) (time.Time, bool) {
+	var latest time.Time
+	found := false
+	consider := func(onset time.Time) {
+		if !onset.After(wall) && (!found || onset.After(latest)) {
+			latest, found = onset, true
+		}
+	}
+
+	consider(o.start)
+	for _, date := range o.dates {
+		consider(date)
+	}
+	if o.rule != nil && o.rule.Frequency == FrequencyYearly {
+		for _, year := range []int{wall.Year() - 1, wall.Year()} {
+			onset := o.rule.yearlyOnset(year, o.start)
+			switch {
+			case onset.Before(o.start):
+			case !o.rule.Until.IsZero() && onset.After(o.rule.Until):
+			case o.rule.Count > 0 && year-o.start.Year() >= o.rule.Count:
+			default:
+				consider(onset)
+			}
+		}
+	}
+	return latest, found
+}
+
+// yearlyOnset returns the occurrence in year of a yearly rule starting at
+// start, which time zone rules name by a month and a day of it
+func (r *Recurrence) yearlyOnset(year int, start time.Time) time.Time {
+	month := start.Month()
+	if len(r.ByMonth) > 0 {
+		month = r.ByMonth[0]
+	}
+	day := start.Day()
+	switch {
+	case len(r.ByDay) > 0:
+		day = nthWeekday(year, month, r.ByDay[0])
+	case len(r.ByMonthDay) > 0:
+		day = r.ByMonthDay[0]
+		if day < 0 {
+			day = daysIn(year, month) + day + 1
+		}
+	}
+	return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+}
+
+// nthWeekday returns the day of the month of the nth weekday of a month,
+// counting from its end when n is negative; 0 counts as the first
+func nthWeekday(year int, month time.Month, weekday WeekdayNum) int {
+	n := weekday.N
+	if n == 0 {
+		n = 1
+	}
+	if n > 0 {
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+		day := 1 + (int(weekday.Day)-int(first)+7)%7 + 7*(n-1)
+		for day > daysIn(year, month) {
+			day -= 7
+		}
+		return day
+	}
+	lastDay := daysIn(year, month)
+	last := time.Date(year, month, lastDay, 0, 0, 0, 0, time.UTC).Weekday()
+	day := lastDay - (int(last)-int(weekday.Day)+7)%7 + 7*(n+1)
+	for day < 1 {
+		day += 7
+	}
+	return day
+}
+
+// daysIn returns the number of days of a month
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
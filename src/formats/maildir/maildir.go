@@ -0,0 +1,305 @@
+// Package maildir reads and writes Maildir directories as kept by
+// offlineimap, mbsync, Dovecot and most Unix mail tools.
+//
+// A maildir holds one message per file in three subdirectories: tmp for
+// deliveries in progress, new for messages no reader has seen, and cur for
+// the rest. A file name is a unique key, optionally followed by ":2," and
+// the message's flags in ASCII order. Maildir++ subfolders are sibling
+// maildirs whose names start with a dot.
+package maildir
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotMaildir is returned when a directory lacks cur, new or tmp
+var ErrNotMaildir = errors.New("not a maildir")
+
+// ErrNotFound is returned when no message has the requested key
+var ErrNotFound = errors.New("message not found in maildir")
+
+// Message flags as defined by the Maildir specification
+const (
+	FlagPassed  = 'P' // resent, forwarded or bounced
+	FlagReplied = 'R'
+	FlagSeen    = 'S'
+	FlagTrashed = 'T' // marked for deletion
+	FlagDraft   = 'D'
+	FlagFlagged = 'F'
+)
+
+// infoSeparators separate the key from the flags. Besides the standard
+// colon, mbsync and offlineimap use ';' or '!' on filesystems that do not
+// allow colons in names.
+const infoSeparators = ":;!"
+
+// Flags is a set of flag letters, kept sorted and without duplicates
+type Flags string
+
+// NewFlags normalizes a flag string, dropping anything but letters
+func NewFlags(s string) Flags {
+	var seen [128]bool
+	for _, c := range s {
+		if c < 128 && (c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			seen[c] = true
+		}
+	}
+	var b strings.Builder
+	for c := range seen {
+		if seen[c] {
+			b.WriteByte(byte(c))
+		}
+	}
+	return Flags(b.String())
+}
+
+// Has reports whether flag is set
+func (f Flags) Has(flag rune) bool {
+	return strings.ContainsRune(string(f), flag)
+}
+
+// With returns the flags with flag set or cleared
+func (f Flags) With(flag rune, set bool) Flags {
+	if set {
+		return NewFlags(string(f) + string(flag))
+	}
+	return Flags(strings.ReplaceAll(string(f), string(flag), ""))
+}
+
+// Entry is one message file of a maildir
+type Entry struct {
+	Key     string // unique name without the flags
+	Flags   Flags
+	New     bool // delivered to new and not yet seen by any reader
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Dir is the root directory of a maildir
+type Dir string
+
+// Open checks that path is a maildir
+func Open(path string) (Dir, error) {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		info, err := os.Stat(filepath.Join(path, sub))
+		if err != nil || !info.IsDir() {
+			return "", fmt.Errorf("%w: %s", ErrNotMaildir, path)
+		}
+	}
+	return Dir(path), nil
+}
+
+// Create makes path a maildir, keeping any messages it already has
+func Create(path string) (Dir, error) {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(path, sub), 0o700); err != nil {
+			return "", fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+	return Dir(path), nil
+}
+
+// Folders returns the names of the Maildir++ subfolders, such as ".Sent"
+func (d Dir) Folders() ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var folders []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, ".") || name == "." || name == ".." || !entry.IsDir() {
+			continue
+		}
+		if _, err := Open(filepath.Join(string(d), name)); err == nil {
+			folders = append(folders, name)
+		}
+	}
+	return folders, nil
+}
+
+// Folder returns the Maildir++ subfolder with the given name
+func (d Dir) Folder(name string) Dir {
+	return Dir(filepath.Join(string(d), name))
+}
+
+// ModTime returns the latest modification time of new and cur. Delivering,
+// renaming or removing a message changes it, so comparing it between scans
+// tells whether the maildir needs to be read again.
+func (d Dir) ModTime() (time.Time, error) {
+	var latest time.Time
+	for _, sub := range []string{"new", "cur"} {
+		info, err := os.Stat(filepath.Join(string(d), sub))
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Entries lists the messages in new and cur, oldest first
+func (d Dir) Entries() ([]*Entry, error) {
+	var entries []*Entry
+	for _, sub := range []string{"new", "cur"} {
+		files, err := os.ReadDir(filepath.Join(string(d), sub))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read maildir: %w", err)
+		}
+		for _, file := range files {
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				// Renamed or removed by another program since ReadDir
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+			key, flags := parseName(file.Name())
+			entries = append(entries, &Entry{
+				Key:     key,
+				Flags:   flags,
+				New:     sub == "new",
+				Path:    filepath.Join(string(d), sub, file.Name()),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].ModTime.Equal(entries[j].ModTime) {
+			return entries[i].ModTime.Before(entries[j].ModTime)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// Lookup finds the message with the given key wherever it currently is
+func (d Dir) Lookup(key string) (*Entry, error) {
+	entries, err := d.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Key == key {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+}
+
+// Deliver stores a message. It is written to tmp and then moved to new, or
+// to cur when it already has flags, so readers never see a partial file.
+// Line endings are stored as LF, as mail tools on Unix expect.
+func (d Dir) Deliver(data []byte, flags Flags) (*Entry, error) {
+	key := uniqueName()
+	tmp := filepath.Join(string(d), "tmp", key)
+
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if err := writeFileSync(tmp, data); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write message: %w", err)
+	}
+
+	flags = NewFlags(string(flags))
+	entry := &Entry{Key: key, Flags: flags, Size: int64(len(data))}
+	if flags == "" {
+		entry.New = true
+		entry.Path = filepath.Join(string(d), "new", key)
+	} else {
+		entry.Path = filepath.Join(string(d), "cur", key+":2,"+string(flags))
+	}
+	if err := os.Rename(tmp, entry.Path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to deliver message: %w", err)
+	}
+	entry.ModTime = time.Now()
+	return entry, nil
+}
+
+// SetFlags replaces the flags of the message with the given key, moving it
+// to cur as a reader does once it has seen a message
+func (d Dir) SetFlags(key string, flags Flags) (*Entry, error) {
+	entry, err := d.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	flags = NewFlags(string(flags))
+	target := filepath.Join(string(d), "cur", key+":2,"+string(flags))
+	if target == entry.Path {
+		return entry, nil
+	}
+	if err := os.Rename(entry.Path, target); err != nil {
+		return nil, fmt.Errorf("failed to update flags: %w", err)
+	}
+	entry.Path, entry.Flags, entry.New = target, flags, false
+	return entry, nil
+}
+
+// Remove deletes the message with the given key
+func (d Dir) Remove(key string) error {
+	entry, err := d.Lookup(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(entry.Path)
+}
+
+// parseName splits a file name into its key and flags
+func parseName(name string) (string, Flags) {
+	for i := len(name) - 3; i >= 0; i-- {
+		if strings.IndexByte(infoSeparators, name[i]) >= 0 && name[i+1:i+3] == "2," {
+			return name[:i], NewFlags(name[i+3:])
+		}
+	}
+	return name, ""
+}
+
+var deliveries atomic.Uint64
+
+// uniqueName returns a key following the conventions of the Maildir
+// specification: the time, the process and a counter, then the host name
+func uniqueName() string {
+	now := time.Now()
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`, ";", `\073`, "!", `\041`).Replace(host)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s",
+		now.Unix(), now.Nanosecond()/1000, os.Getpid(), deliveries.Add(1), host)
+}
+
+// writeFileSync writes data to a new file and flushes it to disk
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,459 @@
+// Package managesieve is a client of the ManageSieve protocol (RFC 5804),
+// which uploads, activates and lists the Sieve scripts a mail server runs.
+//
+// Only SASL PLAIN authentication is implemented; its password is sent in
+// the clear, so connections use TLS or STARTTLS unless told otherwise.
+package managesieve
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the port ManageSieve servers listen on
+const DefaultPort = "4190"
+
+// maxLiteral bounds the strings a server may send, in bytes
+const maxLiteral = 1 << 20
+
+// Common errors
+var (
+	ErrProtocol   = errors.New("managesieve protocol error")
+	ErrNoStartTLS = errors.New("managesieve server does not offer STARTTLS")
+	ErrNoPlain    = errors.New("managesieve server does not offer PLAIN authentication")
+)
+
+// ServerError is a NO or BYE response
+type ServerError struct {
+	Status string // NO or BYE
+	Code   string // Response code without parentheses, e.g. "NONEXISTENT"
+	Text   string
+}
+
+func (e *ServerError) Error() string {
+	msg := "managesieve server replied " + e.Status
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Text != "" {
+		msg += ": " + e.Text
+	}
+	return msg
+}
+
+// Security is how a connection is protected
+type Security string
+
+// Connection security
+const (
+	SecurityStartTLS Security = "starttls" // Upgrade with STARTTLS; the default
+	SecurityTLS      Security = "tls"      // TLS from the start
+	SecurityNone     Security = "none"     // Plain text, for servers on the local host
+)
+
+// Options set how Dial connects
+type Options struct {
+	Security  Security
+	TLSConfig *tls.Config // Server name and roots; the defaults if nil
+}
+
+// Script is a script stored on the server
+type Script struct {
+	Name   string
+	Active bool
+}
+
+// Client is a connection to a ManageSieve server. It is not safe for
+// concurrent use.
+type Client struct {
+	conn         net.Conn
+	r            *bufio.Reader
+	w            *bufio.Writer
+	capabilities map[string]string
+	tls          bool
+}
+
+// Dial connects to a server at host:port, protecting the connection as
+// opts ask. The context's deadline applies to the whole session.
+func Dial(ctx context.Context, addr string, opts Options) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{}
+	if opts.TLSConfig != nil {
+		config = opts.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if opts.Security == SecurityTLS {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	c, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.tls = opts.Security == SecurityTLS
+	if opts.Security == SecurityStartTLS || opts.Security == "" {
+		if err := c.StartTLS(config); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// NewClient starts a session on a connection, reading the server's
+// greeting
+func NewClient(conn net.Conn) (*Client, error) {
+	c := &Client{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+	if _, ok := conn.(*tls.Conn); ok {
+		c.tls = true
+	}
+	if err := c.readCapabilities(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Capability returns the value of a capability the server announced, such
+// as "SIEVE" for the extensions it supports
+func (c *Client) Capability(name string) (string, bool) {
+	value, ok := c.capabilities[strings.ToUpper(name)]
+	return value, ok
+}
+
+// Extensions returns the Sieve extensions the server supports
+func (c *Client) Extensions() []string {
+	return strings.Fields(c.capabilities["SIEVE"])
+}
+
+// StartTLS upgrades the connection to TLS and reads the capabilities the
+// server announces again
+func (c *Client) StartTLS(config *tls.Config) error {
+	if c.tls {
+		return nil
+	}
+	if _, ok := c.capabilities["STARTTLS"]; !ok {
+		return ErrNoStartTLS
+	}
+	if _, err := c.command("STARTTLS"); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.tls = tlsConn, true
+	c.r, c.w = bufio.NewReader(tlsConn), bufio.NewWriter(tlsConn)
+	return c.readCapabilities()
+}
+
+// Authenticate logs in with SASL PLAIN
+func (c *Client) Authenticate(username, password string) error {
+	mechanisms := strings.Fields(strings.ToUpper(c.capabilities["SASL"]))
+	if !slices.Contains(mechanisms, "PLAIN") {
+		return ErrNoPlain
+	}
+	response := base64.StdEncoding.EncodeToString([]byte("\x00" + username + "\x00" + password))
+	_, err := c.command("AUTHENTICATE", "PLAIN", response)
+	return err
+}
+
+// CheckScript asks the server whether it accepts a script without
+// storing it
+func (c *Client) CheckScript(source string) error {
+	_, err := c.command("CHECKSCRIPT", source)
+	return err
+}
+
+// PutScript stores a script, replacing any of the same name
+func (c *Client) PutScript(name, source string) error {
+	_, err := c.command("PUTSCRIPT", name, source)
+	return err
+}
+
+// SetActive makes a script the one the server runs; an empty name
+// deactivates every script
+func (c *Client) SetActive(name string) error {
+	_, err := c.command("SETACTIVE", name)
+	return err
+}
+
+// ListScripts returns the scripts stored on the server
+func (c *Client) ListScripts() ([]Script, error) {
+	lines, err := c.command("LISTSCRIPTS")
+	if err != nil {
+		return nil, err
+	}
+	scripts := make([]Script, 0, len(lines))
+	for _, line := range lines {
+		if len(line) == 0 || line[0].atom {
+			return nil, fmt.Errorf("%w: unexpected script listing", ErrProtocol)
+		}
+		script := Script{Name: line[0].text}
+		if len(line) > 1 && line[1].atom && strings.EqualFold(line[1].text, "ACTIVE") {
+			script.Active = true
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+// GetScript returns the source of a stored script
+func (c *Client) GetScript(name string) (string, error) {
+	lines, err := c.command("GETSCRIPT", name)
+	if err != nil {
+		return "", err
+	}
+	if len(lines) != 1 || len(lines[0]) != 1 || lines[0][0].atom {
+		return "", fmt.Errorf("%w: unexpected script contents", ErrProtocol)
+	}
+	return lines[0][0].text, nil
+}
+
+// DeleteScript removes a stored script
+func (c *Client) DeleteScript(name string) error {
+	_, err := c.command("DELETESCRIPT", name)
+	return err
+}
+
+// Logout ends the session and closes the connection
+func (c *Client) Logout() error {
+	_, err := c.command("LOGOUT")
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close closes the connection without logging out
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// readCapabilities reads the capability listing the server sends on
+// connecting and after STARTTLS
+func (c *Client) readCapabilities() error {
+	lines, err := c.response()
+	if err != nil {
+		return err
+	}
+	c.capabilities = map[string]string{}
+	for _, line := range lines {
+		if len(line) == 0 || line[0].atom {
+			return fmt.Errorf("%w: unexpected capability", ErrProtocol)
+		}
+		value := ""
+		if len(line) > 1 {
+			value = line[1].text
+		}
+		c.capabilities[strings.ToUpper(line[0].text)] = value
+	}
+	return nil
+}
+
+// command sends a command with string arguments and returns the data
+// lines of its response
+func (c *Client) command(name string, args ...string) ([][]word, error) {
+	c.w.WriteString(name)
+	for _, arg := range args {
+		c.w.WriteByte(' ')
+		writeString(c.w, arg)
+	}
+	c.w.WriteString("\r\n")
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.response()
+}
+
+// writeString writes a string quoted if it can be, as a non-synchronizing
+// literal otherwise
+func writeString(w *bufio.Writer, s string) {
+	if len(s) <= 1024 && !strings.ContainsAny(s, "\r\n\"\\\x00") {
+		w.WriteString(`"` + s + `"`)
+		return
+	}
+	w.WriteString("{" + strconv.Itoa(len(s)) + "+}\r\n")
+	w.WriteString(s)
+}
+
+// word is an atom or a string of a response line
+type word struct {
+	text string
+	atom bool
+}
+
+// response reads data lines up to an OK, NO or BYE. NO and BYE are
+// returned as a *ServerError.
+func (c *Client) response() ([][]word, error) {
+	var lines [][]word
+	for {
+		line, code, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > 0 && line[0].atom {
+			status := strings.ToUpper(line[0].text)
+			text := ""
+			if len(line) > 1 {
+				text = line[1].text
+			}
+			switch status {
+			case "OK":
+				return lines, nil
+			case "NO", "BYE":
+				return nil, &ServerError{Status: status, Code: code, Text: text}
+			}
+		}
+		lines = append(lines, line)
+	}
+}
+
+// readLine reads the words of a line, reading literals in full. A
+// parenthesized response code is returned apart.
+func (c *Client) readLine() (line []word, code string, err error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, "", unexpectedEOF(err)
+		}
+		switch {
+		case b == ' ':
+		case b == '\r':
+		case b == '\n':
+			return line, code, nil
+		case b == '"':
+			s, err := c.readQuoted()
+			if err != nil {
+				return nil, "", err
+			}
+			line = append(line, word{text: s})
+		case b == '{':
+			s, err := c.readLiteral()
+			if err != nil {
+				return nil, "", err
+			}
+			line = append(line, word{text: s})
+		case b == '(':
+			if code, err = c.readCode(); err != nil {
+				return nil, "", err
+			}
+		default:
+			atom := []byte{b}
+			for {
+				next, err := c.r.Peek(1)
+				if err != nil {
+					return nil, "", unexpectedEOF(err)
+				}
+				if next[0] == ' ' || next[0] == '\r' || next[0] == '\n' || next[0] == '(' {
+					break
+				}
+				c.r.ReadByte()
+				atom = append(atom, next[0])
+			}
+			line = append(line, word{text: string(atom), atom: true})
+		}
+	}
+}
+
+func (c *Client) readQuoted() (string, error) {
+	var b strings.Builder
+	for {
+		ch, err := c.r.ReadByte()
+		if err != nil {
+			return "", unexpectedEOF(err)
+		}
+		switch ch {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if ch, err = c.r.ReadByte(); err != nil {
+				return "", unexpectedEOF(err)
+			}
+		case '\r', '\n':
+			return "", fmt.Errorf("%w: line break in a quoted string", ErrProtocol)
+		}
+		if b.Len() >= maxLiteral {
+			return "", fmt.Errorf("%w: string too long", ErrProtocol)
+		}
+		b.WriteByte(ch)
+	}
+}
+
+// readLiteral reads a {n} or {n+} literal after its opening brace
+func (c *Client) readLiteral() (string, error) {
+	header, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	header = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(header, "\n"), "\r"), "}")
+	n, err := strconv.Atoi(strings.TrimSuffix(header, "+"))
+	if err != nil || n < 0 || n > maxLiteral {
+		return "", fmt.Errorf("%w: invalid literal length %q", ErrProtocol, header)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return string(data), nil
+}
+
+// readCode reads a response code up to its closing parenthesis
+func (c *Client) readCode() (string, error) {
+	var b strings.Builder
+	quoted := false
+	for {
+		ch, err := c.r.ReadByte()
+		if err != nil {
+			return "", unexpectedEOF(err)
+		}
+		switch {
+		case ch == ')' && !quoted:
+			return b.String(), nil
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\r' || ch == '\n':
+			return "", fmt.Errorf("%w: unterminated response code", ErrProtocol)
+		}
+		b.WriteByte(ch)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("%w: connection closed", ErrProtocol)
+	}
+	return err
+}
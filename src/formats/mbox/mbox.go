@@ -0,0 +1,221 @@
+// Package mbox reads and writes mbox mailbox files as produced by
+// Thunderbird, Google Takeout and most Unix mail tools.
+//
+// Two quoting conventions exist for body lines that look like message
+// separators. mboxo escapes only "From " lines as ">From ", which cannot be
+// undone unambiguously; mboxrd escapes every ">*From " line by adding one
+// more '>', which round-trips.
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Format selects the quoting convention
+type Format int
+
+const (
+	// MboxRD quotes ">*From " lines reversibly (the default)
+	MboxRD Format = iota
+	// MboxO quotes only "From " lines
+	MboxO
+)
+
+// ErrNotMbox is returned when a file does not start with a "From " line
+var ErrNotMbox = errors.New("not an mbox file")
+
+// asctime is the date layout of separator lines
+const asctime = "Mon Jan _2 15:04:05 2006"
+
+// Message is one message of a mailbox
+type Message struct {
+	// Envelope is the separator line after "From ", usually the envelope
+	// sender and the delivery date
+	Envelope string
+	// Data is the raw RFC 5322 message with quoting undone
+	Data []byte
+}
+
+// Sender returns the envelope sender of the separator line
+func (m *Message) Sender() string {
+	sender, _, _ := strings.Cut(m.Envelope, " ")
+	return sender
+}
+
+// Reader reads messages one at a time so mailboxes of any size can be
+// processed in constant memory
+type Reader struct {
+	br       *bufio.Reader
+	format   Format
+	next     string // separator line of the next message, already read
+	started  bool
+	done     bool
+	consumed int64
+}
+
+// NewReader creates a reader for the given quoting convention
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{br: bufio.NewReaderSize(r, 64*1024), format: format}
+}
+
+// BytesRead returns how many bytes of the input have been consumed, for
+// progress reporting
+func (r *Reader) BytesRead() int64 {
+	return r.consumed
+}
+
+// Next returns the next message, or io.EOF after the last one
+func (r *Reader) Next() (*Message, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	if !r.started {
+		r.started = true
+		line, err := r.readLine()
+		// Skip blank lines some tools leave at the top of the file
+		for err == nil && strings.TrimRight(line, "\r\n") == "" {
+			line, err = r.readLine()
+		}
+		if err == io.EOF && line == "" {
+			r.done = true
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "From ") {
+			r.done = true
+			return nil, ErrNotMbox
+		}
+		r.next = line
+	}
+
+	msg := &Message{Envelope: strings.TrimRight(strings.TrimPrefix(r.next, "From "), "\r\n")}
+	var data bytes.Buffer
+	previousBlank := false
+	for {
+		line, err := r.readLine()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			r.done = true
+			break
+		}
+
+		// A separator is a "From " line following a blank line
+		if previousBlank && strings.HasPrefix(line, "From ") {
+			r.next = line
+			break
+		}
+		previousBlank = strings.TrimRight(line, "\r\n") == ""
+		data.WriteString(r.unquote(line))
+
+		if err == io.EOF {
+			r.done = true
+			break
+		}
+	}
+
+	msg.Data = trimSeparatorBlank(data.Bytes())
+	return msg, nil
+}
+
+// readLine reads one line including its terminator
+func (r *Reader) readLine() (string, error) {
+	line, err := r.br.ReadString('\n')
+	r.consumed += int64(len(line))
+	return line, err
+}
+
+// unquote undoes the quoting of a body line
+func (r *Reader) unquote(line string) string {
+	if !strings.HasPrefix(line, ">") {
+		return line
+	}
+	if r.format == MboxO {
+		if strings.HasPrefix(line, ">From ") {
+			return line[1:]
+		}
+		return line
+	}
+	if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+		return line[1:]
+	}
+	return line
+}
+
+// trimSeparatorBlank removes the blank line written between messages
+func trimSeparatorBlank(data []byte) []byte {
+	if bytes.HasSuffix(data, []byte("\r\n\r\n")) {
+		return data[:len(data)-2]
+	}
+	if bytes.HasSuffix(data, []byte("\n\n")) {
+		return data[:len(data)-1]
+	}
+	return data
+}
+
+// Writer appends messages to a mailbox
+type Writer struct {
+	w      *bufio.Writer
+	format Format
+}
+
+// NewWriter creates a writer using the given quoting convention
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: bufio.NewWriter(w), format: format}
+}
+
+// WriteMessage appends a message. Line endings are converted to LF as mbox
+// files use them; an empty sender is written as MAILER-DAEMON.
+func (w *Writer) WriteMessage(sender string, date time.Time, data []byte) error {
+	if sender == "" || strings.ContainsAny(sender, " \t\r\n") {
+		sender = "MAILER-DAEMON"
+	}
+	if date.IsZero() {
+		date = time.Unix(0, 0)
+	}
+	if _, err := fmt.Fprintf(w.w, "From %s %s\n", sender, date.UTC().Format(asctime)); err != nil {
+		return err
+	}
+
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+
+		if w.quoted(line) {
+			w.w.WriteByte('>')
+		}
+		w.w.Write(line)
+		if !bytes.HasSuffix(line, []byte("\n")) {
+			w.w.WriteByte('\n')
+		}
+	}
+	_, err := w.w.WriteString("\n")
+	return err
+}
+
+// quoted reports whether a body line must be escaped
+func (w *Writer) quoted(line []byte) bool {
+	if w.format == MboxO {
+		return bytes.HasPrefix(line, []byte("From "))
+	}
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// Flush writes buffered data to the underlying writer
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
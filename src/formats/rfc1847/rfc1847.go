@@ -0,0 +1,296 @@
+// Package rfc1847 reads and writes the security multiparts of RFC 1847,
+// multipart/signed and multipart/encrypted, which OpenPGP/MIME (RFC 3156)
+// and S/MIME (RFC 8551) wrap messages in.
+//
+// The package only handles the MIME structure; signing, verifying and
+// encryption are left to the caller.
+package rfc1847
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"palm/src/formats/rfc5322"
+	"strings"
+)
+
+// base64LineLength is the line length of RFC 2045 section 6.8
+const base64LineLength = 76
+
+// Common errors
+var (
+	ErrNotSigned    = errors.New("entity is not multipart/signed")
+	ErrNotEncrypted = errors.New("entity is not multipart/encrypted")
+	ErrMalformed    = errors.New("malformed security multipart")
+)
+
+// Signed is a parsed multipart/signed entity
+type Signed struct {
+	Protocol  string // Media type of the signature, e.g. "application/pgp-signature"
+	Micalg    string // Hash algorithm of the signature, e.g. "pgp-sha256"
+	Content   []byte // Signed entity exactly as sent, with CRLF line endings
+	Signature []byte // Signature with its transfer encoding undone
+}
+
+// Encrypted is a parsed multipart/encrypted entity
+type Encrypted struct {
+	Protocol string // Media type of the control part, e.g. "application/pgp-encrypted"
+	Control  []byte // Control information with its transfer encoding undone
+	Data     []byte // Encrypted data with its transfer encoding undone
+}
+
+// Part is a part written into a security multipart. Data that is not
+// short-lined ASCII is sent as base64.
+type Part struct {
+	ContentType string // Media type without parameters
+	Filename    string // Optional
+	Data        []byte
+}
+
+// ParseSigned splits a multipart/signed entity, such as a whole message,
+// into its signed content and signature
+func ParseSigned(entity []byte) (*Signed, error) {
+	mediaType, params, body, err := split(entity)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/signed" {
+		return nil, ErrNotSigned
+	}
+
+	parts, err := splitParts(body, params["boundary"])
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: multipart/signed has %d parts", ErrMalformed, len(parts))
+	}
+	signature, err := partData(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &Signed{
+		Protocol:  strings.ToLower(params["protocol"]),
+		Micalg:    strings.ToLower(params["micalg"]),
+		Content:   parts[0],
+		Signature: signature,
+	}, nil
+}
+
+// ParseEncrypted splits a multipart/encrypted entity into its control
+// information and encrypted data
+func ParseEncrypted(entity []byte) (*Encrypted, error) {
+	mediaType, params, body, err := split(entity)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/encrypted" {
+		return nil, ErrNotEncrypted
+	}
+
+	parts, err := splitParts(body, params["boundary"])
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: multipart/encrypted has %d parts", ErrMalformed, len(parts))
+	}
+	control, err := partData(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := partData(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &Encrypted{
+		Protocol: strings.ToLower(params["protocol"]),
+		Control:  control,
+		Data:     data,
+	}, nil
+}
+
+// WriteSigned returns a multipart/signed entity of content, an entity such
+// as written by rfc5322.WriteBody, and its signature. The signature must
+// have been computed over Canonical(content).
+func WriteSigned(content []byte, micalg string, signature Part) []byte {
+	boundary := randomBoundary()
+	var b bytes.Buffer
+	b.WriteString("Content-Type: " + mime.FormatMediaType("multipart/signed", map[string]string{
+		"boundary": boundary,
+		"micalg":   micalg,
+		"protocol": signature.ContentType,
+	}) + "\r\n\r\n")
+	b.WriteString("--" + boundary + "\r\n")
+	b.Write(Canonical(content))
+	b.WriteString("\r\n--" + boundary + "\r\n")
+	writePart(&b, signature)
+	b.WriteString("\r\n--" + boundary + "--\r\n")
+	return b.Bytes()
+}
+
+// WriteEncrypted returns a multipart/encrypted entity of control
+// information and encrypted data
+func WriteEncrypted(control, data Part) []byte {
+	boundary := randomBoundary()
+	var b bytes.Buffer
+	b.WriteString("Content-Type: " + mime.FormatMediaType("multipart/encrypted", map[string]string{
+		"boundary": boundary,
+		"protocol": control.ContentType,
+	}) + "\r\n\r\n")
+	b.WriteString("--" + boundary + "\r\n")
+	writePart(&b, control)
+	b.WriteString("\r\n--" + boundary + "\r\n")
+	writePart(&b, data)
+	b.WriteString("\r\n--" + boundary + "--\r\n")
+	return b.Bytes()
+}
+
+// Canonical converts bare LF line endings to CRLF, the form in which
+// entities are signed
+func Canonical(data []byte) []byte {
+	var b bytes.Buffer
+	b.Grow(len(data))
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			b.WriteByte('\r')
+		}
+		b.WriteByte(c)
+	}
+	return b.Bytes()
+}
+
+// split returns the media type, parameters and body of an entity
+func split(entity []byte) (string, map[string]string, []byte, error) {
+	entity = Canonical(entity)
+	header, err := rfc5322.ReadHeader(bufio.NewReader(bytes.NewReader(entity)))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	var body []byte
+	if i := bytes.Index(entity, []byte("\r\n\r\n")); i >= 0 {
+		body = entity[i+4:]
+	}
+	return mediaType, params, body, nil
+}
+
+// splitParts returns the parts of a multipart body exactly as they appear.
+// The line break before a delimiter belongs to the delimiter.
+func splitParts(body []byte, boundary string) ([][]byte, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("%w: no boundary", ErrMalformed)
+	}
+	delimiter := []byte("\r\n--" + boundary)
+
+	// The preamble may be empty, so the first delimiter may start the body
+	rest := append([]byte("\r\n"), body...)
+	i := bytes.Index(rest, delimiter)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: boundary not found", ErrMalformed)
+	}
+	rest = rest[i+len(delimiter):]
+
+	var parts [][]byte
+	for {
+		if bytes.HasPrefix(rest, []byte("--")) {
+			return parts, nil
+		}
+		// Skip transport padding after the boundary
+		eol := bytes.Index(rest, []byte("\r\n"))
+		if eol < 0 {
+			return nil, fmt.Errorf("%w: unterminated multipart", ErrMalformed)
+		}
+		rest = rest[eol+2:]
+
+		end := bytes.Index(rest, delimiter)
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated multipart", ErrMalformed)
+		}
+		parts = append(parts, rest[:end])
+		rest = rest[end+len(delimiter):]
+	}
+}
+
+// partData returns the content of a part with its transfer encoding undone
+func partData(part []byte) ([]byte, error) {
+	// A part without header fields is plain text
+	if body, ok := bytes.CutPrefix(part, []byte("\r\n")); ok {
+		return body, nil
+	}
+	m, err := rfc5322.Parse(bytes.NewReader(part))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	switch {
+	case len(m.Attachments) > 0:
+		return m.Attachments[0].Data, nil
+	case m.Text != "":
+		return []byte(m.Text), nil
+	default:
+		return []byte(m.HTML), nil
+	}
+}
+
+// writePart serializes a part, without the line break that ends it
+func writePart(b *bytes.Buffer, part Part) {
+	params := map[string]string{}
+	if part.Filename != "" {
+		params["name"] = part.Filename
+	}
+	b.WriteString("Content-Type: " + mime.FormatMediaType(part.ContentType, params) + "\r\n")
+	if part.Filename != "" {
+		b.WriteString("Content-Disposition: " + mime.FormatMediaType("attachment", map[string]string{"filename": part.Filename}) + "\r\n")
+	}
+
+	if isShortLinedASCII(part.Data) {
+		b.WriteString("Content-Transfer-Encoding: 7bit\r\n\r\n")
+		b.Write(bytes.TrimRight(Canonical(part.Data), "\r\n"))
+		return
+	}
+
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(part.Data)
+	for len(encoded) > base64LineLength {
+		b.WriteString(encoded[:base64LineLength])
+		b.WriteString("\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	b.WriteString(encoded)
+}
+
+// isShortLinedASCII reports whether data can be sent as 7bit
+func isShortLinedASCII(data []byte) bool {
+	lineLength := 0
+	for _, c := range data {
+		switch {
+		case c == '\n':
+			lineLength = 0
+			continue
+		case c >= 0x80 || c == 0:
+			return false
+		}
+		lineLength++
+		if lineLength > 998 {
+			return false
+		}
+	}
+	return true
+}
+
+func randomBoundary() string {
+	var b [15]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return "palm-" + hex.EncodeToString(b[:])
+}
@@ -0,0 +1,131 @@
+// Package rfc5322 parses and writes Internet messages (RFC 5322) with their
+// MIME structure (RFC 2045-2047, RFC 2231).
+//
+// Parsing is lenient: real-world archives contain malformed addresses,
+// dates and encodings, so unreadable parts are skipped rather than
+// failing the whole message.
+package rfc5322
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Common errors
+var (
+	ErrMalformed = errors.New("malformed message")
+)
+
+// Field is one header field. Value is unfolded but otherwise raw, so
+// encoded words are left as they are.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Header is the ordered list of a message's header fields
+type Header []Field
+
+// Get returns the first value of the named field, or "" if absent
+func (h Header) Get(name string) string {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Values returns every value of the named field in order
+func (h Header) Values(name string) []string {
+	var values []string
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// Has reports whether the named field is present
+func (h Header) Has(name string) bool {
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Message is a parsed message. The structured fields are decoded from
+// Header; when writing, they take precedence over the fields in Header.
+type Message struct {
+	Header     Header
+	MessageID  string // without angle brackets
+	InReplyTo  string // without angle brackets
+	References []string
+	Subject    string
+	Date       time.Time
+	From       *mail.Address
+	ReplyTo    []*mail.Address
+	To         []*mail.Address
+	Cc         []*mail.Address
+	Bcc        []*mail.Address
+
+	Text        string // text/plain body, decoded to UTF-8
+	HTML        string // text/html body, decoded to UTF-8
+	Attachments []*Attachment
+}
+
+// Attachment is a non-body part of a message
+type Attachment struct {
+	Filename    string
+	ContentType string // media type without parameters, e.g. "image/png"
+	ContentID   string // without angle brackets; set for inline parts
+	Inline      bool
+	Params      map[string]string // Further Content-Type parameters, e.g. method for text/calendar; only written
+	Data        []byte
+}
+
+// structuredFields are generated from Message's fields by Write
+var structuredFields = map[string]bool{
+	"date":                      true,
+	"from":                      true,
+	"reply-to":                  true,
+	"to":                        true,
+	"cc":                        true,
+	"bcc":                       true,
+	"subject":                   true,
+	"message-id":                true,
+	"in-reply-to":               true,
+	"references":                true,
+	"mime-version":              true,
+	"content-type":              true,
+	"content-transfer-encoding": true,
+	"content-disposition":       true,
+	"content-id":                true,
+}
+
+// trimAngle removes the angle brackets around a message or content ID
+func trimAngle(id string) string {
+	id = strings.TrimSpace(id)
+	id = strings.TrimPrefix(id, "<")
+	return strings.TrimSuffix(id, ">")
+}
+
+// ParseIDList splits a References or In-Reply-To value into message IDs,
+// without angle brackets
+func ParseIDList(value string) []string {
+	var ids []string
+	for _, field := range strings.Fields(value) {
+		// Some clients separate IDs with commas
+		for _, id := range strings.Split(field, ",") {
+			if id = trimAngle(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,346 @@
+package rfc5322
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// maxDepth bounds the nesting of multipart bodies
+const maxDepth = 20
+
+// wordDecoder decodes RFC 2047 encoded words in any charset x/text knows
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// Parse reads one message. Both CRLF and bare LF line endings are accepted.
+func Parse(r io.Reader) (*Message, error) {
+	br := bufio.NewReader(r)
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{Header: header}
+	m.decodeFields()
+	if err := m.readPart(header, br, 0); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadHeader reads and unfolds header fields up to the blank line that
+// separates them from the body. Lines that are not fields are skipped.
+func ReadHeader(br *bufio.Reader) (Header, error) {
+	var header Header
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		trimmed := strings.TrimRight(line, "\r\n")
+
+		if trimmed == "" {
+			break
+		}
+
+		if (trimmed[0] == ' ' || trimmed[0] == '\t') && len(header) > 0 {
+			// Folded continuation of the previous field
+			header[len(header)-1].Value += " " + strings.TrimSpace(trimmed)
+		} else if name, value, ok := strings.Cut(trimmed, ":"); ok && validFieldName(name) {
+			header = append(header, Field{Name: name, Value: strings.TrimSpace(value)})
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(header) == 0 {
+		return nil, fmt.Errorf("%w: no header fields", ErrMalformed)
+	}
+	return header, nil
+}
+
+// validFieldName reports whether name consists of printable ASCII without
+// spaces, as RFC 5322 requires
+func validFieldName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeHeader decodes the RFC 2047 encoded words in a header value. Values
+// that cannot be decoded are returned unchanged.
+func DecodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// ParseAddressList parses an address header value. Addresses that cannot be
+// parsed strictly are recovered from their angle brackets or bare form.
+func ParseAddressList(value string) []*mail.Address {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	if list, err := parser.ParseList(value); err == nil {
+		return list
+	}
+
+	var list []*mail.Address
+	for _, part := range strings.Split(value, ",") {
+		if addr, err := parser.Parse(part); err == nil {
+			list = append(list, addr)
+			continue
+		}
+		if addr := looseAddress(part); addr != nil {
+			list = append(list, addr)
+		}
+	}
+	return list
+}
+
+var angleAddress = regexp.MustCompile(`<([^<>\s]+@[^<>\s]+)>`)
+
+// looseAddress extracts an address from a malformed mailbox such as
+// `John "Johnny" Doe <john@example.com>` or `john@example.com (John)`
+func looseAddress(s string) *mail.Address {
+	s = strings.TrimSpace(s)
+	if m := angleAddress.FindStringSubmatchIndex(s); m != nil {
+		name := strings.Trim(strings.TrimSpace(s[:m[0]]), `"' `)
+		return &mail.Address{Name: DecodeHeader(name), Address: s[m[2]:m[3]]}
+	}
+	for _, field := range strings.Fields(s) {
+		if strings.Contains(field, "@") {
+			return &mail.Address{Address: strings.Trim(field, `<>"'(),;`)}
+		}
+	}
+	return nil
+}
+
+// ParseDate parses a Date header, accepting common deviations from RFC 5322
+func ParseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if t, err := mail.ParseDate(value); err == nil {
+		return t, true
+	}
+	// Strip a trailing zone comment such as "(UTC)" and retry
+	if i := strings.LastIndex(value, "("); i > 0 {
+		if t, err := mail.ParseDate(strings.TrimSpace(value[:i])); err == nil {
+			return t, true
+		}
+	}
+	for _, layout := range []string{
+		time.RFC1123Z, time.RFC1123, time.RFC850, time.ANSIC, time.UnixDate,
+		"Mon, 2 Jan 2006 15:04:05 -0700 MST",
+		"2 Jan 2006 15:04:05 MST",
+		"Mon, 2 Jan 06 15:04:05 -0700",
+		time.RFC3339,
+	} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// decodeFields fills the structured fields from the header
+func (m *Message) decodeFields() {
+	h := m.Header
+	m.Subject = DecodeHeader(h.Get("Subject"))
+	if from := ParseAddressList(h.Get("From")); len(from) > 0 {
+		m.From = from[0]
+	}
+	m.ReplyTo = ParseAddressList(strings.Join(h.Values("Reply-To"), ", "))
+	m.To = ParseAddressList(strings.Join(h.Values("To"), ", "))
+	m.Cc = ParseAddressList(strings.Join(h.Values("Cc"), ", "))
+	m.Bcc = ParseAddressList(strings.Join(h.Values("Bcc"), ", "))
+	m.Date, _ = ParseDate(h.Get("Date"))
+
+	if ids := ParseIDList(h.Get("Message-ID")); len(ids) > 0 {
+		m.MessageID = ids[0]
+	}
+	if ids := ParseIDList(h.Get("In-Reply-To")); len(ids) > 0 {
+		m.InReplyTo = ids[0]
+	}
+	m.References = ParseIDList(strings.Join(h.Values("References"), " "))
+}
+
+// headerGetter is satisfied by Header and textproto.MIMEHeader
+type headerGetter interface {
+	Get(key string) string
+}
+
+// readPart walks one MIME entity, collecting bodies and attachments
+func (m *Message) readPart(h headerGetter, body io.Reader, depth int) error {
+	mediaType, params := parseContentType(h.Get("Content-Type"))
+	disposition, dispParams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < maxDepth {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				// Truncated or malformed multipart: keep what was read
+				return nil
+			}
+			if err := m.readPart(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(transferDecoder(body, h.Get("Content-Transfer-Encoding")))
+	if err != nil && len(data) == 0 {
+		return nil
+	}
+
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = DecodeHeader(filename)
+
+	if disposition != "attachment" && filename == "" && (mediaType == "text/plain" || mediaType == "text/html") {
+		text := decodeCharset(data, params["charset"])
+		if mediaType == "text/html" {
+			m.HTML = joinBodies(m.HTML, text)
+		} else {
+			m.Text = joinBodies(m.Text, text)
+		}
+		return nil
+	}
+
+	contentID := trimAngle(h.Get("Content-Id"))
+	m.Attachments = append(m.Attachments, &Attachment{
+		Filename:    filename,
+		ContentType: mediaType,
+		ContentID:   contentID,
+		Inline:      disposition == "inline" || (disposition == "" && contentID != ""),
+		Data:        data,
+	})
+	return nil
+}
+
+// joinBodies appends a further body part of the same type
+func joinBodies(existing, next string) string {
+	if existing == "" {
+		return next
+	}
+	return existing + "\n" + next
+}
+
+// parseContentType returns the lower-cased media type and its parameters,
+// defaulting to text/plain as RFC 2045 requires
+func parseContentType(value string) (string, map[string]string) {
+	if strings.TrimSpace(value) == "" {
+		return "text/plain", map[string]string{}
+	}
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		// Keep the type of values with broken parameters
+		mediaType = strings.ToLower(strings.TrimSpace(strings.SplitN(value, ";", 2)[0]))
+		if !strings.Contains(mediaType, "/") {
+			mediaType = "text/plain"
+		}
+		if params == nil {
+			params = map[string]string{}
+		}
+	}
+	return mediaType, params
+}
+
+// transferDecoder undoes a Content-Transfer-Encoding
+func transferDecoder(r io.Reader, encoding string) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return &lenientBase64{r: r}
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+// lenientBase64 decodes base64 while ignoring line breaks, stray characters
+// and missing padding
+type lenientBase64 struct {
+	r    io.Reader
+	done bool
+	out  *bytes.Reader
+}
+
+func (l *lenientBase64) Read(p []byte) (int, error) {
+	if !l.done {
+		l.done = true
+		raw, err := io.ReadAll(l.r)
+		if err != nil && len(raw) == 0 {
+			return 0, err
+		}
+		clean := make([]byte, 0, len(raw))
+		for _, c := range raw {
+			if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' {
+				clean = append(clean, c)
+			}
+		}
+		if len(clean)%4 == 1 {
+			clean = clean[:len(clean)-1]
+		}
+		decoded := make([]byte, base64.RawStdEncoding.DecodedLen(len(clean)))
+		n, _ := base64.RawStdEncoding.Decode(decoded, clean)
+		l.out = bytes.NewReader(decoded[:n])
+	}
+	return l.out.Read(p)
+}
+
+// decodeCharset converts text in the given charset to UTF-8
+func decodeCharset(data []byte, charset string) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset != "" && charset != "utf-8" && charset != "us-ascii" && charset != "utf8" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := enc.NewDecoder().Bytes(data); err == nil {
+				return string(decoded)
+			}
+		}
+	}
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	return strings.ToValidUTF8(string(data), "�")
+}
+
+// charsetReader lets mime.WordDecoder handle charsets beyond UTF-8 and Latin-1
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
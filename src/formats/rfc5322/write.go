@@ -0,0 +1,295 @@
+package rfc5322
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const (
+	// maxLineLength is the folding target of RFC 5322 section 2.1.1
+	maxLineLength = 78
+	// base64LineLength is the line length of RFC 2045 section 6.8
+	base64LineLength = 76
+	// maxTextLineLength is the longest line sent without quoted-printable
+	maxTextLineLength = 998
+)
+
+// entity is a MIME entity ready to be serialized. Its body is already
+// transfer-encoded.
+type entity struct {
+	header   Header
+	body     []byte
+	boundary string
+	children []*entity
+}
+
+// Write serializes m with CRLF line endings. Structured fields are written
+// from m's fields; other fields of m.Header (Received, List-Id, ...) are
+// kept as they are. Text is sent as quoted-printable when needed and
+// attachments as base64.
+func Write(w io.Writer, m *Message) error {
+	bw := bufio.NewWriter(w)
+	body := m.bodyEntity()
+	body.header = append(m.messageHeader(), body.header...)
+	writeEntity(bw, body, true)
+	return bw.Flush()
+}
+
+// WriteBody serializes the MIME entity of m's body alone: its content
+// fields, a blank line and its content. This is the part that security
+// multiparts (RFC 1847) sign or encrypt.
+func WriteBody(w io.Writer, m *Message) error {
+	bw := bufio.NewWriter(w)
+	writeEntity(bw, m.bodyEntity(), true)
+	return bw.Flush()
+}
+
+// WriteWithBody serializes m's header fields followed by body, an entity
+// already serialized with its own content fields, instead of m's body
+func WriteWithBody(w io.Writer, m *Message, body []byte) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range m.messageHeader() {
+		bw.WriteString(foldField(f.Name, f.Value))
+	}
+	bw.Write(body)
+	if len(body) > 0 && !bytes.HasSuffix(body, []byte("\r\n")) {
+		bw.WriteString("\r\n")
+	}
+	return bw.Flush()
+}
+
+// messageHeader returns the fields Write puts before the body's content
+// fields, ending with MIME-Version
+func (m *Message) messageHeader() Header {
+	var header Header
+	if !m.Date.IsZero() {
+		header = append(header, Field{"Date", m.Date.Format(time.RFC1123Z)})
+	}
+	if m.From != nil {
+		header = append(header, Field{"From", m.From.String()})
+	}
+	for _, f := range []struct {
+		name string
+		list []*mail.Address
+	}{
+		{"Reply-To", m.ReplyTo},
+		{"To", m.To},
+		{"Cc", m.Cc},
+		{"Bcc", m.Bcc},
+	} {
+		if len(f.list) > 0 {
+			header = append(header, Field{f.name, FormatAddressList(f.list)})
+		}
+	}
+	header = append(header, Field{"Subject", EncodeHeader(m.Subject)})
+	if m.MessageID != "" {
+		header = append(header, Field{"Message-ID", "<" + m.MessageID + ">"})
+	}
+	if m.InReplyTo != "" {
+		header = append(header, Field{"In-Reply-To", "<" + m.InReplyTo + ">"})
+	}
+	if len(m.References) > 0 {
+		refs := make([]string, len(m.References))
+		for i, id := range m.References {
+			refs[i] = "<" + id + ">"
+		}
+		header = append(header, Field{"References", strings.Join(refs, " ")})
+	}
+	for _, f := range m.Header {
+		if !structuredFields[strings.ToLower(f.Name)] {
+			header = append(header, f)
+		}
+	}
+	return append(header, Field{"MIME-Version", "1.0"})
+}
+
+// bodyEntity builds the MIME tree of the message body
+func (m *Message) bodyEntity() *entity {
+	var content *entity
+	switch {
+	case m.Text != "" && m.HTML != "":
+		content = multipartEntity("alternative", textEntity("text/plain", m.Text), textEntity("text/html", m.HTML))
+	case m.HTML != "":
+		content = textEntity("text/html", m.HTML)
+	default:
+		content = textEntity("text/plain", m.Text)
+	}
+	if len(m.Attachments) == 0 {
+		return content
+	}
+
+	children := []*entity{content}
+	for _, a := range m.Attachments {
+		children = append(children, attachmentEntity(a))
+	}
+	return multipartEntity("mixed", children...)
+}
+
+// textEntity encodes a UTF-8 body, using quoted-printable only when the
+// text is not short-lined ASCII
+func textEntity(mediaType, text string) *entity {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	header := Header{{"Content-Type", mediaType + "; charset=utf-8"}}
+
+	if isPlainASCII(text) {
+		header = append(header, Field{"Content-Transfer-Encoding", "7bit"})
+		return &entity{header: header, body: []byte(strings.ReplaceAll(text, "\n", "\r\n"))}
+	}
+
+	var buf bytes.Buffer
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(text))
+	qp.Close()
+	header = append(header, Field{"Content-Transfer-Encoding", "quoted-printable"})
+	return &entity{header: header, body: buf.Bytes()}
+}
+
+// isPlainASCII reports whether text can be sent as 7bit
+func isPlainASCII(text string) bool {
+	lineLength := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '\n' {
+			lineLength = 0
+			continue
+		}
+		if c >= 0x80 || c == 0 || c == '\r' {
+			return false
+		}
+		lineLength++
+		if lineLength > maxTextLineLength {
+			return false
+		}
+	}
+	return true
+}
+
+// attachmentEntity encodes an attachment as base64
+func attachmentEntity(a *Attachment) *entity {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if a.Inline {
+		disposition = "inline"
+	}
+
+	typeParams := map[string]string{}
+	for name, value := range a.Params {
+		typeParams[name] = value
+	}
+	dispParams := map[string]string{}
+	if a.Filename != "" {
+		typeParams["name"] = a.Filename
+		dispParams["filename"] = a.Filename
+	}
+	typeValue := mime.FormatMediaType(contentType, typeParams)
+	if typeValue == "" {
+		typeValue = mime.FormatMediaType("application/octet-stream", typeParams)
+	}
+
+	header := Header{
+		{"Content-Type", typeValue},
+		{"Content-Disposition", mime.FormatMediaType(disposition, dispParams)},
+		{"Content-Transfer-Encoding", "base64"},
+	}
+	if a.ContentID != "" {
+		header = append(header, Field{"Content-ID", "<" + a.ContentID + ">"})
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	var body bytes.Buffer
+	for len(encoded) > base64LineLength {
+		body.WriteString(encoded[:base64LineLength])
+		body.WriteString("\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	body.WriteString(encoded)
+	return &entity{header: header, body: body.Bytes()}
+}
+
+// multipartEntity groups children under a fresh boundary
+func multipartEntity(subtype string, children ...*entity) *entity {
+	boundary := randomBoundary()
+	return &entity{
+		header:   Header{{"Content-Type", mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": boundary})}},
+		boundary: boundary,
+		children: children,
+	}
+}
+
+func randomBoundary() string {
+	var b [15]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return "palm-" + hex.EncodeToString(b[:])
+}
+
+// writeEntity serializes an entity; errors surface on Flush. The line
+// break that ends a body part belongs to the following boundary, so
+// multipart children are always followed by one more CRLF.
+func writeEntity(w *bufio.Writer, e *entity, top bool) {
+	for _, f := range e.header {
+		w.WriteString(foldField(f.Name, f.Value))
+	}
+	w.WriteString("\r\n")
+
+	if e.children == nil {
+		w.Write(e.body)
+		if top && len(e.body) > 0 && !bytes.HasSuffix(e.body, []byte("\r\n")) {
+			w.WriteString("\r\n")
+		}
+		return
+	}
+	for _, child := range e.children {
+		w.WriteString("--" + e.boundary + "\r\n")
+		writeEntity(w, child, false)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("--" + e.boundary + "--\r\n")
+}
+
+// foldField renders a header field, folding at spaces so lines stay
+// within maxLineLength where possible
+func foldField(name, value string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteString(":")
+	lineLength := len(name) + 1
+	for i, word := range strings.Split(value, " ") {
+		if i > 0 && lineLength+1+len(word) > maxLineLength && lineLength > 1 && word != "" {
+			b.WriteString("\r\n")
+			lineLength = 0
+		}
+		b.WriteString(" ")
+		b.WriteString(word)
+		lineLength += 1 + len(word)
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// EncodeHeader encodes a header value as RFC 2047 words if it is not ASCII
+func EncodeHeader(value string) string {
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+// FormatAddressList renders addresses for an address header
+func FormatAddressList(list []*mail.Address) string {
+	parts := make([]string, 0, len(list))
+	for _, addr := range list {
+		parts = append(parts, addr.String())
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,211 @@
+package sieve
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagKind tells what value follows a tagged argument
+type tagKind int
+
+const (
+	tagFlag tagKind = iota // No value
+	tagString
+	tagStringList
+	tagNumber
+)
+
+// tagSpec describes a tagged argument a command or test accepts
+type tagSpec struct {
+	kind      tagKind
+	group     string // Tags of a group exclude each other; defaults to the tag
+	extension string // Extension the tag belongs to, if any
+}
+
+// Tagged arguments shared by several tests
+var (
+	comparatorTags = map[string]tagSpec{
+		"comparator": {kind: tagString},
+	}
+	matchTypeTags = map[string]tagSpec{
+		"is":       {group: "match-type"},
+		"contains": {group: "match-type"},
+		"matches":  {group: "match-type"},
+		"value":    {kind: tagString, group: "match-type", extension: "relational"},
+		"count":    {kind: tagString, group: "match-type", extension: "relational"},
+	}
+	addressPartTags = map[string]tagSpec{
+		"all":       {group: "address-part"},
+		"localpart": {group: "address-part"},
+		"domain":    {group: "address-part"},
+	}
+)
+
+// mergeTags combines tag specifications
+func mergeTags(specs ...map[string]tagSpec) map[string]tagSpec {
+	merged := map[string]tagSpec{}
+	for _, spec := range specs {
+		for name, tag := range spec {
+			merged[name] = tag
+		}
+	}
+	return merged
+}
+
+// args are the arguments of a command or test, with tagged arguments
+// separated from positional ones
+type args struct {
+	node       *node
+	tags       map[string]argument // By tag name; the tag itself for tags without value
+	groups     map[string]string   // Tag given for each group
+	positional []argument
+}
+
+// args checks the tagged arguments of a command or test against the tags
+// it accepts, which precede its positional arguments
+func (c *compiler) args(n *node, specs map[string]tagSpec) (*args, error) {
+	a := &args{node: n, tags: map[string]argument{}, groups: map[string]string{}}
+	for i := 0; i < len(n.args); i++ {
+		arg := n.args[i]
+		if arg.kind != argTag {
+			a.positional = append(a.positional, arg)
+			continue
+		}
+		if len(a.positional) > 0 {
+			return nil, syntaxError(arg.line, "tag :%s of %s follows a positional argument", arg.tag, n.name)
+		}
+		spec, ok := specs[arg.tag]
+		if !ok {
+			return nil, syntaxError(arg.line, "unknown tag :%s for %s", arg.tag, n.name)
+		}
+		if spec.extension != "" && !c.extensions[spec.extension] {
+			return nil, syntaxError(arg.line, "tag :%s requires the %q extension", arg.tag, spec.extension)
+		}
+		group := spec.group
+		if group == "" {
+			group = arg.tag
+		}
+		if other, ok := a.groups[group]; ok {
+			return nil, syntaxError(arg.line, "tags :%s and :%s of %s exclude each other", other, arg.tag, n.name)
+		}
+		a.groups[group] = arg.tag
+
+		if spec.kind == tagFlag {
+			a.tags[arg.tag] = arg
+			continue
+		}
+		if i+1 >= len(n.args) {
+			return nil, syntaxError(arg.line, "tag :%s of %s needs a value", arg.tag, n.name)
+		}
+		i++
+		value := n.args[i]
+		switch spec.kind {
+		case tagString:
+			if value.kind != argStrings || value.list || len(value.strings) != 1 {
+				return nil, syntaxError(value.line, "tag :%s of %s takes a string", arg.tag, n.name)
+			}
+		case tagStringList:
+			if value.kind != argStrings {
+				return nil, syntaxError(value.line, "tag :%s of %s takes a string list", arg.tag, n.name)
+			}
+		case tagNumber:
+			if value.kind != argNumber {
+				return nil, syntaxError(value.line, "tag :%s of %s takes a number", arg.tag, n.name)
+			}
+		}
+		a.tags[arg.tag] = value
+	}
+	return a, nil
+}
+
+// has tells whether a tag was given
+func (a *args) has(tag string) bool {
+	_, ok := a.tags[tag]
+	return ok
+}
+
+// tagString returns the string value of a tag, or ""
+func (a *args) tagString(tag string) string {
+	if v, ok := a.tags[tag]; ok && len(v.strings) > 0 {
+		return v.strings[0]
+	}
+	return ""
+}
+
+// count checks the number of positional arguments, which may range from
+// min to max
+func (a *args) count(min, max int) error {
+	n := len(a.positional)
+	if n >= min && n <= max {
+		return nil
+	}
+	want := fmt.Sprint(min)
+	if max != min {
+		want = fmt.Sprintf("%d to %d", min, max)
+	}
+	return syntaxError(a.node.line, "%s takes %s positional arguments, not %d", a.node.name, want, n)
+}
+
+// stringList returns the positional string list at index i
+func (a *args) stringList(i int) ([]string, error) {
+	arg := a.positional[i]
+	if arg.kind != argStrings {
+		return nil, syntaxError(arg.line, "argument %d of %s must be a string list", i+1, a.node.name)
+	}
+	return arg.strings, nil
+}
+
+// string returns the positional string at index i
+func (a *args) string(i int) (string, error) {
+	arg := a.positional[i]
+	if arg.kind != argStrings || arg.list || len(arg.strings) != 1 {
+		return "", syntaxError(arg.line, "argument %d of %s must be a string", i+1, a.node.name)
+	}
+	return arg.strings[0], nil
+}
+
+// number returns the positional number at index i
+func (a *args) number(i int) (int64, error) {
+	arg := a.positional[i]
+	if arg.kind != argNumber {
+		return 0, syntaxError(arg.line, "argument %d of %s must be a number", i+1, a.node.name)
+	}
+	return arg.number, nil
+}
+
+// matcher builds the comparator and match type of a test
+func (c *compiler) matcher(a *args) (*matcher, error) {
+	m := &matcher{comparator: comparatorCasemap, matchType: "is"}
+	if a.has("comparator") {
+		m.comparator = strings.ToLower(a.tagString("comparator"))
+		switch m.comparator {
+		case comparatorOctet, comparatorCasemap:
+		case comparatorNumeric:
+			if !c.extensions["comparator-"+comparatorNumeric] {
+				return nil, syntaxError(a.node.line, "comparator %q must be required", m.comparator)
+			}
+		default:
+			return nil, fmt.Errorf("%w: line %d: comparator %q", ErrUnsupported, a.node.line, m.comparator)
+		}
+	}
+	if matchType, ok := a.groups["match-type"]; ok {
+		m.matchType = matchType
+	}
+	switch m.matchType {
+	case "value", "count":
+		m.relation = strings.ToLower(a.tagString(m.matchType))
+		if !validRelation(m.relation) {
+			return nil, syntaxError(a.node.line, "unknown relation %q", m.relation)
+		}
+	case "contains", "matches":
+		if m.comparator == comparatorNumeric {
+			return nil, syntaxError(a.node.line, "comparator %s does not support :%s", m.comparator, m.matchType)
+		}
+	}
+	return m, nil
+}
+
+// syntaxError reports an invalid command or test on a line
+func syntaxError(line int, format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrSyntax, line, fmt.Sprintf(format, args...))
+}
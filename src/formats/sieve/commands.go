@@ -0,0 +1,295 @@
+package sieve
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on what a script may do to one message
+const (
+	maxRedirects        = 5
+	defaultVacationDays = 7
+	maxVacationDays     = 365
+)
+
+// errStop ends a script through the stop command
+var errStop = errors.New("stop")
+
+// command is a compiled command
+type command interface {
+	run(st *state) error
+}
+
+// runBlock runs commands in order
+func runBlock(st *state, commands []command) error {
+	for _, c := range commands {
+		if err := c.run(st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ifCommand runs the block of the first branch whose test holds
+type ifCommand struct {
+	branches  []ifBranch
+	otherwise []command
+	hasElse   bool
+}
+
+type ifBranch struct {
+	test  test
+	block []command
+}
+
+func (c *ifCommand) run(st *state) error {
+	for _, b := range c.branches {
+		ok, err := b.test.eval(st)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return runBlock(st, b.block)
+		}
+	}
+	return runBlock(st, c.otherwise)
+}
+
+type stopCommand struct{}
+
+func (stopCommand) run(*state) error {
+	return errStop
+}
+
+type keepCommand struct {
+	flags    []string
+	hasFlags bool
+}
+
+func (c *keepCommand) run(st *state) error {
+	st.implicit = false
+	for _, a := range st.actions {
+		if _, ok := a.(Keep); ok {
+			return nil
+		}
+	}
+	flags := st.currentFlags()
+	if c.hasFlags {
+		flags = splitFlags(st.expandAll(c.flags))
+	}
+	st.actions = append(st.actions, Keep{Flags: flags})
+	return nil
+}
+
+type discardCommand struct{}
+
+func (discardCommand) run(st *state) error {
+	st.implicit = false
+	for _, a := range st.actions {
+		if _, ok := a.(Discard); ok {
+			return nil
+		}
+	}
+	st.actions = append(st.actions, Discard{})
+	return nil
+}
+
+type fileintoCommand struct {
+	mailbox  string
+	flags    []string
+	hasFlags bool
+	copy     bool
+	line     int
+}
+
+func (c *fileintoCommand) run(st *state) error {
+	mailbox := st.expandString(c.mailbox)
+	if mailbox == "" {
+		return runtimeError(c.line, "fileinto names no mailbox")
+	}
+	if !c.copy {
+		st.implicit = false
+	}
+	// Filing into the same mailbox twice files one copy
+	for _, a := range st.actions {
+		if f, ok := a.(FileInto); ok && f.Mailbox == mailbox {
+			return nil
+		}
+	}
+	flags := st.currentFlags()
+	if c.hasFlags {
+		flags = splitFlags(st.expandAll(c.flags))
+	}
+	st.actions = append(st.actions, FileInto{Mailbox: mailbox, Flags: flags, Copy: c.copy})
+	return nil
+}
+
+type redirectCommand struct {
+	address string
+	copy    bool
+	line    int
+}
+
+func (c *redirectCommand) run(st *state) error {
+	addr, err := mail.ParseAddress(st.expandString(c.address))
+	if err != nil {
+		return runtimeError(c.line, "cannot redirect to %q", st.expandString(c.address))
+	}
+	if !c.copy {
+		st.implicit = false
+	}
+	for _, a := range st.actions {
+		if r, ok := a.(Redirect); ok && strings.EqualFold(r.Address, addr.Address) {
+			return nil
+		}
+	}
+	if st.redirects >= maxRedirects {
+		return runtimeError(c.line, "too many redirects")
+	}
+	st.redirects++
+	st.actions = append(st.actions, Redirect{Address: addr.Address, Copy: c.copy})
+	return nil
+}
+
+// flagCommand sets, adds or removes IMAP flags in the internal variable
+// or a named one
+type flagCommand struct {
+	operation string
+	variable  string
+	flags     []string
+}
+
+func (c *flagCommand) run(st *state) error {
+	flags := splitFlags(st.expandAll(c.flags))
+	current := splitFlags([]string{st.variables[c.variable]})
+	switch c.operation {
+	case "setflag":
+		current = flags
+	case "addflag":
+		current = splitFlags(append(current, flags...))
+	case "removeflag":
+		kept := current[:0]
+		for _, flag := range current {
+			if !containsFold(flags, flag) {
+				kept = append(kept, flag)
+			}
+		}
+		current = kept
+	}
+	st.setVariable(c.variable, strings.Join(current, " "))
+	return nil
+}
+
+// splitFlags splits space separated flags, dropping duplicates
+func splitFlags(values []string) []string {
+	var flags []string
+	for _, value := range values {
+		for _, flag := range strings.Fields(value) {
+			if !containsFold(flags, flag) {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	return flags
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type setCommand struct {
+	name      string
+	value     string
+	modifiers []string
+}
+
+func (c *setCommand) run(st *state) error {
+	value := st.expandString(c.value)
+	for _, modifier := range c.modifiers {
+		switch modifier {
+		case "lower":
+			value = strings.ToLower(value)
+		case "upper":
+			value = strings.ToUpper(value)
+		case "lowerfirst", "upperfirst":
+			if r, size := utf8.DecodeRuneInString(value); size > 0 {
+				if modifier == "lowerfirst" {
+					r = unicode.ToLower(r)
+				} else {
+					r = unicode.ToUpper(r)
+				}
+				value = string(r) + value[size:]
+			}
+		case "quotewildcard":
+			value = quoteWildcards(value)
+		case "length":
+			value = strconv.Itoa(utf8.RuneCountInString(value))
+		}
+	}
+	st.setVariable(c.name, value)
+	return nil
+}
+
+// quoteWildcards escapes the characters :matches gives a meaning to
+func quoteWildcards(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r == '*' || r == '?' || r == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type vacationCommand struct {
+	reason    string
+	subject   string
+	from      string
+	addresses []string
+	days      int
+	mime      bool
+	handle    string
+	line      int
+}
+
+func (c *vacationCommand) run(st *state) error {
+	if st.vacation {
+		return runtimeError(c.line, "vacation is used twice")
+	}
+	st.vacation = true
+
+	v := Vacation{
+		Reason:    st.expandString(c.reason),
+		Subject:   st.expandString(c.subject),
+		From:      st.expandString(c.from),
+		Addresses: st.expandAll(c.addresses),
+		Days:      c.days,
+		MIME:      c.mime,
+		Handle:    st.expandString(c.handle),
+	}
+	if v.From != "" {
+		if _, err := mail.ParseAddress(v.From); err != nil {
+			return runtimeError(c.line, "invalid vacation :from address %q", v.From)
+		}
+	}
+	if v.Handle == "" {
+		// Responses differing in any of their parts are distinct
+		// (RFC 5230 section 4.2)
+		sum := sha256.Sum256([]byte(strings.Join([]string{v.Subject, v.From, v.Reason, strconv.FormatBool(v.MIME)}, "\x00")))
+		v.Handle = hex.EncodeToString(sum[:8])
+	}
+	st.actions = append(st.actions, v)
+	return nil
+}
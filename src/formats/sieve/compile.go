@@ -0,0 +1,357 @@
+package sieve
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// supported holds the extensions a script may require
+var supported = func() map[string]bool {
+	m := map[string]bool{}
+	for _, ext := range Extensions {
+		m[ext] = true
+	}
+	return m
+}()
+
+// compiler turns the syntax tree of a script into commands, checking
+// their arguments and the extensions they need
+type compiler struct {
+	extensions map[string]bool
+}
+
+// block compiles a list of commands. Require is only allowed at the start
+// of the script.
+func (c *compiler) block(nodes []*node, top bool) ([]command, error) {
+	var commands []command
+	requires := top
+	for _, n := range nodes {
+		if n.name == "require" {
+			if !requires {
+				return nil, syntaxError(n.line, "require must come before other commands")
+			}
+			if err := c.require(n); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		requires = false
+
+		switch n.name {
+		case "elsif", "else":
+			var last *ifCommand
+			if len(commands) > 0 {
+				last, _ = commands[len(commands)-1].(*ifCommand)
+			}
+			if last == nil || last.hasElse {
+				return nil, syntaxError(n.line, "%s without a preceding if", n.name)
+			}
+			if err := c.branch(last, n); err != nil {
+				return nil, err
+			}
+			continue
+		case "if":
+			command := &ifCommand{}
+			if err := c.branch(command, n); err != nil {
+				return nil, err
+			}
+			commands = append(commands, command)
+			continue
+		}
+
+		if n.hasBlock {
+			return nil, syntaxError(n.line, "%s does not take a block", n.name)
+		}
+		if len(n.tests) > 0 {
+			return nil, syntaxError(n.line, "%s does not take tests", n.name)
+		}
+		command, err := c.command(n)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
+
+// require enables the extensions a script lists
+func (c *compiler) require(n *node) error {
+	a, err := c.args(n, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.count(1, 1); err != nil {
+		return err
+	}
+	names, err := a.stringList(0)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !supported[name] {
+			return fmt.Errorf("%w: line %d: %q", ErrUnsupported, n.line, name)
+		}
+		c.extensions[name] = true
+	}
+	return nil
+}
+
+// branch adds an if, elsif or else to a conditional
+func (c *compiler) branch(command *ifCommand, n *node) error {
+	if !n.hasBlock {
+		return syntaxError(n.line, "%s needs a block", n.name)
+	}
+	if len(n.args) > 0 {
+		return syntaxError(n.line, "%s takes no arguments", n.name)
+	}
+	block, err := c.block(n.block, false)
+	if err != nil {
+		return err
+	}
+
+	if n.name == "else" {
+		if len(n.tests) > 0 {
+			return syntaxError(n.line, "else takes no test")
+		}
+		command.otherwise, command.hasElse = block, true
+		return nil
+	}
+	if len(n.tests) != 1 {
+		return syntaxError(n.line, "%s takes one test", n.name)
+	}
+	t, err := c.test(n.tests[0])
+	if err != nil {
+		return err
+	}
+	command.branches = append(command.branches, ifBranch{test: t, block: block})
+	return nil
+}
+
+// need checks that a command or test's extension is required
+func (c *compiler) need(n *node, extension string) error {
+	if !c.extensions[extension] {
+		return syntaxError(n.line, "%s requires the %q extension", n.name, extension)
+	}
+	return nil
+}
+
+// command compiles an action or control command other than if
+func (c *compiler) command(n *node) (command, error) {
+	switch n.name {
+	case "stop":
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		return stopCommand{}, a.count(0, 0)
+
+	case "keep", "discard":
+		var specs map[string]tagSpec
+		if n.name == "keep" {
+			specs = map[string]tagSpec{"flags": {kind: tagStringList, extension: "imap4flags"}}
+		}
+		a, err := c.args(n, specs)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(0, 0); err != nil {
+			return nil, err
+		}
+		if n.name == "discard" {
+			return discardCommand{}, nil
+		}
+		command := &keepCommand{}
+		if a.has("flags") {
+			command.flags, command.hasFlags = a.tags["flags"].strings, true
+		}
+		return command, nil
+
+	case "fileinto":
+		if err := c.need(n, "fileinto"); err != nil {
+			return nil, err
+		}
+		a, err := c.args(n, map[string]tagSpec{
+			"flags": {kind: tagStringList, extension: "imap4flags"},
+			"copy":  {extension: "copy"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(1, 1); err != nil {
+			return nil, err
+		}
+		command := &fileintoCommand{copy: a.has("copy"), line: n.line}
+		if command.mailbox, err = a.string(0); err != nil {
+			return nil, err
+		}
+		if a.has("flags") {
+			command.flags, command.hasFlags = a.tags["flags"].strings, true
+		}
+		return command, nil
+
+	case "redirect":
+		a, err := c.args(n, map[string]tagSpec{"copy": {extension: "copy"}})
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(1, 1); err != nil {
+			return nil, err
+		}
+		command := &redirectCommand{copy: a.has("copy"), line: n.line}
+		if command.address, err = a.string(0); err != nil {
+			return nil, err
+		}
+		if !c.hasVariables(command.address) {
+			if _, err := mail.ParseAddress(command.address); err != nil {
+				return nil, syntaxError(n.line, "cannot redirect to %q", command.address)
+			}
+		}
+		return command, nil
+
+	case "setflag", "addflag", "removeflag":
+		if err := c.need(n, "imap4flags"); err != nil {
+			return nil, err
+		}
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(1, 2); err != nil {
+			return nil, err
+		}
+		command := &flagCommand{operation: n.name}
+		if len(a.positional) == 2 {
+			if err := c.need(n, "variables"); err != nil {
+				return nil, err
+			}
+			name, err := a.string(0)
+			if err != nil {
+				return nil, err
+			}
+			if command.variable, err = c.variableName(n, name); err != nil {
+				return nil, err
+			}
+		}
+		if command.flags, err = a.stringList(len(a.positional) - 1); err != nil {
+			return nil, err
+		}
+		return command, nil
+
+	case "set":
+		return c.set(n)
+
+	case "vacation":
+		return c.vacation(n)
+	}
+	return nil, syntaxError(n.line, "unknown command %s", n.name)
+}
+
+// set compiles the variables extension's set command
+func (c *compiler) set(n *node) (command, error) {
+	if err := c.need(n, "variables"); err != nil {
+		return nil, err
+	}
+	a, err := c.args(n, map[string]tagSpec{
+		"lower":         {group: "case"},
+		"upper":         {group: "case"},
+		"lowerfirst":    {group: "first"},
+		"upperfirst":    {group: "first"},
+		"quotewildcard": {},
+		"length":        {},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.count(2, 2); err != nil {
+		return nil, err
+	}
+	name, err := a.string(0)
+	if err != nil {
+		return nil, err
+	}
+	command := &setCommand{}
+	if command.name, err = c.variableName(n, name); err != nil {
+		return nil, err
+	}
+	if command.value, err = a.string(1); err != nil {
+		return nil, err
+	}
+	// Modifiers apply from the highest precedence down (RFC 5229 section 4)
+	for _, modifier := range []string{a.groups["case"], a.groups["first"], a.groups["quotewildcard"], a.groups["length"]} {
+		if modifier != "" {
+			command.modifiers = append(command.modifiers, modifier)
+		}
+	}
+	return command, nil
+}
+
+// vacation compiles the vacation command
+func (c *compiler) vacation(n *node) (command, error) {
+	if err := c.need(n, "vacation"); err != nil {
+		return nil, err
+	}
+	a, err := c.args(n, map[string]tagSpec{
+		"days":      {kind: tagNumber},
+		"subject":   {kind: tagString},
+		"from":      {kind: tagString},
+		"addresses": {kind: tagStringList},
+		"mime":      {},
+		"handle":    {kind: tagString},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.count(1, 1); err != nil {
+		return nil, err
+	}
+	command := &vacationCommand{
+		days:      defaultVacationDays,
+		subject:   a.tagString("subject"),
+		from:      a.tagString("from"),
+		addresses: a.tags["addresses"].strings,
+		mime:      a.has("mime"),
+		handle:    a.tagString("handle"),
+		line:      n.line,
+	}
+	if a.has("days") {
+		command.days = int(min(max(a.tags["days"].number, 1), maxVacationDays))
+	}
+	if command.reason, err = a.string(0); err != nil {
+		return nil, err
+	}
+	if command.from != "" && !c.hasVariables(command.from) {
+		if _, err := mail.ParseAddress(command.from); err != nil {
+			return nil, syntaxError(n.line, "invalid vacation :from address %q", command.from)
+		}
+	}
+	return command, nil
+}
+
+// variableName validates the name of a variable a command sets
+func (c *compiler) variableName(n *node, name string) (string, error) {
+	if !isIdentifier(name) {
+		return "", syntaxError(n.line, "invalid variable name %q", name)
+	}
+	return strings.ToLower(name), nil
+}
+
+// hasVariables tells whether a string may change when its variables are
+// expanded
+func (c *compiler) hasVariables(s string) bool {
+	return c.extensions["variables"] && strings.Contains(s, "${")
+}
+
+// isIdentifier tells whether a string is a Sieve identifier
+func isIdentifier(s string) bool {
+	if s == "" || !isIdentifierStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentifierStart(s[i]) && !(s[i] >= '0' && s[i] <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package sieve
+
+import (
+	"fmt"
+	"palm/src/formats/rfc5322"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateParts extract the date-parts (RFC 5260 section 4.2) of a time
+var dateParts = map[string]func(t time.Time) string{
+	"year":  func(t time.Time) string { return fmt.Sprintf("%04d", t.Year()) },
+	"month": func(t time.Time) string { return fmt.Sprintf("%02d", int(t.Month())) },
+	"day":   func(t time.Time) string { return fmt.Sprintf("%02d", t.Day()) },
+	"date":  func(t time.Time) string { return t.Format("2006-01-02") },
+	"julian": func(t time.Time) string {
+		// Modified Julian Day: days since 1858-11-17
+		date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		epoch := time.Date(1858, time.November, 17, 0, 0, 0, 0, time.UTC)
+		return strconv.Itoa(int(date.Sub(epoch).Hours() / 24))
+	},
+	"hour":    func(t time.Time) string { return fmt.Sprintf("%02d", t.Hour()) },
+	"minute":  func(t time.Time) string { return fmt.Sprintf("%02d", t.Minute()) },
+	"second":  func(t time.Time) string { return fmt.Sprintf("%02d", t.Second()) },
+	"time":    func(t time.Time) string { return t.Format("15:04:05") },
+	"iso8601": func(t time.Time) string { return t.Format("2006-01-02T15:04:05-07:00") },
+	"std11":   func(t time.Time) string { return t.Format("Mon, 02 Jan 2006 15:04:05 -0700") },
+	"zone":    func(t time.Time) string { return t.Format("-0700") },
+	"weekday": func(t time.Time) string { return strconv.Itoa(int(t.Weekday())) },
+}
+
+// dateTest is the date test, or the currentdate test when header is empty
+type dateTest struct {
+	matcher  *matcher
+	current  bool
+	zone     string // Zone to convert to, as +hhmm; the local zone if empty
+	original bool   // Keep the zone of the date header
+	header   string
+	part     string
+	keys     []string
+	line     int
+}
+
+// dateTest compiles the date and currentdate tests
+func (c *compiler) dateTest(n *node) (test, error) {
+	if err := c.need(n, "date"); err != nil {
+		return nil, err
+	}
+	specs := mergeTags(comparatorTags, matchTypeTags, map[string]tagSpec{
+		"zone": {kind: tagString, group: "zone"},
+	})
+	t := &dateTest{current: n.name == "currentdate", line: n.line}
+	if !t.current {
+		specs["originalzone"] = tagSpec{group: "zone"}
+	}
+	a, err := c.args(n, specs)
+	if err != nil {
+		return nil, err
+	}
+	if t.matcher, err = c.matcher(a); err != nil {
+		return nil, err
+	}
+	t.original = a.has("originalzone")
+	if t.zone = a.tagString("zone"); a.has("zone") && !c.hasVariables(t.zone) {
+		if _, ok := parseZone(t.zone); !ok {
+			return nil, syntaxError(n.line, "invalid zone %q", t.zone)
+		}
+	}
+
+	positional := 3
+	if t.current {
+		positional = 2
+	}
+	if err := a.count(positional, positional); err != nil {
+		return nil, err
+	}
+	i := 0
+	if !t.current {
+		if t.header, err = a.string(0); err != nil {
+			return nil, err
+		}
+		i++
+	}
+	if t.part, err = a.string(i); err != nil {
+		return nil, err
+	}
+	if !c.hasVariables(t.part) {
+		if _, ok := dateParts[strings.ToLower(t.part)]; !ok {
+			return nil, syntaxError(n.line, "unknown date part %q", t.part)
+		}
+	}
+	t.keys, err = a.stringList(i + 1)
+	return t, err
+}
+
+func (t *dateTest) eval(st *state) (bool, error) {
+	part, ok := dateParts[strings.ToLower(st.expandString(t.part))]
+	if !ok {
+		return false, runtimeError(t.line, "unknown date part %q", st.expandString(t.part))
+	}
+
+	var date time.Time
+	if t.current {
+		date = st.opts.Now
+	} else {
+		header := st.expandString(t.header)
+		value := st.message.Header.Get(header)
+		if strings.EqualFold(header, "received") {
+			// The date of a Received field follows its last semicolon
+			if i := strings.LastIndexByte(value, ';'); i >= 0 {
+				value = value[i+1:]
+			}
+		}
+		if date, ok = rfc5322.ParseDate(value); !ok {
+			return false, nil
+		}
+	}
+
+	switch {
+	case t.zone != "":
+		zone, ok := parseZone(st.expandString(t.zone))
+		if !ok {
+			return false, runtimeError(t.line, "invalid zone %q", st.expandString(t.zone))
+		}
+		date = date.In(zone)
+	case !t.original:
+		date = date.In(st.opts.Location)
+	}
+	return t.matcher.match(st, []string{part(date)}, st.expandAll(t.keys)), nil
+}
+
+// parseZone parses a +hhmm or -hhmm zone offset
+func parseZone(s string) (*time.Location, bool) {
+	if len(s) != 5 || (s[0] != '+' && s[0] != '-') {
+		return nil, false
+	}
+	hours, err1 := strconv.Atoi(s[1:3])
+	minutes, err2 := strconv.Atoi(s[3:5])
+	if err1 != nil || err2 != nil || hours > 23 || minutes > 59 {
+		return nil, false
+	}
+	offset := hours*3600 + minutes*60
+	if s[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone(s, offset), true
+}
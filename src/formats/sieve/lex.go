@@ -0,0 +1,260 @@
+package sieve
+
+import (
+	"strconv"
+	"strings"
+)
+
+// tokenKind is the lexical class of a token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdentifier
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenPunct // One of [ ] ( ) , ; { }
+)
+
+// token is a lexical token with the line it starts on
+type token struct {
+	kind   tokenKind
+	text   string // Identifier or tag name, string value or punctuation
+	number int64
+	line   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of script"
+	case tokenTag:
+		return ":" + t.text
+	case tokenNumber:
+		return strconv.FormatInt(t.number, 10)
+	}
+	return strconv.Quote(t.text)
+}
+
+// lexer splits a script into tokens (RFC 5228 section 8.1)
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+// lex returns every token of a script, ending with tokenEOF
+func lex(src string) ([]token, error) {
+	l := &lexer{src: src, line: 1}
+	var tokens []token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.kind == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return syntaxError(l.line, format, args...)
+}
+
+// next reads the token after any white space and comments
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokenEOF, line: l.line}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("[](),;{}", c) >= 0:
+		l.pos++
+		return token{kind: tokenPunct, text: string(c), line: l.line}, nil
+	case c == '"':
+		return l.quoted()
+	case c == ':':
+		l.pos++
+		name := l.identifier()
+		if name == "" {
+			return token{}, l.errorf("expected a tag name after ':'")
+		}
+		return token{kind: tokenTag, text: strings.ToLower(name), line: l.line}, nil
+	case c >= '0' && c <= '9':
+		return l.number()
+	case isIdentifierStart(c):
+		name := l.identifier()
+		if strings.EqualFold(name, "text") && l.pos < len(l.src) && l.src[l.pos] == ':' {
+			l.pos++
+			return l.multiline()
+		}
+		return token{kind: tokenIdentifier, text: strings.ToLower(name), line: l.line}, nil
+	}
+	return token{}, l.errorf("unexpected character %q", c)
+}
+
+// skipSpace skips white space, hash comments and bracket comments
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			comment := l.src[l.pos : l.pos+2+end+2]
+			l.line += strings.Count(comment, "\n")
+			l.pos += len(comment)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (l *lexer) identifier() string {
+	start := l.pos
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if !isIdentifierStart(c) && !(c >= '0' && c <= '9' && l.pos > start) {
+			break
+		}
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+// number reads a number with an optional K, M or G quantifier
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+		l.pos++
+	}
+	n, err := strconv.ParseInt(l.src[start:l.pos], 10, 64)
+	if err != nil {
+		return token{}, l.errorf("number %s is too large", l.src[start:l.pos])
+	}
+	if l.pos < len(l.src) {
+		shift := 0
+		switch l.src[l.pos] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		}
+		if shift > 0 {
+			l.pos++
+			if n > 1<<(62-shift) {
+				return token{}, l.errorf("number %s is too large", l.src[start:l.pos])
+			}
+			n <<= shift
+		}
+	}
+	return token{kind: tokenNumber, number: n, line: l.line}, nil
+}
+
+// quoted reads a quoted string. Backslash escapes the next character.
+func (l *lexer) quoted() (token, error) {
+	line := l.line
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return token{kind: tokenString, text: normalizeNewlines(b.String()), line: line}, nil
+		case '\\':
+			if l.pos+1 < len(l.src) {
+				l.pos++
+				c = l.src[l.pos]
+			}
+		case '\n':
+			l.line++
+		}
+		b.WriteByte(c)
+		l.pos++
+	}
+	l.line = line
+	return token{}, l.errorf("unterminated string")
+}
+
+// multiline reads the lines of a text: string up to the line holding a
+// single dot, removing the first dot of lines starting with two
+func (l *lexer) multiline() (token, error) {
+	line := l.line
+	// Only white space and a hash comment may follow "text:"
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '#' {
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos >= len(l.src) || l.src[l.pos] != '\n' {
+		return token{}, l.errorf("expected a line break after text:")
+	}
+	l.pos++
+	l.line++
+
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		end := strings.IndexByte(l.src[l.pos:], '\n')
+		var text string
+		if end < 0 {
+			text = l.src[l.pos:]
+			l.pos = len(l.src)
+		} else {
+			text = l.src[l.pos : l.pos+end]
+			l.pos += end + 1
+			l.line++
+		}
+		text = strings.TrimSuffix(text, "\r")
+		if text == "." {
+			return token{kind: tokenString, text: b.String(), line: line}, nil
+		}
+		if strings.HasPrefix(text, "..") {
+			text = text[1:]
+		}
+		b.WriteString(text)
+		b.WriteString("\r\n")
+	}
+	l.line = line
+	return token{}, l.errorf("unterminated text: string")
+}
+
+// normalizeNewlines makes every line break in a string CRLF, as the
+// values of quoted strings spanning lines are defined to have
+func normalizeNewlines(s string) string {
+	if !strings.Contains(s, "\n") {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
@@ -0,0 +1,189 @@
+package sieve
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Comparators (RFC 4790)
+const (
+	comparatorOctet   = "i;octet"
+	comparatorCasemap = "i;ascii-casemap"
+	comparatorNumeric = "i;ascii-numeric"
+)
+
+// validRelation tells whether a relational match names a relation
+// (RFC 5231 section 4)
+func validRelation(relation string) bool {
+	switch relation {
+	case "gt", "ge", "lt", "le", "eq", "ne":
+		return true
+	}
+	return false
+}
+
+// matcher compares the values a test extracts with its keys
+type matcher struct {
+	comparator string
+	matchType  string // is, contains, matches, value or count
+	relation   string // For value and count
+}
+
+// match tells whether any value matches any key. A successful :matches
+// sets the match variables to the text its wildcards matched. Keys have
+// their variables expanded already.
+func (m *matcher) match(st *state, values, keys []string) bool {
+	if m.matchType == "count" {
+		count := strconv.Itoa(len(values))
+		for _, key := range keys {
+			if relate(m.relation, compareNumeric(count, key)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, value := range values {
+		for _, key := range keys {
+			switch m.matchType {
+			case "is":
+				if m.compare(value, key) == 0 {
+					return true
+				}
+			case "contains":
+				if m.fold(value, key, strings.Contains) {
+					return true
+				}
+			case "matches":
+				if groups := m.wildcard(key).FindStringSubmatch(value); groups != nil {
+					if st.expand {
+						st.matchValues = groups
+					}
+					return true
+				}
+			case "value":
+				if relate(m.relation, m.compare(value, key)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// compare orders two strings under the comparator
+func (m *matcher) compare(a, b string) int {
+	switch m.comparator {
+	case comparatorNumeric:
+		return compareNumeric(a, b)
+	case comparatorCasemap:
+		a, b = asciiLower(a), asciiLower(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// fold applies a substring function, ignoring ASCII case unless the
+// comparator is i;octet
+func (m *matcher) fold(a, b string, f func(string, string) bool) bool {
+	if m.comparator == comparatorCasemap {
+		a, b = asciiLower(a), asciiLower(b)
+	}
+	return f(a, b)
+}
+
+// wildcard compiles a :matches key, in which * matches any text, ?
+// matches one character and backslash quotes the next character. Each
+// wildcard captures what it matches, * as little as possible.
+func (m *matcher) wildcard(key string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?s)")
+	if m.comparator == comparatorCasemap {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	runes := []rune(key)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			b.WriteString("(.*?)")
+		case '?':
+			b.WriteString("(.)")
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// relate tells whether the result of a comparison satisfies a relation
+func relate(relation string, cmp int) bool {
+	switch relation {
+	case "gt":
+		return cmp > 0
+	case "ge":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	}
+	return false
+}
+
+// compareNumeric orders strings by the number their leading digits form
+// (i;ascii-numeric). Strings without leading digits come after every
+// number and equal each other.
+func compareNumeric(a, b string) int {
+	da, db := leadingDigits(a), leadingDigits(b)
+	switch {
+	case da == "" && db == "":
+		return 0
+	case da == "":
+		return 1
+	case db == "":
+		return -1
+	}
+	da, db = strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+	if len(da) != len(db) {
+		if len(da) < len(db) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(da, db)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// asciiLower lowers the case of ASCII letters only, as i;ascii-casemap does
+func asciiLower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 'A' && c <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				if b[j] >= 'A' && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
+}
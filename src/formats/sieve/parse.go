@@ -0,0 +1,242 @@
+package sieve
+
+// maxNesting bounds the nesting of blocks and tests
+const maxNesting = 64
+
+// argKind is the kind of an argument
+type argKind int
+
+const (
+	argTag argKind = iota
+	argNumber
+	argStrings
+)
+
+// argument is a tagged argument, a number, a string or a string list
+type argument struct {
+	kind    argKind
+	tag     string
+	number  int64
+	strings []string
+	list    bool // Written as a bracketed list
+	line    int
+}
+
+// node is a command or a test with its arguments, nested tests and, for
+// commands, block
+type node struct {
+	name     string
+	args     []argument
+	tests    []*node
+	block    []*node
+	hasBlock bool
+	line     int
+}
+
+// parser builds the syntax tree of a script (RFC 5228 section 8.2)
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// parse returns the commands of a script
+func parse(src string) ([]*node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	commands, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return commands, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isPunct(c string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.text == c
+}
+
+func (p *parser) expect(c string) error {
+	if !p.isPunct(c) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, found %s", c, t)
+	}
+	p.advance()
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return syntaxError(t.line, format, args...)
+}
+
+func (p *parser) enter(t token) error {
+	p.depth++
+	if p.depth > maxNesting {
+		return p.errorf(t, "too deeply nested")
+	}
+	return nil
+}
+
+// commands reads commands up to the end of the script or block
+func (p *parser) commands() ([]*node, error) {
+	var commands []*node
+	for {
+		t := p.peek()
+		if t.kind == tokenEOF || (t.kind == tokenPunct && t.text == "}") {
+			return commands, nil
+		}
+		command, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, command)
+	}
+}
+
+// command reads an identifier, its arguments and tests and either a
+// semicolon or a block
+func (p *parser) command() (*node, error) {
+	t := p.advance()
+	if t.kind != tokenIdentifier {
+		return nil, p.errorf(t, "expected a command, found %s", t)
+	}
+	command := &node{name: t.text, line: t.line}
+	if err := p.arguments(command); err != nil {
+		return nil, err
+	}
+
+	if p.isPunct(";") {
+		p.advance()
+		return command, nil
+	}
+	if !p.isPunct("{") {
+		next := p.peek()
+		return nil, p.errorf(next, "expected ';' or '{' after %s, found %s", command.name, next)
+	}
+	open := p.advance()
+	if err := p.enter(open); err != nil {
+		return nil, err
+	}
+	block, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	p.depth--
+	command.block, command.hasBlock = block, true
+	return command, nil
+}
+
+// test reads an identifier with its arguments and nested tests
+func (p *parser) test() (*node, error) {
+	t := p.advance()
+	if t.kind != tokenIdentifier {
+		return nil, p.errorf(t, "expected a test, found %s", t)
+	}
+	if err := p.enter(t); err != nil {
+		return nil, err
+	}
+	test := &node{name: t.text, line: t.line}
+	if err := p.arguments(test); err != nil {
+		return nil, err
+	}
+	p.depth--
+	return test, nil
+}
+
+// arguments reads the arguments of a command or test, followed by a
+// single test or a parenthesized test list
+func (p *parser) arguments(n *node) error {
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokenTag:
+			p.advance()
+			n.args = append(n.args, argument{kind: argTag, tag: t.text, line: t.line})
+		case t.kind == tokenNumber:
+			p.advance()
+			n.args = append(n.args, argument{kind: argNumber, number: t.number, line: t.line})
+		case t.kind == tokenString:
+			p.advance()
+			n.args = append(n.args, argument{kind: argStrings, strings: []string{t.text}, line: t.line})
+		case t.kind == tokenPunct && t.text == "[":
+			list, err := p.stringList()
+			if err != nil {
+				return err
+			}
+			n.args = append(n.args, list)
+		default:
+			return p.tests(n)
+		}
+	}
+}
+
+// stringList reads a bracketed list of strings
+func (p *parser) stringList() (argument, error) {
+	open := p.advance()
+	list := argument{kind: argStrings, list: true, line: open.line}
+	for {
+		t := p.advance()
+		if t.kind != tokenString {
+			return argument{}, p.errorf(t, "expected a string in the list, found %s", t)
+		}
+		list.strings = append(list.strings, t.text)
+		if p.isPunct("]") {
+			p.advance()
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return argument{}, err
+		}
+	}
+}
+
+// tests reads the test or test list that may end arguments
+func (p *parser) tests(n *node) error {
+	t := p.peek()
+	switch {
+	case t.kind == tokenIdentifier:
+		test, err := p.test()
+		if err != nil {
+			return err
+		}
+		n.tests = []*node{test}
+	case t.kind == tokenPunct && t.text == "(":
+		p.advance()
+		for {
+			test, err := p.test()
+			if err != nil {
+				return err
+			}
+			n.tests = append(n.tests, test)
+			if p.isPunct(")") {
+				p.advance()
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
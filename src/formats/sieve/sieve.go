@@ -0,0 +1,173 @@
+// Package sieve interprets Sieve mail filtering scripts (RFC 5228).
+//
+// Besides the base language it implements the fileinto, copy (RFC 3894),
+// imap4flags (RFC 5232), variables (RFC 5229), body (RFC 5173), date
+// (RFC 5260), relational (RFC 5231) and vacation (RFC 5230) extensions,
+// and the i;octet, i;ascii-casemap and i;ascii-numeric comparators.
+// Envelope tests are not supported: a stored message has no envelope.
+//
+// Running a script only decides what to do with a message; the caller
+// takes the resulting actions.
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"palm/src/formats/rfc5322"
+	"time"
+)
+
+// Common errors
+var (
+	ErrSyntax      = errors.New("sieve syntax error")
+	ErrUnsupported = errors.New("unsupported sieve extension")
+	ErrRuntime     = errors.New("sieve runtime error")
+)
+
+// Extensions lists the extensions a script may require
+var Extensions = []string{
+	"fileinto",
+	"copy",
+	"imap4flags",
+	"variables",
+	"body",
+	"date",
+	"relational",
+	"vacation",
+	"comparator-i;octet",
+	"comparator-i;ascii-casemap",
+	"comparator-i;ascii-numeric",
+}
+
+// Message is the message a script runs on
+type Message struct {
+	Header rfc5322.Header
+	Size   int64  // Size of the whole message in octets
+	Body   string // Body as it appears in the message, for body :raw
+	Parts  []Part // Decoded body parts, for body :text and :content
+}
+
+// Part is a decoded body part
+type Part struct {
+	ContentType string // Media type without parameters, e.g. "text/plain"
+	Text        string
+}
+
+// Action is an action a script takes on a message: Keep, FileInto,
+// Redirect, Discard or Vacation
+type Action interface {
+	action()
+}
+
+// Keep files the message into the inbox. Implicit keeps are those taken
+// because no action cancelled them.
+type Keep struct {
+	Flags    []string // IMAP flags and keywords to set, e.g. \Seen
+	Implicit bool
+}
+
+// FileInto files the message into a mailbox
+type FileInto struct {
+	Mailbox string
+	Flags   []string
+	Copy    bool // Does not cancel the implicit keep
+}
+
+// Redirect sends the message on to another address
+type Redirect struct {
+	Address string
+	Copy    bool
+}
+
+// Discard silently drops the message
+type Discard struct{}
+
+// Vacation replies to the sender that the user is away. The caller
+// decides whether to reply: at most once in Days per sender and Handle,
+// and never to lists or automated mail.
+type Vacation struct {
+	Reason    string
+	Subject   string   // Empty for "Auto: " and the original subject
+	From      string   // Empty for the user's address
+	Addresses []string // Further addresses of the user
+	Days      int
+	MIME      bool   // Reason is a MIME entity rather than plain text
+	Handle    string // Identifies the vacation response for its rate limit
+}
+
+func (Keep) action()     {}
+func (FileInto) action() {}
+func (Redirect) action() {}
+func (Discard) action()  {}
+func (Vacation) action() {}
+
+// Result lists the actions a script took, in order. A message that is
+// neither kept nor filed into a mailbox is to be dropped.
+type Result struct {
+	Actions []Action
+}
+
+// Options set the environment a script runs in
+type Options struct {
+	Now      time.Time      // For currentdate; time.Now() if zero
+	Location *time.Location // Zone of date tests without :zone; time.Local if nil
+}
+
+// Script is a parsed and validated script
+type Script struct {
+	commands   []command
+	extensions map[string]bool
+}
+
+// Parse parses a script and checks its commands and tests against the
+// extensions it requires
+func Parse(src string) (*Script, error) {
+	nodes, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{extensions: map[string]bool{}}
+	commands, err := c.block(nodes, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{commands: commands, extensions: c.extensions}, nil
+}
+
+// Requires tells whether the script requires an extension
+func (s *Script) Requires(extension string) bool {
+	return s.extensions[extension]
+}
+
+// Run runs the script on a message. On a runtime error the message is to
+// be kept: the returned result then holds the implicit keep alone.
+func (s *Script) Run(m *Message, opts Options) (*Result, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	st := &state{
+		message:     m,
+		opts:        opts,
+		variables:   map[string]string{},
+		expand:      s.extensions["variables"],
+		flagsActive: s.extensions["imap4flags"],
+		implicit:    true,
+	}
+
+	err := runBlock(st, s.commands)
+	if err != nil && !errors.Is(err, errStop) {
+		return &Result{Actions: []Action{Keep{Implicit: true}}}, err
+	}
+	if st.implicit {
+		st.actions = append(st.actions, Keep{Flags: st.currentFlags(), Implicit: true})
+	}
+	return &Result{Actions: st.actions}, nil
+}
+
+// runtimeError reports an error running the command or test on a line
+func runtimeError(line int, format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrRuntime, line, fmt.Sprintf(format, args...))
+}
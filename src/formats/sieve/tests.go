@@ -0,0 +1,394 @@
+package sieve
+
+import (
+	"palm/src/formats/htmltext"
+	"palm/src/formats/rfc5322"
+	"strings"
+)
+
+// test is a compiled test
+type test interface {
+	eval(st *state) (bool, error)
+}
+
+// test compiles a test
+func (c *compiler) test(n *node) (test, error) {
+	switch n.name {
+	case "allof", "anyof", "not":
+		if len(n.args) > 0 {
+			return nil, syntaxError(n.line, "%s takes no arguments", n.name)
+		}
+		if n.name == "not" && len(n.tests) != 1 {
+			return nil, syntaxError(n.line, "not takes one test")
+		}
+		if len(n.tests) == 0 {
+			return nil, syntaxError(n.line, "%s takes a test list", n.name)
+		}
+		tests := make([]test, len(n.tests))
+		for i, nested := range n.tests {
+			t, err := c.test(nested)
+			if err != nil {
+				return nil, err
+			}
+			tests[i] = t
+		}
+		switch n.name {
+		case "allof":
+			return allofTest(tests), nil
+		case "anyof":
+			return anyofTest(tests), nil
+		}
+		return notTest{tests[0]}, nil
+	}
+
+	if len(n.tests) > 0 {
+		return nil, syntaxError(n.line, "%s takes no nested tests", n.name)
+	}
+	switch n.name {
+	case "true", "false":
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		return constTest(n.name == "true"), a.count(0, 0)
+
+	case "exists":
+		a, err := c.args(n, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(1, 1); err != nil {
+			return nil, err
+		}
+		names, err := a.stringList(0)
+		return existsTest{names: names}, err
+
+	case "size":
+		a, err := c.args(n, map[string]tagSpec{
+			"over":  {group: "size"},
+			"under": {group: "size"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(1, 1); err != nil {
+			return nil, err
+		}
+		relation, ok := a.groups["size"]
+		if !ok {
+			return nil, syntaxError(n.line, "size needs :over or :under")
+		}
+		limit, err := a.number(0)
+		return sizeTest{over: relation == "over", limit: limit}, err
+
+	case "header", "address":
+		specs := mergeTags(comparatorTags, matchTypeTags)
+		if n.name == "address" {
+			specs = mergeTags(specs, addressPartTags)
+		}
+		a, err := c.args(n, specs)
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(a)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(2, 2); err != nil {
+			return nil, err
+		}
+		t := &headerTest{matcher: m}
+		if t.names, err = a.stringList(0); err != nil {
+			return nil, err
+		}
+		if t.keys, err = a.stringList(1); err != nil {
+			return nil, err
+		}
+		if n.name == "address" {
+			t.address = true
+			t.part = a.groups["address-part"]
+		}
+		return t, nil
+
+	case "body":
+		if err := c.need(n, "body"); err != nil {
+			return nil, err
+		}
+		a, err := c.args(n, mergeTags(comparatorTags, matchTypeTags, map[string]tagSpec{
+			"raw":     {group: "transform"},
+			"text":    {group: "transform"},
+			"content": {kind: tagStringList, group: "transform"},
+		}))
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(a)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(1, 1); err != nil {
+			return nil, err
+		}
+		t := &bodyTest{matcher: m, transform: a.groups["transform"], types: a.tags["content"].strings}
+		if t.transform == "" {
+			t.transform = "text"
+		}
+		t.keys, err = a.stringList(0)
+		return t, err
+
+	case "date", "currentdate":
+		return c.dateTest(n)
+
+	case "string":
+		if err := c.need(n, "variables"); err != nil {
+			return nil, err
+		}
+		a, err := c.args(n, mergeTags(comparatorTags, matchTypeTags))
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(a)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(2, 2); err != nil {
+			return nil, err
+		}
+		t := &stringTest{matcher: m}
+		if t.sources, err = a.stringList(0); err != nil {
+			return nil, err
+		}
+		t.keys, err = a.stringList(1)
+		return t, err
+
+	case "hasflag":
+		if err := c.need(n, "imap4flags"); err != nil {
+			return nil, err
+		}
+		a, err := c.args(n, mergeTags(comparatorTags, matchTypeTags))
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.matcher(a)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.count(1, 2); err != nil {
+			return nil, err
+		}
+		t := &hasflagTest{matcher: m, variables: []string{flagsVariable}}
+		if len(a.positional) == 2 {
+			if err := c.need(n, "variables"); err != nil {
+				return nil, err
+			}
+			names, err := a.stringList(0)
+			if err != nil {
+				return nil, err
+			}
+			t.variables = t.variables[:0]
+			for _, name := range names {
+				name, err := c.variableName(n, name)
+				if err != nil {
+					return nil, err
+				}
+				t.variables = append(t.variables, name)
+			}
+		}
+		t.keys, err = a.stringList(len(a.positional) - 1)
+		return t, err
+
+	case "envelope":
+		return nil, syntaxError(n.line, "envelope tests are not supported")
+	}
+	return nil, syntaxError(n.line, "unknown test %s", n.name)
+}
+
+type constTest bool
+
+func (t constTest) eval(*state) (bool, error) {
+	return bool(t), nil
+}
+
+type notTest struct {
+	test test
+}
+
+func (t notTest) eval(st *state) (bool, error) {
+	ok, err := t.test.eval(st)
+	return !ok, err
+}
+
+type allofTest []test
+
+func (t allofTest) eval(st *state) (bool, error) {
+	for _, nested := range t {
+		if ok, err := nested.eval(st); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+type anyofTest []test
+
+func (t anyofTest) eval(st *state) (bool, error) {
+	for _, nested := range t {
+		if ok, err := nested.eval(st); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+type existsTest struct {
+	names []string
+}
+
+func (t existsTest) eval(st *state) (bool, error) {
+	for _, name := range st.expandAll(t.names) {
+		if !st.message.Header.Has(name) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+type sizeTest struct {
+	over  bool
+	limit int64
+}
+
+func (t sizeTest) eval(st *state) (bool, error) {
+	if t.over {
+		return st.message.Size > t.limit, nil
+	}
+	return st.message.Size < t.limit, nil
+}
+
+// headerTest is the header test, or the address test when address is set
+type headerTest struct {
+	matcher *matcher
+	names   []string
+	keys    []string
+	address bool
+	part    string // all, localpart or domain; all if empty
+}
+
+func (t *headerTest) eval(st *state) (bool, error) {
+	var values []string
+	for _, name := range st.expandAll(t.names) {
+		for _, raw := range st.message.Header.Values(name) {
+			if !t.address {
+				values = append(values, strings.TrimSpace(rfc5322.DecodeHeader(raw)))
+				continue
+			}
+			for _, addr := range rfc5322.ParseAddressList(raw) {
+				values = append(values, addressPart(addr.Address, t.part))
+			}
+		}
+	}
+	return t.matcher.match(st, values, st.expandAll(t.keys)), nil
+}
+
+// addressPart returns the local part, domain or whole of an address
+func addressPart(address, part string) string {
+	at := strings.LastIndexByte(address, '@')
+	switch {
+	case part == "localpart" && at >= 0:
+		return address[:at]
+	case part == "domain":
+		if at < 0 {
+			return ""
+		}
+		return address[at+1:]
+	}
+	return address
+}
+
+type bodyTest struct {
+	matcher   *matcher
+	transform string   // raw, text or content
+	types     []string // Media types for content
+	keys      []string
+}
+
+func (t *bodyTest) eval(st *state) (bool, error) {
+	var values []string
+	switch t.transform {
+	case "raw":
+		values = []string{st.message.Body}
+	case "text":
+		for _, part := range st.message.Parts {
+			switch {
+			case strings.EqualFold(part.ContentType, "text/html"):
+				values = append(values, htmltext.ToText(part.Text))
+			case hasMediaType(part.ContentType, "text"):
+				values = append(values, part.Text)
+			}
+		}
+	case "content":
+		types := st.expandAll(t.types)
+		for _, part := range st.message.Parts {
+			for _, mediaType := range types {
+				if hasMediaType(part.ContentType, mediaType) {
+					values = append(values, part.Text)
+					break
+				}
+			}
+		}
+	}
+	return t.matcher.match(st, values, st.expandAll(t.keys)), nil
+}
+
+// hasMediaType tells whether a content type matches a body :content type:
+// empty for any type, a type alone for any of its subtypes, or a full
+// type/subtype
+func hasMediaType(contentType, want string) bool {
+	if want == "" {
+		return true
+	}
+	if strings.Contains(want, "/") {
+		return strings.EqualFold(contentType, want)
+	}
+	mainType, _, _ := strings.Cut(contentType, "/")
+	return strings.EqualFold(mainType, want)
+}
+
+type stringTest struct {
+	matcher *matcher
+	sources []string
+	keys    []string
+}
+
+func (t *stringTest) eval(st *state) (bool, error) {
+	values := st.expandAll(t.sources)
+	if t.matcher.matchType == "count" {
+		// Empty strings are not counted (RFC 5229 section 5)
+		var nonEmpty []string
+		for _, v := range values {
+			if v != "" {
+				nonEmpty = append(nonEmpty, v)
+			}
+		}
+		values = nonEmpty
+	}
+	return t.matcher.match(st, values, st.expandAll(t.keys)), nil
+}
+
+type hasflagTest struct {
+	matcher   *matcher
+	variables []string
+	keys      []string
+}
+
+func (t *hasflagTest) eval(st *state) (bool, error) {
+	var flags []string
+	for _, name := range t.variables {
+		flags = append(flags, strings.Fields(st.variables[name])...)
+	}
+	keys := st.expandAll(t.keys)
+	if t.matcher.matchType != "count" {
+		keys = splitFlags(keys)
+	}
+	return t.matcher.match(st, flags, keys), nil
+}
@@ -0,0 +1,111 @@
+package sieve
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxVariableLength bounds the value of a variable, in bytes
+const maxVariableLength = 64 << 10
+
+// flagsVariable is the key of the internal variable holding the flags of
+// keep and fileinto. No script can name it.
+const flagsVariable = ""
+
+// state is the state of a running script
+type state struct {
+	message     *Message
+	opts        Options
+	variables   map[string]string // By lower case name
+	matchValues []string          // ${0} to ${n}, from the last :matches that held
+	expand      bool              // Strings have variables expanded
+	flagsActive bool              // The script uses imap4flags
+	implicit    bool              // The implicit keep is not cancelled
+	actions     []Action
+	redirects   int
+	vacation    bool
+}
+
+// setVariable assigns a variable, truncating long values
+func (st *state) setVariable(name, value string) {
+	if len(value) > maxVariableLength {
+		value = value[:maxVariableLength]
+	}
+	st.variables[name] = value
+}
+
+// currentFlags returns the flags of the internal variable, or nil for
+// scripts without imap4flags
+func (st *state) currentFlags() []string {
+	if !st.flagsActive {
+		return nil
+	}
+	return splitFlags([]string{st.variables[flagsVariable]})
+}
+
+// expandString replaces the variable references in a string with their
+// values (RFC 5229 section 3). Unknown variables expand to nothing, and
+// references that are not well formed are left as they are.
+func (st *state) expandString(s string) string {
+	if !st.expand || !strings.Contains(s, "${") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		name := s[start+2 : start+end]
+		value, ok := st.reference(name)
+		if !ok {
+			// Not a reference; go on after the "${"
+			b.WriteString(s[:start+2])
+			s = s[start+2:]
+			continue
+		}
+		b.WriteString(s[:start])
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// reference resolves the name in a ${...} reference. ok is false when the
+// name is not a variable name.
+func (st *state) reference(name string) (value string, ok bool) {
+	if name == "" {
+		return "", false
+	}
+	if n, err := strconv.Atoi(name); err == nil && name[0] != '+' && name[0] != '-' {
+		if n < len(st.matchValues) {
+			return st.matchValues[n], true
+		}
+		return "", true
+	}
+	for _, part := range strings.Split(name, ".") {
+		if !isIdentifier(part) {
+			return "", false
+		}
+	}
+	// Namespaced variables such as ${env.name} are not supported and
+	// expand to nothing
+	return st.variables[strings.ToLower(name)], true
+}
+
+// expandAll expands the variables of each string of a list
+func (st *state) expandAll(list []string) []string {
+	if !st.expand {
+		return list
+	}
+	expanded := make([]string, len(list))
+	for i, s := range list {
+		expanded[i] = st.expandString(s)
+	}
+	return expanded
+}
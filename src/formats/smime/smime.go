@@ -0,0 +1,104 @@
+// Package smime reads and writes the application/pkcs7-mime entities of
+// S/MIME (RFC 8551 section 3.2), which carry enveloped (encrypted) or
+// opaque signed CMS data. Detached signatures are multipart/signed
+// entities, handled by package rfc1847.
+//
+// The package only handles the MIME structure; CMS is left to the caller.
+package smime
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"palm/src/formats/rfc5322"
+	"strings"
+)
+
+// Media types of RFC 8551
+const (
+	MIMEType      = "application/pkcs7-mime"
+	SignatureType = "application/pkcs7-signature"
+)
+
+// The "x-" media types that older clients still send
+const (
+	legacyMIMEType      = "application/x-pkcs7-mime"
+	legacySignatureType = "application/x-pkcs7-signature"
+)
+
+const (
+	base64LineLength = 76 // RFC 2045 section 6.8
+	filename         = "smime.p7m"
+)
+
+// Values of the smime-type parameter
+const (
+	EnvelopedData = "enveloped-data"
+	SignedData    = "signed-data"
+)
+
+// Common errors
+var (
+	ErrNotPKCS7MIME = errors.New("entity is not application/pkcs7-mime")
+	ErrMalformed    = errors.New("malformed application/pkcs7-mime entity")
+)
+
+// Entity is a parsed application/pkcs7-mime entity
+type Entity struct {
+	SMIMEType string // EnvelopedData, SignedData or another value, lower case; empty if absent
+	Data      []byte // DER-encoded CMS with its transfer encoding undone
+}
+
+// IsSignatureType reports whether mediaType is the protocol of an S/MIME
+// multipart/signed entity
+func IsSignatureType(mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	return mediaType == SignatureType || mediaType == legacySignatureType
+}
+
+// Parse reads an application/pkcs7-mime entity, such as a whole message
+func Parse(entity []byte) (*Entity, error) {
+	header, err := rfc5322.ReadHeader(bufio.NewReader(bytes.NewReader(entity)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || (mediaType != MIMEType && mediaType != legacyMIMEType) {
+		return nil, ErrNotPKCS7MIME
+	}
+
+	m, err := rfc5322.Parse(bytes.NewReader(entity))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if len(m.Attachments) == 0 {
+		return nil, fmt.Errorf("%w: no content", ErrMalformed)
+	}
+	return &Entity{
+		SMIMEType: strings.ToLower(params["smime-type"]),
+		Data:      m.Attachments[0].Data,
+	}, nil
+}
+
+// Write returns an application/pkcs7-mime entity of DER-encoded CMS data
+func Write(smimeType string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("Content-Type: " + mime.FormatMediaType(MIMEType, map[string]string{
+		"smime-type": smimeType,
+		"name":       filename,
+	}) + "\r\n")
+	b.WriteString("Content-Disposition: " + mime.FormatMediaType("attachment", map[string]string{"filename": filename}) + "\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > base64LineLength {
+		b.WriteString(encoded[:base64LineLength])
+		b.WriteString("\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.Bytes()
+}
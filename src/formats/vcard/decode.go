@@ -0,0 +1,368 @@
+package vcard
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Decoder reads cards from a stream of concatenated vCards
+type Decoder struct {
+	scanner *bufio.Scanner
+	pending *string
+	line    int
+}
+
+// NewDecoder creates a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &Decoder{scanner: scanner}
+}
+
+// DecodeAll reads every card from r
+func DecodeAll(r io.Reader) ([]*Card, error) {
+	decoder := NewDecoder(r)
+	var cards []*Card
+	for {
+		card, err := decoder.Decode()
+		if err == io.EOF {
+			return cards, nil
+		}
+		if err != nil {
+			return cards, err
+		}
+		cards = append(cards, card)
+	}
+}
+
+// Decode reads the next card. It returns io.EOF when no cards remain.
+func (d *Decoder) Decode() (*Card, error) {
+	var card *Card
+	for {
+		line, err := d.nextLine()
+		if err == io.EOF {
+			if card != nil {
+				return nil, fmt.Errorf("%w: missing END:VCARD", ErrMalformed)
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		prop, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", d.line, err)
+		}
+
+		switch {
+		case prop.name == "BEGIN" && strings.EqualFold(prop.value, "VCARD"):
+			if card != nil {
+				return nil, fmt.Errorf("line %d: %w: nested BEGIN:VCARD", d.line, ErrMalformed)
+			}
+			card = &Card{}
+		case card == nil:
+			return nil, fmt.Errorf("line %d: %w: property outside BEGIN:VCARD", d.line, ErrMalformed)
+		case prop.name == "END" && strings.EqualFold(prop.value, "VCARD"):
+			if card.Version != Version3 && card.Version != Version4 {
+				return nil, fmt.Errorf("%w: %q", ErrUnsupportedVersion, card.Version)
+			}
+			return card, nil
+		default:
+			if err := card.apply(prop); err != nil {
+				return nil, fmt.Errorf("line %d: %w", d.line, err)
+			}
+		}
+	}
+}
+
+// nextLine returns the next logical line, joining folded continuation lines
+func (d *Decoder) nextLine() (string, error) {
+	var line string
+	if d.pending != nil {
+		line = *d.pending
+		d.pending = nil
+	} else {
+		if !d.scanner.Scan() {
+			if err := d.scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		d.line++
+		line = strings.TrimSuffix(d.scanner.Text(), "\r")
+	}
+
+	for d.scanner.Scan() {
+		d.line++
+		next := strings.TrimSuffix(d.scanner.Text(), "\r")
+		if strings.HasPrefix(next, " ") || strings.HasPrefix(next, "\t") {
+			line += next[1:]
+			continue
+		}
+		d.pending = &next
+		break
+	}
+	if err := d.scanner.Err(); err != nil {
+		return "", err
+	}
+	return line, nil
+}
+
+// property is one parsed content line
+type property struct {
+	name   string              // upper-case, group prefix removed
+	params map[string][]string // upper-case keys, raw values
+	value  string              // raw (still escaped) value
+}
+
+func (p property) param(name string) string {
+	if values := p.params[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// types returns the lower-cased TYPE values, splitting comma lists
+func (p property) types() []string {
+	var types []string
+	for _, value := range p.params["TYPE"] {
+		for _, t := range strings.Split(value, ",") {
+			if t = strings.ToLower(strings.Trim(t, `" `)); t != "" {
+				types = append(types, t)
+			}
+		}
+	}
+	return types
+}
+
+// parseProperty splits "group.NAME;PARAM=a,b;PARAM2=c:value"
+func parseProperty(line string) (property, error) {
+	colon := -1
+	inQuotes := false
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, fmt.Errorf("%w: missing ':' in %q", ErrMalformed, line)
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	parts := splitUnquoted(head, ';')
+	name := strings.ToUpper(parts[0])
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+
+	prop := property{name: name, params: make(map[string][]string), value: value}
+	for _, param := range parts[1:] {
+		key, val, found := strings.Cut(param, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if !found {
+			// vCard 2.1 style bare type, e.g. "TEL;WORK:..."
+			prop.params["TYPE"] = append(prop.params["TYPE"], key)
+			continue
+		}
+		prop.params[key] = append(prop.params[key], strings.Trim(val, `"`))
+	}
+	return prop, nil
+}
+
+// splitUnquoted splits s on sep outside double quotes
+func splitUnquoted(s string, sep rune) []string {
+	var parts []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case r == sep && !inQuotes:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(parts, current.String())
+}
+
+// apply stores a property on the card
+func (c *Card) apply(p property) error {
+	switch p.name {
+	case "VERSION":
+		c.Version = strings.TrimSpace(p.value)
+	case "UID":
+		c.UID = unescapeText(p.value)
+	case "FN":
+		c.FormattedName = unescapeText(p.value)
+	case "N":
+		fields := splitEscaped(p.value, ';')
+		for len(fields) < 5 {
+			fields = append(fields, "")
+		}
+		c.Name = Name{
+			Family:     fields[0],
+			Given:      fields[1],
+			Additional: fields[2],
+			Prefix:     fields[3],
+			Suffix:     fields[4],
+		}
+	case "EMAIL":
+		c.Emails = append(c.Emails, typedValue(p, unescapeText(p.value)))
+	case "TEL":
+		number := strings.TrimPrefix(unescapeText(p.value), "tel:")
+		c.Phones = append(c.Phones, typedValue(p, number))
+	case "ORG":
+		c.Organization = strings.Join(nonEmpty(splitEscaped(p.value, ';')), ", ")
+	case "NOTE":
+		c.Note = unescapeText(p.value)
+	case "PHOTO":
+		photo, err := parsePhoto(p)
+		if err != nil {
+			return err
+		}
+		c.Photo = photo
+	}
+	return nil
+}
+
+// typedValue builds a TypedValue, moving the "pref" type or PREF parameter
+// into the Preferred flag
+func typedValue(p property, value string) TypedValue {
+	v := TypedValue{Value: strings.TrimSpace(value)}
+	types := p.types()
+	for _, t := range types {
+		switch {
+		case t == "pref":
+			v.Preferred = true
+		case t == "internet":
+			// Implied for every EMAIL
+		case t == "voice" && len(types) > 1:
+			// Implied for TEL when a more specific type is given
+		default:
+			v.Types = append(v.Types, t)
+		}
+	}
+	if p.param("PREF") != "" {
+		v.Preferred = true
+	}
+	return v
+}
+
+// parsePhoto reads an inline (3.0 ENCODING=b or 4.0 data: URI) or linked photo
+func parsePhoto(p property) (*Photo, error) {
+	encoding := strings.ToLower(p.param("ENCODING"))
+	if encoding == "b" || encoding == "base64" {
+		data, err := decodeBase64(p.value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid PHOTO data: %v", ErrMalformed, err)
+		}
+		return &Photo{MediaType: photoMediaType(p), Data: data}, nil
+	}
+
+	value := strings.TrimSpace(p.value)
+	if strings.HasPrefix(strings.ToLower(value), "data:") {
+		meta, payload, found := strings.Cut(value[len("data:"):], ",")
+		if !found || !strings.HasSuffix(strings.ToLower(meta), ";base64") {
+			return nil, fmt.Errorf("%w: unsupported PHOTO data URI", ErrMalformed)
+		}
+		data, err := decodeBase64(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid PHOTO data: %v", ErrMalformed, err)
+		}
+		return &Photo{MediaType: meta[:len(meta)-len(";base64")], Data: data}, nil
+	}
+
+	return &Photo{MediaType: p.param("MEDIATYPE"), URI: value}, nil
+}
+
+// photoMediaType maps a 3.0 TYPE parameter such as JPEG to a media type
+func photoMediaType(p property) string {
+	t := strings.ToLower(p.param("TYPE"))
+	switch {
+	case t == "":
+		return ""
+	case strings.Contains(t, "/"):
+		return t
+	case t == "jpg":
+		return "image/jpeg"
+	default:
+		return "image/" + t
+	}
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+	if data, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return data, nil
+	}
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// splitEscaped splits a structured value on sep, honouring backslash escapes,
+// and unescapes each component
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			parts = append(parts, unescapeText(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, unescapeText(s[start:]))
+}
+
+// unescapeText reverses the TEXT escaping of RFC 6350 section 3.4
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func nonEmpty(values []string) []string {
+	out := values[:0]
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
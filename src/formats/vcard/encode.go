@@ -0,0 +1,162 @@
+package vcard
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the folding limit of RFC 6350 section 3.2
+const maxLineOctets = 75
+
+// Encoder writes cards to a stream
+type Encoder struct {
+	w       *bufio.Writer
+	version string
+}
+
+// NewEncoder creates an encoder writing cards of the given version
+func NewEncoder(w io.Writer, version string) (*Encoder, error) {
+	if version != Version3 && version != Version4 {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedVersion, version)
+	}
+	return &Encoder{w: bufio.NewWriter(w), version: version}, nil
+}
+
+// EncodeAll writes every card to w in the given version
+func EncodeAll(w io.Writer, version string, cards []*Card) error {
+	encoder, err := NewEncoder(w, version)
+	if err != nil {
+		return err
+	}
+	for _, card := range cards {
+		if err := encoder.Encode(card); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode writes one card. The card's own Version is ignored in favour of
+// the encoder's.
+func (e *Encoder) Encode(c *Card) error {
+	e.writeLine("BEGIN:VCARD")
+	e.writeLine("VERSION:" + e.version)
+	if c.UID != "" {
+		e.writeLine("UID:" + escapeText(c.UID))
+	}
+
+	// FN is required in both versions; N is required in 3.0
+	e.writeLine("FN:" + escapeText(c.DisplayName()))
+	if !c.Name.IsZero() || e.version == Version3 {
+		n := c.Name
+		e.writeLine("N:" + strings.Join([]string{
+			escapeText(n.Family), escapeText(n.Given), escapeText(n.Additional),
+			escapeText(n.Prefix), escapeText(n.Suffix),
+		}, ";"))
+	}
+
+	for _, email := range c.Emails {
+		types := email.Types
+		if e.version == Version3 {
+			types = append([]string{"internet"}, types...)
+		}
+		e.writeLine("EMAIL" + e.typeParams(types, email.Preferred) + ":" + escapeText(email.Value))
+	}
+	for _, phone := range c.Phones {
+		e.writeLine("TEL" + e.typeParams(phone.Types, phone.Preferred) + ":" + escapeText(phone.Value))
+	}
+	if c.Organization != "" {
+		e.writeLine("ORG:" + escapeText(c.Organization))
+	}
+	if c.Note != "" {
+		e.writeLine("NOTE:" + escapeText(c.Note))
+	}
+	if c.Photo != nil {
+		e.writeLine(e.photoLine(c.Photo))
+	}
+
+	e.writeLine("END:VCARD")
+	return e.w.Flush()
+}
+
+// typeParams renders TYPE and preference parameters for the version
+func (e *Encoder) typeParams(types []string, preferred bool) string {
+	var params []string
+	upper := make([]string, 0, len(types)+1)
+	for _, t := range types {
+		if e.version == Version3 {
+			t = strings.ToUpper(t)
+		}
+		upper = append(upper, t)
+	}
+	if preferred {
+		if e.version == Version3 {
+			upper = append(upper, "PREF")
+		} else {
+			params = append(params, "PREF=1")
+		}
+	}
+	if len(upper) > 0 {
+		params = append([]string{"TYPE=" + strings.Join(upper, ",")}, params...)
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return ";" + strings.Join(params, ";")
+}
+
+// photoLine renders an inline photo as ENCODING=b (3.0) or a data URI (4.0)
+func (e *Encoder) photoLine(photo *Photo) string {
+	if photo.URI != "" {
+		if e.version == Version3 {
+			return "PHOTO;VALUE=uri:" + photo.URI
+		}
+		if photo.MediaType != "" {
+			return "PHOTO;MEDIATYPE=" + photo.MediaType + ":" + photo.URI
+		}
+		return "PHOTO:" + photo.URI
+	}
+
+	data := base64.StdEncoding.EncodeToString(photo.Data)
+	if e.version == Version3 {
+		line := "PHOTO;ENCODING=b"
+		if subtype := strings.TrimPrefix(photo.MediaType, "image/"); subtype != "" {
+			line += ";TYPE=" + strings.ToUpper(subtype)
+		}
+		return line + ":" + data
+	}
+	mediaType := photo.MediaType
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	return "PHOTO:data:" + mediaType + ";base64," + data
+}
+
+// writeLine writes a content line folded at 75 octets without splitting
+// UTF-8 sequences, terminated by CRLF
+func (e *Encoder) writeLine(line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		e.w.WriteString(line[:cut])
+		e.w.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts toward the limit
+		limit = maxLineOctets - 1
+	}
+	e.w.WriteString(line)
+	e.w.WriteString("\r\n")
+}
+
+// escapeText applies the TEXT escaping of RFC 6350 section 3.4
+func escapeText(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,92 @@
+// Package vcard reads and writes vCard 3.0 (RFC 2426) and 4.0 (RFC 6350)
+// address book entries.
+//
+// Only the properties Palm keeps for a contact are modelled: names, email
+// addresses, phone numbers, organization, note, UID and photo. Other
+// properties are skipped when decoding.
+package vcard
+
+import (
+	"errors"
+	"strings"
+)
+
+// Supported versions
+const (
+	Version3 = "3.0"
+	Version4 = "4.0"
+)
+
+// Common errors
+var (
+	ErrUnsupportedVersion = errors.New("unsupported vCard version")
+	ErrMalformed          = errors.New("malformed vCard")
+)
+
+// Card is a single vCard
+type Card struct {
+	Version       string
+	UID           string
+	FormattedName string // FN
+	Name          Name   // N
+	Emails        []TypedValue
+	Phones        []TypedValue
+	Organization  string
+	Note          string
+	Photo         *Photo
+}
+
+// Name holds the structured N property
+type Name struct {
+	Family     string
+	Given      string
+	Additional string
+	Prefix     string
+	Suffix     string
+}
+
+// IsZero reports whether no name component is set
+func (n Name) IsZero() bool {
+	return n == Name{}
+}
+
+// TypedValue is an email address or phone number with its TYPE parameters
+// (lower-cased, e.g. "work", "home", "cell") and preference flag
+type TypedValue struct {
+	Value     string
+	Types     []string
+	Preferred bool
+}
+
+// HasType reports whether t is one of the value's types
+func (v TypedValue) HasType(t string) bool {
+	for _, typ := range v.Types {
+		if strings.EqualFold(typ, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Photo is an embedded image or a link to one. Exactly one of Data and URI
+// is set.
+type Photo struct {
+	MediaType string // e.g. "image/jpeg"; may be empty for URIs
+	Data      []byte
+	URI       string
+}
+
+// DisplayName returns the formatted name, falling back to the structured name
+func (c *Card) DisplayName() string {
+	if c.FormattedName != "" {
+		return c.FormattedName
+	}
+	parts := []string{c.Name.Prefix, c.Name.Given, c.Name.Additional, c.Name.Family, c.Name.Suffix}
+	var words []string
+	for _, part := range parts {
+		if part != "" {
+			words = append(words, part)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -17,6 +17,7 @@ type AccountRepository interface {
 	Create(ctx context.Context, account *entities.Account) *gorm.DB
 	GetByID(ctx context.Context, id uint) (*entities.Account, error)
 	GetByEmail(ctx context.Context, email string) (*entities.Account, error)
+	Update(ctx context.Context, account *entities.Account) error
 	Delete(ctx context.Context, id uint) error
 	List(ctx context.Context) ([]*entities.Account, error)
 }
@@ -0,0 +1,32 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"palm/src/entities"
+
+	"gorm.io/gorm"
+)
+
+// Common repository errors
+var (
+	ErrContactNotFound = errors.New("contact not found")
+)
+
+type ContactRepository interface {
+	Create(ctx context.Context, contact *entities.Contact) *gorm.DB
+	GetByID(ctx context.Context, id uint) (*entities.Contact, error)
+	GetByEmail(ctx context.Context, email string) (*entities.Contact, error)
+	Update(ctx context.Context, contact *entities.Contact) error
+	Delete(ctx context.Context, id uint) error
+	List(ctx context.Context) ([]*entities.Contact, error)
+	Count(ctx context.Context) (int64, error)
+	// Search returns contacts with an address or a display-name word starting
+	// with prefix, most frequently contacted first
+	Search(ctx context.Context, prefix string, limit int) ([]*entities.Contact, error)
+	AddEmail(ctx context.Context, contactID uint, email string) error
+	AddPhone(ctx context.Context, contactID uint, number, phoneType string) error
+	// Merge moves the addresses, phones and counters of the source contacts into the
+	// target contact and deletes the sources
+	Merge(ctx context.Context, targetID uint, sourceIDs []uint) error
+}
@@ -73,6 +73,22 @@ func (r *accountRepository) GetByEmail(ctx context.Context, email string) (*enti
 	return &account, nil
 }
 
+func (r *accountRepository) Update(ctx context.Context, account *entities.Account) error {
+	config.Logger.Debug().Uint("id", account.ID).Msg("Updating account")
+
+	result := r.db.WithContext(ctx).Model(account).Select("*").Omit("created_at").Updates(account)
+	if result.Error != nil {
+		config.Logger.Error().Err(result.Error).Uint("id", account.ID).Msg("Error updating account")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		config.Logger.Warn().Uint("id", account.ID).Msg("Account not found for update")
+		return repositories.ErrAccountNotFound
+	}
+	config.Logger.Info().Uint("id", account.ID).Msg("Account updated successfully")
+	return nil
+}
+
 func (r *accountRepository) Delete(ctx context.Context, id uint) error {
 	config.Logger.Debug().Uint("id", id).Msg("Deleting account")
 
@@ -0,0 +1,262 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/repositories"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// contactRankOrder orders contacts by interaction frequency, weighting
+// messages we sent, then by recency
+const contactRankOrder = "(contacts.message_count + 2 * contacts.sent_count) DESC, contacts.last_interaction DESC, contacts.id"
+
+type contactRepository struct {
+	db *gorm.DB
+}
+
+func NewContactRepository(db *gorm.DB) repositories.ContactRepository {
+	config.Logger.Debug().Msg("Initializing contact repository")
+	return &contactRepository{db: db}
+}
+
+func (r *contactRepository) Create(ctx context.Context, contact *entities.Contact) *gorm.DB {
+	config.Logger.Debug().
+		Int("emailCount", len(contact.Emails)).
+		Msg("Creating new contact")
+
+	result := r.db.WithContext(ctx).Create(contact)
+	if result.Error != nil {
+		config.Logger.Error().
+			Err(result.Error).
+			Msg("Failed to create contact")
+	} else {
+		config.Logger.Info().
+			Uint("contactID", contact.ID).
+			Msg("Contact created successfully")
+	}
+	return result
+}
+
+func (r *contactRepository) GetByID(ctx context.Context, id uint) (*entities.Contact, error) {
+	config.Logger.Debug().Uint("id", id).Msg("Getting contact by ID")
+
+	var contact entities.Contact
+	err := r.db.WithContext(ctx).Preload("Emails").Preload("Phones").First(&contact, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			config.Logger.Warn().Uint("id", id).Msg("Contact not found")
+			return nil, repositories.ErrContactNotFound
+		}
+		config.Logger.Error().Err(err).Uint("id", id).Msg("Error retrieving contact")
+		return nil, err
+	}
+	return &contact, nil
+}
+
+func (r *contactRepository) GetByEmail(ctx context.Context, email string) (*entities.Contact, error) {
+	config.Logger.Debug().Str("email", email).Msg("Getting contact by email")
+
+	var contact entities.Contact
+	err := r.db.WithContext(ctx).
+		Preload("Emails").Preload("Phones").
+		Where("id = (SELECT contact_id FROM contact_emails WHERE email = ?)", email).
+		First(&contact).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, repositories.ErrContactNotFound
+		}
+		config.Logger.Error().Err(err).Str("email", email).Msg("Error retrieving contact")
+		return nil, err
+	}
+	return &contact, nil
+}
+
+func (r *contactRepository) Update(ctx context.Context, contact *entities.Contact) error {
+	config.Logger.Debug().Uint("contactID", contact.ID).Msg("Updating contact")
+
+	result := r.db.WithContext(ctx).Omit("Emails", "Phones").Save(contact)
+	if result.Error != nil {
+		config.Logger.Error().
+			Err(result.Error).
+			Uint("contactID", contact.ID).
+			Msg("Error updating contact")
+		return result.Error
+	}
+	return nil
+}
+
+func (r *contactRepository) Delete(ctx context.Context, id uint) error {
+	config.Logger.Debug().Uint("id", id).Msg("Deleting contact")
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&entities.Contact{}, id)
+		if result.Error != nil {
+			config.Logger.Error().Err(result.Error).Uint("id", id).Msg("Error deleting contact")
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			config.Logger.Warn().Uint("id", id).Msg("Contact not found for deletion")
+			return repositories.ErrContactNotFound
+		}
+		// Release the addresses so they can be attached to other contacts
+		if err := tx.Where("contact_id = ?", id).Delete(&entities.ContactEmail{}).Error; err != nil {
+			config.Logger.Error().Err(err).Uint("id", id).Msg("Error deleting contact emails")
+			return err
+		}
+		if err := tx.Where("contact_id = ?", id).Delete(&entities.ContactPhone{}).Error; err != nil {
+			config.Logger.Error().Err(err).Uint("id", id).Msg("Error deleting contact phones")
+			return err
+		}
+		config.Logger.Info().Uint("id", id).Msg("Contact deleted successfully")
+		return nil
+	})
+}
+
+func (r *contactRepository) List(ctx context.Context) ([]*entities.Contact, error) {
+	config.Logger.Debug().Msg("Listing all contacts")
+
+	var contacts []*entities.Contact
+	err := r.db.WithContext(ctx).Preload("Emails").Preload("Phones").Order(contactRankOrder).Find(&contacts).Error
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Error listing contacts")
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func (r *contactRepository) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&entities.Contact{}).Count(&count).Error
+	return count, err
+}
+
+func (r *contactRepository) Search(ctx context.Context, prefix string, limit int) ([]*entities.Contact, error) {
+	config.Logger.Debug().Str("prefix", prefix).Int("limit", limit).Msg("Searching contacts")
+
+	pattern := escapeLike(strings.ToLower(prefix)) + "%"
+	var contacts []*entities.Contact
+	err := r.db.WithContext(ctx).
+		Preload("Emails").Preload("Phones").
+		// Parenthesized so the OR cannot escape the soft-delete condition
+		Where(`(id IN (SELECT contact_id FROM contact_emails WHERE email LIKE ? ESCAPE '\') OR
+			LOWER(COALESCE(display_name, '')) LIKE ? ESCAPE '\' OR
+			LOWER(COALESCE(display_name, '')) LIKE ? ESCAPE '\')`,
+			pattern, pattern, "% "+pattern).
+		Order(contactRankOrder).
+		Limit(limit).
+		Find(&contacts).Error
+	if err != nil {
+		config.Logger.Error().Err(err).Str("prefix", prefix).Msg("Error searching contacts")
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func (r *contactRepository) AddEmail(ctx context.Context, contactID uint, email string) error {
+	config.Logger.Debug().Uint("contactID", contactID).Str("email", email).Msg("Adding contact email")
+
+	err := r.db.WithContext(ctx).Create(&entities.ContactEmail{ContactID: contactID, Email: email}).Error
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("contactID", contactID).
+			Str("email", email).
+			Msg("Error adding contact email")
+	}
+	return err
+}
+
+func (r *contactRepository) AddPhone(ctx context.Context, contactID uint, number, phoneType string) error {
+	config.Logger.Debug().Uint("contactID", contactID).Str("number", number).Msg("Adding contact phone")
+
+	err := r.db.WithContext(ctx).Create(&entities.ContactPhone{ContactID: contactID, Number: number, Type: phoneType}).Error
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("contactID", contactID).
+			Msg("Error adding contact phone")
+	}
+	return err
+}
+
+func (r *contactRepository) Merge(ctx context.Context, targetID uint, sourceIDs []uint) error {
+	config.Logger.Debug().
+		Uint("targetID", targetID).
+		Interface("sourceIDs", sourceIDs).
+		Msg("Merging contacts")
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var target entities.Contact
+		if err := tx.First(&target, targetID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return repositories.ErrContactNotFound
+			}
+			return err
+		}
+
+		var sources []entities.Contact
+		if err := tx.Where("id IN ? AND id <> ?", sourceIDs, targetID).Find(&sources).Error; err != nil {
+			return err
+		}
+		if len(sources) != len(sourceIDs) {
+			return repositories.ErrContactNotFound
+		}
+
+		for _, source := range sources {
+			target.MessageCount += source.MessageCount
+			target.SentCount += source.SentCount
+			if source.LastInteraction != nil &&
+				(target.LastInteraction == nil || source.LastInteraction.After(*target.LastInteraction)) {
+				target.LastInteraction = source.LastInteraction
+			}
+			if target.DisplayName == nil && source.DisplayName != nil {
+				target.DisplayName = source.DisplayName
+				target.DisplayNameLocked = source.DisplayNameLocked
+			}
+			if target.Organization == nil {
+				target.Organization = source.Organization
+			}
+			if target.Note == nil {
+				target.Note = source.Note
+			}
+			if target.Photo == nil && source.Photo != nil {
+				target.Photo = source.Photo
+				target.PhotoMediaType = source.PhotoMediaType
+			}
+		}
+
+		if err := tx.Model(&entities.ContactEmail{}).
+			Where("contact_id IN ?", sourceIDs).
+			Update("contact_id", targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&entities.ContactPhone{}).
+			Where("contact_id IN ?", sourceIDs).
+			Update("contact_id", targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&entities.Contact{}, sourceIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Omit("Emails", "Phones").Save(&target).Error; err != nil {
+			return err
+		}
+
+		config.Logger.Info().
+			Uint("targetID", targetID).
+			Int("merged", len(sources)).
+			Msg("Contacts merged successfully")
+		return nil
+	})
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return replacer.Replace(s)
+}
@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"palm/src/config"
 	"palm/src/entities"
+	"palm/src/formats/maildir"
 	"palm/src/repositories"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
 // Custom error types
@@ -28,7 +32,9 @@ func NewAccountService(repo repositories.AccountRepository) *AccountService {
 func (s *AccountService) validateAccountType(accountType string) error {
 	config.Logger.Debug().Str("accountType", accountType).Msg("Validating account type")
 
-	if accountType != entities.AccountTypeMicrosoft && accountType != entities.AccountTypeGoogle {
+	if accountType != entities.AccountTypeMicrosoft &&
+		accountType != entities.AccountTypeGoogle &&
+		accountType != entities.AccountTypeMaildir {
 		config.Logger.Warn().
 			Str("accountType", accountType).
 			Msg("Invalid account type")
@@ -46,26 +52,58 @@ func (s *AccountService) CreateAccount(ctx context.Context, email, accountType s
 	if err := s.validateAccountType(accountType); err != nil {
 		return nil, err
 	}
+	if accountType == entities.AccountTypeMaildir {
+		return nil, ErrNoMaildirPath
+	}
 
-	account := &entities.Account{
+	return s.create(ctx, &entities.Account{
 		Email:       email,
 		AccountType: accountType,
+	})
+}
+
+// CreateMaildirAccount creates a Local Maildir account that reads the
+// maildir at path. Its messages are loaded by the account's first sync.
+func (s *AccountService) CreateMaildirAccount(ctx context.Context, email, path string) (*entities.Account, error) {
+	config.Logger.Info().
+		Str("email", email).
+		Str("path", path).
+		Msg("Creating new maildir account")
+
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maildir path: %w", err)
 	}
+	if _, err := maildir.Open(absolute); err != nil {
+		config.Logger.Warn().
+			Err(err).
+			Str("path", absolute).
+			Msg("Not a maildir")
+		return nil, err
+	}
+
+	return s.create(ctx, &entities.Account{
+		Email:       email,
+		AccountType: entities.AccountTypeMaildir,
+		MaildirPath: &absolute,
+	})
+}
 
+func (s *AccountService) create(ctx context.Context, account *entities.Account) (*entities.Account, error) {
 	result := s.repo.Create(ctx, account)
 	if result.Error != nil {
 		config.Logger.Error().
 			Err(result.Error).
-			Str("email", email).
-			Str("accountType", accountType).
+			Str("email", account.Email).
+			Str("accountType", account.AccountType).
 			Msg("Failed to create account")
 		return nil, fmt.Errorf("failed to create account: %w", result.Error)
 	}
 
 	config.Logger.Info().
 		Uint("id", account.ID).
-		Str("email", email).
-		Str("accountType", accountType).
+		Str("email", account.Email).
+		Str("accountType", account.AccountType).
 		Msg("Account created successfully")
 
 	return account, nil
@@ -113,6 +151,34 @@ func (s *AccountService) GetAccountByEmail(ctx context.Context, email string) (*
 	return account, nil
 }
 
+// SetTrustedAuthServIDs sets the authserv-ids of the servers delivering
+// the account's mail, whose Authentication-Results fields are believed.
+// Without any, senders are only verified from DKIM signatures.
+func (s *AccountService) SetTrustedAuthServIDs(ctx context.Context, id uint, authServIDs []string) (*entities.Account, error) {
+	config.Logger.Info().Uint("id", id).Strs("authServIDs", authServIDs).Msg("Setting trusted authserv-ids")
+
+	account, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	// authserv-ids are domain names, compared without case
+	account.TrustedAuthServIDs = []string{}
+	for _, authServID := range authServIDs {
+		authServID = strings.ToLower(strings.TrimSpace(authServID))
+		if authServID != "" && !slices.Contains(account.TrustedAuthServIDs, authServID) {
+			account.TrustedAuthServIDs = append(account.TrustedAuthServIDs, authServID)
+		}
+	}
+	if err := s.repo.Update(ctx, account); err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("id", id).
+			Msg("Failed to set trusted authserv-ids")
+		return nil, fmt.Errorf("failed to update account: %w", err)
+	}
+	return account, nil
+}
+
 func (s *AccountService) DeleteAccount(ctx context.Context, id uint) error {
 	config.Logger.Info().Uint("id", id).Msg("Deleting account")
 
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"palm/src/config"
+	"palm/src/events"
+	"strings"
+	"unicode/utf8"
+)
+
+// Custom error types
+var (
+	// ErrDuplicateEmail is returned when an imported message's Message-ID is
+	// already in the account
+	ErrDuplicateEmail = errors.New("email already exists in account")
+	// ErrInvalidEML is returned for a file that is not an RFC 5322 message
+	ErrInvalidEML = errors.New("not an email message")
+)
+
+// maxEMLFilenameLength bounds the subject part of an exported file name
+const maxEMLFilenameLength = 80
+
+// ImportEML creates an email in an account from the RFC 5322 message read
+// from r, such as a .eml file, and returns it. A message the account
+// already has is rejected with ErrDuplicateEmail.
+func (s *ArchiveService) ImportEML(ctx context.Context, accountID uint, r io.Reader) (*EmailDTO, error) {
+	account, err := s.account(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	email, err := ParseEmail(account, data, s.store)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.existingMessageIDs(ctx, accountID, []*EmailDTO{email})
+	if err != nil {
+		return nil, err
+	}
+	if messageID := *email.Message.InternetMessageID; existing[messageID] {
+		config.Logger.Info().
+			Uint("accountID", accountID).
+			Str("messageID", messageID).
+			Msg("Skipping .eml import of a message the account already has")
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateEmail, messageID)
+	}
+
+	email.Origin = events.OriginImport
+	if err := s.emailService.Create(ctx, email); err != nil {
+		return nil, err
+	}
+
+	config.Logger.Info().
+		Uint("accountID", accountID).
+		Uint("messageID", email.Message.ID).
+		Msg("Imported .eml message")
+	return email, nil
+}
+
+// ImportEMLFiles imports many .eml files into an account, the same way as
+// ImportMbox: duplicates are skipped and unreadable files are counted as
+// failed without stopping the import.
+func (s *ArchiveService) ImportEMLFiles(ctx context.Context, accountID uint, paths []string, opts ImportOptions) (*ImportResult, error) {
+	opts.TotalBytes = 0
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			opts.TotalBytes += info.Size()
+		}
+	}
+
+	var bytesRead int64
+	next := func() (*rawMessage, error) {
+		if len(paths) == 0 {
+			return nil, io.EOF
+		}
+		path := paths[0]
+		paths = paths[1:]
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// An empty message is counted as failed
+			config.Logger.Warn().Err(err).Str("path", path).Msg("Failed to read .eml file")
+			return &rawMessage{}, nil
+		}
+		bytesRead += int64(len(data))
+		return &rawMessage{data: data}, nil
+	}
+	return s.importMessages(ctx, accountID, "eml", opts, next, func() int64 { return bytesRead })
+}
+
+// WriteEML writes the RFC 5322 source of an email to w, as it is saved to
+// a .eml file. The source is the one the email was stored with, or else
+// one rebuilt from its fields.
+func (s *ArchiveService) WriteEML(ctx context.Context, messageID uint, w io.Writer) error {
+	source, err := emailSource(ctx, s.db, s.emailService, s.store, messageID)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(source.Data); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// EMLFilename suggests a file name for saving an email, based on its subject
+func (s *ArchiveService) EMLFilename(ctx context.Context, messageID uint) (string, error) {
+	email, err := s.emailService.GetByID(ctx, messageID)
+	if err != nil {
+		return "", err
+	}
+
+	subject := strings.TrimSpace(stringOrEmpty(email.Message.Subject))
+	if len(subject) > maxEMLFilenameLength {
+		subject = subject[:maxEMLFilenameLength]
+		for !utf8.ValidString(subject) {
+			subject = subject[:len(subject)-1]
+		}
+	}
+	if strings.Trim(subject, ". ") == "" {
+		return fmt.Sprintf("message-%d.eml", messageID), nil
+	}
+	return safeFilename(subject) + ".eml", nil
+}
@@ -0,0 +1,491 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/maildir"
+	"palm/src/formats/mbox"
+	"palm/src/formats/rfc5322"
+	"palm/src/repositories"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultImportBatchSize is the number of messages inserted per transaction
+	DefaultImportBatchSize = 100
+	// exportPageSize is the number of messages loaded at a time when exporting
+	exportPageSize = 100
+)
+
+// ImportOptions controls a mailbox import
+type ImportOptions struct {
+	Format     mbox.Format
+	BatchSize  int   // messages per transaction; DefaultImportBatchSize if zero
+	TotalBytes int64 // size of the input if known, for progress reporting
+	// Progress, if set, is called after every batch
+	Progress func(ImportResult)
+}
+
+// ImportResult counts the messages of an import. It is also reported as
+// progress while the import runs.
+type ImportResult struct {
+	AccountID  uint  `json:"accountId"`
+	Processed  int   `json:"processed"`
+	Imported   int   `json:"imported"`
+	Duplicates int   `json:"duplicates"` // already stored, matched by Message-ID
+	Failed     int   `json:"failed"`     // unreadable or rejected messages
+	BytesRead  int64 `json:"bytesRead"`
+	TotalBytes int64 `json:"totalBytes,omitempty"`
+}
+
+// ArchiveService imports and exports messages as mbox files and maildirs
+type ArchiveService struct {
+	db           *gorm.DB
+	accountRepo  repositories.AccountRepository
+	emailService *EmailService
+	store        *AttachmentStore
+	events       *events.Bus
+}
+
+// NewArchiveService creates a new ArchiveService. Imported attachments are
+// kept in store, which may be nil to record only their names and sizes.
+func NewArchiveService(db *gorm.DB, accountRepo repositories.AccountRepository, emailService *EmailService, store *AttachmentStore) *ArchiveService {
+	config.Logger.Debug().Msg("Initializing archive service")
+	return &ArchiveService{
+		db:           db,
+		accountRepo:  accountRepo,
+		emailService: emailService,
+		store:        store,
+	}
+}
+
+// SetEventBus sets the bus that import progress is published to
+func (s *ArchiveService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+func (s *ArchiveService) account(ctx context.Context, accountID uint) (*entities.Account, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrAccountNotFound) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+// rawMessage is one message read from a mailbox
+type rawMessage struct {
+	data []byte
+	// flags, if set, adjusts the parsed email with state the mailbox keeps
+	// outside the message, such as Maildir flags
+	flags func(*entities.Message)
+}
+
+// ImportMbox streams the messages of an mbox file into an account. Messages
+// whose Message-ID the account already has, or that appeared earlier in the
+// file, are skipped; messages without one are identified by their content.
+// Messages that cannot be parsed or stored are counted as failed without
+// stopping the import.
+func (s *ArchiveService) ImportMbox(ctx context.Context, accountID uint, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	reader := mbox.NewReader(r, opts.Format)
+	next := func() (*rawMessage, error) {
+		msg, err := reader.Next()
+		if err != nil {
+			if err != io.EOF {
+				err = fmt.Errorf("failed to read mbox: %w", err)
+			}
+			return nil, err
+		}
+		return &rawMessage{data: msg.Data}, nil
+	}
+	return s.importMessages(ctx, accountID, "mbox", opts, next, reader.BytesRead)
+}
+
+// ImportMaildir imports the messages of a maildir and its Maildir++
+// subfolders into an account, the same way as ImportMbox. The Maildir flags
+// decide whether messages are read, drafts or flagged.
+func (s *ArchiveService) ImportMaildir(ctx context.Context, accountID uint, path string, opts ImportOptions) (*ImportResult, error) {
+	root, err := maildir.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := maildirEntries(root)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.TotalBytes = 0
+	for _, entry := range entries {
+		opts.TotalBytes += entry.Size
+	}
+
+	var bytesRead int64
+	next := func() (*rawMessage, error) {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		entry := entries[0]
+		entries = entries[1:]
+		bytesRead += entry.Size
+
+		data, err := os.ReadFile(entry.Path)
+		if err != nil {
+			// Moved by another program since it was listed; an empty
+			// message is counted as failed
+			config.Logger.Warn().Err(err).Str("path", entry.Path).Msg("Failed to read maildir message")
+			return &rawMessage{}, nil
+		}
+		return &rawMessage{data: data, flags: func(m *entities.Message) {
+			applyMaildirFlags(m, entry.Flags)
+		}}, nil
+	}
+	return s.importMessages(ctx, accountID, "maildir", opts, next, func() int64 { return bytesRead })
+}
+
+// importMessages stores the messages returned by next until it returns
+// io.EOF. bytesRead reports how far into the input the import is.
+func (s *ArchiveService) importMessages(ctx context.Context, accountID uint, source string, opts ImportOptions, next func() (*rawMessage, error), bytesRead func() int64) (*ImportResult, error) {
+	account, err := s.account(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatchSize
+	}
+
+	config.Logger.Info().
+		Uint("accountID", accountID).
+		Str("source", source).
+		Int64("totalBytes", opts.TotalBytes).
+		Msg("Starting import")
+
+	result := &ImportResult{AccountID: accountID, TotalBytes: opts.TotalBytes}
+	seen := make(map[string]bool)
+	batch := make([]*EmailDTO, 0, opts.BatchSize)
+
+	flush := func() error {
+		if err := s.insertBatch(ctx, batch, result); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		result.BytesRead = bytesRead()
+		s.reportProgress(source, result, opts)
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		raw, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.publishImportFinished(source, result, err)
+			return result, err
+		}
+		result.Processed++
+
+		parsed, err := rfc5322.Parse(bytes.NewReader(raw.data))
+		if err != nil {
+			config.Logger.Warn().
+				Err(err).
+				Str("source", source).
+				Int("index", result.Processed).
+				Msg("Skipping unreadable message")
+			result.Failed++
+			continue
+		}
+
+		if parsed.MessageID == "" {
+			parsed.MessageID = contentMessageID(raw.data)
+		}
+		if seen[parsed.MessageID] {
+			result.Duplicates++
+			continue
+		}
+		seen[parsed.MessageID] = true
+
+		email, err := emailFromMessage(account, parsed, s.store)
+		if err != nil {
+			s.publishImportFinished(source, result, err)
+			return result, err
+		}
+		if raw.flags != nil {
+			raw.flags(email.Message)
+		}
+		email.Raw = raw.data
+		email.Origin = events.OriginImport
+		batch = append(batch, email)
+
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				s.publishImportFinished(source, result, err)
+				return result, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		s.publishImportFinished(source, result, err)
+		return result, err
+	}
+
+	config.Logger.Info().
+		Uint("accountID", accountID).
+		Str("source", source).
+		Int("processed", result.Processed).
+		Int("imported", result.Imported).
+		Int("duplicates", result.Duplicates).
+		Int("failed", result.Failed).
+		Msg("Finished import")
+
+	s.publishImportFinished(source, result, nil)
+	return result, nil
+}
+
+// insertBatch stores the emails that the account does not have yet. If the
+// batch is rejected, its emails are retried one by one so a single bad
+// message does not fail its neighbours.
+func (s *ArchiveService) insertBatch(ctx context.Context, batch []*EmailDTO, result *ImportResult) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	existing, err := s.existingMessageIDs(ctx, batch[0].Message.AccountID, batch)
+	if err != nil {
+		return err
+	}
+	fresh := make([]*EmailDTO, 0, len(batch))
+	for _, email := range batch {
+		if id := email.Message.InternetMessageID; id != nil && existing[*id] {
+			result.Duplicates++
+			continue
+		}
+		fresh = append(fresh, email)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	if err := s.emailService.CreateBatch(ctx, fresh); err == nil {
+		result.Imported += len(fresh)
+		return nil
+	}
+
+	for _, email := range fresh {
+		// A failed batch may have assigned IDs before rolling back
+		resetIDs(email)
+		if err := s.emailService.Create(ctx, email); err != nil {
+			result.Failed++
+			continue
+		}
+		result.Imported++
+	}
+	return nil
+}
+
+// existingMessageIDs returns which of the batch's Message-IDs the account
+// already has
+func (s *ArchiveService) existingMessageIDs(ctx context.Context, accountID uint, batch []*EmailDTO) (map[string]bool, error) {
+	ids := make([]string, 0, len(batch))
+	for _, email := range batch {
+		if email.Message.InternetMessageID != nil {
+			ids = append(ids, *email.Message.InternetMessageID)
+		}
+	}
+	existing := make(map[string]bool)
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	var found []string
+	err := s.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Where("account_id = ? AND internet_message_id IN ?", accountID, ids).
+		Pluck("internet_message_id", &found).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up existing messages: %w", err)
+	}
+	for _, id := range found {
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+// contentMessageID identifies a message that has no Message-ID by its
+// content, so importing the same file twice still finds the duplicate
+func contentMessageID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16]) + "@palm.invalid"
+}
+
+// resetIDs clears primary keys so an email can be inserted again
+func resetIDs(email *EmailDTO) {
+	email.Message.ID = 0
+	for _, recipient := range email.Recipients {
+		recipient.ID = 0
+	}
+	for _, attachment := range email.Attachments {
+		attachment.ID = 0
+	}
+}
+
+func (s *ArchiveService) reportProgress(source string, result *ImportResult, opts ImportOptions) {
+	if opts.Progress != nil {
+		opts.Progress(*result)
+	}
+	s.events.Publish(events.TopicImportProgress, importPayload(source, result, nil))
+}
+
+func (s *ArchiveService) publishImportFinished(source string, result *ImportResult, err error) {
+	s.events.Publish(events.TopicImportFinished, importPayload(source, result, err))
+}
+
+func importPayload(source string, result *ImportResult, err error) events.ImportPayload {
+	payload := events.ImportPayload{
+		AccountID:  result.AccountID,
+		Source:     source,
+		Processed:  result.Processed,
+		Imported:   result.Imported,
+		Duplicates: result.Duplicates,
+		Failed:     result.Failed,
+		BytesRead:  result.BytesRead,
+		TotalBytes: result.TotalBytes,
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	return payload
+}
+
+// ExportMbox writes the messages of an account, oldest first, to w. A
+// non-empty query exports only the messages Search finds for it. It
+// returns the number of messages written.
+func (s *ArchiveService) ExportMbox(ctx context.Context, accountID uint, query string, w io.Writer, format mbox.Format) (int, error) {
+	if _, err := s.account(ctx, accountID); err != nil {
+		return 0, err
+	}
+
+	config.Logger.Info().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Msg("Starting mbox export")
+
+	writer := mbox.NewWriter(w, format)
+	written, err := s.eachEmail(ctx, accountID, query, func(email *EmailDTO) error {
+		raw, err := s.source(ctx, email)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteMessage(email.Message.SenderEmail, messageDate(email.Message), raw); err != nil {
+			return fmt.Errorf("failed to write mbox: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return written, err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return written, fmt.Errorf("failed to write mbox: %w", err)
+	}
+
+	config.Logger.Info().
+		Uint("accountID", accountID).
+		Int("count", written).
+		Msg("Finished mbox export")
+	return written, nil
+}
+
+// ExportMaildir delivers the messages of an account, or those matching
+// query, into the maildir at path, creating it if needed. Read, draft and
+// flagged messages keep that state as Maildir flags.
+func (s *ArchiveService) ExportMaildir(ctx context.Context, accountID uint, query string, path string) (int, error) {
+	if _, err := s.account(ctx, accountID); err != nil {
+		return 0, err
+	}
+	dir, err := maildir.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	config.Logger.Info().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Str("path", path).
+		Msg("Starting maildir export")
+
+	written, err := s.eachEmail(ctx, accountID, query, func(email *EmailDTO) error {
+		raw, err := s.source(ctx, email)
+		if err != nil {
+			return err
+		}
+		_, err = dir.Deliver(raw, maildirFlags(email.Message))
+		return err
+	})
+	if err != nil {
+		return written, err
+	}
+
+	config.Logger.Info().
+		Uint("accountID", accountID).
+		Int("count", written).
+		Msg("Finished maildir export")
+	return written, nil
+}
+
+// eachEmail calls fn for the account's emails oldest first, or for the
+// ones Search finds for a non-empty query. It returns how many emails fn
+// accepted.
+func (s *ArchiveService) eachEmail(ctx context.Context, accountID uint, query string, fn func(*EmailDTO) error) (int, error) {
+	oldestFirst := ListOptions{
+		Filter: EmailFilter{AllFolders: true},
+		Sort:   EmailSort{Field: SortByDate, Order: SortAscending},
+	}
+	count := 0
+	for page := 1; ; page++ {
+		var result *PaginatedEmailsResult
+		var err error
+		if query != "" {
+			result, err = s.emailService.Search(ctx, accountID, query, exportPageSize, page)
+		} else {
+			result, err = s.emailService.ListWithOptions(ctx, accountID, oldestFirst, exportPageSize, page)
+		}
+		if err != nil {
+			return count, err
+		}
+
+		for _, email := range result.Emails {
+			if err := fn(email); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if page >= result.TotalPages {
+			return count, nil
+		}
+	}
+}
+
+// source returns the stored source of an email, or rebuilds it
+func (s *ArchiveService) source(ctx context.Context, email *EmailDTO) ([]byte, error) {
+	raw, err := loadSource(ctx, s.db, email.Message.ID)
+	if errors.Is(err, ErrSourceNotStored) {
+		return reconstructSource(email, s.store)
+	}
+	return raw, err
+}
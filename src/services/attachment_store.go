@@ -0,0 +1,98 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"palm/src/entities"
+	"path/filepath"
+	"strings"
+)
+
+// ErrAttachmentUnavailable is returned when an attachment's content was
+// never stored or its file has been removed
+var ErrAttachmentUnavailable = errors.New("attachment content is not available")
+
+// AttachmentStore keeps attachment contents as files on disk, addressed by
+// their SHA-256 so identical attachments are stored once. A nil store keeps
+// nothing.
+type AttachmentStore struct {
+	dir string
+}
+
+// NewAttachmentStore creates a store rooted at dir
+func NewAttachmentStore(dir string) *AttachmentStore {
+	return &AttachmentStore{dir: dir}
+}
+
+// Save writes data and returns the path to record as the attachment's
+// LocalPath. It returns "" when the store is nil.
+func (s *AttachmentStore) Save(filename string, data []byte) (string, error) {
+	if s == nil {
+		return "", nil
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	dir := filepath.Join(s.dir, hash[:2], hash)
+	path := filepath.Join(dir, safeFilename(filename))
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create attachment directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial
+	// attachment under its final name
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to store attachment: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to store attachment: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to store attachment: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to store attachment: %w", err)
+	}
+	return path, nil
+}
+
+// Read returns the content of a stored attachment
+func (s *AttachmentStore) Read(attachment *entities.Attachment) ([]byte, error) {
+	if attachment.LocalPath == nil || *attachment.LocalPath == "" {
+		return nil, ErrAttachmentUnavailable
+	}
+	data, err := os.ReadFile(*attachment.LocalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrAttachmentUnavailable
+	}
+	return data, err
+}
+
+// safeFilename strips directories and characters that are unsafe in file
+// names on common platforms
+func safeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, ". ")
+	if name == "" {
+		return "attachment"
+	}
+	return name
+}
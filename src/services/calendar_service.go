@@ -0,0 +1,369 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/ical"
+	"palm/src/formats/rfc5322"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrEventNotFound   = errors.New("calendar event not found")
+	ErrNotAnInvitation = errors.New("calendar event does not await a reply")
+	ErrInvalidRSVP     = errors.New("reply must be ACCEPTED, TENTATIVE or DECLINED")
+	ErrOrganizerReply  = errors.New("the organizer of an event cannot reply to it")
+)
+
+// rsvpWording is how each reply reads in its subject and text
+var rsvpWording = map[string]struct{ subject, verb string }{
+	ical.PartStatAccepted:  {"Accepted", "accepted"},
+	ical.PartStatTentative: {"Tentative", "tentatively accepted"},
+	ical.PartStatDeclined:  {"Declined", "declined"},
+}
+
+// CalendarService extracts the events of iCalendar parts, such as meeting
+// invitations, from messages and answers invitations with iTIP replies
+// sent like any other message
+type CalendarService struct {
+	db           *gorm.DB
+	emailService *EmailService
+	sendService  *SendService
+	store        *AttachmentStore
+}
+
+// NewCalendarService creates a new CalendarService. Sources of messages
+// stored without them are rebuilt with the attachments of store.
+func NewCalendarService(db *gorm.DB, emailService *EmailService, sendService *SendService, store *AttachmentStore) *CalendarService {
+	config.Logger.Debug().Msg("Initializing calendar service")
+	return &CalendarService{
+		db:           db,
+		emailService: emailService,
+		sendService:  sendService,
+		store:        store,
+	}
+}
+
+// Subscribe extracts the events of every message created on bus. It
+// returns a function that stops extracting.
+func (s *CalendarService) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		if _, err := s.Extract(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to extract calendar events")
+		}
+	})
+}
+
+// Extract stores the events of a message's iCalendar parts, replacing
+// those stored before. An event sent both inline and as an .ics
+// attachment is stored once; parts that cannot be read are skipped.
+func (s *CalendarService) Extract(ctx context.Context, messageID uint) ([]*entities.CalendarEvent, error) {
+	source, err := emailSource(ctx, s.db, s.emailService, s.store, messageID)
+	if err != nil {
+		return nil, err
+	}
+	m, err := rfc5322.Parse(bytes.NewReader(source.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message %d: %w", messageID, err)
+	}
+
+	var records []*entities.CalendarEvent
+	seen := make(map[string]bool)
+	for _, part := range m.Attachments {
+		if !isCalendarPart(part) {
+			continue
+		}
+		cal, err := ical.Parse(bytes.NewReader(part.Data))
+		if err != nil {
+			config.Logger.Warn().Err(err).Uint("messageID", messageID).Msg("Skipping unreadable calendar part")
+			continue
+		}
+		for _, event := range cal.Events {
+			key := event.UID + "/" + event.RecurrenceID.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			records = append(records, calendarEventRecord(messageID, cal.Method, event))
+		}
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := deleteCalendarEvents(tx, messageID); err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store calendar events: %w", err)
+	}
+
+	if len(records) > 0 {
+		config.Logger.Debug().
+			Uint("messageID", messageID).
+			Int("events", len(records)).
+			Msg("Calendar events extracted")
+	}
+	return records, nil
+}
+
+// Events returns the events of a message with their attendees
+func (s *CalendarService) Events(ctx context.Context, messageID uint) ([]*entities.CalendarEvent, error) {
+	var records []*entities.CalendarEvent
+	err := s.db.WithContext(ctx).
+		Preload("Attendees", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("message_id = ?", messageID).
+		Order("start, id").
+		Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load calendar events: %w", err)
+	}
+	return records, nil
+}
+
+// Respond answers an invitation on behalf of the account that received
+// it: an iTIP REPLY is sent to the organizer through SendService and the
+// answer recorded on the event. partStat is ACCEPTED, TENTATIVE or
+// DECLINED; an invitation may be answered again to change the answer.
+func (s *CalendarService) Respond(ctx context.Context, eventID uint, partStat string) (*SendResult, error) {
+	partStat = strings.ToUpper(strings.TrimSpace(partStat))
+	wording, ok := rsvpWording[partStat]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRSVP, partStat)
+	}
+
+	var record entities.CalendarEvent
+	result := s.db.WithContext(ctx).
+		Preload("Attendees").
+		Preload("Message.Account").
+		Limit(1).
+		Find(&record, eventID)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load calendar event: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrEventNotFound
+	}
+	if record.Method != ical.MethodRequest || record.Status == ical.StatusCancelled || record.OrganizerEmail == "" {
+		return nil, ErrNotAnInvitation
+	}
+	account := record.Message.Account
+	if normalizeEmail(record.OrganizerEmail) == normalizeEmail(account.Email) {
+		return nil, ErrOrganizerReply
+	}
+
+	// Invitations forwarded to the account do not name it; the reply
+	// then adds it as an attendee
+	event := calendarEvent(&record)
+	attendee := event.Attendee(account.Email)
+	if attendee == nil {
+		attendee = &ical.Participant{Email: account.Email, Role: "REQ-PARTICIPANT"}
+	}
+	var calendar bytes.Buffer
+	if err := ical.Write(&calendar, ical.Reply(event, attendee, partStat, time.Now())); err != nil {
+		return nil, fmt.Errorf("failed to write reply: %w", err)
+	}
+
+	name := attendee.Name
+	if name == "" {
+		name = account.Email
+	}
+	summary := record.Summary
+	if summary == "" {
+		summary = "(no title)"
+	}
+	outgoing := &OutgoingEmail{
+		AccountID: account.ID,
+		To:        []*mail.Address{{Name: record.OrganizerName, Address: record.OrganizerEmail}},
+		Subject:   wording.subject + ": " + summary,
+		Text:      fmt.Sprintf("%s has %s this invitation.\n\n%s\n%s\n", name, wording.verb, summary, FormatEventTime(&record, time.Local)),
+		Attachments: []*rfc5322.Attachment{{
+			Filename:    "reply.ics",
+			ContentType: ical.MediaType,
+			Params:      map[string]string{"method": ical.MethodReply, "charset": "utf-8"},
+			Data:        calendar.Bytes(),
+		}},
+	}
+	if id := record.Message.InternetMessageID; id != nil {
+		outgoing.InReplyTo = *id
+	}
+	sent, err := s.sendService.Send(ctx, outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&entities.CalendarEvent{}).
+			Where("id = ?", record.ID).
+			Updates(map[string]interface{}{"response": partStat, "responded_at": now}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&entities.CalendarAttendee{}).
+			Where("calendar_event_id = ? AND LOWER(email) = ?", record.ID, normalizeEmail(account.Email)).
+			Update("part_stat", partStat).Error
+	})
+	if err != nil {
+		// The reply is out; only the local record of it is missing
+		config.Logger.Error().Err(err).Uint("eventID", record.ID).Msg("Failed to record invitation reply")
+	}
+
+	config.Logger.Info().
+		Uint("eventID", record.ID).
+		Str("uid", record.UID).
+		Str("reply", partStat).
+		Msg("Invitation answered")
+	return sent, nil
+}
+
+// FormatEventTime renders when an event takes place in loc, such as
+// "Tue, Mar 3, 2026, 10:00 – 11:00 CET". All-day events show their dates,
+// which belong to no time zone.
+func FormatEventTime(event *entities.CalendarEvent, loc *time.Location) string {
+	const day = "Mon, Jan 2, 2006"
+	if event.AllDay {
+		start := event.Start.UTC()
+		last := event.End.UTC().AddDate(0, 0, -1)
+		switch {
+		case !last.After(start):
+			return start.Format(day)
+		case start.Year() == last.Year():
+			return start.Format("Mon, Jan 2") + " – " + last.Format(day)
+		default:
+			return start.Format(day) + " – " + last.Format(day)
+		}
+	}
+
+	start, end := event.Start.In(loc), event.End.In(loc)
+	switch {
+	case !end.After(start):
+		return start.Format(day + ", 15:04 MST")
+	case start.Year() == end.Year() && start.YearDay() == end.YearDay():
+		return start.Format(day+", 15:04") + " – " + end.Format("15:04 MST")
+	default:
+		return start.Format(day+", 15:04") + " – " + end.Format(day+", 15:04 MST")
+	}
+}
+
+// RecurrenceSummary describes how an event repeats, such as "Every week
+// on Monday", or returns "" for a single event
+func RecurrenceSummary(event *entities.CalendarEvent) string {
+	if event.Recurrence == "" {
+		return ""
+	}
+	rule, err := ical.ParseRecurrence(event.Recurrence)
+	if err != nil {
+		return ""
+	}
+	return rule.Summary()
+}
+
+// isCalendarPart reports whether a message part is an iCalendar object
+func isCalendarPart(part *rfc5322.Attachment) bool {
+	switch part.ContentType {
+	case ical.MediaType, "application/ics":
+		return true
+	}
+	return strings.EqualFold(filepath.Ext(part.Filename), ".ics")
+}
+
+// calendarEventRecord converts a parsed event to its entity
+func calendarEventRecord(messageID uint, method string, event *ical.Event) *entities.CalendarEvent {
+	record := &entities.CalendarEvent{
+		Method:      method,
+		UID:         event.UID,
+		Sequence:    event.Sequence,
+		Status:      event.Status,
+		Summary:     event.Summary,
+		Location:    event.Location,
+		Description: event.Description,
+		Start:       event.Start,
+		End:         event.End,
+		AllDay:      event.AllDay,
+		TimeZone:    event.TimeZone,
+		MessageID:   messageID,
+	}
+	if !event.RecurrenceID.IsZero() {
+		recurrenceID := event.RecurrenceID
+		record.RecurrenceID = &recurrenceID
+	}
+	if event.Recurrence != nil {
+		record.Recurrence = event.Recurrence.String()
+	}
+	if event.Organizer != nil {
+		record.OrganizerEmail = event.Organizer.Email
+		record.OrganizerName = event.Organizer.Name
+	}
+	for _, attendee := range event.Attendees {
+		record.Attendees = append(record.Attendees, entities.CalendarAttendee{
+			Email:    attendee.Email,
+			Name:     attendee.Name,
+			Role:     attendee.Role,
+			PartStat: attendee.PartStat,
+			RSVP:     attendee.RSVP,
+		})
+	}
+	return record
+}
+
+// calendarEvent converts a stored event back to the form replies are
+// built from
+func calendarEvent(record *entities.CalendarEvent) *ical.Event {
+	event := &ical.Event{
+		UID:      record.UID,
+		Sequence: record.Sequence,
+		Summary:  record.Summary,
+		Start:    record.Start,
+		End:      record.End,
+		AllDay:   record.AllDay,
+		Organizer: &ical.Participant{
+			Email: record.OrganizerEmail,
+			Name:  record.OrganizerName,
+		},
+	}
+	if record.RecurrenceID != nil {
+		event.RecurrenceID = *record.RecurrenceID
+	}
+	for _, attendee := range record.Attendees {
+		event.Attendees = append(event.Attendees, &ical.Participant{
+			Email:    attendee.Email,
+			Name:     attendee.Name,
+			Role:     attendee.Role,
+			PartStat: attendee.PartStat,
+			RSVP:     attendee.RSVP,
+		})
+	}
+	return event
+}
+
+// deleteCalendarEvents removes the events of a message with their
+// attendees
+func deleteCalendarEvents(tx *gorm.DB, messageID uint) error {
+	ids := tx.Model(&entities.CalendarEvent{}).Select("id").Where("message_id = ?", messageID)
+	if err := tx.Where("calendar_event_id IN (?)", ids).Delete(&entities.CalendarAttendee{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("message_id = ?", messageID).Delete(&entities.CalendarEvent{}).Error
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/htmltext"
+	"palm/src/formats/rfc5322"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Custom error types
+var (
+	ErrInvalidCategory          = errors.New("unknown category")
+	ErrCategoryOutgoing         = errors.New("sent emails are not categorized")
+	ErrCategoryOverrideNotFound = errors.New("category override not found")
+)
+
+// Categorizer sorts incoming messages into the Primary, Updates,
+// Promotions and Social tabs from their header fields, the phrases they
+// use and whether the user writes to the sender. The user teaches it by
+// moving a message to another tab, which moves every message of the
+// sender there.
+type Categorizer struct {
+	db     *gorm.DB
+	events *events.Bus
+}
+
+// NewCategorizer creates a new Categorizer
+func NewCategorizer(db *gorm.DB) *Categorizer {
+	config.Logger.Debug().Msg("Initializing categorizer")
+	return &Categorizer{db: db}
+}
+
+// SetEventBus sets the bus that categorized messages are published to
+func (c *Categorizer) SetEventBus(bus *events.Bus) {
+	c.events = bus
+}
+
+// Subscribe categorizes every message created on bus. It returns a
+// function that stops categorizing.
+func (c *Categorizer) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		if _, err := c.Categorize(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to categorize message")
+		}
+	})
+}
+
+// Categorize sorts a message into a category and stores it. The user's
+// override for the sender wins over the heuristics. Messages the user
+// sent are left uncategorized and "" is returned for them.
+func (c *Categorizer) Categorize(ctx context.Context, messageID uint) (entities.Category, error) {
+	var message entities.Message
+	found := c.db.WithContext(ctx).Preload("Account").Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return "", fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 || !isIncoming(&message) {
+		return "", nil
+	}
+
+	category, err := c.categoryOf(ctx, &message)
+	if err != nil {
+		return "", err
+	}
+	if category != message.Category {
+		if err := c.store(ctx, &message, category); err != nil {
+			return "", err
+		}
+	}
+	return category, nil
+}
+
+// CategorizeMissing categorizes the incoming messages that have no
+// category yet, such as those stored before the categorizer existed. It
+// returns how many were categorized.
+func (c *Categorizer) CategorizeMissing(ctx context.Context) (int, error) {
+	var ids []uint
+	err := c.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Where("category = '' AND is_draft = ?", false).
+		Where("LOWER(sender_email) <> (SELECT LOWER(email) FROM accounts WHERE accounts.id = messages.account_id)").
+		Order("id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find uncategorized messages: %w", err)
+	}
+
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if _, err := c.Categorize(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// SetCategory moves a message to a category and remembers the choice for
+// its sender, moving the sender's other messages too. It returns how many
+// messages were moved.
+func (c *Categorizer) SetCategory(ctx context.Context, messageID uint, category entities.Category) (int, error) {
+	if !slices.Contains(entities.Categories, category) {
+		return 0, fmt.Errorf("%w %q", ErrInvalidCategory, category)
+	}
+	var message entities.Message
+	found := c.db.WithContext(ctx).Preload("Account").Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return 0, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return 0, ErrEmailNotFound
+	}
+	if !isIncoming(&message) {
+		return 0, ErrCategoryOutgoing
+	}
+
+	override := &entities.CategoryOverride{
+		AccountID:   message.AccountID,
+		SenderEmail: normalizeEmail(message.SenderEmail),
+		Category:    category,
+	}
+	err := c.db.WithContext(ctx).Omit("Account").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "account_id"}, {Name: "sender_email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"category", "updated_at"}),
+	}).Create(override).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to store category override: %w", err)
+	}
+	config.Logger.Info().
+		Uint("accountID", override.AccountID).
+		Str("sender", override.SenderEmail).
+		Str("category", string(category)).
+		Msg("Category override set")
+
+	return c.recategorizeSender(ctx, override.AccountID, override.SenderEmail)
+}
+
+// Overrides returns the categories the user chose for senders of an
+// account, by sender
+func (c *Categorizer) Overrides(ctx context.Context, accountID uint) ([]*entities.CategoryOverride, error) {
+	var overrides []*entities.CategoryOverride
+	err := c.db.WithContext(ctx).
+		Where("account_id = ?", accountID).
+		Order("sender_email").
+		Find(&overrides).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list category overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+// DeleteOverride forgets the category chosen for a sender and sorts the
+// sender's messages by the heuristics again. It returns how many messages
+// were moved.
+func (c *Categorizer) DeleteOverride(ctx context.Context, id uint) (int, error) {
+	var override entities.CategoryOverride
+	found := c.db.WithContext(ctx).Limit(1).Find(&override, id)
+	if found.Error != nil {
+		return 0, fmt.Errorf("failed to load category override: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return 0, ErrCategoryOverrideNotFound
+	}
+	if err := c.db.WithContext(ctx).Delete(&override).Error; err != nil {
+		return 0, fmt.Errorf("failed to delete category override: %w", err)
+	}
+	return c.recategorizeSender(ctx, override.AccountID, override.SenderEmail)
+}
+
+// recategorizeSender categorizes again the messages of a sender
+func (c *Categorizer) recategorizeSender(ctx context.Context, accountID uint, sender string) (int, error) {
+	var messages []*entities.Message
+	err := c.db.WithContext(ctx).
+		Preload("Account").
+		Where("account_id = ? AND LOWER(sender_email) = ?", accountID, sender).
+		Order("id").
+		Find(&messages).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load sender messages: %w", err)
+	}
+
+	moved := 0
+	for _, message := range messages {
+		if !isIncoming(message) {
+			continue
+		}
+		category, err := c.categoryOf(ctx, message)
+		if err != nil {
+			return moved, err
+		}
+		if category == message.Category {
+			continue
+		}
+		if err := c.store(ctx, message, category); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// categoryOf returns the category of an incoming message
+func (c *Categorizer) categoryOf(ctx context.Context, message *entities.Message) (entities.Category, error) {
+	db := c.db.WithContext(ctx)
+	sender := normalizeEmail(message.SenderEmail)
+
+	var override entities.CategoryOverride
+	found := db.Where("account_id = ? AND sender_email = ?", message.AccountID, sender).Limit(1).Find(&override)
+	if found.Error != nil {
+		return "", fmt.Errorf("failed to load category override: %w", found.Error)
+	}
+	if found.RowsAffected > 0 {
+		return override.Category, nil
+	}
+
+	var rows []entities.MessageHeader
+	if err := db.Where("message_id = ?", message.ID).Order("position").Find(&rows).Error; err != nil {
+		return "", fmt.Errorf("failed to load headers: %w", err)
+	}
+	header := make(rfc5322.Header, len(rows))
+	for i, row := range rows {
+		header[i] = rfc5322.Field{Name: row.Name, Value: row.Value}
+	}
+
+	var written int64
+	err := db.Model(&entities.ContactEmail{}).
+		Joins("JOIN contacts ON contacts.id = contact_emails.contact_id AND contacts.deleted_at IS NULL").
+		Where("contact_emails.email = ? AND contacts.sent_count > 0", sender).
+		Count(&written).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to look up sender: %w", err)
+	}
+
+	body := stringOrEmpty(message.Body)
+	if len(body) > 4*maxCategoryText {
+		body = body[:4*maxCategoryText]
+	}
+	return categorize(categoryEvidence{
+		header:        header,
+		sender:        sender,
+		subject:       stringOrEmpty(message.Subject),
+		text:          htmltext.ToText(body),
+		correspondent: written > 0,
+	}), nil
+}
+
+// store sets the category of a message
+func (c *Categorizer) store(ctx context.Context, message *entities.Message, category entities.Category) error {
+	err := c.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Where("id = ?", message.ID).
+		Update("category", category).Error
+	if err != nil {
+		return fmt.Errorf("failed to store category: %w", err)
+	}
+	message.Category = category
+	c.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+	})
+	return nil
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"palm/src/entities"
+	"palm/src/formats/rfc5322"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxCategoryText bounds the body text searched for category phrases
+const maxCategoryText = 8 << 10
+
+// socialDomains are the registrable domains social networks send
+// notifications from
+var socialDomains = map[string]bool{
+	"facebook.com":     true,
+	"facebookmail.com": true,
+	"instagram.com":    true,
+	"linkedin.com":     true,
+	"twitter.com":      true,
+	"x.com":            true,
+	"pinterest.com":    true,
+	"tiktok.com":       true,
+	"reddit.com":       true,
+	"redditmail.com":   true,
+	"tumblr.com":       true,
+	"quora.com":        true,
+	"meetup.com":       true,
+	"nextdoor.com":     true,
+	"snapchat.com":     true,
+	"discord.com":      true,
+	"mastodon.social":  true,
+	"bsky.app":         true,
+	"strava.com":       true,
+	"goodreads.com":    true,
+}
+
+// socialHeaderPrefixes start header fields social networks add
+var socialHeaderPrefixes = []string{"x-facebook-", "x-linkedin-", "x-twitter", "x-pinterest-", "x-reddit-"}
+
+// marketingHeaders are added by bulk mail services, mostly to campaigns
+var marketingHeaders = []string{
+	"x-mailchimp-campaign", "x-mc-user", "x-campaign", "x-campaignid", "x-campaign-id",
+	"x-mailgun-tag", "x-sg-eid", "x-marketo-id", "x-mktg", "x-csa-complaints",
+	"x-sfmc-stack", "x-klaviyo-message", "x-emarsys-identify",
+}
+
+// machineLocalParts are the local parts of addresses no one reads
+var machineLocalParts = []string{
+	"noreply", "no-reply", "no_reply", "donotreply", "do-not-reply",
+	"do_not_reply", "notifications", "notification", "notify", "alerts",
+	"alert", "mailer-daemon", "postmaster", "automated",
+}
+
+// promotionPhrases mark marketing mail, updatePhrases mail about the
+// user's own orders, accounts and appointments
+var (
+	promotionPhrases = []string{
+		"sale", "discount", "coupon", "promo code", "deal", "deals", "offer",
+		"offers", "free shipping", "limited time", "shop now", "buy now",
+		"exclusive", "new arrivals", "black friday", "cyber monday",
+		"clearance", "save up to", "last chance", "don't miss",
+	}
+	updatePhrases = []string{
+		"receipt", "your order", "order confirmation", "invoice", "shipped",
+		"has shipped", "delivery", "delivered", "tracking", "password",
+		"verification code", "verify", "security alert", "sign-in",
+		"statement", "payment", "reminder", "confirm", "confirmation",
+		"your account", "appointment", "reservation", "booking", "itinerary",
+		"renewal", "build failed", "pull request", "mentioned you",
+	}
+	percentOff = regexp.MustCompile(`\d+\s*%\s*off`)
+)
+
+// categoryEvidence is what a message is categorized on
+type categoryEvidence struct {
+	header        rfc5322.Header
+	sender        string // Lower-cased address
+	subject       string
+	text          string // Start of the body as plain text
+	correspondent bool   // The user has written to the sender
+}
+
+// categorize sorts a message into a tab. Social network mail goes to
+// Social, and mail sent in bulk or by machines to Updates or Promotions
+// by the phrases it uses; mail from people, above all those the user
+// writes to, stays in Primary.
+func categorize(e categoryEvidence) entities.Category {
+	if isSocial(e) {
+		return entities.CategorySocial
+	}
+	bulk := isBulk(e.header)
+	automated := isAutomated(e)
+	if !bulk && !automated {
+		return entities.CategoryPrimary
+	}
+	// Automated addresses the user writes to, such as a help desk, are
+	// conversations
+	if e.correspondent && !bulk {
+		return entities.CategoryPrimary
+	}
+	// Discussion lists are read like notifications
+	if e.header.Has("List-Id") && e.header.Has("List-Post") {
+		return entities.CategoryUpdates
+	}
+
+	subject, text := categoryWords(e.subject), categoryWords(e.text)
+	// Subjects say what a message is about more surely than bodies
+	promotion := 2*countPhrases(subject, promotionPhrases) + countPhrases(text, promotionPhrases)
+	update := 2*countPhrases(subject, updatePhrases) + countPhrases(text, updatePhrases)
+	if percentOff.MatchString(subject) {
+		promotion += 2
+	}
+	if percentOff.MatchString(text) {
+		promotion++
+	}
+	switch {
+	case promotion > update:
+		return entities.CategoryPromotions
+	case update > promotion:
+		return entities.CategoryUpdates
+	case hasMarketingHeader(e.header):
+		return entities.CategoryPromotions
+	default:
+		return entities.CategoryUpdates
+	}
+}
+
+// isSocial reports whether a message comes from a social network
+func isSocial(e categoryEvidence) bool {
+	if at := strings.LastIndex(e.sender, "@"); at >= 0 && socialDomains[registrableDomain(e.sender[at+1:])] {
+		return true
+	}
+	for _, field := range e.header {
+		name := strings.ToLower(field.Name)
+		for _, prefix := range socialHeaderPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBulk reports whether a message was sent to a list of recipients
+func isBulk(header rfc5322.Header) bool {
+	if header.Has("List-Id") || header.Has("List-Unsubscribe") {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(header.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		return true
+	}
+	return header.Has("Feedback-ID") || hasMarketingHeader(header)
+}
+
+// hasMarketingHeader reports whether a campaign service sent the message
+func hasMarketingHeader(header rfc5322.Header) bool {
+	for _, name := range marketingHeaders {
+		if header.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAutomated reports whether a machine sent the message (RFC 3834)
+func isAutomated(e categoryEvidence) bool {
+	if value := strings.ToLower(strings.TrimSpace(e.header.Get("Auto-Submitted"))); value != "" && value != "no" {
+		return true
+	}
+	if e.header.Has("X-Auto-Response-Suppress") {
+		return true
+	}
+	local, _, _ := strings.Cut(e.sender, "@")
+	return slices.Contains(machineLocalParts, local)
+}
+
+// categoryWords lower-cases text and reduces it to words separated by
+// single spaces, with a space at each end so phrases match whole words
+func categoryWords(text string) string {
+	if len(text) > maxCategoryText {
+		text = text[:maxCategoryText]
+	}
+	var b strings.Builder
+	b.WriteByte(' ')
+	space := true
+	for _, r := range strings.ToLower(text) {
+		if r == '\'' || r == '%' || r == '-' || ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r > 127 {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	if !space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// countPhrases counts the phrases found in words
+func countPhrases(words string, phrases []string) int {
+	n := 0
+	for _, phrase := range phrases {
+		if strings.Contains(words, " "+phrase+" ") {
+			n++
+		}
+	}
+	return n
+}
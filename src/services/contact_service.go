@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/repositories"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrContactNotFound     = errors.New("contact not found")
+	ErrInvalidContactEmail = errors.New("invalid contact email")
+	ErrEmailAlreadyUsed    = errors.New("email already belongs to another contact")
+)
+
+// RecipientSuggestion is one address offered when composing a message
+type RecipientSuggestion struct {
+	ContactID uint
+	Name      string
+	Email     string
+}
+
+// ContactService maintains the address book derived from messages
+type ContactService struct {
+	db   *gorm.DB
+	repo repositories.ContactRepository
+}
+
+// NewContactService creates a new ContactService
+func NewContactService(db *gorm.DB, repo repositories.ContactRepository) *ContactService {
+	config.Logger.Debug().Msg("Initializing contact service")
+	return &ContactService{db: db, repo: repo}
+}
+
+// Subscribe records the correspondents of every message created on bus.
+// It returns a function that stops recording.
+func (s *ContactService) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		if err := s.RecordMessage(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to record contacts for message")
+		}
+	})
+}
+
+// RecordMessage updates the contacts of the sender and recipients of a
+// message. The account owner's own address is never recorded. When the
+// account owner sent the message its recipients count as sent-to.
+func (s *ContactService) RecordMessage(ctx context.Context, messageID uint) error {
+	var message entities.Message
+	err := s.db.WithContext(ctx).Preload("Account").Preload("Recipients").First(&message, messageID).Error
+	if err != nil {
+		return fmt.Errorf("failed to load message: %w", err)
+	}
+
+	owner := normalizeEmail(message.Account.Email)
+	sent := normalizeEmail(message.SenderEmail) == owner
+
+	at := message.ReceivedDatetime
+	if at == nil {
+		at = message.SentDatetime
+	}
+	if at == nil {
+		at = &message.CreatedAt
+	}
+
+	if !sent {
+		if err := s.recordAddress(ctx, message.SenderEmail, stringOrEmpty(message.SenderName), true, false, *at); err != nil {
+			return err
+		}
+	}
+	for _, recipient := range message.Recipients {
+		if normalizeEmail(recipient.Email) == owner {
+			continue
+		}
+		if err := s.recordAddress(ctx, recipient.Email, stringOrEmpty(recipient.Name), false, sent, *at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordAddress counts one interaction with an address, creating its contact
+// if needed. Names given by the person themselves (as sender) replace
+// names others gave them unless the user edited the name.
+func (s *ContactService) recordAddress(ctx context.Context, email, name string, fromSelf, sentTo bool, at time.Time) error {
+	email = normalizeEmail(email)
+	if !validEmail(email) {
+		return nil
+	}
+	name = NormalizeDisplayName(name, email)
+
+	contact, err := s.repo.GetByEmail(ctx, email)
+	if errors.Is(err, repositories.ErrContactNotFound) {
+		contact = &entities.Contact{
+			MessageCount:    1,
+			LastInteraction: &at,
+			Emails:          []entities.ContactEmail{{Email: email}},
+		}
+		if sentTo {
+			contact.SentCount = 1
+		}
+		if name != "" {
+			contact.DisplayName = &name
+		}
+		return s.repo.Create(ctx, contact).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	contact.MessageCount++
+	if sentTo {
+		contact.SentCount++
+	}
+	if contact.LastInteraction == nil || at.After(*contact.LastInteraction) {
+		contact.LastInteraction = &at
+	}
+	if name != "" && !contact.DisplayNameLocked && (contact.DisplayName == nil || fromSelf) {
+		contact.DisplayName = &name
+	}
+	return s.repo.Update(ctx, contact)
+}
+
+// Rebuild records every existing message, for databases that predate
+// contacts. It does nothing when contacts already exist.
+func (s *ContactService) Rebuild(ctx context.Context) (int, error) {
+	count, err := s.repo.Count(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, nil
+	}
+
+	config.Logger.Info().Msg("Building contacts from existing messages")
+
+	var ids []uint
+	if err := s.db.WithContext(ctx).Model(&entities.Message{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("failed to list messages: %w", err)
+	}
+	for _, id := range ids {
+		if err := s.RecordMessage(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	count, err = s.repo.Count(ctx)
+	config.Logger.Info().Int64("contacts", count).Int("messages", len(ids)).Msg("Contacts built")
+	return int(count), err
+}
+
+// Autocomplete returns up to limit addresses whose contact matches prefix,
+// most frequently contacted first
+func (s *ContactService) Autocomplete(ctx context.Context, prefix string, limit int) ([]RecipientSuggestion, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" || limit < 1 {
+		return []RecipientSuggestion{}, nil
+	}
+
+	contacts, err := s.repo.Search(ctx, prefix, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search contacts: %w", err)
+	}
+
+	lower := strings.ToLower(prefix)
+	suggestions := make([]RecipientSuggestion, 0, limit)
+	for _, contact := range contacts {
+		name := stringOrEmpty(contact.DisplayName)
+		nameMatches := nameHasWordPrefix(name, lower)
+		for _, email := range contact.Emails {
+			if !nameMatches && !strings.HasPrefix(email.Email, lower) {
+				continue
+			}
+			suggestions = append(suggestions, RecipientSuggestion{ContactID: contact.ID, Name: name, Email: email.Email})
+			if len(suggestions) == limit {
+				return suggestions, nil
+			}
+		}
+	}
+	return suggestions, nil
+}
+
+// CreateContact adds a contact by hand
+func (s *ContactService) CreateContact(ctx context.Context, name string, emails []string) (*entities.Contact, error) {
+	contact := &entities.Contact{}
+	for _, email := range emails {
+		email = normalizeEmail(email)
+		if !validEmail(email) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidContactEmail, email)
+		}
+		if _, err := s.repo.GetByEmail(ctx, email); err == nil {
+			return nil, fmt.Errorf("%w: %s", ErrEmailAlreadyUsed, email)
+		}
+		contact.Emails = append(contact.Emails, entities.ContactEmail{Email: email})
+	}
+	if len(contact.Emails) == 0 {
+		return nil, fmt.Errorf("%w: at least one email is required", ErrInvalidContactEmail)
+	}
+	if name = NormalizeDisplayName(name, contact.Emails[0].Email); name != "" {
+		contact.DisplayName = &name
+		contact.DisplayNameLocked = true
+	}
+
+	if err := s.repo.Create(ctx, contact).Error; err != nil {
+		return nil, fmt.Errorf("failed to create contact: %w", err)
+	}
+	return contact, nil
+}
+
+// GetContact returns a contact with its addresses
+func (s *ContactService) GetContact(ctx context.Context, id uint) (*entities.Contact, error) {
+	contact, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repositories.ErrContactNotFound) {
+			return nil, ErrContactNotFound
+		}
+		return nil, err
+	}
+	return contact, nil
+}
+
+// ListContacts returns every contact, most frequently contacted first
+func (s *ContactService) ListContacts(ctx context.Context) ([]*entities.Contact, error) {
+	contacts, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// UpdateContact sets a contact's display name and adds addresses. A manual
+// name is kept even when later messages use a different one; an empty name
+// unlocks it again.
+func (s *ContactService) UpdateContact(ctx context.Context, id uint, name string, addEmails []string) (*entities.Contact, error) {
+	contact, err := s.GetContact(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if name = strings.TrimSpace(name); name != "" {
+		contact.DisplayName = &name
+		contact.DisplayNameLocked = true
+	} else {
+		contact.DisplayNameLocked = false
+	}
+	if err := s.repo.Update(ctx, contact); err != nil {
+		return nil, fmt.Errorf("failed to update contact: %w", err)
+	}
+
+	for _, email := range addEmails {
+		email = normalizeEmail(email)
+		if !validEmail(email) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidContactEmail, email)
+		}
+		if existing, err := s.repo.GetByEmail(ctx, email); err == nil {
+			if existing.ID == contact.ID {
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s", ErrEmailAlreadyUsed, email)
+		}
+		if err := s.repo.AddEmail(ctx, contact.ID, email); err != nil {
+			return nil, fmt.Errorf("failed to add contact email: %w", err)
+		}
+	}
+
+	return s.GetContact(ctx, id)
+}
+
+// MergeContacts folds duplicate contacts into target
+func (s *ContactService) MergeContacts(ctx context.Context, targetID uint, duplicateIDs []uint) (*entities.Contact, error) {
+	sources := make([]uint, 0, len(duplicateIDs))
+	for _, id := range duplicateIDs {
+		if id != targetID {
+			sources = append(sources, id)
+		}
+	}
+	if len(sources) == 0 {
+		return s.GetContact(ctx, targetID)
+	}
+
+	if err := s.repo.Merge(ctx, targetID, sources); err != nil {
+		if errors.Is(err, repositories.ErrContactNotFound) {
+			return nil, ErrContactNotFound
+		}
+		return nil, fmt.Errorf("failed to merge contacts: %w", err)
+	}
+	return s.GetContact(ctx, targetID)
+}
+
+// FindDuplicates groups contacts that share a display name (ignoring case)
+// so they can be offered for merging
+func (s *ContactService) FindDuplicates(ctx context.Context) ([][]*entities.Contact, error) {
+	contacts, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contacts: %w", err)
+	}
+
+	groups := make(map[string][]*entities.Contact)
+	var order []string
+	for _, contact := range contacts {
+		key := strings.ToLower(stringOrEmpty(contact.DisplayName))
+		if key == "" {
+			continue
+		}
+		if _, seen := groups[key]; !seen {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], contact)
+	}
+
+	duplicates := make([][]*entities.Contact, 0)
+	for _, key := range order {
+		if len(groups[key]) > 1 {
+			duplicates = append(duplicates, groups[key])
+		}
+	}
+	return duplicates, nil
+}
+
+// DeleteContact removes a contact and releases its addresses
+func (s *ContactService) DeleteContact(ctx context.Context, id uint) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if errors.Is(err, repositories.ErrContactNotFound) {
+			return ErrContactNotFound
+		}
+		return fmt.Errorf("failed to delete contact: %w", err)
+	}
+	return nil
+}
+
+// normalizeEmail trims and lower-cases an address
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validEmail reports whether email looks like a single mailbox address
+func validEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return at > 0 && at < len(email)-1 && !strings.ContainsAny(email, " <>,;\"")
+}
+
+// NormalizeDisplayName cleans up a display name found in a header: it strips
+// quotes and extra whitespace, turns "Last, First" into "First Last", fixes
+// names written entirely in upper or lower case, and drops names that merely
+// repeat the address.
+func NormalizeDisplayName(name, email string) string {
+	name = strings.Trim(strings.TrimSpace(name), `"'`)
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" || strings.EqualFold(name, email) || strings.Contains(name, "@") {
+		return ""
+	}
+
+	if parts := strings.Split(name, ","); len(parts) == 2 {
+		last, first := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if last != "" && first != "" && !strings.Contains(last, " ") && !strings.Contains(name, ".") {
+			name = first + " " + last
+		}
+	}
+
+	if name == strings.ToUpper(name) || name == strings.ToLower(name) {
+		words := strings.Fields(strings.ToLower(name))
+		for i, word := range words {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+		name = strings.Join(words, " ")
+	}
+	return name
+}
+
+// nameHasWordPrefix reports whether any word of name starts with the
+// lower-case prefix
+func nameHasWordPrefix(name, prefix string) bool {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, prefix) {
+		return true
+	}
+	return strings.Contains(name, " "+prefix)
+}
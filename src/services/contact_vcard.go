@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/formats/vcard"
+	"palm/src/repositories"
+	"strings"
+)
+
+// VCardImportResult summarises an address book import
+type VCardImportResult struct {
+	Created int `json:"created"`
+	Merged  int `json:"merged"`
+	Skipped int `json:"skipped"` // cards without a usable email address
+}
+
+// ImportVCards reads vCard 3.0 or 4.0 entries from r and adds them to the
+// address book. A card is merged into the contacts that already own one of
+// its addresses; when several contacts match they are merged together
+// first. Names from the file replace derived names but never a name the
+// user set by hand.
+func (s *ContactService) ImportVCards(ctx context.Context, r io.Reader) (*VCardImportResult, error) {
+	cards, err := vcard.DecodeAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vCards: %w", err)
+	}
+
+	result := &VCardImportResult{}
+	for _, card := range cards {
+		emails := cardEmails(card)
+		if len(emails) == 0 {
+			result.Skipped++
+			continue
+		}
+
+		merged, err := s.importCard(ctx, card, emails)
+		if err != nil {
+			return result, err
+		}
+		if merged {
+			result.Merged++
+		} else {
+			result.Created++
+		}
+	}
+
+	config.Logger.Info().
+		Int("created", result.Created).
+		Int("merged", result.Merged).
+		Int("skipped", result.Skipped).
+		Msg("Imported vCards")
+	return result, nil
+}
+
+// importCard stores one card and reports whether it matched existing contacts
+func (s *ContactService) importCard(ctx context.Context, card *vcard.Card, emails []string) (bool, error) {
+	var matched []uint
+	seen := make(map[uint]bool)
+	for _, email := range emails {
+		existing, err := s.repo.GetByEmail(ctx, email)
+		if err != nil {
+			if errors.Is(err, repositories.ErrContactNotFound) {
+				continue
+			}
+			return false, fmt.Errorf("failed to look up contact: %w", err)
+		}
+		if !seen[existing.ID] {
+			seen[existing.ID] = true
+			matched = append(matched, existing.ID)
+		}
+	}
+
+	if len(matched) == 0 {
+		contact := &entities.Contact{}
+		for _, email := range emails {
+			contact.Emails = append(contact.Emails, entities.ContactEmail{Email: email})
+		}
+		contact.Phones = cardPhones(card)
+		applyCard(contact, card, emails[0])
+		if err := s.repo.Create(ctx, contact).Error; err != nil {
+			return false, fmt.Errorf("failed to create contact: %w", err)
+		}
+		return false, nil
+	}
+
+	contact, err := s.MergeContacts(ctx, matched[0], matched[1:])
+	if err != nil {
+		return true, err
+	}
+	applyCard(contact, card, emails[0])
+	if err := s.repo.Update(ctx, contact); err != nil {
+		return true, fmt.Errorf("failed to update contact: %w", err)
+	}
+
+	for _, email := range emails {
+		if !hasContactEmail(contact, email) {
+			if err := s.repo.AddEmail(ctx, contact.ID, email); err != nil {
+				return true, fmt.Errorf("failed to add contact email: %w", err)
+			}
+		}
+	}
+	for _, phone := range cardPhones(card) {
+		if !hasContactPhone(contact, phone.Number) {
+			if err := s.repo.AddPhone(ctx, contact.ID, phone.Number, phone.Type); err != nil {
+				return true, fmt.Errorf("failed to add contact phone: %w", err)
+			}
+		}
+	}
+	return true, nil
+}
+
+// ExportVCards writes contacts as vCards of the given version. An empty
+// contactIDs exports the whole address book.
+func (s *ContactService) ExportVCards(ctx context.Context, w io.Writer, contactIDs []uint, version string) (int, error) {
+	var contacts []*entities.Contact
+	if len(contactIDs) == 0 {
+		all, err := s.ListContacts(ctx)
+		if err != nil {
+			return 0, err
+		}
+		contacts = all
+	} else {
+		for _, id := range contactIDs {
+			contact, err := s.GetContact(ctx, id)
+			if err != nil {
+				return 0, err
+			}
+			contacts = append(contacts, contact)
+		}
+	}
+
+	cards := make([]*vcard.Card, 0, len(contacts))
+	for _, contact := range contacts {
+		cards = append(cards, contactToCard(contact))
+	}
+	if err := vcard.EncodeAll(w, version, cards); err != nil {
+		return 0, fmt.Errorf("failed to write vCards: %w", err)
+	}
+	return len(cards), nil
+}
+
+// applyCard copies the card's name, organization, note and photo onto
+// contact without discarding what the user entered
+func applyCard(contact *entities.Contact, card *vcard.Card, email string) {
+	if !contact.DisplayNameLocked {
+		if name := NormalizeDisplayName(card.DisplayName(), email); name != "" {
+			contact.DisplayName = &name
+			contact.DisplayNameLocked = true
+		}
+	}
+	if contact.Organization == nil && card.Organization != "" {
+		organization := card.Organization
+		contact.Organization = &organization
+	}
+	if contact.Note == nil && card.Note != "" {
+		note := card.Note
+		contact.Note = &note
+	}
+	if contact.Photo == nil && card.Photo != nil && len(card.Photo.Data) > 0 {
+		contact.Photo = card.Photo.Data
+		if card.Photo.MediaType != "" {
+			mediaType := card.Photo.MediaType
+			contact.PhotoMediaType = &mediaType
+		}
+	}
+}
+
+// contactToCard converts a stored contact into a vCard
+func contactToCard(contact *entities.Contact) *vcard.Card {
+	card := &vcard.Card{
+		UID:           fmt.Sprintf("palm-contact-%d", contact.ID),
+		FormattedName: stringOrEmpty(contact.DisplayName),
+		Organization:  stringOrEmpty(contact.Organization),
+		Note:          stringOrEmpty(contact.Note),
+	}
+	if card.FormattedName == "" && len(contact.Emails) > 0 {
+		card.FormattedName = contact.Emails[0].Email
+	}
+	if given, family, ok := strings.Cut(stringOrEmpty(contact.DisplayName), " "); ok {
+		card.Name = vcard.Name{Given: given, Family: family}
+	}
+	for i, email := range contact.Emails {
+		card.Emails = append(card.Emails, vcard.TypedValue{Value: email.Email, Preferred: i == 0 && len(contact.Emails) > 1})
+	}
+	for _, phone := range contact.Phones {
+		value := vcard.TypedValue{Value: phone.Number}
+		if phone.Type != "" {
+			value.Types = []string{phone.Type}
+		}
+		card.Phones = append(card.Phones, value)
+	}
+	if len(contact.Photo) > 0 {
+		card.Photo = &vcard.Photo{MediaType: stringOrEmpty(contact.PhotoMediaType), Data: contact.Photo}
+	}
+	return card
+}
+
+// cardEmails returns the card's valid, normalized addresses, preferred first
+func cardEmails(card *vcard.Card) []string {
+	var emails []string
+	seen := make(map[string]bool)
+	add := func(value vcard.TypedValue) {
+		email := normalizeEmail(value.Value)
+		if validEmail(email) && !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+	for _, value := range card.Emails {
+		if value.Preferred {
+			add(value)
+		}
+	}
+	for _, value := range card.Emails {
+		add(value)
+	}
+	return emails
+}
+
+// cardPhones converts the card's phone numbers, keeping the first TYPE that
+// describes the kind of line
+func cardPhones(card *vcard.Card) []entities.ContactPhone {
+	var phones []entities.ContactPhone
+	for _, value := range card.Phones {
+		number := strings.TrimSpace(value.Value)
+		if number == "" {
+			continue
+		}
+		phone := entities.ContactPhone{Number: number}
+		for _, t := range value.Types {
+			if t != "voice" && t != "pref" {
+				phone.Type = t
+				break
+			}
+		}
+		phones = append(phones, phone)
+	}
+	return phones
+}
+
+func hasContactEmail(contact *entities.Contact, email string) bool {
+	for _, existing := range contact.Emails {
+		if existing.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func hasContactPhone(contact *entities.Contact, number string) bool {
+	for _, existing := range contact.Phones {
+		if existing.Number == number {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"palm/src/entities"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrInvalidFilter = errors.New("invalid email filter")
+	ErrInvalidSort   = errors.New("invalid email sort")
+)
+
+// SortField selects the column emails are ordered by
+type SortField string
+
+const (
+	SortByDate    SortField = "date"
+	SortBySender  SortField = "sender"
+	SortBySubject SortField = "subject"
+	SortBySize    SortField = "size"
+)
+
+// SortOrder is the direction emails are ordered in
+type SortOrder string
+
+const (
+	SortAscending  SortOrder = "asc"
+	SortDescending SortOrder = "desc"
+)
+
+// hiddenFolders hold messages kept out of listings that do not ask for a
+// folder: snoozed messages until they wake, and likely spam
+var hiddenFolders = []string{SnoozedFolder, SpamFolder}
+
+// EmailFilter narrows the emails returned by a listing.
+// The zero value matches every email outside the hidden folders.
+type EmailFilter struct {
+	UnreadOnly     bool                // Only unread emails
+	FlaggedOnly    bool                // Only flagged emails
+	Importance     entities.Importance // Only emails with this importance, if set
+	HasAttachments *bool               // With (true) or without (false) attachments, if set
+	Sender         string              // Substring of the sender address or name, if set
+	ReceivedAfter  *time.Time          // Received at or after this time, if set
+	ReceivedBefore *time.Time          // Received before this time, if set
+	Drafts         *bool               // Only drafts (true) or no drafts (false), if set
+	Folder         *string             // Only emails in this folder, "" being the inbox, if set
+	AllFolders     bool                // Include the hidden folders, Snoozed and Spam, when Folder is not set
+	Label          string              // Only emails with this label, if set
+	MailingListID  *uint               // Only emails from this mailing list, if set
+	Category       entities.Category   // Only emails in this category, if set; Primary includes uncategorized emails
+}
+
+// EmailSort orders the emails returned by a listing.
+// The zero value orders by date, newest first.
+type EmailSort struct {
+	Field SortField
+	Order SortOrder
+}
+
+// ListOptions combines the filter and sort applied to a listing
+type ListOptions struct {
+	Filter EmailFilter
+	Sort   EmailSort
+}
+
+// validate checks the options and fills in sort defaults
+func (o *ListOptions) validate() error {
+	f := o.Filter
+	if f.Importance != "" &&
+		f.Importance != entities.ImportanceLow &&
+		f.Importance != entities.ImportanceNormal &&
+		f.Importance != entities.ImportanceHigh {
+		return fmt.Errorf("%w: unknown importance %q", ErrInvalidFilter, f.Importance)
+	}
+	if f.Category != "" && !slices.Contains(entities.Categories, f.Category) {
+		return fmt.Errorf("%w: unknown category %q", ErrInvalidFilter, f.Category)
+	}
+	if f.ReceivedAfter != nil && f.ReceivedBefore != nil && !f.ReceivedAfter.Before(*f.ReceivedBefore) {
+		return fmt.Errorf("%w: received-after must be before received-before", ErrInvalidFilter)
+	}
+	if len(f.Label) > maxLabelLength {
+		return fmt.Errorf("%w: label is too long", ErrInvalidFilter)
+	}
+	if len(f.Sender) > 320 {
+		return fmt.Errorf("%w: sender filter is too long", ErrInvalidFilter)
+	}
+
+	switch o.Sort.Field {
+	case "":
+		o.Sort.Field = SortByDate
+	case SortByDate, SortBySender, SortBySubject, SortBySize:
+	default:
+		return fmt.Errorf("%w: unknown sort field %q", ErrInvalidSort, o.Sort.Field)
+	}
+
+	switch o.Sort.Order {
+	case "":
+		if o.Sort.Field == SortByDate || o.Sort.Field == SortBySize {
+			o.Sort.Order = SortDescending
+		} else {
+			o.Sort.Order = SortAscending
+		}
+	case SortAscending, SortDescending:
+	default:
+		return fmt.Errorf("%w: unknown sort order %q", ErrInvalidSort, o.Sort.Order)
+	}
+
+	return nil
+}
+
+// scope applies the filter to a query on the messages table
+func (f EmailFilter) scope(db *gorm.DB) *gorm.DB {
+	if f.UnreadOnly {
+		db = db.Where("messages.is_read = ?", false)
+	}
+	if f.FlaggedOnly {
+		db = db.Where("messages.is_flagged = ?", true)
+	}
+	if f.Importance != "" {
+		db = db.Where("messages.importance = ?", f.Importance)
+	}
+	if f.HasAttachments != nil {
+		exists := "EXISTS (SELECT 1 FROM attachments WHERE attachments.message_id = messages.id AND attachments.deleted_at IS NULL)"
+		if *f.HasAttachments {
+			db = db.Where(exists)
+		} else {
+			db = db.Where("NOT " + exists)
+		}
+	}
+	if f.Sender != "" {
+		pattern := "%" + escapeLike(strings.ToLower(f.Sender)) + "%"
+		// Parenthesized so the OR cannot escape the account and other
+		// conditions
+		db = db.Where(`(LOWER(messages.sender_email) LIKE ? ESCAPE '\' OR
+			LOWER(COALESCE(messages.sender_name, '')) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if f.ReceivedAfter != nil {
+		db = db.Where("messages.received_datetime >= ?", *f.ReceivedAfter)
+	}
+	if f.ReceivedBefore != nil {
+		db = db.Where("messages.received_datetime < ?", *f.ReceivedBefore)
+	}
+	if f.Drafts != nil {
+		db = db.Where("messages.is_draft = ?", *f.Drafts)
+	}
+	if f.Folder != nil {
+		db = db.Where("messages.folder = ?", *f.Folder)
+	} else if !f.AllFolders {
+		db = db.Where("messages.folder NOT IN ?", hiddenFolders)
+	}
+	if f.MailingListID != nil {
+		db = db.Where("messages.mailing_list_id = ?", *f.MailingListID)
+	}
+	switch f.Category {
+	case "":
+	case entities.CategoryPrimary:
+		db = db.Where("messages.category IN ?", []entities.Category{entities.CategoryPrimary, ""})
+	default:
+		db = db.Where("messages.category = ?", f.Category)
+	}
+	if f.Label != "" {
+		db = db.Where("EXISTS (SELECT 1 FROM message_labels WHERE message_labels.message_id = messages.id AND message_labels.name = ?)", f.Label)
+	}
+	return db
+}
+
+// orderColumns returns the ORDER BY expressions for a validated sort.
+// Every sort ends with the message id so pagination stays stable.
+func (s EmailSort) orderColumns() []string {
+	direction := "DESC"
+	if s.Order == SortAscending {
+		direction = "ASC"
+	}
+
+	switch s.Field {
+	case SortBySender:
+		return []string{
+			"LOWER(COALESCE(NULLIF(messages.sender_name, ''), messages.sender_email)) " + direction,
+			"messages.id " + direction,
+		}
+	case SortBySubject:
+		return []string{
+			"LOWER(COALESCE(messages.subject, '')) " + direction,
+			"messages.id " + direction,
+		}
+	case SortBySize:
+		return []string{
+			`(LENGTH(COALESCE(messages.body, '')) + COALESCE((SELECT SUM(attachments.size) FROM attachments
+				WHERE attachments.message_id = messages.id AND attachments.deleted_at IS NULL), 0)) ` + direction,
+			"messages.id " + direction,
+		}
+	default:
+		return []string{
+			"messages.received_datetime " + direction,
+			"messages.id " + direction,
+		}
+	}
+}
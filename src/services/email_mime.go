@@ -0,0 +1,338 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/formats/htmltext"
+	"palm/src/formats/rfc5322"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// previewLength is the number of characters kept in BodyPreview
+const previewLength = 255
+
+// ParseEmail converts an RFC 5322 message into an email of account, with
+// data as its source, for sources that fetch whole messages. A message
+// without a Message-ID is identified by its content. Attachment contents
+// are written to store; a nil store records only their names and sizes.
+func ParseEmail(account *entities.Account, data []byte, store *AttachmentStore) (*EmailDTO, error) {
+	parsed, err := rfc5322.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEML, err)
+	}
+	if parsed.MessageID == "" {
+		parsed.MessageID = contentMessageID(data)
+	}
+
+	email, err := emailFromMessage(account, parsed, store)
+	if err != nil {
+		return nil, err
+	}
+	email.Raw = data
+	return email, nil
+}
+
+// emailFromMessage converts a parsed message into an email of account.
+// Attachment contents are written to store; a nil store records only their
+// names and sizes.
+func emailFromMessage(account *entities.Account, m *rfc5322.Message, store *AttachmentStore) (*EmailDTO, error) {
+	message := &entities.Message{
+		AccountID:  account.ID,
+		Importance: importanceFromHeader(m.Header),
+		IsRead:     true,
+		IsDraft:    isDraftHeader(m.Header),
+	}
+	if read, known := readFromHeader(m.Header); known {
+		message.IsRead = read
+	}
+
+	subject := m.Subject
+	message.Subject = &subject
+
+	body := m.HTML
+	text := m.Text
+	if body == "" {
+		body = htmltext.FromText(text)
+	}
+	if text == "" {
+		text = htmltext.ToText(m.HTML)
+	}
+	preview := htmltext.Preview(text, previewLength)
+	message.Body = &body
+	message.BodyPreview = &preview
+
+	if m.From != nil {
+		message.SenderEmail = strings.ToLower(m.From.Address)
+		if m.From.Name != "" {
+			name := m.From.Name
+			message.SenderName = &name
+		}
+	}
+
+	if !m.Date.IsZero() {
+		sent := m.Date
+		message.SentDatetime = &sent
+	}
+	if received, ok := receivedDate(m.Header); ok {
+		message.ReceivedDatetime = &received
+	} else {
+		message.ReceivedDatetime = message.SentDatetime
+	}
+
+	if m.MessageID != "" {
+		id := m.MessageID
+		message.InternetMessageID = &id
+	}
+	if conversation := conversationRoot(m); conversation != "" {
+		message.ConversationID = &conversation
+	}
+
+	email := &EmailDTO{Message: message}
+	for _, group := range []struct {
+		list []*mail.Address
+		kind entities.RecipientType
+	}{
+		{m.To, entities.RecipientTypeTo},
+		{m.Cc, entities.RecipientTypeCc},
+		{m.Bcc, entities.RecipientTypeBcc},
+	} {
+		for _, addr := range group.list {
+			email.Recipients = append(email.Recipients, recipientFromAddress(addr, group.kind))
+		}
+	}
+	if len(email.Recipients) == 0 {
+		// Messages sent to undisclosed recipients name nobody; attribute
+		// them to the address they were delivered to
+		delivered := rfc5322.ParseAddressList(m.Header.Get("Delivered-To"))
+		if len(delivered) == 0 {
+			delivered = []*mail.Address{{Address: account.Email}}
+		}
+		email.Recipients = append(email.Recipients, recipientFromAddress(delivered[0], entities.RecipientTypeTo))
+	}
+
+	for _, a := range m.Attachments {
+		filename := a.Filename
+		if filename == "" {
+			filename = defaultAttachmentName(a.ContentType)
+		}
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		attachment := &entities.Attachment{
+			Filename: filename,
+			MimeType: contentType,
+			Size:     uint(len(a.Data)),
+		}
+		path, err := store.Save(filename, a.Data)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			attachment.LocalPath = &path
+		}
+		if a.ContentID != "" {
+			contentID := a.ContentID
+			attachment.ContentID = &contentID
+		}
+		email.Attachments = append(email.Attachments, attachment)
+	}
+
+	return email, nil
+}
+
+// messageDate is the date a message was sent, or else when it arrived
+func messageDate(message *entities.Message) time.Time {
+	switch {
+	case message.SentDatetime != nil:
+		return *message.SentDatetime
+	case message.ReceivedDatetime != nil:
+		return *message.ReceivedDatetime
+	default:
+		return message.CreatedAt
+	}
+}
+
+// messageFromEmail rebuilds an Internet message from a stored email.
+// Attachments whose content is not in store are left out.
+func messageFromEmail(email *EmailDTO, store *AttachmentStore) (*rfc5322.Message, error) {
+	message := email.Message
+	m := &rfc5322.Message{
+		Subject: stringOrEmpty(message.Subject),
+		HTML:    stringOrEmpty(message.Body),
+	}
+	if m.HTML != "" {
+		m.Text = htmltext.ToText(m.HTML)
+	}
+	if message.SenderEmail != "" {
+		m.From = &mail.Address{Name: stringOrEmpty(message.SenderName), Address: message.SenderEmail}
+	}
+	m.Date = messageDate(message)
+
+	if message.InternetMessageID != nil {
+		m.MessageID = *message.InternetMessageID
+	} else {
+		m.MessageID = fmt.Sprintf("%d.%d@palm.invalid", message.AccountID, message.ID)
+	}
+	// Only the root of the thread is stored, which is enough for readers to
+	// thread the message again
+	if root := stringOrEmpty(message.ConversationID); root != "" && root != m.MessageID {
+		m.References = []string{root}
+	}
+
+	for _, recipient := range email.Recipients {
+		addr := &mail.Address{Name: stringOrEmpty(recipient.Name), Address: recipient.Email}
+		switch recipient.RecipientType {
+		case entities.RecipientTypeCc:
+			m.Cc = append(m.Cc, addr)
+		case entities.RecipientTypeBcc:
+			m.Bcc = append(m.Bcc, addr)
+		default:
+			m.To = append(m.To, addr)
+		}
+	}
+
+	switch message.Importance {
+	case entities.ImportanceHigh:
+		m.Header = append(m.Header, rfc5322.Field{Name: "Importance", Value: "high"})
+	case entities.ImportanceLow:
+		m.Header = append(m.Header, rfc5322.Field{Name: "Importance", Value: "low"})
+	}
+	if message.IsRead {
+		m.Header = append(m.Header, rfc5322.Field{Name: "Status", Value: "RO"})
+	} else {
+		m.Header = append(m.Header, rfc5322.Field{Name: "Status", Value: "O"})
+	}
+
+	for _, attachment := range email.Attachments {
+		data, err := store.Read(attachment)
+		if err != nil {
+			if errors.Is(err, ErrAttachmentUnavailable) {
+				config.Logger.Warn().
+					Uint("messageID", message.ID).
+					Str("filename", attachment.Filename).
+					Msg("Attachment content unavailable, leaving it out")
+				continue
+			}
+			return nil, err
+		}
+		contentID := stringOrEmpty(attachment.ContentID)
+		m.Attachments = append(m.Attachments, &rfc5322.Attachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.MimeType,
+			ContentID:   contentID,
+			Inline:      contentID != "",
+			Data:        data,
+		})
+	}
+
+	return m, nil
+}
+
+func recipientFromAddress(addr *mail.Address, kind entities.RecipientType) *entities.Recipient {
+	recipient := &entities.Recipient{Email: strings.ToLower(addr.Address), RecipientType: kind}
+	if addr.Name != "" {
+		name := addr.Name
+		recipient.Name = &name
+	}
+	return recipient
+}
+
+// importanceFromHeader reads the Importance or X-Priority field
+func importanceFromHeader(h rfc5322.Header) entities.Importance {
+	switch strings.ToLower(strings.TrimSpace(h.Get("Importance"))) {
+	case "high":
+		return entities.ImportanceHigh
+	case "low":
+		return entities.ImportanceLow
+	}
+	// X-Priority: 1 (Highest) ... 5 (Lowest)
+	priority := strings.TrimSpace(h.Get("X-Priority"))
+	if priority != "" {
+		if n, err := strconv.Atoi(priority[:1]); err == nil {
+			switch {
+			case n <= 2:
+				return entities.ImportanceHigh
+			case n >= 4:
+				return entities.ImportanceLow
+			}
+		}
+	}
+	return entities.ImportanceNormal
+}
+
+// readFromHeader reads the seen flag that mail stores keep in the message:
+// Status (mbox), X-Mozilla-Status (Thunderbird) and X-Gmail-Labels (Google
+// Takeout)
+func readFromHeader(h rfc5322.Header) (read bool, known bool) {
+	if labels := h.Get("X-Gmail-Labels"); labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			if strings.EqualFold(strings.TrimSpace(label), "Unread") {
+				return false, true
+			}
+		}
+		return true, true
+	}
+	if status := h.Get("X-Mozilla-Status"); status != "" {
+		if flags, err := strconv.ParseUint(strings.TrimSpace(status), 16, 32); err == nil {
+			return flags&0x0001 != 0, true
+		}
+	}
+	if h.Has("Status") {
+		return strings.Contains(h.Get("Status"), "R"), true
+	}
+	return false, false
+}
+
+// isDraftHeader reports whether the message is marked as a draft
+func isDraftHeader(h rfc5322.Header) bool {
+	for _, label := range strings.Split(h.Get("X-Gmail-Labels"), ",") {
+		if strings.EqualFold(strings.TrimSpace(label), "Drafts") || strings.EqualFold(strings.TrimSpace(label), "Draft") {
+			return true
+		}
+	}
+	return strings.Contains(h.Get("X-Status"), "T")
+}
+
+// receivedDate returns the delivery time from the topmost Received field
+func receivedDate(h rfc5322.Header) (time.Time, bool) {
+	received := h.Values("Received")
+	if len(received) == 0 {
+		return time.Time{}, false
+	}
+	i := strings.LastIndex(received[0], ";")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	return rfc5322.ParseDate(received[0][i+1:])
+}
+
+// conversationRoot identifies a thread by the first message it started with
+func conversationRoot(m *rfc5322.Message) string {
+	switch {
+	case len(m.References) > 0:
+		return m.References[0]
+	case m.InReplyTo != "":
+		return m.InReplyTo
+	default:
+		return m.MessageID
+	}
+}
+
+// defaultAttachmentName names an attachment that came without a file name
+func defaultAttachmentName(contentType string) string {
+	if contentType == "message/rfc822" {
+		return "message.eml"
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return "attachment" + exts[0]
+	}
+	return "attachment"
+}
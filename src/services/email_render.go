@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"palm/src/formats/htmlsafe"
+	"palm/src/formats/htmltext"
+	"strconv"
+	"strings"
+)
+
+// BodyScope is the class of the element a rendered body is wrapped in.
+// The body's styles only apply inside it.
+const BodyScope = "palm-message"
+
+// AttachmentURLPrefix is the path under which the webview loads attachment
+// contents, such as inline images, from the application
+const AttachmentURLPrefix = "/palm/attachments/"
+
+// RenderOptions control how the remote content of a body is rendered
+type RenderOptions struct {
+	// AllowRemote loads remote images other than tracking pixels
+	AllowRemote bool
+	// Proxy loads allowed images on behalf of the webview; without one
+	// they stay blocked
+	Proxy *ImageProxy
+}
+
+// RenderedBody is an email body that is safe to show in the webview
+type RenderedBody struct {
+	HTML   string // Sanitized HTML, wrapped in an element of class BodyScope
+	Text   string // Plain text rendering
+	Remote RemoteContent
+}
+
+// RemoteContent summarizes the remote content of a rendered body
+type RemoteContent struct {
+	Blocked        int  // Remote images that were not loaded
+	TrackingPixels int  // Images recognized as trackers, never loaded
+	TrackedLinks   int  // Links through click-tracking redirects
+	Allowed        bool // Remote images other than trackers are loaded
+}
+
+// AttachmentURL returns the URL the webview loads an attachment from
+func AttachmentURL(attachmentID uint) string {
+	return fmt.Sprintf("%s%d", AttachmentURLPrefix, attachmentID)
+}
+
+// ParseAttachmentURL returns the attachment ID of a path built by
+// AttachmentURL
+func ParseAttachmentURL(path string) (uint, bool) {
+	rest, ok := strings.CutPrefix(path, AttachmentURLPrefix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(rest, 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// RenderBody sanitizes the body of an email for display. Scripts, forms
+// and event handlers are removed, its styles are limited to the body and
+// inline images referenced with cid: are loaded from the application.
+// Remote images are blocked unless opts allow them, tracking pixels always
+// are, and tracking parameters are removed from links.
+func RenderBody(email *EmailDTO, opts RenderOptions) RenderedBody {
+	body := stringOrEmpty(email.Message.Body)
+
+	contentIDs := make(map[string]uint)
+	for _, attachment := range email.Attachments {
+		if attachment.ContentID != nil && attachment.LocalPath != nil {
+			contentIDs[*attachment.ContentID] = attachment.ID
+		}
+	}
+
+	remote := RemoteContent{Allowed: opts.AllowRemote && opts.Proxy != nil}
+	html := htmlsafe.Sanitize(body, htmlsafe.Options{
+		Scope: BodyScope,
+		ResolveCID: func(contentID string) (string, bool) {
+			id, ok := contentIDs[contentID]
+			if !ok {
+				return "", false
+			}
+			return AttachmentURL(id), true
+		},
+		RemoteImage: func(image htmlsafe.Image) (string, bool) {
+			switch {
+			case isTrackingPixel(image):
+				remote.TrackingPixels++
+				return "", false
+			case !remote.Allowed:
+				remote.Blocked++
+				return "", false
+			}
+			return opts.Proxy.URL(image.URL.String()), true
+		},
+		Link: func(u *url.URL) string {
+			if isTrackedLink(u) {
+				remote.TrackedLinks++
+			}
+			stripTrackingParameters(u)
+			return u.String()
+		},
+	})
+
+	return RenderedBody{
+		HTML:   html,
+		Text:   htmltext.ToText(body),
+		Remote: remote,
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"palm/src/config"
 	"palm/src/entities"
+	"palm/src/events"
 	"palm/src/repositories"
+	"strings"
 
 	"gorm.io/gorm"
 )
@@ -21,9 +23,14 @@ var (
 
 // EmailDTO represents an email to be created with all its components
 type EmailDTO struct {
-	Message     *entities.Message      // The message entity
-	Recipients  []*entities.Recipient  // List of recipients
-	Attachments []*entities.Attachment // List of attachments (optional)
+	Message        *entities.Message               // The message entity
+	Recipients     []*entities.Recipient           // List of recipients
+	Attachments    []*entities.Attachment          // List of attachments (optional)
+	Raw            []byte                          // RFC 5322 source, stored with its header fields if set (optional)
+	Risk           *entities.MessageRisk           // Phishing analysis, once the message was analyzed (optional)
+	Authentication *entities.MessageAuthentication // Sender authentication, once verified (optional)
+	Labels         []string                        // Labels put on the message, by name (optional)
+	Origin         string                          // How the message arrived, one of events.OriginSync and the like, announced with message:created (optional)
 }
 
 // PaginatedEmailsResult represents the result of a paginated email list operation
@@ -41,6 +48,7 @@ type EmailService struct {
 	messageRepo    repositories.MessageRepository
 	recipientRepo  repositories.RecipientRepository
 	attachmentRepo repositories.AttachmentRepository
+	events         *events.Bus
 }
 
 // NewEmailService creates a new EmailService
@@ -59,6 +67,11 @@ func NewEmailService(
 	}
 }
 
+// SetEventBus sets the bus that message created/deleted events are published to
+func (s *EmailService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
 // validateEmail validates the email data before creation
 func (s *EmailService) validateEmail(email *EmailDTO) error {
 	// Message is required
@@ -109,42 +122,7 @@ func (s *EmailService) Create(ctx context.Context, email *EmailDTO) error {
 
 	// Start a transaction
 	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		// Create the message first to get its ID
-		if err := tx.Create(email.Message).Error; err != nil {
-			config.Logger.Error().
-				Err(err).
-				Uint("accountID", email.Message.AccountID).
-				Msg("Failed to create message")
-			return err
-		}
-
-		// Set the message ID on all recipients and create them
-		for _, recipient := range email.Recipients {
-			recipient.MessageID = email.Message.ID
-			if err := tx.Create(recipient).Error; err != nil {
-				config.Logger.Error().
-					Err(err).
-					Uint("messageID", email.Message.ID).
-					Str("email", recipient.Email).
-					Msg("Failed to create recipient")
-				return err
-			}
-		}
-
-		// Create attachments if any
-		for _, attachment := range email.Attachments {
-			attachment.MessageID = email.Message.ID
-			if err := tx.Create(attachment).Error; err != nil {
-				config.Logger.Error().
-					Err(err).
-					Uint("messageID", email.Message.ID).
-					Str("filename", attachment.Filename).
-					Msg("Failed to create attachment")
-				return err
-			}
-		}
-
-		return nil
+		return s.createInTx(tx, email)
 	})
 
 	if err != nil {
@@ -162,9 +140,107 @@ func (s *EmailService) Create(ctx context.Context, email *EmailDTO) error {
 		Int("attachmentCount", len(email.Attachments)).
 		Msg("Email created successfully")
 
+	s.publishCreated(email)
+
+	return nil
+}
+
+// CreateBatch creates several emails in a single transaction. Either all of
+// them are stored or, on the first failure, none.
+func (s *EmailService) CreateBatch(ctx context.Context, emails []*EmailDTO) error {
+	for i, email := range emails {
+		if err := s.validateEmail(email); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int("index", i).
+				Msg("Email validation failed")
+			return fmt.Errorf("email %d: %w", i, err)
+		}
+	}
+
+	config.Logger.Info().Int("count", len(emails)).Msg("Creating batch of emails")
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, email := range emails {
+			if err := s.createInTx(tx, email); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Int("count", len(emails)).
+			Msg("Email batch creation failed")
+		return fmt.Errorf("%w: %s", ErrEmailCreationFailed, err.Error())
+	}
+
+	for _, email := range emails {
+		s.publishCreated(email)
+	}
 	return nil
 }
 
+// createInTx stores a message with its recipients and attachments
+func (s *EmailService) createInTx(tx *gorm.DB, email *EmailDTO) error {
+	// Create the message first to get its ID
+	if err := tx.Create(email.Message).Error; err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", email.Message.AccountID).
+			Msg("Failed to create message")
+		return err
+	}
+
+	// Set the message ID on all recipients and create them
+	for _, recipient := range email.Recipients {
+		recipient.MessageID = email.Message.ID
+		if err := tx.Create(recipient).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", email.Message.ID).
+				Str("email", recipient.Email).
+				Msg("Failed to create recipient")
+			return err
+		}
+	}
+
+	// Create attachments if any
+	for _, attachment := range email.Attachments {
+		attachment.MessageID = email.Message.ID
+		if err := tx.Create(attachment).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", email.Message.ID).
+				Str("filename", attachment.Filename).
+				Msg("Failed to create attachment")
+			return err
+		}
+	}
+
+	if email.Raw != nil {
+		if err := storeSource(tx, email.Message.ID, email.Raw); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", email.Message.ID).
+				Msg("Failed to store message source")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishCreated announces a stored email on the event bus
+func (s *EmailService) publishCreated(email *EmailDTO) {
+	s.events.Publish(events.TopicMessageCreated, events.MessagePayload{
+		MessageID: email.Message.ID,
+		AccountID: email.Message.AccountID,
+		Origin:    email.Origin,
+	})
+}
+
 // GetByID retrieves an email with all its components by message ID
 func (s *EmailService) GetByID(ctx context.Context, messageID uint) (*EmailDTO, error) {
 	config.Logger.Debug().Uint("messageID", messageID).Msg("Getting email by ID")
@@ -202,10 +278,39 @@ func (s *EmailService) GetByID(ctx context.Context, messageID uint) (*EmailDTO,
 		return nil, err
 	}
 
+	risks, err := byMessageID(ctx, s.db, []uint{messageID}, riskMessageID)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to get message risk")
+		return nil, err
+	}
+	authentications, err := byMessageID(ctx, s.db, []uint{messageID}, authenticationMessageID)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to get message authentication")
+		return nil, err
+	}
+
+	labels, err := messageLabels(ctx, s.db, []uint{messageID})
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("messageID", messageID).
+			Msg("Failed to get message labels")
+		return nil, err
+	}
+
 	email := &EmailDTO{
-		Message:     message,
-		Recipients:  recipients,
-		Attachments: attachments,
+		Message:        message,
+		Recipients:     recipients,
+		Attachments:    attachments,
+		Risk:           risks[messageID],
+		Authentication: authentications[messageID],
+		Labels:         labels[messageID],
 	}
 
 	config.Logger.Debug().
@@ -246,12 +351,20 @@ func (s *EmailService) ListCount(ctx context.Context, accountID uint) (int64, er
 	return totalCount, nil
 }
 
-// List retrieves a paginated list of emails for a specific account
+// List retrieves a paginated list of emails for a specific account, newest first
 func (s *EmailService) List(ctx context.Context, accountID uint, pageSize int, page int) (*PaginatedEmailsResult, error) {
+	return s.ListWithOptions(ctx, accountID, ListOptions{}, pageSize, page)
+}
+
+// ListWithOptions retrieves a paginated list of emails for a specific account
+// matching the filter and ordered by the sort in opts
+func (s *EmailService) ListWithOptions(ctx context.Context, accountID uint, opts ListOptions, pageSize int, page int) (*PaginatedEmailsResult, error) {
 	config.Logger.Debug().
 		Uint("accountID", accountID).
 		Int("pageSize", pageSize).
 		Int("page", page).
+		Str("sortBy", string(opts.Sort.Field)).
+		Str("sortOrder", string(opts.Sort.Order)).
 		Msg("Listing emails for account")
 
 	// Validate page size
@@ -262,61 +375,242 @@ func (s *EmailService) List(ctx context.Context, accountID uint, pageSize int, p
 		return nil, ErrInvalidPageSize
 	}
 
-	// Default page to 1 if not positive
-	if page < 1 {
-		page = 1
+	// Validate filter and sort
+	if err := opts.validate(); err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", accountID).
+			Msg("Invalid list options")
+		return nil, err
 	}
 
-	// Calculate offset for pagination
-	offset := (page - 1) * pageSize
+	scope := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&entities.Message{}).
+			Where("messages.account_id = ?", accountID).
+			Scopes(opts.Filter.scope)
+	}
 
-	var messages []*entities.Message
-	var totalCount int64
+	result, err := s.paginate(ctx, scope, opts.Sort, pageSize, page)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", accountID).
+			Msg("Failed to list messages")
+		return nil, err
+	}
 
-	// Get total count first
-	err := s.db.WithContext(ctx).
-		Model(&entities.Message{}).
-		Where("account_id = ?", accountID).
-		Count(&totalCount).Error
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Int("found", len(result.Emails)).
+		Int64("total", result.TotalCount).
+		Int("page", result.Page).
+		Int("totalPages", result.TotalPages).
+		Msg("Emails listed successfully")
+
+	return result, nil
+}
+
+// Search retrieves a paginated list of emails for a specific account whose
+// subject, body, sender or recipients contain the query (case-insensitive)
+func (s *EmailService) Search(ctx context.Context, accountID uint, query string, pageSize int, page int) (*PaginatedEmailsResult, error) {
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Str("query", query).
+		Int("pageSize", pageSize).
+		Int("page", page).
+		Msg("Searching emails for account")
+
+	if pageSize < 1 || pageSize > 100 {
+		config.Logger.Error().
+			Int("pageSize", pageSize).
+			Msg("Invalid page size")
+		return nil, ErrInvalidPageSize
+	}
+
+	pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+	scope := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&entities.Message{}).
+			Where("account_id = ?", accountID).
+			Where(`LOWER(COALESCE(subject, '')) LIKE ? ESCAPE '\' OR
+				LOWER(COALESCE(body, '')) LIKE ? ESCAPE '\' OR
+				LOWER(sender_email) LIKE ? ESCAPE '\' OR
+				LOWER(COALESCE(sender_name, '')) LIKE ? ESCAPE '\' OR
+				id IN (SELECT message_id FROM recipients
+					WHERE deleted_at IS NULL AND
+					(LOWER(email) LIKE ? ESCAPE '\' OR LOWER(COALESCE(name, '')) LIKE ? ESCAPE '\'))`,
+				pattern, pattern, pattern, pattern, pattern, pattern)
+	}
+
+	result, err := s.paginate(ctx, scope, EmailSort{}, pageSize, page)
 	if err != nil {
 		config.Logger.Error().
 			Err(err).
 			Uint("accountID", accountID).
-			Msg("Failed to count messages")
+			Msg("Failed to search messages")
+		return nil, err
+	}
+
+	config.Logger.Debug().
+		Uint("accountID", accountID).
+		Int("found", len(result.Emails)).
+		Int64("total", result.TotalCount).
+		Msg("Emails searched successfully")
+
+	return result, nil
+}
+
+// ListUnified retrieves a paginated list of emails merged across accounts,
+// newest first, leaving out the hidden folders. An empty accountIDs selects
+// every account. Each message has its Account loaded so callers can tell
+// where it came from.
+func (s *EmailService) ListUnified(ctx context.Context, accountIDs []uint, pageSize int, page int) (*PaginatedEmailsResult, error) {
+	config.Logger.Debug().
+		Interface("accountIDs", accountIDs).
+		Int("pageSize", pageSize).
+		Int("page", page).
+		Msg("Listing unified emails")
+
+	if pageSize < 1 || pageSize > 100 {
+		config.Logger.Error().
+			Int("pageSize", pageSize).
+			Msg("Invalid page size")
+		return nil, ErrInvalidPageSize
+	}
+
+	scope := func(db *gorm.DB) *gorm.DB {
+		db = db.Model(&entities.Message{}).Scopes(EmailFilter{}.scope)
+		if len(accountIDs) > 0 {
+			db = db.Where("account_id IN ?", accountIDs)
+		}
+		return db
+	}
+
+	result, err := s.paginate(ctx, scope, EmailSort{}, pageSize, page, "Account")
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Interface("accountIDs", accountIDs).
+			Msg("Failed to list unified messages")
+		return nil, err
+	}
+
+	config.Logger.Debug().
+		Int("found", len(result.Emails)).
+		Int64("total", result.TotalCount).
+		Msg("Unified emails listed successfully")
+
+	return result, nil
+}
+
+// AccountUnreadCount is the number of unread messages in one account
+type AccountUnreadCount struct {
+	AccountID   uint
+	Email       string
+	AccountType string
+	Unread      int64
+}
+
+// UnreadCounts returns the unread message count of each selected account.
+// An empty accountIDs selects every account; accounts without unread
+// messages are included with a zero count.
+func (s *EmailService) UnreadCounts(ctx context.Context, accountIDs []uint) ([]AccountUnreadCount, error) {
+	config.Logger.Debug().
+		Interface("accountIDs", accountIDs).
+		Msg("Counting unread emails")
+
+	query := s.db.WithContext(ctx).
+		Table("accounts").
+		Select(`accounts.id AS account_id, accounts.email, accounts.account_type,
+			COUNT(messages.id) AS unread`).
+		Joins(`LEFT JOIN messages ON messages.account_id = accounts.id
+			AND messages.is_read = ? AND messages.deleted_at IS NULL
+			AND messages.folder NOT IN ?`, false, hiddenFolders).
+		Where("accounts.deleted_at IS NULL").
+		Group("accounts.id").
+		Order("accounts.id")
+	if len(accountIDs) > 0 {
+		query = query.Where("accounts.id IN ?", accountIDs)
+	}
+
+	var counts []AccountUnreadCount
+	if err := query.Scan(&counts).Error; err != nil {
+		config.Logger.Error().
+			Err(err).
+			Msg("Failed to count unread messages")
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// paginate counts and loads one page of the messages selected by scope in
+// the given order (newest first for the zero EmailSort). Extra associations
+// named in preload are loaded on each message.
+func (s *EmailService) paginate(ctx context.Context, scope func(*gorm.DB) *gorm.DB, sort EmailSort, pageSize int, page int, preload ...string) (*PaginatedEmailsResult, error) {
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * pageSize
+
+	var totalCount int64
+	if err := s.db.WithContext(ctx).Scopes(scope).Count(&totalCount).Error; err != nil {
 		return nil, err
 	}
 
-	// Calculate total pages
 	totalPages := int((totalCount + int64(pageSize) - 1) / int64(pageSize))
 	if totalPages == 0 {
 		totalPages = 1
 	}
 
-	// Get messages with pagination
-	err = s.db.WithContext(ctx).
-		Where("account_id = ?", accountID).
-		Order("received_datetime DESC").
+	query := s.db.WithContext(ctx).Scopes(scope)
+	for _, association := range preload {
+		query = query.Preload(association)
+	}
+
+	for _, column := range sort.orderColumns() {
+		query = query.Order(column)
+	}
+
+	var messages []*entities.Message
+	err := query.
 		Limit(pageSize).
 		Offset(offset).
 		Find(&messages).Error
 	if err != nil {
-		config.Logger.Error().
-			Err(err).
-			Uint("accountID", accountID).
-			Msg("Failed to list messages")
 		return nil, err
 	}
 
-	// Create the result with pagination info
-	result := &PaginatedEmailsResult{
-		Emails:     make([]*EmailDTO, 0, len(messages)),
+	return &PaginatedEmailsResult{
+		Emails:     s.loadEmails(ctx, messages),
 		TotalCount: totalCount,
 		Page:       page,
 		PageSize:   pageSize,
 		TotalPages: totalPages,
+	}, nil
+}
+
+// loadEmails fetches recipients and attachments for each message.
+// Messages whose related entities cannot be loaded are skipped.
+func (s *EmailService) loadEmails(ctx context.Context, messages []*entities.Message) []*EmailDTO {
+	ids := make([]uint, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	// Analyses are shown when available; the messages still are
+	risks, err := byMessageID(ctx, s.db, ids, riskMessageID)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to get message risks")
+	}
+	authentications, err := byMessageID(ctx, s.db, ids, authenticationMessageID)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to get message authentications")
+	}
+	labels, err := messageLabels(ctx, s.db, ids)
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to get message labels")
 	}
 
-	// For each message, fetch related entities and create an EmailDTO
+	emails := make([]*EmailDTO, 0, len(messages))
 	for _, message := range messages {
 		// Get recipients for this message
 		recipients, err := s.recipientRepo.GetByMessageID(ctx, message.ID)
@@ -338,32 +632,78 @@ func (s *EmailService) List(ctx context.Context, accountID uint, pageSize int, p
 			continue
 		}
 
-		// Create an EmailDTO and add it to the result
-		email := &EmailDTO{
-			Message:     message,
-			Recipients:  recipients,
-			Attachments: attachments,
-		}
-		result.Emails = append(result.Emails, email)
+		emails = append(emails, &EmailDTO{
+			Message:        message,
+			Recipients:     recipients,
+			Attachments:    attachments,
+			Risk:           risks[message.ID],
+			Authentication: authentications[message.ID],
+			Labels:         labels[message.ID],
+		})
 	}
+	return emails
+}
 
-	config.Logger.Debug().
-		Uint("accountID", uint(accountID)).
-		Int("found", len(result.Emails)).
-		Uint("total", uint(totalCount)).
-		Int("page", page).
-		Int("totalPages", totalPages).
-		Msg("Emails listed successfully")
+// byMessageID loads the rows of a per-message table, such as message
+// risks, for messages and returns them by message ID. Messages without a
+// row have none; key returns a row's message ID.
+func byMessageID[T any](ctx context.Context, db *gorm.DB, messageIDs []uint, key func(*T) uint) (map[uint]*T, error) {
+	rows := make(map[uint]*T, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return rows, nil
+	}
+	var found []*T
+	if err := db.WithContext(ctx).Where("message_id IN ?", messageIDs).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range found {
+		rows[key(row)] = row
+	}
+	return rows, nil
+}
 
-	return result, nil
+// messageLabels returns the label names of messages by message ID, in
+// alphabetical order
+func messageLabels(ctx context.Context, db *gorm.DB, messageIDs []uint) (map[uint][]string, error) {
+	labels := make(map[uint][]string, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return labels, nil
+	}
+	var rows []*entities.MessageLabel
+	err := db.WithContext(ctx).
+		Where("message_id IN ?", messageIDs).
+		Order("name").
+		Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		labels[row.MessageID] = append(labels[row.MessageID], row.Name)
+	}
+	return labels, nil
 }
 
+// riskMessageID returns the message ID of a risk, for byMessageID
+func riskMessageID(risk *entities.MessageRisk) uint { return risk.MessageID }
+
+// authenticationMessageID returns the message ID of an authentication,
+// for byMessageID
+func authenticationMessageID(auth *entities.MessageAuthentication) uint { return auth.MessageID }
+
 // Delete deletes an email with all its components in a single transaction
 func (s *EmailService) Delete(ctx context.Context, messageID int64) error {
 	config.Logger.Info().Int64("messageID", messageID).Msg("Deleting email")
 
+	var accountID uint
+
 	// Start a transaction
 	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Remember the owning account for the deletion event
+		var message entities.Message
+		if err := tx.Select("account_id").First(&message, messageID).Error; err == nil {
+			accountID = message.AccountID
+		}
+
 		// Delete attachments first (foreign key references)
 		if err := tx.Where("message_id = ?", messageID).Delete(&entities.Attachment{}).Error; err != nil {
 			config.Logger.Error().
@@ -382,6 +722,72 @@ func (s *EmailService) Delete(ctx context.Context, messageID int64) error {
 			return err
 		}
 
+		// The source and its header fields are only useful with the message
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.MessageHeader{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete message headers")
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.MessageSource{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete message source")
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.MessageRisk{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete message risk")
+			return err
+		}
+		// The training a verdict records stays in the spam model
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.SpamVerdict{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete spam verdict")
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.MessageAuthentication{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete message authentication")
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.MessageLabel{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete message labels")
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.Snooze{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete snooze")
+			return err
+		}
+		if err := tx.Where("message_id = ?", messageID).Delete(&entities.FollowUp{}).Error; err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete follow-up")
+			return err
+		}
+		if err := deleteCalendarEvents(tx, uint(messageID)); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Int64("messageID", messageID).
+				Msg("Failed to delete calendar events")
+			return err
+		}
+
 		// Delete the message last
 		result := tx.Delete(&entities.Message{}, messageID)
 		if result.Error != nil {
@@ -417,9 +823,20 @@ func (s *EmailService) Delete(ctx context.Context, messageID int64) error {
 		Int64("messageID", messageID).
 		Msg("Email deleted successfully")
 
+	s.events.Publish(events.TopicMessageDeleted, events.MessagePayload{
+		MessageID: uint(messageID),
+		AccountID: accountID,
+	})
+
 	return nil
 }
 
+// escapeLike escapes the LIKE wildcards in s so it matches literally
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return replacer.Replace(s)
+}
+
 // Helper function to safely return string value from pointer
 func stringOrEmpty(s *string) string {
 	if s == nil {
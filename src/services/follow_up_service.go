@@ -0,0 +1,418 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/rfc5322"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrInvalidFollowUpDelay = errors.New("follow-up delay must be positive")
+	ErrFollowUpIncoming     = errors.New("only emails the user sent can be followed up")
+	ErrFollowUpReplied      = errors.New("email has already been replied to")
+	ErrNoFollowUp           = errors.New("email has no follow-up reminder")
+)
+
+// maxFollowUpWait bounds how long the reminder loop sleeps, so that it
+// notices clock changes and system sleep
+const maxFollowUpWait = time.Hour
+
+// followUpRetryInterval is how long the reminder loop waits after a failure
+const followUpRetryInterval = time.Minute
+
+// FollowUpService reminds the user of messages they sent that no one
+// answered. A message counts as answered once one of its recipients
+// writes back in its thread, found by conversation or by the In-Reply-To
+// and References fields. Run resurfaces unanswered messages in the inbox
+// as unread when their reminder is due.
+type FollowUpService struct {
+	db      *gorm.DB
+	events  *events.Bus
+	changed chan struct{}
+}
+
+// NewFollowUpService creates a new FollowUpService
+func NewFollowUpService(db *gorm.DB) *FollowUpService {
+	config.Logger.Debug().Msg("Initializing follow-up service")
+	return &FollowUpService{db: db, changed: make(chan struct{}, 1)}
+}
+
+// SetEventBus sets the bus that resurfaced messages are published to
+func (s *FollowUpService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// Subscribe drops the reminders of messages answered by each new message
+// and returns a function that removes the subscription
+func (s *FollowUpService) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		if _, err := s.ResolveReplies(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to match reply to follow-ups")
+		}
+	})
+}
+
+// FollowUp reminds the user of a message they sent if no recipient
+// replies within after of sending it. Following up a message again moves
+// its reminder.
+func (s *FollowUpService) FollowUp(ctx context.Context, messageID uint, after time.Duration) (*entities.FollowUp, error) {
+	if after <= 0 {
+		return nil, ErrInvalidFollowUpDelay
+	}
+	message, err := s.loadMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.IsDraft || isIncoming(message) {
+		return nil, ErrFollowUpIncoming
+	}
+	replied, err := s.hasReply(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	if replied {
+		return nil, ErrFollowUpReplied
+	}
+
+	followUp := &entities.FollowUp{MessageID: messageID}
+	found := s.db.WithContext(ctx).Where("message_id = ?", messageID).Limit(1).Find(followUp)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to look up follow-up: %w", found.Error)
+	}
+	followUp.RemindAt = sentTime(message).Add(after).UTC().Truncate(time.Second)
+	if err := s.db.WithContext(ctx).Omit("Message").Save(followUp).Error; err != nil {
+		return nil, fmt.Errorf("failed to store follow-up: %w", err)
+	}
+	config.Logger.Info().
+		Uint("messageID", messageID).
+		Time("remindAt", followUp.RemindAt).
+		Msg("Follow-up set")
+
+	s.notify()
+	return followUp, nil
+}
+
+// Cancel drops the reminder of a message
+func (s *FollowUpService) Cancel(ctx context.Context, messageID uint) error {
+	result := s.db.WithContext(ctx).Where("message_id = ?", messageID).Delete(&entities.FollowUp{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete follow-up: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNoFollowUp
+	}
+	s.notify()
+	return nil
+}
+
+// List returns the messages of an account awaiting a reply, the first
+// reminder first
+func (s *FollowUpService) List(ctx context.Context, accountID uint) ([]*entities.FollowUp, error) {
+	var followUps []*entities.FollowUp
+	err := s.db.WithContext(ctx).
+		Joins("Message").
+		Where("Message.account_id = ?", accountID).
+		Order("follow_ups.remind_at, follow_ups.id").
+		Find(&followUps).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list follow-ups: %w", err)
+	}
+	return followUps, nil
+}
+
+// ResolveReplies drops the reminders of the messages a message answers.
+// It returns how many it dropped.
+func (s *FollowUpService) ResolveReplies(ctx context.Context, messageID uint) (int, error) {
+	reply, err := s.loadMessage(ctx, messageID)
+	if err != nil {
+		if errors.Is(err, ErrEmailNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !isIncoming(reply) {
+		return 0, nil
+	}
+
+	// The messages the reply may answer are those of its thread
+	ids, err := s.threadIDs(ctx, reply)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	var followUps []*entities.FollowUp
+	err = s.db.WithContext(ctx).
+		Joins("Message").
+		Where("Message.account_id = ?", reply.AccountID).
+		Where("Message.internet_message_id IN ? OR Message.conversation_id IN ?", ids, ids).
+		Find(&followUps).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find follow-ups: %w", err)
+	}
+
+	resolved := 0
+	for _, followUp := range followUps {
+		message := &followUp.Message
+		replied, err := s.hasReply(ctx, message)
+		if err != nil {
+			return resolved, err
+		}
+		if !replied {
+			continue
+		}
+		if err := s.db.WithContext(ctx).Delete(followUp).Error; err != nil {
+			return resolved, fmt.Errorf("failed to delete follow-up: %w", err)
+		}
+		config.Logger.Info().
+			Uint("messageID", message.ID).
+			Uint("replyID", reply.ID).
+			Msg("Follow-up answered")
+		resolved++
+	}
+	if resolved > 0 {
+		s.notify()
+	}
+	return resolved, nil
+}
+
+// RemindDue resurfaces the messages whose reminder is due by now and that
+// no one answered. It returns how many it resurfaced.
+func (s *FollowUpService) RemindDue(ctx context.Context, now time.Time) (int, error) {
+	var followUps []*entities.FollowUp
+	err := s.db.WithContext(ctx).
+		Preload("Message").
+		Where("remind_at <= ?", now.UTC()).
+		Order("remind_at, id").
+		Find(&followUps).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due follow-ups: %w", err)
+	}
+
+	reminded := 0
+	for _, followUp := range followUps {
+		if err := ctx.Err(); err != nil {
+			return reminded, err
+		}
+		ok, err := s.remind(ctx, followUp)
+		if err != nil {
+			return reminded, err
+		}
+		if ok {
+			reminded++
+		}
+	}
+	return reminded, nil
+}
+
+// Run resurfaces unanswered messages on time until ctx is cancelled.
+// Reminders due while the app was closed fire at once.
+func (s *FollowUpService) Run(ctx context.Context) {
+	for {
+		wait := maxFollowUpWait
+		if _, err := s.RemindDue(ctx, time.Now()); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			config.Logger.Error().Err(err).Msg("Failed to send follow-up reminders")
+			wait = followUpRetryInterval
+		} else if next, err := s.nextReminder(ctx); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to find next follow-up")
+			wait = followUpRetryInterval
+		} else if next != nil {
+			wait = min(wait, max(time.Until(*next), 0))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// remind deletes a due follow-up and, unless someone answered meanwhile,
+// returns its message to the inbox as unread and announces it
+func (s *FollowUpService) remind(ctx context.Context, followUp *entities.FollowUp) (bool, error) {
+	message := &followUp.Message
+	replied := false
+	if message.ID != 0 {
+		var err error
+		if replied, err = s.hasReply(ctx, message); err != nil {
+			return false, err
+		}
+	}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(followUp).Error; err != nil {
+			return fmt.Errorf("failed to delete follow-up: %w", err)
+		}
+		if message.ID == 0 || replied {
+			return nil
+		}
+		updates := map[string]interface{}{"is_read": false}
+		// Snoozed messages come back when they wake
+		if message.Folder != SnoozedFolder {
+			updates["folder"] = ""
+		}
+		if err := tx.Model(&entities.Message{}).Where("id = ?", message.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to resurface message: %w", err)
+		}
+		return nil
+	})
+	if err != nil || message.ID == 0 || replied {
+		return false, err
+	}
+
+	recipients, err := s.recipients(ctx, message.ID)
+	if err != nil {
+		return false, err
+	}
+	config.Logger.Info().Uint("messageID", message.ID).Msg("Follow-up reminder due")
+
+	s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+	})
+	s.events.Publish(events.TopicFollowUpDue, events.FollowUpPayload{
+		MessageID:  message.ID,
+		AccountID:  message.AccountID,
+		Subject:    stringOrEmpty(message.Subject),
+		Recipients: strings.Join(recipients, ", "),
+	})
+	return true, nil
+}
+
+// hasReply reports whether a recipient of a sent message wrote back in
+// its thread after it was sent
+func (s *FollowUpService) hasReply(ctx context.Context, message *entities.Message) (bool, error) {
+	messageID := stringOrEmpty(message.InternetMessageID)
+	var thread []string
+	for _, id := range []string{messageID, stringOrEmpty(message.ConversationID)} {
+		if id != "" {
+			thread = append(thread, id)
+		}
+	}
+	recipients, err := s.recipients(ctx, message.ID)
+	if err != nil || len(recipients) == 0 || len(thread) == 0 {
+		return false, err
+	}
+
+	db := s.db.WithContext(ctx)
+	// Replies name the message in In-Reply-To or References, or at least
+	// share its conversation
+	inThread := db.Where("messages.conversation_id IN ?", thread)
+	if messageID != "" {
+		inThread = inThread.Or("messages.id IN (?)", db.Model(&entities.MessageHeader{}).
+			Select("message_id").
+			Where("name IN ? AND value LIKE ?", []string{"In-Reply-To", "References"}, "%<"+messageID+">%"))
+	}
+	var replies []entities.Message
+	err = db.
+		Where("messages.account_id = ? AND messages.id <> ? AND messages.is_draft = ?", message.AccountID, message.ID, false).
+		Where("LOWER(messages.sender_email) IN ?", recipients).
+		Where(inThread).
+		Find(&replies).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up replies: %w", err)
+	}
+	sent := sentTime(message)
+	for _, reply := range replies {
+		// Earlier messages of the thread are not replies
+		if reply.ReceivedDatetime == nil || reply.ReceivedDatetime.After(sent) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// threadIDs returns the message IDs naming the thread of a message: its
+// conversation and the messages it answers
+func (s *FollowUpService) threadIDs(ctx context.Context, message *entities.Message) ([]string, error) {
+	var values []string
+	err := s.db.WithContext(ctx).
+		Model(&entities.MessageHeader{}).
+		Where("message_id = ? AND name IN ?", message.ID, []string{"In-Reply-To", "References"}).
+		Pluck("value", &values).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load headers: %w", err)
+	}
+	ids := rfc5322.ParseIDList(strings.Join(values, " "))
+	if conversation := stringOrEmpty(message.ConversationID); conversation != "" {
+		ids = append(ids, conversation)
+	}
+	return ids, nil
+}
+
+// recipients returns the lower-cased addresses a message was sent to
+func (s *FollowUpService) recipients(ctx context.Context, messageID uint) ([]string, error) {
+	var addresses []string
+	err := s.db.WithContext(ctx).
+		Model(&entities.Recipient{}).
+		Where("message_id = ?", messageID).
+		Distinct().
+		Pluck("LOWER(email)", &addresses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recipients: %w", err)
+	}
+	return addresses, nil
+}
+
+// loadMessage returns a message with its account
+func (s *FollowUpService) loadMessage(ctx context.Context, messageID uint) (*entities.Message, error) {
+	var message entities.Message
+	found := s.db.WithContext(ctx).Preload("Account").Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return nil, ErrEmailNotFound
+	}
+	return &message, nil
+}
+
+// nextReminder returns when the next reminder is due, or nil
+func (s *FollowUpService) nextReminder(ctx context.Context) (*time.Time, error) {
+	var followUp entities.FollowUp
+	found := s.db.WithContext(ctx).Order("remind_at").Limit(1).Find(&followUp)
+	if found.Error != nil {
+		return nil, found.Error
+	}
+	if found.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &followUp.RemindAt, nil
+}
+
+// notify tells Run that follow-ups changed
+func (s *FollowUpService) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// sentTime returns when a message was sent, or stored if it has no date
+func sentTime(message *entities.Message) time.Time {
+	if message.SentDatetime != nil {
+		return *message.SentDatetime
+	}
+	return message.CreatedAt
+}
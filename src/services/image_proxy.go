@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"palm/src/config"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Custom error types
+var (
+	// ErrInvalidProxyURL is returned for a proxy URL that ImageProxy.URL
+	// did not build
+	ErrInvalidProxyURL = errors.New("invalid image proxy URL")
+	// ErrNotAnImage is returned when a remote resource is not an image
+	// the webview may display
+	ErrNotAnImage = errors.New("remote resource is not an image")
+	// ErrPrivateAddress is returned when a remote image is on a private
+	// network, which messages must not be able to reach
+	ErrPrivateAddress = errors.New("remote image is on a private network")
+)
+
+const (
+	// RemoteImageURLPrefix is the path under which the webview loads
+	// remote images through the application
+	RemoteImageURLPrefix = "/palm/remote-images/"
+	// DefaultImageCacheTTL is how long a fetched image is served from the
+	// cache
+	DefaultImageCacheTTL = 7 * 24 * time.Hour
+	// maxRemoteImageSize bounds the size of a fetched image
+	maxRemoteImageSize = 10 << 20
+	// proxyUserAgent is sent instead of the webview's user agent
+	proxyUserAgent = "Mozilla/5.0"
+)
+
+// ProxiedImage is a remote image fetched by an ImageProxy
+type ProxiedImage struct {
+	ContentType string
+	Data        []byte
+}
+
+// ImageProxy fetches remote images of messages on behalf of the webview and
+// caches them on disk. Remote hosts see neither the webview's user agent
+// nor its cookies, and an image is fetched once however often the message
+// is opened. Proxy URLs are signed with a key that lives as long as the
+// proxy, so only images the renderer allowed can be fetched.
+type ImageProxy struct {
+	dir    string
+	client *http.Client
+	key    []byte
+	ttl    time.Duration
+}
+
+// NewImageProxy creates a proxy caching images in dir. A nil client uses
+// one that refuses to connect to loopback and private addresses.
+func NewImageProxy(dir string, client *http.Client) *ImageProxy {
+	config.Logger.Debug().Str("dir", dir).Msg("Initializing image proxy")
+	if client == nil {
+		client = publicHTTPClient()
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to generate image proxy key: %v", err))
+	}
+	return &ImageProxy{
+		dir:    dir,
+		client: client,
+		key:    key,
+		ttl:    DefaultImageCacheTTL,
+	}
+}
+
+// URL returns the proxy URL the webview loads a remote image from
+func (p *ImageProxy) URL(remote string) string {
+	return RemoteImageURLPrefix + p.sign(remote) + "?url=" + url.QueryEscape(remote)
+}
+
+// Resolve returns the remote URL of a proxy URL's path and url parameter
+func (p *ImageProxy) Resolve(path, remote string) (string, error) {
+	signature, ok := strings.CutPrefix(path, RemoteImageURLPrefix)
+	if !ok || remote == "" || !hmac.Equal([]byte(signature), []byte(p.sign(remote))) {
+		return "", ErrInvalidProxyURL
+	}
+	return remote, nil
+}
+
+// Fetch returns a remote image from the cache, or fetches and caches it
+func (p *ImageProxy) Fetch(ctx context.Context, remote string) (*ProxiedImage, error) {
+	path := p.cachePath(remote)
+	if image, err := p.cached(path); err == nil {
+		return image, nil
+	}
+
+	u, err := url.Parse(remote)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, ErrInvalidProxyURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", proxyUserAgent)
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch remote image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch remote image: %s", resp.Status)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "image/svg") {
+		return nil, fmt.Errorf("%w: %s", ErrNotAnImage, contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote image: %w", err)
+	}
+	if len(data) > maxRemoteImageSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrNotAnImage, maxRemoteImageSize)
+	}
+
+	image := &ProxiedImage{ContentType: contentType, Data: data}
+	if err := p.store(path, image); err != nil {
+		// The image is still served, only fetched again next time
+		config.Logger.Warn().Err(err).Msg("Failed to cache remote image")
+	}
+	return image, nil
+}
+
+// sign returns the signature of a remote URL
+func (p *ImageProxy) sign(remote string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(remote))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cachePath returns where a remote image is cached
+func (p *ImageProxy) cachePath(remote string) string {
+	sum := sha256.Sum256([]byte(remote))
+	hash := hex.EncodeToString(sum[:])
+	return filepath.Join(p.dir, hash[:2], hash)
+}
+
+// cached reads an image from the cache unless it has expired. Cache files
+// hold the content type on their first line followed by the image.
+func (p *ImageProxy) cached(path string) (*ProxiedImage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(info.ModTime()) > p.ttl {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contentType, body, ok := strings.Cut(string(data), "\n")
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &ProxiedImage{ContentType: contentType, Data: []byte(body)}, nil
+}
+
+// store writes an image to the cache
+func (p *ImageProxy) store(path string, image *ProxiedImage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(image.ContentType + "\n")
+	if err == nil {
+		_, err = tmp.Write(image.Data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// sharedAddressSpace is the range carrier-grade NATs number their
+// customers from (RFC 6598)
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddress reports whether ip may be reachable from the internet.
+// IPv4 addresses mapped into IPv6 are checked as IPv4.
+func isPublicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// publicHTTPClient returns a client that only connects to public addresses,
+// so a message cannot make the application probe the local network
+func publicHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip, err := netip.ParseAddr(host); err != nil || !isPublicAddress(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+		},
+	}
+}
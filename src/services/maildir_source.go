@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/maildir"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoMaildirPath is returned for a Local Maildir account without a directory
+var ErrNoMaildirPath = errors.New("account has no maildir path")
+
+// maildirFile is a message file of a Local Maildir account
+type maildirFile struct {
+	key   string // SourceKey of the message: the folder and the Maildir key
+	entry *maildir.Entry
+}
+
+// syncedMessage is the state of a stored message that came from a maildir
+type syncedMessage struct {
+	ID                uint
+	SourceKey         string
+	InternetMessageID *string
+	IsRead            bool
+	IsDraft           bool
+	IsFlagged         bool
+	DeletedAt         gorm.DeletedAt
+}
+
+// MaildirSource is the MailSource of Local Maildir accounts. Every sync
+// reconciles the account's messages with the files of its maildir and its
+// Maildir++ subfolders: new files are emitted, changed flags are copied and
+// messages whose file is gone are deleted. Messages deleted in Palm are not
+// brought back while their file remains.
+type MaildirSource struct {
+	db           *gorm.DB
+	emailService *EmailService
+	store        *AttachmentStore
+	events       *events.Bus
+
+	// mu keeps a watcher-triggered sync and a manual one from both
+	// emitting the same new files
+	mu sync.Mutex
+}
+
+// NewMaildirSource creates a new MaildirSource. Attachments of new messages
+// are kept in store.
+func NewMaildirSource(db *gorm.DB, emailService *EmailService, store *AttachmentStore) *MaildirSource {
+	config.Logger.Debug().Msg("Initializing maildir source")
+	return &MaildirSource{
+		db:           db,
+		emailService: emailService,
+		store:        store,
+	}
+}
+
+// SetEventBus sets the bus that flag changes read from maildirs are
+// published to
+func (s *MaildirSource) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// Subscribe writes the flags of messages updated on bus back to their
+// maildir file, so other mail tools see what was read in Palm. It returns
+// a function that stops writing.
+func (s *MaildirSource) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageUpdated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		if err := s.WriteFlags(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to write maildir flags")
+		}
+	})
+}
+
+// Fetch implements MailSource
+func (s *MaildirSource) Fetch(ctx context.Context, account *entities.Account, emit func(*EmailDTO) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := accountMaildir(account)
+	if err != nil {
+		return err
+	}
+	files, err := maildirFiles(root)
+	if err != nil {
+		return err
+	}
+	known, err := s.knownMessages(ctx, account.ID)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool, len(files))
+	var added []maildirFile
+	for _, file := range files {
+		present[file.key] = true
+		message, ok := known[file.key]
+		if !ok {
+			added = append(added, file)
+			continue
+		}
+		if message.DeletedAt.Valid {
+			continue
+		}
+		if err := s.updateFlags(ctx, account.ID, message, file.entry.Flags); err != nil {
+			return err
+		}
+	}
+
+	// Messages whose file is gone may have moved to another folder, in
+	// which case a new file carries their Message-ID
+	missing := make(map[string]*syncedMessage)
+	for key, message := range known {
+		if present[key] || message.DeletedAt.Valid {
+			continue
+		}
+		if message.InternetMessageID != nil {
+			missing[*message.InternetMessageID] = message
+		} else {
+			missing[key] = message
+		}
+	}
+
+	for _, file := range added {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		email, err := s.readEmail(account, file)
+		if err != nil {
+			config.Logger.Warn().
+				Err(err).
+				Str("path", file.entry.Path).
+				Msg("Skipping unreadable maildir message")
+			continue
+		}
+
+		if id := email.Message.InternetMessageID; id != nil && missing[*id] != nil {
+			if err := s.moveMessage(ctx, account.ID, missing[*id], file); err != nil {
+				return err
+			}
+			delete(missing, *id)
+			continue
+		}
+		if err := emit(email); err != nil {
+			return err
+		}
+	}
+
+	for _, message := range missing {
+		config.Logger.Debug().
+			Uint("messageID", message.ID).
+			Str("sourceKey", message.SourceKey).
+			Msg("Maildir file removed, deleting message")
+		if err := s.emailService.Delete(ctx, int64(message.ID)); err != nil && !errors.Is(err, ErrEmailNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// knownMessages returns the account's messages that came from its
+// maildir, including deleted ones, by SourceKey
+func (s *MaildirSource) knownMessages(ctx context.Context, accountID uint) (map[string]*syncedMessage, error) {
+	var messages []*syncedMessage
+	err := s.db.WithContext(ctx).
+		Unscoped().
+		Model(&entities.Message{}).
+		Select("id, source_key, internet_message_id, is_read, is_draft, is_flagged, deleted_at").
+		Where("account_id = ? AND source_key IS NOT NULL", accountID).
+		Scan(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load maildir messages: %w", err)
+	}
+
+	known := make(map[string]*syncedMessage, len(messages))
+	for _, message := range messages {
+		known[message.SourceKey] = message
+	}
+	return known, nil
+}
+
+// readEmail parses a new maildir file into an email of account
+func (s *MaildirSource) readEmail(account *entities.Account, file maildirFile) (*EmailDTO, error) {
+	data, err := os.ReadFile(file.entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	email, err := ParseEmail(account, data, s.store)
+	if err != nil {
+		return nil, err
+	}
+	applyMaildirFlags(email.Message, file.entry.Flags)
+	key := file.key
+	email.Message.SourceKey = &key
+	return email, nil
+}
+
+// updateFlags copies changed Maildir flags to a stored message
+func (s *MaildirSource) updateFlags(ctx context.Context, accountID uint, message *syncedMessage, flags maildir.Flags) error {
+	var want entities.Message
+	applyMaildirFlags(&want, flags)
+	if want.IsRead == message.IsRead && want.IsDraft == message.IsDraft && want.IsFlagged == message.IsFlagged {
+		return nil
+	}
+
+	err := s.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Where("id = ?", message.ID).
+		Updates(map[string]interface{}{
+			"is_read":    want.IsRead,
+			"is_draft":   want.IsDraft,
+			"is_flagged": want.IsFlagged,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update message flags: %w", err)
+	}
+	s.publishUpdated(message.ID, accountID)
+	return nil
+}
+
+// moveMessage points a stored message at the file it was moved to
+func (s *MaildirSource) moveMessage(ctx context.Context, accountID uint, message *syncedMessage, file maildirFile) error {
+	config.Logger.Debug().
+		Uint("messageID", message.ID).
+		Str("from", message.SourceKey).
+		Str("to", file.key).
+		Msg("Maildir message moved")
+
+	var want entities.Message
+	applyMaildirFlags(&want, file.entry.Flags)
+	err := s.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Where("id = ?", message.ID).
+		Updates(map[string]interface{}{
+			"source_key": file.key,
+			"is_read":    want.IsRead,
+			"is_draft":   want.IsDraft,
+			"is_flagged": want.IsFlagged,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update moved message: %w", err)
+	}
+	s.publishUpdated(message.ID, accountID)
+	return nil
+}
+
+func (s *MaildirSource) publishUpdated(messageID, accountID uint) {
+	s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: messageID,
+		AccountID: accountID,
+	})
+}
+
+// WriteFlags renames the maildir file of a message so its flags match the
+// message. Messages that did not come from a maildir are left alone.
+func (s *MaildirSource) WriteFlags(ctx context.Context, messageID uint) error {
+	var message entities.Message
+	if err := s.db.WithContext(ctx).Preload("Account").First(&message, messageID).Error; err != nil {
+		return fmt.Errorf("failed to load message: %w", err)
+	}
+	if message.Account.AccountType != entities.AccountTypeMaildir || message.SourceKey == nil {
+		return nil
+	}
+
+	root, err := accountMaildir(&message.Account)
+	if err != nil {
+		return err
+	}
+	dir, key := splitSourceKey(root, *message.SourceKey)
+	entry, err := dir.Lookup(key)
+	if err != nil {
+		// The next sync deletes the message
+		if errors.Is(err, maildir.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	// Keep the flags Palm does not track, such as replied
+	flags := entry.Flags.
+		With(maildir.FlagSeen, message.IsRead).
+		With(maildir.FlagDraft, message.IsDraft).
+		With(maildir.FlagFlagged, message.IsFlagged)
+	if flags == entry.Flags {
+		return nil
+	}
+	_, err = dir.SetFlags(key, flags)
+	return err
+}
+
+// accountMaildir opens the maildir of a Local Maildir account
+func accountMaildir(account *entities.Account) (maildir.Dir, error) {
+	if account.MaildirPath == nil || *account.MaildirPath == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoMaildirPath, account.Email)
+	}
+	return maildir.Open(*account.MaildirPath)
+}
+
+// maildirFiles lists the messages of a maildir and its Maildir++
+// subfolders. The key of a message in a subfolder is prefixed with the
+// folder name, as in ".Sent/1700000000.M1P2Q3.host".
+func maildirFiles(root maildir.Dir) ([]maildirFile, error) {
+	folders, err := root.Folders()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list maildir folders: %w", err)
+	}
+
+	var files []maildirFile
+	for _, folder := range append([]string{""}, folders...) {
+		entries, err := root.Folder(folder).Entries()
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			key := entry.Key
+			if folder != "" {
+				key = folder + "/" + key
+			}
+			files = append(files, maildirFile{key: key, entry: entry})
+		}
+	}
+	return files, nil
+}
+
+// maildirEntries lists the messages of a maildir and its subfolders
+func maildirEntries(root maildir.Dir) ([]*maildir.Entry, error) {
+	files, err := maildirFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*maildir.Entry, len(files))
+	for i, file := range files {
+		entries[i] = file.entry
+	}
+	return entries, nil
+}
+
+// splitSourceKey returns the folder holding a message and its Maildir key
+func splitSourceKey(root maildir.Dir, sourceKey string) (maildir.Dir, string) {
+	if i := strings.LastIndexByte(sourceKey, '/'); i >= 0 {
+		return root.Folder(sourceKey[:i]), sourceKey[i+1:]
+	}
+	return root, sourceKey
+}
+
+// applyMaildirFlags sets the state of a message from its Maildir flags
+func applyMaildirFlags(message *entities.Message, flags maildir.Flags) {
+	message.IsRead = flags.Has(maildir.FlagSeen)
+	message.IsDraft = flags.Has(maildir.FlagDraft)
+	message.IsFlagged = flags.Has(maildir.FlagFlagged)
+}
+
+// maildirFlags returns the Maildir flags for the state of a message
+func maildirFlags(message *entities.Message) maildir.Flags {
+	return maildir.Flags("").
+		With(maildir.FlagSeen, message.IsRead).
+		With(maildir.FlagDraft, message.IsDraft).
+		With(maildir.FlagFlagged, message.IsFlagged)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/repositories"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaildirPollInterval is how often a MaildirWatcher looks for changes
+const DefaultMaildirPollInterval = 5 * time.Second
+
+// MaildirWatcher syncs Local Maildir accounts whenever their directory
+// changes. It compares directory modification times instead of relying on
+// change notifications, which network filesystems do not deliver.
+type MaildirWatcher struct {
+	accountRepo repositories.AccountRepository
+	syncService *SyncService
+	interval    time.Duration
+
+	mu     sync.Mutex
+	stamps map[uint]string
+}
+
+// NewMaildirWatcher creates a watcher that polls every interval
+func NewMaildirWatcher(accountRepo repositories.AccountRepository, syncService *SyncService, interval time.Duration) *MaildirWatcher {
+	config.Logger.Debug().Dur("interval", interval).Msg("Initializing maildir watcher")
+	return &MaildirWatcher{
+		accountRepo: accountRepo,
+		syncService: syncService,
+		interval:    interval,
+		stamps:      make(map[uint]string),
+	}
+}
+
+// Run polls until ctx is cancelled. Every maildir account is synced on the
+// first poll.
+func (w *MaildirWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.Poll(ctx); err != nil && ctx.Err() == nil {
+			config.Logger.Error().Err(err).Msg("Failed to poll maildirs")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll syncs the maildir accounts that changed since the previous poll and
+// returns their results. An account whose sync failed is retried on the
+// next poll.
+func (w *MaildirWatcher) Poll(ctx context.Context) ([]*SyncResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	accounts, err := w.accountRepo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+
+	var results []*SyncResult
+	for _, account := range accounts {
+		if account.AccountType != entities.AccountTypeMaildir {
+			continue
+		}
+
+		// Taken before syncing so changes made during the sync are seen
+		// by the next poll
+		stamp, err := maildirStamp(account)
+		if err != nil {
+			config.Logger.Warn().
+				Err(err).
+				Uint("accountID", account.ID).
+				Msg("Cannot read maildir")
+			delete(w.stamps, account.ID)
+			continue
+		}
+		if w.stamps[account.ID] == stamp {
+			continue
+		}
+
+		result, err := w.syncService.SyncAccount(ctx, account.ID)
+		if err != nil {
+			delete(w.stamps, account.ID)
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+			continue
+		}
+		w.stamps[account.ID] = stamp
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// maildirStamp summarizes the modification times of an account's maildir
+// and its subfolders; it changes whenever a message is added, renamed or
+// removed
+func maildirStamp(account *entities.Account) (string, error) {
+	root, err := accountMaildir(account)
+	if err != nil {
+		return "", err
+	}
+	folders, err := root.Folders()
+	if err != nil {
+		return "", err
+	}
+
+	var stamp strings.Builder
+	for _, folder := range append([]string{""}, folders...) {
+		modTime, err := root.Folder(folder).ModTime()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&stamp, "%s=%d;", folder, modTime.UnixNano())
+	}
+	return stamp.String(), nil
+}
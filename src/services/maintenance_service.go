@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"palm/src/config"
+
+	"gorm.io/gorm"
+)
+
+// DatabaseCheckResult reports the outcome of a database consistency check
+type DatabaseCheckResult struct {
+	OK                   bool     `json:"ok"`
+	MissingTables        []string `json:"missingTables,omitempty"`
+	IntegrityErrors      []string `json:"integrityErrors,omitempty"`
+	ForeignKeyViolations []string `json:"foreignKeyViolations,omitempty"`
+}
+
+// MaintenanceService runs administrative operations on the database
+type MaintenanceService struct {
+	db *gorm.DB
+}
+
+// NewMaintenanceService creates a new MaintenanceService
+func NewMaintenanceService(db *gorm.DB) *MaintenanceService {
+	config.Logger.Debug().Msg("Initializing maintenance service")
+	return &MaintenanceService{db: db}
+}
+
+// Migrate creates or updates the schema for all entities
+func (s *MaintenanceService) Migrate(ctx context.Context) error {
+	config.Logger.Info().Msg("Migrating database")
+
+	if err := config.Migrate(s.db.WithContext(ctx)); err != nil {
+		config.Logger.Error().Err(err).Msg("Database migration failed")
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file, reclaiming unused space
+func (s *MaintenanceService) Vacuum(ctx context.Context) error {
+	config.Logger.Info().Msg("Vacuuming database")
+
+	if err := s.db.WithContext(ctx).Exec("VACUUM").Error; err != nil {
+		config.Logger.Error().Err(err).Msg("Database vacuum failed")
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
+
+// Check verifies that all tables exist and that SQLite reports no integrity
+// or foreign key problems
+func (s *MaintenanceService) Check(ctx context.Context) (*DatabaseCheckResult, error) {
+	config.Logger.Info().Msg("Checking database")
+
+	db := s.db.WithContext(ctx)
+	result := &DatabaseCheckResult{}
+
+	for _, model := range config.Models() {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, fmt.Errorf("failed to parse model: %w", err)
+		}
+		if !db.Migrator().HasTable(stmt.Schema.Table) {
+			result.MissingTables = append(result.MissingTables, stmt.Schema.Table)
+		}
+	}
+
+	var integrity []string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&integrity).Error; err != nil {
+		return nil, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	for _, line := range integrity {
+		if line != "ok" {
+			result.IntegrityErrors = append(result.IntegrityErrors, line)
+		}
+	}
+
+	var violations []struct {
+		Table  string
+		Rowid  int64
+		Parent string
+	}
+	if err := db.Raw("PRAGMA foreign_key_check").Scan(&violations).Error; err != nil {
+		return nil, fmt.Errorf("failed to run foreign key check: %w", err)
+	}
+	for _, v := range violations {
+		result.ForeignKeyViolations = append(result.ForeignKeyViolations,
+			fmt.Sprintf("%s row %d references missing %s", v.Table, v.Rowid, v.Parent))
+	}
+
+	result.OK = len(result.MissingTables) == 0 &&
+		len(result.IntegrityErrors) == 0 &&
+		len(result.ForeignKeyViolations) == 0
+
+	config.Logger.Info().Bool("ok", result.OK).Msg("Database check completed")
+	return result, nil
+}
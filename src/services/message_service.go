@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"palm/src/config"
 	"palm/src/entities"
+	"palm/src/events"
 	"palm/src/repositories"
 )
 
@@ -16,7 +17,8 @@ var (
 )
 
 type MessageService struct {
-	repo repositories.MessageRepository
+	repo   repositories.MessageRepository
+	events *events.Bus
 }
 
 func NewMessageService(repo repositories.MessageRepository) *MessageService {
@@ -24,6 +26,11 @@ func NewMessageService(repo repositories.MessageRepository) *MessageService {
 	return &MessageService{repo: repo}
 }
 
+// SetEventBus sets the bus that message events are published to
+func (s *MessageService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
 // validateImportance validates the importance value is one of the allowed values
 func (s *MessageService) validateImportance(importance entities.Importance) error {
 	config.Logger.Debug().Str("importance", string(importance)).Msg("Validating importance")
@@ -63,6 +70,11 @@ func (s *MessageService) CreateMessage(ctx context.Context, message *entities.Me
 		Uint("accountID", message.AccountID).
 		Msg("Message created successfully")
 
+	s.events.Publish(events.TopicMessageCreated, events.MessagePayload{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+	})
+
 	return nil
 }
 
@@ -108,12 +120,22 @@ func (s *MessageService) UpdateMessage(ctx context.Context, message *entities.Me
 		Uint("messageID", message.ID).
 		Msg("Message updated successfully")
 
+	s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+	})
+
 	return nil
 }
 
 func (s *MessageService) DeleteMessage(ctx context.Context, id uint) error {
 	config.Logger.Info().Uint("id", id).Msg("Deleting message")
 
+	var accountID uint
+	if message, err := s.repo.GetByID(ctx, id); err == nil {
+		accountID = message.AccountID
+	}
+
 	if err := s.repo.Delete(ctx, id); err != nil {
 		config.Logger.Error().
 			Err(err).
@@ -123,5 +145,10 @@ func (s *MessageService) DeleteMessage(ctx context.Context, id uint) error {
 	}
 
 	config.Logger.Info().Uint("id", id).Msg("Message deleted successfully")
+
+	s.events.Publish(events.TopicMessageDeleted, events.MessagePayload{
+		MessageID: id,
+		AccountID: accountID,
+	})
 	return nil
 }
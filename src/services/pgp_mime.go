@@ -0,0 +1,371 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/formats/htmltext"
+	"palm/src/formats/rfc1847"
+	"palm/src/formats/rfc5322"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// OpenPGP/MIME media types of RFC 3156
+const (
+	pgpEncryptedType = "application/pgp-encrypted"
+	pgpSignatureType = "application/pgp-signature"
+	// pgpMicalg names the hash of the signatures Protect makes
+	pgpMicalg = "pgp-sha256"
+)
+
+// ErrNoDecryptionKey is returned when none of the secret keys a message is
+// encrypted to is in the keyring and unlocked
+var ErrNoDecryptionKey = errors.New("no unlocked secret key can decrypt this message")
+
+// SignatureStatus is the outcome of checking an OpenPGP signature
+type SignatureStatus string
+
+const (
+	SignatureNone       SignatureStatus = ""            // The message is not signed
+	SignatureGood       SignatureStatus = "good"        // Signed by a key in the keyring
+	SignatureBad        SignatureStatus = "bad"         // Altered, or the key is expired or revoked
+	SignatureUnknownKey SignatureStatus = "unknown-key" // The signer's key is not in the keyring
+)
+
+// PGPStatus describes the OpenPGP protection of an opened message
+type PGPStatus struct {
+	Encrypted     bool
+	Decrypted     bool
+	DecryptError  error // Why an encrypted message could not be decrypted
+	Signature     SignatureStatus
+	SignerKeyID   string // Upper-case hex; set when the message is signed
+	SignerUserID  string // Primary user ID of the signing key, when known
+	SignerMatches bool   // The signing key belongs to the sender's address
+}
+
+// Open decrypts an email encrypted with OpenPGP/MIME and checks its
+// signature. The body and attachments of a decrypted email are replaced
+// with the decrypted ones in memory only; decrypted attachments are not
+// stored and have no ID. Emails without a stored source are left as they
+// are.
+func (s *PGPService) Open(ctx context.Context, email *EmailDTO) (*PGPStatus, error) {
+	status := &PGPStatus{}
+	raw, err := loadSource(ctx, s.db, email.Message.ID)
+	if err != nil {
+		if errors.Is(err, ErrSourceNotStored) {
+			return status, nil
+		}
+		return nil, err
+	}
+
+	encrypted, err := rfc1847.ParseEncrypted(raw)
+	isPGP := err == nil && encrypted.Protocol == pgpEncryptedType
+	signed, err := rfc1847.ParseSigned(raw)
+	isSigned := err == nil && signed.Protocol == pgpSignatureType
+	if !isPGP && !isSigned {
+		return status, nil
+	}
+
+	keyring, err := s.keyring(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	content := raw
+	if isPGP {
+		status.Encrypted = true
+		plaintext, err := decrypt(keyring, encrypted.Data, status)
+		if err != nil {
+			config.Logger.Debug().Err(err).Uint("messageID", email.Message.ID).Msg("Failed to decrypt message")
+			status.DecryptError = err
+			return status, nil
+		}
+		status.Decrypted = true
+		content = plaintext
+
+		// Signed, then encrypted (RFC 3156 section 6.1)
+		signed, err = rfc1847.ParseSigned(content)
+		isSigned = err == nil && signed.Protocol == pgpSignatureType
+	}
+	if isSigned {
+		verifyDetached(keyring, signed, status)
+		content = signed.Content
+	}
+
+	if status.Signature != SignatureNone {
+		status.SignerMatches = hasAddress(status, keyring, email.Message.SenderEmail)
+	}
+	if status.Decrypted {
+		if err := replaceContent(email, content); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Protect applies OpenPGP/MIME to an outgoing message. It is signed when
+// its sender has a usable secret key and encrypted when every recipient
+// has a key, to the recipients and the sender's own key. Otherwise the
+// message is written as it is.
+func (s *PGPService) Protect(ctx context.Context, m *rfc5322.Message, recipients []string) (*ProtectedMessage, error) {
+	var signer *openpgp.Entity
+	var own []*openpgp.Entity
+	if m.From != nil {
+		keys, err := s.keysFor(ctx, m.From.Address)
+		if err != nil {
+			return nil, err
+		}
+		own = keys
+		for _, key := range keys {
+			if !secretLocked(key) {
+				signer = key
+				break
+			}
+		}
+		if signer == nil && len(keys) > 0 {
+			config.Logger.Warn().Str("from", m.From.Address).Msg("Sender's OpenPGP key is locked, sending unsigned")
+		}
+	}
+
+	to, err := s.encryptionKeys(ctx, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := rfc5322.WriteBody(&body, m); err != nil {
+		return nil, err
+	}
+	content := rfc1847.Canonical(body.Bytes())
+	protected := &ProtectedMessage{}
+	pgpConfig := &packet.Config{DefaultHash: crypto.SHA256}
+
+	var entity []byte
+	switch {
+	case to != nil:
+		for _, key := range own {
+			if _, ok := key.EncryptionKey(pgpConfig.Now()); ok {
+				to = append(to, key)
+				break
+			}
+		}
+		// Signed and encrypted in one OpenPGP message (RFC 3156 section 6.2)
+		ciphertext, err := armored("PGP MESSAGE", func(w io.Writer) error {
+			plaintext, err := openpgp.Encrypt(w, to, signer, nil, pgpConfig)
+			if err != nil {
+				return err
+			}
+			if _, err := plaintext.Write(content); err != nil {
+				return err
+			}
+			return plaintext.Close()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		entity = rfc1847.WriteEncrypted(
+			rfc1847.Part{ContentType: pgpEncryptedType, Data: []byte("Version: 1\r\n")},
+			rfc1847.Part{ContentType: "application/octet-stream", Filename: "encrypted.asc", Data: ciphertext},
+		)
+		protected.Encrypted = true
+		protected.Signed = signer != nil
+	case signer != nil:
+		var signature bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&signature, signer, bytes.NewReader(content), pgpConfig); err != nil {
+			return nil, fmt.Errorf("failed to sign message: %w", err)
+		}
+		entity = rfc1847.WriteSigned(content, pgpMicalg,
+			rfc1847.Part{ContentType: pgpSignatureType, Filename: "signature.asc", Data: signature.Bytes()})
+		protected.Signed = true
+	}
+
+	var raw bytes.Buffer
+	if entity == nil {
+		err = rfc5322.Write(&raw, m)
+	} else {
+		err = rfc5322.WriteWithBody(&raw, m, entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	protected.Raw = raw.Bytes()
+	return protected, nil
+}
+
+// encryptionKeys returns one encryption key per recipient, or nil when a
+// recipient has none
+func (s *PGPService) encryptionKeys(ctx context.Context, recipients []string) ([]*openpgp.Entity, error) {
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+	var to []*openpgp.Entity
+	for _, recipient := range recipients {
+		keys, err := s.keysFor(ctx, recipient)
+		if err != nil {
+			return nil, err
+		}
+		var found *openpgp.Entity
+		for _, key := range keys {
+			if _, ok := key.EncryptionKey(time.Now()); ok {
+				found = key
+				break
+			}
+		}
+		if found == nil {
+			return nil, nil
+		}
+		to = append(to, found)
+	}
+	return to, nil
+}
+
+// CanEncrypt reports whether every recipient has an encryption key
+func (s *PGPService) CanEncrypt(ctx context.Context, recipients []string) (bool, error) {
+	to, err := s.encryptionKeys(ctx, recipients)
+	return to != nil, err
+}
+
+// decrypt decrypts an armored or binary OpenPGP message. A signature
+// inside it (RFC 3156 section 6.2) is checked into status.
+func decrypt(keyring openpgp.EntityList, data []byte, status *PGPStatus) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.Contains(data, []byte(armorBegin)) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OpenPGP message: %w", err)
+		}
+		r = block.Body
+	}
+
+	md, err := openpgp.ReadMessage(r, keyring, nil, nil)
+	if err != nil {
+		if errors.Is(err, pgperrors.ErrKeyIncorrect) {
+			return nil, ErrNoDecryptionKey
+		}
+		return nil, fmt.Errorf("invalid OpenPGP message: %w", err)
+	}
+	plaintext, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil && !md.IsSigned {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	if md.IsSigned {
+		if md.SignatureError == nil && err != nil {
+			md.SignatureError = err
+		}
+		var signer *openpgp.Entity
+		if md.SignedBy != nil {
+			signer = md.SignedBy.Entity
+		}
+		setSignature(status, signer, md.SignatureError)
+		if status.SignerKeyID == "" {
+			status.SignerKeyID = fmt.Sprintf("%016X", md.SignedByKeyId)
+		}
+	}
+	return plaintext, nil
+}
+
+// verifyDetached checks the detached signature of a multipart/signed
+// entity into status
+func verifyDetached(keyring openpgp.EntityList, signed *rfc1847.Signed, status *PGPStatus) {
+	signature := signed.Signature
+	if bytes.Contains(signature, []byte(armorBegin)) {
+		block, err := armor.Decode(bytes.NewReader(signature))
+		if err != nil {
+			setSignature(status, nil, err)
+			return
+		}
+		if signature, err = io.ReadAll(block.Body); err != nil {
+			setSignature(status, nil, err)
+			return
+		}
+	}
+
+	_, signer, err := openpgp.VerifyDetachedSignature(keyring, bytes.NewReader(signed.Content), bytes.NewReader(signature), nil)
+	setSignature(status, signer, err)
+	if signer == nil {
+		// Name the unknown key from the signature packet
+		if p, err := packet.Read(bytes.NewReader(signature)); err == nil {
+			if sig, ok := p.(*packet.Signature); ok && sig.IssuerKeyId != nil {
+				status.SignerKeyID = fmt.Sprintf("%016X", *sig.IssuerKeyId)
+			}
+		}
+	}
+}
+
+// setSignature records the outcome of a signature check
+func setSignature(status *PGPStatus, signer *openpgp.Entity, err error) {
+	switch {
+	case err == nil:
+		status.Signature = SignatureGood
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		status.Signature = SignatureUnknownKey
+	default:
+		status.Signature = SignatureBad
+	}
+	if signer != nil {
+		status.SignerKeyID = fmt.Sprintf("%016X", signer.PrimaryKey.KeyId)
+		if identity := signer.PrimaryIdentity(); identity != nil {
+			status.SignerUserID = identity.Name
+		}
+	}
+}
+
+// hasAddress reports whether the key that signed a message names address
+func hasAddress(status *PGPStatus, keyring openpgp.EntityList, address string) bool {
+	address = normalizeEmail(address)
+	for _, entity := range keyring {
+		if fmt.Sprintf("%016X", entity.PrimaryKey.KeyId) != status.SignerKeyID {
+			continue
+		}
+		for _, identity := range entity.Identities {
+			if normalizeEmail(identity.UserId.Email) == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replaceContent sets the body and attachments of email from a decrypted
+// or unwrapped entity
+func replaceContent(email *EmailDTO, content []byte) error {
+	m, err := rfc5322.Parse(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to read decrypted message: %w", err)
+	}
+
+	body := m.HTML
+	if body == "" {
+		body = htmltext.FromText(m.Text)
+	}
+	email.Message.Body = &body
+
+	email.Attachments = nil
+	for _, a := range m.Attachments {
+		if strings.EqualFold(a.ContentType, pgpSignatureType) {
+			continue
+		}
+		filename := a.Filename
+		if filename == "" {
+			filename = defaultAttachmentName(a.ContentType)
+		}
+		email.Attachments = append(email.Attachments, &entities.Attachment{
+			Filename:  filename,
+			MimeType:  a.ContentType,
+			Size:      uint(len(a.Data)),
+			MessageID: email.Message.ID,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"palm/src/config"
+	"palm/src/entities"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrPGPKeyNotFound  = errors.New("OpenPGP key not found")
+	ErrInvalidPGPKey   = errors.New("invalid OpenPGP key")
+	ErrNoSecretKey     = errors.New("OpenPGP key has no secret part")
+	ErrWrongPassphrase = errors.New("wrong passphrase for OpenPGP key")
+)
+
+// armorBegin starts every ASCII-armored OpenPGP block
+const armorBegin = "-----BEGIN PGP "
+
+// PGPService keeps the local OpenPGP keyring and applies OpenPGP/MIME
+// (RFC 3156) to messages. Secret keys protected by a passphrase must be
+// unlocked once per session before they can decrypt or sign.
+type PGPService struct {
+	db *gorm.DB
+
+	mu       sync.Mutex
+	unlocked map[string]*openpgp.Entity // Secret keys decrypted this session, by fingerprint
+}
+
+// NewPGPService creates a new PGPService
+func NewPGPService(db *gorm.DB) *PGPService {
+	config.Logger.Debug().Msg("Initializing OpenPGP service")
+	return &PGPService{db: db, unlocked: make(map[string]*openpgp.Entity)}
+}
+
+// ImportKeys adds the keys in data, ASCII-armored or binary, to the
+// keyring. Keys already present are updated; a secret key stays when its
+// public key is imported again.
+func (s *PGPService) ImportKeys(ctx context.Context, data []byte) ([]*entities.PGPKey, error) {
+	list, err := readKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*entities.PGPKey
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, entity := range list {
+			key, err := keyFromEntity(entity)
+			if err != nil {
+				return err
+			}
+
+			var existing entities.PGPKey
+			result := tx.Where("fingerprint = ?", key.Fingerprint).Limit(1).Find(&existing)
+			if result.Error != nil {
+				return fmt.Errorf("failed to look up key: %w", result.Error)
+			}
+			if result.RowsAffected > 0 {
+				key.ID = existing.ID
+				key.CreatedAt = existing.CreatedAt
+				if key.Secret == nil {
+					key.Secret = existing.Secret
+				}
+				if err := tx.Where("pgp_key_id = ?", existing.ID).Delete(&entities.PGPKeyEmail{}).Error; err != nil {
+					return fmt.Errorf("failed to update key addresses: %w", err)
+				}
+			}
+			if err := tx.Save(key).Error; err != nil {
+				return fmt.Errorf("failed to save key %s: %w", key.KeyID, err)
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config.Logger.Info().Int("count", len(keys)).Msg("OpenPGP keys imported")
+	return keys, nil
+}
+
+// ExportKey returns a key ASCII-armored: its public part, or its secret
+// part as it was imported when secret is set
+func (s *PGPService) ExportKey(ctx context.Context, fingerprint string, secret bool) (string, error) {
+	key, err := s.key(ctx, fingerprint)
+	if err != nil {
+		return "", err
+	}
+
+	blockType, data := openpgp.PublicKeyType, key.Public
+	if secret {
+		if key.Secret == nil {
+			return "", ErrNoSecretKey
+		}
+		blockType, data = openpgp.PrivateKeyType, key.Secret
+	}
+
+	out, err := armored(blockType, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to export key: %w", err)
+	}
+	return string(out), nil
+}
+
+// ListKeys returns every key in the keyring with its addresses
+func (s *PGPService) ListKeys(ctx context.Context) ([]*entities.PGPKey, error) {
+	var keys []*entities.PGPKey
+	err := s.db.WithContext(ctx).Preload("Emails").Order("user_id, fingerprint").Find(&keys).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	return keys, nil
+}
+
+// DeleteKey removes a key from the keyring
+func (s *PGPService) DeleteKey(ctx context.Context, fingerprint string) error {
+	key, err := s.key(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("pgp_key_id = ?", key.ID).Delete(&entities.PGPKeyEmail{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(key).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.unlocked, key.Fingerprint)
+	s.mu.Unlock()
+
+	config.Logger.Info().Str("fingerprint", key.Fingerprint).Msg("OpenPGP key deleted")
+	return nil
+}
+
+// Unlock decrypts a secret key with its passphrase for the rest of the
+// session
+func (s *PGPService) Unlock(ctx context.Context, fingerprint string, passphrase string) error {
+	key, err := s.key(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	if key.Secret == nil {
+		return ErrNoSecretKey
+	}
+
+	entity, err := readEntity(key.Secret)
+	if err != nil {
+		return err
+	}
+	if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+		return ErrWrongPassphrase
+	}
+
+	s.mu.Lock()
+	s.unlocked[key.Fingerprint] = entity
+	s.mu.Unlock()
+	return nil
+}
+
+// IsLocked reports whether key has a secret part that needs its
+// passphrase before it can decrypt or sign
+func (s *PGPService) IsLocked(key *entities.PGPKey) bool {
+	if key.Secret == nil {
+		return false
+	}
+	s.mu.Lock()
+	_, unlocked := s.unlocked[key.Fingerprint]
+	s.mu.Unlock()
+	if unlocked {
+		return false
+	}
+	entity, err := readEntity(key.Secret)
+	return err != nil || secretLocked(entity)
+}
+
+// key loads a key by fingerprint, given in any case and with or without
+// spaces
+func (s *PGPService) key(ctx context.Context, fingerprint string) (*entities.PGPKey, error) {
+	fingerprint = strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+	var key entities.PGPKey
+	err := s.db.WithContext(ctx).Preload("Emails").Where("fingerprint = ?", fingerprint).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPGPKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to load key: %w", err)
+	}
+	return &key, nil
+}
+
+// keyring returns the public keys of the keyring together with the secret
+// keys that are usable: unprotected or unlocked
+func (s *PGPService) keyring(ctx context.Context) (openpgp.EntityList, error) {
+	var keys []*entities.PGPKey
+	if err := s.db.WithContext(ctx).Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to load keyring: %w", err)
+	}
+
+	var list openpgp.EntityList
+	for _, key := range keys {
+		entity, err := s.usableEntity(key)
+		if err != nil {
+			config.Logger.Warn().Err(err).Str("fingerprint", key.Fingerprint).Msg("Skipping unreadable OpenPGP key")
+			continue
+		}
+		list = append(list, entity)
+	}
+	return list, nil
+}
+
+// usableEntity returns the secret key of key if it can be used, and its
+// public key otherwise
+func (s *PGPService) usableEntity(key *entities.PGPKey) (*openpgp.Entity, error) {
+	s.mu.Lock()
+	entity := s.unlocked[key.Fingerprint]
+	s.mu.Unlock()
+	if entity != nil {
+		return entity, nil
+	}
+
+	if key.Secret != nil {
+		entity, err := readEntity(key.Secret)
+		if err == nil && !secretLocked(entity) {
+			return entity, nil
+		}
+	}
+	return readEntity(key.Public)
+}
+
+// keysFor returns the usable keys of an address that are neither revoked
+// nor expired, most recently imported first
+func (s *PGPService) keysFor(ctx context.Context, address string) ([]*openpgp.Entity, error) {
+	var keys []*entities.PGPKey
+	err := s.db.WithContext(ctx).
+		Joins("JOIN pgp_key_emails ON pgp_key_emails.pgp_key_id = pgp_keys.id").
+		Where("pgp_key_emails.email = ? AND pgp_keys.revoked = ?", normalizeEmail(address), false).
+		Order("pgp_keys.updated_at DESC").
+		Find(&keys).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up keys: %w", err)
+	}
+
+	now := time.Now()
+	var list []*openpgp.Entity
+	for _, key := range keys {
+		if key.ExpiresAt != nil && key.ExpiresAt.Before(now) {
+			continue
+		}
+		entity, err := s.usableEntity(key)
+		if err != nil {
+			continue
+		}
+		list = append(list, entity)
+	}
+	return list, nil
+}
+
+// readKeyRing reads every key of ASCII-armored or binary data. Armored
+// data may hold several blocks.
+func readKeyRing(data []byte) (openpgp.EntityList, error) {
+	text := string(data)
+	if !strings.Contains(text, armorBegin) {
+		list, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPGPKey, err)
+		}
+		return list, nil
+	}
+
+	var list openpgp.EntityList
+	blocks := strings.Split(text, armorBegin)
+	for _, block := range blocks[1:] {
+		keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armorBegin + block))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPGPKey, err)
+		}
+		list = append(list, keys...)
+	}
+	return list, nil
+}
+
+// readEntity reads one stored key
+func readEntity(data []byte) (*openpgp.Entity, error) {
+	entity, err := openpgp.ReadEntity(packet.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPGPKey, err)
+	}
+	return entity, nil
+}
+
+// keyFromEntity describes a key for the keyring
+func keyFromEntity(entity *openpgp.Entity) (*entities.PGPKey, error) {
+	fingerprint := strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint))
+	key := &entities.PGPKey{
+		Fingerprint: fingerprint,
+		KeyID:       fingerprint[len(fingerprint)-16:],
+		Revoked:     entity.Revoked(time.Now()),
+	}
+
+	var public bytes.Buffer
+	if err := entity.Serialize(&public); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPGPKey, err)
+	}
+	key.Public = public.Bytes()
+	if entity.PrivateKey != nil {
+		var secret bytes.Buffer
+		if err := entity.SerializePrivateWithoutSigning(&secret, nil); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPGPKey, err)
+		}
+		key.Secret = secret.Bytes()
+	}
+
+	if identity := entity.PrimaryIdentity(); identity != nil {
+		key.UserID = identity.Name
+	}
+	if sig, _ := entity.PrimarySelfSignature(); sig != nil && sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs > 0 {
+		expires := entity.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+		key.ExpiresAt = &expires
+	}
+
+	seen := make(map[string]bool)
+	for _, identity := range entity.Identities {
+		email := normalizeEmail(identity.UserId.Email)
+		if email == "" || seen[email] || identity.Revoked(time.Now()) {
+			continue
+		}
+		seen[email] = true
+		key.Emails = append(key.Emails, entities.PGPKeyEmail{Email: email})
+	}
+	return key, nil
+}
+
+// secretLocked reports whether a secret key still needs its passphrase
+func secretLocked(entity *openpgp.Entity) bool {
+	if entity.PrivateKey == nil {
+		return true
+	}
+	if entity.PrivateKey.Encrypted {
+		return true
+	}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			return true
+		}
+	}
+	return false
+}
+
+// armored encodes data as an ASCII-armored block
+func armored(blockType string, write func(io.Writer) error) ([]byte, error) {
+	var out bytes.Buffer
+	w, err := armor.Encode(&out, blockType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := write(w); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
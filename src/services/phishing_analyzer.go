@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Risk levels of a message, from its score
+const (
+	RiskLevelNone   = "none"
+	RiskLevelLow    = "low"
+	RiskLevelMedium = "medium"
+	RiskLevelHigh   = "high"
+)
+
+// RiskLevel returns the level of a risk score. Messages from medium up
+// deserve a warning.
+func RiskLevel(score int) string {
+	switch {
+	case score >= 60:
+		return RiskLevelHigh
+	case score >= 30:
+		return RiskLevelMedium
+	case score > 0:
+		return RiskLevelLow
+	}
+	return RiskLevelNone
+}
+
+// PhishingAnalyzer scores how likely messages are to be phishing: senders
+// posing as contacts, lookalike and mixed-script domains, links whose text
+// names another site, dangerous attachments and failed authentication.
+// Scores are stored with the reasons behind them.
+type PhishingAnalyzer struct {
+	db *gorm.DB
+}
+
+// NewPhishingAnalyzer creates a new PhishingAnalyzer
+func NewPhishingAnalyzer(db *gorm.DB) *PhishingAnalyzer {
+	config.Logger.Debug().Msg("Initializing phishing analyzer")
+	return &PhishingAnalyzer{db: db}
+}
+
+// Subscribe analyzes every message created on bus. It returns a function
+// that stops analyzing.
+func (a *PhishingAnalyzer) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		if _, err := a.Analyze(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to analyze message")
+		}
+	})
+}
+
+// Analyze scores a message and stores the result, replacing an earlier
+// analysis
+func (a *PhishingAnalyzer) Analyze(ctx context.Context, messageID uint) (*entities.MessageRisk, error) {
+	var message entities.Message
+	err := a.db.WithContext(ctx).Preload("Account").Preload("Attachments").First(&message, messageID).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+
+	reasons, err := a.reasons(ctx, &message)
+	if err != nil {
+		return nil, err
+	}
+
+	risk := &entities.MessageRisk{
+		AnalyzedAt: time.Now(),
+		Score:      riskScore(reasons),
+		Reasons:    reasons,
+		MessageID:  messageID,
+	}
+	err = a.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "message_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"analyzed_at", "score", "reasons"}),
+		}).
+		Create(risk).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to store message risk: %w", err)
+	}
+
+	if risk.Score > 0 {
+		config.Logger.Info().
+			Uint("messageID", messageID).
+			Int("score", risk.Score).
+			Int("reasonCount", len(reasons)).
+			Msg("Message looks suspicious")
+	}
+	return risk, nil
+}
+
+// AnalyzeMissing analyzes the messages that have no analysis yet, such as
+// those stored before the analyzer existed. It returns how many were
+// analyzed.
+func (a *PhishingAnalyzer) AnalyzeMissing(ctx context.Context) (int, error) {
+	var ids []uint
+	err := a.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Where("id NOT IN (?)", a.db.Model(&entities.MessageRisk{}).Select("message_id")).
+		Order("id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find unanalyzed messages: %w", err)
+	}
+
+	for i, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if _, err := a.Analyze(ctx, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// reasons runs the checks against a message
+func (a *PhishingAnalyzer) reasons(ctx context.Context, message *entities.Message) ([]entities.RiskReason, error) {
+	sender := normalizeEmail(message.SenderEmail)
+	// The account owner's own messages are not analyzed
+	if sender == normalizeEmail(message.Account.Email) {
+		return []entities.RiskReason{}, nil
+	}
+
+	known, err := a.knownDomains(ctx, message.Account.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	senderName := stringOrEmpty(message.SenderName)
+	contactEmails, err := a.namesakeEmails(ctx, senderName, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	dmarc, spf, dkim, err := a.authentication(ctx, message.ID, message.Account.TrustedAuthServIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		filenames = append(filenames, attachment.Filename)
+	}
+
+	reasons := []entities.RiskReason{}
+	reasons = append(reasons, checkDisplayName(senderName, sender, contactEmails)...)
+	if _, domain, ok := strings.Cut(sender, "@"); ok {
+		reasons = append(reasons, checkDomain(domain, "sender", known)...)
+	}
+	reasons = append(reasons, checkLinks(stringOrEmpty(message.Body), known)...)
+	reasons = append(reasons, checkAttachments(filenames)...)
+	reasons = append(reasons, checkAuthentication(dmarc, spf, dkim)...)
+	return reasons, nil
+}
+
+// authentication returns the DMARC, SPF and DKIM results of a message:
+// those of the sender verifier, or else those reported in the topmost
+// Authentication-Results field of a trusted server
+func (a *PhishingAnalyzer) authentication(ctx context.Context, messageID uint, trusted []string) (string, string, string, error) {
+	var verified entities.MessageAuthentication
+	result := a.db.WithContext(ctx).Where("message_id = ?", messageID).Limit(1).Find(&verified)
+	if result.Error != nil {
+		return "", "", "", fmt.Errorf("failed to load message authentication: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return verified.DMARC, verified.SPF, verified.DKIM, nil
+	}
+
+	var fields []entities.MessageHeader
+	err := a.db.WithContext(ctx).
+		Where("message_id = ? AND LOWER(name) = ?", messageID, "authentication-results").
+		Order("position").
+		Find(&fields).Error
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to load authentication results: %w", err)
+	}
+	reported, _ := deliveryResults(fields, trusted)
+	if reported == nil {
+		return "", "", "", nil
+	}
+	values := make([]string, 3)
+	for i, method := range []string{"dmarc", "spf", "dkim"} {
+		if r, ok := reported.Get(method); ok {
+			values[i] = r.Value
+		}
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// knownDomains returns the domains lookalikes are compared with: the
+// account's own, those of contacts we have written to and frequently
+// impersonated ones
+func (a *PhishingAnalyzer) knownDomains(ctx context.Context, owner string) ([]string, error) {
+	var emails []string
+	err := a.db.WithContext(ctx).
+		Model(&entities.ContactEmail{}).
+		Joins("JOIN contacts ON contacts.id = contact_emails.contact_id AND contacts.deleted_at IS NULL").
+		Where("contacts.sent_count > 0").
+		Pluck("contact_emails.email", &emails).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contact domains: %w", err)
+	}
+	emails = append(emails, normalizeEmail(owner))
+
+	seen := make(map[string]bool)
+	known := make([]string, 0, len(emails)+len(phishedDomains))
+	for _, domain := range phishedDomains {
+		seen[domain] = true
+		known = append(known, domain)
+	}
+	for _, email := range emails {
+		_, domain, ok := strings.Cut(email, "@")
+		if !ok {
+			continue
+		}
+		domain = registrableDomain(domain)
+		if !seen[domain] {
+			seen[domain] = true
+			known = append(known, domain)
+		}
+	}
+	return known, nil
+}
+
+// namesakeEmails returns the addresses of the contacts named like a sender
+// other than the sender's own contact, which may have been recorded from
+// the very message analyzed
+func (a *PhishingAnalyzer) namesakeEmails(ctx context.Context, senderName, sender string) ([]string, error) {
+	name := NormalizeDisplayName(senderName, sender)
+	if name == "" {
+		return nil, nil
+	}
+
+	var emails []string
+	err := a.db.WithContext(ctx).
+		Model(&entities.ContactEmail{}).
+		Joins("JOIN contacts ON contacts.id = contact_emails.contact_id AND contacts.deleted_at IS NULL").
+		Where("LOWER(contacts.display_name) = LOWER(?)", name).
+		Where("contacts.id NOT IN (?)", a.db.Model(&entities.ContactEmail{}).Select("contact_id").Where("email = ?", sender)).
+		Order("contact_emails.email").
+		Pluck("contact_emails.email", &emails).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contacts: %w", err)
+	}
+	return emails, nil
+}
@@ -0,0 +1,413 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"palm/src/entities"
+	"path"
+	"strings"
+	"unicode"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Weights of the phishing checks; a message's score is their sum, capped
+// at 100
+const (
+	weightDisplayNameSpoofing = 40
+	weightEmbeddedAddress     = 30
+	weightLookalikeDomain     = 40
+	weightMixedScripts        = 30
+	weightLinkMismatch        = 20
+	weightExecutable          = 40
+	weightDisguisedFile       = 50
+	weightRiskyAttachment     = 20
+	weightDMARCFail           = 35
+	weightSPFFail             = 20
+	weightSPFSoftFail         = 10
+	weightDKIMFail            = 20
+	maxRiskScore              = 100
+	// maxLinkMismatches bounds the link reasons of one message
+	maxLinkMismatches = 3
+)
+
+// phishedDomains are frequently impersonated; domains imitating them are
+// flagged even when no contact uses them
+var phishedDomains = []string{
+	"adobe.com", "amazon.com", "apple.com", "booking.com", "chase.com",
+	"dhl.com", "docusign.com", "dropbox.com", "ebay.com", "facebook.com",
+	"fedex.com", "github.com", "google.com", "icloud.com", "instagram.com",
+	"linkedin.com", "microsoft.com", "netflix.com", "office.com",
+	"outlook.com", "paypal.com", "ups.com", "usps.com", "wellsfargo.com",
+}
+
+// confusables map letters and digits to the Latin letter they are mistaken
+// for, after diacritics are removed
+var confusables = map[rune]rune{
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'х': 'x', 'у': 'y',
+	'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ѵ': 'v', 'ӏ': 'l',
+	'ɡ': 'g', 'ɩ': 'l', 'ı': 'i', 'ο': 'o', 'α': 'a', 'ν': 'v', 'ι': 'i',
+	'κ': 'k', 'τ': 't', 'ρ': 'p', '0': 'o', '1': 'l',
+}
+
+// confusableSequences are letter pairs that read as one letter
+var confusableSequences = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// executableExtensions run code when opened
+var executableExtensions = map[string]bool{
+	".apk": true, ".app": true, ".bat": true, ".cmd": true, ".com": true,
+	".cpl": true, ".dll": true, ".exe": true, ".hta": true, ".img": true,
+	".iso": true, ".jar": true, ".js": true, ".jse": true, ".lnk": true,
+	".msi": true, ".msp": true, ".pif": true, ".ps1": true, ".reg": true,
+	".scr": true, ".vbe": true, ".vbs": true, ".vhd": true, ".wsf": true,
+	".wsh": true,
+}
+
+// riskyExtensions are documents that can run macros or imitate login pages
+var riskyExtensions = map[string]bool{
+	".docm": true, ".dotm": true, ".htm": true, ".html": true, ".one": true,
+	".pptm": true, ".shtml": true, ".svg": true, ".xlam": true, ".xlsm": true,
+}
+
+// checkDisplayName flags a sender name that belongs to a known contact
+// whose addresses do not include the sender's, and names that contain an
+// address other than the sender's
+func checkDisplayName(senderName, senderEmail string, contactEmails []string) []entities.RiskReason {
+	var reasons []entities.RiskReason
+	if senderName == "" {
+		return nil
+	}
+	if len(contactEmails) > 0 {
+		reasons = append(reasons, entities.RiskReason{
+			Code:   entities.RiskDisplayNameSpoofing,
+			Detail: fmt.Sprintf("The sender uses the name of your contact %s, who writes from %s, but this message is from %s", senderName, strings.Join(contactEmails, ", "), senderEmail),
+			Weight: weightDisplayNameSpoofing,
+		})
+	}
+	for _, word := range strings.Fields(senderName) {
+		word = strings.Trim(word, `"'<>()[],;`)
+		if validEmail(word) && normalizeEmail(word) != normalizeEmail(senderEmail) {
+			reasons = append(reasons, entities.RiskReason{
+				Code:   entities.RiskDisplayNameSpoofing,
+				Detail: fmt.Sprintf("The sender name shows the address %s, but this message is from %s", word, senderEmail),
+				Weight: weightEmbeddedAddress,
+			})
+			break
+		}
+	}
+	return reasons
+}
+
+// checkDomain flags a domain that mixes scripts or imitates one of known.
+// what describes where the domain was found, such as "sender".
+func checkDomain(domain, what string, known []string) []entities.RiskReason {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return nil
+	}
+	display := domain
+	if unicodeDomain, err := idna.ToUnicode(domain); err == nil {
+		display = unicodeDomain
+	}
+
+	var reasons []entities.RiskReason
+	if mixedScripts(display) {
+		reasons = append(reasons, entities.RiskReason{
+			Code:   entities.RiskPunycodeDomain,
+			Detail: fmt.Sprintf("The %s domain %s (%s) mixes letters of different alphabets", what, display, domain),
+			Weight: weightMixedScripts,
+		})
+	}
+	if imitated := imitatedDomain(display, known); imitated != "" {
+		reasons = append(reasons, entities.RiskReason{
+			Code:   entities.RiskLookalikeDomain,
+			Detail: fmt.Sprintf("The %s domain %s looks like %s", what, display, imitated),
+			Weight: weightLookalikeDomain,
+		})
+	}
+	return reasons
+}
+
+// imitatedDomain returns the known domain that domain imitates, if any:
+// one it cannot be told apart from, one it differs from by a single
+// letter, or one it carries as a subdomain of another site
+func imitatedDomain(domain string, known []string) string {
+	registrable := registrableDomain(domain)
+	for _, k := range known {
+		if registrable == k {
+			return ""
+		}
+	}
+
+	ownSkeleton := skeleton(registrable)
+	ownName := strings.SplitN(registrable, ".", 2)[0]
+	for _, k := range known {
+		switch {
+		case skeleton(k) == ownSkeleton:
+			return k
+		case strings.HasPrefix(domain, k+".") || strings.Contains(domain, "."+k+"."):
+			return k
+		}
+		name := strings.SplitN(k, ".", 2)[0]
+		if len(name) >= 5 && levenshtein(ownName, name) == 1 {
+			return k
+		}
+	}
+	return ""
+}
+
+// checkLinks flags links whose text names another site than they lead to,
+// and links to lookalike domains
+func checkLinks(body string, known []string) []entities.RiskReason {
+	var reasons []entities.RiskReason
+	mismatches := 0
+	seen := make(map[string]bool)
+	for _, link := range bodyLinks(body) {
+		href, err := url.Parse(link.href)
+		if err != nil || (href.Scheme != "http" && href.Scheme != "https") {
+			continue
+		}
+		target := strings.ToLower(href.Hostname())
+
+		if !seen[target] {
+			seen[target] = true
+			reasons = append(reasons, checkDomain(target, "link", known)...)
+		}
+
+		shown := textDomain(link.text)
+		if shown == "" || mismatches >= maxLinkMismatches {
+			continue
+		}
+		if registrableDomain(shown) != registrableDomain(target) {
+			mismatches++
+			reasons = append(reasons, entities.RiskReason{
+				Code:   entities.RiskLinkMismatch,
+				Detail: fmt.Sprintf("A link shows %s but leads to %s", shown, target),
+				Weight: weightLinkMismatch,
+			})
+		}
+	}
+	return reasons
+}
+
+// checkAttachments flags attachments that run code, hide their type or
+// are often used to deliver malware
+func checkAttachments(filenames []string) []entities.RiskReason {
+	var reasons []entities.RiskReason
+	for _, filename := range filenames {
+		name := strings.ToLower(strings.TrimSpace(filename))
+		ext := path.Ext(name)
+		inner := path.Ext(strings.TrimSuffix(name, ext))
+		switch {
+		case strings.ContainsAny(name, "‮‭‏"):
+			reasons = append(reasons, entities.RiskReason{
+				Code:   entities.RiskDangerousAttachment,
+				Detail: fmt.Sprintf("The attachment %q hides its real file type", filename),
+				Weight: weightDisguisedFile,
+			})
+		case executableExtensions[ext] && inner != "" && !executableExtensions[inner]:
+			reasons = append(reasons, entities.RiskReason{
+				Code:   entities.RiskDangerousAttachment,
+				Detail: fmt.Sprintf("The attachment %q is a program disguised as a %s file", filename, inner),
+				Weight: weightDisguisedFile,
+			})
+		case executableExtensions[ext]:
+			reasons = append(reasons, entities.RiskReason{
+				Code:   entities.RiskDangerousAttachment,
+				Detail: fmt.Sprintf("The attachment %q is a program", filename),
+				Weight: weightExecutable,
+			})
+		case riskyExtensions[ext]:
+			reasons = append(reasons, entities.RiskReason{
+				Code:   entities.RiskDangerousAttachment,
+				Detail: fmt.Sprintf("The attachment %q can contain scripts or macros", filename),
+				Weight: weightRiskyAttachment,
+			})
+		}
+	}
+	return reasons
+}
+
+// checkAuthentication flags failed DMARC, SPF and DKIM results
+func checkAuthentication(dmarc, spf, dkim string) []entities.RiskReason {
+	var reasons []entities.RiskReason
+	if dmarc == "fail" {
+		reasons = append(reasons, entities.RiskReason{
+			Code:   entities.RiskAuthentication,
+			Detail: "The sender's domain does not vouch for this message (DMARC failed)",
+			Weight: weightDMARCFail,
+		})
+	}
+	switch spf {
+	case "fail":
+		reasons = append(reasons, entities.RiskReason{
+			Code:   entities.RiskAuthentication,
+			Detail: "The message came from a server not allowed to send for the sender's domain (SPF failed)",
+			Weight: weightSPFFail,
+		})
+	case "softfail":
+		reasons = append(reasons, entities.RiskReason{
+			Code:   entities.RiskAuthentication,
+			Detail: "The message came from a server the sender's domain does not list (SPF soft fail)",
+			Weight: weightSPFSoftFail,
+		})
+	}
+	if dkim == "fail" {
+		reasons = append(reasons, entities.RiskReason{
+			Code:   entities.RiskAuthentication,
+			Detail: "The message's signature does not match its content (DKIM failed)",
+			Weight: weightDKIMFail,
+		})
+	}
+	return reasons
+}
+
+// riskScore sums the weights of reasons, capped at maxRiskScore
+func riskScore(reasons []entities.RiskReason) int {
+	score := 0
+	for _, reason := range reasons {
+		score += reason.Weight
+	}
+	return min(score, maxRiskScore)
+}
+
+// bodyLink is a link of an HTML body with its visible text
+type bodyLink struct {
+	href string
+	text string
+}
+
+// bodyLinks returns the links of an HTML body
+func bodyLinks(body string) []bodyLink {
+	var links []bodyLink
+	var current *bodyLink
+	var text strings.Builder
+
+	z := nethtml.NewTokenizer(strings.NewReader(body))
+	for {
+		switch z.Next() {
+		case nethtml.ErrorToken:
+			return links
+		case nethtml.StartTagToken:
+			name, hasAttr := z.TagName()
+			if atom.Lookup(name) != atom.A {
+				continue
+			}
+			current = &bodyLink{}
+			text.Reset()
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					current.href = strings.TrimSpace(string(value))
+				}
+			}
+		case nethtml.TextToken:
+			if current != nil {
+				text.Write(z.Text())
+			}
+		case nethtml.EndTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.A && current != nil {
+				current.text = strings.TrimSpace(text.String())
+				links = append(links, *current)
+				current = nil
+			}
+		}
+	}
+}
+
+// textDomain returns the host named by link text that reads as a web
+// address, such as "www.example.com" or "https://example.com/login"
+func textDomain(text string) string {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" || strings.ContainsAny(text, " \t\n@") {
+		return ""
+	}
+	if i := strings.Index(text, "://"); i >= 0 {
+		text = text[i+3:]
+	}
+	host, _, _ := strings.Cut(text, "/")
+	host, _, _ = strings.Cut(host, "?")
+	host = strings.TrimSuffix(host, ".")
+	if !domainName.MatchString(host) {
+		return ""
+	}
+	// Text such as "file.pdf" names no site
+	if _, icann := publicsuffix.PublicSuffix(host); !icann {
+		return ""
+	}
+	return host
+}
+
+// registrableDomain returns the part of a host registered by its owner,
+// such as example.co.uk for www.example.co.uk
+func registrableDomain(host string) string {
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
+
+// skeleton reduces a domain to the letters it is read as, so that domains
+// that cannot be told apart have the same skeleton
+func skeleton(domain string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(domain)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return confusableSequences.Replace(b.String())
+}
+
+// mixedScripts reports whether a label of domain mixes Latin letters with
+// letters of another script
+func mixedScripts(domain string) bool {
+	for _, label := range strings.Split(domain, ".") {
+		latin, other := false, false
+		for _, r := range label {
+			switch {
+			case !unicode.IsLetter(r):
+			case unicode.Is(unicode.Latin, r):
+				latin = true
+			default:
+				other = true
+			}
+		}
+		if latin && other {
+			return true
+		}
+	}
+	return false
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
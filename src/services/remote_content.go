@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/formats/htmlsafe"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidRemoteSender is returned for a sender that is neither an
+// address nor a domain
+var ErrInvalidRemoteSender = errors.New("sender must be an email address or a domain")
+
+// domainName matches a domain with at least two labels
+var domainName = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z0-9-]{2,}$`)
+
+// trackerDomains only serve open-tracking pixels and click redirects, with
+// their subdomains
+var trackerDomains = []string{
+	"awstrack.me", "bananatag.com", "createsend1.com", "ct.sendgrid.net",
+	"doubleclick.net", "emltrk.com", "exct.net", "getnotify.com",
+	"google-analytics.com", "hubspotlinks.com", "list-manage.com",
+	"mailtrack.io", "mandrillapp.com", "mixpanel.com", "mlsend.com",
+	"rs6.net", "sparkpostmail.com", "yesware.com",
+}
+
+// pixelPaths are path fragments of open-tracking pixels
+var pixelPaths = []string{
+	"/1x1", "/beacon", "/e/o/", "/o.gif", "/open.gif", "/open.php",
+	"/pixel", "/t.gif", "/track/open", "/trk/", "/wf/open",
+}
+
+// clickPaths are path fragments of click-tracking redirects
+var clickPaths = []string{"/ls/click", "/track/click", "/click.php", "/redirect.php", "/c/"}
+
+// clickHosts are host name prefixes of click-tracking redirectors
+var clickHosts = []string{"click.", "clicks.", "email.mg.", "links.", "track.", "trk."}
+
+// trackingParameters are query parameters that identify the recipient or
+// campaign of a link. Parameters starting with "utm_" are removed too.
+var trackingParameters = map[string]bool{
+	"_hsenc": true, "_hsmi": true, "fbclid": true, "gclid": true,
+	"mc_cid": true, "mc_eid": true, "mkt_tok": true, "ml_subscriber": true,
+	"ml_subscriber_hash": true, "msclkid": true, "oly_anon_id": true,
+	"oly_enc_id": true, "rb_clickid": true, "s_cid": true, "vero_id": true,
+	"yclid": true,
+}
+
+// RemoteContentService decides which messages may load remote images and
+// renders their bodies accordingly. Remote images are blocked unless their
+// sender is allowed or the user loads them once; tracking pixels are never
+// loaded.
+type RemoteContentService struct {
+	db    *gorm.DB
+	proxy *ImageProxy
+}
+
+// NewRemoteContentService creates a new RemoteContentService. Allowed
+// images are loaded through proxy.
+func NewRemoteContentService(db *gorm.DB, proxy *ImageProxy) *RemoteContentService {
+	config.Logger.Debug().Msg("Initializing remote content service")
+	return &RemoteContentService{db: db, proxy: proxy}
+}
+
+// Render renders the body of an email, loading remote images when its
+// sender is allowed or loadRemote is set
+func (s *RemoteContentService) Render(ctx context.Context, email *EmailDTO, loadRemote bool) (RenderedBody, error) {
+	allowed := loadRemote
+	if !allowed {
+		var err error
+		if allowed, err = s.Allowed(ctx, email.Message.SenderEmail); err != nil {
+			return RenderedBody{}, err
+		}
+	}
+	return RenderBody(email, RenderOptions{AllowRemote: allowed, Proxy: s.proxy}), nil
+}
+
+// Allow lets messages from a sender load remote images. The sender is an
+// address or a domain, which covers its subdomains too.
+func (s *RemoteContentService) Allow(ctx context.Context, sender string) (*entities.RemoteContentSender, error) {
+	sender, err := normalizeRemoteSender(sender)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := &entities.RemoteContentSender{Sender: sender}
+	err = s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(allowed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to allow remote content: %w", err)
+	}
+	if err := s.db.WithContext(ctx).Where("sender = ?", sender).First(allowed).Error; err != nil {
+		return nil, fmt.Errorf("failed to allow remote content: %w", err)
+	}
+
+	config.Logger.Info().Str("sender", sender).Msg("Remote content allowed")
+	return allowed, nil
+}
+
+// Disallow blocks remote images from a sender again. Senders that were
+// never allowed are ignored.
+func (s *RemoteContentService) Disallow(ctx context.Context, sender string) error {
+	sender, err := normalizeRemoteSender(sender)
+	if err != nil {
+		return err
+	}
+	err = s.db.WithContext(ctx).Where("sender = ?", sender).Delete(&entities.RemoteContentSender{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to disallow remote content: %w", err)
+	}
+
+	config.Logger.Info().Str("sender", sender).Msg("Remote content disallowed")
+	return nil
+}
+
+// List returns the allowed senders in alphabetical order
+func (s *RemoteContentService) List(ctx context.Context) ([]*entities.RemoteContentSender, error) {
+	var senders []*entities.RemoteContentSender
+	if err := s.db.WithContext(ctx).Order("sender").Find(&senders).Error; err != nil {
+		return nil, fmt.Errorf("failed to list remote content senders: %w", err)
+	}
+	return senders, nil
+}
+
+// Allowed reports whether messages from an address may load remote
+// images: the address itself, its domain or a parent domain is allowed
+func (s *RemoteContentService) Allowed(ctx context.Context, address string) (bool, error) {
+	address = normalizeEmail(address)
+	if !validEmail(address) {
+		return false, nil
+	}
+
+	candidates := []string{address}
+	domain := address[strings.LastIndex(address, "@")+1:]
+	for strings.Contains(domain, ".") {
+		candidates = append(candidates, domain)
+		domain = domain[strings.Index(domain, ".")+1:]
+	}
+
+	var count int64
+	err := s.db.WithContext(ctx).
+		Model(&entities.RemoteContentSender{}).
+		Where("sender IN ?", candidates).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check remote content senders: %w", err)
+	}
+	return count > 0, nil
+}
+
+// normalizeRemoteSender validates an address or domain and lower-cases it.
+// A domain may be written as "@example.com".
+func normalizeRemoteSender(sender string) (string, error) {
+	sender = strings.TrimPrefix(normalizeEmail(sender), "@")
+	if strings.Contains(sender, "@") {
+		if !validEmail(sender) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidRemoteSender, sender)
+		}
+		return sender, nil
+	}
+	if !domainName.MatchString(sender) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRemoteSender, sender)
+	}
+	return sender, nil
+}
+
+// isTrackingPixel reports whether a remote image only tells the sender that
+// the message was opened
+func isTrackingPixel(image htmlsafe.Image) bool {
+	if image.Hidden || isTrackerDomain(image.URL.Hostname()) {
+		return true
+	}
+	path := strings.ToLower(image.URL.Path)
+	for _, fragment := range pixelPaths {
+		if strings.Contains(path, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrackedLink reports whether a link goes through a click-tracking
+// redirect before reaching its destination
+func isTrackedLink(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if isTrackerDomain(host) {
+		return true
+	}
+	for _, prefix := range clickHosts {
+		if strings.HasPrefix(host, prefix) {
+			return true
+		}
+	}
+	path := strings.ToLower(u.Path)
+	for _, fragment := range clickPaths {
+		if strings.Contains(path, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrackerDomain reports whether host is or is under a tracker domain
+func isTrackerDomain(host string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range trackerDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTrackingParameters removes recipient and campaign identifiers from
+// the query of a link
+func stripTrackingParameters(u *url.URL) {
+	if u.RawQuery == "" {
+		return
+	}
+	query := u.Query()
+	changed := false
+	for name := range query {
+		lower := strings.ToLower(name)
+		if trackingParameters[lower] || strings.HasPrefix(lower, "utm_") {
+			query.Del(name)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/mail"
+	"palm/src/entities"
+	"palm/src/formats/htmltext"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// maxLabelLength bounds label and folder names
+const maxLabelLength = 100
+
+// compiledRule is a validated rule with the regular expressions of its
+// conditions compiled
+type compiledRule struct {
+	*entities.Rule
+	patterns map[int]*regexp.Regexp // By condition index
+}
+
+// compileRule validates a rule and compiles its regular expressions
+func compileRule(rule *entities.Rule) (*compiledRule, error) {
+	if strings.TrimSpace(rule.Name) == "" {
+		return nil, fmt.Errorf("%w: the rule has no name", ErrInvalidRule)
+	}
+	if len(rule.Conditions) == 0 {
+		return nil, fmt.Errorf("%w: the rule has no conditions", ErrInvalidRule)
+	}
+	if len(rule.Actions) == 0 {
+		return nil, fmt.Errorf("%w: the rule has no actions", ErrInvalidRule)
+	}
+
+	compiled := &compiledRule{Rule: rule, patterns: map[int]*regexp.Regexp{}}
+	for i, c := range rule.Conditions {
+		switch c.Field {
+		case entities.RuleFieldSender, entities.RuleFieldRecipients, entities.RuleFieldSubject,
+			entities.RuleFieldBody, entities.RuleFieldImportance:
+		case entities.RuleFieldHeader:
+			if strings.TrimSpace(c.Header) == "" {
+				return nil, fmt.Errorf("%w: a header condition names no header field", ErrInvalidRule)
+			}
+		case entities.RuleFieldHasAttachments:
+			if c.Operator != entities.RuleOperatorEquals && c.Operator != entities.RuleOperatorNotEquals {
+				return nil, fmt.Errorf("%w: attachment presence is tested with equals or not-equals", ErrInvalidRule)
+			}
+			if c.Value != "true" && c.Value != "false" {
+				return nil, fmt.Errorf("%w: attachment presence is true or false", ErrInvalidRule)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidRule, c.Field)
+		}
+
+		switch c.Operator {
+		case entities.RuleOperatorContains, entities.RuleOperatorNotContains,
+			entities.RuleOperatorEquals, entities.RuleOperatorNotEquals,
+			entities.RuleOperatorStartsWith, entities.RuleOperatorEndsWith:
+		case entities.RuleOperatorMatches:
+			pattern, err := regexp.Compile(c.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+			}
+			compiled.patterns[i] = pattern
+		default:
+			return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidRule, c.Operator)
+		}
+	}
+
+	for _, a := range rule.Actions {
+		switch a.Type {
+		case entities.RuleActionMarkRead, entities.RuleActionDelete:
+		case entities.RuleActionSetImportance:
+			switch entities.Importance(a.Value) {
+			case entities.ImportanceLow, entities.ImportanceNormal, entities.ImportanceHigh:
+			default:
+				return nil, fmt.Errorf("%w: unknown importance %q", ErrInvalidRule, a.Value)
+			}
+		case entities.RuleActionLabel:
+			if strings.TrimSpace(a.Value) == "" {
+				return nil, fmt.Errorf("%w: a label action names no label", ErrInvalidRule)
+			}
+			if len(a.Value) > maxLabelLength {
+				return nil, fmt.Errorf("%w: label is too long", ErrInvalidRule)
+			}
+		case entities.RuleActionMove:
+			if len(a.Value) > maxLabelLength {
+				return nil, fmt.Errorf("%w: folder name is too long", ErrInvalidRule)
+			}
+		case entities.RuleActionForward:
+			if _, err := mail.ParseAddress(a.Value); err != nil {
+				return nil, fmt.Errorf("%w: cannot forward to %q", ErrInvalidRule, a.Value)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidRule, a.Type)
+		}
+	}
+	return compiled, nil
+}
+
+// matches tells whether a message satisfies all or any of the rule's
+// conditions
+func (r *compiledRule) matches(ctx context.Context, m *ruleMessage) (bool, error) {
+	for i, c := range r.Conditions {
+		ok, err := r.test(ctx, i, c, m)
+		if err != nil {
+			return false, err
+		}
+		if r.MatchAll && !ok {
+			return false, nil
+		}
+		if !r.MatchAll && ok {
+			return true, nil
+		}
+	}
+	return r.MatchAll, nil
+}
+
+// test evaluates one condition on a message
+func (r *compiledRule) test(ctx context.Context, i int, c entities.RuleCondition, m *ruleMessage) (bool, error) {
+	var values []string
+	switch c.Field {
+	case entities.RuleFieldSender:
+		values = []string{m.message.SenderEmail, stringOrEmpty(m.message.SenderName)}
+	case entities.RuleFieldRecipients:
+		recipients, err := m.recipients(ctx)
+		if err != nil {
+			return false, err
+		}
+		values = recipients
+	case entities.RuleFieldSubject:
+		values = []string{stringOrEmpty(m.message.Subject)}
+	case entities.RuleFieldBody:
+		values = []string{m.body()}
+	case entities.RuleFieldHeader:
+		headers, err := m.header(ctx, c.Header)
+		if err != nil {
+			return false, err
+		}
+		values = headers
+	case entities.RuleFieldImportance:
+		values = []string{string(m.message.Importance)}
+	case entities.RuleFieldHasAttachments:
+		has, err := m.hasAttachments(ctx)
+		if err != nil {
+			return false, err
+		}
+		equal := has == (c.Value == "true")
+		return equal == (c.Operator == entities.RuleOperatorEquals), nil
+	}
+	return compare(c.Operator, c.Value, r.patterns[i], values), nil
+}
+
+// compare applies a text operator to the values of a field. Positive
+// operators hold if any value satisfies them; negated ones if none
+// satisfies their positive form.
+func compare(operator, want string, pattern *regexp.Regexp, values []string) bool {
+	negated := false
+	switch operator {
+	case entities.RuleOperatorNotContains:
+		operator, negated = entities.RuleOperatorContains, true
+	case entities.RuleOperatorNotEquals:
+		operator, negated = entities.RuleOperatorEquals, true
+	}
+
+	want = strings.ToLower(want)
+	for _, value := range values {
+		var ok bool
+		switch operator {
+		case entities.RuleOperatorContains:
+			ok = strings.Contains(strings.ToLower(value), want)
+		case entities.RuleOperatorEquals:
+			ok = strings.ToLower(value) == want
+		case entities.RuleOperatorStartsWith:
+			ok = strings.HasPrefix(strings.ToLower(value), want)
+		case entities.RuleOperatorEndsWith:
+			ok = strings.HasSuffix(strings.ToLower(value), want)
+		case entities.RuleOperatorMatches:
+			ok = pattern.MatchString(value)
+		}
+		if ok {
+			return !negated
+		}
+	}
+	return negated
+}
+
+// ruleMessage is a message rules are evaluated on. The parts conditions
+// rarely test are loaded the first time one does.
+type ruleMessage struct {
+	db      *gorm.DB
+	message *entities.Message
+
+	recipientValues []string
+	headers         []*entities.MessageHeader
+	attachments     *bool
+	text            *string
+}
+
+func newRuleMessage(db *gorm.DB, message *entities.Message) *ruleMessage {
+	return &ruleMessage{db: db, message: message}
+}
+
+// recipients returns the addresses and names of the To and Cc recipients
+func (m *ruleMessage) recipients(ctx context.Context) ([]string, error) {
+	if m.recipientValues != nil {
+		return m.recipientValues, nil
+	}
+	var recipients []*entities.Recipient
+	err := m.db.WithContext(ctx).
+		Where("message_id = ? AND recipient_type IN ?", m.message.ID,
+			[]entities.RecipientType{entities.RecipientTypeTo, entities.RecipientTypeCc}).
+		Find(&recipients).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recipients: %w", err)
+	}
+	m.recipientValues = []string{}
+	for _, r := range recipients {
+		m.recipientValues = append(m.recipientValues, r.Email)
+		if name := stringOrEmpty(r.Name); name != "" {
+			m.recipientValues = append(m.recipientValues, name)
+		}
+	}
+	return m.recipientValues, nil
+}
+
+// header returns the values of the header fields named name, with their
+// encoded words decoded
+func (m *ruleMessage) header(ctx context.Context, name string) ([]string, error) {
+	if m.headers == nil {
+		err := m.db.WithContext(ctx).
+			Where("message_id = ?", m.message.ID).
+			Order("position").
+			Find(&m.headers).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load header fields: %w", err)
+		}
+	}
+
+	var decoder mime.WordDecoder
+	var values []string
+	for _, field := range m.headers {
+		if !strings.EqualFold(field.Name, name) {
+			continue
+		}
+		value, err := decoder.DecodeHeader(field.Value)
+		if err != nil {
+			value = field.Value
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// hasAttachments tells whether the message has attachments, inline
+// images included
+func (m *ruleMessage) hasAttachments(ctx context.Context) (bool, error) {
+	if m.attachments == nil {
+		var count int64
+		err := m.db.WithContext(ctx).
+			Model(&entities.Attachment{}).
+			Where("message_id = ?", m.message.ID).
+			Count(&count).Error
+		if err != nil {
+			return false, fmt.Errorf("failed to count attachments: %w", err)
+		}
+		has := count > 0
+		m.attachments = &has
+	}
+	return *m.attachments, nil
+}
+
+// body returns the body as plain text
+func (m *ruleMessage) body() string {
+	if m.text == nil {
+		text := htmltext.ToText(stringOrEmpty(m.message.Body))
+		m.text = &text
+	}
+	return *m.text
+}
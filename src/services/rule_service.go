@@ -0,0 +1,467 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/htmltext"
+	"palm/src/formats/rfc5322"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Custom error types
+var (
+	ErrRuleNotFound = errors.New("rule not found")
+	ErrInvalidRule  = errors.New("invalid rule")
+)
+
+// DefaultDryRunLimit is the number of matches a dry run returns at most
+const DefaultDryRunLimit = 100
+
+// dryRunBatchSize is the number of messages a dry run loads at once
+const dryRunBatchSize = 200
+
+// RuleResult tells which rules matched a message
+type RuleResult struct {
+	MessageID uint
+	Rules     []string // Names of the matching rules, in the order they ran
+	Deleted   bool     // A rule deleted the message
+}
+
+// DryRunResult is the preview of a rule on stored messages
+type DryRunResult struct {
+	Matches   []*EmailDTO // Matching messages, newest first
+	Scanned   int         // Messages the rule was evaluated on
+	Truncated bool        // More messages match than were returned
+}
+
+// RuleService stores the user's rules and runs them on incoming messages.
+// Rules run in order; each matching rule takes its actions and may stop
+// the later ones. Only messages received from others are filtered, so the
+// sent copies of forwarded messages are not.
+type RuleService struct {
+	db           *gorm.DB
+	emailService *EmailService
+	sendService  *SendService
+	store        *AttachmentStore
+	events       *events.Bus
+}
+
+// NewRuleService creates a new RuleService. Messages are forwarded through
+// sendService; sources of messages stored without them are rebuilt with
+// the attachments of store.
+func NewRuleService(db *gorm.DB, emailService *EmailService, sendService *SendService, store *AttachmentStore) *RuleService {
+	config.Logger.Debug().Msg("Initializing rule service")
+	return &RuleService{
+		db:           db,
+		emailService: emailService,
+		sendService:  sendService,
+		store:        store,
+	}
+}
+
+// SetEventBus sets the bus that changes made by rules are published to
+func (s *RuleService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// Subscribe runs the rules on every message created on bus. It returns a
+// function that stops running them.
+func (s *RuleService) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		// Only mail just delivered is filtered: importing an archive or
+		// storing a sent copy must not move, forward or answer old mail
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok || payload.Origin != events.OriginSync {
+			return
+		}
+		if _, err := s.Apply(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to apply rules")
+		}
+	})
+}
+
+// List returns the rules in the order they run
+func (s *RuleService) List(ctx context.Context) ([]*entities.Rule, error) {
+	var rules []*entities.Rule
+	if err := s.db.WithContext(ctx).Order("position, id").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Get returns a rule
+func (s *RuleService) Get(ctx context.Context, id uint) (*entities.Rule, error) {
+	var rule entities.Rule
+	result := s.db.WithContext(ctx).Limit(1).Find(&rule, id)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load rule: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRuleNotFound
+	}
+	return &rule, nil
+}
+
+// Create validates a rule and adds it after the existing rules
+func (s *RuleService) Create(ctx context.Context, rule *entities.Rule) error {
+	if _, err := compileRule(rule); err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var last struct{ Position *int }
+		if err := tx.Model(&entities.Rule{}).Select("MAX(position) AS position").Scan(&last).Error; err != nil {
+			return fmt.Errorf("failed to find the last rule: %w", err)
+		}
+		rule.ID = 0
+		rule.Position = 0
+		if last.Position != nil {
+			rule.Position = *last.Position + 1
+		}
+		if err := tx.Create(rule).Error; err != nil {
+			return fmt.Errorf("failed to create rule: %w", err)
+		}
+
+		config.Logger.Info().Uint("ruleID", rule.ID).Str("name", rule.Name).Msg("Rule created")
+		return nil
+	})
+}
+
+// Update validates a rule and replaces the stored rule with its ID. The
+// rule keeps its place in the order.
+func (s *RuleService) Update(ctx context.Context, rule *entities.Rule) error {
+	if _, err := compileRule(rule); err != nil {
+		return err
+	}
+	stored, err := s.Get(ctx, rule.ID)
+	if err != nil {
+		return err
+	}
+
+	rule.CreatedAt = stored.CreatedAt
+	rule.Position = stored.Position
+	if err := s.db.WithContext(ctx).Omit("Account").Save(rule).Error; err != nil {
+		return fmt.Errorf("failed to update rule: %w", err)
+	}
+	config.Logger.Info().Uint("ruleID", rule.ID).Str("name", rule.Name).Msg("Rule updated")
+	return nil
+}
+
+// Delete removes a rule
+func (s *RuleService) Delete(ctx context.Context, id uint) error {
+	result := s.db.WithContext(ctx).Delete(&entities.Rule{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete rule: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRuleNotFound
+	}
+	config.Logger.Info().Uint("ruleID", id).Msg("Rule deleted")
+	return nil
+}
+
+// Reorder sets the order rules run in. ids must list every rule once.
+func (s *RuleService) Reorder(ctx context.Context, ids []uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var stored []uint
+		if err := tx.Model(&entities.Rule{}).Pluck("id", &stored).Error; err != nil {
+			return fmt.Errorf("failed to list rules: %w", err)
+		}
+		remaining := make(map[uint]bool, len(stored))
+		for _, id := range stored {
+			remaining[id] = true
+		}
+		for _, id := range ids {
+			if !remaining[id] {
+				return fmt.Errorf("%w: rule %d is unknown or listed twice", ErrInvalidRule, id)
+			}
+			delete(remaining, id)
+		}
+		if len(remaining) > 0 {
+			return fmt.Errorf("%w: the order leaves out %d rule(s)", ErrInvalidRule, len(remaining))
+		}
+
+		for position, id := range ids {
+			err := tx.Model(&entities.Rule{}).Where("id = ?", id).Update("position", position).Error
+			if err != nil {
+				return fmt.Errorf("failed to reorder rules: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Apply runs the enabled rules of a message's account on the message and
+// takes the actions of those that match
+func (s *RuleService) Apply(ctx context.Context, messageID uint) (*RuleResult, error) {
+	result := &RuleResult{MessageID: messageID}
+
+	var message entities.Message
+	if err := s.db.WithContext(ctx).Preload("Account").First(&message, messageID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+	if !isIncoming(&message) {
+		return result, nil
+	}
+
+	rules, err := s.accountRules(ctx, message.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newRuleMessage(s.db, &message)
+	updated := false
+	for _, rule := range rules {
+		ok, err := rule.matches(ctx, m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate rule %q: %w", rule.Name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		config.Logger.Debug().
+			Uint("messageID", messageID).
+			Str("rule", rule.Name).
+			Msg("Rule matched")
+		result.Rules = append(result.Rules, rule.Name)
+		changed, deleted, err := s.perform(ctx, rule, &message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply rule %q: %w", rule.Name, err)
+		}
+		updated = updated || changed
+		if deleted {
+			result.Deleted = true
+			return result, nil
+		}
+		if rule.StopProcessing {
+			break
+		}
+	}
+
+	if updated {
+		s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+			MessageID: messageID,
+			AccountID: message.AccountID,
+		})
+	}
+	return result, nil
+}
+
+// DryRun evaluates a rule, stored or not, on the stored messages it would
+// apply to without taking its actions. It returns up to limit matches,
+// newest first.
+func (s *RuleService) DryRun(ctx context.Context, rule *entities.Rule, limit int) (*DryRunResult, error) {
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultDryRunLimit
+	}
+
+	result := &DryRunResult{}
+	var matches []*entities.Message
+	var lastID uint
+	for {
+		query := s.db.WithContext(ctx).Preload("Account").Order("id DESC").Limit(dryRunBatchSize)
+		if lastID != 0 {
+			query = query.Where("id < ?", lastID)
+		}
+		if rule.AccountID != nil {
+			query = query.Where("account_id = ?", *rule.AccountID)
+		}
+		var batch []*entities.Message
+		if err := query.Find(&batch).Error; err != nil {
+			return nil, fmt.Errorf("failed to load messages: %w", err)
+		}
+
+		for _, message := range batch {
+			if !isIncoming(message) {
+				continue
+			}
+			result.Scanned++
+			ok, err := compiled.matches(ctx, newRuleMessage(s.db, message))
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate rule: %w", err)
+			}
+			if !ok {
+				continue
+			}
+			if len(matches) == limit {
+				result.Truncated = true
+				break
+			}
+			matches = append(matches, message)
+		}
+		if result.Truncated || len(batch) < dryRunBatchSize {
+			break
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+
+	result.Matches = s.emailService.loadEmails(ctx, matches)
+	config.Logger.Debug().
+		Str("rule", rule.Name).
+		Int("scanned", result.Scanned).
+		Int("matches", len(result.Matches)).
+		Msg("Rule dry run finished")
+	return result, nil
+}
+
+// accountRules returns the enabled rules applying to an account, compiled
+// and in order
+func (s *RuleService) accountRules(ctx context.Context, accountID uint) ([]*compiledRule, error) {
+	var rules []*entities.Rule
+	err := s.db.WithContext(ctx).
+		Where("enabled = ? AND (account_id IS NULL OR account_id = ?)", true, accountID).
+		Order("position, id").
+		Find(&rules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	compiled := make([]*compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c, err := compileRule(rule)
+		if err != nil {
+			// Rules are validated when saved; skip one that no longer is
+			config.Logger.Warn().Err(err).Uint("ruleID", rule.ID).Msg("Skipping invalid rule")
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// perform takes the actions of a rule on a message. It reports whether the
+// message's fields changed and whether it was deleted, which ends the
+// rule's actions.
+func (s *RuleService) perform(ctx context.Context, rule *compiledRule, message *entities.Message) (changed, deleted bool, err error) {
+	db := s.db.WithContext(ctx)
+	update := func(column string, value interface{}) error {
+		changed = true
+		return db.Model(&entities.Message{}).Where("id = ?", message.ID).Update(column, value).Error
+	}
+
+	for _, action := range rule.Actions {
+		switch action.Type {
+		case entities.RuleActionMarkRead:
+			message.IsRead = true
+			err = update("is_read", true)
+		case entities.RuleActionSetImportance:
+			message.Importance = entities.Importance(action.Value)
+			err = update("importance", message.Importance)
+		case entities.RuleActionMove:
+			message.Folder = action.Value
+			err = update("folder", message.Folder)
+		case entities.RuleActionLabel:
+			err = db.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&entities.MessageLabel{Name: action.Value, MessageID: message.ID}).Error
+		case entities.RuleActionForward:
+			// A failed forward does not undo the rule's other actions
+			if err := s.forward(ctx, message, action.Value); err != nil {
+				config.Logger.Error().
+					Err(err).
+					Uint("messageID", message.ID).
+					Str("rule", rule.Name).
+					Msg("Failed to forward message")
+			}
+		case entities.RuleActionDelete:
+			if err := s.emailService.Delete(ctx, int64(message.ID)); err != nil {
+				return changed, false, err
+			}
+			return changed, true, nil
+		}
+		if err != nil {
+			return changed, false, err
+		}
+	}
+	return changed, false, nil
+}
+
+// forward sends a message on to an address, quoting its header and
+// keeping its attachments
+func (s *RuleService) forward(ctx context.Context, message *entities.Message, to string) error {
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("%w: cannot forward to %q", ErrInvalidRule, to)
+	}
+	source, err := emailSource(ctx, s.db, s.emailService, s.store, message.ID)
+	if err != nil {
+		return err
+	}
+	original, err := rfc5322.Parse(bytes.NewReader(source.Data))
+	if err != nil {
+		return fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	subject := original.Subject
+	if !strings.HasPrefix(strings.ToLower(subject), "fwd:") {
+		subject = "Fwd: " + subject
+	}
+	var intro strings.Builder
+	intro.WriteString("---------- Forwarded message ----------\n")
+	if original.From != nil {
+		fmt.Fprintf(&intro, "From: %s\n", displayAddresses([]*mail.Address{original.From}))
+	}
+	if !original.Date.IsZero() {
+		fmt.Fprintf(&intro, "Date: %s\n", original.Date.Format("Mon, Jan 2, 2006 at 15:04"))
+	}
+	fmt.Fprintf(&intro, "Subject: %s\n", original.Subject)
+	if len(original.To) > 0 {
+		fmt.Fprintf(&intro, "To: %s\n", displayAddresses(original.To))
+	}
+	intro.WriteString("\n")
+	text := original.Text
+	if text == "" {
+		text = htmltext.ToText(original.HTML)
+	}
+
+	outgoing := &OutgoingEmail{
+		AccountID:   message.AccountID,
+		To:          []*mail.Address{addr},
+		Subject:     subject,
+		Text:        intro.String() + text,
+		Attachments: original.Attachments,
+	}
+	if _, err := s.sendService.Send(ctx, outgoing); err != nil {
+		return err
+	}
+	config.Logger.Info().
+		Uint("messageID", message.ID).
+		Str("to", addr.Address).
+		Msg("Message forwarded by rule")
+	return nil
+}
+
+// isIncoming tells whether a message was received from someone else, the
+// messages rules run on
+func isIncoming(message *entities.Message) bool {
+	return !message.IsDraft && !strings.EqualFold(message.SenderEmail, message.Account.Email)
+}
+
+// displayAddresses formats addresses for reading, as in
+// "Ada Lovelace <ada@example.com>, bob@example.com"
+func displayAddresses(addrs []*mail.Address) string {
+	parts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		parts[i] = addr.Address
+		if addr.Name != "" {
+			parts[i] = addr.Name + " <" + addr.Address + ">"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,432 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrInvalidUndoDelay = errors.New("undo delay must be between 0 and 2 minutes")
+	ErrOutboxNotFound   = errors.New("email is not waiting to be sent")
+	ErrOutboxSending    = errors.New("email is being sent")
+	ErrOutboxNotFailed  = errors.New("only emails that failed to send can be retried")
+)
+
+// DefaultUndoSendDelay is how long sending can be undone unless the user
+// chooses otherwise
+const DefaultUndoSendDelay = 10 * time.Second
+
+// MaxUndoSendDelay bounds the grace period in which sending can be undone
+const MaxUndoSendDelay = 2 * time.Minute
+
+// maxOutboxWait bounds how long the scheduler sleeps, so that it notices
+// clock changes and system sleep
+const maxOutboxWait = time.Hour
+
+// outboxRetryInterval is how long the scheduler waits after a failure to
+// read the outbox
+const outboxRetryInterval = time.Minute
+
+// interruptedSendError is recorded for messages the app stopped sending
+// halfway, which may have reached some recipients
+const interruptedSendError = "sending was interrupted; some recipients may have received the email"
+
+// SendScheduler sends messages later: once the grace period in which
+// sending can be undone is over, or at the time the user chose. Messages
+// wait in the outbox table, so that they survive restarts. When the
+// account's provider can hold messages, those sent later are handed to
+// it at the end of the grace period and go out even if the app is closed.
+type SendScheduler struct {
+	db          *gorm.DB
+	sendService *SendService
+	followUps   *FollowUpService
+	events      *events.Bus
+	changed     chan struct{}
+}
+
+// NewSendScheduler creates a new SendScheduler sending through
+// sendService. followUps sets the reminders asked for when scheduling; if
+// nil, none are set.
+func NewSendScheduler(db *gorm.DB, sendService *SendService, followUps *FollowUpService) *SendScheduler {
+	config.Logger.Debug().Msg("Initializing send scheduler")
+	return &SendScheduler{db: db, sendService: sendService, followUps: followUps, changed: make(chan struct{}, 1)}
+}
+
+// SetEventBus sets the bus that outbox changes are published to
+func (s *SendScheduler) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// Schedule puts a message in the outbox to be sent at sendAt, or as soon
+// as possible if sendAt is not in the future. Sending can be undone with
+// Cancel for undo after scheduling, and until sendAt for messages sent
+// later that the provider does not hold. If followUp is positive, the
+// user is reminded of the message if no one replies within followUp of
+// sending it.
+func (s *SendScheduler) Schedule(ctx context.Context, outgoing *OutgoingEmail, sendAt time.Time, undo, followUp time.Duration) (*entities.OutboxMessage, error) {
+	if undo < 0 || undo > MaxUndoSendDelay {
+		return nil, ErrInvalidUndoDelay
+	}
+	if followUp < 0 {
+		return nil, ErrInvalidFollowUpDelay
+	}
+	if err := s.sendService.Prepare(ctx, outgoing); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	undoUntil := now.Add(undo)
+	sendAt = sendAt.UTC()
+	if sendAt.Before(now) {
+		sendAt = now
+	}
+	// Messages sent later are dated when they go out
+	if sendAt.After(undoUntil) {
+		outgoing.Date = sendAt
+	}
+	content, err := json.Marshal(outgoing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	var recipients []*mail.Address
+	for _, list := range [][]*mail.Address{outgoing.To, outgoing.Cc, outgoing.Bcc} {
+		recipients = append(recipients, list...)
+	}
+	message := &entities.OutboxMessage{
+		Status:     entities.OutboxQueued,
+		SendAt:     sendAt,
+		UndoUntil:  undoUntil,
+		DueAt:      undoUntil,
+		Subject:    outgoing.Subject,
+		Recipients: displayAddresses(recipients),
+		Content:    content,
+		FollowUp:   followUp,
+		AccountID:  outgoing.AccountID,
+	}
+	if err := s.db.WithContext(ctx).Omit("Account").Create(message).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue message: %w", err)
+	}
+	config.Logger.Info().
+		Uint("outboxID", message.ID).
+		Uint("accountID", message.AccountID).
+		Time("sendAt", sendAt).
+		Dur("undo", undo).
+		Msg("Message queued")
+
+	s.publish(message, message.Status, 0)
+	s.notify()
+	return message, nil
+}
+
+// Cancel takes a message out of the outbox before it is sent and returns
+// it, so that it can be edited again. Messages the provider holds are
+// cancelled with the provider.
+func (s *SendScheduler) Cancel(ctx context.Context, id uint) (*OutgoingEmail, error) {
+	message, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch message.Status {
+	case entities.OutboxSending:
+		return nil, ErrOutboxSending
+	case entities.OutboxDeferred:
+		if err := s.sendService.CancelSendLater(ctx, message.AccountID, message.ProviderIDs); err != nil {
+			return nil, err
+		}
+	}
+	outgoing, err := outboxContent(message)
+	if err != nil {
+		return nil, err
+	}
+
+	result := s.db.WithContext(ctx).Where("id = ? AND status = ?", id, message.Status).Delete(&entities.OutboxMessage{})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to cancel message: %w", result.Error)
+	}
+	// The scheduler got to the message first
+	if result.RowsAffected == 0 {
+		return nil, ErrOutboxSending
+	}
+	config.Logger.Info().Uint("outboxID", id).Msg("Sending cancelled")
+
+	s.publish(message, entities.OutboxCancelled, 0)
+	s.notify()
+	return outgoing, nil
+}
+
+// Retry queues a message that failed to send to be sent now
+func (s *SendScheduler) Retry(ctx context.Context, id uint) (*entities.OutboxMessage, error) {
+	message, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if message.Status != entities.OutboxFailed {
+		return nil, ErrOutboxNotFailed
+	}
+	now := time.Now().UTC()
+	message.Status, message.Error, message.DueAt = entities.OutboxQueued, "", now
+	if message.SendAt.Before(now) {
+		message.SendAt = now
+	}
+	err = s.db.WithContext(ctx).Model(message).Updates(map[string]interface{}{
+		"status":  message.Status,
+		"error":   message.Error,
+		"due_at":  message.DueAt,
+		"send_at": message.SendAt,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue message: %w", err)
+	}
+	s.publish(message, message.Status, 0)
+	s.notify()
+	return message, nil
+}
+
+// List returns the messages of an account waiting to be sent or that
+// failed, the first to go out first
+func (s *SendScheduler) List(ctx context.Context, accountID uint) ([]*entities.OutboxMessage, error) {
+	var messages []*entities.OutboxMessage
+	err := s.db.WithContext(ctx).
+		Where("account_id = ?", accountID).
+		Order("send_at, id").
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox: %w", err)
+	}
+	return messages, nil
+}
+
+// ProcessDue acts on the messages due by now: it sends those whose time
+// came, hands those sent later to providers that can hold them, and
+// stores the sent copy of those providers sent. It returns how many
+// messages it acted on. Failures to send are recorded on the message.
+func (s *SendScheduler) ProcessDue(ctx context.Context, now time.Time) (int, error) {
+	var messages []*entities.OutboxMessage
+	err := s.db.WithContext(ctx).
+		Where("status IN ? AND due_at <= ?", []string{entities.OutboxQueued, entities.OutboxDeferred}, now.UTC()).
+		Order("due_at, id").
+		Find(&messages).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due messages: %w", err)
+	}
+
+	for i, message := range messages {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := s.process(ctx, message, now); err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
+// Run sends messages on time until ctx is cancelled. Messages whose time
+// passed while the app was closed are sent at once; those it was closed
+// while sending are marked failed, as they may have been sent.
+func (s *SendScheduler) Run(ctx context.Context) {
+	err := s.db.WithContext(ctx).
+		Model(&entities.OutboxMessage{}).
+		Where("status = ?", entities.OutboxSending).
+		Updates(map[string]interface{}{"status": entities.OutboxFailed, "error": interruptedSendError}).Error
+	if err != nil {
+		config.Logger.Error().Err(err).Msg("Failed to recover interrupted messages")
+	}
+
+	for {
+		wait := maxOutboxWait
+		if _, err := s.ProcessDue(ctx, time.Now()); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			config.Logger.Error().Err(err).Msg("Failed to send queued messages")
+			wait = outboxRetryInterval
+		} else if next, err := s.nextDue(ctx); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to find next queued message")
+			wait = outboxRetryInterval
+		} else if next != nil {
+			wait = min(wait, max(time.Until(*next), 0))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// process acts on a due message
+func (s *SendScheduler) process(ctx context.Context, message *entities.OutboxMessage, now time.Time) error {
+	db := s.db.WithContext(ctx)
+	outgoing, err := outboxContent(message)
+	if err != nil {
+		return s.fail(ctx, message, err)
+	}
+
+	// The provider sent the message it held
+	if message.Status == entities.OutboxDeferred {
+		sentID, err := s.sendService.StoreSent(ctx, outgoing)
+		if err != nil {
+			return fmt.Errorf("failed to store sent message: %w", err)
+		}
+		if err := db.Delete(message).Error; err != nil {
+			return fmt.Errorf("failed to remove sent message: %w", err)
+		}
+		s.sent(ctx, message, sentID)
+		return nil
+	}
+
+	// Claimed so that it can no longer be cancelled
+	claimed := db.Model(&entities.OutboxMessage{}).
+		Where("id = ? AND status = ?", message.ID, entities.OutboxQueued).
+		Update("status", entities.OutboxSending)
+	if claimed.Error != nil {
+		return fmt.Errorf("failed to claim message: %w", claimed.Error)
+	}
+	if claimed.RowsAffected == 0 {
+		return nil
+	}
+	message.Status = entities.OutboxSending
+
+	if now.Before(message.SendAt) {
+		return s.wait(ctx, message, outgoing)
+	}
+	result, err := s.sendService.Send(ctx, outgoing)
+	if err != nil {
+		return s.fail(ctx, message, err)
+	}
+	if err := db.Delete(message).Error; err != nil {
+		return fmt.Errorf("failed to remove sent message: %w", err)
+	}
+	s.sent(ctx, message, result.MessageID)
+	return nil
+}
+
+// wait keeps a message sent later after its grace period: with the
+// provider if it can hold it, else in the outbox until its time. A
+// message no transport can send fails at once rather than at its time.
+func (s *SendScheduler) wait(ctx context.Context, message *entities.OutboxMessage, outgoing *OutgoingEmail) error {
+	ok, err := s.sendService.CanSendLater(ctx, message.AccountID)
+	if errors.Is(err, ErrNoTransport) {
+		return s.fail(ctx, message, err)
+	}
+	message.Status, message.DueAt = entities.OutboxQueued, message.SendAt
+	if err == nil && ok {
+		ids, err := s.sendService.SendLater(ctx, outgoing, message.SendAt)
+		if err == nil {
+			message.Status, message.ProviderIDs = entities.OutboxDeferred, ids
+		} else {
+			config.Logger.Warn().
+				Err(err).
+				Uint("outboxID", message.ID).
+				Msg("Provider cannot hold message; keeping it until its time")
+		}
+	}
+	// Saved from the struct so that the ids go through their serializer
+	err = s.db.WithContext(ctx).Model(message).Select("status", "due_at", "provider_ids").Updates(message).Error
+	if err != nil {
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+	s.publish(message, message.Status, 0)
+	return nil
+}
+
+// sent announces a message that left the outbox and sets the reminder
+// asked for when it was scheduled
+func (s *SendScheduler) sent(ctx context.Context, message *entities.OutboxMessage, sentID uint) {
+	if message.FollowUp > 0 && s.followUps != nil {
+		if _, err := s.followUps.FollowUp(ctx, sentID, message.FollowUp); err != nil {
+			config.Logger.Error().Err(err).Uint("messageID", sentID).Msg("Failed to set follow-up")
+		}
+	}
+	s.publish(message, entities.OutboxSent, sentID)
+}
+
+// fail records why a message could not be sent
+func (s *SendScheduler) fail(ctx context.Context, message *entities.OutboxMessage, cause error) error {
+	config.Logger.Error().Err(cause).Uint("outboxID", message.ID).Msg("Failed to send queued message")
+	message.Status, message.Error = entities.OutboxFailed, cause.Error()
+	err := s.db.WithContext(ctx).Model(message).Updates(map[string]interface{}{
+		"status": message.Status,
+		"error":  message.Error,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+	s.publish(message, message.Status, 0)
+	return nil
+}
+
+// load returns a message of the outbox
+func (s *SendScheduler) load(ctx context.Context, id uint) (*entities.OutboxMessage, error) {
+	var message entities.OutboxMessage
+	found := s.db.WithContext(ctx).Limit(1).Find(&message, id)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return nil, ErrOutboxNotFound
+	}
+	return &message, nil
+}
+
+// nextDue returns when the scheduler next has a message to act on, or nil
+func (s *SendScheduler) nextDue(ctx context.Context) (*time.Time, error) {
+	var message entities.OutboxMessage
+	found := s.db.WithContext(ctx).
+		Where("status IN ?", []string{entities.OutboxQueued, entities.OutboxDeferred}).
+		Order("due_at").
+		Limit(1).
+		Find(&message)
+	if found.Error != nil {
+		return nil, found.Error
+	}
+	if found.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &message.DueAt, nil
+}
+
+// notify tells Run that the outbox changed
+func (s *SendScheduler) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// publish announces a message's new status
+func (s *SendScheduler) publish(message *entities.OutboxMessage, status string, sentID uint) {
+	s.events.Publish(events.TopicOutboxUpdated, events.OutboxPayload{
+		ID:        message.ID,
+		AccountID: message.AccountID,
+		Status:    status,
+		SendAt:    message.SendAt,
+		MessageID: sentID,
+		Error:     message.Error,
+	})
+}
+
+// outboxContent decodes the message an outbox entry holds
+func outboxContent(message *entities.OutboxMessage) (*OutgoingEmail, error) {
+	var outgoing OutgoingEmail
+	if err := json.Unmarshal(message.Content, &outgoing); err != nil {
+		return nil, fmt.Errorf("failed to decode queued message: %w", err)
+	}
+	return &outgoing, nil
+}
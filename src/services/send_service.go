@@ -0,0 +1,483 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/rfc5322"
+	"palm/src/repositories"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Custom error types
+var (
+	ErrNoTransport      = errors.New("no transport registered for account type")
+	ErrNoRecipients     = errors.New("message has no recipients")
+	ErrInvalidRecipient = errors.New("invalid recipient address")
+	ErrInvalidSender    = errors.New("account address is not a valid sender")
+	ErrNoDeferredSend   = errors.New("account's provider cannot send later")
+)
+
+// Transport delivers messages for an account through its provider. to
+// lists the envelope recipients, which include Bcc recipients that raw
+// does not name.
+type Transport interface {
+	Send(ctx context.Context, account *entities.Account, to []string, raw []byte) error
+}
+
+// DeferredTransport is a Transport whose provider can hold a message and
+// deliver it at a later time, as Microsoft Graph can, so that it goes out
+// even while the app is closed
+type DeferredTransport interface {
+	Transport
+	// SendAt hands a message to the provider to deliver at a time. It
+	// returns the provider's id for the message.
+	SendAt(ctx context.Context, account *entities.Account, to []string, raw []byte, at time.Time) (string, error)
+	// CancelSend keeps a message handed over with SendAt from going out
+	CancelSend(ctx context.Context, account *entities.Account, id string) error
+}
+
+// OutgoingEmail is a message composed by the user
+type OutgoingEmail struct {
+	AccountID   uint
+	MessageID   string    // Message-ID without angle brackets; generated if empty (optional)
+	Date        time.Time // Date of the message; the time of sending if zero (optional)
+	To          []*mail.Address
+	Cc          []*mail.Address
+	Bcc         []*mail.Address
+	Subject     string
+	Text        string // Plain text body
+	HTML        string // HTML body (optional)
+	InReplyTo   string // Message-ID of the message replied to, without angle brackets (optional)
+	References  []string
+	Header      rfc5322.Header // Further header fields, e.g. Auto-Submitted (optional)
+	Attachments []*rfc5322.Attachment
+}
+
+// ProtectedMessage is an outgoing message after OpenPGP or S/MIME was
+// applied
+type ProtectedMessage struct {
+	Raw       []byte
+	Signed    bool
+	Encrypted bool
+}
+
+// protector applies end-to-end protection to outgoing messages; it is
+// implemented by PGPService and SMIMEService
+type protector interface {
+	Protect(ctx context.Context, m *rfc5322.Message, recipients []string) (*ProtectedMessage, error)
+	CanEncrypt(ctx context.Context, recipients []string) (bool, error)
+}
+
+// SendResult tells how a message was sent
+type SendResult struct {
+	MessageID uint     // ID of the stored sent copy
+	Encrypted []string // Recipients who received the message encrypted
+	Signed    bool     // The message was signed
+}
+
+// SendService composes messages, applies OpenPGP or S/MIME and hands them
+// to the transport of their account's type. A copy of every sent message
+// is stored in the account.
+type SendService struct {
+	accountRepo  repositories.AccountRepository
+	emailService *EmailService
+	pgpService   *PGPService
+	smimeService *SMIMEService
+	store        *AttachmentStore
+
+	mu         sync.RWMutex
+	transports map[string]Transport
+}
+
+// NewSendService creates a new SendService with no transports registered.
+// Attachments of sent copies are written to store.
+func NewSendService(accountRepo repositories.AccountRepository, emailService *EmailService, pgpService *PGPService, smimeService *SMIMEService, store *AttachmentStore) *SendService {
+	config.Logger.Debug().Msg("Initializing send service")
+	return &SendService{
+		accountRepo:  accountRepo,
+		emailService: emailService,
+		pgpService:   pgpService,
+		smimeService: smimeService,
+		store:        store,
+		transports:   make(map[string]Transport),
+	}
+}
+
+// RegisterTransport sets the transport used to send from accounts of the
+// given type
+func (s *SendService) RegisterTransport(accountType string, transport Transport) {
+	config.Logger.Debug().Str("accountType", accountType).Msg("Registering transport")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transports[accountType] = transport
+}
+
+func (s *SendService) transport(accountType string) (Transport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	transport, ok := s.transports[accountType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoTransport, accountType)
+	}
+	return transport, nil
+}
+
+// recipientGroup is a set of envelope recipients sent the same message
+type recipientGroup struct {
+	addresses []string
+	encrypt   bool
+	protector protector
+}
+
+// protect applies the group's protection to a message
+func (g recipientGroup) protect(ctx context.Context, m *rfc5322.Message) (*ProtectedMessage, error) {
+	var encryptTo []string
+	if g.encrypt {
+		encryptTo = g.addresses
+	}
+	return g.protector.Protect(ctx, m, encryptTo)
+}
+
+// Send sends a message. Recipients with an OpenPGP key receive it
+// encrypted with OpenPGP, those with an S/MIME certificate and no key
+// encrypted with S/MIME. The others receive it signed when the account has
+// an S/MIME identity or an OpenPGP key, preferring S/MIME, which more
+// clients display. Each Bcc recipient is sent a message of their own so
+// that no encrypted message reveals them.
+func (s *SendService) Send(ctx context.Context, outgoing *OutgoingEmail) (*SendResult, error) {
+	account, m, err := s.compose(ctx, outgoing)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := s.transport(account.AccountType)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.groupRecipients(ctx, account.Email, outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SendResult{}
+	for _, group := range groups {
+		protected, err := group.protect(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		if err := transport.Send(ctx, account, group.addresses, protected.Raw); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("accountID", account.ID).
+				Strs("recipients", group.addresses).
+				Msg("Failed to send message")
+			return nil, fmt.Errorf("failed to send message: %w", err)
+		}
+		if protected.Encrypted {
+			result.Encrypted = append(result.Encrypted, group.addresses...)
+		}
+		result.Signed = result.Signed || protected.Signed
+	}
+
+	result.MessageID, err = s.storeSent(ctx, account, m, outgoing)
+	if err != nil {
+		return nil, err
+	}
+	config.Logger.Info().
+		Uint("accountID", account.ID).
+		Uint("messageID", result.MessageID).
+		Int("encrypted", len(result.Encrypted)).
+		Bool("signed", result.Signed).
+		Msg("Message sent")
+	return result, nil
+}
+
+// Prepare checks that a message can be composed from its account and
+// fixes its Message-ID, so that the message keeps it however late it is
+// sent. Whether the account has a transport is only checked on sending.
+func (s *SendService) Prepare(ctx context.Context, outgoing *OutgoingEmail) error {
+	_, m, err := s.compose(ctx, outgoing)
+	if err != nil {
+		return err
+	}
+	outgoing.MessageID = m.MessageID
+	return nil
+}
+
+// CanSendLater reports whether the provider of an account can hold
+// messages to deliver later
+func (s *SendService) CanSendLater(ctx context.Context, accountID uint) (bool, error) {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return false, err
+	}
+	transport, err := s.transport(account.AccountType)
+	if err != nil {
+		return false, err
+	}
+	_, ok := transport.(DeferredTransport)
+	return ok, nil
+}
+
+// SendLater hands a message to the account's provider to deliver at a
+// time, protected as Send would. It returns the provider's ids for the
+// messages, to cancel them with CancelSendLater. No sent copy is stored;
+// StoreSent stores it once the time has come.
+func (s *SendService) SendLater(ctx context.Context, outgoing *OutgoingEmail, at time.Time) ([]string, error) {
+	account, m, err := s.compose(ctx, outgoing)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := s.transport(account.AccountType)
+	if err != nil {
+		return nil, err
+	}
+	deferred, ok := transport.(DeferredTransport)
+	if !ok {
+		return nil, ErrNoDeferredSend
+	}
+	groups, err := s.groupRecipients(ctx, account.Email, outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, group := range groups {
+		id, err := s.sendGroupLater(ctx, account, deferred, group, m, at)
+		if err != nil {
+			// Nothing is half sent later
+			if cancelErr := s.cancelSendLater(ctx, account, deferred, ids); cancelErr != nil {
+				config.Logger.Error().Err(cancelErr).Uint("accountID", account.ID).Msg("Failed to cancel deferred messages")
+			}
+			return nil, fmt.Errorf("failed to send message later: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	config.Logger.Info().
+		Uint("accountID", account.ID).
+		Time("at", at).
+		Int("messages", len(ids)).
+		Msg("Message handed to provider to send later")
+	return ids, nil
+}
+
+// sendGroupLater protects the message of a recipient group and hands it
+// to the provider
+func (s *SendService) sendGroupLater(ctx context.Context, account *entities.Account, deferred DeferredTransport, group recipientGroup, m *rfc5322.Message, at time.Time) (string, error) {
+	protected, err := group.protect(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	return deferred.SendAt(ctx, account, group.addresses, protected.Raw, at)
+}
+
+// CancelSendLater keeps messages handed over with SendLater from going out
+func (s *SendService) CancelSendLater(ctx context.Context, accountID uint, ids []string) error {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	transport, err := s.transport(account.AccountType)
+	if err != nil {
+		return err
+	}
+	deferred, ok := transport.(DeferredTransport)
+	if !ok {
+		return ErrNoDeferredSend
+	}
+	return s.cancelSendLater(ctx, account, deferred, ids)
+}
+
+func (s *SendService) cancelSendLater(ctx context.Context, account *entities.Account, deferred DeferredTransport, ids []string) error {
+	for _, id := range ids {
+		if err := deferred.CancelSend(ctx, account, id); err != nil {
+			return fmt.Errorf("failed to cancel message: %w", err)
+		}
+	}
+	return nil
+}
+
+// StoreSent stores the sent copy of a message the provider sent later.
+// It returns the ID of the copy.
+func (s *SendService) StoreSent(ctx context.Context, outgoing *OutgoingEmail) (uint, error) {
+	account, m, err := s.compose(ctx, outgoing)
+	if err != nil {
+		return 0, err
+	}
+	return s.storeSent(ctx, account, m, outgoing)
+}
+
+// compose builds the message to send from an account and returns it with
+// the account
+func (s *SendService) compose(ctx context.Context, outgoing *OutgoingEmail) (*entities.Account, *rfc5322.Message, error) {
+	account, err := s.accountRepo.GetByID(ctx, outgoing.AccountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(outgoing.To)+len(outgoing.Cc)+len(outgoing.Bcc) == 0 {
+		return nil, nil, ErrNoRecipients
+	}
+	_, domain, ok := strings.Cut(account.Email, "@")
+	if !ok {
+		return nil, nil, ErrInvalidSender
+	}
+
+	m := &rfc5322.Message{
+		Header:      outgoing.Header,
+		MessageID:   outgoing.MessageID,
+		InReplyTo:   outgoing.InReplyTo,
+		References:  outgoing.References,
+		Subject:     outgoing.Subject,
+		Date:        outgoing.Date,
+		From:        &mail.Address{Address: account.Email},
+		To:          outgoing.To,
+		Cc:          outgoing.Cc,
+		Text:        outgoing.Text,
+		HTML:        outgoing.HTML,
+		Attachments: outgoing.Attachments,
+	}
+	if m.MessageID == "" {
+		m.MessageID = newMessageID(domain)
+	}
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+	if m.InReplyTo != "" && len(m.References) == 0 {
+		m.References = []string{m.InReplyTo}
+	}
+	return account, m, nil
+}
+
+// storeSent stores the sent copy of a message readable, with every
+// recipient, the way the account's other messages are
+func (s *SendService) storeSent(ctx context.Context, account *entities.Account, m *rfc5322.Message, outgoing *OutgoingEmail) (uint, error) {
+	m.Bcc = outgoing.Bcc
+	sent, err := emailFromMessage(account, m, s.store)
+	if err != nil {
+		return 0, err
+	}
+	var raw bytes.Buffer
+	if err := rfc5322.Write(&raw, m); err != nil {
+		return 0, err
+	}
+	sent.Raw = raw.Bytes()
+	sent.Origin = events.OriginSent
+	if err := s.emailService.Create(ctx, sent); err != nil {
+		return 0, err
+	}
+	return sent.Message.ID, nil
+}
+
+// Redirect sends a received message on to an address unchanged, but for
+// the Resent-* fields (RFC 5322 section 3.6.6) naming the account it was
+// redirected from. No sent copy is stored.
+func (s *SendService) Redirect(ctx context.Context, accountID uint, to *mail.Address, raw []byte) error {
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	transport, err := s.transport(account.AccountType)
+	if err != nil {
+		return err
+	}
+	_, domain, ok := strings.Cut(account.Email, "@")
+	if !ok {
+		return ErrInvalidSender
+	}
+
+	var resent bytes.Buffer
+	fmt.Fprintf(&resent, "Resent-From: %s\r\n", (&mail.Address{Address: account.Email}).String())
+	fmt.Fprintf(&resent, "Resent-To: %s\r\n", to.String())
+	fmt.Fprintf(&resent, "Resent-Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&resent, "Resent-Message-ID: <%s>\r\n", newMessageID(domain))
+	resent.Write(raw)
+
+	if err := transport.Send(ctx, account, []string{to.Address}, resent.Bytes()); err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", account.ID).
+			Str("to", to.Address).
+			Msg("Failed to redirect message")
+		return fmt.Errorf("failed to redirect message: %w", err)
+	}
+	config.Logger.Info().Uint("accountID", account.ID).Str("to", to.Address).Msg("Message redirected")
+	return nil
+}
+
+// groupRecipients splits the recipients into the messages to send: one
+// encrypted with OpenPGP to the To and Cc recipients whose keys are known,
+// one encrypted with S/MIME to those with certificates, one for the others
+// and one per Bcc recipient
+func (s *SendService) groupRecipients(ctx context.Context, from string, outgoing *OutgoingEmail) ([]recipientGroup, error) {
+	// Unencrypted messages are signed with S/MIME when possible
+	var signer protector = s.pgpService
+	if ok, err := s.smimeService.CanSign(ctx, from); err != nil {
+		return nil, err
+	} else if ok {
+		signer = s.smimeService
+	}
+
+	pgp := recipientGroup{encrypt: true, protector: s.pgpService}
+	smime := recipientGroup{encrypt: true, protector: s.smimeService}
+	plain := recipientGroup{protector: signer}
+	for _, addr := range append(append([]*mail.Address{}, outgoing.To...), outgoing.Cc...) {
+		group, err := s.groupFor(ctx, addr.Address, &pgp, &smime, &plain)
+		if err != nil {
+			return nil, err
+		}
+		group.addresses = append(group.addresses, addr.Address)
+	}
+
+	var groups []recipientGroup
+	for _, group := range []recipientGroup{pgp, smime, plain} {
+		if len(group.addresses) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	for _, addr := range outgoing.Bcc {
+		group, err := s.groupFor(ctx, addr.Address, &pgp, &smime, &plain)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, recipientGroup{
+			addresses: []string{addr.Address},
+			encrypt:   group.encrypt,
+			protector: group.protector,
+		})
+	}
+	return groups, nil
+}
+
+// groupFor returns the group an address belongs to: OpenPGP if it has a
+// key, S/MIME if it has a certificate, plain otherwise
+func (s *SendService) groupFor(ctx context.Context, address string, pgp, smime, plain *recipientGroup) (*recipientGroup, error) {
+	for _, group := range []*recipientGroup{pgp, smime} {
+		ok, err := group.protector.CanEncrypt(ctx, []string{address})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return group, nil
+		}
+	}
+	return plain, nil
+}
+
+// newMessageID returns a unique Message-ID at domain
+func newMessageID(domain string) string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:]) + "@" + domain
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/authres"
+	"palm/src/formats/dkim"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// dnsCacheTTL is how long the default resolver caches TXT records
+	dnsCacheTTL = time.Hour
+	// dnsTimeout bounds each TXT lookup of the default resolver
+	dnsTimeout = 5 * time.Second
+)
+
+// DNSResolver looks up DNS TXT records; *net.Resolver implements it
+type DNSResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// dmarcPolicy is the part of a DMARC record (RFC 7489) that decides
+// whether a message passes
+type dmarcPolicy struct {
+	strictDKIM bool // adkim=s: the signing domain must be the From domain
+	strictSPF  bool // aspf=s: the envelope domain must be the From domain
+}
+
+// SenderVerifier records whether messages come from the domain of their
+// From address. DKIM signatures are verified against the stored source,
+// SPF is taken from the Authentication-Results field added on delivery,
+// and DMARC is evaluated from both and the domain's published policy.
+// Anyone can add an Authentication-Results field, so only those of the
+// servers the account trusts are read.
+//
+// Verifying looks up DNS records, so created messages are queued and
+// verified by Run rather than while they are stored.
+type SenderVerifier struct {
+	db       *gorm.DB
+	resolver DNSResolver
+	analyzer *PhishingAnalyzer
+	mu       sync.Mutex
+	pending  []uint
+	queued   chan struct{} // signals Run that messages were queued
+}
+
+// NewSenderVerifier creates a new SenderVerifier. A nil resolver uses the
+// system's, with its answers cached for an hour.
+func NewSenderVerifier(db *gorm.DB, resolver DNSResolver) *SenderVerifier {
+	config.Logger.Debug().Msg("Initializing sender verifier")
+	if resolver == nil {
+		resolver = newCachingResolver(net.DefaultResolver, dnsCacheTTL)
+	}
+	return &SenderVerifier{db: db, resolver: resolver, queued: make(chan struct{}, 1)}
+}
+
+// SetPhishingAnalyzer makes the verifier analyze messages again once they
+// are verified, since the analyzer scores failed authentication
+func (v *SenderVerifier) SetPhishingAnalyzer(analyzer *PhishingAnalyzer) {
+	v.analyzer = analyzer
+}
+
+// Subscribe queues every message created on bus for verification. It
+// returns a function that stops queueing.
+func (v *SenderVerifier) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		v.mu.Lock()
+		v.pending = append(v.pending, payload.MessageID)
+		v.mu.Unlock()
+		select {
+		case v.queued <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// Run verifies queued messages until ctx is cancelled
+func (v *SenderVerifier) Run(ctx context.Context) {
+	for {
+		v.VerifyPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-v.queued:
+		}
+	}
+}
+
+// VerifyPending verifies the queued messages, and analyzes them again
+// when an analyzer is set. Failures are logged, as messages are queued by
+// events. It returns how many messages were verified.
+func (v *SenderVerifier) VerifyPending(ctx context.Context) int {
+	verified := 0
+	for ctx.Err() == nil {
+		v.mu.Lock()
+		if len(v.pending) == 0 {
+			v.mu.Unlock()
+			break
+		}
+		messageID := v.pending[0]
+		v.pending = v.pending[1:]
+		v.mu.Unlock()
+
+		if _, err := v.Verify(ctx, messageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", messageID).
+				Msg("Failed to verify message sender")
+			continue
+		}
+		verified++
+		if v.analyzer == nil {
+			continue
+		}
+		if _, err := v.analyzer.Analyze(ctx, messageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", messageID).
+				Msg("Failed to analyze message")
+		}
+	}
+	return verified
+}
+
+// Verify authenticates the sender of a message and stores the result,
+// replacing an earlier one
+func (v *SenderVerifier) Verify(ctx context.Context, messageID uint) (*entities.MessageAuthentication, error) {
+	var message entities.Message
+	if err := v.db.WithContext(ctx).First(&message, messageID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+	fromDomain := ""
+	if _, domain, ok := strings.Cut(normalizeEmail(message.SenderEmail), "@"); ok {
+		fromDomain = domain
+	}
+
+	raw, err := loadSource(ctx, v.db, messageID)
+	if err != nil && !errors.Is(err, ErrSourceNotStored) {
+		return nil, err
+	}
+	var fields []entities.MessageHeader
+	err = v.db.WithContext(ctx).
+		Where("message_id = ? AND LOWER(name) IN ?", messageID, []string{"authentication-results", "arc-seal"}).
+		Order("position").
+		Find(&fields).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load headers: %w", err)
+	}
+	var account entities.Account
+	if err := v.db.WithContext(ctx).First(&account, message.AccountID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load account: %w", err)
+	}
+	reported, seal := deliveryResults(fields, account.TrustedAuthServIDs)
+
+	auth := &entities.MessageAuthentication{
+		VerifiedAt: time.Now(),
+		SPF:        "none",
+		ARC:        "none",
+		MessageID:  messageID,
+	}
+
+	// DKIM: signatures verified here, or reported when the source is not
+	// stored or its keys could not be retrieved
+	var dkimPasses []string
+	if raw != nil {
+		auth.DKIM, auth.DKIMDomain, dkimPasses = localDKIM(dkim.Verify(ctx, v.resolver, raw), fromDomain)
+		auth.DKIMLocal = true
+	}
+	inconclusive := raw == nil || auth.DKIM == string(dkim.TempError) || auth.DKIM == string(dkim.PermError)
+	if inconclusive && reported != nil {
+		if results := reported.All("dkim"); len(results) > 0 {
+			auth.DKIM, auth.DKIMDomain, dkimPasses = reportedDKIM(results, fromDomain)
+			auth.DKIMLocal = false
+		}
+	}
+	if auth.DKIM == "" {
+		auth.DKIM = "none"
+	}
+
+	// SPF: only the delivering server saw the connection
+	spfDomain := ""
+	if reported != nil {
+		if spf, ok := reported.Get("spf"); ok {
+			auth.SPF = spf.Value
+			spfDomain = spf.Properties["smtp.mailfrom"]
+			if _, domain, ok := strings.Cut(spfDomain, "@"); ok {
+				spfDomain = domain
+			}
+			spfDomain = strings.ToLower(spfDomain)
+		}
+		if arc, ok := reported.Get("arc"); ok {
+			auth.ARC = arc.Value
+		}
+	}
+	if auth.ARC == "none" && seal != nil {
+		auth.ARC = seal.ChainValidation
+	}
+
+	// DMARC: a passing method aligned with the From domain
+	auth.DMARC, err = v.dmarc(ctx, fromDomain, dkimPasses, auth.SPF, spfDomain)
+	if err != nil && reported != nil {
+		if dmarc, ok := reported.Get("dmarc"); ok {
+			auth.DMARC = dmarc.Value
+		}
+	}
+
+	// A forwarder such as a mailing list breaks DMARC; the delivering
+	// server vouching for its ARC chain leaves the sender unproven instead
+	forwarded := reported != nil && hasResult(reported, "arc", "pass")
+	switch {
+	case auth.DMARC == "pass":
+		auth.SenderStatus = entities.SenderVerified
+	case auth.DMARC == "fail" && !forwarded:
+		auth.SenderStatus = entities.SenderFailed
+	default:
+		auth.SenderStatus = entities.SenderUnverified
+	}
+
+	err = v.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "message_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"verified_at", "sender_status", "dkim", "dkim_domain", "dkim_local", "spf", "dmarc", "arc",
+			}),
+		}).
+		Create(auth).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to store message authentication: %w", err)
+	}
+
+	config.Logger.Debug().
+		Uint("messageID", messageID).
+		Str("status", auth.SenderStatus).
+		Str("dkim", auth.DKIM).
+		Str("spf", auth.SPF).
+		Str("dmarc", auth.DMARC).
+		Msg("Message sender verified")
+	return auth, nil
+}
+
+// dmarc evaluates the DMARC result of a message from the domains of its
+// passing DKIM signatures and its SPF result. It fails with the lookup
+// error when the policy could not be retrieved.
+func (v *SenderVerifier) dmarc(ctx context.Context, fromDomain string, dkimPasses []string, spf, spfDomain string) (string, error) {
+	if fromDomain == "" {
+		return "none", nil
+	}
+	policy, found, err := v.lookupDMARC(ctx, fromDomain)
+	if err != nil {
+		return "temperror", err
+	}
+	if !found {
+		return "none", nil
+	}
+
+	for _, domain := range dkimPasses {
+		if aligned(domain, fromDomain, policy.strictDKIM) {
+			return "pass", nil
+		}
+	}
+	if spf == "pass" && spfDomain != "" && aligned(spfDomain, fromDomain, policy.strictSPF) {
+		return "pass", nil
+	}
+	return "fail", nil
+}
+
+// lookupDMARC retrieves the DMARC policy of a domain, or of its
+// organizational domain when it publishes none
+func (v *SenderVerifier) lookupDMARC(ctx context.Context, domain string) (dmarcPolicy, bool, error) {
+	candidates := []string{domain}
+	if organizational := registrableDomain(domain); organizational != domain {
+		candidates = append(candidates, organizational)
+	}
+
+	for _, candidate := range candidates {
+		records, err := v.resolver.LookupTXT(ctx, "_dmarc."+candidate)
+		if err != nil {
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				continue
+			}
+			return dmarcPolicy{}, false, fmt.Errorf("failed to look up DMARC policy of %s: %w", candidate, err)
+		}
+		for _, record := range records {
+			if policy, ok := parseDMARC(record); ok {
+				return policy, true, nil
+			}
+		}
+	}
+	return dmarcPolicy{}, false, nil
+}
+
+// parseDMARC parses a DMARC record
+func parseDMARC(record string) (dmarcPolicy, bool) {
+	var policy dmarcPolicy
+	tags := strings.Split(record, ";")
+	if strings.TrimSpace(tags[0]) != "v=DMARC1" {
+		return policy, false
+	}
+	for _, tag := range tags[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(tag), "=")
+		switch strings.TrimSpace(name) {
+		case "adkim":
+			policy.strictDKIM = strings.TrimSpace(value) == "s"
+		case "aspf":
+			policy.strictSPF = strings.TrimSpace(value) == "s"
+		}
+	}
+	return policy, true
+}
+
+// deliveryResults returns the topmost Authentication-Results field added
+// by a trusted server, and the newest ARC seal of a message's fields. As
+// RFC 8601 section 5 requires, fields of other servers are ignored, since
+// the sender may have added them; with no trusted server, none is read.
+func deliveryResults(fields []entities.MessageHeader, trusted []string) (*authres.Results, *authres.ARCSeal) {
+	var results *authres.Results
+	var seal *authres.ARCSeal
+	for _, f := range fields {
+		switch strings.ToLower(f.Name) {
+		case "authentication-results":
+			if results != nil {
+				continue
+			}
+			parsed, err := authres.Parse(f.Value)
+			if err == nil && slices.ContainsFunc(trusted, func(id string) bool {
+				return strings.EqualFold(id, parsed.AuthServID)
+			}) {
+				results = parsed
+			}
+		case "arc-seal":
+			parsed, err := authres.ParseARCSeal(f.Value)
+			if err == nil && (seal == nil || parsed.Instance > seal.Instance) {
+				seal = &parsed
+			}
+		}
+	}
+	return results, seal
+}
+
+// localDKIM summarizes verified signatures: the best result, preferring a
+// pass by the From domain, its signing domain, and the domains of every
+// passing signature
+func localDKIM(verifications []dkim.Verification, fromDomain string) (string, string, []string) {
+	if len(verifications) == 0 {
+		return "none", "", nil
+	}
+	best := verifications[0]
+	var passes []string
+	for _, verification := range verifications {
+		if verification.Result != dkim.Pass {
+			continue
+		}
+		passes = append(passes, verification.Domain)
+		if best.Result != dkim.Pass || aligned(verification.Domain, fromDomain, false) && !aligned(best.Domain, fromDomain, false) {
+			best = verification
+		}
+	}
+	return string(best.Result), best.Domain, passes
+}
+
+// reportedDKIM summarizes the DKIM results of Authentication-Results like
+// localDKIM
+func reportedDKIM(results []authres.Result, fromDomain string) (string, string, []string) {
+	best := results[0]
+	var passes []string
+	for _, result := range results {
+		if result.Value != "pass" {
+			continue
+		}
+		passes = append(passes, reportedDomain(result))
+		if best.Value != "pass" || aligned(reportedDomain(result), fromDomain, false) && !aligned(reportedDomain(best), fromDomain, false) {
+			best = result
+		}
+	}
+	return best.Value, reportedDomain(best), passes
+}
+
+// reportedDomain returns the signing domain of a reported DKIM result
+func reportedDomain(result authres.Result) string {
+	if d := result.Properties["header.d"]; d != "" {
+		return strings.ToLower(d)
+	}
+	_, domain, _ := strings.Cut(result.Properties["header.i"], "@")
+	return strings.ToLower(domain)
+}
+
+// hasResult reports whether results report value for method
+func hasResult(results *authres.Results, method, value string) bool {
+	result, ok := results.Get(method)
+	return ok && result.Value == value
+}
+
+// aligned reports whether an authenticated domain vouches for the From
+// domain: the same domain when strict, the same organization otherwise
+func aligned(domain, fromDomain string, strict bool) bool {
+	if domain == "" || fromDomain == "" {
+		return false
+	}
+	if strict {
+		return strings.EqualFold(domain, fromDomain)
+	}
+	return strings.EqualFold(registrableDomain(domain), registrableDomain(fromDomain))
+}
+
+// cachedTXT is a cached TXT lookup
+type cachedTXT struct {
+	records []string
+	err     error
+	expires time.Time
+}
+
+// cachingResolver caches the answers of a resolver, including names that
+// do not exist, since every message of a sender looks up the same names
+type cachingResolver struct {
+	resolver DNSResolver
+	ttl      time.Duration
+	mu       sync.Mutex
+	entries  map[string]cachedTXT
+}
+
+// newCachingResolver creates a resolver caching answers for ttl
+func newCachingResolver(resolver DNSResolver, ttl time.Duration) *cachingResolver {
+	return &cachingResolver{resolver: resolver, ttl: ttl, entries: make(map[string]cachedTXT)}
+}
+
+// LookupTXT returns cached records, or looks them up. Temporary failures
+// are not cached.
+func (r *cachingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	key := strings.ToLower(name)
+	r.mu.Lock()
+	entry, ok := r.entries[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.records, entry.err
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+	records, err := r.resolver.LookupTXT(lookupCtx, name)
+	var dnsErr *net.DNSError
+	if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		r.mu.Lock()
+		r.entries[key] = cachedTXT{records: records, err: err, expires: time.Now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return records, err
+}
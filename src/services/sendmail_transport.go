@@ -0,0 +1,47 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"palm/src/config"
+	"palm/src/entities"
+	"strings"
+)
+
+// DefaultSendmailPath is where mail servers such as Postfix and Exim, and
+// relays such as msmtp, install their sendmail program
+const DefaultSendmailPath = "/usr/sbin/sendmail"
+
+// SendmailTransport is the Transport of Local Maildir accounts. It hands
+// messages to the system's sendmail program, which delivers or relays them
+// as the system is configured, as other clients reading a maildir do.
+type SendmailTransport struct {
+	path string
+}
+
+// NewSendmailTransport creates a new SendmailTransport running the
+// sendmail program at path
+func NewSendmailTransport(path string) *SendmailTransport {
+	config.Logger.Debug().Str("path", path).Msg("Initializing sendmail transport")
+	return &SendmailTransport{path: path}
+}
+
+// Send pipes raw to sendmail with the account's address as the envelope
+// sender. Lines end in a bare newline, as sendmail expects, and -i keeps
+// a line holding a single dot from ending the message.
+func (t *SendmailTransport) Send(ctx context.Context, account *entities.Account, to []string, raw []byte) error {
+	args := append([]string{"-i", "-f", account.Email, "--"}, to...)
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	cmd.Stdin = bytes.NewReader(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("sendmail failed: %w: %s", err, message)
+		}
+		return fmt.Errorf("sendmail failed: %w", err)
+	}
+	return nil
+}
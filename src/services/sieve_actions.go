@@ -0,0 +1,295 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/rfc5322"
+	"palm/src/formats/sieve"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// sieveMessage is a stored message prepared for a Sieve script, with the
+// source it was read from
+type sieveMessage struct {
+	message *sieve.Message
+	parsed  *rfc5322.Message
+	raw     []byte
+}
+
+// sieveMessage reads the source of a stored message
+func (s *SieveService) sieveMessage(ctx context.Context, messageID uint) (*sieveMessage, error) {
+	source, err := emailSource(ctx, s.db, s.emailService, s.store, messageID)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(bytes.NewReader(source.Data))
+	header, err := rfc5322.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+	body, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := rfc5322.Parse(bytes.NewReader(source.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
+
+	m := &sieve.Message{
+		Header: header,
+		Size:   int64(len(source.Data)),
+		Body:   string(body),
+	}
+	if parsed.Text != "" {
+		m.Parts = append(m.Parts, sieve.Part{ContentType: "text/plain", Text: parsed.Text})
+	}
+	if parsed.HTML != "" {
+		m.Parts = append(m.Parts, sieve.Part{ContentType: "text/html", Text: parsed.HTML})
+	}
+	for _, a := range parsed.Attachments {
+		if strings.HasPrefix(a.ContentType, "text/") {
+			m.Parts = append(m.Parts, sieve.Part{ContentType: a.ContentType, Text: string(a.Data)})
+		}
+	}
+	return &sieveMessage{message: m, parsed: parsed, raw: source.Data}, nil
+}
+
+// perform takes the actions of a script on a message. Redirects and
+// vacation responses that fail are logged without undoing the rest.
+func (s *SieveService) perform(ctx context.Context, message *entities.Message, m *sieveMessage, actions []sieve.Action, result *SieveResult) error {
+	kept := false
+	var mailboxes, flags []string
+	for _, action := range actions {
+		switch a := action.(type) {
+		case sieve.Keep:
+			kept = true
+			flags = append(flags, a.Flags...)
+		case sieve.FileInto:
+			mailboxes = append(mailboxes, a.Mailbox)
+			flags = append(flags, a.Flags...)
+		case sieve.Redirect:
+			if err := s.redirect(ctx, message, m, a.Address); err != nil {
+				config.Logger.Error().Err(err).Uint("messageID", message.ID).Str("to", a.Address).Msg("Failed to redirect message")
+				continue
+			}
+			result.Redirected = append(result.Redirected, a.Address)
+		case sieve.Vacation:
+			replied, err := s.vacation(ctx, message, m, a)
+			if err != nil {
+				config.Logger.Error().Err(err).Uint("messageID", message.ID).Msg("Failed to send vacation response")
+				continue
+			}
+			result.Replied = replied
+		}
+	}
+
+	if !kept && len(mailboxes) == 0 {
+		if err := s.emailService.Delete(ctx, int64(message.ID)); err != nil {
+			return err
+		}
+		result.Deleted = true
+		return nil
+	}
+
+	// A kept message stays where it is; otherwise it goes to the first
+	// mailbox, and the others become labels
+	folder := message.Folder
+	filed := kept
+	var labels []string
+	for _, mailbox := range mailboxes {
+		if strings.EqualFold(mailbox, "INBOX") {
+			mailbox = ""
+		}
+		if len(mailbox) > maxLabelLength {
+			config.Logger.Warn().Str("mailbox", mailbox).Msg("Ignoring sieve mailbox with a too long name")
+			continue
+		}
+		if !filed {
+			folder, filed = mailbox, true
+		} else if mailbox != "" && mailbox != folder {
+			labels = append(labels, mailbox)
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if folder != message.Folder {
+		updates["folder"] = folder
+	}
+	for _, flag := range flags {
+		switch {
+		case strings.EqualFold(flag, `\Seen`):
+			if !message.IsRead {
+				updates["is_read"] = true
+			}
+		case strings.EqualFold(flag, `\Flagged`):
+			if !message.IsFlagged {
+				updates["is_flagged"] = true
+			}
+		case strings.HasPrefix(flag, `\`):
+			// Other system flags have no counterpart
+		case len(flag) <= maxLabelLength && !slices.Contains(labels, flag):
+			labels = append(labels, flag)
+		}
+	}
+
+	db := s.db.WithContext(ctx)
+	if len(updates) > 0 {
+		if err := db.Model(&entities.Message{}).Where("id = ?", message.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update message: %w", err)
+		}
+	}
+	for _, label := range labels {
+		err := db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&entities.MessageLabel{Name: label, MessageID: message.ID}).Error
+		if err != nil {
+			return fmt.Errorf("failed to label message: %w", err)
+		}
+	}
+	result.Folder, result.Labels = folder, labels
+
+	if len(updates) > 0 || len(labels) > 0 {
+		s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+			MessageID: message.ID,
+			AccountID: message.AccountID,
+		})
+	}
+	return nil
+}
+
+// redirect sends a message on to an address, unless it was redirected
+// from the account before, which would loop
+func (s *SieveService) redirect(ctx context.Context, message *entities.Message, m *sieveMessage, to string) error {
+	for _, value := range m.message.Header.Values("Resent-From") {
+		for _, addr := range rfc5322.ParseAddressList(value) {
+			if strings.EqualFold(addr.Address, message.Account.Email) {
+				return fmt.Errorf("message was already redirected from %s", addr.Address)
+			}
+		}
+	}
+	return s.sendService.Redirect(ctx, message.AccountID, &mail.Address{Address: to}, m.raw)
+}
+
+// automatedLocalParts are senders that never get vacation responses
+var automatedLocalParts = []string{
+	"mailer-daemon", "postmaster", "listserv", "majordomo",
+	"noreply", "no-reply", "donotreply", "do-not-reply",
+}
+
+// vacation sends a vacation response to the sender of a message unless
+// RFC 5230 section 4.5 rules it out: the message is automated, from a
+// list, not addressed to the user, or the sender got this response within
+// its period. Responses come from the account's address; :from is not
+// honoured.
+func (s *SieveService) vacation(ctx context.Context, message *entities.Message, m *sieveMessage, v sieve.Vacation) (bool, error) {
+	header := m.message.Header
+	if value := strings.TrimSpace(header.Get("Auto-Submitted")); value != "" && !strings.EqualFold(value, "no") {
+		return false, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(header.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		return false, nil
+	}
+	for _, f := range header {
+		if strings.HasPrefix(strings.ToLower(f.Name), "list-") {
+			return false, nil
+		}
+	}
+
+	// Responses go to the envelope sender; an empty one marks a bounce
+	sender := message.SenderEmail
+	if returnPath := strings.TrimSpace(header.Get("Return-Path")); returnPath != "" {
+		if returnPath == "<>" {
+			return false, nil
+		}
+		if addrs := rfc5322.ParseAddressList(returnPath); len(addrs) > 0 {
+			sender = addrs[0].Address
+		}
+	}
+	sender = strings.ToLower(sender)
+	local, _, ok := strings.Cut(sender, "@")
+	if !ok || slices.Contains(automatedLocalParts, local) ||
+		strings.HasPrefix(local, "owner-") || strings.HasSuffix(local, "-request") {
+		return false, nil
+	}
+
+	own := append([]string{message.Account.Email}, v.Addresses...)
+	isOwn := func(address string) bool {
+		return slices.ContainsFunc(own, func(o string) bool { return strings.EqualFold(o, address) })
+	}
+	if isOwn(sender) {
+		return false, nil
+	}
+	addressed := false
+	for _, name := range []string{"To", "Cc", "Bcc", "Resent-To", "Resent-Cc"} {
+		for _, value := range header.Values(name) {
+			for _, addr := range rfc5322.ParseAddressList(value) {
+				addressed = addressed || isOwn(addr.Address)
+			}
+		}
+	}
+	if !addressed {
+		return false, nil
+	}
+
+	db := s.db.WithContext(ctx)
+	var last entities.VacationReply
+	found := db.Where("account_id = ? AND handle = ? AND sender = ?", message.AccountID, v.Handle, sender).
+		Limit(1).Find(&last)
+	if found.Error != nil {
+		return false, fmt.Errorf("failed to look up vacation responses: %w", found.Error)
+	}
+	now := time.Now()
+	if found.RowsAffected > 0 && now.Sub(last.SentAt) < time.Duration(v.Days)*24*time.Hour {
+		return false, nil
+	}
+
+	outgoing := &OutgoingEmail{
+		AccountID:  message.AccountID,
+		To:         []*mail.Address{{Address: sender}},
+		Subject:    v.Subject,
+		Text:       v.Reason,
+		InReplyTo:  m.parsed.MessageID,
+		References: m.parsed.References,
+		Header:     rfc5322.Header{{Name: "Auto-Submitted", Value: "auto-replied"}},
+	}
+	if outgoing.Subject == "" {
+		outgoing.Subject = "Auto: " + m.parsed.Subject
+	}
+	if m.parsed.MessageID != "" {
+		outgoing.References = append(slices.Clone(m.parsed.References), m.parsed.MessageID)
+	}
+	if v.MIME {
+		// The reason is a MIME entity with its own header
+		entity, err := rfc5322.Parse(strings.NewReader(v.Reason))
+		if err != nil {
+			return false, fmt.Errorf("invalid vacation MIME reason: %w", err)
+		}
+		outgoing.Text, outgoing.HTML = entity.Text, entity.HTML
+	}
+	if _, err := s.sendService.Send(ctx, outgoing); err != nil {
+		return false, err
+	}
+
+	reply := &entities.VacationReply{AccountID: message.AccountID, Handle: v.Handle, Sender: sender, SentAt: now}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "account_id"}, {Name: "handle"}, {Name: "sender"}},
+		DoUpdates: clause.AssignmentColumns([]string{"sent_at"}),
+	}).Create(reply).Error
+	if err != nil {
+		return true, fmt.Errorf("failed to record vacation response: %w", err)
+	}
+	config.Logger.Info().Uint("messageID", message.ID).Str("to", sender).Msg("Vacation response sent")
+	return true, nil
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/managesieve"
+	"palm/src/formats/sieve"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrSieveScriptNotFound = errors.New("sieve script not found")
+	ErrInvalidSieveScript  = errors.New("invalid sieve script")
+)
+
+// uploadTimeout bounds a ManageSieve session
+const uploadTimeout = 30 * time.Second
+
+// SieveResult tells what the active Sieve script did with a message
+type SieveResult struct {
+	MessageID  uint
+	Script     string   // Name of the script that ran; empty if none did
+	Folder     string   // Folder the message was filed into, "" being the inbox
+	Labels     []string // Labels added for further mailboxes and keywords
+	Deleted    bool     // The message was discarded
+	Redirected []string // Addresses the message was redirected to
+	Replied    bool     // A vacation response was sent
+}
+
+// ManageSieveServer is a ManageSieve server scripts are uploaded to
+type ManageSieveServer struct {
+	Address  string // host or host:port, the port defaulting to 4190
+	Username string
+	Password string
+	Security managesieve.Security // STARTTLS if empty
+}
+
+// SieveService stores Sieve scripts, runs the active one on incoming
+// messages and uploads scripts to ManageSieve servers.
+//
+// Palm keeps a single copy of each message, so a message filed into
+// several mailboxes goes to the first and is labelled with the others.
+// The \Seen and \Flagged flags mark it read and flagged; other keywords
+// become labels.
+type SieveService struct {
+	db           *gorm.DB
+	emailService *EmailService
+	sendService  *SendService
+	store        *AttachmentStore
+	events       *events.Bus
+}
+
+// NewSieveService creates a new SieveService. Redirects and vacation
+// responses are sent through sendService; sources of messages stored
+// without them are rebuilt with the attachments of store.
+func NewSieveService(db *gorm.DB, emailService *EmailService, sendService *SendService, store *AttachmentStore) *SieveService {
+	config.Logger.Debug().Msg("Initializing sieve service")
+	return &SieveService{
+		db:           db,
+		emailService: emailService,
+		sendService:  sendService,
+		store:        store,
+	}
+}
+
+// SetEventBus sets the bus that changes made by scripts are published to
+func (s *SieveService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// Subscribe runs the active script on every message created on bus. It
+// returns a function that stops running it.
+func (s *SieveService) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		// Only mail just delivered is filtered: importing an archive or
+		// storing a sent copy must not move, forward or answer old mail
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok || payload.Origin != events.OriginSync {
+			return
+		}
+		if _, err := s.Run(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to run sieve script")
+		}
+	})
+}
+
+// List returns the stored scripts by name
+func (s *SieveService) List(ctx context.Context) ([]*entities.SieveScript, error) {
+	var scripts []*entities.SieveScript
+	if err := s.db.WithContext(ctx).Order("name, id").Find(&scripts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sieve scripts: %w", err)
+	}
+	return scripts, nil
+}
+
+// Get returns a script
+func (s *SieveService) Get(ctx context.Context, id uint) (*entities.SieveScript, error) {
+	var script entities.SieveScript
+	result := s.db.WithContext(ctx).Limit(1).Find(&script, id)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load sieve script: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrSieveScriptNotFound
+	}
+	return &script, nil
+}
+
+// Import validates a script and stores it, replacing the script of the
+// same name and account. An active script replaces the active script of
+// its account.
+func (s *SieveService) Import(ctx context.Context, script *entities.SieveScript) error {
+	script.Name = strings.TrimSpace(script.Name)
+	if script.Name == "" {
+		return fmt.Errorf("%w: the script has no name", ErrInvalidSieveScript)
+	}
+	if len(script.Name) > maxLabelLength {
+		return fmt.Errorf("%w: script name is too long", ErrInvalidSieveScript)
+	}
+	if _, err := sieve.Parse(script.Source); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSieveScript, err)
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing entities.SieveScript
+		result := scopeAccount(tx, script.AccountID).Where("name = ?", script.Name).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return fmt.Errorf("failed to look up sieve script: %w", result.Error)
+		}
+		script.ID = 0
+		if result.RowsAffected > 0 {
+			script.ID = existing.ID
+			script.CreatedAt = existing.CreatedAt
+		}
+		if script.Active {
+			if err := deactivateScripts(tx, script.AccountID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit("Account").Save(script).Error; err != nil {
+			return fmt.Errorf("failed to store sieve script: %w", err)
+		}
+
+		config.Logger.Info().
+			Uint("scriptID", script.ID).
+			Str("name", script.Name).
+			Bool("active", script.Active).
+			Msg("Sieve script imported")
+		return nil
+	})
+}
+
+// SetActive activates a script, deactivating the other scripts of its
+// account, or deactivates it
+func (s *SieveService) SetActive(ctx context.Context, id uint, active bool) error {
+	script, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if active {
+			if err := deactivateScripts(tx, script.AccountID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&entities.SieveScript{}).Where("id = ?", id).Update("active", active).Error; err != nil {
+			return fmt.Errorf("failed to update sieve script: %w", err)
+		}
+		config.Logger.Info().Uint("scriptID", id).Bool("active", active).Msg("Sieve script activation changed")
+		return nil
+	})
+}
+
+// Delete removes a script
+func (s *SieveService) Delete(ctx context.Context, id uint) error {
+	result := s.db.WithContext(ctx).Delete(&entities.SieveScript{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete sieve script: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSieveScriptNotFound
+	}
+	config.Logger.Info().Uint("scriptID", id).Msg("Sieve script deleted")
+	return nil
+}
+
+// scopeAccount restricts a query on scripts to an account, or to the
+// scripts of every account when accountID is nil
+func scopeAccount(db *gorm.DB, accountID *uint) *gorm.DB {
+	if accountID == nil {
+		return db.Where("account_id IS NULL")
+	}
+	return db.Where("account_id = ?", *accountID)
+}
+
+func deactivateScripts(tx *gorm.DB, accountID *uint) error {
+	err := scopeAccount(tx.Model(&entities.SieveScript{}), accountID).Update("active", false).Error
+	if err != nil {
+		return fmt.Errorf("failed to deactivate sieve scripts: %w", err)
+	}
+	return nil
+}
+
+// Run runs the active script of a message's account on the message and
+// takes its actions. Messages that are not incoming, or that an earlier
+// filter deleted, are left alone. A script failing at run time keeps the
+// message as it is.
+func (s *SieveService) Run(ctx context.Context, messageID uint) (*SieveResult, error) {
+	result := &SieveResult{MessageID: messageID}
+
+	var message entities.Message
+	found := s.db.WithContext(ctx).Preload("Account").Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 || !isIncoming(&message) {
+		return result, nil
+	}
+
+	script, err := s.activeScript(ctx, message.AccountID)
+	if err != nil || script == nil {
+		return result, err
+	}
+	compiled, err := sieve.Parse(script.Source)
+	if err != nil {
+		// Scripts are validated when imported; skip one that no longer is
+		config.Logger.Warn().Err(err).Uint("scriptID", script.ID).Msg("Skipping invalid sieve script")
+		return result, nil
+	}
+	result.Script = script.Name
+
+	m, err := s.sieveMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	outcome, err := compiled.Run(m.message, sieve.Options{})
+	if err != nil {
+		config.Logger.Warn().
+			Err(err).
+			Uint("messageID", messageID).
+			Str("script", script.Name).
+			Msg("Sieve script failed; keeping the message")
+		return result, nil
+	}
+
+	if err := s.perform(ctx, &message, m, outcome.Actions, result); err != nil {
+		return nil, fmt.Errorf("failed to take the actions of sieve script %q: %w", script.Name, err)
+	}
+	return result, nil
+}
+
+// Evaluate runs a script, stored or not, on a stored message without
+// taking its actions
+func (s *SieveService) Evaluate(ctx context.Context, source string, messageID uint) (*sieve.Result, error) {
+	compiled, err := sieve.Parse(source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSieveScript, err)
+	}
+	m, err := s.sieveMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Run(m.message, sieve.Options{})
+}
+
+// activeScript returns the active script of an account, or else the
+// active script of every account, or nil
+func (s *SieveService) activeScript(ctx context.Context, accountID uint) (*entities.SieveScript, error) {
+	var scripts []*entities.SieveScript
+	err := s.db.WithContext(ctx).
+		Where("active = ? AND (account_id IS NULL OR account_id = ?)", true, accountID).
+		Order("account_id IS NULL, id").
+		Limit(1).
+		Find(&scripts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the active sieve script: %w", err)
+	}
+	if len(scripts) == 0 {
+		return nil, nil
+	}
+	return scripts[0], nil
+}
+
+// Upload stores a script on a ManageSieve server, activating it there if
+// it is active here. The server checks the script against the extensions
+// it supports.
+func (s *SieveService) Upload(ctx context.Context, id uint, server ManageSieveServer) error {
+	script, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	addr := server.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, managesieve.DefaultPort)
+	}
+	switch server.Security {
+	case "", managesieve.SecurityStartTLS, managesieve.SecurityTLS, managesieve.SecurityNone:
+	default:
+		return fmt.Errorf("unknown connection security %q", server.Security)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+	client, err := managesieve.Dial(ctx, addr, managesieve.Options{Security: server.Security})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	if err := client.Authenticate(server.Username, server.Password); err != nil {
+		return fmt.Errorf("failed to log in to %s: %w", addr, err)
+	}
+	if err := client.PutScript(script.Name, script.Source); err != nil {
+		return fmt.Errorf("failed to upload sieve script: %w", err)
+	}
+	if script.Active {
+		if err := client.SetActive(script.Name); err != nil {
+			return fmt.Errorf("failed to activate sieve script: %w", err)
+		}
+	}
+	if err := client.Logout(); err != nil {
+		config.Logger.Warn().Err(err).Str("server", addr).Msg("ManageSieve logout failed")
+	}
+
+	config.Logger.Info().
+		Uint("scriptID", script.ID).
+		Str("name", script.Name).
+		Str("server", addr).
+		Msg("Sieve script uploaded")
+	return nil
+}
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/formats/rfc1847"
+	"palm/src/formats/rfc5322"
+	"palm/src/formats/smime"
+	"sync"
+	"time"
+
+	"github.com/smallstep/pkcs7"
+	"gorm.io/gorm"
+)
+
+// smimeMicalg names the hash of the signatures Protect makes
+const smimeMicalg = "sha-256"
+
+// ErrNoSMIMEDecryptionKey is returned when none of the identities a
+// message is encrypted to is in the store and unlocked
+var ErrNoSMIMEDecryptionKey = errors.New("no unlocked S/MIME identity can decrypt this message")
+
+// pkcs7Mu guards the pkcs7 package's encryption settings, which are global
+var pkcs7Mu sync.Mutex
+
+// SMIMEStatus describes the S/MIME protection of an opened message
+type SMIMEStatus struct {
+	Encrypted    bool
+	Decrypted    bool
+	DecryptError error // Why an encrypted message could not be decrypted
+	Signature    SignatureStatus
+	// Signer describes the signing certificate; set when the message is
+	// signed and carries it
+	SignerSubject string
+	SignerEmails  []string
+	SignerIssuer  string
+	SignerExpires time.Time
+	Trusted       bool  // The certificate chains to the trust store
+	TrustError    error // Why a good signature's certificate is not trusted
+	SignerMatches bool  // The certificate is issued to the sender's address
+}
+
+// Open decrypts an email encrypted with S/MIME and checks its signature,
+// detached or opaque, in either order (RFC 8551 section 3.7). The body and
+// attachments of a decrypted or opaque-signed email are replaced in memory
+// only, as by PGPService.Open. Certificates are checked when the email was
+// received, not at the signing time the signer claims. The certificates of
+// trusted signers are added to the store so that replies can be encrypted
+// to them.
+func (s *SMIMEService) Open(ctx context.Context, email *EmailDTO) (*SMIMEStatus, error) {
+	status := &SMIMEStatus{}
+	raw, err := loadSource(ctx, s.db, email.Message.ID)
+	if err != nil {
+		if errors.Is(err, ErrSourceNotStored) {
+			return status, nil
+		}
+		return nil, err
+	}
+
+	// A signature cannot vouch for itself by claiming a signing time
+	// from before its certificate expired
+	verifyAt := time.Now()
+	if received := email.Message.ReceivedDatetime; received != nil && received.Before(verifyAt) {
+		verifyAt = *received
+	}
+
+	content := raw
+	unwrapped := false
+	for {
+		next, ok := s.unwrap(ctx, content, verifyAt, status)
+		if !ok {
+			break
+		}
+		content, unwrapped = next, true
+	}
+	if status.DecryptError != nil {
+		config.Logger.Debug().Err(status.DecryptError).Uint("messageID", email.Message.ID).Msg("Failed to decrypt message")
+		return status, nil
+	}
+
+	for _, address := range status.SignerEmails {
+		if address == normalizeEmail(email.Message.SenderEmail) {
+			status.SignerMatches = true
+		}
+	}
+	if unwrapped {
+		if err := replaceContent(email, content); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// unwrap removes one layer of S/MIME protection from content, checking it
+// into status, with certificates checked at verifyAt. It reports false
+// when there is no layer left that content may carry: each of encryption
+// and signature appears at most once.
+func (s *SMIMEService) unwrap(ctx context.Context, content []byte, verifyAt time.Time, status *SMIMEStatus) ([]byte, bool) {
+	if entity, err := smime.Parse(content); err == nil {
+		switch {
+		case entity.SMIMEType == smime.EnvelopedData && !status.Encrypted:
+			status.Encrypted = true
+			plaintext, err := s.decrypt(ctx, entity.Data)
+			if err != nil {
+				status.DecryptError = err
+				return nil, false
+			}
+			status.Decrypted = true
+			return plaintext, true
+		case entity.SMIMEType == smime.SignedData && status.Signature == SignatureNone:
+			p7, err := pkcs7.Parse(entity.Data)
+			if err != nil {
+				status.Signature = SignatureBad
+				return nil, false
+			}
+			s.verify(ctx, p7, verifyAt, status)
+			return p7.Content, true
+		}
+		return nil, false
+	}
+
+	signed, err := rfc1847.ParseSigned(content)
+	if err != nil || !smime.IsSignatureType(signed.Protocol) || status.Signature != SignatureNone {
+		return nil, false
+	}
+	p7, err := pkcs7.Parse(signed.Signature)
+	if err != nil {
+		status.Signature = SignatureBad
+	} else {
+		p7.Content = signed.Content
+		s.verify(ctx, p7, verifyAt, status)
+	}
+	return signed.Content, true
+}
+
+// Protect applies S/MIME to an outgoing message. It is signed when its
+// sender has a usable identity and encrypted when every recipient has a
+// certificate, to the recipients and the sender's own certificate.
+// Otherwise the message is written as it is.
+func (s *SMIMEService) Protect(ctx context.Context, m *rfc5322.Message, recipients []string) (*ProtectedMessage, error) {
+	var signer *smimeIdentity
+	if m.From != nil {
+		identities, err := s.identities(ctx, m.From.Address)
+		if err != nil {
+			return nil, err
+		}
+		if len(identities) > 0 {
+			signer = identities[0]
+		} else if locked, err := s.certificatesFor(ctx, m.From.Address, true); err == nil && len(locked) > 0 {
+			config.Logger.Warn().Str("from", m.From.Address).Msg("Sender's S/MIME identity is locked, sending unsigned")
+		}
+	}
+
+	to, err := s.encryptionCertificates(ctx, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := rfc5322.WriteBody(&body, m); err != nil {
+		return nil, err
+	}
+	content := rfc1847.Canonical(body.Bytes())
+	protected := &ProtectedMessage{}
+
+	var entity []byte
+	if signer != nil {
+		signature, err := sign(content, signer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign message: %w", err)
+		}
+		entity = rfc1847.WriteSigned(content, smimeMicalg,
+			rfc1847.Part{ContentType: smime.SignatureType, Filename: "smime.p7s", Data: signature})
+		protected.Signed = true
+	}
+	if to != nil {
+		// Signed, then encrypted (RFC 8551 section 3.7)
+		inner := entity
+		if inner == nil {
+			inner = content
+		}
+		if m.From != nil {
+			own, err := s.encryptionCertificate(ctx, m.From.Address)
+			if err != nil {
+				return nil, err
+			}
+			if own != nil {
+				to = append(to, own)
+			}
+		}
+		ciphertext, err := encrypt(inner, to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		entity = smime.Write(smime.EnvelopedData, ciphertext)
+		protected.Encrypted = true
+	}
+
+	var raw bytes.Buffer
+	if entity == nil {
+		err = rfc5322.Write(&raw, m)
+	} else {
+		err = rfc5322.WriteWithBody(&raw, m, entity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	protected.Raw = raw.Bytes()
+	return protected, nil
+}
+
+// CanEncrypt reports whether every recipient has a certificate to encrypt
+// to
+func (s *SMIMEService) CanEncrypt(ctx context.Context, recipients []string) (bool, error) {
+	to, err := s.encryptionCertificates(ctx, recipients)
+	return to != nil, err
+}
+
+// CanSign reports whether address has a usable identity to sign with
+func (s *SMIMEService) CanSign(ctx context.Context, address string) (bool, error) {
+	identities, err := s.identities(ctx, address)
+	return len(identities) > 0, err
+}
+
+// encryptionCertificates returns one certificate per recipient, or nil
+// when a recipient has none
+func (s *SMIMEService) encryptionCertificates(ctx context.Context, recipients []string) ([]*x509.Certificate, error) {
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+	var to []*x509.Certificate
+	for _, recipient := range recipients {
+		certificate, err := s.encryptionCertificate(ctx, recipient)
+		if err != nil {
+			return nil, err
+		}
+		if certificate == nil {
+			return nil, nil
+		}
+		to = append(to, certificate)
+	}
+	return to, nil
+}
+
+// decrypt decrypts enveloped CMS data with the first identity it is
+// encrypted to
+func (s *SMIMEService) decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	p7, err := pkcs7.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S/MIME message: %w", err)
+	}
+	identities, err := s.identities(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		plaintext, err := p7.Decrypt(identity.certificate, identity.key)
+		if err == nil {
+			return plaintext, nil
+		}
+		if !errors.Is(err, pkcs7.ErrNotEncryptedContent) {
+			config.Logger.Debug().Err(err).Str("subject", identity.certificate.Subject.String()).Msg("Identity cannot decrypt message")
+		}
+	}
+	return nil, ErrNoSMIMEDecryptionKey
+}
+
+// verify checks a CMS signature whose content is set into status. A good
+// signature is then checked to chain to the trust store at verifyAt.
+func (s *SMIMEService) verify(ctx context.Context, p7 *pkcs7.PKCS7, verifyAt time.Time, status *SMIMEStatus) {
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		status.Signature = SignatureUnknownKey
+		return
+	}
+	status.SignerSubject = signer.Subject.String()
+	status.SignerEmails = certificateEmails(signer)
+	status.SignerIssuer = signer.Issuer.String()
+	status.SignerExpires = signer.NotAfter
+
+	if err := p7.Verify(); err != nil {
+		config.Logger.Debug().Err(err).Str("subject", status.SignerSubject).Msg("Bad S/MIME signature")
+		status.Signature = SignatureBad
+		return
+	}
+	status.Signature = SignatureGood
+
+	roots, intermediates, err := s.trustStore(ctx)
+	if err != nil {
+		status.TrustError = err
+		return
+	}
+	for _, certificate := range p7.Certificates {
+		intermediates.AddCert(certificate)
+	}
+	_, err = signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		CurrentTime:   verifyAt,
+	})
+	if err != nil {
+		status.TrustError = err
+		return
+	}
+	status.Trusted = true
+
+	// Replies cannot be encrypted to a certificate that has expired since
+	if time.Now().After(signer.NotAfter) {
+		return
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := saveCertificate(tx, signer, nil)
+		return err
+	})
+	if err != nil {
+		config.Logger.Warn().Err(err).Str("subject", status.SignerSubject).Msg("Failed to store signer's certificate")
+	}
+}
+
+// sign returns a detached CMS signature of content, with the signer's
+// certificate chain
+func sign(content []byte, signer *smimeIdentity) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(signer.certificate, signer.key, signer.chain, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, err
+	}
+	sd.Detach()
+	return sd.Finish()
+}
+
+// encrypt returns content enveloped for the recipients with AES-256-CBC,
+// which every S/MIME client reads
+func encrypt(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	pkcs7Mu.Lock()
+	defer pkcs7Mu.Unlock()
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+	return pkcs7.Encrypt(content, recipients)
+}
@@ -0,0 +1,462 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Custom error types
+var (
+	ErrCertificateNotFound = errors.New("S/MIME certificate not found")
+	ErrInvalidCertificate  = errors.New("invalid S/MIME certificate")
+	ErrInvalidIdentity     = errors.New("invalid PKCS#12 identity")
+	ErrNotAnIdentity       = errors.New("S/MIME certificate has no private key")
+	ErrWrongPassword       = errors.New("wrong password for PKCS#12 identity")
+)
+
+// oidEmailAddress is the emailAddress attribute some certificates name
+// their address with in the subject instead of the subjectAltName
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// smimeIdentity is one of the user's certificates with its private key
+type smimeIdentity struct {
+	certificate *x509.Certificate
+	key         crypto.PrivateKey
+	chain       []*x509.Certificate // Issuers from the PKCS#12 file, sent along with signatures
+}
+
+// SMIMEService keeps the local S/MIME certificate store and applies S/MIME
+// (RFC 8551) to messages. Signatures are trusted when their certificate
+// chains to the trust store: the roots it was created with and the
+// certificates marked as trust anchors. Identities protected by a password
+// must be unlocked once per session before they can decrypt or sign.
+type SMIMEService struct {
+	db    *gorm.DB
+	roots *x509.CertPool
+
+	mu       sync.Mutex
+	unlocked map[string]*smimeIdentity // Identities decrypted this session, by fingerprint
+}
+
+// NewSMIMEService creates a new SMIMEService. A nil roots uses the
+// system's trusted roots.
+func NewSMIMEService(db *gorm.DB, roots *x509.CertPool) *SMIMEService {
+	config.Logger.Debug().Msg("Initializing S/MIME service")
+	if roots == nil {
+		system, err := x509.SystemCertPool()
+		if err != nil {
+			config.Logger.Warn().Err(err).Msg("Failed to load system roots, only trust anchors will be trusted")
+			system = x509.NewCertPool()
+		}
+		roots = system
+	}
+	return &SMIMEService{db: db, roots: roots, unlocked: make(map[string]*smimeIdentity)}
+}
+
+// ImportCertificates adds the certificates in data, PEM or DER, to the
+// store. Certificates already present are updated and keep their private
+// key and trust.
+func (s *SMIMEService) ImportCertificates(ctx context.Context, data []byte) ([]*entities.SMIMECertificate, error) {
+	certificates, err := readCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []*entities.SMIMECertificate
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, certificate := range certificates {
+			record, err := saveCertificate(tx, certificate, nil)
+			if err != nil {
+				return err
+			}
+			saved = append(saved, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config.Logger.Info().Int("count", len(saved)).Msg("S/MIME certificates imported")
+	return saved, nil
+}
+
+// ImportIdentity adds a PKCS#12 file holding one of the user's
+// certificates and its private key. The issuers the file carries are added
+// as ordinary certificates. The identity stays unlocked for the session.
+func (s *SMIMEService) ImportIdentity(ctx context.Context, data []byte, password string) (*entities.SMIMECertificate, error) {
+	identity, err := decodeIdentity(data, password)
+	if err != nil {
+		return nil, err
+	}
+
+	var record *entities.SMIMECertificate
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, issuer := range identity.chain {
+			if _, err := saveCertificate(tx, issuer, nil); err != nil {
+				return err
+			}
+		}
+		record, err = saveCertificate(tx, identity.certificate, data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.unlocked[record.Fingerprint] = identity
+	s.mu.Unlock()
+
+	config.Logger.Info().Str("fingerprint", record.Fingerprint).Msg("S/MIME identity imported")
+	return record, nil
+}
+
+// ExportCertificate returns a certificate PEM-encoded, without its private
+// key
+func (s *SMIMEService) ExportCertificate(ctx context.Context, fingerprint string) (string, error) {
+	record, err := s.certificate(ctx, fingerprint)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: record.Certificate})), nil
+}
+
+// ListCertificates returns every certificate in the store with its
+// addresses
+func (s *SMIMEService) ListCertificates(ctx context.Context) ([]*entities.SMIMECertificate, error) {
+	var records []*entities.SMIMECertificate
+	err := s.db.WithContext(ctx).Preload("Emails").Order("subject, fingerprint").Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteCertificate removes a certificate from the store
+func (s *SMIMEService) DeleteCertificate(ctx context.Context, fingerprint string) error {
+	record, err := s.certificate(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("smime_certificate_id = ?", record.ID).Delete(&entities.SMIMECertificateEmail{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(record).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete certificate: %w", err)
+	}
+
+	s.mu.Lock()
+	delete(s.unlocked, record.Fingerprint)
+	s.mu.Unlock()
+
+	config.Logger.Info().Str("fingerprint", record.Fingerprint).Msg("S/MIME certificate deleted")
+	return nil
+}
+
+// SetTrustAnchor adds a certificate to the trust store, or removes it
+func (s *SMIMEService) SetTrustAnchor(ctx context.Context, fingerprint string, trusted bool) error {
+	record, err := s.certificate(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	if err := s.db.WithContext(ctx).Model(record).Update("trust_anchor", trusted).Error; err != nil {
+		return fmt.Errorf("failed to update certificate: %w", err)
+	}
+
+	config.Logger.Info().
+		Str("fingerprint", record.Fingerprint).
+		Bool("trusted", trusted).
+		Msg("S/MIME trust anchor updated")
+	return nil
+}
+
+// Unlock decrypts an identity with its password for the rest of the
+// session
+func (s *SMIMEService) Unlock(ctx context.Context, fingerprint string, password string) error {
+	record, err := s.certificate(ctx, fingerprint)
+	if err != nil {
+		return err
+	}
+	if record.PKCS12 == nil {
+		return ErrNotAnIdentity
+	}
+
+	identity, err := decodeIdentity(record.PKCS12, password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.unlocked[record.Fingerprint] = identity
+	s.mu.Unlock()
+	return nil
+}
+
+// IsLocked reports whether record is an identity that needs its password
+// before it can decrypt or sign
+func (s *SMIMEService) IsLocked(record *entities.SMIMECertificate) bool {
+	return record.PKCS12 != nil && s.usableIdentity(record) == nil
+}
+
+// certificate loads a certificate by fingerprint, given in any case and
+// with or without colons and spaces
+func (s *SMIMEService) certificate(ctx context.Context, fingerprint string) (*entities.SMIMECertificate, error) {
+	fingerprint = strings.ToUpper(strings.NewReplacer(" ", "", ":", "").Replace(fingerprint))
+	var record entities.SMIMECertificate
+	err := s.db.WithContext(ctx).Preload("Emails").Where("fingerprint = ?", fingerprint).First(&record).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCertificateNotFound
+		}
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	return &record, nil
+}
+
+// usableIdentity returns the private key of an identity if it is
+// unlocked or has no password, and nil otherwise
+func (s *SMIMEService) usableIdentity(record *entities.SMIMECertificate) *smimeIdentity {
+	s.mu.Lock()
+	identity := s.unlocked[record.Fingerprint]
+	s.mu.Unlock()
+	if identity != nil || record.PKCS12 == nil {
+		return identity
+	}
+
+	identity, err := decodeIdentity(record.PKCS12, "")
+	if err != nil {
+		return nil
+	}
+	return identity
+}
+
+// identities returns the usable identities, those of address only when it
+// is not empty, whose certificates are currently valid, latest expiry
+// first
+func (s *SMIMEService) identities(ctx context.Context, address string) ([]*smimeIdentity, error) {
+	records, err := s.certificatesFor(ctx, address, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*smimeIdentity
+	for _, record := range records {
+		if identity := s.usableIdentity(record); identity != nil {
+			list = append(list, identity)
+		}
+	}
+	return list, nil
+}
+
+// certificatesFor returns the currently valid certificates issued to an
+// address, or every one when address is empty, latest expiry first
+func (s *SMIMEService) certificatesFor(ctx context.Context, address string, identitiesOnly bool) ([]*entities.SMIMECertificate, error) {
+	now := time.Now()
+	query := s.db.WithContext(ctx).
+		Where("smime_certificates.not_before <= ? AND smime_certificates.not_after > ?", now, now).
+		Order("smime_certificates.not_after DESC")
+	if address != "" {
+		query = query.
+			Joins("JOIN smime_certificate_emails ON smime_certificate_emails.smime_certificate_id = smime_certificates.id").
+			Where("smime_certificate_emails.email = ?", normalizeEmail(address))
+	}
+	if identitiesOnly {
+		query = query.Where("smime_certificates.pkcs12 IS NOT NULL")
+	}
+
+	var records []*entities.SMIMECertificate
+	if err := query.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up certificates: %w", err)
+	}
+	return records, nil
+}
+
+// encryptionCertificate returns the certificate to encrypt to address, or
+// nil when it has none. Only RSA certificates can be encrypted to.
+func (s *SMIMEService) encryptionCertificate(ctx context.Context, address string) (*x509.Certificate, error) {
+	records, err := s.certificatesFor(ctx, address, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		certificate, err := x509.ParseCertificate(record.Certificate)
+		if err != nil {
+			continue
+		}
+		if _, ok := certificate.PublicKey.(*rsa.PublicKey); !ok {
+			continue
+		}
+		if certificate.KeyUsage != 0 && certificate.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+			continue
+		}
+		return certificate, nil
+	}
+	return nil, nil
+}
+
+// trustStore returns the roots signatures are verified against and the
+// stored certificates that may complete a chain to them
+func (s *SMIMEService) trustStore(ctx context.Context) (*x509.CertPool, *x509.CertPool, error) {
+	var records []*entities.SMIMECertificate
+	if err := s.db.WithContext(ctx).Where("trust_anchor = ? OR is_ca = ?", true, true).Find(&records).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to load trust store: %w", err)
+	}
+
+	roots := s.roots.Clone()
+	intermediates := x509.NewCertPool()
+	for _, record := range records {
+		certificate, err := x509.ParseCertificate(record.Certificate)
+		if err != nil {
+			config.Logger.Warn().Err(err).Str("fingerprint", record.Fingerprint).Msg("Skipping unreadable S/MIME certificate")
+			continue
+		}
+		if record.TrustAnchor {
+			roots.AddCert(certificate)
+		} else {
+			intermediates.AddCert(certificate)
+		}
+	}
+	return roots, intermediates, nil
+}
+
+// saveCertificate inserts or updates a certificate. An identity's PKCS#12
+// file replaces the stored one; otherwise the stored file and the trust
+// are kept.
+func saveCertificate(tx *gorm.DB, certificate *x509.Certificate, pkcs12Data []byte) (*entities.SMIMECertificate, error) {
+	record := certificateRecord(certificate)
+	record.PKCS12 = pkcs12Data
+
+	var existing entities.SMIMECertificate
+	result := tx.Where("fingerprint = ?", record.Fingerprint).Limit(1).Find(&existing)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to look up certificate: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		record.ID = existing.ID
+		record.CreatedAt = existing.CreatedAt
+		record.TrustAnchor = existing.TrustAnchor
+		if record.PKCS12 == nil {
+			record.PKCS12 = existing.PKCS12
+		}
+		if err := tx.Where("smime_certificate_id = ?", existing.ID).Delete(&entities.SMIMECertificateEmail{}).Error; err != nil {
+			return nil, fmt.Errorf("failed to update certificate addresses: %w", err)
+		}
+	}
+	if err := tx.Save(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save certificate %s: %w", record.Subject, err)
+	}
+	return record, nil
+}
+
+// certificateRecord describes a certificate for the store
+func certificateRecord(certificate *x509.Certificate) *entities.SMIMECertificate {
+	record := &entities.SMIMECertificate{
+		Fingerprint: certificateFingerprint(certificate),
+		Subject:     certificate.Subject.String(),
+		Issuer:      certificate.Issuer.String(),
+		NotBefore:   certificate.NotBefore,
+		NotAfter:    certificate.NotAfter,
+		IsCA:        certificate.IsCA,
+		Certificate: certificate.Raw,
+	}
+	for _, email := range certificateEmails(certificate) {
+		record.Emails = append(record.Emails, entities.SMIMECertificateEmail{Email: email})
+	}
+	return record
+}
+
+// certificateFingerprint returns the upper-case hex SHA-256 of a
+// certificate
+func certificateFingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// certificateEmails returns the normalized addresses a certificate is
+// issued to, from its subjectAltName and subject
+func certificateEmails(certificate *x509.Certificate) []string {
+	candidates := append([]string{}, certificate.EmailAddresses...)
+	for _, name := range certificate.Subject.Names {
+		if value, ok := name.Value.(string); ok && name.Type.Equal(oidEmailAddress) {
+			candidates = append(candidates, value)
+		}
+	}
+
+	var emails []string
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		email := normalizeEmail(candidate)
+		if email == "" || seen[email] {
+			continue
+		}
+		seen[email] = true
+		emails = append(emails, email)
+	}
+	return emails
+}
+
+// readCertificates reads every certificate of PEM or DER data
+func readCertificates(data []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		certificates, err := x509.ParseCertificates(data)
+		if err != nil || len(certificates) == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+		}
+		return certificates, nil
+	}
+
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("%w: no certificate found", ErrInvalidCertificate)
+	}
+	return certificates, nil
+}
+
+// decodeIdentity reads a PKCS#12 file
+func decodeIdentity(data []byte, password string) (*smimeIdentity, error) {
+	key, certificate, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) || errors.Is(err, pkcs12.ErrDecryption) {
+			return nil, ErrWrongPassword
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIdentity, err)
+	}
+	return &smimeIdentity{certificate: certificate, key: key, chain: chain}, nil
+}
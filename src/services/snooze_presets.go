@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// Snooze presets
+const (
+	SnoozeLaterToday      = "later-today"
+	SnoozeThisEvening     = "this-evening"
+	SnoozeTomorrowMorning = "tomorrow-morning"
+	SnoozeThisWeekend     = "this-weekend"
+	SnoozeNextWeek        = "next-week"
+)
+
+// Hours of the day presets wake at
+const (
+	snoozeMorningHour = 8
+	snoozeEveningHour = 18
+	snoozeWeekendHour = 9
+)
+
+// SnoozePreset is a time offered for snoozing
+type SnoozePreset struct {
+	Name  string
+	Label string
+	Until time.Time
+}
+
+// SnoozePresets returns the presets that make sense at now in the user's
+// time zone loc, earliest first. Later today and this evening are only
+// offered while the day lasts, and this weekend on weekdays.
+func SnoozePresets(now time.Time, loc *time.Location) []SnoozePreset {
+	now = now.In(loc)
+	year, month, day := now.Date()
+	at := func(days, hour int) time.Time {
+		return time.Date(year, month, day+days, hour, 0, 0, 0, loc)
+	}
+
+	var presets []SnoozePreset
+	// Three hours on, rounded up to the hour
+	later := now.Add(3 * time.Hour)
+	if later.Truncate(time.Hour) != later {
+		later = later.Truncate(time.Hour).Add(time.Hour)
+	}
+	if later.Before(at(0, snoozeEveningHour)) {
+		presets = append(presets, SnoozePreset{Name: SnoozeLaterToday, Label: "Later today", Until: later})
+	}
+	if evening := at(0, snoozeEveningHour); now.Before(evening.Add(-time.Hour)) {
+		presets = append(presets, SnoozePreset{Name: SnoozeThisEvening, Label: "This evening", Until: evening})
+	}
+	presets = append(presets, SnoozePreset{Name: SnoozeTomorrowMorning, Label: "Tomorrow morning", Until: at(1, snoozeMorningHour)})
+	weekday := now.Weekday()
+	if weekday != time.Saturday && weekday != time.Sunday {
+		days := int(time.Saturday - weekday)
+		presets = append(presets, SnoozePreset{Name: SnoozeThisWeekend, Label: "This weekend", Until: at(days, snoozeWeekendHour)})
+	}
+	// Next Monday; from Sunday that is tomorrow
+	days := (8 - int(weekday)) % 7
+	if days == 0 {
+		days = 7
+	}
+	presets = append(presets, SnoozePreset{Name: SnoozeNextWeek, Label: "Next week", Until: at(days, snoozeMorningHour)})
+	return presets
+}
+
+// SnoozePresetTime returns the time a preset wakes at from now in loc
+func SnoozePresetTime(name string, now time.Time, loc *time.Location) (time.Time, error) {
+	for _, preset := range SnoozePresets(now, loc) {
+		if preset.Name == name {
+			return preset.Until, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w %q", ErrUnknownSnoozePreset, name)
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrSnoozeInPast        = errors.New("snooze time must be in the future")
+	ErrSnoozeDraft         = errors.New("drafts cannot be snoozed")
+	ErrNotSnoozed          = errors.New("email is not snoozed")
+	ErrUnknownSnoozePreset = errors.New("unknown snooze preset")
+)
+
+// SnoozedFolder is the folder snoozed messages wait in
+const SnoozedFolder = "Snoozed"
+
+// maxSnoozeWait bounds how long the scheduler sleeps, so that it notices
+// clock changes and system sleep
+const maxSnoozeWait = time.Hour
+
+// snoozeRetryInterval is how long the scheduler waits after a failure
+const snoozeRetryInterval = time.Minute
+
+// SnoozeService hides messages until a chosen time. Snoozed messages wait
+// in SnoozedFolder; Run wakes them when their time comes, returning them
+// to their folder as unread.
+type SnoozeService struct {
+	db      *gorm.DB
+	events  *events.Bus
+	changed chan struct{}
+}
+
+// NewSnoozeService creates a new SnoozeService
+func NewSnoozeService(db *gorm.DB) *SnoozeService {
+	config.Logger.Debug().Msg("Initializing snooze service")
+	return &SnoozeService{db: db, changed: make(chan struct{}, 1)}
+}
+
+// SetEventBus sets the bus that snoozed and woken messages are published to
+func (s *SnoozeService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// Snooze hides a message until a time. Snoozing a snoozed message moves
+// its wake time. The snooze is returned with its message.
+func (s *SnoozeService) Snooze(ctx context.Context, messageID uint, until time.Time) (*entities.Snooze, error) {
+	until = until.UTC().Truncate(time.Second)
+	if !until.After(time.Now()) {
+		return nil, ErrSnoozeInPast
+	}
+	var message entities.Message
+	found := s.db.WithContext(ctx).Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return nil, ErrEmailNotFound
+	}
+	if message.IsDraft {
+		return nil, ErrSnoozeDraft
+	}
+
+	snooze := &entities.Snooze{MessageID: messageID, Folder: message.Folder}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("message_id = ?", messageID).Limit(1).Find(snooze)
+		if result.Error != nil {
+			return fmt.Errorf("failed to look up snooze: %w", result.Error)
+		}
+		// A message snoozed again keeps the folder it first came from
+		if result.RowsAffected == 0 || message.Folder != SnoozedFolder {
+			snooze.Folder = message.Folder
+		}
+		if snooze.Folder == SnoozedFolder {
+			snooze.Folder = ""
+		}
+		snooze.WakeAt = until
+		if err := tx.Omit("Message").Save(snooze).Error; err != nil {
+			return fmt.Errorf("failed to store snooze: %w", err)
+		}
+		err := tx.Model(&entities.Message{}).Where("id = ?", messageID).Update("folder", SnoozedFolder).Error
+		if err != nil {
+			return fmt.Errorf("failed to move message: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	message.Folder = SnoozedFolder
+	snooze.Message = message
+	config.Logger.Info().
+		Uint("messageID", messageID).
+		Time("wakeAt", until).
+		Msg("Message snoozed")
+
+	s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: messageID,
+		AccountID: message.AccountID,
+	})
+	s.notify()
+	return snooze, nil
+}
+
+// Unsnooze returns a snoozed message to its folder now, leaving it read
+// or unread as it was
+func (s *SnoozeService) Unsnooze(ctx context.Context, messageID uint) error {
+	var snooze entities.Snooze
+	found := s.db.WithContext(ctx).Preload("Message").Where("message_id = ?", messageID).Limit(1).Find(&snooze)
+	if found.Error != nil {
+		return fmt.Errorf("failed to load snooze: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return ErrNotSnoozed
+	}
+	if err := s.wake(ctx, &snooze, false); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// List returns the messages of an account that are snoozed, the first to
+// wake first
+func (s *SnoozeService) List(ctx context.Context, accountID uint) ([]*entities.Snooze, error) {
+	var snoozes []*entities.Snooze
+	err := s.db.WithContext(ctx).
+		Joins("Message").
+		Where("Message.account_id = ?", accountID).
+		Order("snoozes.wake_at, snoozes.id").
+		Find(&snoozes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snoozes: %w", err)
+	}
+	return snoozes, nil
+}
+
+// WakeDue wakes the messages whose time came by now. It returns how many
+// woke.
+func (s *SnoozeService) WakeDue(ctx context.Context, now time.Time) (int, error) {
+	var snoozes []*entities.Snooze
+	err := s.db.WithContext(ctx).
+		Preload("Message").
+		Where("wake_at <= ?", now.UTC()).
+		Order("wake_at, id").
+		Find(&snoozes).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due snoozes: %w", err)
+	}
+
+	for i, snooze := range snoozes {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := s.wake(ctx, snooze, true); err != nil {
+			return i, err
+		}
+	}
+	return len(snoozes), nil
+}
+
+// Run wakes snoozed messages on time until ctx is cancelled. Messages
+// whose time passed while the app was closed wake at once.
+func (s *SnoozeService) Run(ctx context.Context) {
+	for {
+		wait := maxSnoozeWait
+		if _, err := s.WakeDue(ctx, time.Now()); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			config.Logger.Error().Err(err).Msg("Failed to wake snoozed messages")
+			wait = snoozeRetryInterval
+		} else if next, err := s.nextWake(ctx); err != nil {
+			config.Logger.Error().Err(err).Msg("Failed to find next snoozed message")
+			wait = snoozeRetryInterval
+		} else if next != nil {
+			wait = min(wait, max(time.Until(*next), 0))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// nextWake returns when the next snoozed message wakes, or nil
+func (s *SnoozeService) nextWake(ctx context.Context) (*time.Time, error) {
+	var snooze entities.Snooze
+	found := s.db.WithContext(ctx).Order("wake_at").Limit(1).Find(&snooze)
+	if found.Error != nil {
+		return nil, found.Error
+	}
+	if found.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &snooze.WakeAt, nil
+}
+
+// notify tells Run that snoozes changed
+func (s *SnoozeService) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// wake deletes a snooze and returns its message to the folder it was
+// snoozed from, unless the user moved it meanwhile. Messages woken on
+// time are marked unread and announced.
+func (s *SnoozeService) wake(ctx context.Context, snooze *entities.Snooze, due bool) error {
+	message := &snooze.Message
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(snooze).Error; err != nil {
+			return fmt.Errorf("failed to delete snooze: %w", err)
+		}
+		if message.ID == 0 {
+			return nil
+		}
+		updates := map[string]interface{}{}
+		if message.Folder == SnoozedFolder {
+			updates["folder"] = snooze.Folder
+		}
+		if due {
+			updates["is_read"] = false
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&entities.Message{}).Where("id = ?", message.ID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to wake message: %w", err)
+		}
+		return nil
+	})
+	if err != nil || message.ID == 0 {
+		return err
+	}
+	config.Logger.Info().
+		Uint("messageID", message.ID).
+		Bool("due", due).
+		Msg("Snoozed message woke")
+
+	s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+	})
+	if due {
+		s.events.Publish(events.TopicMessageWoken, events.SnoozePayload{
+			MessageID: message.ID,
+			AccountID: message.AccountID,
+			Subject:   stringOrEmpty(message.Subject),
+			Sender:    message.SenderEmail,
+		})
+	}
+	return nil
+}
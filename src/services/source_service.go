@@ -0,0 +1,191 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/formats/rfc5322"
+
+	"gorm.io/gorm"
+)
+
+// ErrSourceNotStored is returned when an email's raw source was never stored
+var ErrSourceNotStored = errors.New("message source is not stored")
+
+// headerBatchSize is the number of header rows inserted per statement
+const headerBatchSize = 100
+
+// EmailSource is the RFC 5322 source of an email. Emails stored without
+// their source, such as those created before sources were kept, are
+// rebuilt from their fields and marked Reconstructed.
+type EmailSource struct {
+	Data          []byte
+	Reconstructed bool
+}
+
+// EmailHeaders are the header fields of an email's source in their
+// original order
+type EmailHeaders struct {
+	Fields        rfc5322.Header
+	Reconstructed bool
+}
+
+// SourceService reads the raw sources and header fields of emails
+type SourceService struct {
+	db           *gorm.DB
+	emailService *EmailService
+	store        *AttachmentStore
+}
+
+// NewSourceService creates a new SourceService. Attachments of rebuilt
+// sources are read from store.
+func NewSourceService(db *gorm.DB, emailService *EmailService, store *AttachmentStore) *SourceService {
+	config.Logger.Debug().Msg("Initializing source service")
+	return &SourceService{
+		db:           db,
+		emailService: emailService,
+		store:        store,
+	}
+}
+
+// Source returns the source of an email
+func (s *SourceService) Source(ctx context.Context, messageID uint) (*EmailSource, error) {
+	return emailSource(ctx, s.db, s.emailService, s.store, messageID)
+}
+
+// Headers returns the header fields of an email
+func (s *SourceService) Headers(ctx context.Context, messageID uint) (*EmailHeaders, error) {
+	var rows []entities.MessageHeader
+	err := s.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("position").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load headers: %w", err)
+	}
+	if len(rows) > 0 {
+		fields := make(rfc5322.Header, len(rows))
+		for i, row := range rows {
+			fields[i] = rfc5322.Field{Name: row.Name, Value: row.Value}
+		}
+		return &EmailHeaders{Fields: fields}, nil
+	}
+
+	source, err := s.Source(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := rfc5322.ReadHeader(bufio.NewReader(bytes.NewReader(source.Data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read headers: %w", err)
+	}
+	return &EmailHeaders{Fields: fields, Reconstructed: source.Reconstructed}, nil
+}
+
+// emailSource returns the stored source of an email, or rebuilds it
+func emailSource(ctx context.Context, db *gorm.DB, emailService *EmailService, store *AttachmentStore, messageID uint) (*EmailSource, error) {
+	data, err := loadSource(ctx, db, messageID)
+	if err == nil {
+		return &EmailSource{Data: data}, nil
+	}
+	if !errors.Is(err, ErrSourceNotStored) {
+		return nil, err
+	}
+
+	email, err := emailService.GetByID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	data, err = reconstructSource(email, store)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailSource{Data: data, Reconstructed: true}, nil
+}
+
+// reconstructSource rebuilds the RFC 5322 source of an email from its fields
+func reconstructSource(email *EmailDTO, store *AttachmentStore) ([]byte, error) {
+	message, err := messageFromEmail(email, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw bytes.Buffer
+	if err := rfc5322.Write(&raw, message); err != nil {
+		return nil, fmt.Errorf("failed to encode message %d: %w", email.Message.ID, err)
+	}
+	return raw.Bytes(), nil
+}
+
+// loadSource reads and decompresses the stored source of a message
+func loadSource(ctx context.Context, db *gorm.DB, messageID uint) ([]byte, error) {
+	var source entities.MessageSource
+	err := db.WithContext(ctx).Where("message_id = ?", messageID).First(&source).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSourceNotStored
+		}
+		return nil, fmt.Errorf("failed to load message source: %w", err)
+	}
+
+	if source.Encoding != entities.SourceEncodingGzip {
+		return nil, fmt.Errorf("unknown message source encoding %q", source.Encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(source.Data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress message source: %w", err)
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress message source: %w", err)
+	}
+	return data, nil
+}
+
+// storeSource saves the compressed source of a message and its header
+// fields. A source without a header stores no fields.
+func storeSource(tx *gorm.DB, messageID uint, raw []byte) error {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(raw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	source := &entities.MessageSource{
+		Encoding:  entities.SourceEncodingGzip,
+		Size:      uint(len(raw)),
+		Data:      compressed.Bytes(),
+		MessageID: messageID,
+	}
+	if err := tx.Create(source).Error; err != nil {
+		return fmt.Errorf("failed to store message source: %w", err)
+	}
+
+	fields, err := rfc5322.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil || len(fields) == 0 {
+		return nil
+	}
+	rows := make([]*entities.MessageHeader, len(fields))
+	for i, field := range fields {
+		rows[i] = &entities.MessageHeader{
+			Position:  i,
+			Name:      field.Name,
+			Value:     field.Value,
+			MessageID: messageID,
+		}
+	}
+	if err := tx.CreateInBatches(rows, headerBatchSize).Error; err != nil {
+		return fmt.Errorf("failed to store message headers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"net/url"
+	"palm/src/formats/htmltext"
+	"palm/src/formats/rfc5322"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Classifier parameters, after Gary Robinson's "A statistical approach to
+// the spam problem" as used by SpamBayes
+const (
+	spamStrength     = 0.45 // Weight of the prior for rarely seen tokens
+	spamPrior        = 0.5  // Probability assumed for unseen tokens
+	spamMaxClues     = 150  // Tokens that decide a score at most
+	spamMinDistance  = 0.1  // Tokens closer to the prior are not clues
+	spamMinWordLen   = 3
+	spamMaxWordLen   = 20
+	spamMaxTokens    = 3000 // Tokens read from a message at most
+	spamMinTrainings = 10   // Messages of each kind needed before scoring
+)
+
+// spamURLPattern finds links in bodies
+var spamURLPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>()]+`)
+
+// spamTokens returns the distinct tokens of a raw message: words of the
+// subject and body, the sender's address and domain, the domains of
+// links, and traits of the message's structure. Tokens from the header
+// are prefixed with the field they came from.
+func spamTokens(raw []byte) []string {
+	parsed, err := rfc5322.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	header, _ := rfc5322.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+
+	seen := map[string]bool{}
+	var tokens []string
+	add := func(token string) {
+		if len(tokens) < spamMaxTokens && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	words := func(prefix, text string) {
+		for _, word := range spamWords(text) {
+			add(prefix + word)
+		}
+	}
+
+	words("subject:", parsed.Subject)
+	if parsed.From != nil {
+		address := strings.ToLower(parsed.From.Address)
+		add("from:" + address)
+		if _, domain, ok := strings.Cut(address, "@"); ok {
+			add("from-domain:" + domain)
+		}
+		words("from-name:", parsed.From.Name)
+	}
+	for _, addr := range parsed.ReplyTo {
+		if _, domain, ok := strings.Cut(strings.ToLower(addr.Address), "@"); ok {
+			add("reply-to-domain:" + domain)
+		}
+	}
+	if mailer := header.Get("X-Mailer"); mailer != "" {
+		words("x-mailer:", mailer)
+	}
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ := strings.Cut(contentType, ";")
+		add("content-type:" + strings.ToLower(strings.TrimSpace(mediaType)))
+	}
+	if parsed.HTML != "" && parsed.Text == "" {
+		add("trait:html-only")
+	}
+	for _, a := range parsed.Attachments {
+		if !a.Inline {
+			add("attachment:" + strings.ToLower(a.ContentType))
+		}
+	}
+
+	body := parsed.Text
+	if body == "" && parsed.HTML != "" {
+		body = htmltext.ToText(parsed.HTML)
+	}
+	for _, link := range spamURLPattern.FindAllString(parsed.Text+" "+parsed.HTML, -1) {
+		if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+			add("url:" + strings.ToLower(u.Hostname()))
+		}
+	}
+	words("", spamURLPattern.ReplaceAllString(body, " "))
+	return tokens
+}
+
+// spamWords splits text into lower-cased words. Words too short to tell
+// anything, too long to recur and plain numbers are left out.
+func spamWords(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '$' && r != '\'' && r != '-'
+	})
+	words := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.Trim(field, "'-")
+		n := len([]rune(field))
+		if n < spamMinWordLen || n > spamMaxWordLen {
+			continue
+		}
+		if strings.IndexFunc(field, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		words = append(words, strings.ToLower(field))
+	}
+	return words
+}
+
+// spamCount counts the trained messages of each kind a token appeared in
+type spamCount struct {
+	spam, ham int
+}
+
+// spamCorpus is the part of the classifier's training needed to score
+// messages: the messages trained and the counts of their tokens
+type spamCorpus struct {
+	spamMessages int
+	hamMessages  int
+	tokens       map[string]spamCount
+}
+
+func newSpamCorpus() *spamCorpus {
+	return &spamCorpus{tokens: map[string]spamCount{}}
+}
+
+// train adds a message's tokens to the corpus
+func (c *spamCorpus) train(tokens []string, spam bool) {
+	if spam {
+		c.spamMessages++
+	} else {
+		c.hamMessages++
+	}
+	for _, token := range tokens {
+		count := c.tokens[token]
+		if spam {
+			count.spam++
+		} else {
+			count.ham++
+		}
+		c.tokens[token] = count
+	}
+}
+
+// ready tells whether the corpus holds enough messages of both kinds to
+// score others
+func (c *spamCorpus) ready() bool {
+	return c.spamMessages >= spamMinTrainings && c.hamMessages >= spamMinTrainings
+}
+
+// probability returns the probability of a message containing token to be
+// spam, pulled towards the prior for rarely seen tokens
+func (c *spamCorpus) probability(token string) float64 {
+	count := c.tokens[token]
+	n := float64(count.spam + count.ham)
+	if n == 0 {
+		return spamPrior
+	}
+	spamRatio := min(float64(count.spam)/float64(max(c.spamMessages, 1)), 1)
+	hamRatio := min(float64(count.ham)/float64(max(c.hamMessages, 1)), 1)
+	p := spamRatio / (spamRatio + hamRatio)
+	return (spamStrength*spamPrior + n*p) / (spamStrength + n)
+}
+
+// score combines the probabilities of a message's most telling tokens
+// with Fisher's method into a score from 0, wanted, to 1, spam
+func (c *spamCorpus) score(tokens []string) float64 {
+	clues := make([]float64, 0, len(tokens))
+	for _, token := range tokens {
+		if p := c.probability(token); math.Abs(p-spamPrior) >= spamMinDistance {
+			clues = append(clues, p)
+		}
+	}
+	if len(clues) == 0 {
+		return spamPrior
+	}
+	sort.Slice(clues, func(i, j int) bool {
+		return math.Abs(clues[i]-spamPrior) > math.Abs(clues[j]-spamPrior)
+	})
+	if len(clues) > spamMaxClues {
+		clues = clues[:spamMaxClues]
+	}
+
+	var spamLog, hamLog float64
+	for _, p := range clues {
+		spamLog += math.Log(1 - p)
+		hamLog += math.Log(p)
+	}
+	n := 2 * len(clues)
+	spamness := 1 - chi2Q(-2*spamLog, n)
+	hamness := 1 - chi2Q(-2*hamLog, n)
+	return (1 + spamness - hamness) / 2
+}
+
+// chi2Q returns the probability of a chi-squared value of x2 or more with
+// an even number v of degrees of freedom. Terms are summed in log space,
+// since with many clues they underflow.
+func chi2Q(x2 float64, v int) float64 {
+	m := x2 / 2
+	logTerm := -m
+	logSum := logTerm
+	for i := 1; i < v/2; i++ {
+		logTerm += math.Log(m / float64(i))
+		hi, lo := max(logSum, logTerm), min(logSum, logTerm)
+		logSum = hi + math.Log1p(math.Exp(lo-hi))
+	}
+	return min(math.Exp(logSum), 1)
+}
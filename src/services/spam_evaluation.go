@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultSpamFolds is the number of folds of a cross-validation
+const DefaultSpamFolds = 5
+
+// SpamSample is a message of a labelled corpus
+type SpamSample struct {
+	Name string // File the message was read from
+	Raw  []byte
+	Spam bool
+}
+
+// SpamEvaluation is how well the classifier told the samples of a corpus
+// apart. Spam is the positive class.
+type SpamEvaluation struct {
+	TruePositives  int
+	FalsePositives int // Wanted messages taken for spam
+	TrueNegatives  int
+	FalseNegatives int      // Spam let through
+	Unsure         int      // Samples left unscored since their fold had too little training
+	Misclassified  []string // Names of the samples scored wrongly
+}
+
+// Precision is the share of the messages taken for spam that were spam
+func (e *SpamEvaluation) Precision() float64 {
+	return shareOf(e.TruePositives, e.TruePositives+e.FalsePositives)
+}
+
+// Recall is the share of spam that was caught
+func (e *SpamEvaluation) Recall() float64 {
+	return shareOf(e.TruePositives, e.TruePositives+e.FalseNegatives)
+}
+
+// Accuracy is the share of samples scored correctly
+func (e *SpamEvaluation) Accuracy() float64 {
+	return shareOf(e.TruePositives+e.TrueNegatives,
+		e.TruePositives+e.TrueNegatives+e.FalsePositives+e.FalseNegatives)
+}
+
+func shareOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// LoadSpamCorpus reads a labelled corpus: the messages in the spam and
+// ham subdirectories of dir, one per file
+func LoadSpamCorpus(dir string) ([]SpamSample, error) {
+	var samples []SpamSample
+	for _, kind := range []struct {
+		name string
+		spam bool
+	}{{"ham", false}, {"spam", true}} {
+		entries, err := os.ReadDir(filepath.Join(dir, kind.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read corpus: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, kind.name, entry.Name())
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read corpus: %w", err)
+			}
+			samples = append(samples, SpamSample{
+				Name: filepath.Join(kind.name, entry.Name()),
+				Raw:  raw,
+				Spam: kind.spam,
+			})
+		}
+	}
+	return samples, nil
+}
+
+// EvaluateSpamClassifier cross-validates the classifier on a labelled
+// corpus: the samples are dealt into folds, and each fold is scored by a
+// classifier trained on the others. No stored training is used or
+// changed.
+func EvaluateSpamClassifier(samples []SpamSample, folds int, threshold float64) (*SpamEvaluation, error) {
+	if folds < 2 {
+		return nil, errors.New("at least 2 folds are needed")
+	}
+	if !(threshold > 0 && threshold <= 1) {
+		return nil, ErrInvalidSpamThreshold
+	}
+	// Sorting by name deals the same folds on every run
+	sorted := make([]SpamSample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	tokens := make([][]string, len(sorted))
+	for i, sample := range sorted {
+		tokens[i] = spamTokens(sample.Raw)
+	}
+
+	evaluation := &SpamEvaluation{}
+	for fold := 0; fold < folds; fold++ {
+		corpus := newSpamCorpus()
+		for i, sample := range sorted {
+			if i%folds != fold {
+				corpus.train(tokens[i], sample.Spam)
+			}
+		}
+		for i, sample := range sorted {
+			if i%folds != fold {
+				continue
+			}
+			if !corpus.ready() {
+				evaluation.Unsure++
+				continue
+			}
+			spam := corpus.score(tokens[i]) >= threshold
+			switch {
+			case spam && sample.Spam:
+				evaluation.TruePositives++
+			case spam:
+				evaluation.FalsePositives++
+			case sample.Spam:
+				evaluation.FalseNegatives++
+			default:
+				evaluation.TrueNegatives++
+			}
+			if spam != sample.Spam {
+				evaluation.Misclassified = append(evaluation.Misclassified, sample.Name)
+			}
+		}
+	}
+	sort.Strings(evaluation.Misclassified)
+	return evaluation, nil
+}
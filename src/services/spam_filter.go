@@ -0,0 +1,341 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidSpamThreshold is returned for thresholds outside (0, 1]
+var ErrInvalidSpamThreshold = errors.New("spam threshold must be above 0 and at most 1")
+
+// SpamFolder is the folder likely spam is moved to
+const SpamFolder = "Spam"
+
+// DefaultSpamThreshold is the score from which messages are moved aside
+// until the user sets another
+const DefaultSpamThreshold = 0.9
+
+// spamModelID is the ID of the single SpamModel row
+const spamModelID = 1
+
+// spamTokenBatchSize bounds the tokens read or written by one statement,
+// below SQLite's limit on variables
+const spamTokenBatchSize = 500
+
+// SpamStatus describes the training of the spam classifier
+type SpamStatus struct {
+	SpamMessages int     // Messages trained as spam
+	HamMessages  int     // Messages trained as not spam
+	Tokens       int64   // Distinct tokens learned
+	Threshold    float64 // Score from which messages are moved aside
+	Ready        bool    // Enough messages are trained for new ones to be scored
+}
+
+// SpamFilter scores incoming messages with a naive Bayes classifier and
+// moves likely spam to SpamFolder. It learns from the messages the user
+// marks as spam or not spam, keeping the token counts in the database,
+// and scores nothing until it saw enough of both.
+type SpamFilter struct {
+	db           *gorm.DB
+	emailService *EmailService
+	store        *AttachmentStore
+	events       *events.Bus
+}
+
+// NewSpamFilter creates a new SpamFilter. Sources of messages stored
+// without them are rebuilt with the attachments of store.
+func NewSpamFilter(db *gorm.DB, emailService *EmailService, store *AttachmentStore) *SpamFilter {
+	config.Logger.Debug().Msg("Initializing spam filter")
+	return &SpamFilter{db: db, emailService: emailService, store: store}
+}
+
+// SetEventBus sets the bus that moved messages are published to
+func (f *SpamFilter) SetEventBus(bus *events.Bus) {
+	f.events = bus
+}
+
+// Subscribe scores every message synced into an account on bus. It
+// returns a function that stops scoring.
+func (f *SpamFilter) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		// Only mail just delivered is scored: importing an archive must
+		// not move old mail aside
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok || payload.Origin != events.OriginSync {
+			return
+		}
+		if _, err := f.Classify(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to classify message")
+		}
+	})
+}
+
+// Status returns the training of the classifier
+func (f *SpamFilter) Status(ctx context.Context) (*SpamStatus, error) {
+	model, err := f.model(f.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var tokens int64
+	if err := f.db.WithContext(ctx).Model(&entities.SpamToken{}).Count(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to count spam tokens: %w", err)
+	}
+	return &SpamStatus{
+		SpamMessages: model.SpamMessages,
+		HamMessages:  model.HamMessages,
+		Tokens:       tokens,
+		Threshold:    model.Threshold,
+		Ready:        model.SpamMessages >= spamMinTrainings && model.HamMessages >= spamMinTrainings,
+	}, nil
+}
+
+// SetThreshold sets the score from which messages are moved aside
+func (f *SpamFilter) SetThreshold(ctx context.Context, threshold float64) error {
+	if !(threshold > 0 && threshold <= 1) {
+		return ErrInvalidSpamThreshold
+	}
+	db := f.db.WithContext(ctx)
+	if _, err := f.model(db); err != nil {
+		return err
+	}
+	err := db.Model(&entities.SpamModel{}).Where("id = ?", spamModelID).Update("threshold", threshold).Error
+	if err != nil {
+		return fmt.Errorf("failed to set spam threshold: %w", err)
+	}
+	config.Logger.Info().Float64("threshold", threshold).Msg("Spam threshold changed")
+	return nil
+}
+
+// Classify scores an incoming message and moves it from the inbox to
+// SpamFolder if its score reaches the threshold. Messages the user
+// labelled, sent messages and messages gone by now are left alone, as is
+// everything until the classifier is trained.
+func (f *SpamFilter) Classify(ctx context.Context, messageID uint) (*entities.SpamVerdict, error) {
+	db := f.db.WithContext(ctx)
+	var message entities.Message
+	found := db.Preload("Account").Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 || !isIncoming(&message) {
+		return nil, nil
+	}
+	verdict, err := f.verdict(db, messageID)
+	if err != nil || verdict.Label != "" {
+		return verdict, err
+	}
+	model, err := f.model(db)
+	if err != nil {
+		return nil, err
+	}
+	if model.SpamMessages < spamMinTrainings || model.HamMessages < spamMinTrainings {
+		return verdict, nil
+	}
+
+	tokens, err := f.messageTokens(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	corpus, err := f.corpus(db, model, tokens)
+	if err != nil {
+		return nil, err
+	}
+	score := corpus.score(tokens)
+	now := time.Now()
+	verdict.Score, verdict.ScoredAt = &score, &now
+	if err := db.Omit("Message").Save(verdict).Error; err != nil {
+		return nil, fmt.Errorf("failed to store spam verdict: %w", err)
+	}
+
+	if score >= model.Threshold && message.Folder == "" {
+		if err := f.move(ctx, &message, SpamFolder); err != nil {
+			return nil, err
+		}
+		config.Logger.Info().
+			Uint("messageID", messageID).
+			Float64("score", score).
+			Msg("Moved likely spam aside")
+	}
+	return verdict, nil
+}
+
+// Train labels a message as spam or not spam and learns from it,
+// unlearning an earlier opposite label. A message marked spam moves to
+// SpamFolder, and one marked not spam back from it to the inbox.
+func (f *SpamFilter) Train(ctx context.Context, messageID uint, spam bool) error {
+	var message entities.Message
+	found := f.db.WithContext(ctx).Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return ErrEmailNotFound
+	}
+	tokens, err := f.messageTokens(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	label := entities.SpamLabelHam
+	if spam {
+		label = entities.SpamLabelSpam
+	}
+	err = f.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		verdict, err := f.verdict(tx, messageID)
+		if err != nil {
+			return err
+		}
+		if verdict.Label == label {
+			return nil
+		}
+		if verdict.Label != "" {
+			if err := f.learn(tx, tokens, verdict.Label == entities.SpamLabelSpam, -1); err != nil {
+				return err
+			}
+		}
+		if err := f.learn(tx, tokens, spam, 1); err != nil {
+			return err
+		}
+		verdict.Label = label
+		if err := tx.Omit("Message").Save(verdict).Error; err != nil {
+			return fmt.Errorf("failed to store spam verdict: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	config.Logger.Info().Uint("messageID", messageID).Str("label", label).Msg("Spam classifier trained")
+
+	switch {
+	case spam && message.Folder != SpamFolder:
+		return f.move(ctx, &message, SpamFolder)
+	case !spam && message.Folder == SpamFolder:
+		return f.move(ctx, &message, "")
+	}
+	return nil
+}
+
+// learn adds the tokens of a message trained as spam or not spam to the
+// counts, or removes them when delta is -1
+func (f *SpamFilter) learn(tx *gorm.DB, tokens []string, spam bool, delta int) error {
+	if _, err := f.model(tx); err != nil {
+		return err
+	}
+	messages, column := "ham_messages", "ham"
+	if spam {
+		messages, column = "spam_messages", "spam"
+	}
+	err := tx.Model(&entities.SpamModel{}).Where("id = ?", spamModelID).
+		Update(messages, gorm.Expr(messages+" + ?", delta)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update spam model: %w", err)
+	}
+
+	for start := 0; start < len(tokens); start += spamTokenBatchSize {
+		batch := tokens[start:min(start+spamTokenBatchSize, len(tokens))]
+		if delta < 0 {
+			err := tx.Model(&entities.SpamToken{}).Where("token IN ?", batch).
+				Update(column, gorm.Expr("MAX("+column+" - 1, 0)")).Error
+			if err != nil {
+				return fmt.Errorf("failed to update spam tokens: %w", err)
+			}
+			continue
+		}
+		rows := make([]entities.SpamToken, len(batch))
+		for i, token := range batch {
+			rows[i] = entities.SpamToken{Token: token}
+			if spam {
+				rows[i].Spam = 1
+			} else {
+				rows[i].Ham = 1
+			}
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "token"}},
+			DoUpdates: clause.Set{{Column: clause.Column{Name: column}, Value: gorm.Expr("spam_tokens." + column + " + 1")}},
+		}).Create(&rows).Error
+		if err != nil {
+			return fmt.Errorf("failed to update spam tokens: %w", err)
+		}
+	}
+	if delta < 0 {
+		if err := tx.Where("spam = 0 AND ham = 0").Delete(&entities.SpamToken{}).Error; err != nil {
+			return fmt.Errorf("failed to prune spam tokens: %w", err)
+		}
+	}
+	return nil
+}
+
+// corpus loads the counts of the given tokens
+func (f *SpamFilter) corpus(db *gorm.DB, model *entities.SpamModel, tokens []string) (*spamCorpus, error) {
+	corpus := newSpamCorpus()
+	corpus.spamMessages, corpus.hamMessages = model.SpamMessages, model.HamMessages
+	for start := 0; start < len(tokens); start += spamTokenBatchSize {
+		var rows []entities.SpamToken
+		batch := tokens[start:min(start+spamTokenBatchSize, len(tokens))]
+		if err := db.Where("token IN ?", batch).Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to load spam tokens: %w", err)
+		}
+		for _, row := range rows {
+			corpus.tokens[row.Token] = spamCount{spam: row.Spam, ham: row.Ham}
+		}
+	}
+	return corpus, nil
+}
+
+// messageTokens tokenizes the source of a stored message
+func (f *SpamFilter) messageTokens(ctx context.Context, messageID uint) ([]string, error) {
+	source, err := emailSource(ctx, f.db, f.emailService, f.store, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return spamTokens(source.Data), nil
+}
+
+// model returns the classifier's totals and settings, creating them on
+// first use
+func (f *SpamFilter) model(db *gorm.DB) (*entities.SpamModel, error) {
+	model := entities.SpamModel{ID: spamModelID, Threshold: DefaultSpamThreshold}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model).Error; err != nil {
+		return nil, fmt.Errorf("failed to create spam model: %w", err)
+	}
+	if err := db.First(&model, spamModelID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load spam model: %w", err)
+	}
+	return &model, nil
+}
+
+// verdict returns the stored verdict of a message, or a new one
+func (f *SpamFilter) verdict(db *gorm.DB, messageID uint) (*entities.SpamVerdict, error) {
+	verdict := entities.SpamVerdict{MessageID: messageID}
+	if err := db.Where("message_id = ?", messageID).Limit(1).Find(&verdict).Error; err != nil {
+		return nil, fmt.Errorf("failed to load spam verdict: %w", err)
+	}
+	return &verdict, nil
+}
+
+// move puts a message in a folder
+func (f *SpamFilter) move(ctx context.Context, message *entities.Message, folder string) error {
+	err := f.db.WithContext(ctx).Model(&entities.Message{}).Where("id = ?", message.ID).Update("folder", folder).Error
+	if err != nil {
+		return fmt.Errorf("failed to move message: %w", err)
+	}
+	message.Folder = folder
+	f.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: message.ID,
+		AccountID: message.AccountID,
+	})
+	return nil
+}
@@ -0,0 +1,490 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/rfc5322"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Custom error types
+var (
+	ErrMailingListNotFound = errors.New("email is not from a mailing list")
+	ErrNoUnsubscribe       = errors.New("mailing list offers no way to unsubscribe")
+)
+
+// Ways of unsubscribing from a list
+const (
+	UnsubscribeOneClick = "one-click" // An RFC 8058 POST was made
+	UnsubscribeMailto   = "mailto"    // An unsubscribe request was mailed
+	UnsubscribeWeb      = "web"       // The user has to open a page
+)
+
+// oneClickBody is the body of an RFC 8058 unsubscribe request
+const oneClickBody = "List-Unsubscribe=One-Click"
+
+// maxMailingListField bounds the List-* values kept
+const maxMailingListField = 2048
+
+// listHeaders are the header fields read to find a message's list
+var listHeaders = []string{"list-id", "list-unsubscribe", "list-unsubscribe-post"}
+
+// Subscription is a mailing list with statistics on its messages
+type Subscription struct {
+	List         *entities.MailingList
+	Messages     int        // Messages stored from the list
+	Unread       int        // Of which unread
+	LastReceived *time.Time // Receipt time of the newest message
+	LastRead     *time.Time // Receipt time of the newest message read
+}
+
+// UnsubscribeResult tells how the user was unsubscribed from a list
+type UnsubscribeResult struct {
+	List   *entities.MailingList
+	Method string // UnsubscribeOneClick, UnsubscribeMailto or UnsubscribeWeb
+	URL    string // Page to open for UnsubscribeWeb
+}
+
+// SubscriptionService groups messages by the mailing list or newsletter
+// they came from and unsubscribes from lists. Lists are found from the
+// List-Id and List-Unsubscribe header fields as messages arrive.
+type SubscriptionService struct {
+	db          *gorm.DB
+	sendService *SendService
+	client      *http.Client
+	events      *events.Bus
+}
+
+// NewSubscriptionService creates a new SubscriptionService. Unsubscribe
+// requests are mailed through sendService and posted with client, which
+// defaults to one that only connects to public addresses. Redirects are
+// never followed.
+func NewSubscriptionService(db *gorm.DB, sendService *SendService, client *http.Client) *SubscriptionService {
+	config.Logger.Debug().Msg("Initializing subscription service")
+	if client == nil {
+		client = publicHTTPClient()
+	}
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &SubscriptionService{db: db, sendService: sendService, client: &noRedirects}
+}
+
+// SetEventBus sets the bus that grouped messages are published to
+func (s *SubscriptionService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// Subscribe groups every message created on bus. It returns a function
+// that stops grouping.
+func (s *SubscriptionService) Subscribe(bus *events.Bus) func() {
+	return bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		payload, ok := e.Payload.(events.MessagePayload)
+		if !ok {
+			return
+		}
+		if _, err := s.Detect(context.Background(), payload.MessageID); err != nil {
+			config.Logger.Error().
+				Err(err).
+				Uint("messageID", payload.MessageID).
+				Msg("Failed to detect mailing list")
+		}
+	})
+}
+
+// Detect finds the list a message came from, recording the list or
+// updating its unsubscribe addresses, and assigns the message to it. It
+// returns nil for messages from no list.
+func (s *SubscriptionService) Detect(ctx context.Context, messageID uint) (*entities.MailingList, error) {
+	db := s.db.WithContext(ctx)
+	var message entities.Message
+	found := db.Preload("Account").Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 || !isIncoming(&message) {
+		return nil, nil
+	}
+	header, err := s.listHeader(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	list := parseMailingList(header, &message)
+	if list == nil {
+		return nil, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing entities.MailingList
+		result := tx.Where("account_id = ? AND list_id = ?", list.AccountID, list.ListID).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return fmt.Errorf("failed to look up mailing list: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			list.ID, list.CreatedAt, list.UnsubscribedAt = existing.ID, existing.CreatedAt, existing.UnsubscribedAt
+			// Older messages do not replace what newer ones said
+			var newest entities.Message
+			newer := tx.Select("id").
+				Where("mailing_list_id = ? AND received_datetime > ?", existing.ID, message.ReceivedDatetime).
+				Limit(1).Find(&newest)
+			if newer.Error != nil {
+				return fmt.Errorf("failed to look up mailing list messages: %w", newer.Error)
+			}
+			if newer.RowsAffected > 0 || message.ReceivedDatetime == nil {
+				*list = existing
+			}
+		}
+		if err := tx.Omit("Account").Save(list).Error; err != nil {
+			return fmt.Errorf("failed to store mailing list: %w", err)
+		}
+		err := tx.Model(&entities.Message{}).Where("id = ?", messageID).Update("mailing_list_id", list.ID).Error
+		if err != nil {
+			return fmt.Errorf("failed to group message: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish(events.TopicMessageUpdated, events.MessagePayload{
+		MessageID: messageID,
+		AccountID: message.AccountID,
+	})
+	return list, nil
+}
+
+// DetectMissing groups the messages stored with List-* header fields
+// before lists were detected. It returns how many were grouped.
+func (s *SubscriptionService) DetectMissing(ctx context.Context) (int, error) {
+	var ids []uint
+	err := s.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Where("mailing_list_id IS NULL").
+		Where("id IN (?)", s.db.Model(&entities.MessageHeader{}).
+			Select("message_id").
+			Where("LOWER(name) IN ?", listHeaders[:2])).
+		Order("received_datetime, id").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find ungrouped messages: %w", err)
+	}
+
+	grouped := 0
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return grouped, err
+		}
+		list, err := s.Detect(ctx, id)
+		if err != nil {
+			return grouped, err
+		}
+		if list != nil {
+			grouped++
+		}
+	}
+	return grouped, nil
+}
+
+// List returns the lists an account receives with statistics on their
+// messages, the most prolific first
+func (s *SubscriptionService) List(ctx context.Context, accountID uint) ([]*Subscription, error) {
+	var lists []*entities.MailingList
+	if err := s.db.WithContext(ctx).Where("account_id = ?", accountID).Find(&lists).Error; err != nil {
+		return nil, fmt.Errorf("failed to list mailing lists: %w", err)
+	}
+
+	var stats []struct {
+		MailingListID uint
+		Messages      int
+		Unread        int
+		LastReceived  string
+		LastRead      string
+	}
+	err := s.db.WithContext(ctx).
+		Model(&entities.Message{}).
+		Select(`mailing_list_id,
+			COUNT(*) AS messages,
+			SUM(CASE WHEN is_read THEN 0 ELSE 1 END) AS unread,
+			COALESCE(MAX(received_datetime), '') AS last_received,
+			COALESCE(MAX(CASE WHEN is_read THEN received_datetime END), '') AS last_read`).
+		Where("account_id = ? AND mailing_list_id IS NOT NULL", accountID).
+		Group("mailing_list_id").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count mailing list messages: %w", err)
+	}
+
+	subscriptions := make([]*Subscription, 0, len(lists))
+	byID := make(map[uint]*Subscription, len(lists))
+	for _, list := range lists {
+		subscription := &Subscription{List: list}
+		subscriptions = append(subscriptions, subscription)
+		byID[list.ID] = subscription
+	}
+	for _, stat := range stats {
+		subscription, ok := byID[stat.MailingListID]
+		if !ok {
+			continue
+		}
+		subscription.Messages, subscription.Unread = stat.Messages, stat.Unread
+		subscription.LastReceived = parseSQLiteTime(stat.LastReceived)
+		subscription.LastRead = parseSQLiteTime(stat.LastRead)
+	}
+	sortSubscriptions(subscriptions)
+	return subscriptions, nil
+}
+
+// Unsubscribe unsubscribes from the list an email came from, using the
+// List-Unsubscribe field of that email: with an RFC 8058 one-click POST
+// if the list offers it, else by mailing the mailto address from the
+// email's account. Lists offering only a web page are returned with the
+// page for the user to open.
+func (s *SubscriptionService) Unsubscribe(ctx context.Context, messageID uint) (*UnsubscribeResult, error) {
+	var message entities.Message
+	found := s.db.WithContext(ctx).Preload("Account").Limit(1).Find(&message, messageID)
+	if found.Error != nil {
+		return nil, fmt.Errorf("failed to load message: %w", found.Error)
+	}
+	if found.RowsAffected == 0 {
+		return nil, ErrEmailNotFound
+	}
+	if message.MailingListID == nil {
+		return nil, ErrMailingListNotFound
+	}
+	var list entities.MailingList
+	if err := s.db.WithContext(ctx).First(&list, *message.MailingListID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load mailing list: %w", err)
+	}
+	// The email's own addresses may be newer or older than the list's
+	header, err := s.listHeader(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if own := parseMailingList(header, &message); own != nil && (own.UnsubscribeURL != "" || own.UnsubscribeMailto != "") {
+		list.UnsubscribeURL, list.UnsubscribeMailto, list.OneClick = own.UnsubscribeURL, own.UnsubscribeMailto, own.OneClick
+	}
+
+	result := &UnsubscribeResult{List: &list}
+	switch {
+	case list.OneClick:
+		if err := s.postOneClick(ctx, list.UnsubscribeURL); err != nil {
+			return nil, err
+		}
+		result.Method = UnsubscribeOneClick
+	case list.UnsubscribeMailto != "":
+		if err := s.mailUnsubscribe(ctx, &message, list.UnsubscribeMailto); err != nil {
+			return nil, err
+		}
+		result.Method = UnsubscribeMailto
+	case list.UnsubscribeURL != "":
+		result.Method, result.URL = UnsubscribeWeb, list.UnsubscribeURL
+		return result, nil
+	default:
+		return nil, ErrNoUnsubscribe
+	}
+
+	now := time.Now()
+	list.UnsubscribedAt = &now
+	err = s.db.WithContext(ctx).Model(&entities.MailingList{}).Where("id = ?", list.ID).Update("unsubscribed_at", now).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to record unsubscription: %w", err)
+	}
+	config.Logger.Info().
+		Uint("mailingListID", list.ID).
+		Str("list", list.ListID).
+		Str("method", result.Method).
+		Msg("Unsubscribed from mailing list")
+	return result, nil
+}
+
+// postOneClick makes the POST of RFC 8058 section 3.2, without cookies or
+// credentials
+func (s *SubscriptionService) postOneClick(ctx context.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(oneClickBody))
+	if err != nil {
+		return fmt.Errorf("invalid unsubscribe URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unsubscribe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unsubscribe request failed: %s", resp.Status)
+	}
+	return nil
+}
+
+// mailUnsubscribe sends the request a mailto URI describes (RFC 6068)
+// from the account that received the email
+func (s *SubscriptionService) mailUnsubscribe(ctx context.Context, message *entities.Message, target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid unsubscribe address: %w", err)
+	}
+	to, err := url.PathUnescape(u.Opaque)
+	if err != nil {
+		return fmt.Errorf("invalid unsubscribe address: %w", err)
+	}
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid unsubscribe address: %w", err)
+	}
+	query := u.Query()
+	subject := query.Get("subject")
+	if subject == "" {
+		subject = "unsubscribe"
+	}
+	_, err = s.sendService.Send(ctx, &OutgoingEmail{
+		AccountID: message.AccountID,
+		To:        []*mail.Address{addr},
+		Subject:   subject,
+		Text:      query.Get("body"),
+	})
+	return err
+}
+
+// listHeader returns the List-* fields stored for a message
+func (s *SubscriptionService) listHeader(ctx context.Context, messageID uint) (rfc5322.Header, error) {
+	var rows []entities.MessageHeader
+	err := s.db.WithContext(ctx).
+		Where("message_id = ? AND LOWER(name) IN ?", messageID, listHeaders).
+		Order("position").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load headers: %w", err)
+	}
+	header := make(rfc5322.Header, len(rows))
+	for i, row := range rows {
+		header[i] = rfc5322.Field{Name: row.Name, Value: row.Value}
+	}
+	return header, nil
+}
+
+// parseMailingList reads the list a message came from off its List-*
+// fields, or returns nil. Messages with List-Unsubscribe but no List-Id,
+// as most newsletters send, are grouped by sender.
+func parseMailingList(header rfc5322.Header, message *entities.Message) *entities.MailingList {
+	list := &entities.MailingList{
+		AccountID:   message.AccountID,
+		SenderEmail: strings.ToLower(message.SenderEmail),
+		Name:        stringOrEmpty(message.SenderName),
+	}
+	for _, uri := range parseListURIs(header.Get("List-Unsubscribe")) {
+		u, err := url.Parse(uri)
+		if err != nil || len(uri) > maxMailingListField {
+			continue
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "https", "http":
+			if list.UnsubscribeURL == "" && u.Host != "" {
+				list.UnsubscribeURL = uri
+			}
+		case "mailto":
+			if list.UnsubscribeMailto == "" {
+				list.UnsubscribeMailto = uri
+			}
+		}
+	}
+	// RFC 8058 section 3.1: one-click needs HTTPS and the exact POST body
+	list.OneClick = strings.HasPrefix(strings.ToLower(list.UnsubscribeURL), "https:") &&
+		strings.EqualFold(strings.TrimSpace(header.Get("List-Unsubscribe-Post")), oneClickBody)
+
+	if value := header.Get("List-Id"); value != "" {
+		name, id := parseListID(rfc5322.DecodeHeader(value))
+		if id != "" && len(id) <= maxMailingListField {
+			list.ListID = id
+			if name != "" {
+				list.Name = name
+			}
+		}
+	}
+	if list.ListID == "" {
+		if list.UnsubscribeURL == "" && list.UnsubscribeMailto == "" || list.SenderEmail == "" {
+			return nil
+		}
+		list.ListID = list.SenderEmail
+	}
+	if list.Name == "" {
+		list.Name = list.ListID
+	}
+	return list
+}
+
+// parseListID splits a List-Id value, as in `Go Nuts <golang-nuts.example>`,
+// into its description and lower-cased identifier
+func parseListID(value string) (string, string) {
+	start, end := strings.LastIndex(value, "<"), strings.LastIndex(value, ">")
+	if start < 0 || end < start {
+		return "", strings.ToLower(strings.TrimSpace(value))
+	}
+	name := strings.Trim(strings.TrimSpace(value[:start]), `"`)
+	return name, strings.ToLower(strings.TrimSpace(value[start+1 : end]))
+}
+
+// parseListURIs returns the URIs of an RFC 2369 field, written in angle
+// brackets and separated by commas
+func parseListURIs(value string) []string {
+	var uris []string
+	for {
+		start := strings.Index(value, "<")
+		if start < 0 {
+			return uris
+		}
+		end := strings.Index(value[start:], ">")
+		if end < 0 {
+			return uris
+		}
+		uri := strings.Join(strings.Fields(value[start+1:start+end]), "")
+		if uri != "" {
+			uris = append(uris, uri)
+		}
+		value = value[start+end+1:]
+	}
+}
+
+// sortSubscriptions orders subscriptions by message count, then by name
+func sortSubscriptions(subscriptions []*Subscription) {
+	sort.SliceStable(subscriptions, func(i, j int) bool {
+		a, b := subscriptions[i], subscriptions[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return strings.ToLower(a.List.Name) < strings.ToLower(b.List.Name)
+	})
+}
+
+// sqliteTimeLayouts are the layouts the SQLite driver writes times in
+var sqliteTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+// parseSQLiteTime parses a time an aggregate returned as text, or returns
+// nil for an empty or unknown value
+func parseSQLiteTime(value string) *time.Time {
+	for _, layout := range sqliteTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
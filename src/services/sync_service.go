@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"palm/src/config"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/repositories"
+	"sync"
+)
+
+// Custom error types
+var (
+	ErrNoSyncSource = errors.New("no sync source for account type")
+	// ErrAuthExpired is returned (wrapped) by mail sources whose credentials
+	// were rejected by the provider
+	ErrAuthExpired = errors.New("account authorization expired")
+)
+
+// MailSource fetches messages for an account from its provider.
+// Implementations call emit once per message; returning an error from emit
+// aborts the fetch.
+type MailSource interface {
+	Fetch(ctx context.Context, account *entities.Account, emit func(*EmailDTO) error) error
+}
+
+// SyncResult summarizes a synchronization run for one account
+type SyncResult struct {
+	AccountID uint   `json:"accountId"`
+	Email     string `json:"email"`
+	Fetched   int    `json:"fetched"`
+	Created   int    `json:"created"`
+	Failed    int    `json:"failed"`
+}
+
+// SyncService pulls messages from the registered mail sources into the database
+type SyncService struct {
+	accountRepo  repositories.AccountRepository
+	emailService *EmailService
+	events       *events.Bus
+
+	mu      sync.RWMutex
+	sources map[string]MailSource
+}
+
+// NewSyncService creates a new SyncService with no sources registered
+func NewSyncService(accountRepo repositories.AccountRepository, emailService *EmailService) *SyncService {
+	config.Logger.Debug().Msg("Initializing sync service")
+	return &SyncService{
+		accountRepo:  accountRepo,
+		emailService: emailService,
+		sources:      make(map[string]MailSource),
+	}
+}
+
+// SetEventBus sets the bus that sync and account events are published to
+func (s *SyncService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// RegisterSource sets the source used to sync accounts of the given type
+func (s *SyncService) RegisterSource(accountType string, source MailSource) {
+	config.Logger.Debug().Str("accountType", accountType).Msg("Registering sync source")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources[accountType] = source
+}
+
+func (s *SyncService) source(accountType string) (MailSource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	source, ok := s.sources[accountType]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrNoSyncSource, accountType)
+	}
+	return source, nil
+}
+
+// SyncAccount fetches new messages for a single account and stores them
+func (s *SyncService) SyncAccount(ctx context.Context, accountID uint) (*SyncResult, error) {
+	config.Logger.Info().Uint("accountID", accountID).Msg("Syncing account")
+
+	account, err := s.accountRepo.GetByID(ctx, accountID)
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", accountID).
+			Msg("Failed to get account for sync")
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+
+	source, err := s.source(account.AccountType)
+	if err != nil {
+		config.Logger.Warn().
+			Uint("accountID", accountID).
+			Str("accountType", account.AccountType).
+			Msg("No sync source for account type")
+		return nil, err
+	}
+
+	result := &SyncResult{AccountID: account.ID, Email: account.Email}
+	s.events.Publish(events.TopicSyncStarted, result.payload(nil))
+
+	err = source.Fetch(ctx, account, func(email *EmailDTO) error {
+		result.Fetched++
+		if email.Message != nil {
+			email.Message.AccountID = account.ID
+		}
+		email.Origin = events.OriginSync
+		if err := s.emailService.Create(ctx, email); err != nil {
+			result.Failed++
+			config.Logger.Warn().
+				Err(err).
+				Uint("accountID", account.ID).
+				Msg("Failed to store synced email")
+		} else {
+			result.Created++
+		}
+		s.events.Publish(events.TopicSyncProgress, result.payload(nil))
+		return ctx.Err()
+	})
+	if err != nil {
+		config.Logger.Error().
+			Err(err).
+			Uint("accountID", account.ID).
+			Int("created", result.Created).
+			Msg("Account sync failed")
+		if errors.Is(err, ErrAuthExpired) {
+			s.events.Publish(events.TopicAccountAuthExpired, events.AccountPayload{
+				AccountID: account.ID,
+				Email:     account.Email,
+				Reason:    err.Error(),
+			})
+		}
+		s.events.Publish(events.TopicSyncFailed, result.payload(err))
+		return result, fmt.Errorf("failed to sync account %s: %w", account.Email, err)
+	}
+
+	s.events.Publish(events.TopicSyncFinished, result.payload(nil))
+
+	config.Logger.Info().
+		Uint("accountID", account.ID).
+		Int("fetched", result.Fetched).
+		Int("created", result.Created).
+		Int("failed", result.Failed).
+		Msg("Account synced successfully")
+
+	return result, nil
+}
+
+// payload converts the result to the payload of a sync event
+func (r *SyncResult) payload(err error) events.SyncPayload {
+	payload := events.SyncPayload{
+		AccountID: r.AccountID,
+		Email:     r.Email,
+		Fetched:   r.Fetched,
+		Created:   r.Created,
+		Failed:    r.Failed,
+	}
+	if err != nil {
+		payload.Error = err.Error()
+	}
+	return payload
+}
+
+// SyncAll syncs every account, continuing past failures. The returned error
+// joins the errors of all accounts that failed.
+func (s *SyncService) SyncAll(ctx context.Context) ([]*SyncResult, error) {
+	accounts, err := s.accountRepo.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+
+	results := make([]*SyncResult, 0, len(accounts))
+	var errs []error
+	for _, account := range accounts {
+		result, err := s.SyncAccount(ctx, account.ID)
+		if result != nil {
+			results = append(results, result)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return results, errors.Join(errs...)
+}
@@ -0,0 +1,93 @@
+package events_test
+
+import (
+	"palm/src/events"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type emitted struct {
+	name    string
+	payload interface{}
+}
+
+// recorder collects the events a bridge forwards
+type recorder struct {
+	mu     sync.Mutex
+	events []emitted
+}
+
+func (r *recorder) emit(name string, payload interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, emitted{name: name, payload: payload})
+}
+
+func (r *recorder) snapshot() []emitted {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]emitted(nil), r.events...)
+}
+
+func TestBridge_CoalescesMessageEvents(t *testing.T) {
+	bus := events.NewBus()
+	rec := &recorder{}
+	bridge := events.NewBridge(bus, rec.emit, time.Hour)
+	defer bridge.Close()
+
+	// A large sync produces thousands of events
+	for i := 1; i <= 5000; i++ {
+		bus.Publish(events.TopicMessageCreated, events.MessagePayload{MessageID: uint(i), AccountID: uint(1 + i%2)})
+		bus.Publish(events.TopicSyncProgress, events.SyncPayload{AccountID: 1, Created: i})
+	}
+	assert.Empty(t, rec.snapshot(), "nothing is forwarded before the window elapses")
+
+	bridge.Flush()
+
+	got := rec.snapshot()
+	require.Len(t, got, 2)
+
+	assert.Equal(t, string(events.TopicMessageCreated), got[0].name)
+	batch := got[0].payload.(events.MessageBatchPayload)
+	assert.Equal(t, 5000, batch.Count)
+	assert.True(t, batch.Truncated)
+	assert.Less(t, len(batch.MessageIDs), 5000)
+	assert.Equal(t, []uint{1, 2}, batch.AccountIDs)
+
+	// Only the latest progress per account is forwarded
+	assert.Equal(t, string(events.TopicSyncProgress), got[1].name)
+	assert.Equal(t, 5000, got[1].payload.(events.SyncPayload).Created)
+}
+
+func TestBridge_ImmediateEventsFlushPending(t *testing.T) {
+	bus := events.NewBus()
+	rec := &recorder{}
+	bridge := events.NewBridge(bus, rec.emit, time.Hour)
+	defer bridge.Close()
+
+	bus.Publish(events.TopicMessageCreated, events.MessagePayload{MessageID: 1, AccountID: 1})
+	bus.Publish(events.TopicSyncFinished, events.SyncPayload{AccountID: 1, Created: 1})
+
+	got := rec.snapshot()
+	require.Len(t, got, 2)
+	assert.Equal(t, string(events.TopicMessageCreated), got[0].name)
+	assert.Equal(t, string(events.TopicSyncFinished), got[1].name)
+}
+
+func TestBridge_FlushesAfterWindow(t *testing.T) {
+	bus := events.NewBus()
+	rec := &recorder{}
+	bridge := events.NewBridge(bus, rec.emit, 10*time.Millisecond)
+	defer bridge.Close()
+
+	bus.Publish(events.TopicMessageDeleted, events.MessagePayload{MessageID: 7, AccountID: 1})
+
+	assert.Eventually(t, func() bool { return len(rec.snapshot()) == 1 }, time.Second, 5*time.Millisecond)
+	got := rec.snapshot()
+	assert.Equal(t, string(events.TopicMessageDeleted), got[0].name)
+	assert.Equal(t, []uint{7}, got[0].payload.(events.MessageBatchPayload).MessageIDs)
+}
@@ -0,0 +1,43 @@
+package events_test
+
+import (
+	"palm/src/events"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus_PublishSubscribe(t *testing.T) {
+	bus := events.NewBus()
+
+	var created, all []events.Event
+	unsubscribe := bus.Subscribe(events.TopicMessageCreated, func(e events.Event) {
+		created = append(created, e)
+	})
+	bus.SubscribeAll(func(e events.Event) {
+		all = append(all, e)
+	})
+
+	bus.Publish(events.TopicMessageCreated, events.MessagePayload{MessageID: 1, AccountID: 2})
+	bus.Publish(events.TopicSyncStarted, events.SyncPayload{AccountID: 2})
+
+	assert.Len(t, created, 1)
+	assert.Equal(t, events.MessagePayload{MessageID: 1, AccountID: 2}, created[0].Payload)
+	assert.Len(t, all, 2)
+
+	// Unsubscribed handlers no longer receive events
+	unsubscribe()
+	bus.Publish(events.TopicMessageCreated, events.MessagePayload{MessageID: 3})
+	assert.Len(t, created, 1)
+	assert.Len(t, all, 3)
+}
+
+func TestBus_NilIsNoop(t *testing.T) {
+	var bus *events.Bus
+
+	assert.NotPanics(t, func() {
+		unsubscribe := bus.SubscribeAll(func(events.Event) {})
+		bus.Publish(events.TopicMessageCreated, events.MessagePayload{})
+		unsubscribe()
+	})
+}
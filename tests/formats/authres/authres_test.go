@@ -0,0 +1,71 @@
+package authres_test
+
+import (
+	"palm/src/formats/authres"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	results, err := authres.Parse(`mx.google.com;
+       dkim=pass header.i=@example.com header.s=sel header.b=AbCdEf;
+       dkim=fail (bad signature) header.d=list.example.org;
+       arc=pass (i=1 spf=pass spfdomain=example.com);
+       spf = softfail (google.com: domain of transitioning bounce@example.com does not designate 192.0.2.1 as permitted sender) smtp.mailfrom=bounce@example.com;
+       dmarc=pass (p=REJECT sp=REJECT dis=NONE) header.from=example.com;
+       iprev/1=pass reason="reverse ; lookup" policy.iprev=192.0.2.1`)
+	require.NoError(t, err)
+
+	assert.Equal(t, "mx.google.com", results.AuthServID)
+	require.Len(t, results.Results, 6)
+
+	dkim, ok := results.Get("dkim")
+	require.True(t, ok)
+	assert.Equal(t, "pass", dkim.Value, "a passing signature is preferred")
+	assert.Equal(t, "@example.com", dkim.Properties["header.i"])
+	assert.Len(t, results.All("dkim"), 2)
+	assert.Equal(t, "list.example.org", results.All("dkim")[1].Properties["header.d"])
+
+	spf, _ := results.Get("spf")
+	assert.Equal(t, "softfail", spf.Value, "comments and spaces around = are ignored")
+	assert.Equal(t, "bounce@example.com", spf.Properties["smtp.mailfrom"])
+
+	iprev, _ := results.Get("iprev")
+	assert.Equal(t, "pass", iprev.Value, "versions are ignored")
+	assert.Equal(t, "reverse ; lookup", iprev.Reason, "quoted strings may contain separators")
+
+	_, ok = results.Get("auth")
+	assert.False(t, ok)
+
+	none, err := authres.Parse("example.org 1; none")
+	require.NoError(t, err)
+	assert.Equal(t, "example.org", none.AuthServID)
+	assert.Empty(t, none.Results)
+
+	_, err = authres.Parse("example.org; dkim")
+	assert.ErrorIs(t, err, authres.ErrMalformed)
+	_, err = authres.Parse(" ; spf=pass")
+	assert.ErrorIs(t, err, authres.ErrMalformed)
+}
+
+func TestParseARC(t *testing.T) {
+	seal, err := authres.ParseARCSeal("i=2; a=rsa-sha256; t=1700000000; cv=pass;\r\n d=lists.example.org; s=arc; b=AAAA")
+	require.NoError(t, err)
+	assert.Equal(t, authres.ARCSeal{Instance: 2, ChainValidation: "pass", Domain: "lists.example.org"}, seal)
+
+	_, err = authres.ParseARCSeal("a=rsa-sha256; d=example.org")
+	assert.ErrorIs(t, err, authres.ErrMalformed)
+
+	instance, results, err := authres.ParseARCResults("i=1; mx.example.org; dkim=pass header.d=example.com; dmarc=pass header.from=example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 1, instance)
+	assert.Equal(t, "mx.example.org", results.AuthServID)
+	dmarc, ok := results.Get("dmarc")
+	assert.True(t, ok)
+	assert.Equal(t, "pass", dmarc.Value)
+
+	_, _, err = authres.ParseARCResults("mx.example.org; dkim=pass")
+	assert.ErrorIs(t, err, authres.ErrMalformed)
+}
@@ -0,0 +1,237 @@
+package dkim_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"palm/src/formats/dkim"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const message = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.org\r\n" +
+	"Subject: Quarterly report\r\n" +
+	"Date: Tue, 2 Jan 2024 10:30:00 +0100\r\n" +
+	"Message-ID: <report-1@example.com>\r\n" +
+	"\r\n" +
+	"Hi Bob,\r\n" +
+	"\r\n" +
+	"the report is attached.\r\n" +
+	"\r\n" +
+	"\r\n"
+
+// staticResolver answers TXT lookups from a map; missing names do not
+// exist and names mapped to nil fail temporarily
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	switch {
+	case !ok:
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	case records == nil:
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	return records, nil
+}
+
+// rsaKey returns a key and its TXT record
+func rsaKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return key, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+}
+
+// sign signs message and prepends the signature
+func sign(t *testing.T, message string, key crypto.Signer, selector string) string {
+	field, err := dkim.Sign([]byte(message), dkim.SignOptions{Domain: "example.com", Selector: selector, Key: key})
+	require.NoError(t, err)
+	return field + message
+}
+
+func TestVerify_RoundTrip(t *testing.T) {
+	rsaPrivate, rsaRecord := rsaKey(t)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	resolver := staticResolver{
+		"rsa._domainkey.example.com": {rsaRecord[:60], rsaRecord[60:]},
+		"ed._domainkey.example.com":  {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPublic)},
+	}
+	ctx := context.Background()
+
+	signed := sign(t, sign(t, message, rsaPrivate, "rsa"), edPrivate, "ed")
+	verifications := dkim.Verify(ctx, resolver, []byte(signed))
+	require.Len(t, verifications, 2)
+	for _, v := range verifications {
+		assert.Equal(t, dkim.Pass, v.Result, v.Err)
+		assert.Equal(t, "example.com", v.Domain)
+		assert.Equal(t, "@example.com", v.Identity)
+	}
+	assert.Equal(t, "ed25519-sha256", verifications[0].Algorithm)
+	assert.Equal(t, "rsa-sha256", verifications[1].Algorithm)
+
+	// Relaxed canonicalization survives whitespace changes in transit
+	// and messages stored with bare LF line endings
+	reformatted := strings.NewReplacer(
+		"Subject: Quarterly report", "subject:   Quarterly\r\n\treport ",
+		"the report is attached.", "the  report\tis attached.  ",
+	).Replace(signed)
+	for _, v := range dkim.Verify(ctx, resolver, []byte(strings.ReplaceAll(reformatted, "\r\n", "\n"))) {
+		assert.Equal(t, dkim.Pass, v.Result, v.Err)
+	}
+
+	// Trailing empty lines are not signed
+	for _, v := range dkim.Verify(ctx, resolver, []byte(signed+"\r\n\r\n")) {
+		assert.Equal(t, dkim.Pass, v.Result, v.Err)
+	}
+
+	// No signature, no verification
+	assert.Empty(t, dkim.Verify(ctx, resolver, []byte(message)))
+}
+
+// rfc8463Message is the signed example of RFC 8463, appendix A
+const rfc8463Message = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func TestVerify_RFC8463(t *testing.T) {
+	resolver := staticResolver{
+		"brisbane._domainkey.football.example.com": {"v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="},
+	}
+	verifications := dkim.Verify(context.Background(), resolver, []byte(rfc8463Message))
+	require.Len(t, verifications, 1)
+	assert.Equal(t, dkim.Pass, verifications[0].Result, verifications[0].Err)
+	assert.Equal(t, "football.example.com", verifications[0].Domain)
+	assert.Equal(t, "brisbane", verifications[0].Selector)
+}
+
+func TestVerify_Failures(t *testing.T) {
+	key, record := rsaKey(t)
+	signed := sign(t, message, key, "sel")
+	ctx := context.Background()
+	weak, err := rsa.GenerateKey(rand.Reader, 512)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&weak.PublicKey)
+	require.NoError(t, err)
+	weakRecord := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+
+	tests := []struct {
+		name     string
+		message  string
+		resolver staticResolver
+		result   dkim.Result
+		err      error
+	}{
+		{
+			name:     "altered body",
+			message:  strings.Replace(signed, "is attached", "is at https://evil.example/", 1),
+			resolver: staticResolver{"sel._domainkey.example.com": {record}},
+			result:   dkim.Fail,
+			err:      dkim.ErrBodyHashMismatch,
+		},
+		{
+			name:     "altered header",
+			message:  strings.Replace(signed, "Quarterly report", "Urgent: wire transfer", 1),
+			resolver: staticResolver{"sel._domainkey.example.com": {record}},
+			result:   dkim.Fail,
+			err:      dkim.ErrSignatureMismatch,
+		},
+		{
+			name:     "another From prepended",
+			message:  "From: ceo@example.com\r\n" + signed,
+			resolver: staticResolver{"sel._domainkey.example.com": {record}},
+			result:   dkim.Fail,
+			err:      dkim.ErrSignatureMismatch,
+		},
+		{
+			name:     "unpublished key",
+			message:  signed,
+			resolver: staticResolver{},
+			result:   dkim.PermError,
+			err:      dkim.ErrKeyNotFound,
+		},
+		{
+			name:     "DNS failure",
+			message:  signed,
+			resolver: staticResolver{"sel._domainkey.example.com": nil},
+			result:   dkim.TempError,
+			err:      dkim.ErrKeyLookup,
+		},
+		{
+			name:     "revoked key",
+			message:  signed,
+			resolver: staticResolver{"sel._domainkey.example.com": {"v=DKIM1; p="}},
+			result:   dkim.PermError,
+			err:      dkim.ErrKeyRevoked,
+		},
+		{
+			name:     "unknown algorithm",
+			message:  strings.Replace(signed, "a=rsa-sha256", "a=rsa-sha512", 1),
+			resolver: staticResolver{"sel._domainkey.example.com": {record}},
+			result:   dkim.Neutral,
+			err:      dkim.ErrUnsupportedAlgorithm,
+		},
+		{
+			name:     "SHA-1",
+			message:  strings.Replace(signed, "a=rsa-sha256", "a=rsa-sha1", 1),
+			resolver: staticResolver{"sel._domainkey.example.com": {record}},
+			result:   dkim.PermError,
+			err:      dkim.ErrInsecureAlgorithm,
+		},
+		{
+			name:     "short RSA key",
+			message:  signed,
+			resolver: staticResolver{"sel._domainkey.example.com": {weakRecord}},
+			result:   dkim.PermError,
+			err:      dkim.ErrWeakKey,
+		},
+		{
+			name:     "identity outside the signing domain",
+			message:  strings.Replace(signed, "d=example.com;", "d=example.com; i=alice@evil.example;", 1),
+			resolver: staticResolver{"sel._domainkey.example.com": {record}},
+			result:   dkim.PermError,
+			err:      dkim.ErrMalformedSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifications := dkim.Verify(ctx, tt.resolver, []byte(tt.message))
+			require.Len(t, verifications, 1)
+			assert.Equal(t, tt.result, verifications[0].Result)
+			assert.True(t, errors.Is(verifications[0].Err, tt.err), "got %v", verifications[0].Err)
+		})
+	}
+}
+
+func TestSign_RequiresFrom(t *testing.T) {
+	key, _ := rsaKey(t)
+	_, err := dkim.Sign([]byte("Subject: x\r\n\r\nbody\r\n"), dkim.SignOptions{Domain: "example.com", Selector: "sel", Key: key})
+	assert.Error(t, err)
+}
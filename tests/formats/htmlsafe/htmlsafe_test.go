@@ -0,0 +1,242 @@
+package htmlsafe_test
+
+import (
+	"net/url"
+	"palm/src/formats/htmlsafe"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var opts = htmlsafe.Options{
+	Scope: "msg",
+	ResolveCID: func(contentID string) (string, bool) {
+		if contentID == "logo@example.com" {
+			return "/attachments/7", true
+		}
+		return "", false
+	},
+}
+
+func TestSanitize_Elements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "fragments are wrapped in the scope element",
+			source: "<p>Hello <b>world</b></p>",
+			want:   `<div class="msg"><p>Hello <b>world</b></p></div>`,
+		},
+		{
+			name:   "scripts are removed with their content",
+			source: `<p>a</p><script>alert(1)</script><noscript><p>b</p></noscript>`,
+			want:   `<div class="msg"><p>a</p></div>`,
+		},
+		{
+			name:   "event handlers and unknown attributes are removed",
+			source: `<div onclick="steal()" data-x="1" align="center" onmouseover=x>text</div>`,
+			want:   `<div class="msg"><div align="center">text</div></div>`,
+		},
+		{
+			name:   "forms keep their text but lose their controls",
+			source: `<form action="https://evil.example/"><p>Log in</p><input name="password"><button>Go</button></form>`,
+			want:   `<div class="msg"><p>Log in</p></div>`,
+		},
+		{
+			name:   "frames, objects and SVG are removed",
+			source: `<iframe src="https://evil.example/"></iframe><object data="x.swf"></object><svg><script>alert(1)</script></svg>ok`,
+			want:   `<div class="msg">ok</div>`,
+		},
+		{
+			name:   "comments are removed",
+			source: `<!--[if mso]><p>Outlook</p><![endif]--><p>all</p>`,
+			want:   `<div class="msg"><p>all</p></div>`,
+		},
+		{
+			name:   "class names and ids are prefixed",
+			source: `<p class="hidden intro" id="top">x</p><a href="#top">up</a>`,
+			want:   `<div class="msg"><p class="msg-hidden msg-intro" id="msg-top">x</p><a href="#msg-top">up</a></div>`,
+		},
+		{
+			name:   "body colors apply to the wrapper",
+			source: `<html><body bgcolor="#eee" style="margin: 0" onload="x()"><p>x</p></body></html>`,
+			want:   `<div class="msg" style="background-color:#eee;margin:0"><p>x</p></div>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, htmlsafe.Sanitize(tt.source, opts))
+		})
+	}
+}
+
+func TestSanitize_URLs(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "web links open outside the message",
+			source: `<a href="https://example.com/a?b=c">x</a>`,
+			want:   `<a href="https://example.com/a?b=c" target="_blank" rel="noopener noreferrer">x</a>`,
+		},
+		{
+			name:   "links within the message stay in it",
+			source: `<a href="#notes">notes</a><h2 id="notes">Notes</h2>`,
+			want:   `<a href="#msg-notes">notes</a><h2 id="msg-notes">Notes</h2>`,
+		},
+		{
+			name:   "javascript links are removed",
+			source: `<a href=" JavaScript:alert(1)">x</a>`,
+			want:   `<a>x</a>`,
+		},
+		{
+			name:   "cid references load the inline part",
+			source: `<img src="cid:logo@example.com" alt="Logo">`,
+			want:   `<img src="/attachments/7" alt="Logo"/>`,
+		},
+		{
+			name:   "unknown cid references are removed",
+			source: `<img src="cid:other@example.com">`,
+			want:   `<img/>`,
+		},
+		{
+			name:   "relative images would load from the application",
+			source: `<img src="/palm/attachments/1"><img src="file:///etc/passwd">`,
+			want:   `<img/><img/>`,
+		},
+		{
+			name:   "data images are allowed except SVG",
+			source: `<img src="data:image/png;base64,AAAA"><img src="data:image/svg+xml,<svg/>"><img src="data:text/html,x">`,
+			want:   `<img src="data:image/png;base64,AAAA"/><img/><img/>`,
+		},
+		{
+			name:   "protocol-relative images use https",
+			source: `<img src="//cdn.example.com/a.png">`,
+			want:   `<img src="https://cdn.example.com/a.png"/>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, `<div class="msg">`+tt.want+`</div>`, htmlsafe.Sanitize(tt.source, opts))
+		})
+	}
+}
+
+func TestSanitize_Styles(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "rules are scoped to the message",
+			source: `p, .note > a:hover { color: red }`,
+			want:   `.msg p,.msg .msg-note > a:hover{color:red}`,
+		},
+		{
+			name:   "body and html stand for the scope element",
+			source: `html, body { margin: 0 } body.dark p { color: #fff } :root > body table { width: 100% }`,
+			want:   `.msg,.msg{margin:0}.msg.msg-dark p{color:#fff}.msg table{width:100%}`,
+		},
+		{
+			name:   "media queries are kept and scoped",
+			source: `@media (max-width: 600px) { .col { width: 100% !important } }`,
+			want:   `@media (max-width: 600px){.msg .msg-col{width:100% !important}}`,
+		},
+		{
+			name:   "imports and unknown at-rules are removed",
+			source: `@import url("https://evil.example/x.css"); @charset "utf-8"; @keyframes spin { to { opacity: 0 } } a { color: blue }`,
+			want:   `.msg a{color:blue}`,
+		},
+		{
+			name:   "dangerous declarations are removed",
+			source: `div { width: expression(alert(1)); -moz-binding: url(x.xml); position: fixed; color: red; background: url("javascript:x") }`,
+			want:   `.msg div{color:red}`,
+		},
+		{
+			name:   "comments cannot hide code",
+			source: `div { width: expr/**/ession(alert(1)); margin: 1px /* } p { color: red */ }`,
+			want:   `.msg div{margin:1px}`,
+		},
+		{
+			name:   "escapes and unclosed strings are removed",
+			source: `p { font-family: "\3c/style\3e" } a { color: red } b { content: "x }`,
+			want:   `.msg a{color:red}`,
+		},
+		{
+			name:   "background images follow the image rules",
+			source: `td { background: url(cid:logo@example.com) no-repeat } th { background-image: url('/local.png') }`,
+			want:   `.msg td{background:url("/attachments/7") no-repeat}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := `<div class="msg"><style>` + tt.want + `</style></div>`
+			assert.Equal(t, want, htmlsafe.Sanitize("<style>"+tt.source+"</style>", opts))
+		})
+	}
+
+	// Style attributes follow the same rules
+	got := htmlsafe.Sanitize(`<p style="color: red; position: absolute; behavior: url(x.htc)">x</p>`, opts)
+	assert.Equal(t, `<div class="msg"><p style="color:red">x</p></div>`, got)
+
+	// Style sheets in the head apply to the body
+	got = htmlsafe.Sanitize(`<html><head><title>T</title><style>p{color:red}</style><meta http-equiv="refresh" content="0;url=https://evil.example/"></head><body><p>x</p></body></html>`, opts)
+	assert.Equal(t, `<div class="msg"><style>.msg p{color:red}</style><p>x</p></div>`, got)
+}
+
+func TestSanitize_Malformed(t *testing.T) {
+	// Markup that browsers repair differently must not open a way around
+	// the sanitizer
+	for _, source := range []string{
+		`<img src=x onerror=alert(1)//`,
+		`<p><svg><p><style><img src=x onerror=alert(1)></style></p></svg>`,
+		`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+		`<a href="jav&#x09;ascript:alert(1)">x</a>`,
+		`<scr<script>ipt>alert(1)</script>`,
+	} {
+		got := htmlsafe.Sanitize(source, opts)
+		assert.NotContains(t, strings.ToLower(got), "onerror", source)
+		assert.NotContains(t, strings.ToLower(got), "javascript", source)
+		assert.NotContains(t, strings.ToLower(got), "<script", source)
+	}
+
+	// Frameset documents have no body to show
+	got := htmlsafe.Sanitize(`<html><frameset cols="50%,50%"><frame src="https://evil.example/"></frameset></html>`, opts)
+	assert.Equal(t, `<div class="msg"></div>`, got)
+}
+
+func TestSanitize_RemoteContent(t *testing.T) {
+	var images []htmlsafe.Image
+	remote := opts
+	remote.RemoteImage = func(image htmlsafe.Image) (string, bool) {
+		images = append(images, image)
+		return "/proxy?" + image.URL.Host, !image.Hidden
+	}
+	remote.Link = func(u *url.URL) string {
+		u.RawQuery = ""
+		return u.String()
+	}
+
+	got := htmlsafe.Sanitize(`<img src="https://a.example/x.png">`+
+		`<img src="https://b.example/p.gif" width="1" height="1">`+
+		`<img src="http://c.example/p.gif" style="visibility: hidden">`+
+		`<div style="background: url(https://d.example/bg.png)"></div>`+
+		`<a href="https://e.example/?id=1">link</a><a href="mailto:x@e.example?subject=hi">mail</a>`, remote)
+
+	require.Len(t, images, 4)
+	assert.False(t, images[0].Hidden)
+	assert.True(t, images[1].Hidden, "images of one pixel are hidden")
+	assert.True(t, images[2].Hidden, "images hidden with CSS are hidden")
+	assert.True(t, images[3].Background)
+	assert.Equal(t, `<div class="msg"><img src="/proxy?a.example"/><img width="1" height="1"/><img style="visibility:hidden"/>`+
+		`<div style="background:url(&#34;/proxy?d.example&#34;)"></div>`+
+		`<a href="https://e.example/" target="_blank" rel="noopener noreferrer">link</a>`+
+		`<a href="mailto:x@e.example?subject=hi" target="_blank" rel="noopener noreferrer">mail</a></div>`, got)
+}
@@ -0,0 +1,167 @@
+package ical_test
+
+import (
+	"bytes"
+	"os"
+	"palm/src/formats/ical"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseFile(t *testing.T, name string) *ical.Calendar {
+	f, err := os.Open(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	cal, err := ical.Parse(f)
+	require.NoError(t, err)
+	return cal
+}
+
+func TestParse_Outlook(t *testing.T) {
+	cal := parseFile(t, "outlook_request.ics")
+	assert.Equal(t, ical.MethodRequest, cal.Method)
+	require.Len(t, cal.Events, 2)
+
+	event := cal.Events[0]
+	assert.Equal(t, "040000008200E00074C5B7101A82E00800000000F0C1D2E3", event.UID)
+	assert.Equal(t, 2, event.Sequence)
+	assert.Equal(t, ical.StatusConfirmed, event.Status)
+	assert.Equal(t, "Engine review", event.Summary)
+	assert.Equal(t, "Room 1; Babbage House", event.Location)
+	assert.Equal(t, "Agenda:\n1. Difference engine\n2. Analytical engine, notes", event.Description,
+		"folded lines are joined and text unescaped")
+
+	// Winter time of the VTIMEZONE, which the IANA database does not name
+	assert.Equal(t, "W. Europe Standard Time", event.TimeZone)
+	assert.Equal(t, time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC), event.Start.UTC())
+	assert.Equal(t, time.Hour, event.End.Sub(event.Start))
+	assert.False(t, event.AllDay)
+
+	require.NotNil(t, event.Organizer)
+	assert.Equal(t, "ada@engines.example", event.Organizer.Email)
+	assert.Equal(t, "Lovelace, Ada", event.Organizer.Name, "quoted parameters keep their commas")
+	require.Len(t, event.Attendees, 2)
+	charles := event.Attendee("Charles@Engines.example")
+	require.NotNil(t, charles)
+	assert.Equal(t, "Charles Babbage", charles.Name)
+	assert.Equal(t, ical.PartStatNeedsAction, charles.PartStat)
+	assert.True(t, charles.RSVP)
+	assert.Equal(t, "OPT-PARTICIPANT", event.Attendees[1].Role)
+	assert.False(t, event.Attendees[1].RSVP)
+
+	require.NotNil(t, event.Recurrence)
+	assert.Equal(t, "Every week on Tuesday, until Jun 30, 2026", event.Recurrence.Summary())
+
+	// The moved occurrence falls in summer time
+	moved := cal.Events[1]
+	assert.Equal(t, time.Date(2026, 4, 7, 8, 0, 0, 0, time.UTC), moved.RecurrenceID.UTC())
+	assert.Equal(t, time.Date(2026, 4, 7, 12, 0, 0, 0, time.UTC), moved.Start.UTC())
+}
+
+func TestParse_Google(t *testing.T) {
+	cal := parseFile(t, "google_request.ics")
+	require.Len(t, cal.Events, 3)
+
+	meeting := cal.Events[0]
+	assert.Equal(t, time.Date(2026, 11, 2, 15, 0, 0, 0, time.UTC), meeting.Start)
+	assert.Equal(t, time.Date(2026, 11, 2, 16, 30, 0, 0, time.UTC), meeting.End, "the end is derived from DURATION")
+	assert.Empty(t, meeting.TimeZone)
+	ada := meeting.Attendee("ada@engines.example")
+	require.NotNil(t, ada)
+	assert.True(t, ada.RSVP, "parameters folded mid-value are joined")
+
+	holidays := cal.Events[1]
+	assert.True(t, holidays.AllDay)
+	assert.Equal(t, time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC), holidays.Start)
+	assert.Equal(t, time.Date(2026, 12, 27, 0, 0, 0, 0, time.UTC), holidays.End)
+	assert.Nil(t, holidays.Organizer)
+
+	// Without a VTIMEZONE, TZID names an IANA zone; New York is back on
+	// standard time in November
+	standup := cal.Events[2]
+	assert.Equal(t, "America/New_York", standup.TimeZone)
+	assert.Equal(t, time.Date(2026, 11, 5, 14, 0, 0, 0, time.UTC), standup.Start.UTC())
+	assert.Equal(t, "Every weekday, 10 times", standup.Recurrence.Summary())
+}
+
+func TestParse_Malformed(t *testing.T) {
+	for name, data := range map[string]string{
+		"not a calendar":  "BEGIN:VCARD\r\nVERSION:4.0\r\nEND:VCARD\r\n",
+		"unterminated":    "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:20260101T100000Z\r\n",
+		"missing DTSTART": "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"bad date":        "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:2026-01-01\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+		"bad rule":        "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:1\r\nDTSTART:20260101\r\nRRULE:INTERVAL=2\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ical.Parse(strings.NewReader(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRecurrence_Summary(t *testing.T) {
+	for rule, want := range map[string]string{
+		"FREQ=DAILY":                                    "Every day",
+		"FREQ=DAILY;INTERVAL=3;COUNT=1":                 "Every 3 days, once",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE,FR":         "Every 2 weeks on Monday, Wednesday and Friday",
+		"FREQ=MONTHLY;BYMONTHDAY=15":                    "Every month on day 15",
+		"FREQ=MONTHLY;BYMONTHDAY=-1":                    "Every month on the last day",
+		"FREQ=MONTHLY;BYDAY=2TU;UNTIL=20271231":         "Every month on the second Tuesday, until Dec 31, 2027",
+		"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1": "Every month on the last weekday",
+		"FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=1":            "Every year on March 1",
+		"FREQ=YEARLY;BYMONTH=11;BYDAY=4TH":              "Every year on the fourth Thursday of November",
+	} {
+		r, err := ical.ParseRecurrence(rule)
+		require.NoError(t, err, rule)
+		assert.Equal(t, want, r.Summary(), rule)
+	}
+
+	r, err := ical.ParseRecurrence("FREQ=WEEKLY;WKST=SU;BYDAY=-1SU,TU")
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=-1SU,TU;WKST=SU", r.String(), "unmodelled parts are kept")
+
+	_, err = ical.ParseRecurrence("FREQ=FORTNIGHTLY")
+	assert.ErrorIs(t, err, ical.ErrInvalidRule)
+}
+
+func TestReply(t *testing.T) {
+	invitation := parseFile(t, "outlook_request.ics")
+	event := invitation.Events[0]
+	now := time.Date(2026, 3, 2, 8, 30, 0, 0, time.UTC)
+
+	reply := ical.Reply(event, event.Attendee("charles@engines.example"), ical.PartStatAccepted, now)
+	var b bytes.Buffer
+	require.NoError(t, ical.Write(&b, reply))
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75, "lines are folded")
+	}
+	assert.Contains(t, b.String(), "METHOD:REPLY\r\n")
+	assert.Contains(t, b.String(), "DTSTART:20260310T090000Z\r\n", "times are written in UTC")
+
+	parsed, err := ical.Parse(&b)
+	require.NoError(t, err)
+	assert.Equal(t, ical.MethodReply, parsed.Method)
+	assert.Equal(t, ical.ProductID, parsed.ProductID)
+	require.Len(t, parsed.Events, 1)
+	answer := parsed.Events[0]
+	assert.Equal(t, event.UID, answer.UID)
+	assert.Equal(t, 2, answer.Sequence)
+	assert.Equal(t, now, answer.Stamp)
+	assert.True(t, event.Start.Equal(answer.Start))
+	assert.Nil(t, answer.Recurrence, "a reply answers the whole series")
+	require.NotNil(t, answer.Organizer)
+	assert.Equal(t, "Lovelace, Ada", answer.Organizer.Name)
+	require.Len(t, answer.Attendees, 1, "only the replying attendee is named")
+	assert.Equal(t, "charles@engines.example", answer.Attendees[0].Email)
+	assert.Equal(t, ical.PartStatAccepted, answer.Attendees[0].PartStat)
+	assert.False(t, answer.Attendees[0].RSVP)
+
+	assert.Equal(t, ical.PartStatNeedsAction, event.Attendee("charles@engines.example").PartStat,
+		"the invitation is left as it was")
+}
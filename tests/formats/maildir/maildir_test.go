@@ -0,0 +1,134 @@
+package maildir_test
+
+import (
+	"os"
+	"palm/src/formats/maildir"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile puts a message file into a maildir subdirectory with the given
+// modification time
+func writeFile(t *testing.T, dir maildir.Dir, sub, name string, modTime time.Time) {
+	path := filepath.Join(string(dir), sub, name)
+	require.NoError(t, os.WriteFile(path, []byte("Subject: "+name+"\n\nbody\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestFlags(t *testing.T) {
+	assert.Equal(t, maildir.Flags("DFS"), maildir.NewFlags("SFDS,"), "flags are sorted, deduplicated and filtered")
+	assert.Equal(t, maildir.Flags("FRS"), maildir.NewFlags("RS").With(maildir.FlagFlagged, true))
+	assert.Equal(t, maildir.Flags("R"), maildir.NewFlags("RS").With(maildir.FlagSeen, false))
+
+	flags := maildir.NewFlags("RS")
+	assert.True(t, flags.Has(maildir.FlagSeen))
+	assert.False(t, flags.Has(maildir.FlagDraft))
+}
+
+func TestOpen(t *testing.T) {
+	path := t.TempDir()
+	_, err := maildir.Open(path)
+	assert.ErrorIs(t, err, maildir.ErrNotMaildir)
+
+	created, err := maildir.Create(path)
+	require.NoError(t, err)
+	opened, err := maildir.Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, created, opened)
+}
+
+func TestDir_Entries(t *testing.T) {
+	dir, err := maildir.Create(t.TempDir())
+	require.NoError(t, err)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	writeFile(t, dir, "cur", "1700000002.M1P1Q1.host:2,SR", base.Add(2*time.Minute))
+	writeFile(t, dir, "new", "1700000001.M1P1Q1.host", base.Add(time.Minute))
+	writeFile(t, dir, "cur", "1700000003.M1P1Q1.host!2,FS", base.Add(3*time.Minute))
+	writeFile(t, dir, "cur", "1700000000.M1P1Q1.host:2,", base)
+	writeFile(t, dir, "tmp", "1700000004.M1P1Q1.host", base)
+	writeFile(t, dir, "cur", ".hidden", base)
+
+	entries, err := dir.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 4, "tmp and hidden files are not messages")
+
+	assert.Equal(t, "1700000000.M1P1Q1.host", entries[0].Key, "entries are ordered oldest first")
+	assert.Equal(t, maildir.Flags(""), entries[0].Flags)
+
+	assert.Equal(t, "1700000001.M1P1Q1.host", entries[1].Key)
+	assert.True(t, entries[1].New)
+
+	assert.Equal(t, maildir.Flags("RS"), entries[2].Flags)
+	assert.False(t, entries[2].New)
+
+	assert.Equal(t, "1700000003.M1P1Q1.host", entries[3].Key, "the '!' separator used on Windows filesystems is understood")
+	assert.Equal(t, maildir.Flags("FS"), entries[3].Flags)
+}
+
+func TestDir_DeliverSetFlagsRemove(t *testing.T) {
+	dir, err := maildir.Create(t.TempDir())
+	require.NoError(t, err)
+
+	unseen, err := dir.Deliver([]byte("Subject: one\r\n\r\nbody\r\n"), "")
+	require.NoError(t, err)
+	assert.True(t, unseen.New, "messages without flags are delivered to new")
+	data, err := os.ReadFile(unseen.Path)
+	require.NoError(t, err)
+	assert.Equal(t, "Subject: one\n\nbody\n", string(data), "line endings are stored as LF")
+
+	seen, err := dir.Deliver([]byte("Subject: two\n\nbody\n"), "SF")
+	require.NoError(t, err)
+	assert.NotEqual(t, unseen.Key, seen.Key, "every delivery gets a unique key")
+	assert.Equal(t, filepath.Join(string(dir), "cur", seen.Key+":2,FS"), seen.Path)
+
+	tmp, err := os.ReadDir(filepath.Join(string(dir), "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp, "tmp is empty after delivery")
+
+	// Reading a new message moves it to cur with its flags
+	updated, err := dir.SetFlags(unseen.Key, "S")
+	require.NoError(t, err)
+	assert.False(t, updated.New)
+	assert.True(t, strings.HasSuffix(updated.Path, filepath.Join("cur", unseen.Key+":2,S")))
+	_, err = os.Stat(unseen.Path)
+	assert.True(t, os.IsNotExist(err))
+
+	found, err := dir.Lookup(unseen.Key)
+	require.NoError(t, err)
+	assert.Equal(t, maildir.Flags("S"), found.Flags)
+
+	require.NoError(t, dir.Remove(seen.Key))
+	_, err = dir.Lookup(seen.Key)
+	assert.ErrorIs(t, err, maildir.ErrNotFound)
+	_, err = dir.SetFlags(seen.Key, "S")
+	assert.ErrorIs(t, err, maildir.ErrNotFound)
+}
+
+func TestDir_Folders(t *testing.T) {
+	root, err := maildir.Create(t.TempDir())
+	require.NoError(t, err)
+	_, err = maildir.Create(filepath.Join(string(root), ".Sent"))
+	require.NoError(t, err)
+	_, err = maildir.Create(filepath.Join(string(root), ".Archive.2023"))
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(string(root), ".notmuch"), 0o700))
+
+	folders, err := root.Folders()
+	require.NoError(t, err)
+	assert.Equal(t, []string{".Archive.2023", ".Sent"}, folders, "only subdirectories that are maildirs are folders")
+
+	before, err := root.Folder(".Sent").ModTime()
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = root.Folder(".Sent").Deliver([]byte("Subject: x\n\n"), "S")
+	require.NoError(t, err)
+	after, err := root.Folder(".Sent").ModTime()
+	require.NoError(t, err)
+	assert.True(t, after.After(before), "a delivery changes the folder's modification time")
+}
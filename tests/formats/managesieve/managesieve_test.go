@@ -0,0 +1,94 @@
+package managesieve_test
+
+import (
+	"context"
+	"palm/src/formats/managesieve"
+	"palm/tests/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const vacationScript = "require \"vacation\";\r\nvacation text:\r\nAway until \"Monday\".\r\n.\r\n;\r\n"
+
+func dial(t *testing.T, server *utils.FakeManageSieve, security managesieve.Security) *managesieve.Client {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	client, err := managesieve.Dial(ctx, server.Addr, managesieve.Options{
+		Security:  security,
+		TLSConfig: server.ClientTLSConfig(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClient_Session(t *testing.T) {
+	server := utils.NewFakeManageSieve(t)
+	client := dial(t, server, managesieve.SecurityStartTLS)
+
+	// The capabilities announced after STARTTLS replace the first ones
+	_, ok := client.Capability("starttls")
+	assert.False(t, ok)
+	assert.Contains(t, client.Extensions(), "vacation")
+
+	err := client.Authenticate("ada", "wrong")
+	var serverErr *managesieve.ServerError
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, "NO", serverErr.Status)
+	require.NoError(t, client.Authenticate("ada", "analytical"))
+
+	require.NoError(t, client.CheckScript(vacationScript))
+	err = client.CheckScript("frobnicate;")
+	require.ErrorAs(t, err, &serverErr)
+	assert.Contains(t, serverErr.Text, "unknown command")
+
+	require.NoError(t, client.PutScript("away", vacationScript))
+	require.NoError(t, client.PutScript("plain", "keep;"))
+	require.NoError(t, client.SetActive("away"))
+	err = client.SetActive("missing")
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, "NONEXISTENT", serverErr.Code)
+
+	scripts, err := client.ListScripts()
+	require.NoError(t, err)
+	assert.Equal(t, []managesieve.Script{{Name: "away", Active: true}, {Name: "plain"}}, scripts)
+
+	source, err := client.GetScript("away")
+	require.NoError(t, err)
+	assert.Equal(t, vacationScript, source)
+
+	err = client.DeleteScript("away")
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, "ACTIVE", serverErr.Code)
+	require.NoError(t, client.DeleteScript("plain"))
+
+	require.NoError(t, client.Logout())
+	stored, ok := server.Script("away")
+	assert.True(t, ok)
+	assert.Equal(t, vacationScript, stored)
+	assert.Equal(t, "away", server.Active())
+}
+
+func TestClient_PlainText(t *testing.T) {
+	server := utils.NewFakeManageSieve(t)
+	client := dial(t, server, managesieve.SecurityNone)
+
+	_, ok := client.Capability("STARTTLS")
+	assert.True(t, ok)
+	require.NoError(t, client.Authenticate("ada", "analytical"))
+	scripts, err := client.ListScripts()
+	require.NoError(t, err)
+	assert.Empty(t, scripts)
+}
+
+func TestDial_UntrustedCertificate(t *testing.T) {
+	server := utils.NewFakeManageSieve(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := managesieve.Dial(ctx, server.Addr, managesieve.Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package mbox_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"palm/src/formats/mbox"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, r *mbox.Reader) []*mbox.Message {
+	var messages []*mbox.Message
+	for {
+		msg, err := r.Next()
+		if err == io.EOF {
+			return messages
+		}
+		require.NoError(t, err)
+		messages = append(messages, msg)
+	}
+}
+
+func TestReader_SampleMbox(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "sample.mbox"))
+	require.NoError(t, err)
+
+	reader := mbox.NewReader(bytes.NewReader(data), mbox.MboxRD)
+	messages := readAll(t, reader)
+	require.Len(t, messages, 4)
+	assert.Equal(t, int64(len(data)), reader.BytesRead())
+
+	first := messages[0]
+	assert.Equal(t, "alice@example.com Mon Jan  1 09:00:00 2024", first.Envelope)
+	assert.Equal(t, "alice@example.com", first.Sender())
+	body := string(first.Data)
+	assert.Contains(t, body, "\nFrom the notes I sent earlier:\n", "one level of quoting is removed")
+	assert.Contains(t, body, "\n>From here on it is quoted twice.\n")
+	assert.Contains(t, body, "\nFrom a line that must be kept.\n", "unquoted From lines inside a paragraph are not separators")
+	assert.True(t, strings.HasSuffix(body, "Alice\n"), "the blank separator line is not part of the message")
+
+	assert.Equal(t, "MAILER-DAEMON", messages[3].Sender())
+}
+
+func TestReader_MboxO(t *testing.T) {
+	input := "From a@example.com Mon Jan  1 00:00:00 2024\nSubject: x\n\n>From one\n>>From two\n"
+	messages := readAll(t, mbox.NewReader(strings.NewReader(input), mbox.MboxO))
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Subject: x\n\nFrom one\n>>From two\n", string(messages[0].Data))
+}
+
+func TestReader_Errors(t *testing.T) {
+	_, err := mbox.NewReader(strings.NewReader("Subject: not an mbox\n"), mbox.MboxRD).Next()
+	assert.ErrorIs(t, err, mbox.ErrNotMbox)
+
+	_, err = mbox.NewReader(strings.NewReader(""), mbox.MboxRD).Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestWriter_RoundTrip(t *testing.T) {
+	bodies := []string{
+		"Subject: one\r\n\r\nFrom the start\r\n>From quoted\r\n>>From twice\r\n",
+		"Subject: two\r\n\r\nNo trailing newline",
+	}
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, format := range []mbox.Format{mbox.MboxRD, mbox.MboxO} {
+		var buf bytes.Buffer
+		writer := mbox.NewWriter(&buf, format)
+		for _, body := range bodies {
+			require.NoError(t, writer.WriteMessage("sender@example.com", date, []byte(body)))
+		}
+		require.NoError(t, writer.Flush())
+		assert.True(t, strings.HasPrefix(buf.String(), "From sender@example.com Tue Jan  2 03:04:05 2024\n"))
+		assert.NotContains(t, buf.String(), "\r\n", "mbox files use LF line endings")
+
+		messages := readAll(t, mbox.NewReader(&buf, format))
+		require.Len(t, messages, 2)
+		if format == mbox.MboxRD {
+			assert.Equal(t, "Subject: one\n\nFrom the start\n>From quoted\n>>From twice\n", string(messages[0].Data))
+		} else {
+			// mboxo cannot tell quoted ">From " lines from escaped ones
+			assert.Equal(t, "Subject: one\n\nFrom the start\nFrom quoted\n>>From twice\n", string(messages[0].Data))
+		}
+		assert.Equal(t, "Subject: two\n\nNo trailing newline\n", string(messages[1].Data))
+	}
+}
+
+func TestWriter_SenderFallback(t *testing.T) {
+	var buf bytes.Buffer
+	writer := mbox.NewWriter(&buf, mbox.MboxRD)
+	require.NoError(t, writer.WriteMessage("", time.Time{}, []byte("Subject: x\n\nbody\n")))
+	require.NoError(t, writer.Flush())
+	assert.True(t, strings.HasPrefix(buf.String(), "From MAILER-DAEMON Thu Jan  1 00:00:00 1970\n"))
+}
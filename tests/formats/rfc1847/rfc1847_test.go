@@ -0,0 +1,83 @@
+package rfc1847_test
+
+import (
+	"palm/src/formats/rfc1847"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const content = "Content-Type: text/plain; charset=utf-8\n" +
+	"Content-Transfer-Encoding: 7bit\n" +
+	"\n" +
+	"Meet me at noon.\n"
+
+func TestSigned_RoundTrip(t *testing.T) {
+	signature := "-----BEGIN PGP SIGNATURE-----\n\nwnUEARYIAB0WIQ\n-----END PGP SIGNATURE-----\n"
+	entity := rfc1847.WriteSigned([]byte(content), "pgp-sha256", rfc1847.Part{
+		ContentType: "application/pgp-signature",
+		Filename:    "signature.asc",
+		Data:        []byte(signature),
+	})
+	assert.NotContains(t, strings.ReplaceAll(string(entity), "\r\n", ""), "\n", "the entity uses CRLF line endings")
+
+	signed, err := rfc1847.ParseSigned(entity)
+	require.NoError(t, err)
+	assert.Equal(t, "application/pgp-signature", signed.Protocol)
+	assert.Equal(t, "pgp-sha256", signed.Micalg)
+	assert.Equal(t, rfc1847.Canonical([]byte(content)), signed.Content, "the signed content is returned exactly as signed")
+	assert.Equal(t, strings.TrimSpace(signature), strings.TrimSpace(strings.ReplaceAll(string(signed.Signature), "\r\n", "\n")))
+}
+
+func TestSigned_BinarySignature(t *testing.T) {
+	signature := []byte{0x30, 0x82, 0x01, 0x00, 0xff, 0x00}
+	entity := rfc1847.WriteSigned([]byte(content), "sha-256", rfc1847.Part{
+		ContentType: "application/pkcs7-signature",
+		Filename:    "smime.p7s",
+		Data:        signature,
+	})
+	assert.Contains(t, string(entity), "Content-Transfer-Encoding: base64")
+
+	signed, err := rfc1847.ParseSigned(entity)
+	require.NoError(t, err)
+	assert.Equal(t, "application/pkcs7-signature", signed.Protocol)
+	assert.Equal(t, signature, signed.Signature)
+}
+
+func TestEncrypted_RoundTrip(t *testing.T) {
+	ciphertext := "-----BEGIN PGP MESSAGE-----\n\nhF4DAAAAAAAAAAASAQdA\n-----END PGP MESSAGE-----\n"
+	entity := rfc1847.WriteEncrypted(
+		rfc1847.Part{ContentType: "application/pgp-encrypted", Data: []byte("Version: 1\r\n")},
+		rfc1847.Part{ContentType: "application/octet-stream", Filename: "encrypted.asc", Data: []byte(ciphertext)},
+	)
+
+	encrypted, err := rfc1847.ParseEncrypted(entity)
+	require.NoError(t, err)
+	assert.Equal(t, "application/pgp-encrypted", encrypted.Protocol)
+	assert.Equal(t, "Version: 1", strings.TrimSpace(string(encrypted.Control)))
+	assert.Equal(t, strings.TrimSpace(ciphertext), strings.TrimSpace(strings.ReplaceAll(string(encrypted.Data), "\r\n", "\n")))
+}
+
+func TestParse_Errors(t *testing.T) {
+	_, err := rfc1847.ParseSigned([]byte(content))
+	assert.ErrorIs(t, err, rfc1847.ErrNotSigned)
+
+	_, err = rfc1847.ParseEncrypted([]byte(content))
+	assert.ErrorIs(t, err, rfc1847.ErrNotEncrypted)
+
+	// A signed entity needs exactly a content and a signature part
+	onePart := "Content-Type: multipart/signed; boundary=b; protocol=\"application/pgp-signature\"\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"\r\n" +
+		"Meet me at noon.\r\n" +
+		"--b--\r\n"
+	_, err = rfc1847.ParseSigned([]byte(onePart))
+	assert.ErrorIs(t, err, rfc1847.ErrMalformed)
+
+	unterminated := strings.TrimSuffix(onePart, "--b--\r\n")
+	_, err = rfc1847.ParseSigned([]byte(unterminated))
+	assert.ErrorIs(t, err, rfc1847.ErrMalformed)
+}
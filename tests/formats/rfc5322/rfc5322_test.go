@@ -0,0 +1,160 @@
+package rfc5322_test
+
+import (
+	"bytes"
+	"net/mail"
+	"palm/src/formats/rfc5322"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const multipartMessage = "From: =?UTF-8?Q?Carol_M=C3=BCller?= <carol@example.net>\r\n" +
+	"To: bob@example.org, \"Dave, Jr.\" <dave@example.org>\r\n" +
+	"Cc: eve@example.org\r\n" +
+	"Subject: =?ISO-8859-1?Q?R=E9union?= with\r\n attachment\r\n" +
+	"Date: Tue, 2 Jan 2024 10:30:00 +0100\r\n" +
+	"Message-ID: <multi-2@example.net>\r\n" +
+	"References: <root@example.com>\r\n <plain-1@example.com>\r\n" +
+	"In-Reply-To: <plain-1@example.com>\r\n" +
+	"Received: from mx.example.com; Tue, 2 Jan 2024 10:30:05 +0100\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"R=E9union demain =E0 10h.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"PHA+UsOpdW5pb24gZGVtYWluIMOgIDEwaC48L3A+\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@example.net>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0K\r\nGgo=\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=\"agenda.csv\"\r\n" +
+	"Content-Disposition: attachment; filename*=UTF-8''%C3%A9t%C3%A9.csv\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"dG9waWMsbWludXRlcwpidWRnZXQsMzAK\r\n" +
+	"--outer--\r\n"
+
+func TestParse_Multipart(t *testing.T) {
+	m, err := rfc5322.Parse(strings.NewReader(multipartMessage))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Réunion with attachment", m.Subject, "encoded words are decoded and folds joined")
+	assert.Equal(t, &mail.Address{Name: "Carol Müller", Address: "carol@example.net"}, m.From)
+	require.Len(t, m.To, 2)
+	assert.Equal(t, "Dave, Jr.", m.To[1].Name)
+	require.Len(t, m.Cc, 1)
+	assert.Equal(t, "multi-2@example.net", m.MessageID)
+	assert.Equal(t, "plain-1@example.com", m.InReplyTo)
+	assert.Equal(t, []string{"root@example.com", "plain-1@example.com"}, m.References)
+	assert.True(t, m.Date.Equal(time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)))
+	assert.Equal(t, "from mx.example.com; Tue, 2 Jan 2024 10:30:05 +0100", m.Header.Get("received"))
+
+	assert.Equal(t, "Réunion demain à 10h.", m.Text, "Latin-1 text is converted to UTF-8")
+	assert.Equal(t, "<p>Réunion demain à 10h.</p>", m.HTML)
+
+	require.Len(t, m.Attachments, 2)
+	logo := m.Attachments[0]
+	assert.Equal(t, "image/png", logo.ContentType)
+	assert.Equal(t, "logo@example.net", logo.ContentID)
+	assert.True(t, logo.Inline, "parts with a Content-ID are inline")
+	assert.Equal(t, []byte("\x89PNG\r\n\x1a\n"), logo.Data)
+
+	agenda := m.Attachments[1]
+	assert.Equal(t, "été.csv", agenda.Filename, "RFC 2231 file names are decoded")
+	assert.False(t, agenda.Inline)
+	assert.Equal(t, "topic,minutes\nbudget,30\n", string(agenda.Data))
+}
+
+func TestParse_Lenient(t *testing.T) {
+	input := "From: Broken Name <broken@example.com\n" +
+		"To: John \"Johnny\" Doe <john@example.com>, undisclosed-recipients:;\n" +
+		"Date: Tue, 2 Jan 2024 10:30:00 +0100 (CET)\n" +
+		"Subject: =?x-unknown?Q?abc?=\n" +
+		"Content-Type: text/plain; charset=\"utf-8\n" +
+		"Content-Transfer-Encoding: base64\n" +
+		"\n" +
+		"SGVsbG8g\nd29ybGQ\n"
+
+	m, err := rfc5322.Parse(strings.NewReader(input))
+	require.NoError(t, err)
+	require.NotNil(t, m.From)
+	assert.Equal(t, "broken@example.com", m.From.Address, "addresses are recovered from malformed mailboxes")
+	require.Len(t, m.To, 1)
+	assert.Equal(t, "john@example.com", m.To[0].Address)
+	assert.False(t, m.Date.IsZero(), "zone comments are ignored")
+	assert.Equal(t, "=?x-unknown?Q?abc?=", m.Subject, "unknown charsets are left encoded")
+	assert.Equal(t, "Hello world", m.Text, "unpadded base64 is decoded")
+}
+
+func TestParse_Errors(t *testing.T) {
+	_, err := rfc5322.Parse(strings.NewReader(""))
+	assert.ErrorIs(t, err, rfc5322.ErrMalformed)
+
+	_, err = rfc5322.Parse(strings.NewReader("\r\nbody only"))
+	assert.ErrorIs(t, err, rfc5322.ErrMalformed)
+}
+
+func TestWrite_RoundTrip(t *testing.T) {
+	original := &rfc5322.Message{
+		Header:     rfc5322.Header{{Name: "List-Id", Value: "<team.example.org>"}, {Name: "Subject", Value: "ignored"}},
+		MessageID:  "round-trip@example.org",
+		InReplyTo:  "parent@example.org",
+		References: []string{"root@example.org", "parent@example.org"},
+		Subject:    "Grüße aus Köln — a subject long enough that its encoded form must be folded",
+		Date:       time.Date(2024, 3, 4, 5, 6, 7, 0, time.FixedZone("", 3600)),
+		From:       &mail.Address{Name: "Jürgen", Address: "juergen@example.de"},
+		To:         []*mail.Address{{Name: "Ann", Address: "ann@example.org"}, {Address: "bob@example.org"}},
+		Bcc:        []*mail.Address{{Address: "hidden@example.org"}},
+		Text:       "Hallo,\n\nschöne Grüße.\n" + strings.Repeat("x", 1200) + "\n",
+		HTML:       "<p>Hallo,</p><p>schöne Grüße.</p>",
+		Attachments: []*rfc5322.Attachment{
+			{Filename: "bericht ü.pdf", ContentType: "application/pdf", Data: bytes.Repeat([]byte{0, 1, 2, 250}, 100)},
+			{ContentType: "image/png", ContentID: "logo", Inline: true, Data: []byte("\x89PNG")},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, rfc5322.Write(&buf, original))
+	raw := buf.String()
+
+	for _, line := range strings.Split(raw, "\r\n") {
+		assert.LessOrEqual(t, len(line), 998, "no line exceeds the RFC 5322 limit")
+	}
+	assert.NotContains(t, strings.ReplaceAll(raw, "\r\n", ""), "\n", "all line endings are CRLF")
+	assert.Contains(t, raw, "List-Id: <team.example.org>\r\n", "unstructured fields are kept")
+	assert.NotContains(t, raw, "ignored", "structured fields come from the message")
+
+	parsed, err := rfc5322.Parse(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, original.MessageID, parsed.MessageID)
+	assert.Equal(t, original.InReplyTo, parsed.InReplyTo)
+	assert.Equal(t, original.References, parsed.References)
+	assert.Equal(t, original.Subject, parsed.Subject)
+	assert.True(t, original.Date.Equal(parsed.Date))
+	assert.Equal(t, original.From, parsed.From)
+	assert.Equal(t, original.To, parsed.To)
+	assert.Equal(t, original.Bcc, parsed.Bcc)
+	assert.Equal(t, strings.ReplaceAll(original.Text, "\n", "\r\n"), parsed.Text)
+	assert.Equal(t, original.HTML, parsed.HTML)
+	require.Len(t, parsed.Attachments, 2)
+	assert.Equal(t, original.Attachments[0], parsed.Attachments[0])
+	assert.Equal(t, original.Attachments[1], parsed.Attachments[1])
+}
@@ -0,0 +1,324 @@
+package sieve_test
+
+import (
+	"palm/src/formats/rfc5322"
+	"palm/src/formats/sieve"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMessage is a message from a mailing list with a plain and an HTML
+// body
+func testMessage() *sieve.Message {
+	return &sieve.Message{
+		Header: rfc5322.Header{
+			{Name: "Received", Value: "from mx.example.org by mx.example.com; Tue, 3 Mar 2026 08:15:00 +0000"},
+			{Name: "From", Value: `"Grace Hopper" <Grace@Navy.example.org>`},
+			{Name: "To", Value: "ada@engines.example, team@engines.example"},
+			{Name: "Cc", Value: "charles@engines.example"},
+			{Name: "Subject", Value: "=?UTF-8?Q?[acme-users]_[fwd]_version_1.0_is_out?="},
+			{Name: "Date", Value: "Tue, 3 Mar 2026 09:15:00 +0100"},
+			{Name: "List-Id", Value: "<acme-users.lists.example.org>"},
+			{Name: "X-Spam-Score", Value: "7"},
+		},
+		Size: 12 * 1024,
+		Body: "--b\r\nContent-Type: text/plain\r\n\r\nHello world\r\n--b--\r\n",
+		Parts: []sieve.Part{
+			{ContentType: "text/plain", Text: "Hello world, the release is ready."},
+			{ContentType: "text/html", Text: "<p>Hello <b>world</b></p>"},
+		},
+	}
+}
+
+// run parses and runs a script on the test message
+func run(t *testing.T, script string) []sieve.Action {
+	t.Helper()
+	s, err := sieve.Parse(script)
+	require.NoError(t, err)
+	result, err := s.Run(testMessage(), sieve.Options{
+		Now:      time.Date(2026, time.March, 4, 23, 30, 0, 0, time.UTC),
+		Location: time.UTC,
+	})
+	require.NoError(t, err)
+	return result.Actions
+}
+
+func TestParse_Errors(t *testing.T) {
+	cases := map[string]struct {
+		script string
+		err    error
+	}{
+		"missing semicolon":     {`keep`, sieve.ErrSyntax},
+		"unterminated string":   {`fileinto "abc;`, sieve.ErrSyntax},
+		"unterminated comment":  {`/* keep;`, sieve.ErrSyntax},
+		"unknown command":       {`frobnicate;`, sieve.ErrSyntax},
+		"unknown test":          {`if frobnicate { keep; }`, sieve.ErrSyntax},
+		"fileinto not required": {`fileinto "Junk";`, sieve.ErrSyntax},
+		"unsupported extension": {`require "reject";`, sieve.ErrUnsupported},
+		"late require":          {"keep;\nrequire \"fileinto\";", sieve.ErrSyntax},
+		"else without if":       {`else { keep; }`, sieve.ErrSyntax},
+		"if without block":      {`if true;`, sieve.ErrSyntax},
+		"size without relation": {`if size 10K { keep; }`, sieve.ErrSyntax},
+		"conflicting tags":      {`if header :is :contains "a" "b" { keep; }`, sieve.ErrSyntax},
+		"unknown tag":           {`if header :frob "a" "b" { keep; }`, sieve.ErrSyntax},
+		"relational not required": {
+			`if header :count "ge" "to" "2" { keep; }`, sieve.ErrSyntax},
+		"numeric comparator not required": {
+			`if header :comparator "i;ascii-numeric" "x" "1" { keep; }`, sieve.ErrSyntax},
+		"bad redirect address": {`redirect "not an address";`, sieve.ErrSyntax},
+		"envelope":             {`require "envelope";`, sieve.ErrUnsupported},
+		"bad date part":        {"require \"date\";\nif currentdate \"fortnight\" \"1\" { keep; }", sieve.ErrSyntax},
+		"bad zone":             {"require \"date\";\nif currentdate :zone \"CET\" \"year\" \"2026\" { keep; }", sieve.ErrSyntax},
+		"bad variable name":    {"require \"variables\";\nset \"1st\" \"x\";", sieve.ErrSyntax},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := sieve.Parse(tc.script)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	_, err := sieve.Parse("require \"fileinto\";\n\nif true {\n  fileinto;\n}")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "line 4")
+}
+
+func TestRun_ImplicitKeep(t *testing.T) {
+	assert.Equal(t, []sieve.Action{sieve.Keep{Implicit: true}}, run(t, ``))
+	assert.Equal(t, []sieve.Action{sieve.Keep{Implicit: true}}, run(t, `# just a comment`))
+	assert.Equal(t, []sieve.Action{sieve.Discard{}}, run(t, `discard;`))
+	assert.Equal(t, []sieve.Action{sieve.Keep{}}, run(t, `keep; keep;`))
+	assert.Equal(t, []sieve.Action{sieve.Keep{Implicit: true}}, run(t, `stop; discard;`))
+}
+
+func TestRun_Tests(t *testing.T) {
+	cases := map[string]bool{
+		`header :contains "subject" "version 1.0"`:                     true,
+		`header :is "Subject" "[acme-users] [fwd] version 1.0 is out"`: true,
+		`header :is "subject" "version"`:                               false,
+		`header :comparator "i;octet" :contains "subject" "VERSION"`:   false,
+		`header :contains "subject" "VERSION"`:                         true,
+		`header :matches "list-id" "<*.lists.example.org>"`:            true,
+		`header :contains ["x-missing", "from"] "grace"`:               true,
+		`address :is "from" "grace@navy.example.org"`:                  true,
+		`address :domain :is "from" "navy.example.org"`:                true,
+		`address :localpart :is "to" "team"`:                           true,
+		`address :all :contains ["to", "cc"] "charles"`:                true,
+		`address :domain :is "to" "example.org"`:                       false,
+		`exists ["from", "list-id"]`:                                   true,
+		`exists ["from", "x-missing"]`:                                 false,
+		`size :over 10K`:                                               true,
+		`size :under 10K`:                                              false,
+		`size :over 1M`:                                                false,
+		`not true`:                                                     false,
+		`allof (true, header :contains "to" "ada")`:                    true,
+		`allof (true, false)`:                                          false,
+		`anyof (false, exists "list-id")`:                              true,
+		`anyof (false, not exists "list-id")`:                          false,
+	}
+	for test, want := range cases {
+		t.Run(test, func(t *testing.T) {
+			actions := run(t, "if "+test+" { discard; }")
+			_, discarded := actions[0].(sieve.Discard)
+			assert.Equal(t, want, discarded)
+		})
+	}
+}
+
+func TestRun_Extensions(t *testing.T) {
+	cases := map[string]struct {
+		require string
+		test    string
+		want    bool
+	}{
+		"relational count":    {`["relational", "comparator-i;ascii-numeric"]`, `address :count "ge" :comparator "i;ascii-numeric" ["to", "cc"] "3"`, true},
+		"relational count lt": {`["relational", "comparator-i;ascii-numeric"]`, `header :count "lt" :comparator "i;ascii-numeric" "to" "1"`, false},
+		"relational value":    {`["relational", "comparator-i;ascii-numeric"]`, `header :value "ge" :comparator "i;ascii-numeric" "x-spam-score" "5"`, true},
+		"relational value lt": {`["relational", "comparator-i;ascii-numeric"]`, `header :value "lt" :comparator "i;ascii-numeric" "x-spam-score" "5"`, false},
+		"body text":           {"body", `body :contains "release is ready"`, true},
+		"body text html":      {"body", `body :text :contains "hello world"`, true},
+		"body raw":            {"body", `body :raw :contains "Content-Type: text/plain"`, true},
+		"body content":        {"body", `body :content "text/html" :contains "<b>"`, true},
+		"body content type":   {"body", `body :content "image" :contains "hello"`, false},
+		"date":                {"date", `date :is "date" "date" "2026-03-03"`, true},
+		"date original zone":  {"date", `date :originalzone :is "date" "hour" "09"`, true},
+		"date zone":           {"date", `date :zone "-0500" :is "date" "hour" "03"`, true},
+		"date weekday":        {"date", `date :is "date" "weekday" "2"`, true},
+		"received date":       {"date", `date :is "received" "time" "08:15:00"`, true},
+		"currentdate":         {"date", `currentdate :is "date" "2026-03-04"`, true},
+		"currentdate zone":    {"date", `currentdate :zone "+0100" :is "date" "2026-03-05"`, true},
+		"currentdate julian":  {"date", `currentdate :is "julian" "61103"`, true},
+		"string":              {"variables", `string :is "a" "a"`, true},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			require := tc.require
+			if !strings.HasPrefix(require, "[") {
+				require = `"` + require + `"`
+			}
+			actions := run(t, "require "+require+";\nif "+tc.test+" { discard; }")
+			_, discarded := actions[0].(sieve.Discard)
+			assert.Equal(t, tc.want, discarded)
+		})
+	}
+}
+
+func TestRun_FileInto(t *testing.T) {
+	actions := run(t, `
+require ["fileinto", "copy"];
+if header :contains "list-id" "acme-users" {
+    fileinto "Lists/acme";
+    fileinto "Lists/acme";
+    fileinto :copy "Archive";
+    stop;
+}
+fileinto "Never";
+`)
+	assert.Equal(t, []sieve.Action{
+		sieve.FileInto{Mailbox: "Lists/acme"},
+		sieve.FileInto{Mailbox: "Archive", Copy: true},
+	}, actions)
+
+	actions = run(t, `require ["fileinto", "copy"]; fileinto :copy "Archive";`)
+	assert.Equal(t, []sieve.Action{
+		sieve.FileInto{Mailbox: "Archive", Copy: true},
+		sieve.Keep{Implicit: true},
+	}, actions)
+}
+
+func TestRun_Redirect(t *testing.T) {
+	actions := run(t, `redirect "Bob <bob@example.net>"; redirect "bob@example.net";`)
+	assert.Equal(t, []sieve.Action{sieve.Redirect{Address: "bob@example.net"}}, actions)
+
+	actions = run(t, `require "copy"; redirect :copy "bob@example.net";`)
+	assert.Equal(t, []sieve.Action{
+		sieve.Redirect{Address: "bob@example.net", Copy: true},
+		sieve.Keep{Implicit: true},
+	}, actions)
+}
+
+func TestRun_Imap4Flags(t *testing.T) {
+	actions := run(t, `
+require ["imap4flags", "fileinto"];
+addflag ["\\Seen", "$Work"];
+addflag "\\Flagged \\Seen";
+removeflag "$Work";
+if hasflag :contains "flagged" {
+    fileinto "Important";
+}
+fileinto :flags "\\Answered" "Answered";
+`)
+	assert.Equal(t, []sieve.Action{
+		sieve.FileInto{Mailbox: "Important", Flags: []string{`\Seen`, `\Flagged`}},
+		sieve.FileInto{Mailbox: "Answered", Flags: []string{`\Answered`}},
+	}, actions)
+
+	actions = run(t, `require "imap4flags"; setflag "\\Seen"; setflag "$Junk";`)
+	assert.Equal(t, []sieve.Action{sieve.Keep{Flags: []string{"$Junk"}, Implicit: true}}, actions)
+
+	actions = run(t, `
+require ["imap4flags", "variables"];
+addflag "mine" "$A";
+if hasflag "mine" "$a" { keep :flags "${0}x"; }
+`)
+	assert.Equal(t, []sieve.Action{sieve.Keep{Flags: []string{"x"}}}, actions)
+}
+
+func TestRun_Variables(t *testing.T) {
+	// The example of RFC 5229 section 3.2
+	actions := run(t, `
+require ["variables", "fileinto"];
+if header :matches "Subject" "[*] *" {
+    set :lower "list" "${1}";
+    set "rest" "${2}";
+    fileinto "Lists/${list}";
+}
+if string :is "${rest}" "[fwd] version 1.0 is out" {
+    fileinto "Rest matched";
+}
+set :upperfirst "name" "grace";
+set :length "length" "${name}";
+set :quotewildcard "pattern" "a*b?";
+set "unknown" "[${undefined}] [${x.y}] [${1bad}] [${}]";
+fileinto "${name} ${length} ${pattern} ${unknown}";
+`)
+	assert.Equal(t, []sieve.Action{
+		sieve.FileInto{Mailbox: "Lists/acme-users"},
+		sieve.FileInto{Mailbox: "Rest matched"},
+		sieve.FileInto{Mailbox: `Grace 5 a\*b\? [] [] [${1bad}] [${}]`},
+	}, actions)
+
+	// Without the extension strings are taken as they are
+	actions = run(t, `require "fileinto"; fileinto "${name}";`)
+	assert.Equal(t, []sieve.Action{sieve.FileInto{Mailbox: "${name}"}}, actions)
+
+	// A :matches key with quoted wildcards matches them literally
+	actions = run(t, `
+require ["variables", "fileinto"];
+set :quotewildcard "q" "[*]";
+if header :matches "subject" "${q}*" { fileinto "literal"; }
+if header :matches "subject" "\\[acme-users\\]*" { fileinto "escaped"; }
+`)
+	assert.Equal(t, []sieve.Action{sieve.FileInto{Mailbox: "escaped"}}, actions)
+}
+
+func TestRun_Vacation(t *testing.T) {
+	actions := run(t, `
+require "vacation";
+vacation :days 3 :subject "Away" :from "ada@engines.example" :addresses ["ada@home.example"]
+    :handle "march" text:
+I am away until Monday.
+..
+.
+;
+`)
+	require.Len(t, actions, 2)
+	assert.Equal(t, sieve.Vacation{
+		Reason:    "I am away until Monday.\r\n.\r\n",
+		Subject:   "Away",
+		From:      "ada@engines.example",
+		Addresses: []string{"ada@home.example"},
+		Days:      3,
+		Handle:    "march",
+	}, actions[0])
+	assert.Equal(t, sieve.Keep{Implicit: true}, actions[1])
+
+	// The default handle is derived from the response
+	first := run(t, `require "vacation"; vacation "Away";`)[0].(sieve.Vacation)
+	second := run(t, `require "vacation"; vacation "Away until May";`)[0].(sieve.Vacation)
+	assert.Equal(t, 7, first.Days)
+	assert.NotEmpty(t, first.Handle)
+	assert.NotEqual(t, first.Handle, second.Handle)
+
+	s, err := sieve.Parse(`require "vacation"; vacation "a"; vacation "b";`)
+	require.NoError(t, err)
+	result, err := s.Run(testMessage(), sieve.Options{})
+	assert.ErrorIs(t, err, sieve.ErrRuntime)
+	assert.Equal(t, []sieve.Action{sieve.Keep{Implicit: true}}, result.Actions)
+}
+
+func TestRun_RuntimeError(t *testing.T) {
+	s, err := sieve.Parse(`
+require ["variables"];
+set "to" "not an address";
+discard;
+redirect "${to}";
+`)
+	require.NoError(t, err)
+	result, err := s.Run(testMessage(), sieve.Options{})
+	assert.ErrorIs(t, err, sieve.ErrRuntime)
+	assert.Contains(t, err.Error(), "line 5")
+	// Actions taken before the error are dropped and the message kept
+	assert.Equal(t, []sieve.Action{sieve.Keep{Implicit: true}}, result.Actions)
+}
+
+func TestRequires(t *testing.T) {
+	s, err := sieve.Parse(`require ["Vacation", "fileinto"];`)
+	require.NoError(t, err)
+	assert.True(t, s.Requires("vacation"))
+	assert.False(t, s.Requires("variables"))
+}
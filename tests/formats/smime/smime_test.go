@@ -0,0 +1,53 @@
+package smime_test
+
+import (
+	"bytes"
+	"palm/src/formats/smime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteParse_RoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte{0x30, 0x82, 0x00, 0xff}, 100)
+	entity := smime.Write(smime.EnvelopedData, data)
+
+	for _, line := range strings.Split(string(entity), "\r\n") {
+		assert.LessOrEqual(t, len(line), 998)
+	}
+
+	parsed, err := smime.Parse(entity)
+	require.NoError(t, err)
+	assert.Equal(t, smime.EnvelopedData, parsed.SMIMEType)
+	assert.Equal(t, data, parsed.Data)
+}
+
+func TestParse_Message(t *testing.T) {
+	// Older clients send the x- media type; a whole message parses too
+	message := "From: alice@example.com\r\n" +
+		"To: bob@example.org\r\n" +
+		"Subject: Signed\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: application/x-pkcs7-mime; smime-type=Signed-Data; name=smime.p7m\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"MIIBAgMEBQ==\r\n"
+
+	parsed, err := smime.Parse([]byte(message))
+	require.NoError(t, err)
+	assert.Equal(t, smime.SignedData, parsed.SMIMEType)
+	assert.Equal(t, []byte{0x30, 0x82, 0x01, 0x02, 0x03, 0x04, 0x05}, parsed.Data)
+}
+
+func TestParse_NotPKCS7MIME(t *testing.T) {
+	_, err := smime.Parse([]byte("Content-Type: text/plain\r\n\r\nHello\r\n"))
+	assert.ErrorIs(t, err, smime.ErrNotPKCS7MIME)
+}
+
+func TestIsSignatureType(t *testing.T) {
+	assert.True(t, smime.IsSignatureType("application/pkcs7-signature"))
+	assert.True(t, smime.IsSignatureType("application/x-pkcs7-signature"))
+	assert.False(t, smime.IsSignatureType("application/pgp-signature"))
+}
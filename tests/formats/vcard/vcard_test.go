@@ -0,0 +1,168 @@
+package vcard_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"palm/src/formats/vcard"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func decodeFile(t *testing.T, name string) []*vcard.Card {
+	f, err := os.Open(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	cards, err := vcard.DecodeAll(f)
+	require.NoError(t, err)
+	return cards
+}
+
+// exportCards is the set written to the golden export files
+func exportCards() []*vcard.Card {
+	return []*vcard.Card{
+		{
+			UID:           "palm-contact-1",
+			FormattedName: "Ada Lovelace",
+			Name:          vcard.Name{Family: "Lovelace", Given: "Ada"},
+			Emails: []vcard.TypedValue{
+				{Value: "ada@engines.example", Types: []string{"work"}, Preferred: true},
+				{Value: "ada.home@example.org"},
+			},
+			Phones:       []vcard.TypedValue{{Value: "+44 20 7946 0000", Types: []string{"cell"}}},
+			Organization: "Analytical Engines, Ltd.",
+			Note:         "Line one\nLine two; with a semicolon and a rather long tail that must be folded",
+			Photo:        &vcard.Photo{MediaType: "image/png", Data: bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, 16)},
+		},
+		{
+			FormattedName: "Grace Hopper",
+			Emails:        []vcard.TypedValue{{Value: "grace@navy.example"}},
+		},
+	}
+}
+
+func TestDecode_Version3(t *testing.T) {
+	cards := decodeFile(t, "apple_3.vcf")
+	require.Len(t, cards, 2)
+
+	ada := cards[0]
+	assert.Equal(t, vcard.Version3, ada.Version)
+	assert.Equal(t, "Ada Lovelace", ada.DisplayName())
+	assert.Equal(t, vcard.Name{Family: "Lovelace", Given: "Ada", Additional: "King", Prefix: "Countess"}, ada.Name)
+	assert.Equal(t, "Analytical Engines, Ltd., Research", ada.Organization)
+	assert.Equal(t, "Met at the Royal Society\nPrefers letters; not telegrams", ada.Note)
+
+	require.Len(t, ada.Emails, 2)
+	assert.Equal(t, "ada@engines.example", ada.Emails[0].Value)
+	assert.True(t, ada.Emails[0].Preferred)
+	assert.True(t, ada.Emails[0].HasType("work"))
+	assert.False(t, ada.Emails[0].HasType("internet"), "INTERNET is implied")
+	assert.True(t, ada.Emails[1].HasType("home"), "comma separated types are split")
+
+	require.Len(t, ada.Phones, 2)
+	assert.Equal(t, "+44 20 7946 0000", ada.Phones[0].Value)
+	assert.Equal(t, []string{"cell"}, ada.Phones[0].Types)
+	assert.True(t, ada.Phones[0].Preferred)
+	assert.Equal(t, []string{"work"}, ada.Phones[1].Types)
+
+	require.NotNil(t, ada.Photo, "folded PHOTO lines are joined")
+	assert.Equal(t, "image/jpeg", ada.Photo.MediaType)
+	assert.Equal(t, []byte{0xff, 0xd8, 0xff, 0xe0}, ada.Photo.Data[:4])
+
+	assert.Empty(t, cards[1].Emails)
+	assert.Equal(t, "No Address", cards[1].DisplayName())
+}
+
+func TestDecode_Version4(t *testing.T) {
+	cards := decodeFile(t, "rfc6350_4.vcf")
+	require.Len(t, cards, 1)
+
+	grace := cards[0]
+	assert.Equal(t, vcard.Version4, grace.Version)
+	assert.Equal(t, "urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1", grace.UID)
+	assert.Equal(t, "Brewster Murray", grace.Name.Additional)
+
+	require.Len(t, grace.Emails, 2)
+	assert.True(t, grace.Emails[0].Preferred, "PREF parameter marks the preferred address")
+	assert.Equal(t, "Grace.Hopper@Example.COM", grace.Emails[1].Value)
+
+	require.Len(t, grace.Phones, 1)
+	assert.Equal(t, "+1-555-555-0199", grace.Phones[0].Value, "tel: URIs are unwrapped")
+	assert.Equal(t, []string{"home"}, grace.Phones[0].Types)
+
+	require.NotNil(t, grace.Photo)
+	assert.Equal(t, "image/png", grace.Photo.MediaType)
+	assert.Equal(t, []byte("\x89PNG"), grace.Photo.Data[:4])
+}
+
+func TestDecode_Errors(t *testing.T) {
+	_, err := vcard.DecodeAll(strings.NewReader("BEGIN:VCARD\r\nVERSION:2.1\r\nFN:Old\r\nEND:VCARD\r\n"))
+	assert.ErrorIs(t, err, vcard.ErrUnsupportedVersion)
+
+	_, err = vcard.DecodeAll(strings.NewReader("BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Unterminated\r\n"))
+	assert.ErrorIs(t, err, vcard.ErrMalformed)
+}
+
+func TestEncode_Golden(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		golden  string
+	}{
+		{vcard.Version3, "export_3.vcf"},
+		{vcard.Version4, "export_4.vcf"},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, vcard.EncodeAll(&buf, tc.version, exportCards()))
+
+			path := filepath.Join("testdata", tc.golden)
+			if *update {
+				require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+			}
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), buf.String())
+
+			for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+				assert.LessOrEqual(t, len(line), 75, "lines are folded at 75 octets")
+			}
+		})
+	}
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	for _, version := range []string{vcard.Version3, vcard.Version4} {
+		t.Run(version, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, vcard.EncodeAll(&buf, version, exportCards()))
+
+			cards, err := vcard.DecodeAll(&buf)
+			require.NoError(t, err)
+			require.Len(t, cards, 2)
+
+			for i, want := range exportCards() {
+				got := cards[i]
+				assert.Equal(t, version, got.Version)
+				assert.Equal(t, want.UID, got.UID)
+				assert.Equal(t, want.FormattedName, got.FormattedName)
+				assert.Equal(t, want.Organization, got.Organization)
+				assert.Equal(t, want.Note, got.Note)
+				assert.Equal(t, want.Emails, got.Emails)
+				assert.Equal(t, want.Phones, got.Phones)
+				assert.Equal(t, want.Photo, got.Photo)
+			}
+		})
+	}
+}
+
+func TestEncode_UnsupportedVersion(t *testing.T) {
+	err := vcard.EncodeAll(&bytes.Buffer{}, "2.1", exportCards())
+	assert.ErrorIs(t, err, vcard.ErrUnsupportedVersion)
+}
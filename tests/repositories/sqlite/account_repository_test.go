@@ -10,6 +10,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 func TestAccountRepository_Create(t *testing.T) {
@@ -121,6 +122,36 @@ func TestAccountRepository_Delete(t *testing.T) {
 	assert.ErrorIs(t, err, repositories.ErrAccountNotFound)
 }
 
+func TestAccountRepository_Update(t *testing.T) {
+	// Setup
+	db := utils.SetupTestDB(t)
+	repo := sqlite.NewAccountRepository(db)
+	ctx := context.Background()
+
+	// Create test account
+	testAccount := &entities.Account{
+		Email:       "test@example.com",
+		AccountType: entities.AccountTypeGoogle,
+	}
+	result := repo.Create(ctx, testAccount)
+	require.NoError(t, result.Error)
+
+	// Test: Update existing account
+	testAccount.TrustedAuthServIDs = []string{"mx.example.com"}
+	err := repo.Update(ctx, testAccount)
+	require.NoError(t, err)
+
+	// Verify update
+	account, err := repo.GetByID(ctx, testAccount.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mx.example.com"}, account.TrustedAuthServIDs)
+	assert.Equal(t, "test@example.com", account.Email)
+
+	// Test: Update non-existent account
+	err = repo.Update(ctx, &entities.Account{Model: gorm.Model{ID: 9999}, Email: "other@example.com"})
+	assert.ErrorIs(t, err, repositories.ErrAccountNotFound)
+}
+
 func TestAccountRepository_List(t *testing.T) {
 	// Setup
 	db := utils.SetupTestDB(t)
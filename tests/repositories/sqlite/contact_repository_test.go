@@ -0,0 +1,39 @@
+package sqlite_test
+
+import (
+	"context"
+	"palm/src/entities"
+	"palm/src/repositories/sqlite"
+	"palm/tests/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContactRepository_SearchSkipsDeleted(t *testing.T) {
+	// Setup
+	db := utils.SetupTestDB(t)
+	repo := sqlite.NewContactRepository(db)
+	ctx := context.Background()
+
+	name := "Jane Doe"
+	kept := &entities.Contact{DisplayName: &name, Emails: []entities.ContactEmail{{Email: "jane@work.example"}}}
+	require.NoError(t, repo.Create(ctx, kept).Error)
+	// A contact merged away is soft-deleted; its name still matches
+	deleted := &entities.Contact{DisplayName: &name, Emails: []entities.ContactEmail{{Email: "jane@home.example"}}}
+	require.NoError(t, repo.Create(ctx, deleted).Error)
+	require.NoError(t, db.Delete(deleted).Error)
+
+	// Test: by display name and by address
+	for _, prefix := range []string{"jane", "doe", "jane@home"} {
+		contacts, err := repo.Search(ctx, prefix, 10)
+		require.NoError(t, err)
+		for _, contact := range contacts {
+			assert.Equal(t, kept.ID, contact.ID, "prefix %q", prefix)
+		}
+	}
+	contacts, err := repo.Search(ctx, "jane", 10)
+	require.NoError(t, err)
+	assert.Len(t, contacts, 1)
+}
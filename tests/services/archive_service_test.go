@@ -0,0 +1,348 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/maildir"
+	"palm/src/formats/mbox"
+	"palm/src/formats/rfc5322"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// sampleMbox is shared with the mbox reader tests
+var sampleMbox = filepath.Join("..", "formats", "mbox", "testdata", "sample.mbox")
+
+func newArchiveTestServices(t *testing.T, db *gorm.DB) (*services.EmailService, *services.ArchiveService) {
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	store := services.NewAttachmentStore(t.TempDir())
+	return emailService, services.NewArchiveService(db, sqlite.NewAccountRepository(db), emailService, store)
+}
+
+// findBySubject returns the account's email with the given subject
+func findBySubject(t *testing.T, ctx context.Context, emailService *services.EmailService, accountID uint, subject string) *services.EmailDTO {
+	page, err := emailService.Search(ctx, accountID, subject, 10, 1)
+	require.NoError(t, err)
+	for _, email := range page.Emails {
+		if *email.Message.Subject == subject {
+			return email
+		}
+	}
+	t.Fatalf("no email with subject %q", subject)
+	return nil
+}
+
+func TestArchiveService_ImportMbox(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, archiveService := newArchiveTestServices(t, db)
+	bus := events.NewBus()
+	archiveService.SetEventBus(bus)
+	var finished []events.ImportPayload
+	bus.Subscribe(events.TopicImportFinished, func(e events.Event) {
+		finished = append(finished, e.Payload.(events.ImportPayload))
+	})
+
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "bob@example.org")
+
+	file, err := os.Open(sampleMbox)
+	require.NoError(t, err)
+	defer file.Close()
+	info, err := file.Stat()
+	require.NoError(t, err)
+
+	var progress []services.ImportResult
+	result, err := archiveService.ImportMbox(ctx, account.ID, file, services.ImportOptions{
+		BatchSize:  2,
+		TotalBytes: info.Size(),
+		Progress:   func(p services.ImportResult) { progress = append(progress, p) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Processed)
+	assert.Equal(t, 3, result.Imported)
+	assert.Equal(t, 1, result.Duplicates, "the repeated Message-ID is skipped")
+	assert.Equal(t, 0, result.Failed)
+
+	require.Len(t, progress, 2, "progress is reported after every batch")
+	assert.Equal(t, info.Size(), progress[1].BytesRead)
+	require.Len(t, finished, 1)
+	assert.Equal(t, 3, finished[0].Imported)
+
+	plain := findBySubject(t, ctx, emailService, account.ID, "Plain hello")
+	assert.Equal(t, "plain-1@example.com", *plain.Message.InternetMessageID)
+	assert.Equal(t, "Alice Example", *plain.Message.SenderName)
+	assert.True(t, plain.Message.IsRead, "Status: RO marks the message read")
+	assert.Equal(t, 2024, plain.Message.ReceivedDatetime.Year())
+	assert.Equal(t, 5, plain.Message.ReceivedDatetime.Second(), "the delivery time comes from Received")
+	assert.Contains(t, *plain.Message.Body, "From the notes I sent earlier:")
+
+	multi := findBySubject(t, ctx, emailService, account.ID, "Réunion with attachment")
+	assert.Equal(t, "carol@example.net", multi.Message.SenderEmail)
+	assert.Equal(t, "Carol Müller", *multi.Message.SenderName)
+	assert.Equal(t, entities.ImportanceHigh, multi.Message.Importance)
+	assert.False(t, multi.Message.IsRead, "X-Mozilla-Status without the read flag")
+	assert.Equal(t, "plain-1@example.com", *multi.Message.ConversationID, "threads are keyed by their root message")
+	assert.Equal(t, "<p>Réunion demain à 10h.</p>", *multi.Message.Body)
+	assert.Equal(t, "Réunion demain à 10h.", *multi.Message.BodyPreview)
+	require.Len(t, multi.Recipients, 3)
+	assert.Equal(t, entities.RecipientTypeCc, multi.Recipients[2].RecipientType)
+	require.Len(t, multi.Attachments, 1)
+	assert.Equal(t, "agenda.csv", multi.Attachments[0].Filename)
+	assert.Equal(t, "text/csv", multi.Attachments[0].MimeType)
+	require.NotNil(t, multi.Attachments[0].LocalPath)
+	content, err := os.ReadFile(*multi.Attachments[0].LocalPath)
+	require.NoError(t, err)
+	assert.Equal(t, "topic,minutes\nbudget,30\n", string(content))
+
+	undisclosed := findBySubject(t, ctx, emailService, account.ID, "No recipients")
+	require.Len(t, undisclosed.Recipients, 1)
+	assert.Equal(t, "bob@example.org", undisclosed.Recipients[0].Email, "messages without recipients are addressed to the account")
+	assert.False(t, undisclosed.Message.IsRead, "the Gmail Unread label is honoured")
+
+	// A second import finds everything already stored
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	result, err = archiveService.ImportMbox(ctx, account.ID, file, services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Imported)
+	assert.Equal(t, 4, result.Duplicates)
+
+	_, err = archiveService.ImportMbox(ctx, 9999, bytes.NewReader(nil), services.ImportOptions{})
+	assert.ErrorIs(t, err, services.ErrAccountNotFound)
+}
+
+func TestArchiveService_ImportMboxSkipsBrokenMessages(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	_, archiveService := newArchiveTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+
+	input := "From a@example.com Mon Jan  1 00:00:00 2024\n\nno header at all\n\n" +
+		"From b@example.com Mon Jan  1 00:00:00 2024\nFrom: b@example.com\nTo: me@example.com\nSubject: fine\n\nbody\n"
+	result, err := archiveService.ImportMbox(ctx, account.ID, bytes.NewBufferString(input), services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Processed)
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, 1, result.Failed)
+
+	_, err = archiveService.ImportMbox(ctx, account.ID, bytes.NewBufferString("not an mbox"), services.ImportOptions{})
+	assert.ErrorIs(t, err, mbox.ErrNotMbox)
+}
+
+func TestArchiveService_ExportMboxRoundTrip(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	_, archiveService := newArchiveTestServices(t, db)
+	accountRepo := sqlite.NewAccountRepository(db)
+	source := createTestAccount(t, ctx, accountRepo, "bob@example.org")
+	target := createTestAccount(t, ctx, accountRepo, "copy@example.org")
+
+	file, err := os.Open(sampleMbox)
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = archiveService.ImportMbox(ctx, source.ID, file, services.ImportOptions{})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	count, err := archiveService.ExportMbox(ctx, source.ID, "", &buf, mbox.MboxRD)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Messages are exported oldest first and parse back with their content
+	reader := mbox.NewReader(bytes.NewReader(buf.Bytes()), mbox.MboxRD)
+	var subjects []string
+	for {
+		msg, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		parsed, err := rfc5322.Parse(bytes.NewReader(msg.Data))
+		require.NoError(t, err)
+		subjects = append(subjects, parsed.Subject)
+		if parsed.Subject == "Réunion with attachment" {
+			assert.Equal(t, "carol@example.net", msg.Sender())
+			require.Len(t, parsed.Attachments, 1)
+			assert.Equal(t, "topic,minutes\nbudget,30\n", string(parsed.Attachments[0].Data))
+		}
+	}
+	assert.Equal(t, []string{"Plain hello", "Réunion with attachment", "No recipients"}, subjects)
+
+	// Importing the export elsewhere reproduces the mailbox
+	result, err := archiveService.ImportMbox(ctx, target.ID, bytes.NewReader(buf.Bytes()), services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Imported)
+
+	// A search exports only its matches
+	buf.Reset()
+	count, err = archiveService.ExportMbox(ctx, source.ID, "Réunion", &buf, mbox.MboxO)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestArchiveService_MaildirRoundTrip(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, archiveService := newArchiveTestServices(t, db)
+	accountRepo := sqlite.NewAccountRepository(db)
+	source := createTestAccount(t, ctx, accountRepo, "bob@example.org")
+	target := createTestAccount(t, ctx, accountRepo, "copy@example.org")
+
+	file, err := os.Open(sampleMbox)
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = archiveService.ImportMbox(ctx, source.ID, file, services.ImportOptions{})
+	require.NoError(t, err)
+
+	// Flag one message so its state travels as a Maildir flag
+	flagged := findBySubject(t, ctx, emailService, source.ID, "Plain hello")
+	require.NoError(t, db.Model(&entities.Message{}).Where("id = ?", flagged.Message.ID).Update("is_flagged", true).Error)
+
+	path := filepath.Join(t.TempDir(), "export")
+	count, err := archiveService.ExportMaildir(ctx, source.ID, "", path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	dir, err := maildir.Open(path)
+	require.NoError(t, err)
+	entries, err := dir.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	var flags []maildir.Flags
+	for _, entry := range entries {
+		flags = append(flags, entry.Flags)
+	}
+	assert.ElementsMatch(t, []maildir.Flags{"FS", "", ""}, flags, "read and flagged state become flags")
+
+	result, err := archiveService.ImportMaildir(ctx, target.ID, path, services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Imported)
+	assert.Equal(t, result.TotalBytes, result.BytesRead)
+
+	copied := findBySubject(t, ctx, emailService, target.ID, "Plain hello")
+	assert.True(t, copied.Message.IsRead)
+	assert.True(t, copied.Message.IsFlagged)
+	assert.Equal(t, "plain-1@example.com", *copied.Message.InternetMessageID)
+	unread := findBySubject(t, ctx, emailService, target.ID, "No recipients")
+	assert.False(t, unread.Message.IsRead, "messages in new without flags are unread")
+
+	result, err = archiveService.ImportMaildir(ctx, target.ID, path, services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Duplicates)
+
+	_, err = archiveService.ImportMaildir(ctx, target.ID, t.TempDir(), services.ImportOptions{})
+	assert.ErrorIs(t, err, maildir.ErrNotMaildir)
+}
+
+func TestArchiveService_EMLRoundTrip(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	_, archiveService := newArchiveTestServices(t, db)
+	accountRepo := sqlite.NewAccountRepository(db)
+	account := createTestAccount(t, ctx, accountRepo, "bob@example.org")
+	copyAccount := createTestAccount(t, ctx, accountRepo, "copy@example.org")
+
+	original, err := os.ReadFile(filepath.Join("testdata", "invoice.eml"))
+	require.NoError(t, err)
+
+	email, err := archiveService.ImportEML(ctx, account.ID, bytes.NewReader(original))
+	require.NoError(t, err)
+	assert.Equal(t, "Invoice № 2024-031", *email.Message.Subject)
+	assert.Equal(t, "billing@supplier.example", email.Message.SenderEmail)
+	assert.Equal(t, "order-77@example.org", *email.Message.ConversationID)
+	assert.Equal(t, 42, email.Message.ReceivedDatetime.Second(), "the delivery time comes from Received")
+	require.Len(t, email.Recipients, 2)
+	assert.Equal(t, entities.RecipientTypeCc, email.Recipients[1].RecipientType)
+	require.Len(t, email.Attachments, 1)
+	assert.Equal(t, "invoice-2024-031.pdf", email.Attachments[0].Filename)
+
+	_, err = archiveService.ImportEML(ctx, account.ID, bytes.NewReader(original))
+	assert.ErrorIs(t, err, services.ErrDuplicateEmail)
+	_, err = archiveService.ImportEML(ctx, account.ID, bytes.NewBufferString("just some text"))
+	assert.ErrorIs(t, err, services.ErrInvalidEML)
+
+	filename, err := archiveService.EMLFilename(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Invoice № 2024-031.eml", filename)
+
+	// The stored source is saved unchanged
+	var exported bytes.Buffer
+	require.NoError(t, archiveService.WriteEML(ctx, email.Message.ID, &exported))
+	assert.Equal(t, string(original), exported.String())
+
+	// Without it, the source rebuilt from the email carries the same content
+	require.NoError(t, db.Where("message_id = ?", email.Message.ID).Delete(&entities.MessageSource{}).Error)
+	exported.Reset()
+	require.NoError(t, archiveService.WriteEML(ctx, email.Message.ID, &exported))
+	want, err := rfc5322.Parse(bytes.NewReader(original))
+	require.NoError(t, err)
+	got, err := rfc5322.Parse(bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+
+	assert.Equal(t, want.MessageID, got.MessageID)
+	assert.Equal(t, want.References, got.References, "the thread root is kept as a reference")
+	assert.Equal(t, want.Subject, got.Subject)
+	assert.True(t, want.Date.Equal(got.Date))
+	assert.Equal(t, want.From.Address, got.From.Address)
+	assert.Equal(t, want.From.Name, got.From.Name)
+	assert.Equal(t, want.To[0].Address, got.To[0].Address)
+	assert.Equal(t, want.Cc[0].Address, got.Cc[0].Address)
+	assert.Equal(t, want.HTML, got.HTML)
+	assert.Contains(t, got.Text, "The total is 120 €.")
+	require.Len(t, got.Attachments, 1)
+	assert.Equal(t, want.Attachments[0].Filename, got.Attachments[0].Filename)
+	assert.Equal(t, want.Attachments[0].ContentType, got.Attachments[0].ContentType)
+	assert.Equal(t, want.Attachments[0].Data, got.Attachments[0].Data)
+
+	// Importing the export elsewhere gives the same email
+	copied, err := archiveService.ImportEML(ctx, copyAccount.ID, bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, *email.Message.Subject, *copied.Message.Subject)
+	assert.Equal(t, *email.Message.Body, *copied.Message.Body)
+	assert.Equal(t, *email.Message.ConversationID, *copied.Message.ConversationID)
+	assert.Equal(t, len(email.Recipients), len(copied.Recipients))
+	assert.Equal(t, email.Attachments[0].Size, copied.Attachments[0].Size)
+
+	_, err = archiveService.EMLFilename(ctx, 9999)
+	assert.ErrorIs(t, err, services.ErrEmailNotFound)
+}
+
+func TestArchiveService_ImportEMLFiles(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	_, archiveService := newArchiveTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "bob@example.org")
+
+	dir := t.TempDir()
+	other := filepath.Join(dir, "other.eml")
+	require.NoError(t, os.WriteFile(other, []byte("From: a@example.com\r\nTo: bob@example.org\r\nSubject: other\r\n\r\nbody\r\n"), 0o644))
+
+	invoice := filepath.Join("testdata", "invoice.eml")
+	result, err := archiveService.ImportEMLFiles(ctx, account.ID,
+		[]string{invoice, other, invoice, filepath.Join(dir, "missing.eml")}, services.ImportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Processed)
+	assert.Equal(t, 2, result.Imported)
+	assert.Equal(t, 1, result.Duplicates)
+	assert.Equal(t, 1, result.Failed, "unreadable files are counted as failed")
+}
@@ -0,0 +1,208 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"palm/src/entities"
+	"palm/src/formats/ical"
+	"palm/src/formats/rfc5322"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// invitationICS is a weekly meeting Grace invites Ada and Charles to
+const invitationICS = "BEGIN:VCALENDAR\r\n" +
+	"PRODID:-//Example//Calendar//EN\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:%s\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:review-42@navy.example\r\n" +
+	"SEQUENCE:1\r\n" +
+	"DTSTAMP:20261020T093000Z\r\n" +
+	"DTSTART;TZID=Europe/Paris:20261103T100000\r\n" +
+	"DTEND;TZID=Europe/Paris:20261103T113000\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=TU;COUNT=6\r\n" +
+	"SUMMARY:Compiler review\r\n" +
+	"LOCATION:Room 4\r\n" +
+	"ORGANIZER;CN=Grace Hopper:mailto:grace@navy.example\r\n" +
+	"ATTENDEE;PARTSTAT=NEEDS-ACTION;RSVP=TRUE;CN=Ada Lovelace:mailto:ada@engines.example\r\n" +
+	"ATTENDEE;PARTSTAT=ACCEPTED;CN=Charles Babbage:mailto:charles@engines.example\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// invitationMessage returns a message carrying the invitation inline and
+// as an attachment, as Outlook and Google Calendar send them
+func invitationMessage(method string) []byte {
+	ics := strings.Replace(invitationICS, "%s", method, 1)
+	encoded := base64.StdEncoding.EncodeToString([]byte(ics))
+	return []byte("From: Grace Hopper <grace@navy.example>\r\n" +
+		"To: Ada Lovelace <ada@engines.example>\r\n" +
+		"Subject: Invitation: Compiler review\r\n" +
+		"Message-ID: <invite-" + method + "@navy.example>\r\n" +
+		"Date: Tue, 20 Oct 2026 09:30:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"You have been invited to Compiler review.\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/calendar; charset=utf-8; method=" + method + "\r\n" +
+		"\r\n" +
+		ics +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/ics; name=invite.ics\r\n" +
+		"Content-Disposition: attachment; filename=invite.ics\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		encoded + "\r\n" +
+		"--outer--\r\n")
+}
+
+func TestCalendarService_Extract(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	calendarService := services.NewCalendarService(db, s.email, s.send, nil)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "ada@engines.example")
+
+	email := s.receive(t, ctx, account.ID, invitationMessage(ical.MethodRequest))
+	extracted, err := calendarService.Extract(ctx, email.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, extracted, 1, "the inline part and the attachment hold the same event")
+
+	events, err := calendarService.Events(ctx, email.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	event := events[0]
+	assert.Equal(t, ical.MethodRequest, event.Method)
+	assert.Equal(t, "review-42@navy.example", event.UID)
+	assert.Equal(t, 1, event.Sequence)
+	assert.Equal(t, "Compiler review", event.Summary)
+	assert.Equal(t, "Europe/Paris", event.TimeZone)
+	assert.Equal(t, "grace@navy.example", event.OrganizerEmail)
+	assert.Equal(t, "Grace Hopper", event.OrganizerName)
+	require.Len(t, event.Attendees, 2)
+	assert.Equal(t, "ada@engines.example", event.Attendees[0].Email)
+	assert.True(t, event.Attendees[0].RSVP)
+	assert.Empty(t, event.Response)
+
+	// Paris is on winter time in November
+	assert.True(t, event.Start.Equal(time.Date(2026, 11, 3, 9, 0, 0, 0, time.UTC)))
+	tokyo := time.FixedZone("JST", 9*60*60)
+	assert.Equal(t, "Tue, Nov 3, 2026, 18:00 – 19:30 JST", services.FormatEventTime(event, tokyo))
+	assert.Equal(t, "Every week on Tuesday, 6 times", services.RecurrenceSummary(event))
+
+	// Extracting again replaces the events
+	_, err = calendarService.Extract(ctx, email.Message.ID)
+	require.NoError(t, err)
+	var count int64
+	require.NoError(t, db.Model(&entities.CalendarEvent{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Deleting the email deletes its events
+	require.NoError(t, s.email.Delete(ctx, int64(email.Message.ID)))
+	require.NoError(t, db.Model(&entities.CalendarEvent{}).Count(&count).Error)
+	assert.Zero(t, count)
+	require.NoError(t, db.Model(&entities.CalendarAttendee{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestCalendarService_Respond(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	calendarService := services.NewCalendarService(db, s.email, s.send, nil)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "ada@engines.example")
+
+	email := s.receive(t, ctx, account.ID, invitationMessage(ical.MethodRequest))
+	events, err := calendarService.Extract(ctx, email.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	_, err = calendarService.Respond(ctx, events[0].ID, "maybe")
+	assert.ErrorIs(t, err, services.ErrInvalidRSVP)
+	_, err = calendarService.Respond(ctx, events[0].ID+100, ical.PartStatAccepted)
+	assert.ErrorIs(t, err, services.ErrEventNotFound)
+
+	result, err := calendarService.Respond(ctx, events[0].ID, "accepted")
+	require.NoError(t, err)
+	assert.NotZero(t, result.MessageID, "a copy of the reply is stored")
+	require.Len(t, s.transport.deliveries, 1)
+
+	raw := s.transport.deliveryTo(t, "grace@navy.example")
+	assert.Contains(t, string(raw), "method=REPLY")
+	m, err := rfc5322.Parse(bytes.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "Accepted: Compiler review", m.Subject)
+	assert.Equal(t, "invite-REQUEST@navy.example", m.InReplyTo)
+	assert.Contains(t, m.Text, "Ada Lovelace has accepted this invitation.")
+	require.Len(t, m.Attachments, 1)
+	assert.Equal(t, ical.MediaType, m.Attachments[0].ContentType)
+
+	reply, err := ical.Parse(bytes.NewReader(m.Attachments[0].Data))
+	require.NoError(t, err)
+	assert.Equal(t, ical.MethodReply, reply.Method)
+	require.Len(t, reply.Events, 1)
+	assert.Equal(t, "review-42@navy.example", reply.Events[0].UID)
+	assert.Equal(t, 1, reply.Events[0].Sequence)
+	require.Len(t, reply.Events[0].Attendees, 1)
+	assert.Equal(t, "ada@engines.example", reply.Events[0].Attendees[0].Email)
+	assert.Equal(t, ical.PartStatAccepted, reply.Events[0].Attendees[0].PartStat)
+
+	events, err = calendarService.Events(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ical.PartStatAccepted, events[0].Response)
+	assert.NotNil(t, events[0].RespondedAt)
+	assert.Equal(t, ical.PartStatAccepted, events[0].Attendees[0].PartStat)
+
+	// The stored copy of the reply carries the REPLY
+	replies, err := calendarService.Extract(ctx, result.MessageID)
+	require.NoError(t, err)
+	require.Len(t, replies, 1)
+	assert.Equal(t, ical.MethodReply, replies[0].Method)
+	_, err = calendarService.Respond(ctx, replies[0].ID, ical.PartStatDeclined)
+	assert.ErrorIs(t, err, services.ErrNotAnInvitation)
+
+	// A cancellation cannot be answered
+	cancel := s.receive(t, ctx, account.ID, invitationMessage(ical.MethodCancel))
+	events, err = calendarService.Extract(ctx, cancel.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	_, err = calendarService.Respond(ctx, events[0].ID, ical.PartStatDeclined)
+	assert.ErrorIs(t, err, services.ErrNotAnInvitation)
+}
+
+func TestFormatEventTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	overnight := &entities.CalendarEvent{
+		Start: time.Date(2026, 3, 3, 22, 0, 0, 0, time.UTC),
+		End:   time.Date(2026, 3, 4, 1, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, "Tue, Mar 3, 2026, 23:00 – Wed, Mar 4, 2026, 02:00 CET", services.FormatEventTime(overnight, paris))
+
+	day := &entities.CalendarEvent{
+		Start:  time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC),
+		End:    time.Date(2026, 12, 25, 0, 0, 0, 0, time.UTC),
+		AllDay: true,
+	}
+	assert.Equal(t, "Thu, Dec 24, 2026", services.FormatEventTime(day, paris), "dates are not shifted")
+
+	day.End = time.Date(2027, 1, 2, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Thu, Dec 24, 2026 – Fri, Jan 1, 2027", services.FormatEventTime(day, paris))
+}
@@ -0,0 +1,154 @@
+package services_test
+
+import (
+	"context"
+	"palm/src/entities"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCategorizer_Categorize(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	subscribe(bus, services.NewCategorizer(db))
+
+	// The user has written to Babbage's notification address
+	contact := &entities.Contact{SentCount: 1, Emails: []entities.ContactEmail{{Email: "notifications@babbage.example"}}}
+	require.NoError(t, db.Create(contact).Error)
+
+	tests := []struct {
+		name string
+		raw  string
+		want entities.Category
+	}{
+		{
+			name: "personal",
+			raw:  ruleMessage("Charles <charles@engines.example>", "Tea on Sunday?", "", "Would you like to come over for tea?"),
+			want: entities.CategoryPrimary,
+		},
+		{
+			name: "social network",
+			raw: ruleMessage("Facebook <notification@facebookmail.com>", "Charles commented on your post",
+				"List-Unsubscribe: <https://www.facebook.com/unsub>\r\n", "See the comment."),
+			want: entities.CategorySocial,
+		},
+		{
+			name: "social header",
+			raw: ruleMessage("Engines Club <club@engines-club.example>", "New member",
+				"X-LinkedIn-Class: INVITE\r\n", "Someone wants to connect."),
+			want: entities.CategorySocial,
+		},
+		{
+			name: "receipt",
+			raw: ruleMessage("Shop <no-reply@shop.example>", "Your order has shipped", "",
+				"Your order 1234 has shipped. Track the delivery with the tracking number."),
+			want: entities.CategoryUpdates,
+		},
+		{
+			name: "promotion",
+			raw: ruleMessage("Shop <news@shop.example>", "Black Friday: 50% off everything",
+				"List-Unsubscribe: <mailto:leave@shop.example>\r\nPrecedence: bulk\r\n",
+				"Shop now, this deal is for a limited time. Free shipping on every order."),
+			want: entities.CategoryPromotions,
+		},
+		{
+			name: "campaign without phrases",
+			raw: ruleMessage("Shop <news@shop.example>", "Our autumn letter",
+				"X-Mailchimp-Campaign: 42\r\nList-Unsubscribe: <mailto:leave@shop.example>\r\n", "Read about our new workshop."),
+			want: entities.CategoryPromotions,
+		},
+		{
+			name: "discussion list",
+			raw: ruleMessage("Rob <rob@golang.example>", "Sale of the old machines",
+				"List-Id: <golang-nuts.googlegroups.example>\r\nList-Post: <mailto:golang-nuts@googlegroups.example>\r\n",
+				"Any deal on the old machines?"),
+			want: entities.CategoryUpdates,
+		},
+		{
+			name: "automated correspondent",
+			raw: ruleMessage("Babbage <notifications@babbage.example>", "Your payment reminder", "",
+				"A payment is due."),
+			want: entities.CategoryPrimary,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := s.deliver(t, ctx, tt.raw)
+			require.NotNil(t, email)
+			assert.Equal(t, tt.want, email.Message.Category)
+		})
+	}
+
+	// The user's own messages are not categorized
+	email := s.deliver(t, ctx, ruleMessage("Ada Lovelace <ada@engines.example>", "Notes", "", "Notes on the engine."))
+	assert.Empty(t, email.Message.Category)
+}
+
+func TestCategorizer_SetCategory(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	categorizer := subscribe(bus, services.NewCategorizer(db))
+
+	first := s.deliver(t, ctx, ruleMessage("Engine Weekly <news@weekly.example>", "Issue 11",
+		"List-Unsubscribe: <mailto:leave@weekly.example>\r\n", "Up to 30% off the new gears, shop now."))
+	second := s.deliver(t, ctx, ruleMessage("Engine Weekly <news@weekly.example>", "Issue 12",
+		"List-Unsubscribe: <mailto:leave@weekly.example>\r\n", "Exclusive offers on gears."))
+	other := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Tea", "", "See you at four."))
+	require.Equal(t, entities.CategoryPromotions, first.Message.Category)
+	require.Equal(t, entities.CategoryPromotions, second.Message.Category)
+
+	_, err := categorizer.SetCategory(ctx, first.Message.ID, "Newsletters")
+	assert.ErrorIs(t, err, services.ErrInvalidCategory)
+	_, err = categorizer.SetCategory(ctx, 999999, entities.CategoryPrimary)
+	assert.ErrorIs(t, err, services.ErrEmailNotFound)
+
+	// Moving one issue moves the sender's other emails and future ones
+	moved, err := categorizer.SetCategory(ctx, first.Message.ID, entities.CategoryPrimary)
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	third := s.deliver(t, ctx, ruleMessage("Engine Weekly <NEWS@weekly.example>", "Issue 13",
+		"List-Unsubscribe: <mailto:leave@weekly.example>\r\n", "Clearance sale: 70% off."))
+	assert.Equal(t, entities.CategoryPrimary, third.Message.Category)
+
+	overrides, err := categorizer.Overrides(ctx, s.account.ID)
+	require.NoError(t, err)
+	require.Len(t, overrides, 1)
+	assert.Equal(t, "news@weekly.example", overrides[0].SenderEmail)
+	assert.Equal(t, entities.CategoryPrimary, overrides[0].Category)
+
+	// Emails are listed by tab; Primary includes uncategorized emails
+	require.NoError(t, db.Model(&entities.Message{}).Where("id = ?", other.Message.ID).Update("category", "").Error)
+	primary, err := s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{Category: entities.CategoryPrimary},
+	}, 20, 1)
+	require.NoError(t, err)
+	assert.Len(t, primary.Emails, 4)
+	_, err = s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{Category: "Forums"},
+	}, 20, 1)
+	assert.ErrorIs(t, err, services.ErrInvalidFilter)
+
+	// Uncategorized emails are categorized in the background
+	categorized, err := categorizer.CategorizeMissing(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, categorized)
+
+	// Forgetting the override categorizes the sender's emails again
+	moved, err = categorizer.DeleteOverride(ctx, overrides[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, moved)
+	promotions, err := s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{Category: entities.CategoryPromotions},
+	}, 20, 1)
+	require.NoError(t, err)
+	assert.Len(t, promotions.Emails, 3)
+
+	_, err = categorizer.DeleteOverride(ctx, overrides[0].ID)
+	assert.ErrorIs(t, err, services.ErrCategoryOverrideNotFound)
+}
@@ -0,0 +1,321 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/vcard"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// newContactTestServices wires an email service whose created messages are
+// recorded by a contact service
+func newContactTestServices(t *testing.T, db *gorm.DB) (*services.EmailService, *services.ContactService) {
+	bus := events.NewBus()
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	emailService.SetEventBus(bus)
+
+	contactService := services.NewContactService(db, sqlite.NewContactRepository(db))
+	t.Cleanup(contactService.Subscribe(bus))
+	return emailService, contactService
+}
+
+// createCorrespondence stores a message from sender to recipients
+func createCorrespondence(t *testing.T, ctx context.Context, emailService *services.EmailService, accountID uint, sender, senderName string, to ...string) {
+	email := createEmailDTO(accountID, "Hello")
+	email.Message.SenderEmail = sender
+	email.Message.SenderName = &senderName
+	email.Recipients = nil
+	for _, address := range to {
+		email.Recipients = append(email.Recipients, &entities.Recipient{Email: address, RecipientType: entities.RecipientTypeTo})
+	}
+	require.NoError(t, emailService.Create(ctx, email))
+}
+
+func TestContactService_RecordsMessages(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, contactService := newContactTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+
+	// Received from Alice, with Bob in copy
+	createCorrespondence(t, ctx, emailService, account.ID, "Alice@Example.com", `"SMITH, ALICE"`, "me@example.com", "bob@example.com")
+	// Sent by us to Alice twice
+	createCorrespondence(t, ctx, emailService, account.ID, "ME@example.com", "Me", "alice@example.com")
+	createCorrespondence(t, ctx, emailService, account.ID, "me@example.com", "Me", "alice@example.com")
+
+	contacts, err := contactService.ListContacts(ctx)
+	require.NoError(t, err)
+	require.Len(t, contacts, 2, "the account owner is not a contact")
+
+	alice := contacts[0]
+	require.Len(t, alice.Emails, 1)
+	assert.Equal(t, "alice@example.com", alice.Emails[0].Email, "addresses are normalized")
+	assert.Equal(t, "Alice Smith", *alice.DisplayName, "display names are normalized")
+	assert.Equal(t, uint(3), alice.MessageCount)
+	assert.Equal(t, uint(2), alice.SentCount)
+	assert.NotNil(t, alice.LastInteraction)
+
+	bob := contacts[1]
+	assert.Equal(t, "bob@example.com", bob.Emails[0].Email)
+	assert.Nil(t, bob.DisplayName)
+	assert.Equal(t, uint(1), bob.MessageCount)
+	assert.Equal(t, uint(0), bob.SentCount)
+}
+
+func TestContactService_Autocomplete(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, contactService := newContactTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+
+	createCorrespondence(t, ctx, emailService, account.ID, "anna@example.com", "Anna Jones", "me@example.com")
+	createCorrespondence(t, ctx, emailService, account.ID, "anna@example.com", "Anna Jones", "me@example.com")
+	for i := 0; i < 3; i++ {
+		createCorrespondence(t, ctx, emailService, account.ID, "me@example.com", "", "andrew@example.com")
+	}
+	createCorrespondence(t, ctx, emailService, account.ID, "zed@example.com", "Zed Andersen", "me@example.com")
+
+	// Most frequent first; display-name words match too
+	suggestions, err := contactService.Autocomplete(ctx, "An", 10)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 3)
+	assert.Equal(t, "andrew@example.com", suggestions[0].Email)
+	assert.Equal(t, "anna@example.com", suggestions[1].Email)
+	assert.Equal(t, "Anna Jones", suggestions[1].Name)
+	assert.Equal(t, "zed@example.com", suggestions[2].Email)
+
+	// The limit is honoured
+	suggestions, err = contactService.Autocomplete(ctx, "an", 1)
+	require.NoError(t, err)
+	assert.Len(t, suggestions, 1)
+
+	// Wildcards are literal and empty prefixes return nothing
+	suggestions, err = contactService.Autocomplete(ctx, "%", 10)
+	require.NoError(t, err)
+	assert.Empty(t, suggestions)
+	suggestions, err = contactService.Autocomplete(ctx, "  ", 10)
+	require.NoError(t, err)
+	assert.Empty(t, suggestions)
+}
+
+func TestContactService_ManualEditsAndMerge(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, contactService := newContactTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+
+	createCorrespondence(t, ctx, emailService, account.ID, "jane@work.example", "Jane Doe", "me@example.com")
+	createCorrespondence(t, ctx, emailService, account.ID, "jane@home.example", "jane doe", "me@example.com")
+
+	// Two contacts share a display name and are offered as duplicates
+	duplicates, err := contactService.FindDuplicates(ctx)
+	require.NoError(t, err)
+	require.Len(t, duplicates, 1)
+	require.Len(t, duplicates[0], 2)
+
+	work, home := duplicates[0][0], duplicates[0][1]
+	merged, err := contactService.MergeContacts(ctx, work.ID, []uint{home.ID})
+	require.NoError(t, err)
+	assert.Len(t, merged.Emails, 2)
+	assert.Equal(t, uint(2), merged.MessageCount)
+
+	_, err = contactService.GetContact(ctx, home.ID)
+	assert.ErrorIs(t, err, services.ErrContactNotFound)
+
+	// A manual rename survives later messages
+	updated, err := contactService.UpdateContact(ctx, work.ID, "Jane (Finance)", []string{" Jane@Personal.example "})
+	require.NoError(t, err)
+	assert.Len(t, updated.Emails, 3)
+
+	createCorrespondence(t, ctx, emailService, account.ID, "jane@personal.example", "J. Doe", "me@example.com")
+	contact, err := contactService.GetContact(ctx, work.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane (Finance)", *contact.DisplayName)
+	assert.Equal(t, uint(3), contact.MessageCount)
+
+	// An address cannot belong to two contacts
+	other, err := contactService.CreateContact(ctx, "Other", []string{"other@example.com"})
+	require.NoError(t, err)
+	_, err = contactService.UpdateContact(ctx, other.ID, "Other", []string{"jane@work.example"})
+	assert.ErrorIs(t, err, services.ErrEmailAlreadyUsed)
+
+	_, err = contactService.CreateContact(ctx, "Nobody", []string{"not-an-address"})
+	assert.ErrorIs(t, err, services.ErrInvalidContactEmail)
+
+	// Deleting releases the addresses
+	require.NoError(t, contactService.DeleteContact(ctx, other.ID))
+	_, err = contactService.CreateContact(ctx, "Other again", []string{"other@example.com"})
+	assert.NoError(t, err)
+}
+
+func TestContactService_Rebuild(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	// Messages stored without a contact service listening
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+	createCorrespondence(t, ctx, emailService, account.ID, "a@example.com", "A", "me@example.com", "b@example.com")
+
+	contactService := services.NewContactService(db, sqlite.NewContactRepository(db))
+	count, err := contactService.Rebuild(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Rebuilding again is a no-op
+	count, err = contactService.Rebuild(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestNormalizeDisplayName(t *testing.T) {
+	tests := []struct {
+		name, email, want string
+	}{
+		{"  Alice   Smith ", "a@example.com", "Alice Smith"},
+		{`"Bob Jones"`, "b@example.com", "Bob Jones"},
+		{"Smith, John", "j@example.com", "John Smith"},
+		{"JOHN SMITH", "j@example.com", "John Smith"},
+		{"mary o'neil", "m@example.com", "Mary O'neil"},
+		{"McDonald", "m@example.com", "McDonald"},
+		{"a@example.com", "a@example.com", ""},
+		{"someone@else.com", "a@example.com", ""},
+		{"Acme, Inc. Support", "s@example.com", "Acme, Inc. Support"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, services.NormalizeDisplayName(tt.name, tt.email), tt.name)
+	}
+}
+
+// Ensure received times drive recency
+func TestContactService_LastInteraction(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, contactService := newContactTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+
+	newer := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	older := newer.Add(-48 * time.Hour)
+	for _, at := range []time.Time{newer, older} {
+		received := at
+		email := createEmailDTO(account.ID, "Ping")
+		email.Message.SenderEmail = "pat@example.com"
+		email.Message.ReceivedDatetime = &received
+		email.Recipients = []*entities.Recipient{{Email: "me@example.com", RecipientType: entities.RecipientTypeTo}}
+		require.NoError(t, emailService.Create(ctx, email))
+	}
+
+	contacts, err := contactService.ListContacts(ctx)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	assert.True(t, contacts[0].LastInteraction.Equal(newer))
+}
+
+func TestContactService_ImportVCardsMerges(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, contactService := newContactTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+
+	// Two contacts derived from mail that the address book knows are one person
+	createCorrespondence(t, ctx, emailService, account.ID, "ada@work.example", "ada", "me@example.com")
+	createCorrespondence(t, ctx, emailService, account.ID, "ada@home.example", "A. L.", "me@example.com")
+	manual, err := contactService.CreateContact(ctx, "Bob Builder", []string{"bob@example.com"})
+	require.NoError(t, err)
+
+	data := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Ada Lovelace\r\nN:Lovelace;Ada;;;\r\n" +
+		"EMAIL;TYPE=INTERNET:ada@work.example\r\nEMAIL;TYPE=INTERNET:ADA@home.example\r\n" +
+		"EMAIL;TYPE=INTERNET:ada@new.example\r\nTEL;TYPE=CELL:+44 20 7946 0000\r\n" +
+		"ORG:Analytical Engines\r\nPHOTO;ENCODING=b;TYPE=PNG:iVBORw==\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Robert\r\nEMAIL:bob@example.com\r\nTEL:555-0100\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Grace Hopper\r\nEMAIL:grace@navy.example\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Nobody\r\nEND:VCARD\r\n"
+
+	result, err := contactService.ImportVCards(ctx, strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, services.VCardImportResult{Created: 1, Merged: 2, Skipped: 1}, *result)
+
+	contacts, err := contactService.ListContacts(ctx)
+	require.NoError(t, err)
+	require.Len(t, contacts, 3, "Ada's two contacts were merged and Grace was added")
+
+	ada, err := contactService.Autocomplete(ctx, "ada@new", 1)
+	require.NoError(t, err)
+	require.Len(t, ada, 1)
+	contact, err := contactService.GetContact(ctx, ada[0].ContactID)
+	require.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", *contact.DisplayName)
+	assert.True(t, contact.DisplayNameLocked)
+	assert.Len(t, contact.Emails, 3)
+	require.Len(t, contact.Phones, 1)
+	assert.Equal(t, "cell", contact.Phones[0].Type)
+	assert.Equal(t, "Analytical Engines", *contact.Organization)
+	assert.Equal(t, "image/png", *contact.PhotoMediaType)
+	assert.NotEmpty(t, contact.Photo)
+
+	bob, err := contactService.GetContact(ctx, manual.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Bob Builder", *bob.DisplayName, "a name set by hand is kept")
+	require.Len(t, bob.Phones, 1)
+
+	// Importing the same file again changes nothing
+	_, err = contactService.ImportVCards(ctx, strings.NewReader(data))
+	require.NoError(t, err)
+	contact, err = contactService.GetContact(ctx, contact.ID)
+	require.NoError(t, err)
+	assert.Len(t, contact.Emails, 3)
+	assert.Len(t, contact.Phones, 1)
+}
+
+func TestContactService_ExportVCardsRoundTrip(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	_, contactService := newContactTestServices(t, db)
+	created, err := contactService.CreateContact(ctx, "Grace Hopper", []string{"grace@navy.example", "grace@example.com"})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := contactService.ExportVCards(ctx, &buf, nil, vcard.Version4)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Contains(t, buf.String(), "FN:Grace Hopper\r\n")
+
+	_, err = contactService.ExportVCards(ctx, &buf, []uint{created.ID + 100}, vcard.Version4)
+	assert.ErrorIs(t, err, services.ErrContactNotFound)
+
+	// Re-importing after deleting the contact yields the same contact
+	require.NoError(t, contactService.DeleteContact(ctx, created.ID))
+	result, err := contactService.ImportVCards(ctx, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Created)
+
+	contacts, err := contactService.ListContacts(ctx)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	assert.Equal(t, "Grace Hopper", *contacts[0].DisplayName)
+	assert.Equal(t, "grace@navy.example", contacts[0].Emails[0].Email)
+	assert.Len(t, contacts[0].Emails, 2)
+}
@@ -0,0 +1,179 @@
+package services_test
+
+import (
+	"context"
+	"palm/src/entities"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// subjects returns the subjects of a page of emails in order
+func subjects(result *services.PaginatedEmailsResult) []string {
+	out := make([]string, 0, len(result.Emails))
+	for _, email := range result.Emails {
+		out = append(out, *email.Message.Subject)
+	}
+	return out
+}
+
+func TestEmailService_ListWithOptions(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	accountRepo := sqlite.NewAccountRepository(db)
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+
+	account := createTestAccount(t, ctx, accountRepo, "filter-test@example.com")
+	base := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	fixtures := []struct {
+		subject     string
+		sender      string
+		senderName  string
+		read        bool
+		draft       bool
+		importance  entities.Importance
+		attachments []uint
+		body        string
+	}{
+		{"Alpha", "zoe@example.com", "Zoe", true, false, entities.ImportanceNormal, nil, "short"},
+		{"bravo", "adam@example.com", "", false, false, entities.ImportanceHigh, []uint{5000}, "short"},
+		{"Charlie", "mike@corp.example", "Mike", false, true, entities.ImportanceLow, nil, "a much longer body text"},
+		{"Delta", "adam@example.com", "Adam", true, false, entities.ImportanceHigh, []uint{10, 20}, "x"},
+	}
+	for i, f := range fixtures {
+		email := createEmailDTO(account.ID, f.subject)
+		received := base.Add(time.Duration(i) * 24 * time.Hour)
+		senderName := f.senderName
+		body := f.body
+		email.Message.ReceivedDatetime = &received
+		email.Message.SenderEmail = f.sender
+		email.Message.SenderName = &senderName
+		email.Message.IsRead = f.read
+		email.Message.IsDraft = f.draft
+		email.Message.Importance = f.importance
+		email.Message.Body = &body
+		email.Attachments = nil
+		for _, size := range f.attachments {
+			email.Attachments = append(email.Attachments, &entities.Attachment{Filename: "a.bin", MimeType: "application/octet-stream", Size: size})
+		}
+		require.NoError(t, emailService.Create(ctx, email))
+	}
+
+	yes, no := true, false
+	after := base.Add(24 * time.Hour)
+	before := base.Add(3 * 24 * time.Hour)
+
+	tests := []struct {
+		name string
+		opts services.ListOptions
+		want []string
+	}{
+		{"default is newest first", services.ListOptions{}, []string{"Delta", "Charlie", "bravo", "Alpha"}},
+		{"unread only", services.ListOptions{Filter: services.EmailFilter{UnreadOnly: true}}, []string{"Charlie", "bravo"}},
+		{"importance", services.ListOptions{Filter: services.EmailFilter{Importance: entities.ImportanceHigh}}, []string{"Delta", "bravo"}},
+		{"with attachments", services.ListOptions{Filter: services.EmailFilter{HasAttachments: &yes}}, []string{"Delta", "bravo"}},
+		{"without attachments", services.ListOptions{Filter: services.EmailFilter{HasAttachments: &no}}, []string{"Charlie", "Alpha"}},
+		{"sender address", services.ListOptions{Filter: services.EmailFilter{Sender: "CORP.example"}}, []string{"Charlie"}},
+		{"sender name", services.ListOptions{Filter: services.EmailFilter{Sender: "zoe"}}, []string{"Alpha"}},
+		{"date range", services.ListOptions{Filter: services.EmailFilter{ReceivedAfter: &after, ReceivedBefore: &before}}, []string{"Charlie", "bravo"}},
+		{"only drafts", services.ListOptions{Filter: services.EmailFilter{Drafts: &yes}}, []string{"Charlie"}},
+		{"no drafts", services.ListOptions{Filter: services.EmailFilter{Drafts: &no}}, []string{"Delta", "bravo", "Alpha"}},
+		{"combined", services.ListOptions{Filter: services.EmailFilter{UnreadOnly: true, Drafts: &no}}, []string{"bravo"}},
+		{"oldest first", services.ListOptions{Sort: services.EmailSort{Field: services.SortByDate, Order: services.SortAscending}}, []string{"Alpha", "bravo", "Charlie", "Delta"}},
+		// Sender sorts by display name, falling back to the address
+		{"sender", services.ListOptions{Sort: services.EmailSort{Field: services.SortBySender}}, []string{"Delta", "bravo", "Charlie", "Alpha"}},
+		{"subject ignores case", services.ListOptions{Sort: services.EmailSort{Field: services.SortBySubject}}, []string{"Alpha", "bravo", "Charlie", "Delta"}},
+		{"subject descending", services.ListOptions{Sort: services.EmailSort{Field: services.SortBySubject, Order: services.SortDescending}}, []string{"Delta", "Charlie", "bravo", "Alpha"}},
+		// Size counts the body and the attachments, largest first by default
+		{"size", services.ListOptions{Sort: services.EmailSort{Field: services.SortBySize}}, []string{"bravo", "Delta", "Charlie", "Alpha"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := emailService.ListWithOptions(ctx, account.ID, tt.opts, 10, 1)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, subjects(result))
+			assert.Equal(t, int64(len(tt.want)), result.TotalCount)
+		})
+	}
+}
+
+func TestEmailService_ListWithOptions_SenderScopedToAccount(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	accountRepo := sqlite.NewAccountRepository(db)
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+
+	mine := createTestAccount(t, ctx, accountRepo, "mine@example.com")
+	other := createTestAccount(t, ctx, accountRepo, "other@example.com")
+	for _, f := range []struct {
+		accountID uint
+		subject   string
+		name      string
+	}{
+		{mine.ID, "Mine", "Bob"},
+		{other.ID, "Other by name", "Bob"},
+		{other.ID, "Other by address", ""},
+	} {
+		email := createEmailDTO(f.accountID, f.subject)
+		name := f.name
+		email.Message.SenderEmail = "bob@example.com"
+		email.Message.SenderName = &name
+		require.NoError(t, emailService.Create(ctx, email))
+	}
+
+	// Matching either the name or the address never reaches another account
+	result, err := emailService.ListWithOptions(ctx, mine.ID, services.ListOptions{
+		Filter: services.EmailFilter{Sender: "bob"},
+	}, 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Mine"}, subjects(result))
+	assert.Equal(t, int64(1), result.TotalCount)
+}
+
+func TestEmailService_ListWithOptions_Validation(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+
+	_, err := emailService.ListWithOptions(ctx, 1, services.ListOptions{
+		Filter: services.EmailFilter{Importance: "Urgent"},
+	}, 10, 1)
+	assert.ErrorIs(t, err, services.ErrInvalidFilter)
+
+	_, err = emailService.ListWithOptions(ctx, 1, services.ListOptions{
+		Filter: services.EmailFilter{ReceivedAfter: &now, ReceivedBefore: &earlier},
+	}, 10, 1)
+	assert.ErrorIs(t, err, services.ErrInvalidFilter)
+
+	_, err = emailService.ListWithOptions(ctx, 1, services.ListOptions{
+		Sort: services.EmailSort{Field: "id; DROP TABLE messages"},
+	}, 10, 1)
+	assert.ErrorIs(t, err, services.ErrInvalidSort)
+
+	_, err = emailService.ListWithOptions(ctx, 1, services.ListOptions{
+		Sort: services.EmailSort{Field: services.SortBySubject, Order: "sideways"},
+	}, 10, 1)
+	assert.ErrorIs(t, err, services.ErrInvalidSort)
+}
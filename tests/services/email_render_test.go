@@ -0,0 +1,66 @@
+package services_test
+
+import (
+	"context"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newsletter is an HTML message with an inline image and a script
+const newsletter = "From: News <news@example.com>\r\n" +
+	"To: bob@example.org\r\n" +
+	"Subject: Newsletter\r\n" +
+	"Message-ID: <news-1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/related; boundary=\"rel\"\r\n" +
+	"\r\n" +
+	"--rel\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<html><head><style>.title { color: red }</style></head>" +
+	"<body><h1 class=\"title\">Hello</h1><img src=\"cid:logo@example.com\">" +
+	"<script>alert(1)</script><p onclick=\"x()\">Read <a href=\"javascript:x()\">more</a></p></body></html>\r\n" +
+	"--rel\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@example.com>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--rel--\r\n"
+
+func TestRenderBody(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, archiveService := newArchiveTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "bob@example.org")
+
+	imported, err := archiveService.ImportEML(ctx, account.ID, strings.NewReader(newsletter))
+	require.NoError(t, err)
+	email, err := emailService.GetByID(ctx, imported.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, email.Attachments, 1)
+	require.NotNil(t, email.Attachments[0].ContentID)
+	assert.Equal(t, "logo@example.com", *email.Attachments[0].ContentID)
+
+	body := services.RenderBody(email, services.RenderOptions{})
+	assert.Equal(t, `<div class="palm-message">`+
+		`<style>.palm-message .palm-message-title{color:red}</style>`+
+		`<h1 class="palm-message-title">Hello</h1>`+
+		`<img src="`+services.AttachmentURL(email.Attachments[0].ID)+`"/>`+
+		`<p>Read <a>more</a></p>`+
+		`</div>`, body.HTML)
+	assert.Equal(t, "Hello\n\nRead more", body.Text)
+
+	id, ok := services.ParseAttachmentURL(services.AttachmentURL(42))
+	assert.True(t, ok)
+	assert.Equal(t, uint(42), id)
+	_, ok = services.ParseAttachmentURL("/palm/attachments/../palm.sqlite")
+	assert.False(t, ok)
+}
@@ -2,6 +2,7 @@ package services_test
 
 import (
 	"context"
+	"fmt"
 	"palm/src/entities"
 	"palm/src/repositories"
 	"palm/src/repositories/sqlite"
@@ -291,6 +292,124 @@ func TestEmailService_List(t *testing.T) {
 	assert.Equal(t, int64(0), result.TotalCount)
 }
 
+// TestEmailService_Search tests the Search method
+func TestEmailService_Search(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	messageRepo := sqlite.NewMessageRepository(db)
+	recipientRepo := sqlite.NewRecipientRepository(db)
+	attachmentRepo := sqlite.NewAttachmentRepository(db)
+	accountRepo := sqlite.NewAccountRepository(db)
+
+	emailService := services.NewEmailService(db, messageRepo, recipientRepo, attachmentRepo)
+
+	account := createTestAccount(t, ctx, accountRepo, "search-test@example.com")
+	other := createTestAccount(t, ctx, accountRepo, "search-other@example.com")
+
+	require.NoError(t, emailService.Create(ctx, createEmailDTO(account.ID, "Quarterly Report")))
+	require.NoError(t, emailService.Create(ctx, createEmailDTO(account.ID, "Lunch plans")))
+	require.NoError(t, emailService.Create(ctx, createEmailDTO(account.ID, "100% discount_code")))
+	require.NoError(t, emailService.Create(ctx, createEmailDTO(other.ID, "Quarterly Report")))
+
+	// Subject match is case-insensitive and scoped to the account
+	result, err := emailService.Search(ctx, account.ID, "quarterly", 10, 1)
+	require.NoError(t, err)
+	require.Len(t, result.Emails, 1)
+	assert.Equal(t, "Quarterly Report", *result.Emails[0].Message.Subject)
+	assert.Equal(t, int64(1), result.TotalCount)
+
+	// Recipients are searched too
+	result, err = emailService.Search(ctx, account.ID, "recipient1@", 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result.TotalCount)
+
+	// LIKE wildcards in the query match literally
+	result, err = emailService.Search(ctx, account.ID, "0% discount_", 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.TotalCount)
+	result, err = emailService.Search(ctx, account.ID, "%", 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.TotalCount)
+
+	// Invalid page size
+	_, err = emailService.Search(ctx, account.ID, "report", 101, 1)
+	assert.ErrorIs(t, err, services.ErrInvalidPageSize)
+}
+
+// TestEmailService_ListUnified tests merging emails across accounts
+func TestEmailService_ListUnified(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	messageRepo := sqlite.NewMessageRepository(db)
+	recipientRepo := sqlite.NewRecipientRepository(db)
+	attachmentRepo := sqlite.NewAttachmentRepository(db)
+	accountRepo := sqlite.NewAccountRepository(db)
+
+	emailService := services.NewEmailService(db, messageRepo, recipientRepo, attachmentRepo)
+
+	work := createTestAccount(t, ctx, accountRepo, "work@example.com")
+	home := createTestAccount(t, ctx, accountRepo, "home@example.com")
+	other := createTestAccount(t, ctx, accountRepo, "other@example.com")
+
+	// Interleave received times across accounts
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, accountID := range []uint{work.ID, home.ID, work.ID, other.ID, home.ID} {
+		email := createEmailDTO(accountID, fmt.Sprintf("Email %d", i))
+		received := base.Add(time.Duration(i) * time.Hour)
+		email.Message.ReceivedDatetime = &received
+		email.Message.IsRead = accountID == work.ID
+		require.NoError(t, emailService.Create(ctx, email))
+	}
+
+	// All accounts, newest first across accounts
+	result, err := emailService.ListUnified(ctx, nil, 3, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), result.TotalCount)
+	assert.Equal(t, 2, result.TotalPages)
+	require.Len(t, result.Emails, 3)
+	assert.Equal(t, "Email 4", *result.Emails[0].Message.Subject)
+	assert.Equal(t, "Email 3", *result.Emails[1].Message.Subject)
+	assert.Equal(t, "Email 2", *result.Emails[2].Message.Subject)
+
+	// Account info is loaded on each message
+	assert.Equal(t, "home@example.com", result.Emails[0].Message.Account.Email)
+	assert.Equal(t, entities.AccountTypeGoogle, result.Emails[0].Message.Account.AccountType)
+
+	result, err = emailService.ListUnified(ctx, nil, 3, 2)
+	require.NoError(t, err)
+	require.Len(t, result.Emails, 2)
+	assert.Equal(t, "Email 1", *result.Emails[0].Message.Subject)
+	assert.Equal(t, "Email 0", *result.Emails[1].Message.Subject)
+
+	// A subset of accounts
+	result, err = emailService.ListUnified(ctx, []uint{work.ID, other.ID}, 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result.TotalCount)
+	for _, email := range result.Emails {
+		assert.NotEqual(t, home.ID, email.Message.AccountID)
+	}
+
+	// Unread counts per account, including accounts without unread mail
+	counts, err := emailService.UnreadCounts(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, counts, 3)
+	assert.Equal(t, work.ID, counts[0].AccountID)
+	assert.Equal(t, int64(0), counts[0].Unread)
+	assert.Equal(t, "home@example.com", counts[1].Email)
+	assert.Equal(t, int64(2), counts[1].Unread)
+	assert.Equal(t, int64(1), counts[2].Unread)
+
+	counts, err = emailService.UnreadCounts(ctx, []uint{home.ID})
+	require.NoError(t, err)
+	require.Len(t, counts, 1)
+	assert.Equal(t, int64(2), counts[0].Unread)
+
+	_, err = emailService.ListUnified(ctx, nil, 0, 1)
+	assert.ErrorIs(t, err, services.ErrInvalidPageSize)
+}
+
 // TestEmailService_Delete tests the Delete method
 func TestEmailService_Delete(t *testing.T) {
 	db := utils.SetupTestDB(t)
@@ -0,0 +1,149 @@
+package services_test
+
+import (
+	"context"
+	"net/mail"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replyMessage returns a message to the account dated now, answering the
+// message with ID inReplyTo
+func replyMessage(from, subject, inReplyTo string) string {
+	return "From: " + from + "\r\n" +
+		"To: Ada Lovelace <ada@engines.example>\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Add(time.Minute).Format(time.RFC1123Z) + "\r\n" +
+		"In-Reply-To: <" + inReplyTo + ">\r\n" +
+		"References: <" + inReplyTo + ">\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Sounds good.\r\n"
+}
+
+func TestFollowUpService_RemindAndReply(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	followUps := subscribe(bus, services.NewFollowUpService(db))
+	var reminders []events.FollowUpPayload
+	bus.Subscribe(events.TopicFollowUpDue, func(e events.Event) {
+		reminders = append(reminders, e.Payload.(events.FollowUpPayload))
+	})
+
+	send := func(subject string) *services.EmailDTO {
+		result, err := s.send.Send(ctx, &services.OutgoingEmail{
+			AccountID: s.account.ID,
+			To:        []*mail.Address{{Name: "Charles", Address: "Charles@engines.example"}},
+			Subject:   subject,
+			Text:      "Shall we meet on Sunday?",
+		})
+		require.NoError(t, err)
+		sent, err := s.email.GetByID(ctx, result.MessageID)
+		require.NoError(t, err)
+		return sent
+	}
+
+	tea := send("Tea")
+	_, err := followUps.FollowUp(ctx, tea.Message.ID, 0)
+	assert.ErrorIs(t, err, services.ErrInvalidFollowUpDelay)
+	_, err = followUps.FollowUp(ctx, 999999, time.Hour)
+	assert.ErrorIs(t, err, services.ErrEmailNotFound)
+	incoming := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Cake", "", "Bring cake."))
+	_, err = followUps.FollowUp(ctx, incoming.Message.ID, time.Hour)
+	assert.ErrorIs(t, err, services.ErrFollowUpIncoming)
+
+	followUp, err := followUps.FollowUp(ctx, tea.Message.ID, 72*time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, tea.Message.SentDatetime.Add(72*time.Hour), followUp.RemindAt, time.Second)
+	require.NoError(t, db.Model(&entities.Message{}).Where("id = ?", tea.Message.ID).Updates(map[string]interface{}{
+		"is_read": true,
+		"folder":  "Archive",
+	}).Error)
+
+	// Someone other than the recipients writing in the thread is no reply
+	s.deliver(t, ctx, replyMessage("Mary <mary@engines.example>", "Re: Tea", *tea.Message.InternetMessageID))
+	list, err := followUps.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "Tea", *list[0].Message.Subject)
+
+	// Nothing is due yet; then the email returns unread, with a reminder
+	now := time.Now()
+	n, err := followUps.RemindDue(ctx, now)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	n, err = followUps.RemindDue(ctx, now.Add(73*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	stored, err := s.email.GetByID(ctx, tea.Message.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.Message.IsRead)
+	assert.Empty(t, stored.Message.Folder)
+	assert.Equal(t, []events.FollowUpPayload{{
+		MessageID:  tea.Message.ID,
+		AccountID:  s.account.ID,
+		Subject:    "Tea",
+		Recipients: "charles@engines.example",
+	}}, reminders)
+	list, err = followUps.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	// A recipient's reply drops the reminder
+	cake := send("Cake")
+	_, err = followUps.FollowUp(ctx, cake.Message.ID, 72*time.Hour)
+	require.NoError(t, err)
+	s.deliver(t, ctx, replyMessage("Charles <charles@engines.example>", "Re: Cake", *cake.Message.InternetMessageID))
+	list, err = followUps.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+	assert.ErrorIs(t, followUps.Cancel(ctx, cake.Message.ID), services.ErrNoFollowUp)
+
+	// Answered emails cannot be followed up
+	_, err = followUps.FollowUp(ctx, cake.Message.ID, 72*time.Hour)
+	assert.ErrorIs(t, err, services.ErrFollowUpReplied)
+	n, err = followUps.RemindDue(ctx, now.Add(100*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Len(t, reminders, 1)
+}
+
+func TestFollowUpService_ScheduledSend(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	followUps := subscribe(bus, services.NewFollowUpService(db))
+	scheduler := services.NewSendScheduler(db, s.send, followUps)
+
+	_, err := scheduler.Schedule(ctx, &services.OutgoingEmail{
+		AccountID: s.account.ID,
+		To:        []*mail.Address{{Address: "charles@engines.example"}},
+		Subject:   "Tea",
+		Text:      "Shall we meet on Sunday?",
+	}, time.Time{}, 0, 48*time.Hour)
+	require.NoError(t, err)
+	n, err := scheduler.ProcessDue(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// The reminder is set on the sent copy once the message went out
+	list, err := followUps.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "Tea", *list[0].Message.Subject)
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), list[0].RemindAt, 5*time.Second)
+
+	// Deleting the sent copy drops its reminder
+	require.NoError(t, s.email.Delete(ctx, int64(list[0].MessageID)))
+	list, err = followUps.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
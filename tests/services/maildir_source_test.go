@@ -0,0 +1,237 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/maildir"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// maildirTest wires a Local Maildir account to its sync services
+type maildirTest struct {
+	db           *gorm.DB
+	dir          maildir.Dir
+	account      *entities.Account
+	emailService *services.EmailService
+	syncService  *services.SyncService
+	source       *services.MaildirSource
+	bus          *events.Bus
+}
+
+func newMaildirTest(t *testing.T) *maildirTest {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	dir, err := maildir.Create(t.TempDir())
+	require.NoError(t, err)
+
+	accountRepo := sqlite.NewAccountRepository(db)
+	account, err := services.NewAccountService(accountRepo).CreateMaildirAccount(ctx, "me@example.org", string(dir))
+	require.NoError(t, err)
+
+	bus := events.NewBus()
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	emailService.SetEventBus(bus)
+	syncService := services.NewSyncService(accountRepo, emailService)
+	source := services.NewMaildirSource(db, emailService, services.NewAttachmentStore(t.TempDir()))
+	source.SetEventBus(bus)
+	syncService.RegisterSource(entities.AccountTypeMaildir, source)
+
+	return &maildirTest{
+		db:           db,
+		dir:          dir,
+		account:      account,
+		emailService: emailService,
+		syncService:  syncService,
+		source:       source,
+		bus:          bus,
+	}
+}
+
+// deliver adds a message with the given subject and Message-ID to a folder
+func (m *maildirTest) deliver(t *testing.T, folder, subject, messageID string, flags maildir.Flags) *maildir.Entry {
+	raw := fmt.Sprintf("From: Alice <alice@example.com>\nTo: me@example.org\nSubject: %s\nMessage-ID: <%s>\nDate: Mon, 1 Jan 2024 09:00:00 +0000\n\nHello\n", subject, messageID)
+	entry, err := m.dir.Folder(folder).Deliver([]byte(raw), flags)
+	require.NoError(t, err)
+	return entry
+}
+
+func (m *maildirTest) sync(t *testing.T) *services.SyncResult {
+	result, err := m.syncService.SyncAccount(context.Background(), m.account.ID)
+	require.NoError(t, err)
+	return result
+}
+
+// message loads the stored message with the given subject, or nil
+func (m *maildirTest) message(t *testing.T, subject string) *entities.Message {
+	var message entities.Message
+	err := m.db.Where("account_id = ? AND subject = ?", m.account.ID, subject).First(&message).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	require.NoError(t, err)
+	return &message
+}
+
+func TestAccountService_CreateMaildirAccount(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	accountService := services.NewAccountService(sqlite.NewAccountRepository(db))
+
+	_, err := accountService.CreateMaildirAccount(ctx, "me@example.org", t.TempDir())
+	assert.ErrorIs(t, err, maildir.ErrNotMaildir)
+
+	_, err = accountService.CreateAccount(ctx, "me@example.org", entities.AccountTypeMaildir)
+	assert.ErrorIs(t, err, services.ErrNoMaildirPath, "a maildir account needs its directory")
+}
+
+func TestMaildirSource_Sync(t *testing.T) {
+	m := newMaildirTest(t)
+
+	m.deliver(t, "", "Unseen", "unseen@example.com", "")
+	read := m.deliver(t, "", "Read and flagged", "read@example.com", "FS")
+	m.deliver(t, "", "Draft", "draft@example.com", "DS")
+	_, err := maildir.Create(filepath.Join(string(m.dir), ".Archive"))
+	require.NoError(t, err)
+	m.deliver(t, ".Archive", "Archived", "archived@example.com", "S")
+
+	result := m.sync(t)
+	assert.Equal(t, 4, result.Created, "messages in subfolders are synced too")
+
+	unseen := m.message(t, "Unseen")
+	require.NotNil(t, unseen)
+	assert.False(t, unseen.IsRead)
+	assert.Equal(t, "alice@example.com", unseen.SenderEmail)
+
+	flagged := m.message(t, "Read and flagged")
+	require.NotNil(t, flagged)
+	assert.True(t, flagged.IsRead)
+	assert.True(t, flagged.IsFlagged)
+	assert.Equal(t, read.Key, *flagged.SourceKey)
+
+	draft := m.message(t, "Draft")
+	require.NotNil(t, draft)
+	assert.True(t, draft.IsDraft)
+
+	archived := m.message(t, "Archived")
+	require.NotNil(t, archived)
+	assert.Equal(t, ".Archive/", (*archived.SourceKey)[:9])
+
+	// Nothing changed, so nothing is created again
+	assert.Equal(t, 0, m.sync(t).Created)
+
+	// Another program marks a message read and deletes one
+	var updated []events.MessagePayload
+	m.bus.Subscribe(events.TopicMessageUpdated, func(e events.Event) {
+		updated = append(updated, e.Payload.(events.MessagePayload))
+	})
+	unseenEntry, err := m.dir.Lookup(*unseen.SourceKey)
+	require.NoError(t, err)
+	_, err = m.dir.SetFlags(unseenEntry.Key, "S")
+	require.NoError(t, err)
+	require.NoError(t, m.dir.Remove(*draft.SourceKey))
+
+	m.sync(t)
+	assert.True(t, m.message(t, "Unseen").IsRead)
+	assert.Nil(t, m.message(t, "Draft"), "messages whose file is gone are deleted")
+	require.Len(t, updated, 1)
+	assert.Equal(t, unseen.ID, updated[0].MessageID)
+
+	// A message moved to another folder keeps its identity
+	data, err := os.ReadFile(read.Path)
+	require.NoError(t, err)
+	require.NoError(t, m.dir.Remove(read.Key))
+	_, err = m.dir.Folder(".Archive").Deliver(data, "S")
+	require.NoError(t, err)
+
+	result = m.sync(t)
+	assert.Equal(t, 0, result.Created)
+	moved := m.message(t, "Read and flagged")
+	require.NotNil(t, moved)
+	assert.Equal(t, flagged.ID, moved.ID)
+	assert.Equal(t, ".Archive/", (*moved.SourceKey)[:9])
+	assert.False(t, moved.IsFlagged, "the moved file's flags apply")
+}
+
+func TestMaildirSource_DeletedMessagesStayDeleted(t *testing.T) {
+	m := newMaildirTest(t)
+	ctx := context.Background()
+
+	m.deliver(t, "", "Unwanted", "unwanted@example.com", "S")
+	m.sync(t)
+	message := m.message(t, "Unwanted")
+	require.NotNil(t, message)
+
+	require.NoError(t, m.emailService.Delete(ctx, int64(message.ID)))
+	assert.Equal(t, 0, m.sync(t).Created, "a message deleted in Palm is not brought back from its file")
+}
+
+func TestMaildirSource_WriteFlags(t *testing.T) {
+	m := newMaildirTest(t)
+	ctx := context.Background()
+	m.source.Subscribe(m.bus)
+
+	entry := m.deliver(t, "", "Answered", "answered@example.com", "R")
+	m.sync(t)
+	message := m.message(t, "Answered")
+	require.NotNil(t, message)
+
+	// Marking the message read in Palm renames its file
+	messageService := services.NewMessageService(sqlite.NewMessageRepository(m.db))
+	messageService.SetEventBus(m.bus)
+	message.IsRead = true
+	message.IsFlagged = true
+	require.NoError(t, messageService.UpdateMessage(ctx, message))
+
+	found, err := m.dir.Lookup(entry.Key)
+	require.NoError(t, err)
+	assert.Equal(t, maildir.Flags("FRS"), found.Flags, "flags Palm does not track are kept")
+
+	// Flags written back do not count as a change on the next sync
+	assert.Equal(t, 0, m.sync(t).Created)
+	assert.True(t, m.message(t, "Answered").IsRead)
+}
+
+func TestMaildirWatcher_Poll(t *testing.T) {
+	m := newMaildirTest(t)
+	ctx := context.Background()
+
+	// Accounts of other types are not watched
+	createTestAccount(t, ctx, sqlite.NewAccountRepository(m.db), "remote@example.com")
+
+	watcher := services.NewMaildirWatcher(sqlite.NewAccountRepository(m.db), m.syncService, time.Hour)
+	m.deliver(t, "", "First", "first@example.com", "")
+
+	results, err := watcher.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, results, 1, "maildir accounts are synced on the first poll")
+	assert.Equal(t, 1, results[0].Created)
+
+	results, err = watcher.Poll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, results, "an unchanged maildir is not synced")
+
+	// Directory modification times have a coarse resolution on some filesystems
+	time.Sleep(10 * time.Millisecond)
+	m.deliver(t, "", "Second", "second@example.com", "")
+	results, err = watcher.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Created)
+	assert.NotNil(t, m.message(t, "Second"))
+}
@@ -0,0 +1,33 @@
+package services_test
+
+import (
+	"context"
+	"palm/src/entities"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceService_Check(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	maintenanceService := services.NewMaintenanceService(db)
+
+	require.NoError(t, maintenanceService.Migrate(ctx))
+
+	result, err := maintenanceService.Check(ctx)
+	require.NoError(t, err)
+	assert.True(t, result.OK)
+	assert.Empty(t, result.MissingTables)
+
+	// A dropped table is reported
+	require.NoError(t, db.Migrator().DropTable(&entities.Attachment{}))
+	result, err = maintenanceService.Check(ctx)
+	require.NoError(t, err)
+	assert.False(t, result.OK)
+	assert.Contains(t, result.MissingTables, "attachments")
+}
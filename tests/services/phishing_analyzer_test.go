@@ -0,0 +1,190 @@
+package services_test
+
+import (
+	"context"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/idna"
+	"gorm.io/gorm"
+)
+
+// newPhishingTestServices wires an email service whose created messages
+// are recorded as contacts, then analyzed
+func newPhishingTestServices(t *testing.T, db *gorm.DB) (*services.EmailService, *services.PhishingAnalyzer) {
+	bus := events.NewBus()
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	emailService.SetEventBus(bus)
+
+	contactService := services.NewContactService(db, sqlite.NewContactRepository(db))
+	t.Cleanup(contactService.Subscribe(bus))
+
+	analyzer := services.NewPhishingAnalyzer(db)
+	t.Cleanup(analyzer.Subscribe(bus))
+	return emailService, analyzer
+}
+
+// suspiciousEmail returns a message from sender without attachments
+func suspiciousEmail(accountID uint, sender, senderName, body string) *services.EmailDTO {
+	email := createEmailDTO(accountID, "Urgent")
+	email.Message.SenderEmail = sender
+	email.Message.SenderName = &senderName
+	email.Message.Body = &body
+	email.Attachments = nil
+	return email
+}
+
+// riskCodes returns the reason codes of an analyzed email
+func riskCodes(t *testing.T, email *services.EmailDTO) []string {
+	require.NotNil(t, email.Risk, "created messages are analyzed")
+	codes := []string{}
+	for _, reason := range email.Risk.Reasons {
+		codes = append(codes, reason.Code)
+	}
+	return codes
+}
+
+func TestPhishingAnalyzer_Analyze(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, _ := newPhishingTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.com")
+
+	// We correspond with Alice at her company
+	createCorrespondence(t, ctx, emailService, account.ID, "me@example.com", "Me", "alice@partner-corp.com")
+	createCorrespondence(t, ctx, emailService, account.ID, "alice@partner-corp.com", "Alice Smith", "me@example.com")
+
+	cyrillicPaypal, err := idna.ToASCII("pаypal.com")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		email  *services.EmailDTO
+		codes  []string
+		levels []string
+	}{
+		{
+			name:   "messages from contacts are not suspicious",
+			email:  suspiciousEmail(account.ID, "alice@partner-corp.com", "Alice Smith", `<a href="https://www.partner-corp.com/q3">www.partner-corp.com/q3</a>`),
+			codes:  []string{},
+			levels: []string{services.RiskLevelNone},
+		},
+		{
+			name:   "a contact's name from another address",
+			email:  suspiciousEmail(account.ID, "alice.smith.ceo@gmail.com", "Alice Smith", "Please buy gift cards"),
+			codes:  []string{entities.RiskDisplayNameSpoofing},
+			levels: []string{services.RiskLevelMedium},
+		},
+		{
+			name:   "a name showing another address",
+			email:  suspiciousEmail(account.ID, "x@mailer.example.net", "security@paypal.com", "Verify your account"),
+			codes:  []string{entities.RiskDisplayNameSpoofing},
+			levels: []string{services.RiskLevelMedium},
+		},
+		{
+			name:   "a domain imitating a correspondent's",
+			email:  suspiciousEmail(account.ID, "alice@partner-c0rp.com", "Accounts", "New bank details"),
+			codes:  []string{entities.RiskLookalikeDomain},
+			levels: []string{services.RiskLevelMedium},
+		},
+		{
+			name:   "a punycode domain imitating a brand",
+			email:  suspiciousEmail(account.ID, "service@"+cyrillicPaypal, "PayPal", "Your account is limited"),
+			codes:  []string{entities.RiskPunycodeDomain, entities.RiskLookalikeDomain},
+			levels: []string{services.RiskLevelHigh},
+		},
+		{
+			name: "links showing another site than they lead to",
+			email: suspiciousEmail(account.ID, "news@shop.example.org", "Shop",
+				`<a href="https://login.evil.example.net/">https://www.paypal.com/signin</a>`+
+					`<a href="https://paypal.com.account-check.example.net/">Sign in</a>`+
+					`<a href="https://shop.example.org/manual.pdf">manual.pdf</a>`+
+					`<a href="https://shop.example.org/sale">www.shop.example.org</a>`),
+			codes:  []string{entities.RiskLinkMismatch, entities.RiskLookalikeDomain},
+			levels: []string{services.RiskLevelHigh},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, emailService.Create(ctx, tt.email))
+			email, err := emailService.GetByID(ctx, tt.email.Message.ID)
+			require.NoError(t, err)
+
+			assert.ElementsMatch(t, tt.codes, riskCodes(t, email))
+			assert.Contains(t, tt.levels, services.RiskLevel(email.Risk.Score))
+		})
+	}
+}
+
+func TestPhishingAnalyzer_AttachmentsAndAuthentication(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, analyzer := newPhishingTestServices(t, db)
+	accountRepo := sqlite.NewAccountRepository(db)
+	account := createTestAccount(t, ctx, accountRepo, "me@example.com")
+	_, err := services.NewAccountService(accountRepo).SetTrustedAuthServIDs(ctx, account.ID, []string{"mx.example.com"})
+	require.NoError(t, err)
+
+	email := suspiciousEmail(account.ID, "billing@vendor.example.org", "Billing", "See the invoice")
+	email.Attachments = []*entities.Attachment{
+		{Filename: "invoice.pdf.exe", MimeType: "application/octet-stream", Size: 10},
+		{Filename: "setup.msi", MimeType: "application/octet-stream", Size: 10},
+		{Filename: "payment.html", MimeType: "text/html", Size: 10},
+		{Filename: "report.pdf", MimeType: "application/pdf", Size: 10},
+	}
+	email.Raw = []byte("Authentication-Results: mx.example.com;\r\n" +
+		"\tspf=softfail smtp.mailfrom=vendor.example.org;\r\n" +
+		"\tdkim=fail (bad signature) header.d=vendor.example.org;\r\n" +
+		"\tdmarc=fail header.from=vendor.example.org\r\n" +
+		"Authentication-Results: relay.example.org; dmarc=pass\r\n" +
+		"From: Billing <billing@vendor.example.org>\r\n" +
+		"Subject: Urgent\r\n" +
+		"\r\n" +
+		"See the invoice\r\n")
+	require.NoError(t, emailService.Create(ctx, email))
+
+	got, err := emailService.GetByID(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 100, got.Risk.Score, "scores are capped")
+
+	details := map[string][]string{}
+	for _, reason := range got.Risk.Reasons {
+		details[reason.Code] = append(details[reason.Code], reason.Detail)
+	}
+	assert.Equal(t, []string{
+		`The attachment "invoice.pdf.exe" is a program disguised as a .pdf file`,
+		`The attachment "setup.msi" is a program`,
+		`The attachment "payment.html" can contain scripts or macros`,
+	}, details[entities.RiskDangerousAttachment])
+	assert.Len(t, details[entities.RiskAuthentication], 3,
+		"the topmost Authentication-Results field is the one added on delivery")
+
+	// Deleting the message deletes its analysis
+	require.NoError(t, emailService.Delete(ctx, int64(email.Message.ID)))
+	var count int64
+	require.NoError(t, db.Model(&entities.MessageRisk{}).Where("message_id = ?", email.Message.ID).Count(&count).Error)
+	assert.Zero(t, count)
+
+	// Messages stored before the analyzer existed are analyzed later
+	createCorrespondence(t, ctx, emailService, account.ID, "a@example.org", "A", "me@example.com")
+	createCorrespondence(t, ctx, emailService, account.ID, "b@example.org", "B", "me@example.com")
+	require.NoError(t, db.Where("1 = 1").Delete(&entities.MessageRisk{}).Error)
+
+	analyzed, err := analyzer.AnalyzeMissing(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, analyzed)
+	analyzed, err = analyzer.AnalyzeMissing(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, analyzed)
+}
@@ -0,0 +1,169 @@
+package services_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"palm/src/entities"
+	"palm/src/services"
+	"palm/tests/utils"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// marketing is a body with a remote image, a tracking pixel and tracked links
+const marketing = `<p><img src="https://cdn.shop.example/banner.png" alt="Sale"></p>` +
+	`<img src="https://shop.example/o.gif?u=123" width="1" height="1">` +
+	`<img src="https://mailtrack.io/trace/mail/abc.png">` +
+	`<img src="https://cdn.shop.example/spacer.png" style="display: none">` +
+	`<a href="https://shop.example/sale?utm_source=mail&utm_campaign=spring&id=7">Shop</a>` +
+	`<a href="https://click.shop.example/ls/click?upn=xyz">Track me</a>`
+
+func marketingEmail(sender string) *services.EmailDTO {
+	body := marketing
+	return &services.EmailDTO{Message: &entities.Message{SenderEmail: sender, Body: &body}}
+}
+
+func TestRenderBody_RemoteContent(t *testing.T) {
+	proxy := services.NewImageProxy(t.TempDir(), nil)
+	email := marketingEmail("news@shop.example")
+
+	blocked := services.RenderBody(email, services.RenderOptions{Proxy: proxy})
+	assert.Equal(t, services.RemoteContent{Blocked: 1, TrackingPixels: 3, TrackedLinks: 1}, blocked.Remote)
+	assert.NotContains(t, blocked.HTML, "https://cdn.shop.example", "remote images are blocked by default")
+	assert.Contains(t, blocked.HTML, `href="https://shop.example/sale?id=7"`, "tracking parameters are removed from links")
+
+	allowed := services.RenderBody(email, services.RenderOptions{AllowRemote: true, Proxy: proxy})
+	assert.Equal(t, services.RemoteContent{TrackingPixels: 3, TrackedLinks: 1, Allowed: true}, allowed.Remote)
+	assert.Contains(t, allowed.HTML, `src="`+strings.ReplaceAll(proxy.URL("https://cdn.shop.example/banner.png"), "&", "&amp;")+`"`,
+		"allowed images load through the proxy")
+	assert.NotContains(t, allowed.HTML, "o.gif", "tracking pixels are never loaded")
+	assert.NotContains(t, allowed.HTML, "mailtrack.io")
+
+	withoutProxy := services.RenderBody(email, services.RenderOptions{AllowRemote: true})
+	assert.False(t, withoutProxy.Remote.Allowed, "images are never loaded directly")
+	assert.Equal(t, 1, withoutProxy.Remote.Blocked)
+}
+
+func TestRemoteContentService_Allow(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	remoteService := services.NewRemoteContentService(db, services.NewImageProxy(t.TempDir(), nil))
+
+	allowed, err := remoteService.Allowed(ctx, "news@shop.example")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	_, err = remoteService.Allow(ctx, "@Shop.Example")
+	require.NoError(t, err)
+	_, err = remoteService.Allow(ctx, "Friend@Mail.Example")
+	require.NoError(t, err)
+	_, err = remoteService.Allow(ctx, "friend@mail.example")
+	require.NoError(t, err, "allowing a sender twice is not an error")
+
+	for address, want := range map[string]bool{
+		"news@shop.example":      true,
+		"deals@eu.shop.example":  true, // subdomains of an allowed domain
+		"news@othershop.example": false,
+		"friend@mail.example":    true,
+		"stranger@mail.example":  false,
+		"news@shop.example.evil": false,
+		"not an address":         false,
+	} {
+		allowed, err := remoteService.Allowed(ctx, address)
+		require.NoError(t, err)
+		assert.Equal(t, want, allowed, address)
+	}
+
+	senders, err := remoteService.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, senders, 2)
+	assert.Equal(t, "friend@mail.example", senders[0].Sender)
+	assert.Equal(t, "shop.example", senders[1].Sender)
+
+	_, err = remoteService.Allow(ctx, "localhost")
+	assert.ErrorIs(t, err, services.ErrInvalidRemoteSender)
+
+	// Rendering follows the allow list
+	body, err := remoteService.Render(ctx, marketingEmail("news@shop.example"), false)
+	require.NoError(t, err)
+	assert.True(t, body.Remote.Allowed)
+
+	require.NoError(t, remoteService.Disallow(ctx, "shop.example"))
+	body, err = remoteService.Render(ctx, marketingEmail("news@shop.example"), false)
+	require.NoError(t, err)
+	assert.False(t, body.Remote.Allowed)
+	assert.Equal(t, 1, body.Remote.Blocked)
+
+	body, err = remoteService.Render(ctx, marketingEmail("news@shop.example"), true)
+	require.NoError(t, err)
+	assert.True(t, body.Remote.Allowed, "images can be loaded once")
+}
+
+func TestImageProxy_Fetch(t *testing.T) {
+	ctx := context.Background()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		assert.Equal(t, "Mozilla/5.0", r.UserAgent(), "the webview's user agent is not sent")
+		assert.Empty(t, r.Header.Get("Cookie"))
+		switch r.URL.Path {
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("PNG DATA"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<script>alert(1)</script>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	proxy := services.NewImageProxy(t.TempDir(), server.Client())
+	remote := server.URL + "/logo.png"
+
+	// Proxy URLs carry a signature of the remote URL
+	proxied, err := url.Parse(proxy.URL(remote))
+	require.NoError(t, err)
+	resolved, err := proxy.Resolve(proxied.Path, proxied.Query().Get("url"))
+	require.NoError(t, err)
+	assert.Equal(t, remote, resolved)
+	_, err = proxy.Resolve(proxied.Path, server.URL+"/other.png")
+	assert.ErrorIs(t, err, services.ErrInvalidProxyURL, "the proxy fetches only URLs it signed")
+
+	image, err := proxy.Fetch(ctx, remote)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", image.ContentType)
+	assert.Equal(t, "PNG DATA", string(image.Data))
+
+	image, err = proxy.Fetch(ctx, remote)
+	require.NoError(t, err)
+	assert.Equal(t, "PNG DATA", string(image.Data))
+	assert.Equal(t, int32(1), requests.Load(), "images are served from the cache")
+
+	_, err = proxy.Fetch(ctx, server.URL+"/page.html")
+	assert.ErrorIs(t, err, services.ErrNotAnImage)
+	_, err = proxy.Fetch(ctx, server.URL+"/missing.png")
+	assert.Error(t, err)
+
+	// The default client does not reach the local network, however its
+	// address is written
+	public := services.NewImageProxy(t.TempDir(), nil)
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+	for _, remote := range []string{
+		remote,
+		"http://[::ffff:127.0.0.1]" + port + "/logo.png",
+		"http://[::ffff:10.0.0.1]/logo.png",
+		"http://100.64.0.1/logo.png",
+		"http://100.127.255.254/logo.png",
+	} {
+		_, err = public.Fetch(ctx, remote)
+		assert.ErrorIs(t, err, services.ErrPrivateAddress, remote)
+	}
+}
@@ -0,0 +1,423 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/rfc5322"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// ruleTestServices delivers messages to an account as the app does. The
+// rules run on them when built by newRuleTestServices.
+type ruleTestServices struct {
+	*sendTestServices
+	rules   *services.RuleService
+	account *entities.Account
+	sync    *services.SyncService
+	mailbox *fakeMailbox
+}
+
+// fakeMailbox is the provider's mailbox of an account, holding the
+// messages the next sync fetches
+type fakeMailbox struct {
+	incoming [][]byte
+	last     *services.EmailDTO // The last message fetched
+}
+
+func (m *fakeMailbox) Fetch(_ context.Context, account *entities.Account, emit func(*services.EmailDTO) error) error {
+	incoming := m.incoming
+	m.incoming = nil
+	for _, raw := range incoming {
+		email, err := services.ParseEmail(account, raw, nil)
+		if err != nil {
+			return err
+		}
+		m.last = email
+		if err := emit(email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDeliveryTestServices returns services whose new messages are
+// published on the returned bus, and an account to deliver them to. No
+// service reacts to them until subscribed.
+func newDeliveryTestServices(t *testing.T, db *gorm.DB) (*ruleTestServices, *events.Bus) {
+	s := &ruleTestServices{sendTestServices: newSendTestServices(t, db)}
+	bus := events.NewBus()
+	s.email.SetEventBus(bus)
+	s.account = createTestAccount(t, context.Background(), sqlite.NewAccountRepository(db), "ada@engines.example")
+	return s, bus
+}
+
+// subscriber is a service reacting to the messages published on a bus
+type subscriber interface {
+	SetEventBus(bus *events.Bus)
+	Subscribe(bus *events.Bus) func()
+}
+
+// subscribe wires a service to bus as the app does and returns it
+func subscribe[T subscriber](bus *events.Bus, service T) T {
+	service.SetEventBus(bus)
+	service.Subscribe(bus)
+	return service
+}
+
+func newRuleTestServices(t *testing.T, db *gorm.DB) *ruleTestServices {
+	s, bus := newDeliveryTestServices(t, db)
+	s.rules = subscribe(bus, services.NewRuleService(db, s.email, s.send, nil))
+	return s
+}
+
+// deliver syncs a message sent to the account and returns it as stored
+// after the rules ran, or nil if a rule deleted it
+func (s *ruleTestServices) deliver(t *testing.T, ctx context.Context, raw string) *services.EmailDTO {
+	if s.sync == nil {
+		s.mailbox = &fakeMailbox{}
+		s.sync = services.NewSyncService(sqlite.NewAccountRepository(s.db), s.email)
+		s.sync.RegisterSource(s.account.AccountType, s.mailbox)
+	}
+	s.mailbox.incoming = append(s.mailbox.incoming, []byte(raw))
+	result, err := s.sync.SyncAccount(ctx, s.account.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Created)
+	stored, err := s.email.GetByID(ctx, s.mailbox.last.Message.ID)
+	if err == services.ErrEmailNotFound {
+		return nil
+	}
+	require.NoError(t, err)
+	return stored
+}
+
+// ruleMessage returns a message to the account
+func ruleMessage(from, subject, extraHeaders, body string) string {
+	return "From: " + from + "\r\n" +
+		"To: Ada Lovelace <ada@engines.example>\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: Mon, 02 Nov 2026 09:00:00 +0000\r\n" +
+		extraHeaders +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		body + "\r\n"
+}
+
+// invoiceMessage is a message with a PDF attached
+const invoiceMessage = "From: Billing <billing@shop.example>\r\n" +
+	"To: ada@engines.example\r\n" +
+	"Subject: Your invoice 2026-11\r\n" +
+	"Date: Mon, 02 Nov 2026 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Please find your invoice attached.\r\n" +
+	"--b\r\n" +
+	"Content-Type: application/pdf; name=invoice.pdf\r\n" +
+	"Content-Disposition: attachment; filename=invoice.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQK\r\n" +
+	"--b--\r\n"
+
+func TestRuleService_Apply(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newRuleTestServices(t, db)
+
+	rules := []*entities.Rule{
+		{
+			Name:           "Invoices",
+			Enabled:        true,
+			MatchAll:       true,
+			StopProcessing: true,
+			Conditions: []entities.RuleCondition{
+				{Field: entities.RuleFieldSubject, Operator: entities.RuleOperatorContains, Value: "INVOICE"},
+				{Field: entities.RuleFieldHasAttachments, Operator: entities.RuleOperatorEquals, Value: "true"},
+			},
+			Actions: []entities.RuleAction{
+				{Type: entities.RuleActionLabel, Value: "Bills"},
+				{Type: entities.RuleActionMove, Value: "Finance"},
+				{Type: entities.RuleActionMarkRead},
+			},
+		},
+		{
+			Name:    "Mailing lists",
+			Enabled: true,
+			Conditions: []entities.RuleCondition{
+				{Field: entities.RuleFieldHeader, Header: "list-id", Operator: entities.RuleOperatorMatches, Value: `<golang-nuts\.`},
+				{Field: entities.RuleFieldRecipients, Operator: entities.RuleOperatorEndsWith, Value: "@lists.example"},
+			},
+			Actions: []entities.RuleAction{
+				{Type: entities.RuleActionSetImportance, Value: string(entities.ImportanceLow)},
+				{Type: entities.RuleActionLabel, Value: "Lists"},
+			},
+		},
+		{
+			Name:    "Shop",
+			Enabled: true,
+			Conditions: []entities.RuleCondition{
+				{Field: entities.RuleFieldSender, Operator: entities.RuleOperatorEndsWith, Value: "@shop.example"},
+			},
+			Actions: []entities.RuleAction{{Type: entities.RuleActionLabel, Value: "Shopping"}},
+		},
+		{
+			Name:    "Disabled",
+			Enabled: false,
+			Conditions: []entities.RuleCondition{
+				{Field: entities.RuleFieldBody, Operator: entities.RuleOperatorContains, Value: "a"},
+			},
+			Actions: []entities.RuleAction{{Type: entities.RuleActionDelete}},
+		},
+	}
+	for _, rule := range rules {
+		require.NoError(t, s.rules.Create(ctx, rule))
+	}
+	assert.Equal(t, []int{0, 1, 2, 3}, []int{rules[0].Position, rules[1].Position, rules[2].Position, rules[3].Position})
+
+	// The first rule stops the shop rule from running
+	invoice := s.deliver(t, ctx, invoiceMessage)
+	require.NotNil(t, invoice)
+	assert.Equal(t, []string{"Bills"}, invoice.Labels)
+	assert.Equal(t, "Finance", invoice.Message.Folder)
+
+	// Without an attachment only the shop rule matches
+	receipt := s.deliver(t, ctx, ruleMessage("Shop <orders@shop.example>", "Invoice for your order", "", "Thanks"))
+	require.NotNil(t, receipt)
+	assert.Equal(t, []string{"Shopping"}, receipt.Labels)
+	assert.Empty(t, receipt.Message.Folder)
+
+	list := s.deliver(t, ctx, ruleMessage("gopher@golang.example", "Generics question",
+		"List-Id: Go nuts <golang-nuts.googlegroups.example>\r\n", "How do I..."))
+	require.NotNil(t, list)
+	assert.Equal(t, entities.ImportanceLow, list.Message.Importance)
+	assert.Equal(t, []string{"Lists"}, list.Labels)
+
+	// Filed emails can be listed by folder and label
+	finance := "Finance"
+	page, err := s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{Folder: &finance},
+	}, 10, 1)
+	require.NoError(t, err)
+	require.Len(t, page.Emails, 1)
+	assert.Equal(t, invoice.Message.ID, page.Emails[0].Message.ID)
+	inbox := ""
+	page, err = s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{Folder: &inbox, Label: "Lists"},
+	}, 10, 1)
+	require.NoError(t, err)
+	require.Len(t, page.Emails, 1)
+	assert.Equal(t, list.Message.ID, page.Emails[0].Message.ID)
+
+	// Applying again puts no label twice
+	result, err := s.rules.Apply(ctx, list.Message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Mailing lists"}, result.Rules)
+	stored, err := s.email.GetByID(ctx, list.Message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Lists"}, stored.Labels)
+
+	// Imported emails are read already; unread ones are marked read
+	require.NoError(t, db.Model(&entities.Message{}).Where("id = ?", invoice.Message.ID).Update("is_read", false).Error)
+	_, err = s.rules.Apply(ctx, invoice.Message.ID)
+	require.NoError(t, err)
+	stored, err = s.email.GetByID(ctx, invoice.Message.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.Message.IsRead)
+
+	// Reordering lets the shop rule run first
+	require.NoError(t, s.email.Delete(ctx, int64(invoice.Message.ID)))
+	require.NoError(t, s.rules.Reorder(ctx, []uint{rules[2].ID, rules[0].ID, rules[1].ID, rules[3].ID}))
+	invoice = s.deliver(t, ctx, invoiceMessage)
+	require.NotNil(t, invoice)
+	assert.Equal(t, []string{"Bills", "Shopping"}, invoice.Labels)
+
+	err = s.rules.Reorder(ctx, []uint{rules[0].ID, rules[1].ID})
+	assert.ErrorIs(t, err, services.ErrInvalidRule)
+	err = s.rules.Reorder(ctx, []uint{rules[0].ID, rules[0].ID, rules[1].ID, rules[2].ID})
+	assert.ErrorIs(t, err, services.ErrInvalidRule)
+
+	// Deleting an email deletes its labels
+	require.NoError(t, s.email.Delete(ctx, int64(invoice.Message.ID)))
+	var count int64
+	require.NoError(t, db.Model(&entities.MessageLabel{}).Where("message_id = ?", invoice.Message.ID).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestRuleService_DeleteAndForward(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newRuleTestServices(t, db)
+
+	require.NoError(t, s.rules.Create(ctx, &entities.Rule{
+		Name:    "Spam",
+		Enabled: true,
+		Conditions: []entities.RuleCondition{
+			{Field: entities.RuleFieldSubject, Operator: entities.RuleOperatorStartsWith, Value: "[SPAM]"},
+		},
+		Actions: []entities.RuleAction{{Type: entities.RuleActionDelete}},
+	}))
+	require.NoError(t, s.rules.Create(ctx, &entities.Rule{
+		Name:    "Accountant",
+		Enabled: true,
+		Conditions: []entities.RuleCondition{
+			{Field: entities.RuleFieldSubject, Operator: entities.RuleOperatorContains, Value: "invoice"},
+		},
+		Actions: []entities.RuleAction{{Type: entities.RuleActionForward, Value: "Charles <charles@accounts.example>"}},
+	}))
+
+	spam := s.deliver(t, ctx, ruleMessage("win@prizes.example", "[SPAM] invoice winner", "", "Claim now"))
+	assert.Nil(t, spam, "the message is deleted")
+	assert.Empty(t, s.transport.deliveries, "no rule runs after a deletion")
+
+	invoice := s.deliver(t, ctx, invoiceMessage)
+	require.NotNil(t, invoice)
+	require.Len(t, s.transport.deliveries, 1, "the sent copy of the forward is not filtered")
+
+	m, err := rfc5322.Parse(bytes.NewReader(s.transport.deliveryTo(t, "charles@accounts.example")))
+	require.NoError(t, err)
+	assert.Equal(t, "Fwd: Your invoice 2026-11", m.Subject)
+	assert.Equal(t, "ada@engines.example", m.From.Address)
+	assert.Contains(t, m.Text, "From: Billing <billing@shop.example>")
+	assert.Contains(t, m.Text, "Please find your invoice attached.")
+	require.Len(t, m.Attachments, 1)
+	assert.Equal(t, "invoice.pdf", m.Attachments[0].Filename)
+}
+
+func TestRuleService_SkipsImports(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newRuleTestServices(t, db)
+
+	require.NoError(t, s.rules.Create(ctx, &entities.Rule{
+		Name:    "Accountant",
+		Enabled: true,
+		Conditions: []entities.RuleCondition{
+			{Field: entities.RuleFieldSubject, Operator: entities.RuleOperatorContains, Value: "invoice"},
+		},
+		Actions: []entities.RuleAction{
+			{Type: entities.RuleActionForward, Value: "charles@accounts.example"},
+			{Type: entities.RuleActionMove, Value: "Finance"},
+		},
+	}))
+
+	// Old mail imported from an archive is not filtered again
+	mbox := "From billing@shop.example Mon Nov  2 10:00:00 2026\r\n" + invoiceMessage
+	result, err := s.archive.ImportMbox(ctx, s.account.ID, strings.NewReader(mbox), services.ImportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Imported)
+	imported := findBySubject(t, ctx, s.email, s.account.ID, "Your invoice 2026-11")
+	assert.Empty(t, imported.Message.Folder)
+	assert.Empty(t, s.transport.deliveries)
+
+	// The same message delivered is
+	require.NoError(t, s.email.Delete(ctx, int64(imported.Message.ID)))
+	delivered := s.deliver(t, ctx, invoiceMessage)
+	assert.Equal(t, "Finance", delivered.Message.Folder)
+	assert.Len(t, s.transport.deliveries, 1)
+}
+
+func TestRuleService_DryRun(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newRuleTestServices(t, db)
+
+	for i := 0; i < 5; i++ {
+		s.deliver(t, ctx, ruleMessage("news@paper.example", fmt.Sprintf("Newsletter %d", i), "", "Unsubscribe here"))
+	}
+	s.deliver(t, ctx, ruleMessage("grace@navy.example", "Lunch", "", "Tomorrow?"))
+
+	rule := &entities.Rule{
+		Name: "Newsletters",
+		Conditions: []entities.RuleCondition{
+			{Field: entities.RuleFieldBody, Operator: entities.RuleOperatorContains, Value: "unsubscribe"},
+			{Field: entities.RuleFieldSender, Operator: entities.RuleOperatorNotContains, Value: "paper"},
+		},
+		MatchAll: true,
+		Actions:  []entities.RuleAction{{Type: entities.RuleActionDelete}},
+	}
+	result, err := s.rules.DryRun(ctx, rule, 0)
+	require.NoError(t, err)
+	assert.Empty(t, result.Matches)
+	assert.Equal(t, 6, result.Scanned)
+
+	rule.Conditions = rule.Conditions[:1]
+	result, err = s.rules.DryRun(ctx, rule, 3)
+	require.NoError(t, err)
+	require.Len(t, result.Matches, 3)
+	assert.True(t, result.Truncated)
+	assert.Equal(t, "Newsletter 4", *result.Matches[0].Message.Subject, "newest first")
+
+	// Nothing was deleted and the rule was not saved
+	count, err := s.email.ListCount(ctx, s.account.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+	rules, err := s.rules.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
+func TestRuleService_Validation(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newRuleTestServices(t, db)
+
+	valid := func() *entities.Rule {
+		return &entities.Rule{
+			Name:       "Rule",
+			Conditions: []entities.RuleCondition{{Field: entities.RuleFieldSubject, Operator: entities.RuleOperatorContains, Value: "x"}},
+			Actions:    []entities.RuleAction{{Type: entities.RuleActionMarkRead}},
+		}
+	}
+	for name, change := range map[string]func(*entities.Rule){
+		"no name":          func(r *entities.Rule) { r.Name = " " },
+		"no conditions":    func(r *entities.Rule) { r.Conditions = nil },
+		"no actions":       func(r *entities.Rule) { r.Actions = nil },
+		"unknown field":    func(r *entities.Rule) { r.Conditions[0].Field = "size" },
+		"unknown operator": func(r *entities.Rule) { r.Conditions[0].Operator = "like" },
+		"bad pattern": func(r *entities.Rule) {
+			r.Conditions[0] = entities.RuleCondition{Field: "subject", Operator: "matches", Value: "("}
+		},
+		"nameless header": func(r *entities.Rule) { r.Conditions[0].Field = entities.RuleFieldHeader },
+		"attachment value": func(r *entities.Rule) {
+			r.Conditions[0] = entities.RuleCondition{Field: "has-attachments", Operator: "equals", Value: "yes"}
+		},
+		"importance":     func(r *entities.Rule) { r.Actions[0] = entities.RuleAction{Type: "set-importance", Value: "Urgent"} },
+		"empty label":    func(r *entities.Rule) { r.Actions[0] = entities.RuleAction{Type: "label"} },
+		"forward":        func(r *entities.Rule) { r.Actions[0] = entities.RuleAction{Type: "forward", Value: "nobody"} },
+		"unknown action": func(r *entities.Rule) { r.Actions[0].Type = "archive" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			rule := valid()
+			change(rule)
+			assert.ErrorIs(t, s.rules.Create(ctx, rule), services.ErrInvalidRule)
+		})
+	}
+
+	rule := valid()
+	require.NoError(t, s.rules.Create(ctx, rule))
+	rule.Name = "Renamed"
+	rule.Position = 7
+	require.NoError(t, s.rules.Update(ctx, rule))
+	stored, err := s.rules.Get(ctx, rule.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", stored.Name)
+	assert.Zero(t, stored.Position, "updates keep the order")
+
+	require.NoError(t, s.rules.Delete(ctx, rule.ID))
+	assert.ErrorIs(t, s.rules.Delete(ctx, rule.ID), services.ErrRuleNotFound)
+	assert.ErrorIs(t, s.rules.Update(ctx, rule), services.ErrRuleNotFound)
+}
@@ -0,0 +1,298 @@
+package services_test
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDeferredTransport holds messages for later as Microsoft Graph does
+type fakeDeferredTransport struct {
+	fakeTransport
+	mu        sync.Mutex
+	held      map[string]time.Time
+	cancelled []string
+}
+
+func (t *fakeDeferredTransport) SendAt(_ context.Context, _ *entities.Account, _ []string, _ []byte, at time.Time) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := fmt.Sprintf("held-%d", len(t.held)+1)
+	t.held[id] = at
+	return id, nil
+}
+
+func (t *fakeDeferredTransport) CancelSend(_ context.Context, _ *entities.Account, id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancelled = append(t.cancelled, id)
+	return nil
+}
+
+func TestSendScheduler_UndoAndSend(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "ada@engines.example")
+	bus := events.NewBus()
+	scheduler := services.NewSendScheduler(db, s.send, nil)
+	scheduler.SetEventBus(bus)
+	var statuses []string
+	bus.Subscribe(events.TopicOutboxUpdated, func(e events.Event) {
+		statuses = append(statuses, e.Payload.(events.OutboxPayload).Status)
+	})
+
+	outgoing := func(subject string) *services.OutgoingEmail {
+		return &services.OutgoingEmail{
+			AccountID: account.ID,
+			To:        []*mail.Address{{Name: "Charles", Address: "charles@engines.example"}},
+			Bcc:       []*mail.Address{{Address: "mary@engines.example"}},
+			Subject:   subject,
+			Text:      "See you at four.",
+		}
+	}
+
+	_, err := scheduler.Schedule(ctx, outgoing("Tea"), time.Time{}, 3*time.Minute, 0)
+	assert.ErrorIs(t, err, services.ErrInvalidUndoDelay)
+	_, err = scheduler.Schedule(ctx, &services.OutgoingEmail{AccountID: account.ID, Subject: "Tea"}, time.Time{}, 0, 0)
+	assert.ErrorIs(t, err, services.ErrNoRecipients)
+
+	// Undoing returns the message as composed and sends nothing
+	now := time.Now()
+	queued, err := scheduler.Schedule(ctx, outgoing("Tea"), time.Time{}, services.DefaultUndoSendDelay, 0)
+	require.NoError(t, err)
+	assert.Equal(t, entities.OutboxQueued, queued.Status)
+	assert.Equal(t, "Charles <charles@engines.example>, mary@engines.example", queued.Recipients)
+	undone, err := scheduler.Cancel(ctx, queued.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Tea", undone.Subject)
+	assert.Equal(t, "mary@engines.example", undone.Bcc[0].Address)
+	_, err = scheduler.Cancel(ctx, queued.ID)
+	assert.ErrorIs(t, err, services.ErrOutboxNotFound)
+
+	// Once the grace period is over, the message is sent and its copy stored
+	queued, err = scheduler.Schedule(ctx, outgoing("Cake"), time.Time{}, services.DefaultUndoSendDelay, 0)
+	require.NoError(t, err)
+	n, err := scheduler.ProcessDue(ctx, now)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	n, err = scheduler.ProcessDue(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	// Blind copies are sent apart
+	require.Len(t, s.transport.deliveries, 2)
+	assert.Contains(t, string(s.transport.deliveryTo(t, "mary@engines.example")), "Subject: Cake")
+	_, err = scheduler.Cancel(ctx, queued.ID)
+	assert.ErrorIs(t, err, services.ErrOutboxNotFound)
+	sent, err := s.email.ListWithOptions(ctx, account.ID, services.ListOptions{}, 20, 1)
+	require.NoError(t, err)
+	require.Len(t, sent.Emails, 1)
+	assert.Equal(t, []string{
+		entities.OutboxQueued, entities.OutboxCancelled, entities.OutboxQueued, entities.OutboxSent,
+	}, statuses)
+
+	// Without native deferred send, messages sent later wait in the outbox
+	// and can be undone until their time
+	sendAt := now.Add(2 * time.Hour)
+	later, err := scheduler.Schedule(ctx, outgoing("Dinner"), sendAt, 0, 0)
+	require.NoError(t, err)
+	n, err = scheduler.ProcessDue(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	list, err := scheduler.List(ctx, account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, entities.OutboxQueued, list[0].Status)
+	assert.WithinDuration(t, sendAt, list[0].DueAt, time.Second)
+	assert.Len(t, s.transport.deliveries, 2)
+
+	n, err = scheduler.ProcessDue(ctx, sendAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, s.transport.deliveries, 4)
+	raw := string(s.transport.deliveries[2].raw)
+	assert.Contains(t, raw, "Subject: Dinner")
+	assert.Contains(t, raw, "Date: "+sendAt.UTC().Format("Mon, 02 Jan 2006 15:04"), "dated when it was meant to go out")
+	_, err = scheduler.Cancel(ctx, later.ID)
+	assert.ErrorIs(t, err, services.ErrOutboxNotFound)
+}
+
+func TestSendScheduler_DeferredSend(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	transport := &fakeDeferredTransport{held: map[string]time.Time{}}
+	s.send.RegisterTransport(entities.AccountTypeMicrosoft, transport)
+	account := &entities.Account{Email: "ada@engines.example", AccountType: entities.AccountTypeMicrosoft}
+	require.NoError(t, sqlite.NewAccountRepository(db).Create(ctx, account).Error)
+	scheduler := services.NewSendScheduler(db, s.send, nil)
+
+	outgoing := func(subject string) *services.OutgoingEmail {
+		return &services.OutgoingEmail{
+			AccountID: account.ID,
+			To:        []*mail.Address{{Address: "charles@engines.example"}},
+			Subject:   subject,
+			Text:      "See you at four.",
+		}
+	}
+
+	// After the grace period, the provider holds the message
+	now := time.Now()
+	sendAt := now.Add(time.Hour)
+	first, err := scheduler.Schedule(ctx, outgoing("Tea"), sendAt, services.DefaultUndoSendDelay, 0)
+	require.NoError(t, err)
+	second, err := scheduler.Schedule(ctx, outgoing("Cake"), sendAt, services.DefaultUndoSendDelay, 0)
+	require.NoError(t, err)
+	n, err := scheduler.ProcessDue(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Len(t, transport.held, 2)
+	assert.Empty(t, transport.deliveries)
+	list, err := scheduler.List(ctx, account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, entities.OutboxDeferred, list[0].Status)
+	assert.Equal(t, []string{"held-1"}, list[0].ProviderIDs)
+
+	// Cancelling a held message cancels it with the provider
+	_, err = scheduler.Cancel(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"held-2"}, transport.cancelled)
+
+	// Once the provider sent it, its copy is stored
+	n, err = scheduler.ProcessDue(ctx, sendAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	sent, err := s.email.ListWithOptions(ctx, account.ID, services.ListOptions{}, 20, 1)
+	require.NoError(t, err)
+	require.Len(t, sent.Emails, 1)
+	assert.Equal(t, "Tea", *sent.Emails[0].Message.Subject)
+	_, err = scheduler.Cancel(ctx, first.ID)
+	assert.ErrorIs(t, err, services.ErrOutboxNotFound)
+}
+
+func TestSendScheduler_NoTransport(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	account := &entities.Account{Email: "ada@engines.example", AccountType: entities.AccountTypeMicrosoft}
+	require.NoError(t, sqlite.NewAccountRepository(db).Create(ctx, account).Error)
+	bus := events.NewBus()
+	scheduler := services.NewSendScheduler(db, s.send, nil)
+	scheduler.SetEventBus(bus)
+	var updates []events.OutboxPayload
+	bus.Subscribe(events.TopicOutboxUpdated, func(e events.Event) {
+		updates = append(updates, e.Payload.(events.OutboxPayload))
+	})
+
+	outgoing := func(subject string) *services.OutgoingEmail {
+		return &services.OutgoingEmail{
+			AccountID: account.ID,
+			To:        []*mail.Address{{Address: "charles@engines.example"}},
+			Subject:   subject,
+			Text:      "See you at four.",
+		}
+	}
+
+	// Messages fail once they can no longer be undone, those sent later
+	// too rather than at their time
+	now := time.Now()
+	_, err := scheduler.Schedule(ctx, outgoing("Tea"), time.Time{}, services.DefaultUndoSendDelay, 0)
+	require.NoError(t, err)
+	_, err = scheduler.Schedule(ctx, outgoing("Cake"), now.Add(2*time.Hour), services.DefaultUndoSendDelay, 0)
+	require.NoError(t, err)
+	n, err := scheduler.ProcessDue(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	list, err := scheduler.List(ctx, account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	for _, message := range list {
+		assert.Equal(t, entities.OutboxFailed, message.Status)
+		assert.Contains(t, message.Error, services.ErrNoTransport.Error())
+	}
+	require.Len(t, updates, 4)
+	assert.Equal(t, entities.OutboxFailed, updates[2].Status)
+	assert.Equal(t, entities.OutboxFailed, updates[3].Status)
+	assert.Contains(t, updates[3].Error, services.ErrNoTransport.Error())
+}
+
+func TestSendScheduler_Run(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "ada@engines.example")
+	outgoing := &services.OutgoingEmail{
+		AccountID: account.ID,
+		To:        []*mail.Address{{Address: "charles@engines.example"}},
+		Subject:   "Tea",
+		Text:      "See you at four.",
+	}
+
+	// The app closed while sending one message, and before sending another
+	interrupted, err := services.NewSendScheduler(db, s.send, nil).Schedule(ctx, outgoing, time.Time{}, time.Minute, 0)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(interrupted).Update("status", entities.OutboxSending).Error)
+	outgoing.MessageID = ""
+	_, err = services.NewSendScheduler(db, s.send, nil).Schedule(ctx, outgoing, time.Time{}, 0, 0)
+	require.NoError(t, err)
+
+	bus := events.NewBus()
+	updates := make(chan events.OutboxPayload, 4)
+	bus.Subscribe(events.TopicOutboxUpdated, func(e events.Event) {
+		updates <- e.Payload.(events.OutboxPayload)
+	})
+	scheduler := services.NewSendScheduler(db, s.send, nil)
+	scheduler.SetEventBus(bus)
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(runCtx)
+		close(done)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	wait := func() events.OutboxPayload {
+		select {
+		case update := <-updates:
+			return update
+		case <-time.After(5 * time.Second):
+			t.Fatal("no outbox update")
+			return events.OutboxPayload{}
+		}
+	}
+	update := wait()
+	assert.Equal(t, entities.OutboxSent, update.Status)
+	assert.NotZero(t, update.MessageID)
+
+	// Interrupted messages may have gone out, so they are not sent again
+	list, err := scheduler.List(ctx, account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, entities.OutboxFailed, list[0].Status)
+	assert.NotEmpty(t, list[0].Error)
+	_, err = scheduler.Retry(ctx, 999999)
+	assert.ErrorIs(t, err, services.ErrOutboxNotFound)
+
+	// Retrying wakes the scheduler to send it
+	_, err = scheduler.Retry(ctx, interrupted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, entities.OutboxQueued, wait().Status)
+	assert.Equal(t, entities.OutboxSent, wait().Status)
+	assert.Len(t, s.transport.deliveries, 2)
+}
@@ -0,0 +1,249 @@
+package services_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/formats/dkim"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticResolver answers TXT lookups from a map; missing names do not
+// exist and names mapped to nil fail temporarily
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	switch {
+	case !ok:
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	case records == nil:
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	return records, nil
+}
+
+// signedEmail returns an email from sender whose source carries the given
+// header fields and is signed by domain
+func signedEmail(t *testing.T, accountID uint, sender, fields, domain string, key ed25519.PrivateKey) *services.EmailDTO {
+	raw := fields +
+		"From: " + sender + "\r\n" +
+		"To: me@example.org\r\n" +
+		"Subject: Invoice\r\n" +
+		"\r\n" +
+		"Please find the invoice attached.\r\n"
+	if key != nil {
+		signature, err := dkim.Sign([]byte(raw), dkim.SignOptions{Domain: domain, Selector: "mail", Key: key})
+		require.NoError(t, err)
+		raw = signature + raw
+	}
+
+	email := suspiciousEmail(accountID, sender, "", "Please find the invoice attached.")
+	email.Message.SenderName = nil
+	email.Raw = []byte(raw)
+	return email
+}
+
+func TestSenderVerifier_Verify(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	record := "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public)
+	resolver := staticResolver{
+		"mail._domainkey.example.com":      {record},
+		"mail._domainkey.evil.example.net": {record},
+		"mail._domainkey.flaky.example":    nil,
+		"_dmarc.example.com":               {"v=DMARC1; p=reject"},
+		"_dmarc.flaky.example":             {"v=DMARC1; p=none"},
+		"_dmarc.strict.example":            {"v=DMARC1; p=reject; adkim=s"},
+	}
+
+	bus := events.NewBus()
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	emailService.SetEventBus(bus)
+	analyzer := services.NewPhishingAnalyzer(db)
+	t.Cleanup(analyzer.Subscribe(bus))
+	verifier := services.NewSenderVerifier(db, resolver)
+	verifier.SetPhishingAnalyzer(analyzer)
+	t.Cleanup(verifier.Subscribe(bus))
+	accountRepo := sqlite.NewAccountRepository(db)
+	account := createTestAccount(t, ctx, accountRepo, "me@example.org")
+	_, err = services.NewAccountService(accountRepo).SetTrustedAuthServIDs(ctx, account.ID, []string{"MX.example.org"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		email  *services.EmailDTO
+		want   entities.MessageAuthentication
+		reason bool // The phishing analyzer flags the failure
+	}{
+		{
+			name:  "signed by the sender's domain",
+			email: signedEmail(t, account.ID, "billing@example.com", "", "example.com", private),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderVerified, DKIM: "pass", DKIMDomain: "example.com", DKIMLocal: true,
+				SPF: "none", DMARC: "pass", ARC: "none",
+			},
+		},
+		{
+			name:  "signed by the sender's organization",
+			email: signedEmail(t, account.ID, "billing@mail.example.com", "", "example.com", private),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderVerified, DKIM: "pass", DKIMDomain: "example.com", DKIMLocal: true,
+				SPF: "none", DMARC: "pass", ARC: "none",
+			},
+		},
+		{
+			name: "signed by another domain",
+			email: signedEmail(t, account.ID, "billing@example.com",
+				"Authentication-Results: mx.example.org; spf=pass smtp.mailfrom=bounce@evil.example.net\r\n",
+				"evil.example.net", private),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderFailed, DKIM: "pass", DKIMDomain: "evil.example.net", DKIMLocal: true,
+				SPF: "pass", DMARC: "fail", ARC: "none",
+			},
+			reason: true,
+		},
+		{
+			name: "reported by the delivering server when keys cannot be retrieved",
+			email: signedEmail(t, account.ID, "news@flaky.example",
+				"Authentication-Results: mx.example.org; dkim=pass header.d=flaky.example; spf=none\r\n"+
+					"Authentication-Results: attacker.example; dkim=fail header.d=flaky.example\r\n",
+				"flaky.example", private),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderVerified, DKIM: "pass", DKIMDomain: "flaky.example", DKIMLocal: false,
+				SPF: "none", DMARC: "pass", ARC: "none",
+			},
+		},
+		{
+			name: "reported by a server the account does not trust",
+			email: signedEmail(t, account.ID, "billing@example.com",
+				"Authentication-Results: attacker.example; spf=pass smtp.mailfrom=billing@example.com; dmarc=pass\r\n",
+				"", nil),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderFailed, DKIM: "none", DKIMLocal: true,
+				SPF: "none", DMARC: "fail", ARC: "none",
+			},
+			reason: true,
+		},
+		{
+			name: "forwarded by a mailing list",
+			email: signedEmail(t, account.ID, "alice@example.com",
+				"Authentication-Results: mx.example.org; arc=pass; dkim=fail header.d=example.com\r\n"+
+					"ARC-Seal: i=1; a=rsa-sha256; cv=none; d=lists.example.org; s=arc; b=AAAA\r\n"+
+					"ARC-Seal: i=2; a=rsa-sha256; cv=pass; d=lists.example.org; s=arc; b=AAAA\r\n",
+				"", nil),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderUnverified, DKIM: "none", DKIMLocal: true,
+				SPF: "none", DMARC: "fail", ARC: "pass",
+			},
+			reason: true,
+		},
+		{
+			name:  "strict alignment",
+			email: signedEmail(t, account.ID, "alice@mail.strict.example", "", "example.com", private),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderFailed, DKIM: "pass", DKIMDomain: "example.com", DKIMLocal: true,
+				SPF: "none", DMARC: "fail", ARC: "none",
+			},
+			reason: true,
+		},
+		{
+			name:  "unsigned without a policy",
+			email: signedEmail(t, account.ID, "someone@unknown.example", "", "", nil),
+			want: entities.MessageAuthentication{
+				SenderStatus: entities.SenderUnverified, DKIM: "none", DKIMLocal: true,
+				SPF: "none", DMARC: "none", ARC: "none",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, emailService.Create(ctx, tt.email))
+			assert.Equal(t, 1, verifier.VerifyPending(ctx))
+			email, err := emailService.GetByID(ctx, tt.email.Message.ID)
+			require.NoError(t, err)
+			require.NotNil(t, email.Authentication, "created messages are verified")
+
+			got := *email.Authentication
+			got.ID, got.VerifiedAt, got.MessageID = 0, tt.want.VerifiedAt, 0
+			assert.Equal(t, tt.want, got)
+
+			flagged := false
+			for _, reason := range email.Risk.Reasons {
+				flagged = flagged || reason.Detail == "The sender's domain does not vouch for this message (DMARC failed)"
+			}
+			assert.Equal(t, tt.reason, flagged)
+		})
+	}
+}
+
+// blockingResolver answers lookups only once released
+type blockingResolver struct {
+	release chan struct{}
+}
+
+func (r blockingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	select {
+	case <-r.release:
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestSenderVerifier_Run(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	bus := events.NewBus()
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	emailService.SetEventBus(bus)
+	resolver := blockingResolver{release: make(chan struct{})}
+	verifier := services.NewSenderVerifier(db, resolver)
+	t.Cleanup(verifier.Subscribe(bus))
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "me@example.org")
+
+	// Storing a message does not wait for DNS
+	email := signedEmail(t, account.ID, "someone@unknown.example", "", "", nil)
+	require.NoError(t, emailService.Create(ctx, email))
+	stored, err := emailService.GetByID(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.Authentication)
+
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		verifier.Run(runCtx)
+		close(done)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+	close(resolver.release)
+
+	require.Eventually(t, func() bool {
+		stored, err := emailService.GetByID(ctx, email.Message.ID)
+		return err == nil && stored.Authentication != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
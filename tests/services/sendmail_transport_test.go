@@ -0,0 +1,66 @@
+package services_test
+
+import (
+	"context"
+	"net/mail"
+	"os"
+	"palm/src/entities"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSendmail writes a sendmail program to dir that records its arguments
+// and input there, or fails with a message when fail is set
+func fakeSendmail(t *testing.T, dir string, fail bool) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("sendmail is not available on Windows")
+	}
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"" + dir + "/args\"\ncat > \"" + dir + "/message\"\n"
+	if fail {
+		script += "echo 'relay refused' >&2\nexit 75\n"
+	}
+	path := filepath.Join(dir, "sendmail")
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+func TestSendmailTransport_Send(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	dir := t.TempDir()
+	s.send.RegisterTransport(entities.AccountTypeMaildir, services.NewSendmailTransport(fakeSendmail(t, dir, false)))
+	account := &entities.Account{Email: "ada@engines.example", AccountType: entities.AccountTypeMaildir}
+	require.NoError(t, sqlite.NewAccountRepository(db).Create(ctx, account).Error)
+
+	_, err := s.send.Send(ctx, &services.OutgoingEmail{
+		AccountID: account.ID,
+		To:        []*mail.Address{{Name: "Charles", Address: "charles@engines.example"}},
+		Subject:   "Tea",
+		Text:      "See you at four.\n.\nNot the end.",
+	})
+	require.NoError(t, err)
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-i\n-f\nada@engines.example\n--\ncharles@engines.example\n", string(args))
+	message, err := os.ReadFile(filepath.Join(dir, "message"))
+	require.NoError(t, err)
+	assert.Contains(t, string(message), "Subject: Tea\n")
+	assert.Contains(t, string(message), "Not the end.")
+	assert.NotContains(t, string(message), "\r\n", "lines end as sendmail expects")
+
+	// What sendmail reports is kept in the error
+	transport := services.NewSendmailTransport(fakeSendmail(t, t.TempDir(), true))
+	err = transport.Send(ctx, account, []string{"charles@engines.example"}, []byte("Subject: Tea\r\n\r\nHi\r\n"))
+	require.Error(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "relay refused"), err.Error())
+}
@@ -0,0 +1,238 @@
+package services_test
+
+import (
+	"context"
+	"palm/src/entities"
+	"palm/src/formats/managesieve"
+	"palm/src/formats/sieve"
+	"palm/src/services"
+	"palm/tests/utils"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const filterScript = `require ["fileinto", "imap4flags", "copy"];
+if address :domain "from" "spam.example" {
+	discard;
+	stop;
+}
+if header :contains "subject" "report" {
+	fileinto :flags ["\\Flagged", "work"] "Reports";
+	fileinto :copy "Archive";
+	stop;
+}
+if header :is "subject" "Forward me" {
+	redirect "archive@example.net";
+}
+`
+
+func TestSieveService_Import(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	sieveService := subscribe(bus, services.NewSieveService(db, s.email, s.send, nil))
+
+	err := sieveService.Import(ctx, &entities.SieveScript{Name: "broken", Source: "frobnicate;"})
+	assert.ErrorIs(t, err, services.ErrInvalidSieveScript)
+	err = sieveService.Import(ctx, &entities.SieveScript{Name: " ", Source: "keep;"})
+	assert.ErrorIs(t, err, services.ErrInvalidSieveScript)
+
+	global := &entities.SieveScript{Name: "filters", Source: "keep;", Active: true}
+	require.NoError(t, sieveService.Import(ctx, global))
+	own := &entities.SieveScript{Name: "filters", Source: "keep;", Active: true, AccountID: &s.account.ID}
+	require.NoError(t, sieveService.Import(ctx, own))
+	assert.NotEqual(t, global.ID, own.ID, "scripts of different accounts are kept apart")
+
+	// Importing under the same name replaces the script
+	replacement := &entities.SieveScript{Name: "filters", Source: filterScript}
+	require.NoError(t, sieveService.Import(ctx, replacement))
+	assert.Equal(t, global.ID, replacement.ID)
+	stored, err := sieveService.Get(ctx, global.ID)
+	require.NoError(t, err)
+	assert.Equal(t, filterScript, stored.Source)
+	assert.False(t, stored.Active)
+
+	// Activating a script deactivates the others of its account only
+	other := &entities.SieveScript{Name: "other", Source: "keep;"}
+	require.NoError(t, sieveService.Import(ctx, other))
+	require.NoError(t, sieveService.SetActive(ctx, global.ID, true))
+	require.NoError(t, sieveService.SetActive(ctx, other.ID, true))
+	scripts, err := sieveService.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, scripts, 3)
+	active := map[uint]bool{}
+	for _, script := range scripts {
+		active[script.ID] = script.Active
+	}
+	assert.Equal(t, map[uint]bool{global.ID: false, own.ID: true, other.ID: true}, active)
+
+	require.NoError(t, sieveService.Delete(ctx, other.ID))
+	assert.ErrorIs(t, sieveService.Delete(ctx, other.ID), services.ErrSieveScriptNotFound)
+	assert.ErrorIs(t, sieveService.SetActive(ctx, other.ID, true), services.ErrSieveScriptNotFound)
+}
+
+func TestSieveService_Run(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	sieveService := subscribe(bus, services.NewSieveService(db, s.email, s.send, nil))
+
+	// Without an active script messages are left alone
+	plain := s.deliver(t, ctx, ruleMessage("spammer@spam.example", "Cheap watches", "", "Buy now"))
+	require.NotNil(t, plain)
+
+	// A script of the account wins over the one of every account
+	require.NoError(t, sieveService.Import(ctx, &entities.SieveScript{Name: "discard all", Source: "discard;", Active: true}))
+	require.NoError(t, sieveService.Import(ctx, &entities.SieveScript{
+		Name:      "filters",
+		Source:    filterScript,
+		Active:    true,
+		AccountID: &s.account.ID,
+	}))
+
+	spam := s.deliver(t, ctx, ruleMessage("spammer@spam.example", "Cheaper watches", "", "Buy now"))
+	assert.Nil(t, spam)
+
+	report := s.deliver(t, ctx, ruleMessage("Bob <bob@example.org>", "Weekly report", "", "Numbers"))
+	require.NotNil(t, report)
+	assert.Equal(t, "Reports", report.Message.Folder)
+	assert.True(t, report.Message.IsFlagged)
+	assert.ElementsMatch(t, []string{"Archive", "work"}, report.Labels)
+
+	kept := s.deliver(t, ctx, ruleMessage("Bob <bob@example.org>", "Lunch?", "", "Noon"))
+	require.NotNil(t, kept)
+	assert.Empty(t, kept.Message.Folder)
+	assert.Empty(t, kept.Labels)
+
+	// Redirected messages are resent from the account and not kept
+	forwarded := s.deliver(t, ctx, ruleMessage("Bob <bob@example.org>", "Forward me", "", "Please"))
+	assert.Nil(t, forwarded)
+	raw := string(s.transport.deliveryTo(t, "archive@example.net"))
+	assert.Contains(t, raw, "Resent-From: <ada@engines.example>")
+	assert.Contains(t, raw, "Subject: Forward me")
+
+	// Messages an earlier filter deleted are skipped
+	result, err := sieveService.Run(ctx, 0)
+	require.NoError(t, err)
+	assert.Empty(t, result.Script)
+}
+
+func TestSieveService_Vacation(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	sieveService := subscribe(bus, services.NewSieveService(db, s.email, s.send, nil))
+
+	require.NoError(t, sieveService.Import(ctx, &entities.SieveScript{
+		Name:   "away",
+		Source: "require \"vacation\";\nvacation :days 3 :subject \"Away\" \"Back on Monday.\";\n",
+		Active: true,
+	}))
+
+	first := s.deliver(t, ctx, ruleMessage("Bob <bob@example.org>", "Question", "", "Are you there?"))
+	require.NotNil(t, first, "vacation keeps the message")
+	reply := string(s.transport.deliveryTo(t, "bob@example.org"))
+	assert.Contains(t, reply, "Subject: Away")
+	assert.Contains(t, reply, "Auto-Submitted: auto-replied")
+	assert.Contains(t, reply, "Back on Monday.")
+
+	// The sender is answered once per period
+	s.deliver(t, ctx, ruleMessage("Bob <bob@example.org>", "Another question", "", "Still there?"))
+	assert.Len(t, s.transport.deliveries, 1)
+
+	// Lists, automated senders and messages to others get no response
+	s.deliver(t, ctx, ruleMessage("list@lists.example", "Digest", "List-Id: <digest.lists.example>\r\n", "News"))
+	s.deliver(t, ctx, ruleMessage("noreply@shop.example", "Order shipped", "", "On its way"))
+	s.deliver(t, ctx, ruleMessage("carol@example.org", "Notice", "Auto-Submitted: auto-generated\r\n", "Automated"))
+	s.deliver(t, ctx, strings.Replace(ruleMessage("dave@example.org", "Bcc", "", "Hi"),
+		"To: Ada Lovelace <ada@engines.example>", "To: team@example.org", 1))
+	assert.Len(t, s.transport.deliveries, 1)
+
+	// A new response is sent once the period is over
+	require.NoError(t, db.Model(&entities.VacationReply{}).Where("1 = 1").
+		Update("sent_at", gorm.Expr("datetime('now', '-4 days')")).Error)
+	s.deliver(t, ctx, ruleMessage("Bob <bob@example.org>", "Third question", "", "Hello?"))
+	assert.Len(t, s.transport.deliveries, 2)
+}
+
+func TestSieveService_SkipsImports(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	sieveService := subscribe(bus, services.NewSieveService(db, s.email, s.send, nil))
+
+	require.NoError(t, sieveService.Import(ctx, &entities.SieveScript{
+		Name:   "away",
+		Source: "require \"vacation\";\nvacation :days 3 :subject \"Away\" \"Back on Monday.\";\n",
+		Active: true,
+	}))
+
+	// Importing years of mail answers no one
+	mbox := "From bob@example.org Mon Nov  2 09:00:00 2026\r\n" +
+		ruleMessage("Bob <bob@example.org>", "Question", "", "Are you there?") +
+		"\r\nFrom carol@example.org Mon Nov  2 09:00:00 2026\r\n" +
+		ruleMessage("Carol <carol@example.org>", "Lunch", "", "Noon?")
+	result, err := s.archive.ImportMbox(ctx, s.account.ID, strings.NewReader(mbox), services.ImportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Imported)
+	_, err = s.archive.ImportEML(ctx, s.account.ID, strings.NewReader(ruleMessage("Dave <dave@example.org>", "Hello", "", "Hi")))
+	require.NoError(t, err)
+	assert.Empty(t, s.transport.deliveries)
+}
+
+func TestSieveService_Evaluate(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	sieveService := subscribe(bus, services.NewSieveService(db, s.email, s.send, nil))
+
+	email := s.deliver(t, ctx, ruleMessage("Bob <bob@example.org>", "Weekly report", "", "Numbers"))
+	require.NotNil(t, email)
+	result, err := sieveService.Evaluate(ctx, filterScript, email.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, result.Actions, 2)
+	assert.Equal(t, sieve.FileInto{Mailbox: "Reports", Flags: []string{`\Flagged`, "work"}}, result.Actions[0])
+	assert.Equal(t, sieve.FileInto{Mailbox: "Archive", Copy: true}, result.Actions[1])
+
+	// Nothing was done to the message
+	stored, err := s.email.GetByID(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Message.Folder)
+
+	_, err = sieveService.Evaluate(ctx, "frobnicate;", email.Message.ID)
+	assert.ErrorIs(t, err, services.ErrInvalidSieveScript)
+}
+
+func TestSieveService_Upload(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	sieveService := subscribe(bus, services.NewSieveService(db, s.email, s.send, nil))
+	server := utils.NewFakeManageSieve(t)
+
+	script := &entities.SieveScript{Name: "filters", Source: filterScript, Active: true}
+	require.NoError(t, sieveService.Import(ctx, script))
+
+	err := sieveService.Upload(ctx, script.ID, services.ManageSieveServer{
+		Address:  server.Addr,
+		Username: server.Username,
+		Password: "wrong",
+		Security: managesieve.SecurityNone,
+	})
+	assert.Error(t, err)
+
+	require.NoError(t, sieveService.Upload(ctx, script.ID, services.ManageSieveServer{
+		Address:  server.Addr,
+		Username: server.Username,
+		Password: server.Password,
+		Security: managesieve.SecurityNone,
+	}))
+	source, ok := server.Script("filters")
+	require.True(t, ok)
+	assert.Equal(t, filterScript, source)
+	assert.Equal(t, "filters", server.Active())
+}
@@ -0,0 +1,373 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/mail"
+	"palm/src/entities"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smallstep/pkcs7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// testCA is a certification authority issuing S/MIME certificates
+type testCA struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{certificate: certificate, key: key}
+}
+
+// issue returns an RSA certificate for address, which S/MIME can both
+// sign and encrypt with
+func (ca *testCA) issue(t *testing.T, name, address string) (*x509.Certificate, *rsa.PrivateKey) {
+	return ca.issueValid(t, name, address, time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour))
+}
+
+// issueValid is issue for a certificate valid from notBefore to notAfter
+func (ca *testCA) issueValid(t *testing.T, name, address string, notBefore, notAfter time.Time) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: name},
+		EmailAddresses: []string{address},
+		NotBefore:      notBefore,
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, key.Public(), ca.key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return certificate, key
+}
+
+// pkcs12Identity returns a PKCS#12 file of a certificate, its key and its
+// issuer
+func (ca *testCA) pkcs12Identity(t *testing.T, certificate *x509.Certificate, key *rsa.PrivateKey, password string) []byte {
+	data, err := pkcs12.Modern.Encode(key, certificate, []*x509.Certificate{ca.certificate}, password)
+	require.NoError(t, err)
+	return data
+}
+
+func pemCertificate(certificate *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+}
+
+func TestSMIMEService_Store(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	smimeService := services.NewSMIMEService(db, x509.NewCertPool())
+
+	ca := newTestCA(t, "Example Corp CA")
+	bob, _ := ca.issue(t, "Bob", "Bob@Example.org")
+
+	// PEM and DER are both accepted
+	imported, err := smimeService.ImportCertificates(ctx, pemCertificate(ca.certificate))
+	require.NoError(t, err)
+	require.Len(t, imported, 1)
+	assert.True(t, imported[0].IsCA)
+	imported, err = smimeService.ImportCertificates(ctx, bob.Raw)
+	require.NoError(t, err)
+	require.Len(t, imported, 1)
+	require.Len(t, imported[0].Emails, 1)
+	assert.Equal(t, "bob@example.org", imported[0].Emails[0].Email)
+
+	_, err = smimeService.ImportCertificates(ctx, []byte("not a certificate"))
+	assert.ErrorIs(t, err, services.ErrInvalidCertificate)
+
+	// Fingerprints are accepted in any case and with colons
+	fingerprint := strings.ToLower(imported[0].Fingerprint[:2]) + ":" + imported[0].Fingerprint[2:]
+	exported, err := smimeService.ExportCertificate(ctx, fingerprint)
+	require.NoError(t, err)
+	assert.Equal(t, string(pemCertificate(bob)), exported)
+
+	alice, aliceKey := ca.issue(t, "Alice", "alice@example.com")
+	identityFile := ca.pkcs12Identity(t, alice, aliceKey, "s3cret")
+	_, err = smimeService.ImportIdentity(ctx, identityFile, "wrong")
+	assert.ErrorIs(t, err, services.ErrWrongPassword)
+	identity, err := smimeService.ImportIdentity(ctx, identityFile, "s3cret")
+	require.NoError(t, err)
+	assert.NotNil(t, identity.PKCS12)
+	assert.False(t, smimeService.IsLocked(identity), "identities stay unlocked for the session")
+
+	// A new session needs the password again
+	smimeService = services.NewSMIMEService(db, x509.NewCertPool())
+	assert.True(t, smimeService.IsLocked(identity))
+	assert.ErrorIs(t, smimeService.Unlock(ctx, identity.Fingerprint, "wrong"), services.ErrWrongPassword)
+	require.NoError(t, smimeService.Unlock(ctx, identity.Fingerprint, "s3cret"))
+	assert.False(t, smimeService.IsLocked(identity))
+	assert.ErrorIs(t, smimeService.Unlock(ctx, imported[0].Fingerprint, ""), services.ErrNotAnIdentity)
+
+	records, err := smimeService.ListCertificates(ctx)
+	require.NoError(t, err)
+	require.Len(t, records, 3, "the identity's issuer is already stored")
+	var caRecord *entities.SMIMECertificate
+	for _, record := range records {
+		if record.IsCA {
+			caRecord = record
+		}
+	}
+	require.NotNil(t, caRecord)
+	require.NoError(t, smimeService.SetTrustAnchor(ctx, caRecord.Fingerprint, true))
+
+	// Importing certificates again keeps the identity and the trust
+	_, err = smimeService.ImportCertificates(ctx, append(pemCertificate(ca.certificate), pemCertificate(alice)...))
+	require.NoError(t, err)
+	records, err = smimeService.ListCertificates(ctx)
+	require.NoError(t, err)
+	for _, record := range records {
+		switch record.Fingerprint {
+		case caRecord.Fingerprint:
+			assert.True(t, record.TrustAnchor)
+		case identity.Fingerprint:
+			assert.NotNil(t, record.PKCS12)
+		}
+	}
+
+	require.NoError(t, smimeService.DeleteCertificate(ctx, imported[0].Fingerprint))
+	assert.ErrorIs(t, smimeService.DeleteCertificate(ctx, imported[0].Fingerprint), services.ErrCertificateNotFound)
+}
+
+func TestSendService_SMIME(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+
+	accountRepo := sqlite.NewAccountRepository(db)
+	sender := createTestAccount(t, ctx, accountRepo, "alice@example.com")
+	receiver := createTestAccount(t, ctx, accountRepo, "bob@example.org")
+
+	// Both ends share the store: Alice signs, Bob decrypts
+	ca := newTestCA(t, "Example Corp CA")
+	alice, aliceKey := ca.issue(t, "Alice", "alice@example.com")
+	bob, bobKey := ca.issue(t, "Bob", "bob@example.org")
+	aliceIdentity, err := s.smime.ImportIdentity(ctx, ca.pkcs12Identity(t, alice, aliceKey, ""), "")
+	require.NoError(t, err)
+	_, err = s.smime.ImportIdentity(ctx, ca.pkcs12Identity(t, bob, bobKey, ""), "")
+	require.NoError(t, err)
+	caRecords, err := s.smime.ImportCertificates(ctx, pemCertificate(ca.certificate))
+	require.NoError(t, err)
+	require.NoError(t, s.smime.SetTrustAnchor(ctx, caRecords[0].Fingerprint, true))
+
+	result, err := s.send.Send(ctx, &services.OutgoingEmail{
+		AccountID: sender.ID,
+		To:        []*mail.Address{{Name: "Bob", Address: "bob@example.org"}},
+		Cc:        []*mail.Address{{Address: "carol@example.net"}},
+		Subject:   "Quarterly figures",
+		Text:      "Revenue is up 12%.",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob@example.org"}, result.Encrypted)
+	assert.True(t, result.Signed)
+	require.Len(t, s.transport.deliveries, 2)
+
+	encrypted := s.transport.deliveryTo(t, "bob@example.org")
+	assert.Contains(t, string(encrypted), "application/pkcs7-mime; name=smime.p7m; smime-type=enveloped-data")
+	assert.NotContains(t, string(encrypted), "Revenue")
+	plain := s.transport.deliveryTo(t, "carol@example.net")
+	assert.Contains(t, string(plain), "application/pkcs7-signature")
+
+	open := func(raw []byte) (*services.EmailDTO, *services.SMIMEStatus) {
+		email := s.receive(t, ctx, receiver.ID, raw)
+		status, err := s.smime.Open(ctx, email)
+		require.NoError(t, err)
+		return email, status
+	}
+
+	email, status := open(encrypted)
+	assert.True(t, status.Encrypted)
+	assert.True(t, status.Decrypted)
+	assert.NoError(t, status.DecryptError)
+	assert.Equal(t, services.SignatureGood, status.Signature, "signed, then encrypted")
+	assert.Equal(t, "CN=Alice", status.SignerSubject)
+	assert.Equal(t, "CN=Example Corp CA", status.SignerIssuer)
+	assert.True(t, status.Trusted)
+	assert.True(t, status.SignerMatches)
+	assert.Contains(t, *email.Message.Body, "Revenue is up 12%.")
+
+	_, status = open(plain)
+	assert.False(t, status.Encrypted)
+	assert.Equal(t, services.SignatureGood, status.Signature)
+	assert.True(t, status.Trusted)
+
+	_, status = open(bytes.Replace(plain, []byte("12%"), []byte("21%"), 1))
+	assert.Equal(t, services.SignatureBad, status.Signature, "altered content fails verification")
+	assert.False(t, status.Trusted)
+
+	// Without the trust anchor the signature is good but not vouched for
+	require.NoError(t, s.smime.SetTrustAnchor(ctx, caRecords[0].Fingerprint, false))
+	_, status = open(plain)
+	assert.Equal(t, services.SignatureGood, status.Signature)
+	assert.False(t, status.Trusted)
+	assert.Error(t, status.TrustError)
+	require.NoError(t, s.smime.SetTrustAnchor(ctx, caRecords[0].Fingerprint, true))
+
+	// Trusted signers' certificates are collected for replies
+	require.NoError(t, s.smime.DeleteCertificate(ctx, aliceIdentity.Fingerprint))
+	_, status = open(plain)
+	assert.True(t, status.Trusted)
+	ok, err := s.smime.CanEncrypt(ctx, []string{"alice@example.com"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	records, err := s.smime.ListCertificates(ctx)
+	require.NoError(t, err)
+	for _, record := range records {
+		if record.PKCS12 != nil {
+			require.NoError(t, s.smime.DeleteCertificate(ctx, record.Fingerprint))
+		}
+	}
+	_, status = open(encrypted)
+	assert.True(t, status.Encrypted)
+	assert.False(t, status.Decrypted)
+	assert.ErrorIs(t, status.DecryptError, services.ErrNoSMIMEDecryptionKey)
+}
+
+// smimeSignedContent is the part smimeSignature signs
+const smimeSignedContent = "Content-Type: text/plain; charset=utf-8\r\n\r\nThe minutes are attached."
+
+// smimeSignature returns a detached S/MIME signature of smimeSignedContent
+func smimeSignature(t *testing.T, certificate *x509.Certificate, key *rsa.PrivateKey) []byte {
+	sd, err := pkcs7.NewSignedData([]byte(smimeSignedContent))
+	require.NoError(t, err)
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	require.NoError(t, sd.AddSigner(certificate, key, pkcs7.SignerInfoConfig{}))
+	sd.Detach()
+	signature, err := sd.Finish()
+	require.NoError(t, err)
+	return signature
+}
+
+// smimeSigned returns a message dated date carrying smimeSignedContent and
+// its signature
+func smimeSigned(signature []byte, date time.Time) []byte {
+	return []byte(strings.Join([]string{
+		"From: Alice <alice@example.com>",
+		"To: bob@example.org",
+		"Subject: Minutes",
+		"Date: " + date.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/signed; protocol="application/pkcs7-signature"; micalg=sha-256; boundary="sig"`,
+		"",
+		"--sig",
+		smimeSignedContent,
+		"--sig",
+		"Content-Type: application/pkcs7-signature; name=smime.p7s",
+		"Content-Transfer-Encoding: base64",
+		"",
+		base64.StdEncoding.EncodeToString(signature),
+		"--sig--",
+		"",
+	}, "\r\n"))
+}
+
+func TestSMIMEService_VerifiesWhenReceived(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	receiver := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "bob@example.org")
+
+	ca := newTestCA(t, "Example Corp CA")
+	caRecords, err := s.smime.ImportCertificates(ctx, pemCertificate(ca.certificate))
+	require.NoError(t, err)
+	require.NoError(t, s.smime.SetTrustAnchor(ctx, caRecords[0].Fingerprint, true))
+
+	// Alice's certificate expires in a moment. She signs while it is
+	// valid, and her messages arrive before and after it expires.
+	expires := time.Now().Add(time.Second).Truncate(time.Second)
+	alice, aliceKey := ca.issueValid(t, "Alice", "alice@example.com", time.Now().Add(-time.Hour), expires)
+	signature := smimeSignature(t, alice, aliceKey)
+	early := smimeSigned(signature, time.Now())
+	time.Sleep(time.Until(expires) + time.Second)
+	late := smimeSigned(signature, time.Now())
+
+	// Mail received while it was valid stays trusted, but the certificate
+	// is not kept for replies
+	email := s.receive(t, ctx, receiver.ID, early)
+	status, err := s.smime.Open(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, services.SignatureGood, status.Signature)
+	assert.True(t, status.Trusted)
+	assert.NoError(t, status.TrustError)
+	records, err := s.smime.ListCertificates(ctx)
+	require.NoError(t, err)
+	assert.Len(t, records, 1, "only the trust anchor is stored")
+
+	// Mail received since is not, whatever signing time it claims
+	email = s.receive(t, ctx, receiver.ID, late)
+	status, err = s.smime.Open(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, services.SignatureGood, status.Signature)
+	assert.False(t, status.Trusted)
+	assert.Error(t, status.TrustError)
+}
+
+func TestSendService_PrefersOpenPGP(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newSendTestServices(t, db)
+	sender := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "alice@example.com")
+
+	// Bob has both a key and a certificate
+	ca := newTestCA(t, "Example Corp CA")
+	bob, _ := ca.issue(t, "Bob", "bob@example.org")
+	_, err := s.smime.ImportCertificates(ctx, bob.Raw)
+	require.NoError(t, err)
+	_, err = s.pgp.ImportKeys(ctx, armorEntity(t, newPGPEntity(t, "Bob", "bob@example.org"), false))
+	require.NoError(t, err)
+	carol, _ := ca.issue(t, "Carol", "carol@example.net")
+	_, err = s.smime.ImportCertificates(ctx, carol.Raw)
+	require.NoError(t, err)
+
+	result, err := s.send.Send(ctx, &services.OutgoingEmail{
+		AccountID: sender.ID,
+		To:        []*mail.Address{{Address: "bob@example.org"}, {Address: "carol@example.net"}},
+		Subject:   "Hello",
+		Text:      "Hello both",
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bob@example.org", "carol@example.net"}, result.Encrypted)
+	assert.False(t, result.Signed, "Alice has neither a key nor an identity")
+	assert.Contains(t, string(s.transport.deliveryTo(t, "bob@example.org")), "multipart/encrypted")
+	assert.Contains(t, string(s.transport.deliveryTo(t, "carol@example.net")), "application/pkcs7-mime")
+}
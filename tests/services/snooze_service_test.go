@@ -0,0 +1,218 @@
+package services_test
+
+import (
+	"context"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnoozePresets(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	names := func(presets []services.SnoozePreset) []string {
+		var names []string
+		for _, p := range presets {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	// A Wednesday morning
+	now := time.Date(2026, 10, 14, 9, 20, 0, 0, paris)
+	presets := services.SnoozePresets(now, paris)
+	assert.Equal(t, []string{
+		services.SnoozeLaterToday, services.SnoozeThisEvening, services.SnoozeTomorrowMorning,
+		services.SnoozeThisWeekend, services.SnoozeNextWeek,
+	}, names(presets))
+	assert.Equal(t, time.Date(2026, 10, 14, 13, 0, 0, 0, paris), presets[0].Until)
+	assert.Equal(t, time.Date(2026, 10, 14, 18, 0, 0, 0, paris), presets[1].Until)
+	assert.Equal(t, time.Date(2026, 10, 15, 8, 0, 0, 0, paris), presets[2].Until)
+	assert.Equal(t, time.Date(2026, 10, 17, 9, 0, 0, 0, paris), presets[3].Until)
+	assert.Equal(t, time.Date(2026, 10, 19, 8, 0, 0, 0, paris), presets[4].Until)
+
+	// Presets are computed in the user's time zone whatever now's zone
+	until, err := services.SnoozePresetTime(services.SnoozeTomorrowMorning, now.UTC(), paris)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 15, 8, 0, 0, 0, paris), until)
+
+	// Saturday night: the day is over and the weekend has come. Clocks go
+	// back on Sunday, so tomorrow morning is 25 hours after midnight.
+	now = time.Date(2026, 10, 24, 22, 0, 0, 0, paris)
+	presets = services.SnoozePresets(now, paris)
+	assert.Equal(t, []string{services.SnoozeTomorrowMorning, services.SnoozeNextWeek}, names(presets))
+	assert.Equal(t, time.Date(2026, 10, 25, 8, 0, 0, 0, paris), presets[0].Until)
+	assert.Equal(t, 11*time.Hour, presets[0].Until.Sub(now))
+	assert.Equal(t, time.Date(2026, 10, 26, 8, 0, 0, 0, paris), presets[1].Until)
+
+	// On Sunday next week is tomorrow
+	now = time.Date(2026, 10, 25, 10, 0, 0, 0, paris)
+	until, err = services.SnoozePresetTime(services.SnoozeNextWeek, now, paris)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 26, 8, 0, 0, 0, paris), until)
+
+	_, err = services.SnoozePresetTime(services.SnoozeThisEvening, time.Date(2026, 10, 14, 17, 30, 0, 0, paris), paris)
+	assert.ErrorIs(t, err, services.ErrUnknownSnoozePreset)
+	_, err = services.SnoozePresetTime("someday", now, paris)
+	assert.ErrorIs(t, err, services.ErrUnknownSnoozePreset)
+}
+
+func TestSnoozeService_SnoozeAndWake(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newRuleTestServices(t, db)
+	bus := events.NewBus()
+	snoozes := services.NewSnoozeService(db)
+	snoozes.SetEventBus(bus)
+	var woken []events.SnoozePayload
+	bus.Subscribe(events.TopicMessageWoken, func(e events.Event) {
+		woken = append(woken, e.Payload.(events.SnoozePayload))
+	})
+
+	email := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Tea", "", "See you at four."))
+	other := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Cake", "", "Bring cake."))
+	require.True(t, email.Message.IsRead)
+	require.NoError(t, db.Model(&entities.Message{}).Where("id = ?", other.Message.ID).Update("folder", "Archive").Error)
+
+	_, err := snoozes.Snooze(ctx, email.Message.ID, time.Now().Add(-time.Minute))
+	assert.ErrorIs(t, err, services.ErrSnoozeInPast)
+	_, err = snoozes.Snooze(ctx, 999999, time.Now().Add(time.Hour))
+	assert.ErrorIs(t, err, services.ErrEmailNotFound)
+
+	// Snoozed emails leave their folder
+	now := time.Now()
+	_, err = snoozes.Snooze(ctx, email.Message.ID, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	snooze, err := snoozes.Snooze(ctx, email.Message.ID, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, snooze.Folder, "snoozing again keeps the original folder")
+	assert.Equal(t, s.account.ID, snooze.Message.AccountID)
+	assert.Equal(t, "Tea", *snooze.Message.Subject)
+	assert.Equal(t, "charles@engines.example", snooze.Message.SenderEmail)
+	_, err = snoozes.Snooze(ctx, other.Message.ID, now.Add(3*time.Hour))
+	require.NoError(t, err)
+
+	inbox, err := s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{Folder: new(string)},
+	}, 20, 1)
+	require.NoError(t, err)
+	assert.Empty(t, inbox.Emails)
+
+	// Snoozed emails are hidden from listings that do not ask for a folder
+	all, err := s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{}, 20, 1)
+	require.NoError(t, err)
+	assert.Empty(t, all.Emails)
+	unified, err := s.email.ListUnified(ctx, nil, 20, 1)
+	require.NoError(t, err)
+	assert.Empty(t, unified.Emails)
+	all, err = s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{AllFolders: true},
+	}, 20, 1)
+	require.NoError(t, err)
+	assert.Len(t, all.Emails, 2)
+
+	list, err := snoozes.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, email.Message.ID, list[0].MessageID)
+	assert.Equal(t, "Tea", *list[0].Message.Subject)
+	assert.Equal(t, other.Message.ID, list[1].MessageID)
+
+	// Nothing is due yet
+	n, err := snoozes.WakeDue(ctx, now)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	n, err = snoozes.WakeDue(ctx, now.Add(90*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	stored, err := s.email.GetByID(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Message.Folder)
+	assert.False(t, stored.Message.IsRead)
+	all, err = s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{}, 20, 1)
+	require.NoError(t, err)
+	require.Len(t, all.Emails, 1)
+	assert.Equal(t, email.Message.ID, all.Emails[0].Message.ID)
+	require.Len(t, woken, 1)
+	assert.Equal(t, events.SnoozePayload{
+		MessageID: email.Message.ID,
+		AccountID: s.account.ID,
+		Subject:   "Tea",
+		Sender:    "charles@engines.example",
+	}, woken[0])
+
+	// Unsnoozing returns the email to its folder as it was
+	require.NoError(t, snoozes.Unsnooze(ctx, other.Message.ID))
+	stored, err = s.email.GetByID(ctx, other.Message.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Archive", stored.Message.Folder)
+	assert.True(t, stored.Message.IsRead)
+	assert.Len(t, woken, 1)
+	assert.ErrorIs(t, snoozes.Unsnooze(ctx, other.Message.ID), services.ErrNotSnoozed)
+
+	// Deleting a snoozed email deletes its snooze
+	_, err = snoozes.Snooze(ctx, other.Message.ID, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, s.email.Delete(ctx, int64(other.Message.ID)))
+	list, err = snoozes.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestSnoozeService_Run(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s := newRuleTestServices(t, db)
+	email := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Tea", "", "See you at four."))
+	late := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Cake", "", "Bring cake."))
+
+	// A snooze that ended while the app was closed
+	_, err := services.NewSnoozeService(db).Snooze(ctx, late.Message.ID, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+
+	bus := events.NewBus()
+	woken := make(chan uint, 2)
+	bus.Subscribe(events.TopicMessageWoken, func(e events.Event) {
+		woken <- e.Payload.(events.SnoozePayload).MessageID
+	})
+	snoozes := services.NewSnoozeService(db)
+	snoozes.SetEventBus(bus)
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		snoozes.Run(runCtx)
+		close(done)
+	}()
+	defer func() {
+		stop()
+		<-done
+	}()
+
+	wait := func() uint {
+		select {
+		case id := <-woken:
+			return id
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message woke")
+			return 0
+		}
+	}
+	assert.Equal(t, late.Message.ID, wait())
+
+	// Snoozing while the scheduler sleeps wakes it to reschedule
+	_, err = snoozes.Snooze(ctx, email.Message.ID, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, email.Message.ID, wait())
+	stored, err := s.email.GetByID(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Message.Folder)
+}
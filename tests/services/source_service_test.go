@@ -0,0 +1,88 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"palm/src/entities"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceService_StoredSource(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService, archiveService := newArchiveTestServices(t, db)
+	sourceService := services.NewSourceService(db, emailService, nil)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "bob@example.org")
+
+	original, err := os.ReadFile(filepath.Join("testdata", "invoice.eml"))
+	require.NoError(t, err)
+	email, err := archiveService.ImportEML(ctx, account.ID, bytes.NewReader(original))
+	require.NoError(t, err)
+
+	var stored entities.MessageSource
+	require.NoError(t, db.Where("message_id = ?", email.Message.ID).First(&stored).Error)
+	assert.Equal(t, entities.SourceEncodingGzip, stored.Encoding)
+	assert.Equal(t, uint(len(original)), stored.Size)
+	assert.NotEqual(t, original, stored.Data, "the source is stored compressed")
+
+	source, err := sourceService.Source(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.False(t, source.Reconstructed)
+	assert.Equal(t, original, source.Data)
+
+	headers, err := sourceService.Headers(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.False(t, headers.Reconstructed)
+	require.Len(t, headers.Fields, 13)
+	assert.Equal(t, "Return-Path", headers.Fields[0].Name)
+	assert.Equal(t, "from mx.supplier.example (mx.supplier.example [192.0.2.10]) by mail.example.org with ESMTPS id 4QxYz; Tue, 05 Mar 2024 10:15:42 +0100",
+		headers.Fields.Get("Received"), "folded fields are unfolded")
+	assert.Equal(t, "=?UTF-8?Q?Invoice_=E2=84=96_2024-031?=", headers.Fields.Get("Subject"), "values are kept as they appear in the source")
+
+	// Deleting the email removes its source and headers
+	require.NoError(t, emailService.Delete(ctx, int64(email.Message.ID)))
+	var count int64
+	require.NoError(t, db.Model(&entities.MessageSource{}).Count(&count).Error)
+	assert.Zero(t, count)
+	require.NoError(t, db.Model(&entities.MessageHeader{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestSourceService_ReconstructedSource(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	sourceService := services.NewSourceService(db, emailService, nil)
+	account := createTestAccount(t, ctx, sqlite.NewAccountRepository(db), "bob@example.org")
+
+	// Emails synced without their source are rebuilt on request
+	email := createEmailDTO(account.ID, "Rebuilt")
+	require.NoError(t, emailService.Create(ctx, email))
+
+	source, err := sourceService.Source(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.True(t, source.Reconstructed)
+	assert.Contains(t, string(source.Data), "Subject: Rebuilt\r\n")
+
+	headers, err := sourceService.Headers(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.True(t, headers.Reconstructed)
+	assert.Equal(t, "Rebuilt", headers.Fields.Get("Subject"))
+	assert.True(t, headers.Fields.Has("Message-ID"))
+
+	_, err = sourceService.Source(ctx, 9999)
+	assert.ErrorIs(t, err, services.ErrEmailNotFound)
+}
@@ -0,0 +1,259 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"palm/src/entities"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const spamCorpus = "testdata/spam"
+
+func TestEvaluateSpamClassifier(t *testing.T) {
+	samples, err := services.LoadSpamCorpus(spamCorpus)
+	require.NoError(t, err)
+	require.Len(t, samples, 48)
+
+	evaluation, err := services.EvaluateSpamClassifier(samples, services.DefaultSpamFolds, services.DefaultSpamThreshold)
+	require.NoError(t, err)
+	t.Logf("precision %.2f, recall %.2f, accuracy %.2f, misclassified %v",
+		evaluation.Precision(), evaluation.Recall(), evaluation.Accuracy(), evaluation.Misclassified)
+
+	assert.Zero(t, evaluation.Unsure)
+	assert.Equal(t, len(samples), evaluation.TruePositives+evaluation.FalsePositives+
+		evaluation.TrueNegatives+evaluation.FalseNegatives)
+	// Wanted mail must not be moved aside; some spam may get through
+	assert.Zero(t, evaluation.FalsePositives)
+	assert.GreaterOrEqual(t, evaluation.Recall(), 0.8)
+
+	// Folds without enough training leave their samples unscored
+	evaluation, err = services.EvaluateSpamClassifier(samples[:30], 2, services.DefaultSpamThreshold)
+	require.NoError(t, err)
+	assert.Equal(t, 30, evaluation.Unsure)
+
+	_, err = services.EvaluateSpamClassifier(samples, 1, services.DefaultSpamThreshold)
+	assert.Error(t, err)
+	_, err = services.EvaluateSpamClassifier(samples, 5, 0)
+	assert.ErrorIs(t, err, services.ErrInvalidSpamThreshold)
+}
+
+func TestSpamFilter_TrainAndClassify(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	filter := subscribe(bus, services.NewSpamFilter(db, s.email, nil))
+
+	samples, err := services.LoadSpamCorpus(spamCorpus)
+	require.NoError(t, err)
+	var spam, ham []services.SpamSample
+	for _, sample := range samples {
+		if sample.Spam {
+			spam = append(spam, sample)
+		} else {
+			ham = append(ham, sample)
+		}
+	}
+
+	// Nothing is scored before the classifier is trained
+	email := s.deliver(t, ctx, string(spam[0].Raw))
+	require.NotNil(t, email)
+	assert.Empty(t, email.Message.Folder)
+	verdict, err := filter.Classify(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.Nil(t, verdict.Score)
+
+	// Training moves spam aside
+	trained := map[string]uint{}
+	for i := 0; i < 18; i++ {
+		for _, sample := range []services.SpamSample{spam[i], ham[i]} {
+			id := email.Message.ID
+			if i > 0 || sample.Name != spam[0].Name {
+				id = s.deliver(t, ctx, string(sample.Raw)).Message.ID
+			}
+			require.NoError(t, filter.Train(ctx, id, sample.Spam))
+			trained[sample.Name] = id
+		}
+	}
+	status, err := filter.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 18, status.SpamMessages)
+	assert.Equal(t, 18, status.HamMessages)
+	assert.True(t, status.Ready)
+	assert.Positive(t, status.Tokens)
+	assert.Equal(t, services.DefaultSpamThreshold, status.Threshold)
+
+	stored, err := s.email.GetByID(ctx, trained[spam[0].Name])
+	require.NoError(t, err)
+	assert.Equal(t, services.SpamFolder, stored.Message.Folder)
+	stored, err = s.email.GetByID(ctx, trained[ham[0].Name])
+	require.NoError(t, err)
+	assert.Empty(t, stored.Message.Folder)
+
+	// Spam is hidden from listings that do not ask for its folder
+	listed := func(list *services.PaginatedEmailsResult, id uint) bool {
+		for _, email := range list.Emails {
+			if email.Message.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	all, err := s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{}, 100, 1)
+	require.NoError(t, err)
+	assert.False(t, listed(all, trained[spam[0].Name]))
+	assert.True(t, listed(all, trained[ham[0].Name]))
+	unified, err := s.email.ListUnified(ctx, nil, 100, 1)
+	require.NoError(t, err)
+	assert.False(t, listed(unified, trained[spam[0].Name]))
+	folder := services.SpamFolder
+	all, err = s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{Folder: &folder},
+	}, 100, 1)
+	require.NoError(t, err)
+	assert.True(t, listed(all, trained[spam[0].Name]))
+
+	// New messages are scored as they arrive
+	caught := 0
+	for _, sample := range spam[18:] {
+		email := s.deliver(t, ctx, string(sample.Raw))
+		require.NotNil(t, email)
+		if email.Message.Folder == services.SpamFolder {
+			caught++
+		}
+	}
+	assert.GreaterOrEqual(t, caught, 5)
+	for _, sample := range ham[18:] {
+		email := s.deliver(t, ctx, string(sample.Raw))
+		require.NotNil(t, email)
+		assert.Empty(t, email.Message.Folder, sample.Name)
+
+		var verdict entities.SpamVerdict
+		require.NoError(t, db.Where("message_id = ?", email.Message.ID).First(&verdict).Error)
+		require.NotNil(t, verdict.Score)
+		assert.Less(t, *verdict.Score, 0.5, sample.Name)
+	}
+
+	// Marking a message not spam unlearns it as spam and moves it back
+	id := trained[spam[1].Name]
+	require.NoError(t, filter.Train(ctx, id, false))
+	require.NoError(t, filter.Train(ctx, id, false), "training twice counts once")
+	status, err = filter.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 17, status.SpamMessages)
+	assert.Equal(t, 19, status.HamMessages)
+	stored, err = s.email.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Message.Folder)
+
+	// Labelled messages are not scored again
+	verdict, err = filter.Classify(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, entities.SpamLabelHam, verdict.Label)
+	stored, err = s.email.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, stored.Message.Folder)
+
+	// Deleting a message keeps what was learned from it
+	require.NoError(t, s.email.Delete(ctx, int64(id)))
+	status, err = filter.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 19, status.HamMessages)
+
+	assert.ErrorIs(t, filter.Train(ctx, id, true), services.ErrEmailNotFound)
+}
+
+// trainSpamFilter delivers and trains the first n spam and ham samples of
+// the corpus, returning the samples
+func trainSpamFilter(t *testing.T, ctx context.Context, s *ruleTestServices, filter *services.SpamFilter, n int) (spam, ham []services.SpamSample) {
+	samples, err := services.LoadSpamCorpus(spamCorpus)
+	require.NoError(t, err)
+	for _, sample := range samples {
+		if sample.Spam {
+			spam = append(spam, sample)
+		} else {
+			ham = append(ham, sample)
+		}
+	}
+	for i := 0; i < n; i++ {
+		for _, sample := range []services.SpamSample{spam[i], ham[i]} {
+			email := s.deliver(t, ctx, string(sample.Raw))
+			require.NotNil(t, email)
+			require.NoError(t, filter.Train(ctx, email.Message.ID, sample.Spam))
+		}
+	}
+	return spam, ham
+}
+
+func TestSpamFilter_SkipsImports(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	filter := subscribe(bus, services.NewSpamFilter(db, s.email, nil))
+	spam, _ := trainSpamFilter(t, ctx, s, filter, 18)
+
+	// Imported spam stays where the archive had it, unscored
+	for _, sample := range spam[18:] {
+		email, err := s.archive.ImportEML(ctx, s.account.ID, bytes.NewReader(sample.Raw))
+		require.NoError(t, err)
+		stored, err := s.email.GetByID(ctx, email.Message.ID)
+		require.NoError(t, err)
+		assert.Empty(t, stored.Message.Folder, sample.Name)
+		var verdicts int64
+		require.NoError(t, db.Model(&entities.SpamVerdict{}).Where("message_id = ?", email.Message.ID).Count(&verdicts).Error)
+		assert.Zero(t, verdicts, sample.Name)
+	}
+}
+
+func TestSpamFilter_Threshold(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	filter := subscribe(bus, services.NewSpamFilter(db, s.email, nil))
+	spam, _ := trainSpamFilter(t, ctx, s, filter, 18)
+
+	email, err := s.archive.ImportEML(ctx, s.account.ID, bytes.NewReader(spam[18].Raw))
+	require.NoError(t, err)
+	classify := func(threshold float64) string {
+		require.NoError(t, filter.SetThreshold(ctx, threshold))
+		_, err := filter.Classify(ctx, email.Message.ID)
+		require.NoError(t, err)
+		stored, err := s.email.GetByID(ctx, email.Message.ID)
+		require.NoError(t, err)
+		return stored.Message.Folder
+	}
+
+	// Scored under the highest threshold to learn its score
+	require.Empty(t, classify(1))
+	verdict, err := filter.Classify(ctx, email.Message.ID)
+	require.NoError(t, err)
+	require.NotNil(t, verdict.Score)
+	score := *verdict.Score
+	require.Positive(t, score)
+	require.Less(t, score, 1.0)
+
+	// A score just under the threshold stays, one reaching it moves
+	assert.Empty(t, classify(math.Nextafter(score, 1)))
+	assert.Equal(t, services.SpamFolder, classify(score))
+}
+
+func TestSpamFilter_SetThreshold(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	filter := subscribe(bus, services.NewSpamFilter(db, s.email, nil))
+
+	require.NoError(t, filter.SetThreshold(ctx, 0.75))
+	status, err := filter.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0.75, status.Threshold)
+	assert.False(t, status.Ready)
+
+	assert.ErrorIs(t, filter.SetThreshold(ctx, 0), services.ErrInvalidSpamThreshold)
+	assert.ErrorIs(t, filter.SetThreshold(ctx, 1.5), services.ErrInvalidSpamThreshold)
+}
@@ -0,0 +1,222 @@
+package services_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"palm/src/entities"
+	"palm/src/services"
+	"palm/tests/utils"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listMessage returns a message to the account received at date, unread
+// unless read is set
+func listMessage(from, subject, date, listHeaders string, read bool) string {
+	status := "O"
+	if read {
+		status = "RO"
+	}
+	return "From: " + from + "\r\n" +
+		"To: Ada Lovelace <ada@engines.example>\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + date + "\r\n" +
+		"Status: " + status + "\r\n" +
+		listHeaders +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"Hello\r\n"
+}
+
+// unsubscribeServer records the one-click requests it receives
+type unsubscribeServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string // Method, content type and body of each request
+}
+
+func newUnsubscribeServer(t *testing.T) *unsubscribeServer {
+	s := &unsubscribeServer{}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.Header.Get("Content-Type")+" "+string(body))
+		s.mu.Unlock()
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/unsubscribe", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSubscriptionService_Detect(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	s, bus := newDeliveryTestServices(t, db)
+	subscriptions := subscribe(bus, services.NewSubscriptionService(db, s.send, nil))
+
+	nutsHeaders := "List-Id: Go Nuts <Golang-Nuts.googlegroups.example>\r\n" +
+		"List-Unsubscribe: <mailto:golang-nuts+unsubscribe@googlegroups.example>\r\n"
+	first := s.deliver(t, ctx, listMessage("Rob <rob@golang.example>", "Generics",
+		"Mon, 02 Nov 2026 09:00:00 +0000", nutsHeaders, true))
+	second := s.deliver(t, ctx, listMessage("Ian <ian@golang.example>", "Re: Generics",
+		"Tue, 03 Nov 2026 09:00:00 +0000", nutsHeaders, false))
+	// Newsletters without List-Id are grouped by sender
+	newsletter := s.deliver(t, ctx, listMessage("Engine Weekly <news@weekly.example>", "Issue 12",
+		"Mon, 02 Nov 2026 10:00:00 +0000",
+		"List-Unsubscribe: <https://weekly.example/u/12>, <mailto:leave@weekly.example?subject=stop>\r\n"+
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n", true))
+	plain := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Tea", "", "See you at four."))
+
+	require.NotNil(t, first.Message.MailingListID)
+	assert.Equal(t, first.Message.MailingListID, second.Message.MailingListID)
+	require.NotNil(t, newsletter.Message.MailingListID)
+	assert.NotEqual(t, *first.Message.MailingListID, *newsletter.Message.MailingListID)
+	assert.Nil(t, plain.Message.MailingListID)
+
+	list, err := subscriptions.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	nuts := list[0]
+	assert.Equal(t, "golang-nuts.googlegroups.example", nuts.List.ListID)
+	assert.Equal(t, "Go Nuts", nuts.List.Name)
+	assert.Equal(t, "mailto:golang-nuts+unsubscribe@googlegroups.example", nuts.List.UnsubscribeMailto)
+	assert.False(t, nuts.List.OneClick)
+	assert.Equal(t, 2, nuts.Messages)
+	assert.Equal(t, 1, nuts.Unread)
+	require.NotNil(t, nuts.LastReceived)
+	assert.Equal(t, 3, nuts.LastReceived.UTC().Day())
+	require.NotNil(t, nuts.LastRead)
+	assert.Equal(t, 2, nuts.LastRead.UTC().Day())
+
+	weekly := list[1]
+	assert.Equal(t, "news@weekly.example", weekly.List.ListID)
+	assert.Equal(t, "Engine Weekly", weekly.List.Name)
+	assert.Equal(t, "https://weekly.example/u/12", weekly.List.UnsubscribeURL)
+	assert.Equal(t, "mailto:leave@weekly.example?subject=stop", weekly.List.UnsubscribeMailto)
+	assert.True(t, weekly.List.OneClick)
+	assert.Equal(t, 1, weekly.Messages)
+	assert.Zero(t, weekly.Unread)
+
+	// An older issue does not replace the newer unsubscribe address
+	s.deliver(t, ctx, listMessage("Engine Weekly <news@weekly.example>", "Issue 11",
+		"Mon, 26 Oct 2026 10:00:00 +0000", "List-Unsubscribe: <https://weekly.example/u/11>\r\n", false))
+	list, err = subscriptions.List(ctx, s.account.ID)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "https://weekly.example/u/12", list[0].List.UnsubscribeURL)
+	assert.True(t, list[0].List.OneClick)
+
+	// Emails are listed by mailing list
+	result, err := s.email.ListWithOptions(ctx, s.account.ID, services.ListOptions{
+		Filter: services.EmailFilter{MailingListID: first.Message.MailingListID},
+	}, 20, 1)
+	require.NoError(t, err)
+	require.Len(t, result.Emails, 2)
+	assert.Equal(t, second.Message.ID, result.Emails[0].Message.ID)
+	assert.Equal(t, first.Message.ID, result.Emails[1].Message.ID)
+}
+
+func TestSubscriptionService_DetectMissing(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	// Messages stored before lists were detected
+	s := newRuleTestServices(t, db)
+	email := s.deliver(t, ctx, listMessage("Rob <rob@golang.example>", "Generics",
+		"Mon, 02 Nov 2026 09:00:00 +0000", "List-Id: <golang-nuts.googlegroups.example>\r\n", true))
+	s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Tea", "", "See you at four."))
+	require.Nil(t, email.Message.MailingListID)
+
+	subscriptions := services.NewSubscriptionService(db, s.send, nil)
+	grouped, err := subscriptions.DetectMissing(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, grouped)
+
+	stored, err := s.email.GetByID(ctx, email.Message.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.Message.MailingListID)
+
+	grouped, err = subscriptions.DetectMissing(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, grouped)
+}
+
+func TestSubscriptionService_Unsubscribe(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+	server := newUnsubscribeServer(t)
+	s, bus := newDeliveryTestServices(t, db)
+	subscriptions := subscribe(bus, services.NewSubscriptionService(db, s.send, server.Client()))
+
+	t.Run("one-click", func(t *testing.T) {
+		email := s.deliver(t, ctx, listMessage("Engine Weekly <news@weekly.example>", "Issue 12",
+			"Mon, 02 Nov 2026 10:00:00 +0000",
+			"List-Unsubscribe: <mailto:leave@weekly.example>, <"+server.URL+"/unsubscribe?u=ada>\r\n"+
+				"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n", true))
+
+		result, err := subscriptions.Unsubscribe(ctx, email.Message.ID)
+		require.NoError(t, err)
+		assert.Equal(t, services.UnsubscribeOneClick, result.Method)
+		assert.Equal(t, []string{"POST application/x-www-form-urlencoded List-Unsubscribe=One-Click"}, server.requests)
+		assert.Empty(t, s.transport.deliveries)
+
+		var list entities.MailingList
+		require.NoError(t, db.First(&list, *email.Message.MailingListID).Error)
+		assert.NotNil(t, list.UnsubscribedAt)
+	})
+
+	t.Run("redirects are not followed", func(t *testing.T) {
+		server.requests = nil
+		email := s.deliver(t, ctx, listMessage("Tea Club <club@tea.example>", "Menu",
+			"Mon, 02 Nov 2026 11:00:00 +0000",
+			"List-Unsubscribe: <"+server.URL+"/moved>\r\n"+
+				"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n", true))
+
+		_, err := subscriptions.Unsubscribe(ctx, email.Message.ID)
+		assert.ErrorContains(t, err, "302")
+		assert.Len(t, server.requests, 1)
+	})
+
+	t.Run("mailto", func(t *testing.T) {
+		email := s.deliver(t, ctx, listMessage("Rob <rob@golang.example>", "Generics",
+			"Mon, 02 Nov 2026 09:00:00 +0000",
+			"List-Id: Go Nuts <golang-nuts.googlegroups.example>\r\n"+
+				"List-Unsubscribe: <mailto:golang-nuts+unsubscribe@googlegroups.example?subject=leave%20list>\r\n", true))
+
+		result, err := subscriptions.Unsubscribe(ctx, email.Message.ID)
+		require.NoError(t, err)
+		assert.Equal(t, services.UnsubscribeMailto, result.Method)
+		raw := string(s.transport.deliveryTo(t, "golang-nuts+unsubscribe@googlegroups.example"))
+		assert.Contains(t, raw, "Subject: leave list")
+		assert.Contains(t, raw, "ada@engines.example")
+	})
+
+	t.Run("web page", func(t *testing.T) {
+		email := s.deliver(t, ctx, listMessage("Shop <deals@shop.example>", "Sale",
+			"Mon, 02 Nov 2026 12:00:00 +0000", "List-Unsubscribe: <https://shop.example/prefs>\r\n", true))
+
+		result, err := subscriptions.Unsubscribe(ctx, email.Message.ID)
+		require.NoError(t, err)
+		assert.Equal(t, services.UnsubscribeWeb, result.Method)
+		assert.Equal(t, "https://shop.example/prefs", result.URL)
+		assert.Nil(t, result.List.UnsubscribedAt)
+	})
+
+	t.Run("not from a list", func(t *testing.T) {
+		email := s.deliver(t, ctx, ruleMessage("Charles <charles@engines.example>", "Tea", "", "See you at four."))
+
+		_, err := subscriptions.Unsubscribe(ctx, email.Message.ID)
+		assert.ErrorIs(t, err, services.ErrMailingListNotFound)
+		_, err = subscriptions.Unsubscribe(ctx, 999999)
+		assert.ErrorIs(t, err, services.ErrEmailNotFound)
+	})
+}
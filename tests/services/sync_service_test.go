@@ -0,0 +1,146 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"palm/src/entities"
+	"palm/src/events"
+	"palm/src/repositories/sqlite"
+	"palm/src/services"
+	"palm/tests/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMailSource emits a fixed set of emails and then returns err
+type fakeMailSource struct {
+	subjects []string
+	err      error
+}
+
+func (f *fakeMailSource) Fetch(ctx context.Context, account *entities.Account, emit func(*services.EmailDTO) error) error {
+	for _, subject := range f.subjects {
+		if err := emit(createEmailDTO(0, subject)); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+func TestSyncService_SyncAccount(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	accountRepo := sqlite.NewAccountRepository(db)
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	syncService := services.NewSyncService(accountRepo, emailService)
+
+	account := createTestAccount(t, ctx, accountRepo, "sync-test@example.com")
+
+	// No source registered for the account type
+	_, err := syncService.SyncAccount(ctx, account.ID)
+	assert.ErrorIs(t, err, services.ErrNoSyncSource)
+
+	syncService.RegisterSource(entities.AccountTypeGoogle, &fakeMailSource{subjects: []string{"One", "Two"}})
+
+	result, err := syncService.SyncAccount(ctx, account.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Fetched)
+	assert.Equal(t, 2, result.Created)
+	assert.Equal(t, 0, result.Failed)
+
+	// Emitted emails are stored under the synced account
+	count, err := emailService.ListCount(ctx, account.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// Unknown account
+	_, err = syncService.SyncAccount(ctx, 9999)
+	assert.Error(t, err)
+}
+
+func TestSyncService_SyncAll(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	accountRepo := sqlite.NewAccountRepository(db)
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	syncService := services.NewSyncService(accountRepo, emailService)
+
+	google := createTestAccount(t, ctx, accountRepo, "sync-google@example.com")
+	microsoft := &entities.Account{Email: "sync-ms@example.com", AccountType: entities.AccountTypeMicrosoft}
+	require.NoError(t, accountRepo.Create(ctx, microsoft).Error)
+	maildir := &entities.Account{Email: "sync-maildir@example.com", AccountType: entities.AccountTypeMaildir}
+	require.NoError(t, accountRepo.Create(ctx, maildir).Error)
+
+	sourceErr := errors.New("connection reset")
+	syncService.RegisterSource(entities.AccountTypeGoogle, &fakeMailSource{subjects: []string{"Hello"}})
+	syncService.RegisterSource(entities.AccountTypeMicrosoft, &fakeMailSource{subjects: []string{"Partial"}, err: sourceErr})
+
+	// A failing account does not stop the others
+	results, err := syncService.SyncAll(ctx)
+	assert.ErrorIs(t, err, sourceErr)
+	require.Len(t, results, 2)
+
+	// Accounts no source can sync fail rather than being skipped
+	assert.ErrorIs(t, err, services.ErrNoSyncSource)
+	assert.ErrorContains(t, err, "no sync source for account type Local Maildir")
+
+	count, err := emailService.ListCount(ctx, google.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = emailService.ListCount(ctx, microsoft.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestSyncService_PublishesEvents(t *testing.T) {
+	db := utils.SetupTestDB(t)
+	ctx := context.Background()
+
+	accountRepo := sqlite.NewAccountRepository(db)
+	emailService := services.NewEmailService(db,
+		sqlite.NewMessageRepository(db),
+		sqlite.NewRecipientRepository(db),
+		sqlite.NewAttachmentRepository(db))
+	syncService := services.NewSyncService(accountRepo, emailService)
+
+	bus := events.NewBus()
+	emailService.SetEventBus(bus)
+	syncService.SetEventBus(bus)
+
+	var topics []events.Topic
+	bus.SubscribeAll(func(e events.Event) { topics = append(topics, e.Topic) })
+
+	account := createTestAccount(t, ctx, accountRepo, "sync-events@example.com")
+	syncService.RegisterSource(entities.AccountTypeGoogle, &fakeMailSource{subjects: []string{"One"}})
+
+	_, err := syncService.SyncAccount(ctx, account.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []events.Topic{
+		events.TopicSyncStarted,
+		events.TopicMessageCreated,
+		events.TopicSyncProgress,
+		events.TopicSyncFinished,
+	}, topics)
+
+	// Rejected credentials are reported as an expired account
+	topics = nil
+	syncService.RegisterSource(entities.AccountTypeGoogle, &fakeMailSource{err: services.ErrAuthExpired})
+	_, err = syncService.SyncAccount(ctx, account.ID)
+	assert.ErrorIs(t, err, services.ErrAuthExpired)
+	assert.Equal(t, []events.Topic{
+		events.TopicSyncStarted,
+		events.TopicAccountAuthExpired,
+		events.TopicSyncFailed,
+	}, topics)
+}
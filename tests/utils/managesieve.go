@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"palm/src/formats/sieve"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// FakeManageSieve is a ManageSieve server on the loopback interface that
+// stores scripts in memory. It offers STARTTLS with a self-signed
+// certificate and accepts PLAIN logins with Username and Password.
+type FakeManageSieve struct {
+	Addr     string
+	Username string
+	Password string
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	roots     *x509.CertPool
+
+	mu      sync.Mutex
+	scripts map[string]string
+	active  string
+}
+
+// NewFakeManageSieve starts a fake server, stopped when the test ends
+func NewFakeManageSieve(t *testing.T) *FakeManageSieve {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sieve.test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &FakeManageSieve{
+		Addr:     listener.Addr().String(),
+		Username: "ada",
+		Password: "analytical",
+		listener: listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}}},
+		roots:   x509.NewCertPool(),
+		scripts: map[string]string{},
+	}
+	f.roots.AddCert(certificate)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// ClientTLSConfig returns a client configuration trusting the server's
+// certificate
+func (f *FakeManageSieve) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: f.roots}
+}
+
+// Script returns a stored script
+func (f *FakeManageSieve) Script(name string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	source, ok := f.scripts[name]
+	return source, ok
+}
+
+// Active returns the name of the active script, or ""
+func (f *FakeManageSieve) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+// serve runs one session
+func (f *FakeManageSieve) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	secure, authenticated := false, false
+
+	capabilities := func() {
+		fmt.Fprint(w, "\"IMPLEMENTATION\" \"Palm fake\"\r\n")
+		fmt.Fprint(w, "\"SASL\" \"PLAIN\"\r\n")
+		fmt.Fprintf(w, "\"SIEVE\" %q\r\n", strings.Join(sieve.Extensions, " "))
+		if !secure {
+			fmt.Fprint(w, "\"STARTTLS\"\r\n")
+		}
+		fmt.Fprint(w, "\"VERSION\" \"1.0\"\r\nOK\r\n")
+		w.Flush()
+	}
+	reply := func(format string, args ...any) {
+		fmt.Fprintf(w, format+"\r\n", args...)
+		w.Flush()
+	}
+	capabilities()
+
+	for {
+		command, args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if !authenticated && command != "STARTTLS" && command != "AUTHENTICATE" && command != "LOGOUT" {
+			reply(`NO "Authenticate first"`)
+			continue
+		}
+
+		f.mu.Lock()
+		switch {
+		case command == "STARTTLS" && !secure:
+			reply("OK")
+			tlsConn := tls.Server(conn, f.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				f.mu.Unlock()
+				return
+			}
+			conn, secure = tlsConn, true
+			r, w = bufio.NewReader(conn), bufio.NewWriter(conn)
+			capabilities()
+		case command == "AUTHENTICATE" && len(args) == 2 && strings.EqualFold(args[0], "PLAIN"):
+			credentials, _ := base64.StdEncoding.DecodeString(args[1])
+			if string(credentials) == "\x00"+f.Username+"\x00"+f.Password {
+				authenticated = true
+				reply("OK")
+			} else {
+				reply(`NO "Authentication failed"`)
+			}
+		case command == "CHECKSCRIPT" && len(args) == 1:
+			if _, err := sieve.Parse(args[0]); err != nil {
+				reply("NO %q", err.Error())
+			} else {
+				reply("OK")
+			}
+		case command == "PUTSCRIPT" && len(args) == 2:
+			if _, err := sieve.Parse(args[1]); err != nil {
+				reply("NO %q", err.Error())
+			} else {
+				f.scripts[args[0]] = args[1]
+				reply("OK")
+			}
+		case command == "SETACTIVE" && len(args) == 1:
+			if _, ok := f.scripts[args[0]]; !ok && args[0] != "" {
+				reply(`NO (NONEXISTENT) "No such script"`)
+			} else {
+				f.active = args[0]
+				reply("OK")
+			}
+		case command == "LISTSCRIPTS":
+			names := make([]string, 0, len(f.scripts))
+			for name := range f.scripts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if name == f.active {
+					fmt.Fprintf(w, "%q ACTIVE\r\n", name)
+				} else {
+					fmt.Fprintf(w, "%q\r\n", name)
+				}
+			}
+			reply("OK")
+		case command == "GETSCRIPT" && len(args) == 1:
+			if source, ok := f.scripts[args[0]]; ok {
+				reply("{%d}\r\n%s\r\nOK", len(source), source)
+			} else {
+				reply(`NO (NONEXISTENT) "No such script"`)
+			}
+		case command == "DELETESCRIPT" && len(args) == 1:
+			if args[0] == f.active {
+				reply(`NO (ACTIVE) "Script is active"`)
+			} else {
+				delete(f.scripts, args[0])
+				reply("OK")
+			}
+		case command == "LOGOUT":
+			reply(`OK "Bye"`)
+			f.mu.Unlock()
+			return
+		default:
+			reply(`NO "Unknown command"`)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// readCommand reads a command name and its string arguments
+func readCommand(r *bufio.Reader) (string, []string, error) {
+	var command string
+	var args []string
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		switch {
+		case b == ' ' || b == '\r':
+		case b == '\n':
+			return strings.ToUpper(command), args, nil
+		case b == '"':
+			s, err := r.ReadString('"')
+			if err != nil {
+				return "", nil, err
+			}
+			args = append(args, strings.TrimSuffix(s, `"`))
+		case b == '{':
+			header, err := r.ReadString('\n')
+			if err != nil {
+				return "", nil, err
+			}
+			n, err := strconv.Atoi(strings.TrimRight(header, "+}\r\n"))
+			if err != nil {
+				return "", nil, err
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return "", nil, err
+			}
+			args = append(args, string(data))
+		default:
+			word := []byte{b}
+			for {
+				next, err := r.Peek(1)
+				if err != nil {
+					return "", nil, err
+				}
+				if next[0] == ' ' || next[0] == '\r' || next[0] == '\n' {
+					break
+				}
+				r.ReadByte()
+				word = append(word, next[0])
+			}
+			command = string(word)
+		}
+	}
+}